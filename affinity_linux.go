@@ -0,0 +1,13 @@
+//go:build linux
+
+package apexlob
+
+import "golang.org/x/sys/unix"
+
+// pinThread restricts the calling OS thread to cpu. The goroutine must hold
+// runtime.LockOSThread.
+func pinThread(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
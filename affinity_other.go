@@ -0,0 +1,9 @@
+//go:build !linux
+
+package apexlob
+
+import "errors"
+
+func pinThread(cpu int) error {
+	return errors.New("CPU affinity is only supported on Linux")
+}
@@ -0,0 +1,168 @@
+package apexlob
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default templates of an email sink, executed with the AlertEvent.
+const (
+	defaultEmailSubject = `[{{.Severity | upper}}] {{.Rule}} on {{.Symbol}}`
+	defaultEmailBody    = `{{.Rule}} fired on {{.Symbol}} at {{.Timestamp.UTC.Format "2006-01-02 15:04:05"}} UTC.
+
+Severity: {{.Severity}}
+Condition: {{.Expr}}
+{{range $name, $v := .Values}}{{$name}} = {{$v}}
+{{end}}`
+)
+
+var emailTimeout = 30 * time.Second
+
+// EmailSink mails alerts and summaries over SMTP. TLS is "starttls" (the
+// default, usually on port 587), "tls" for a connection that is
+// encrypted from the start (port 465) or "none".
+type EmailSink struct {
+	name               string
+	addr, host         string
+	tls                string
+	username, password string
+	from               string
+	to                 []string
+	subject, body      *template.Template
+}
+
+func newEmailSink(cfg AlertSinkConfig) (*EmailSink, error) {
+	if cfg.SMTPAddr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("sink %s: email requires smtp_addr, from and to", cfg.Name)
+	}
+	host, _, err := net.SplitHostPort(cfg.SMTPAddr)
+	if err != nil {
+		return nil, fmt.Errorf("sink %s: invalid smtp_addr: %w", cfg.Name, err)
+	}
+	s := &EmailSink{
+		name:     cfg.Name,
+		addr:     cfg.SMTPAddr,
+		host:     host,
+		tls:      cfg.TLS,
+		username: cfg.Username,
+		password: cfg.Password,
+		from:     cfg.From,
+		to:       cfg.To,
+	}
+	switch s.tls {
+	case "":
+		s.tls = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("sink %s: tls must be starttls, tls or none", cfg.Name)
+	}
+	subject, body := cfg.Subject, cfg.Template
+	if subject == "" {
+		subject = defaultEmailSubject
+	}
+	if body == "" {
+		body = defaultEmailBody
+	}
+	if s.subject, err = template.New(cfg.Name).Funcs(alertTemplateFuncs).Parse(subject); err != nil {
+		return nil, fmt.Errorf("sink %s: subject: %w", cfg.Name, err)
+	}
+	if s.body, err = template.New(cfg.Name).Funcs(alertTemplateFuncs).Parse(body); err != nil {
+		return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+	}
+	return s, nil
+}
+
+func (s *EmailSink) Name() string { return s.name }
+
+func (s *EmailSink) Send(event AlertEvent) error {
+	var subject, body bytes.Buffer
+	if err := s.subject.Execute(&subject, event); err != nil {
+		return err
+	}
+	if err := s.body.Execute(&body, event); err != nil {
+		return err
+	}
+	return s.mail(subject.String(), body.String())
+}
+
+// SendSummary mails the summary as text, under its first line; the
+// templates are for alerts only.
+func (s *EmailSink) SendSummary(summary Summary) error {
+	text := summary.Text()
+	subject, _, _ := strings.Cut(text, "\n")
+	return s.mail("apexlob: "+subject, text)
+}
+
+func (s *EmailSink) mail(subject, body string) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(emailTimeout))
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if s.tls == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not offer STARTTLS", s.addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.from); err != nil {
+		return err
+	}
+	for _, to := range s.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(subject, body)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (s *EmailSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: emailTimeout}
+	if s.tls == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: s.host})
+	}
+	return dialer.Dial("tcp", s.addr)
+}
+
+// message is the email, headers and a plain text body.
+func (s *EmailSink) message(subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\r\n", "\n"))
+	return b.Bytes()
+}
@@ -0,0 +1,163 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Default endpoints of the incident services; a sink's url overrides them,
+// e.g. with https://api.eu.opsgenie.com for Opsgenie's EU region.
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAPIURL     = "https://api.opsgenie.com"
+)
+
+// alertDedupKey identifies a rule's incident on a symbol, so that repeats
+// land on the incident already open.
+func alertDedupKey(event AlertEvent) string {
+	return "apexlob:" + event.Rule + ":" + event.Symbol
+}
+
+// PagerDutySink opens incidents through the PagerDuty Events API v2. It
+// triggers one per alert, deduplicated by rule and symbol, and resolves
+// operational alerts when their check passes again. Summaries are not
+// incidents and are skipped.
+type PagerDutySink struct {
+	name       string
+	url        string
+	routingKey string
+}
+
+func (s *PagerDutySink) Name() string { return s.name }
+
+func (s *PagerDutySink) Send(event AlertEvent) error {
+	details := make(map[string]any, len(event.Values)+1)
+	for name, v := range event.Values {
+		details[name] = v
+	}
+	details["expr"] = event.Expr
+	return s.post("trigger", alertDedupKey(event), map[string]any{
+		"summary":        alertText(event),
+		"source":         event.Symbol,
+		"severity":       pagerDutySeverity(event.Severity),
+		"timestamp":      event.Timestamp,
+		"component":      "apexlob",
+		"group":          event.Rule,
+		"custom_details": details,
+	})
+}
+
+func (s *PagerDutySink) SendSummary(Summary) error { return nil }
+
+func (s *PagerDutySink) SendOps(alert OpsAlert) error {
+	if alert.Resolved {
+		return s.post("resolve", alert.DedupKey(), nil)
+	}
+	return s.post("trigger", alert.DedupKey(), map[string]any{
+		"summary":   alert.Summary,
+		"source":    alert.Source,
+		"severity":  pagerDutySeverity(alert.Severity),
+		"timestamp": alert.Timestamp,
+		"component": "apexlob",
+		"class":     alert.Check,
+	})
+}
+
+func (s *PagerDutySink) post(action, dedupKey string, payload map[string]any) error {
+	event := map[string]any{"routing_key": s.routingKey, "event_action": action, "dedup_key": dedupKey}
+	if payload != nil {
+		event["payload"] = payload
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(s.url, nil, body)
+}
+
+// pagerDutySeverity maps a severity onto the four PagerDuty accepts.
+func pagerDutySeverity(severity string) string {
+	switch severity {
+	case "critical", "error", "info":
+		return severity
+	case "warn", "warning":
+		return "warning"
+	}
+	return "error"
+}
+
+// OpsgenieSink creates Opsgenie alerts through its Alert API, aliased by
+// rule and symbol so that repeats are counted on the open alert, and
+// closes operational alerts when their check passes again. Summaries are
+// skipped.
+type OpsgenieSink struct {
+	name   string
+	url    string
+	apiKey string
+}
+
+func (s *OpsgenieSink) Name() string { return s.name }
+
+func (s *OpsgenieSink) Send(event AlertEvent) error {
+	details := make(map[string]string, len(event.Values)+1)
+	for name, v := range event.Values {
+		details[name] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	details["expr"] = event.Expr
+	return s.create(alertDedupKey(event), alertText(event), opsgeniePriority(event.Severity), event.Symbol, details)
+}
+
+func (s *OpsgenieSink) SendSummary(Summary) error { return nil }
+
+func (s *OpsgenieSink) SendOps(alert OpsAlert) error {
+	if alert.Resolved {
+		body, err := json.Marshal(map[string]string{"source": alert.Source, "note": alert.Summary})
+		if err != nil {
+			return err
+		}
+		return postJSON(s.url+"/v2/alerts/"+url.PathEscape(alert.DedupKey())+"/close?identifierType=alias", s.headers(), body)
+	}
+	return s.create(alert.DedupKey(), alert.Summary, opsgeniePriority(alert.Severity), alert.Source, map[string]string{"check": alert.Check})
+}
+
+func (s *OpsgenieSink) create(alias, text, priority, source string, details map[string]string) error {
+	// The message is limited to 130 characters; the description has it all
+	message := text
+	if len(message) > 130 {
+		message = strings.ToValidUTF8(message[:127], "") + "..."
+	}
+	body, err := json.Marshal(map[string]any{
+		"message":     message,
+		"alias":       alias,
+		"description": text,
+		"priority":    priority,
+		"source":      source,
+		"tags":        []string{"apexlob"},
+		"details":     details,
+	})
+	if err != nil {
+		return err
+	}
+	return postJSON(s.url+"/v2/alerts", s.headers(), body)
+}
+
+func (s *OpsgenieSink) headers() map[string]string {
+	return map[string]string{"Authorization": "GenieKey " + s.apiKey}
+}
+
+// opsgeniePriority maps a severity onto Opsgenie's P1 to P5.
+func opsgeniePriority(severity string) string {
+	switch severity {
+	case "critical":
+		return "P1"
+	case "error":
+		return "P2"
+	case "warn", "warning":
+		return "P3"
+	case "info":
+		return "P5"
+	}
+	return "P3"
+}
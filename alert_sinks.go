@@ -0,0 +1,512 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type AlertSinkConfig struct {
+	Name          string            `json:"name"`
+	Type          string            `json:"type"` // webhook, slack, telegram, email, pagerduty, opsgenie
+	URL           string            `json:"url,omitempty"`
+	Template      string            `json:"template,omitempty"` // webhook or email body; defaults to the event as JSON or text
+	Headers       map[string]string `json:"headers,omitempty"`
+	BotToken      string            `json:"bot_token,omitempty"`
+	ChatID        string            `json:"chat_id,omitempty"`
+	RatePerMinute float64           `json:"rate_per_minute,omitempty"`
+	MaxRetries    int               `json:"max_retries,omitempty"`
+	// Email: the SMTP server as host:port, how to secure the connection
+	// (starttls, tls or none), the login if it needs one, the addresses and
+	// the subject template
+	SMTPAddr string   `json:"smtp_addr,omitempty"`
+	TLS      string   `json:"tls,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+	// PagerDuty's integration key and Opsgenie's API key
+	RoutingKey string `json:"routing_key,omitempty"`
+	APIKey     string `json:"api_key,omitempty"`
+}
+
+type AlertSink interface {
+	Name() string
+	Send(event AlertEvent) error
+	// SendSummary posts a scheduled period summary
+	SendSummary(s Summary) error
+}
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func NewAlertSink(cfg AlertSinkConfig) (AlertSink, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+	switch cfg.Type {
+	case "webhook":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("sink %s: webhook requires url", cfg.Name)
+		}
+		var tmpl *template.Template
+		if cfg.Template != "" {
+			var err error
+			tmpl, err = template.New(cfg.Name).Funcs(alertTemplateFuncs).Parse(cfg.Template)
+			if err != nil {
+				return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+			}
+		}
+		return &WebhookSink{name: cfg.Name, url: cfg.URL, headers: cfg.Headers, tmpl: tmpl}, nil
+	case "slack":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("sink %s: slack requires url", cfg.Name)
+		}
+		return &SlackSink{name: cfg.Name, url: cfg.URL}, nil
+	case "telegram":
+		if cfg.BotToken == "" || cfg.ChatID == "" {
+			return nil, fmt.Errorf("sink %s: telegram requires bot_token and chat_id", cfg.Name)
+		}
+		return &TelegramSink{name: cfg.Name, token: cfg.BotToken, chatID: cfg.ChatID, apiBase: "https://api.telegram.org"}, nil
+	case "email":
+		return newEmailSink(cfg)
+	case "pagerduty":
+		if cfg.RoutingKey == "" {
+			return nil, fmt.Errorf("sink %s: pagerduty requires routing_key", cfg.Name)
+		}
+		url := cfg.URL
+		if url == "" {
+			url = pagerDutyEventsURL
+		}
+		return &PagerDutySink{name: cfg.Name, url: url, routingKey: cfg.RoutingKey}, nil
+	case "opsgenie":
+		if cfg.APIKey == "" {
+			return nil, fmt.Errorf("sink %s: opsgenie requires api_key", cfg.Name)
+		}
+		url := strings.TrimSuffix(cfg.URL, "/")
+		if url == "" {
+			url = opsgenieAPIURL
+		}
+		return &OpsgenieSink{name: cfg.Name, url: url, apiKey: cfg.APIKey}, nil
+	}
+	return nil, fmt.Errorf("sink %s: unknown type %q", cfg.Name, cfg.Type)
+}
+
+var alertTemplateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal so templates stay valid JSON
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+}
+
+func alertText(event AlertEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s on %s: %s", strings.ToUpper(event.Severity), event.Rule, event.Symbol, event.Expr)
+	for _, name := range sortedKeys(event.Values) {
+		fmt.Fprintf(&b, " %s=%.4f", name, event.Values[name])
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// httpStatusError is a non-2xx answer from a sink's endpoint.
+type httpStatusError struct {
+	code int
+	body string
+}
+
+func (e *httpStatusError) Error() string { return fmt.Sprintf("HTTP %d: %s", e.code, e.body) }
+
+// permanent reports whether retrying cannot help: a 4xx means the request
+// itself was refused, except 429 which asks the caller to slow down.
+func (e *httpStatusError) permanent() bool {
+	return e.code/100 == 4 && e.code != http.StatusTooManyRequests
+}
+
+func postJSON(endpoint string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return redactURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return redactURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &httpStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
+
+// redactURL drops the request URL from a client error. Telegram's bot token
+// and Slack's webhook secret are part of the URL, and the error is logged.
+func redactURL(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return fmt.Errorf("%s: %w", uerr.Op, uerr.Err)
+	}
+	return err
+}
+
+type WebhookSink struct {
+	name    string
+	url     string
+	headers map[string]string
+	tmpl    *template.Template
+}
+
+func (s *WebhookSink) Name() string { return s.name }
+
+func (s *WebhookSink) Send(event AlertEvent) error {
+	var body []byte
+	if s.tmpl != nil {
+		var buf bytes.Buffer
+		if err := s.tmpl.Execute(&buf, event); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	} else {
+		var err error
+		if body, err = json.Marshal(event); err != nil {
+			return err
+		}
+	}
+	return postJSON(s.url, s.headers, body)
+}
+
+// SendSummary posts the summary as JSON; the template is for alerts only.
+func (s *WebhookSink) SendSummary(summary Summary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return postJSON(s.url, s.headers, body)
+}
+
+type SlackSink struct {
+	name string
+	url  string
+}
+
+func (s *SlackSink) Name() string { return s.name }
+
+func (s *SlackSink) Send(event AlertEvent) error {
+	return s.post(alertText(event))
+}
+
+func (s *SlackSink) SendSummary(summary Summary) error {
+	return s.post(summary.Text())
+}
+
+func (s *SlackSink) post(text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return postJSON(s.url, nil, body)
+}
+
+type TelegramSink struct {
+	name    string
+	token   string
+	chatID  string
+	apiBase string
+}
+
+func (s *TelegramSink) Name() string { return s.name }
+
+func (s *TelegramSink) Send(event AlertEvent) error {
+	return s.post(alertText(event))
+}
+
+func (s *TelegramSink) SendSummary(summary Summary) error {
+	return s.post(summary.Text())
+}
+
+func (s *TelegramSink) post(text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": s.chatID, "text": text})
+	if err != nil {
+		return err
+	}
+	return postJSON(fmt.Sprintf("%s/bot%s/sendMessage", s.apiBase, s.token), nil, body)
+}
+
+// tokenBucket allows bursts up to capacity and refills at rate tokens/sec.
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute float64) *tokenBucket {
+	capacity := perMinute
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &tokenBucket{capacity: capacity, tokens: capacity, rate: perMinute / 60}
+}
+
+func (tb *tokenBucket) allow(now time.Time) bool {
+	if !tb.last.IsZero() {
+		tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+		if tb.tokens > tb.capacity {
+			tb.tokens = tb.capacity
+		}
+	}
+	tb.last = now
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+type dispatchTarget struct {
+	sink    AlertSink
+	limiter *tokenBucket // nil means unlimited
+	retries int
+	dropped int
+	failed  int
+}
+
+type alertJob struct {
+	event   AlertEvent
+	summary *Summary  // set instead of event for a scheduled summary
+	ops     *OpsAlert // or for an operational alert
+	targets []*dispatchTarget
+}
+
+// AlertDispatcher delivers alert events to sinks on a background goroutine so
+// slow HTTP endpoints never stall the processing loop.
+type AlertDispatcher struct {
+	mu      sync.Mutex
+	targets map[string]*dispatchTarget
+	order   []string
+	queue   chan alertJob
+	done    chan struct{}
+	closed  bool
+	backoff time.Duration
+}
+
+// maxAlertBackoff caps the wait between retries however many are configured.
+const maxAlertBackoff = 30 * time.Second
+
+func NewAlertDispatcher() *AlertDispatcher {
+	d := &AlertDispatcher{
+		targets: make(map[string]*dispatchTarget),
+		queue:   make(chan alertJob, 256),
+		done:    make(chan struct{}),
+		backoff: 500 * time.Millisecond,
+	}
+	go d.run()
+	return d
+}
+
+func (d *AlertDispatcher) AddSink(sink AlertSink, ratePerMinute float64, maxRetries int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t := &dispatchTarget{sink: sink, retries: maxRetries}
+	if ratePerMinute > 0 {
+		t.limiter = newTokenBucket(ratePerMinute)
+	}
+	if _, exists := d.targets[sink.Name()]; !exists {
+		d.order = append(d.order, sink.Name())
+	}
+	d.targets[sink.Name()] = t
+}
+
+// Dispatch queues the event for the sinks named on it, or every sink when
+// the rule didn't name any. It never blocks; events are dropped when the
+// queue is full.
+func (d *AlertDispatcher) Dispatch(event AlertEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	names := event.Sinks
+	if len(names) == 0 {
+		names = d.order
+	}
+	now := time.Now()
+	var targets []*dispatchTarget
+	for _, name := range names {
+		t, ok := d.targets[name]
+		if !ok {
+			logger("alerts").Warn("rule references unknown alert sink", "rule", event.Rule, "sink", name)
+			continue
+		}
+		if t.limiter != nil && !t.limiter.allow(now) {
+			t.dropped++
+			continue
+		}
+		targets = append(targets, t)
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+	select {
+	case d.queue <- alertJob{event: event, targets: targets}:
+	default:
+		logger("alerts").Warn("alert queue full, dropping", "rule", event.Rule)
+	}
+}
+
+// DispatchSummary queues the summary for the named sinks. Summaries are
+// few, so they bypass the sinks' rate limits.
+func (d *AlertDispatcher) DispatchSummary(s Summary, names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	var targets []*dispatchTarget
+	for _, name := range names {
+		if t, ok := d.targets[name]; ok {
+			targets = append(targets, t)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+	select {
+	case d.queue <- alertJob{summary: &s, targets: targets}:
+	default:
+		logger("alerts").Warn("alert queue full, dropping summary", "start", s.Start)
+	}
+}
+
+// DispatchOps queues an operational alert for the named sinks, bypassing
+// their rate limits like summaries.
+func (d *AlertDispatcher) DispatchOps(alert OpsAlert, names []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	var targets []*dispatchTarget
+	for _, name := range names {
+		if t, ok := d.targets[name]; ok {
+			targets = append(targets, t)
+		}
+	}
+
+	if len(targets) == 0 {
+		return
+	}
+	select {
+	case d.queue <- alertJob{ops: &alert, targets: targets}:
+	default:
+		logger("alerts").Warn("alert queue full, dropping operational alert", "check", alert.Check)
+	}
+}
+
+// HasSink reports whether a sink of that name was added.
+func (d *AlertDispatcher) HasSink(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.targets[name]
+	return ok
+}
+
+func (d *AlertDispatcher) run() {
+	defer close(d.done)
+	for job := range d.queue {
+		for _, t := range job.targets {
+			d.deliver(t, job)
+		}
+	}
+}
+
+func (d *AlertDispatcher) deliver(t *dispatchTarget, job alertJob) {
+	var err error
+	attempt := 0
+	for ; attempt <= t.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBackoff(d.backoff, attempt))
+		}
+		switch {
+		case job.summary != nil:
+			err = t.sink.SendSummary(*job.summary)
+		case job.ops != nil:
+			err = sendOps(t.sink, *job.ops)
+		default:
+			err = t.sink.Send(job.event)
+		}
+		if err == nil {
+			return
+		}
+		var serr *httpStatusError
+		if errors.As(err, &serr) && serr.permanent() {
+			attempt++
+			break
+		}
+	}
+	d.mu.Lock()
+	t.failed++
+	d.mu.Unlock()
+	logger("alerts").Error("alert sink failed", "sink", t.sink.Name(), "attempts", attempt, "err", err)
+}
+
+// retryBackoff doubles base for each retry after the first, up to
+// maxAlertBackoff.
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	wait := base
+	for i := 1; i < attempt && wait < maxAlertBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, maxAlertBackoff)
+}
+
+// Close stops accepting events and waits for queued deliveries to finish.
+// Events dispatched after Close are dropped.
+func (d *AlertDispatcher) Close() {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	<-d.done
+}
+
+func LoadAlertSinks(path string) ([]AlertSinkConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []AlertSinkConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfgs, nil
+}
@@ -0,0 +1,389 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingServer struct {
+	mu     sync.Mutex
+	bodies []string
+	paths  []string
+	fail   int // number of initial requests answered with status
+	status int // 500 when unset
+}
+
+func (rs *recordingServer) handler(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.bodies = append(rs.bodies, string(body))
+	rs.paths = append(rs.paths, r.URL.Path)
+	if rs.fail > 0 {
+		rs.fail--
+		if rs.status == 0 {
+			rs.status = http.StatusInternalServerError
+		}
+		w.WriteHeader(rs.status)
+	}
+}
+
+func testAlertEvent() AlertEvent {
+	return AlertEvent{
+		Rule:      "flow",
+		Symbol:    "btcusdt",
+		Expr:      "ofi_1m > 0.8",
+		Severity:  "warn",
+		Timestamp: time.Unix(1700000000, 0).UTC(),
+		Values:    map[string]float64{"ofi_1m": 0.9},
+	}
+}
+
+func TestWebhookSinkTemplate(t *testing.T) {
+	rs := &recordingServer{}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+
+	sink, err := NewAlertSink(AlertSinkConfig{
+		Name:     "hook",
+		Type:     "webhook",
+		URL:      srv.URL,
+		Template: `{"alert":{{json .Rule}},"ofi":{{index .Values "ofi_1m"}}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testAlertEvent()); err != nil {
+		t.Fatalf("Send() error: %v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(rs.bodies[0]), &got); err != nil {
+		t.Fatalf("templated body is not JSON: %v (%s)", err, rs.bodies[0])
+	}
+	if got["alert"] != "flow" || got["ofi"] != 0.9 {
+		t.Errorf("templated body = %v", got)
+	}
+}
+
+func TestSlackAndTelegramPayloads(t *testing.T) {
+	rs := &recordingServer{}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+
+	slack := &SlackSink{name: "slack", url: srv.URL}
+	telegram := &TelegramSink{name: "tg", token: "T0KEN", chatID: "42", apiBase: srv.URL}
+	if err := slack.Send(testAlertEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if err := telegram.Send(testAlertEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(rs.bodies[0], `"text":"[WARN] flow on btcusdt`) {
+		t.Errorf("slack body = %s", rs.bodies[0])
+	}
+	if rs.paths[1] != "/botT0KEN/sendMessage" || !strings.Contains(rs.bodies[1], `"chat_id":"42"`) {
+		t.Errorf("telegram request = %s %s", rs.paths[1], rs.bodies[1])
+	}
+}
+
+func TestAlertSinkConfigValidation(t *testing.T) {
+	bad := []AlertSinkConfig{
+		{Type: "webhook"},
+		{Type: "slack"},
+		{Type: "telegram", BotToken: "x"},
+		{Type: "pigeon"},
+		{Type: "webhook", URL: "http://x", Template: "{{"},
+		{Type: "email", SMTPAddr: "localhost:25", From: "a@x"},
+		{Type: "email", SMTPAddr: "localhost", From: "a@x", To: []string{"b@x"}},
+		{Type: "email", SMTPAddr: "localhost:25", From: "a@x", To: []string{"b@x"}, TLS: "maybe"},
+		{Type: "email", SMTPAddr: "localhost:25", From: "a@x", To: []string{"b@x"}, Subject: "{{"},
+		{Type: "pagerduty"},
+		{Type: "opsgenie", URL: "http://x"},
+	}
+	for _, cfg := range bad {
+		if _, err := NewAlertSink(cfg); err == nil {
+			t.Errorf("NewAlertSink(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestIncidentSinks(t *testing.T) {
+	rs := &recordingServer{}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+
+	pd, err := NewAlertSink(AlertSinkConfig{Type: "pagerduty", URL: srv.URL + "/v2/enqueue", RoutingKey: "R0UTE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	og, err := NewAlertSink(AlertSinkConfig{Type: "opsgenie", URL: srv.URL + "/", APIKey: "K3Y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := OpsAlert{Check: opsFeedDown, Severity: "critical", Summary: "no feed messages for 1m0s", Source: "host1", Timestamp: time.Unix(1700000000, 0)}
+	up := down
+	up.Resolved = true
+	for _, sink := range []AlertSink{pd, og} {
+		if err := sink.Send(testAlertEvent()); err != nil {
+			t.Fatal(err)
+		}
+		for _, alert := range []OpsAlert{down, up} {
+			if err := sendOps(sink, alert); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var event struct {
+		RoutingKey  string `json:"routing_key"`
+		EventAction string `json:"event_action"`
+		DedupKey    string `json:"dedup_key"`
+		Payload     struct {
+			Severity string             `json:"severity"`
+			Details  map[string]float64 `json:"custom_details"`
+		} `json:"payload"`
+	}
+	json.Unmarshal([]byte(rs.bodies[0]), &event)
+	if event.RoutingKey != "R0UTE" || event.EventAction != "trigger" || event.DedupKey != "apexlob:flow:btcusdt" ||
+		event.Payload.Severity != "warning" || event.Payload.Details["ofi_1m"] != 0.9 {
+		t.Errorf("pagerduty alert = %s", rs.bodies[0])
+	}
+	if !strings.Contains(rs.bodies[1], `"dedup_key":"apexlob:host1:feed_down"`) || !strings.Contains(rs.bodies[1], `"severity":"critical"`) {
+		t.Errorf("pagerduty trigger = %s", rs.bodies[1])
+	}
+	if !strings.Contains(rs.bodies[2], `"event_action":"resolve"`) || strings.Contains(rs.bodies[2], "payload") {
+		t.Errorf("pagerduty resolve = %s", rs.bodies[2])
+	}
+
+	var alert struct {
+		Alias    string            `json:"alias"`
+		Priority string            `json:"priority"`
+		Details  map[string]string `json:"details"`
+	}
+	json.Unmarshal([]byte(rs.bodies[3]), &alert)
+	if rs.paths[3] != "/v2/alerts" || alert.Alias != "apexlob:flow:btcusdt" || alert.Priority != "P3" || alert.Details["ofi_1m"] != "0.9" {
+		t.Errorf("opsgenie alert = %s %s", rs.paths[3], rs.bodies[3])
+	}
+	if rs.paths[4] != "/v2/alerts" || !strings.Contains(rs.bodies[4], `"priority":"P1"`) {
+		t.Errorf("opsgenie create = %s %s", rs.paths[4], rs.bodies[4])
+	}
+	if rs.paths[5] != "/v2/alerts/apexlob:host1:feed_down/close" {
+		t.Errorf("opsgenie close = %s", rs.paths[5])
+	}
+
+	// A chat sink is told of the failure only
+	slack := &SlackSink{name: "slack", url: srv.URL}
+	sendOps(slack, down)
+	sendOps(slack, up)
+	if len(rs.bodies) != 7 || !strings.Contains(rs.bodies[6], "[CRITICAL] feed_down on host1: no feed messages") {
+		t.Errorf("slack got %q", rs.bodies[6:])
+	}
+}
+
+// smtpServer accepts one session per connection, without extensions, and
+// keeps the messages it is given.
+func smtpServer(t *testing.T) (addr string, messages chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages = make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				io.WriteString(conn, "220 test\r\n")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "DATA"):
+						io.WriteString(conn, "354 go on\r\n")
+						var msg strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							msg.WriteString(line)
+						}
+						messages <- msg.String()
+						io.WriteString(conn, "250 ok\r\n")
+					case strings.HasPrefix(cmd, "QUIT"):
+						io.WriteString(conn, "221 bye\r\n")
+						return
+					default:
+						io.WriteString(conn, "250 ok\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestEmailSink(t *testing.T) {
+	addr, messages := smtpServer(t)
+	sink, err := NewAlertSink(AlertSinkConfig{Name: "mail", Type: "email", SMTPAddr: addr, TLS: "none", From: "apexlob@example.com", To: []string{"ops@example.com", "desk@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Send(testAlertEvent()); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	for _, want := range []string{"To: ops@example.com, desk@example.com\r\n", "Subject: [WARN] flow on btcusdt\r\n", "fired on btcusdt at 2023-11-14 22:13:20 UTC", "ofi_1m = 0.9"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+
+	start := time.Unix(1700000000, 0)
+	if err := sink.SendSummary(Summary{Start: start, End: start.Add(24 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-messages; !strings.Contains(msg, "Subject: apexlob: Summary 2023-11-14 22:13 to 2023-11-15 22:13 UTC\r\n") || !strings.Contains(msg, "no alerts") {
+		t.Errorf("summary message:\n%s", msg)
+	}
+
+	// Starting TLS is required unless turned off
+	sink, _ = NewAlertSink(AlertSinkConfig{Name: "mail", Type: "email", SMTPAddr: addr, From: "a@x", To: []string{"b@x"}})
+	if err := sink.Send(testAlertEvent()); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("send without STARTTLS: %v", err)
+	}
+}
+
+func TestAlertDispatcherRetryAndRouting(t *testing.T) {
+	rs := &recordingServer{fail: 1}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+
+	other := &recordingServer{}
+	otherSrv := httptest.NewServer(http.HandlerFunc(other.handler))
+	defer otherSrv.Close()
+
+	d := NewAlertDispatcher()
+	d.backoff = time.Millisecond
+	d.AddSink(&SlackSink{name: "primary", url: srv.URL}, 0, 2)
+	d.AddSink(&SlackSink{name: "other", url: otherSrv.URL}, 0, 0)
+
+	event := testAlertEvent()
+	event.Sinks = []string{"primary"}
+	d.Dispatch(event)
+	d.Close()
+
+	if len(rs.bodies) != 2 {
+		t.Errorf("primary received %d requests, want 2 (one retry)", len(rs.bodies))
+	}
+	if len(other.bodies) != 0 {
+		t.Errorf("unrouted sink received %d requests, want 0", len(other.bodies))
+	}
+}
+
+func TestAlertDispatcherClientErrorsArePermanent(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		want   int
+	}{
+		{http.StatusBadRequest, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusServiceUnavailable, 3},
+	} {
+		rs := &recordingServer{fail: 10, status: tc.status}
+		srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+
+		d := NewAlertDispatcher()
+		d.backoff = time.Millisecond
+		d.AddSink(&SlackSink{name: "primary", url: srv.URL}, 0, 2)
+		d.Dispatch(testAlertEvent())
+		d.Close()
+		srv.Close()
+
+		if len(rs.bodies) != tc.want {
+			t.Errorf("HTTP %d: %d requests, want %d", tc.status, len(rs.bodies), tc.want)
+		}
+	}
+}
+
+func TestAlertDispatcherDropsAfterClose(t *testing.T) {
+	rs := &recordingServer{}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+
+	d := NewAlertDispatcher()
+	d.AddSink(&SlackSink{name: "primary", url: srv.URL}, 0, 0)
+	d.Close()
+	d.Dispatch(testAlertEvent())
+	d.DispatchSummary(Summary{}, []string{"primary"})
+	d.DispatchOps(OpsAlert{Check: "feed"}, []string{"primary"})
+	d.Close()
+
+	if len(rs.bodies) != 0 {
+		t.Errorf("received %d requests after Close, want 0", len(rs.bodies))
+	}
+}
+
+func TestRetryBackoffIsCapped(t *testing.T) {
+	base := 500 * time.Millisecond
+	if got := retryBackoff(base, 1); got != base {
+		t.Errorf("first retry waits %v, want %v", got, base)
+	}
+	if got := retryBackoff(base, 3); got != 2*time.Second {
+		t.Errorf("third retry waits %v, want 2s", got)
+	}
+	for _, attempt := range []int{10, 64, 1000} {
+		if got := retryBackoff(base, attempt); got != maxAlertBackoff {
+			t.Errorf("retry %d waits %v, want %v", attempt, got, maxAlertBackoff)
+		}
+	}
+}
+
+func TestTelegramErrorOmitsToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	base := srv.URL
+	srv.Close() // refuse the connection so the client error carries the URL
+
+	const token = "123456:SECRET-bot-token"
+	sink := &TelegramSink{name: "tg", token: token, chatID: "42", apiBase: base}
+	err := sink.Send(testAlertEvent())
+	if err == nil {
+		t.Fatal("send to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), token) || strings.Contains(err.Error(), "SECRET") {
+		t.Errorf("error leaks the bot token: %v", err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	tb := newTokenBucket(2) // 2 per minute, burst 2
+	now := time.Unix(1700000000, 0)
+
+	if !tb.allow(now) || !tb.allow(now) {
+		t.Fatal("burst of 2 should be allowed")
+	}
+	if tb.allow(now) {
+		t.Error("third request in the same instant should be limited")
+	}
+	if !tb.allow(now.Add(30 * time.Second)) {
+		t.Error("one token should have refilled after 30s")
+	}
+}
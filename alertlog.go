@@ -0,0 +1,163 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AlertLogRecord is a line of the alert audit log.
+type AlertLogRecord struct {
+	Time     time.Time          `json:"time"`
+	Action   string             `json:"action"` // fired, escalated or acknowledged
+	Rule     string             `json:"rule"`
+	Symbol   string             `json:"symbol"`
+	Severity string             `json:"severity"`
+	Triggers int                `json:"triggers"`
+	Values   map[string]float64 `json:"values,omitempty"`
+	By       string             `json:"by,omitempty"` // who acknowledged
+}
+
+// AlertLog appends every alert fired or escalated, and every
+// acknowledgment, to a file as JSON lines, so there is a record of them
+// after the monitor has gone.
+type AlertLog struct {
+	mu     sync.Mutex
+	f      *os.File
+	enc    *json.Encoder
+	failed bool // a write failed, and was logged
+}
+
+// OpenAlertLog opens path for appending, creating it if needed.
+func OpenAlertLog(path string) (*AlertLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &AlertLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record logs an alert; it is an AlertHandler.
+func (l *AlertLog) Record(e AlertEvent) {
+	action := "fired"
+	if e.Escalated {
+		action = "escalated"
+	}
+	l.write(AlertLogRecord{
+		Time:     e.Timestamp,
+		Action:   action,
+		Rule:     e.Rule,
+		Symbol:   e.Symbol,
+		Severity: e.Severity,
+		Triggers: e.Triggers,
+		Values:   e.Values,
+	})
+}
+
+// Acknowledged logs that by acknowledged inc at at.
+func (l *AlertLog) Acknowledged(inc AlertIncident, by string, at time.Time) {
+	l.write(AlertLogRecord{
+		Time:     at,
+		Action:   "acknowledged",
+		Rule:     inc.Rule,
+		Symbol:   inc.Symbol,
+		Severity: inc.Severity,
+		Triggers: inc.Triggers,
+		By:       by,
+	})
+}
+
+func (l *AlertLog) write(rec AlertLogRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(rec); err != nil && !l.failed {
+		logger("alerts").Error("failed to write alert log", "file", l.f.Name(), "err", err)
+		l.failed = true
+	}
+}
+
+func (l *AlertLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ReadAlertLog reads back the records of an alert log.
+func ReadAlertLog(path string) ([]AlertLogRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []AlertLogRecord
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var rec AlertLogRecord
+		if err := dec.Decode(&rec); err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Incidents returns the latest incident of every rule on every symbol,
+// most recent first.
+func (m *Monitor) Incidents() []AlertIncident {
+	out := []AlertIncident{}
+	for _, rules := range m.Rules {
+		out = append(out, rules.Incidents()...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Last.After(out[j].Last) })
+	return out
+}
+
+// AcknowledgeAlert acknowledges the latest incident of rule on symbol on
+// behalf of by, and logs it.
+func (m *Monitor) AcknowledgeAlert(rule, symbol, by string) (AlertIncident, bool) {
+	for _, rules := range m.Rules {
+		if inc, ok := rules.Acknowledge(rule, symbol); ok {
+			if m.alertLog != nil {
+				m.alertLog.Acknowledged(inc, by, time.Now())
+			}
+			return inc, true
+		}
+	}
+	return AlertIncident{}, false
+}
+
+// AlertsHandler serves the alert incidents:
+//
+//	GET  /alerts      the latest incident of every rule and symbol
+//	POST /alerts/ack  {"rule", "symbol", "by"}: acknowledge an incident
+func (m *Monitor) AlertsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/alerts" && r.Method == http.MethodGet:
+			writeJSON(w, m.Incidents())
+		case r.URL.Path == "/alerts/ack" && r.Method == http.MethodPost:
+			var req struct {
+				Rule   string `json:"rule"`
+				Symbol string `json:"symbol"`
+				By     string `json:"by"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+				return
+			}
+			inc, ok := m.AcknowledgeAlert(req.Rule, req.Symbol, req.By)
+			if !ok {
+				writeError(w, http.StatusNotFound, "no incident of rule "+req.Rule+" on "+req.Symbol)
+				return
+			}
+			writeJSON(w, inc)
+		case r.URL.Path == "/alerts" || r.URL.Path == "/alerts/ack":
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
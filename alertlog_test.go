@@ -0,0 +1,58 @@
+package apexlob
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertLogAndAck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	log, err := OpenAlertLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Monitor{Rules: []*RuleEngine{NewRuleEngine(), NewRuleEngine()}, alertLog: log}
+	m.Rules[1].AddRule(RuleConfig{Name: "wide", Expr: "spread_bps > 5", Dedup: Duration(time.Minute), EscalateAfter: 2})
+	m.Rules[1].OnAlert(log.Record)
+	now := time.Unix(1700000000, 0)
+	m.Rules[1].Evaluate("ethusdt", map[string]float64{"spread_bps": 6}, now)
+	m.Rules[1].Evaluate("ethusdt", map[string]float64{"spread_bps": 1}, now.Add(time.Second))
+	m.Rules[1].Evaluate("ethusdt", map[string]float64{"spread_bps": 7}, now.Add(2*time.Second))
+
+	h := m.AlertsHandler()
+	var incidents []AlertIncident
+	if code := getJSON(t, h, "/alerts", &incidents); code != http.StatusOK || len(incidents) != 1 || !incidents[0].Escalated {
+		t.Fatalf("GET /alerts = %d, %+v", code, incidents)
+	}
+	for body, want := range map[string]int{
+		`{"rule": "wide", "symbol": "ethusdt", "by": "alice"}`: http.StatusOK,
+		`{"rule": "wide", "symbol": "btcusdt"}`:                http.StatusNotFound,
+		`{`:                                                    http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/alerts/ack", strings.NewReader(body)))
+		if rec.Code != want {
+			t.Errorf("POST /alerts/ack %s = %d, want %d", body, rec.Code, want)
+		}
+	}
+	if code := getJSON(t, h, "/alerts/ack", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /alerts/ack = %d", code)
+	}
+	log.Close()
+
+	records, err := ReadAlertLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, rec := range records {
+		actions = append(actions, rec.Action)
+	}
+	if got := strings.Join(actions, ","); got != "fired,escalated,acknowledged" || records[2].By != "alice" || records[1].Triggers != 2 {
+		t.Errorf("alert log = %+v", records)
+	}
+}
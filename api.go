@@ -0,0 +1,221 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+type StatsSnapshot struct {
+	UptimeSeconds     float64 `json:"uptime_seconds"`
+	TotalMessages     int     `json:"total_messages"`
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	AvgProcessingMs   float64 `json:"avg_processing_ms"`
+	// Processing runs from receipt off the socket to the end of the
+	// pipeline; EndToEnd starts at the exchange's event time instead.
+	Processing LatencyPercentiles `json:"processing_latency"`
+	EndToEnd   LatencyPercentiles `json:"end_to_end_latency"`
+	// Errors and ClockOffsetMs are only filled in by Monitor.Snapshot
+	Errors ErrorCounts `json:"errors"`
+	// ClockOffsetMs is how far the exchange's clock is estimated to be
+	// ahead of the local one; EndToEnd is corrected by it
+	ClockOffsetMs float64 `json:"clock_offset_ms"`
+}
+
+type APIServer struct {
+	symbols *SymbolRegistry
+	stats   func() StatsSnapshot
+	mux     *http.ServeMux
+}
+
+func NewAPIServer(symbols *SymbolRegistry, stats func() StatsSnapshot) *APIServer {
+	api := &APIServer{
+		symbols: symbols,
+		stats:   stats,
+		mux:     http.NewServeMux(),
+	}
+	api.mux.HandleFunc("/book/", api.handleBook)
+	api.mux.HandleFunc("/queue/", api.handleQueue)
+	api.mux.HandleFunc("/trades/", api.handleTrades)
+	api.mux.HandleFunc("/signals/", api.handleSignals)
+	api.mux.HandleFunc("/snapshot/", api.handleSnapshot)
+	api.mux.HandleFunc("/stats", api.handleStats)
+	api.mux.HandleFunc("/symbols", api.handleSymbols)
+	api.mux.HandleFunc("/memory", api.handleMemory)
+	return api
+}
+
+// Handle mounts an extra handler (e.g. /metrics) on the API listener.
+func (api *APIServer) Handle(pattern string, h http.Handler) {
+	api.mux.Handle(pattern, h)
+}
+
+func (api *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mux.ServeHTTP(w, r)
+}
+
+// lookup resolves the {symbol} path segment after prefix.
+func (api *APIServer) lookup(w http.ResponseWriter, r *http.Request, prefix string) (*SymbolState, bool) {
+	return api.lookupSymbol(w, r, strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+}
+
+func (api *APIServer) lookupSymbol(w http.ResponseWriter, r *http.Request, symbol string) (*SymbolState, bool) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return nil, false
+	}
+	state, ok := api.symbols.Get(symbol)
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown symbol "+strconv.Quote(symbol))
+		return nil, false
+	}
+	return state, true
+}
+
+func (api *APIServer) handleBook(w http.ResponseWriter, r *http.Request) {
+	state, ok := api.lookup(w, r, "/book/")
+	if !ok {
+		return
+	}
+	depth, ok := intParam(w, r, "depth", 20)
+	if !ok {
+		return
+	}
+	writeJSON(w, state.BookSnapshot(depth))
+}
+
+// handleQueue serves how long the orders resting in a book have waited:
+// the order count and ages of each of its top levels and a histogram of
+// every order's age.
+func (api *APIServer) handleQueue(w http.ResponseWriter, r *http.Request) {
+	state, ok := api.lookup(w, r, "/queue/")
+	if !ok {
+		return
+	}
+	depth, ok := intParam(w, r, "depth", 20)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	writeJSON(w, struct {
+		Symbol    string    `json:"symbol"`
+		Timestamp time.Time `json:"timestamp"`
+		orderbook.QueueAges
+	}{state.Symbol, now, state.Book.QueueAges(depth, now)})
+}
+
+func (api *APIServer) handleTrades(w http.ResponseWriter, r *http.Request) {
+	state, ok := api.lookup(w, r, "/trades/")
+	if !ok {
+		return
+	}
+	limit, ok := intParam(w, r, "limit", 100)
+	if !ok {
+		return
+	}
+	writeJSON(w, state.Tape.Recent(limit))
+}
+
+func (api *APIServer) handleSignals(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/signals/"), "/")
+	if symbol, name, ok := strings.Cut(path, "/"); ok {
+		api.handleSignalHistory(w, r, symbol, name)
+		return
+	}
+	state, ok := api.lookupSymbol(w, r, path)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"symbol":  state.Symbol,
+		"signals": state.Signals.Snapshot(),
+	})
+}
+
+// handleSignalHistory serves the recent values of one signal, for
+// sparklines. ?since= takes a time, or a duration back from the latest
+// trade, as in since=5m.
+func (api *APIServer) handleSignalHistory(w http.ResponseWriter, r *http.Request, symbol, name string) {
+	state, ok := api.lookupSymbol(w, r, symbol)
+	if !ok {
+		return
+	}
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil {
+			since = state.Signals.UpdatedAt().Add(-d)
+		} else if since, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since parameter")
+			return
+		}
+	}
+	points, ok := state.Signals.History(name, since)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no history of signal "+strconv.Quote(name))
+		return
+	}
+	writeJSON(w, struct {
+		Symbol string          `json:"symbol"`
+		Signal string          `json:"signal"`
+		Points []signals.Point `json:"points"`
+	}{state.Symbol, name, points})
+}
+
+// handleSnapshot serves the whole book for orderbook.Load, as JSON or with
+// ?format=binary in the compact form.
+func (api *APIServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	state, ok := api.lookup(w, r, "/snapshot/")
+	if !ok {
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, state.Book.State())
+	case "binary":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		state.Book.Serialize(w)
+	default:
+		writeError(w, http.StatusBadRequest, "invalid format parameter")
+	}
+}
+
+func (api *APIServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, api.stats())
+}
+
+func (api *APIServer) handleSymbols(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, api.symbols.List())
+}
+
+func (api *APIServer) handleMemory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, ReadMemoryReport(api.symbols))
+}
+
+func intParam(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return def, true
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		writeError(w, http.StatusBadRequest, "invalid "+name+" parameter")
+		return 0, false
+	}
+	return v, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,172 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func newTestAPI() (*APIServer, *SymbolState) {
+	state := NewSymbolState("btcusdt")
+	state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99.0, Quantity: 100, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 98.0, Quantity: 100, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 3, Price: 101.0, Quantity: 100, Side: orderbook.Sell})
+	tr := orderbook.Trade{Symbol: "btcusdt", ID: 7, Price: 100.0, Quantity: 0.1, Side: orderbook.Buy, Timestamp: time.Now()}
+	state.Tape.Add(tr)
+	state.Range.Add(tr.Price, tr.Timestamp)
+	state.Signals.OnTrade(&tr, state.Book)
+
+	symbols := NewSymbolRegistry()
+	symbols.Add(state)
+	api := NewAPIServer(symbols, func() StatsSnapshot { return StatsSnapshot{TotalMessages: 42} })
+	return api, state
+}
+
+func getJSON(t *testing.T, h http.Handler, path string, out interface{}) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if out != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("GET %s: invalid JSON: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPIBook(t *testing.T) {
+	api, _ := newTestAPI()
+
+	var snap BookSnapshot
+	if code := getJSON(t, api, "/book/BTCUSDT?depth=1", &snap); code != http.StatusOK {
+		t.Fatalf("GET /book status = %d", code)
+	}
+	if len(snap.Bids) != 1 || snap.Bids[0].Price != 99.0 || len(snap.Asks) != 1 {
+		t.Errorf("book snapshot = %+v", snap)
+	}
+	if r := snap.Range; r == nil || r.High != 100 || r.WindowLow != 100 || r.Window != Duration(DefaultRangeWindow) {
+		t.Errorf("book snapshot range = %+v", r)
+	}
+
+	if code := getJSON(t, api, "/book/btcusdt?depth=abc", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid depth status = %d, want 400", code)
+	}
+	if code := getJSON(t, api, "/book/ethusdt", nil); code != http.StatusNotFound {
+		t.Errorf("unknown symbol status = %d, want 404", code)
+	}
+}
+
+func TestAPIQueue(t *testing.T) {
+	api, state := newTestAPI()
+	state.Book.SubmitOrder(&orderbook.Order{ID: 4, Price: 99.0, Quantity: 100, Side: orderbook.Buy, EntryTime: time.Now().Add(-time.Minute)})
+
+	var queue struct {
+		Symbol  string                `json:"symbol"`
+		Bids    []orderbook.LevelAge  `json:"bids"`
+		Asks    []orderbook.LevelAge  `json:"asks"`
+		Hist    []orderbook.AgeBucket `json:"histogram"`
+		Unknown int                   `json:"unknown"`
+	}
+	if code := getJSON(t, api, "/queue/btcusdt?depth=1", &queue); code != http.StatusOK {
+		t.Fatalf("GET /queue status = %d", code)
+	}
+	if len(queue.Bids) != 1 || queue.Bids[0].Orders != 2 || queue.Bids[0].MaxAge < 60 || len(queue.Asks) != 1 {
+		t.Errorf("queue = %+v", queue)
+	}
+	if len(queue.Hist) != len(orderbook.AgeBounds)+1 || queue.Hist[3].Orders != 1 || queue.Unknown != 3 {
+		t.Errorf("histogram %+v, %d unknown", queue.Hist, queue.Unknown)
+	}
+}
+
+func TestAPITradesSignalsStats(t *testing.T) {
+	api, _ := newTestAPI()
+
+	var trades []map[string]interface{}
+	getJSON(t, api, "/trades/btcusdt", &trades)
+	if len(trades) != 1 || trades[0]["side"] != "BUY" || trades[0]["id"] != 7.0 {
+		t.Errorf("trades = %v", trades)
+	}
+
+	var signals struct {
+		Symbol  string             `json:"symbol"`
+		Signals map[string]float64 `json:"signals"`
+	}
+	getJSON(t, api, "/signals/btcusdt", &signals)
+	if signals.Signals["last_price"] != 100.0 {
+		t.Errorf("signals = %+v", signals)
+	}
+	var history struct {
+		Signal string `json:"signal"`
+		Points []struct {
+			T time.Time `json:"t"`
+			V float64   `json:"v"`
+		} `json:"points"`
+	}
+	for _, path := range []string{"/signals/btcusdt/last_price", "/signals/btcusdt/last_price?since=1m"} {
+		if code := getJSON(t, api, path, &history); code != http.StatusOK || len(history.Points) != 1 || history.Points[0].V != 100 {
+			t.Errorf("GET %s = %d, %+v", path, code, history)
+		}
+	}
+	future := "/signals/btcusdt/last_price?since=" + time.Now().Add(time.Hour).Format(time.RFC3339)
+	if code := getJSON(t, api, future, &history); code != http.StatusOK || len(history.Points) != 0 {
+		t.Errorf("GET %s = %d, %+v", future, code, history)
+	}
+	for path, want := range map[string]int{
+		"/signals/btcusdt/last_price?since=yesterday": http.StatusBadRequest,
+		"/signals/btcusdt/nope":                       http.StatusNotFound,
+		"/signals/ethusdt/last_price":                 http.StatusNotFound,
+	} {
+		if code := getJSON(t, api, path, nil); code != want {
+			t.Errorf("GET %s = %d, want %d", path, code, want)
+		}
+	}
+
+	var stats StatsSnapshot
+	getJSON(t, api, "/stats", &stats)
+	if stats.TotalMessages != 42 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAPIMemory(t *testing.T) {
+	api, _ := newTestAPI()
+
+	var report MemoryReport
+	if code := getJSON(t, api, "/memory", &report); code != http.StatusOK {
+		t.Fatalf("GET /memory status = %d", code)
+	}
+	m, ok := report.Symbols["btcusdt"]
+	if !ok || m.BidLevels != 2 || m.AskLevels != 1 || m.RestingOrders != 3 || m.TapeTrades != 1 {
+		t.Errorf("memory report = %+v", report)
+	}
+	if report.HeapAllocBytes == 0 {
+		t.Error("heap size not reported")
+	}
+}
+
+func TestAPISnapshot(t *testing.T) {
+	api, state := newTestAPI()
+
+	for _, path := range []string{"/snapshot/btcusdt", "/snapshot/btcusdt?format=binary"} {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d", path, rec.Code)
+		}
+		loaded, err := orderbook.Load(rec.Body)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if got, want := loaded.Checkpoint(), state.Book.Checkpoint(); got != want {
+			t.Errorf("GET %s: loaded checkpoint = %+v, want %+v", path, got, want)
+		}
+	}
+
+	if code := getJSON(t, api, "/snapshot/btcusdt?format=xml", nil); code != http.StatusBadRequest {
+		t.Errorf("invalid format status = %d, want 400", code)
+	}
+}
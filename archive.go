@@ -0,0 +1,521 @@
+package apexlob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// ArchiveConfig says where completed capture and export files are uploaded
+// and how long they are kept, locally and in the bucket.
+type ArchiveConfig struct {
+	// URL is s3://bucket/prefix; objects are named
+	// <prefix>/<directory name>/<file name>
+	URL string
+	// Endpoint is the S3-compatible API the bucket is on, addressed
+	// path-style: AWS, GCS through its interoperability API, MinIO, R2...
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// KeepLocal is how long an uploaded file stays on disk after it was
+	// last written; 0 deletes it as soon as it is uploaded
+	KeepLocal time.Duration
+	// Retention deletes objects this long after they were uploaded (0
+	// keeps them)
+	Retention time.Duration
+}
+
+func (c *ArchiveConfig) register(fs *pflag.FlagSet) {
+	fs.StringVar(&c.URL, "archive-url", "", "upload completed capture, export and Parquet files to this S3-compatible bucket, e.g. s3://bucket/apexlob (disabled when empty)")
+	fs.StringVar(&c.Endpoint, "archive-endpoint", "https://s3.amazonaws.com", "S3-compatible endpoint of --archive-url, e.g. https://storage.googleapis.com for GCS with HMAC keys")
+	fs.StringVar(&c.Region, "archive-region", "us-east-1", "region the archive requests are signed for (auto for GCS and R2)")
+	fs.StringVar(&c.AccessKey, "archive-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "archive access key ID (defaults to $AWS_ACCESS_KEY_ID)")
+	fs.StringVar(&c.SecretKey, "archive-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "archive secret access key (defaults to $AWS_SECRET_ACCESS_KEY)")
+	fs.DurationVar(&c.KeepLocal, "archive-keep-local", 0, "keep uploaded files on disk for this long after they were last written (0 deletes them once uploaded)")
+	fs.DurationVar(&c.Retention, "archive-retention", 0, "delete archived objects this long after upload (0 keeps them)")
+}
+
+// archiveSweepEvery is how often the archiver retries failed uploads and
+// applies its retention.
+const archiveSweepEvery = time.Minute
+
+// archiveAttempts is how many times an upload is tried before it is left
+// for the next sweep.
+const archiveAttempts = 3
+
+// Archiver uploads files as they are completed, keeping a backlog on disk
+// while the bucket cannot be reached, and deletes them locally and
+// remotely as their retention runs out. Files whose object already exists
+// with the same size are not uploaded again, so a restart can hand it
+// everything in its directories.
+type Archiver struct {
+	cfg      ArchiveConfig
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	client   *http.Client
+	now      func() time.Time
+
+	mu       sync.Mutex
+	pending  []string             // completed files to upload, in order
+	failed   map[string]bool      // uploads left for the next sweep
+	uploaded map[string]time.Time // files kept on disk, by when they were last written
+	wake     chan struct{}
+	closing  chan struct{}
+	done     chan struct{}
+	ctx      context.Context // of the requests, cancelled when Close gives up
+	cancel   context.CancelFunc
+
+	uploads map[string]*Counter // by result
+	bytes   *Counter
+	expired map[string]*Counter // by where
+}
+
+// archiveHTTPClient is the archiver's client; an upload of a large file
+// may take a while.
+var archiveHTTPClient = &http.Client{Timeout: 10 * time.Minute}
+
+func NewArchiver(cfg ArchiveConfig, reg *MetricsRegistry) (*Archiver, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("invalid archive URL %q (want s3://bucket/prefix)", cfg.URL)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid archive endpoint %q", cfg.Endpoint)
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("the archive needs an access key and secret key")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Archiver{
+		ctx:      ctx,
+		cancel:   cancel,
+		cfg:      cfg,
+		endpoint: endpoint,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		client:   archiveHTTPClient,
+		now:      time.Now,
+		failed:   make(map[string]bool),
+		uploaded: make(map[string]time.Time),
+		wake:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		uploads:  make(map[string]*Counter),
+		expired:  make(map[string]*Counter),
+		bytes:    reg.Counter("apexlob_archive_bytes_total", "Bytes of completed files uploaded to the archive.", nil),
+	}
+	for _, result := range []string{"ok", "skipped", "error"} {
+		a.uploads[result] = reg.Counter("apexlob_archive_uploads_total", "Completed files handed to the archive, by result: uploaded, already archived or failed.", Labels{"result": result})
+	}
+	for _, where := range []string{"local", "remote"} {
+		a.expired[where] = reg.Counter("apexlob_archive_expired_total", "Archived files deleted as their retention ran out, by where: on local disk or in the bucket.", Labels{"where": where})
+	}
+	reg.GaugeFunc("apexlob_archive_pending_files", "Completed files waiting to be uploaded to the archive.", func() []Sample {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return []Sample{{Value: float64(len(a.pending) + len(a.failed))}}
+	})
+	return a, nil
+}
+
+// Enqueue hands the archiver a file that will not be written again.
+func (a *Archiver) Enqueue(path string) {
+	a.mu.Lock()
+	a.pending = append(a.pending, path)
+	a.mu.Unlock()
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Backlog enqueues the files in dir named <prefix>-...<ext> for one of
+// prefixes, as rotated files are: those a previous run completed but may not
+// have uploaded or expired.
+func (a *Archiver) Backlog(dir string, prefixes []string, ext string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logger("archive").Warn("cannot list backlog", "dir", dir, "err", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(name, p+"-") }) {
+			a.Enqueue(filepath.Join(dir, name))
+		}
+	}
+}
+
+// Run uploads files as they are enqueued and sweeps every minute until
+// Close. Close waits for it to upload what is pending.
+func (a *Archiver) Run() {
+	defer close(a.done)
+	ctx := a.ctx
+	ticker := time.NewTicker(archiveSweepEvery)
+	defer ticker.Stop()
+	a.sweep(ctx)
+	for {
+		a.drain(ctx)
+		select {
+		case <-a.closing:
+			a.drain(ctx)
+			return
+		case <-a.wake:
+		case <-ticker.C:
+			a.sweep(ctx)
+		}
+	}
+}
+
+// Close uploads what is pending, giving up on what is left once ctx is
+// done; those files stay on disk for the next run's Backlog.
+func (a *Archiver) Close(ctx context.Context) {
+	close(a.closing)
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		logger("archive").Warn("stopped before every file was uploaded; they stay on disk")
+		a.cancel()
+		<-a.done
+	}
+	a.cancel()
+}
+
+func (a *Archiver) drain(ctx context.Context) {
+	for {
+		a.mu.Lock()
+		if len(a.pending) == 0 {
+			a.mu.Unlock()
+			return
+		}
+		path := a.pending[0]
+		a.pending = a.pending[1:]
+		a.mu.Unlock()
+		a.archive(ctx, path)
+	}
+}
+
+// archive uploads one file, retrying with backoff, and applies the local
+// retention to it.
+func (a *Archiver) archive(ctx context.Context, path string) {
+	log := logger("archive")
+	info, err := os.Stat(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Warn("cannot archive file", "path", path, "err", err)
+		}
+		return
+	}
+	key := a.objectKey(path)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		var skipped bool
+		if skipped, err = a.upload(ctx, path, key, info.Size()); err == nil {
+			if skipped {
+				a.uploads["skipped"].Inc()
+			} else {
+				a.uploads["ok"].Inc()
+				a.bytes.Add(uint64(info.Size()))
+				log.Info("archived file", "path", path, "key", key, "bytes", info.Size())
+			}
+			break
+		}
+		if attempt == archiveAttempts || ctx.Err() != nil {
+			a.uploads["error"].Inc()
+			log.Error("archive upload failed, retrying at the next sweep", "path", path, "err", err)
+			a.mu.Lock()
+			a.failed[path] = true
+			a.mu.Unlock()
+			return
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	a.mu.Lock()
+	delete(a.failed, path)
+	a.uploaded[path] = info.ModTime()
+	a.mu.Unlock()
+	a.expireLocal()
+}
+
+func (a *Archiver) objectKey(path string) string {
+	key := filepath.Base(filepath.Dir(path)) + "/" + filepath.Base(path)
+	if a.prefix != "" {
+		key = a.prefix + "/" + key
+	}
+	return key
+}
+
+// sweep retries failed uploads and applies both retentions.
+func (a *Archiver) sweep(ctx context.Context) {
+	a.mu.Lock()
+	for path := range a.failed {
+		a.pending = append(a.pending, path)
+	}
+	clear(a.failed)
+	a.mu.Unlock()
+	a.expireLocal()
+	if a.cfg.Retention > 0 {
+		if err := a.expireRemote(ctx); err != nil {
+			logger("archive").Warn("archive retention failed", "err", err)
+		}
+	}
+}
+
+// expireLocal deletes uploaded files that have outlived KeepLocal.
+func (a *Archiver) expireLocal() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for path, written := range a.uploaded {
+		if a.now().Sub(written) < a.cfg.KeepLocal {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger("archive").Warn("cannot delete archived file", "path", path, "err", err)
+			continue
+		}
+		delete(a.uploaded, path)
+		a.expired["local"].Inc()
+	}
+}
+
+// upload puts the file at path as key, unless an object of that size is
+// already there, which it reports.
+func (a *Archiver) upload(ctx context.Context, path, key string, size int64) (skipped bool, err error) {
+	resp, err := a.do(ctx, http.MethodHead, key, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && resp.ContentLength == size {
+		return true, nil
+	}
+
+	// The payload is signed, so the file is read twice: once to hash it
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	resp, err = a.do(ctx, http.MethodPut, key, nil, io.LimitReader(f, size), size, hex.EncodeToString(h.Sum(nil)))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, archiveError(resp)
+	}
+	return false, nil
+}
+
+// expireRemote deletes the objects under the prefix uploaded longer than
+// Retention ago.
+func (a *Archiver) expireRemote(ctx context.Context) error {
+	cutoff := a.now().Add(-a.cfg.Retention)
+	var token string
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if a.prefix != "" {
+			q.Set("prefix", a.prefix+"/")
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := a.do(ctx, http.MethodGet, "", q, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := archiveError(resp)
+			resp.Body.Close()
+			return err
+		}
+		var list struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("listing the archive: %w", err)
+		}
+		for _, obj := range list.Contents {
+			if !obj.LastModified.Before(cutoff) {
+				continue
+			}
+			resp, err := a.do(ctx, http.MethodDelete, obj.Key, nil, nil, 0, emptyPayloadHash)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("deleting %s: %s", obj.Key, resp.Status)
+			}
+			a.expired["remote"].Inc()
+			logger("archive").Info("expired archived object", "key", obj.Key, "uploaded", obj.LastModified)
+		}
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do sends a signed request for key in the bucket (the bucket itself if key
+// is empty), with a body of size bytes.
+func (a *Archiver) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *a.endpoint
+	u.Path = u.Path + "/" + a.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	// The path is sent escaped exactly as it is signed
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signS3(req, a.cfg.AccessKey, a.cfg.SecretKey, a.cfg.Region, payloadHash, a.now())
+	return a.client.Do(req)
+}
+
+// archiveError reads the reason out of an S3 error response.
+func archiveError(resp *http.Response) error {
+	var body struct {
+		Code    string
+		Message string
+	}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && body.Code != "" {
+		return fmt.Errorf("archive: %s: %s (%s)", resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("archive: %s", resp.Status)
+}
+
+// signS3 signs req for S3 with AWS Signature Version 4, over the host and
+// every header already set.
+func signS3(req *http.Request, accessKey, secretKey, region, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// Signature Version 4 requires.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		segments[i] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	pairs := make([]string, 0, len(q))
+	for name, values := range q {
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(v))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
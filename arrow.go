@@ -0,0 +1,501 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// A minimal Apache Arrow IPC stream writer, so Python consumers can read
+// trades and book snapshots with pyarrow.ipc.open_stream instead of parsing
+// JSON. Only the column types the monitor emits are supported: int64,
+// float64, utf8 and UTC microsecond timestamps, all non-nullable. Message
+// metadata is FlatBuffers, produced by the small encoder at the bottom.
+
+const (
+	arrowTypeInt       = 2
+	arrowTypeFloat     = 3
+	arrowTypeUtf8      = 5
+	arrowTypeTimestamp = 10
+
+	arrowMetadataV5     = 4
+	arrowHeaderSchema   = 1
+	arrowHeaderRecBatch = 3
+)
+
+type arrowColumn struct {
+	name    string
+	typ     uint8
+	data    bytes.Buffer
+	offsets []int32 // utf8 only
+	n       int
+}
+
+func (c *arrowColumn) Int64(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	c.data.Write(b[:])
+	c.n++
+}
+
+func (c *arrowColumn) Time(t time.Time) { c.Int64(t.UnixMicro()) }
+
+func (c *arrowColumn) Double(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	c.data.Write(b[:])
+	c.n++
+}
+
+func (c *arrowColumn) String(s string) {
+	if c.offsets == nil {
+		c.offsets = []int32{0}
+	}
+	c.data.WriteString(s)
+	c.offsets = append(c.offsets, int32(c.data.Len()))
+	c.n++
+}
+
+func (c *arrowColumn) reset() {
+	c.data.Reset()
+	if c.offsets != nil {
+		c.offsets = append(c.offsets[:0], 0)
+	}
+	c.n = 0
+}
+
+// field returns the column's Schema.Field table.
+func (c *arrowColumn) field() fbTable {
+	var typ fbTable
+	switch c.typ {
+	case arrowTypeInt:
+		typ = fbTable{int32(64), true}
+	case arrowTypeFloat:
+		typ = fbTable{int16(2)} // DOUBLE
+	case arrowTypeTimestamp:
+		typ = fbTable{int16(2), fbString("UTC")} // MICROSECOND
+	default:
+		typ = fbTable{}
+	}
+	// name, nullable, type_type, type, dictionary, children
+	return fbTable{fbString(c.name), false, c.typ, typ, nil, []fbTable{}}
+}
+
+// arrowTable buffers rows column by column until a record batch is written.
+type arrowTable struct {
+	columns []*arrowColumn
+	byName  map[string]*arrowColumn
+}
+
+func newArrowTable() *arrowTable {
+	return &arrowTable{byName: make(map[string]*arrowColumn)}
+}
+
+func (t *arrowTable) add(name string, typ uint8) *arrowColumn {
+	c := &arrowColumn{name: name, typ: typ}
+	t.columns = append(t.columns, c)
+	t.byName[name] = c
+	return c
+}
+
+func (t *arrowTable) Int64Col(name string) *arrowColumn  { return t.add(name, arrowTypeInt) }
+func (t *arrowTable) TimeCol(name string) *arrowColumn   { return t.add(name, arrowTypeTimestamp) }
+func (t *arrowTable) DoubleCol(name string) *arrowColumn { return t.add(name, arrowTypeFloat) }
+func (t *arrowTable) StringCol(name string) *arrowColumn { return t.add(name, arrowTypeUtf8) }
+
+func (t *arrowTable) Col(name string) *arrowColumn { return t.byName[name] }
+
+func (t *arrowTable) Rows() int {
+	if len(t.columns) == 0 {
+		return 0
+	}
+	return t.columns[0].n
+}
+
+// ArrowStreamWriter writes an Arrow IPC stream: the schema, one record batch
+// per WriteBatch call and an end-of-stream marker on Close.
+type ArrowStreamWriter struct {
+	w     io.Writer
+	table *arrowTable
+}
+
+func NewArrowStreamWriter(w io.Writer, table *arrowTable) (*ArrowStreamWriter, error) {
+	fields := make([]fbTable, len(table.columns))
+	for i, c := range table.columns {
+		fields[i] = c.field()
+	}
+	schema := fbTable{int16(0), fields} // little endian
+	msg := fbTable{int16(arrowMetadataV5), uint8(arrowHeaderSchema), schema, int64(0)}
+	aw := &ArrowStreamWriter{w: w, table: table}
+	return aw, aw.message(fbFinish(msg), nil)
+}
+
+// WriteBatch writes the buffered rows as one record batch and clears them.
+func (aw *ArrowStreamWriter) WriteBatch() error {
+	rows := aw.table.Rows()
+	if rows == 0 {
+		return nil
+	}
+	var body []byte
+	nodes := fbStructs{align: 8}
+	buffers := fbStructs{align: 8}
+	addBuffer := func(b []byte) {
+		buffers.add(int64(len(body)), int64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for _, c := range aw.table.columns {
+		nodes.add(int64(c.n), 0)
+		addBuffer(nil) // validity bitmap, omitted as nothing is null
+		if c.typ == arrowTypeUtf8 {
+			offsets := make([]byte, 4*len(c.offsets))
+			for i, o := range c.offsets {
+				binary.LittleEndian.PutUint32(offsets[4*i:], uint32(o))
+			}
+			addBuffer(offsets)
+		}
+		addBuffer(c.data.Bytes())
+	}
+	batch := fbTable{int64(rows), nodes, buffers}
+	msg := fbTable{int16(arrowMetadataV5), uint8(arrowHeaderRecBatch), batch, int64(len(body))}
+	err := aw.message(fbFinish(msg), body)
+	for _, c := range aw.table.columns {
+		c.reset()
+	}
+	return err
+}
+
+// Close writes any buffered rows and the end-of-stream marker.
+func (aw *ArrowStreamWriter) Close() error {
+	if err := aw.WriteBatch(); err != nil {
+		return err
+	}
+	_, err := aw.w.Write([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	return err
+}
+
+// message writes one encapsulated IPC message: continuation marker,
+// metadata length, metadata padded to 8 bytes, body.
+func (aw *ArrowStreamWriter) message(meta, body []byte) error {
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[:], 0xffffffff)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(len(meta)))
+	for _, b := range [][]byte{prefix[:], meta, body} {
+		if _, err := aw.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newArrowTradesTable() *arrowTable {
+	t := newArrowTable()
+	t.TimeCol("timestamp")
+	t.StringCol("symbol")
+	t.Int64Col("id")
+	t.StringCol("side")
+	t.DoubleCol("price")
+	t.DoubleCol("quantity")
+	return t
+}
+
+func newArrowBookTable() *arrowTable {
+	t := newArrowTable()
+	t.TimeCol("timestamp")
+	t.StringCol("symbol")
+	t.StringCol("side")
+	t.Int64Col("level")
+	t.DoubleCol("price")
+	t.Int64Col("volume")
+	t.Int64Col("orders")
+	return t
+}
+
+func appendArrowTrade(t *arrowTable, tr *orderbook.Trade) {
+	t.Col("timestamp").Time(tr.Timestamp)
+	t.Col("symbol").String(tr.Symbol)
+	t.Col("id").Int64(int64(tr.ID))
+	t.Col("side").String(tr.Side.String())
+	t.Col("price").Double(tr.Price)
+	t.Col("quantity").Double(tr.Quantity)
+}
+
+func appendArrowBook(t *arrowTable, e *Event) {
+	addSide := func(side string, levels []orderbook.PriceLevel) {
+		for i, lvl := range levels {
+			t.Col("timestamp").Time(e.Timestamp)
+			t.Col("symbol").String(e.Symbol)
+			t.Col("side").String(side)
+			t.Col("level").Int64(int64(i))
+			t.Col("price").Double(lvl.Price)
+			t.Col("volume").Int64(int64(lvl.Volume))
+			t.Col("orders").Int64(int64(lvl.Orders))
+		}
+	}
+	addSide("BID", e.Book.Bids)
+	addSide("ASK", e.Book.Asks)
+}
+
+type ArrowStreamConfig struct {
+	BatchInterval time.Duration // longest a row waits before being sent
+	BatchRows     int
+	BookInterval  time.Duration // default minimum spacing of book snapshots per symbol
+}
+
+// ArrowStreamServer serves live Arrow IPC streams over HTTP:
+// /arrow/trades and /arrow/book, filtered with ?symbols=btcusdt,ethusdt.
+// Book snapshots are long form (one row per level) and can be thinned with
+// ?book_interval=250ms.
+//
+//	reader = pyarrow.ipc.open_stream(urllib.request.urlopen(url))
+//	for batch in reader: ...
+type ArrowStreamServer struct {
+	symbols *SymbolRegistry
+	bus     *EventBus
+	cfg     ArrowStreamConfig
+}
+
+func NewArrowStreamServer(symbols *SymbolRegistry, bus *EventBus, cfg ArrowStreamConfig) *ArrowStreamServer {
+	if cfg.BatchInterval <= 0 {
+		cfg.BatchInterval = time.Second
+	}
+	if cfg.BatchRows <= 0 {
+		cfg.BatchRows = 4096
+	}
+	if cfg.BookInterval <= 0 {
+		cfg.BookInterval = time.Second
+	}
+	return &ArrowStreamServer{symbols: symbols, bus: bus, cfg: cfg}
+}
+
+func (s *ArrowStreamServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var table *arrowTable
+	var typ EventType
+	switch strings.TrimPrefix(r.URL.Path, "/arrow/") {
+	case "trades":
+		table, typ = newArrowTradesTable(), EventTrade
+	case "book":
+		table, typ = newArrowBookTable(), EventBook
+	default:
+		writeError(w, http.StatusNotFound, "unknown Arrow stream; use /arrow/trades or /arrow/book")
+		return
+	}
+	var symbols []string
+	for _, sym := range splitList(r.URL.Query().Get("symbols")) {
+		state, ok := s.symbols.Get(sym)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown symbol "+sym)
+			return
+		}
+		symbols = append(symbols, state.Symbol)
+	}
+	bookInterval := s.cfg.BookInterval
+	if v := r.URL.Query().Get("book_interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid book_interval: "+err.Error())
+			return
+		}
+		bookInterval = d
+	}
+
+	events, cancel := s.bus.SubscribeAs("arrow:"+r.RemoteAddr, 4096, symbols, []EventType{typ})
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/vnd.apache.arrow.stream")
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	aw, err := NewArrowStreamWriter(w, table)
+	if err != nil {
+		return
+	}
+	flush()
+
+	ticker := time.NewTicker(s.cfg.BatchInterval)
+	defer ticker.Stop()
+	lastBook := make(map[string]time.Time)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				aw.Close()
+				return
+			}
+			if typ == EventTrade {
+				appendArrowTrade(table, e.Trade)
+				e.Release()
+			} else {
+				if last, ok := lastBook[e.Symbol]; ok && e.Timestamp.Sub(last) < bookInterval {
+					continue
+				}
+				lastBook[e.Symbol] = e.Timestamp
+				appendArrowBook(table, &e)
+			}
+			if table.Rows() < s.cfg.BatchRows {
+				continue
+			}
+		case <-ticker.C:
+			if table.Rows() == 0 {
+				continue
+			}
+		}
+		if err := aw.WriteBatch(); err != nil {
+			logger("arrow").Warn("dropping stream client", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+		flush()
+	}
+}
+
+// fbTable is a FlatBuffers table under construction; element i is field
+// slot i and nil marks an absent field. Elements are scalars (bool, uint8,
+// int16, int32, int64) or references (fbTable, fbString, []fbTable,
+// fbStructs). The encoder writes parents before children, which keeps every
+// uoffset pointing forward as the format requires.
+type fbTable []interface{}
+
+type fbString string
+
+// fbStructs is a vector of structs made of int64 fields.
+type fbStructs struct {
+	align int
+	data  []byte
+	n     int
+}
+
+func (s *fbStructs) add(fields ...int64) {
+	for _, f := range fields {
+		s.data = binary.LittleEndian.AppendUint64(s.data, uint64(f))
+	}
+	s.n++
+}
+
+type fbEncoder struct{ buf []byte }
+
+// fbFinish encodes root and pads the result to 8 bytes.
+func fbFinish(root fbTable) []byte {
+	e := &fbEncoder{buf: make([]byte, 4, 512)}
+	pos := e.table(root)
+	binary.LittleEndian.PutUint32(e.buf, uint32(pos))
+	e.align(8)
+	return e.buf
+}
+
+func (e *fbEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func fbSize(v interface{}) int {
+	switch v.(type) {
+	case bool, uint8:
+		return 1
+	case int16:
+		return 2
+	case int64:
+		return 8
+	}
+	return 4 // int32 and uoffsets
+}
+
+func (e *fbEncoder) table(t fbTable) int {
+	e.align(2)
+	vt := len(e.buf)
+	vtSize := 4 + 2*len(t)
+	e.buf = append(e.buf, make([]byte, vtSize)...)
+	e.align(8)
+	start := len(e.buf)
+	e.buf = append(e.buf, 0, 0, 0, 0) // soffset to the vtable
+
+	type pending struct {
+		at int
+		v  interface{}
+	}
+	var refs []pending
+	for i, f := range t {
+		if f == nil {
+			continue
+		}
+		e.align(fbSize(f))
+		binary.LittleEndian.PutUint16(e.buf[vt+4+2*i:], uint16(len(e.buf)-start))
+		switch x := f.(type) {
+		case bool:
+			if x {
+				e.buf = append(e.buf, 1)
+			} else {
+				e.buf = append(e.buf, 0)
+			}
+		case uint8:
+			e.buf = append(e.buf, x)
+		case int16:
+			e.buf = binary.LittleEndian.AppendUint16(e.buf, uint16(x))
+		case int32:
+			e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(x))
+		case int64:
+			e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(x))
+		default:
+			refs = append(refs, pending{len(e.buf), f})
+			e.buf = append(e.buf, 0, 0, 0, 0)
+		}
+	}
+	binary.LittleEndian.PutUint16(e.buf[vt:], uint16(vtSize))
+	binary.LittleEndian.PutUint16(e.buf[vt+2:], uint16(len(e.buf)-start))
+	binary.LittleEndian.PutUint32(e.buf[start:], uint32(int32(start-vt)))
+
+	for _, r := range refs {
+		pos := e.ref(r.v)
+		binary.LittleEndian.PutUint32(e.buf[r.at:], uint32(pos-r.at))
+	}
+	return start
+}
+
+func (e *fbEncoder) ref(v interface{}) int {
+	switch x := v.(type) {
+	case fbTable:
+		return e.table(x)
+	case fbString:
+		e.align(4)
+		pos := len(e.buf)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(len(x)))
+		e.buf = append(e.buf, x...)
+		e.buf = append(e.buf, 0)
+		return pos
+	case []fbTable:
+		e.align(4)
+		pos := len(e.buf)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(len(x)))
+		slots := len(e.buf)
+		e.buf = append(e.buf, make([]byte, 4*len(x))...)
+		for i, t := range x {
+			at := slots + 4*i
+			child := e.table(t) // may grow e.buf, so index it afterwards
+			binary.LittleEndian.PutUint32(e.buf[at:], uint32(child-at))
+		}
+		return pos
+	case fbStructs:
+		e.align(4)
+		for (len(e.buf)+4)%x.align != 0 {
+			e.buf = append(e.buf, 0)
+		}
+		pos := len(e.buf)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(x.n))
+		e.buf = append(e.buf, x.data...)
+		return pos
+	}
+	panic("fbEncoder: unsupported value")
+}
@@ -0,0 +1,158 @@
+package apexlob
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+
+	"apexlob/pkg/orderbook"
+)
+
+// The streams are read back with the Apache Arrow Go implementation, the
+// same IPC format pyarrow.ipc.open_stream reads.
+
+func TestArrowStreamWriter(t *testing.T) {
+	table := newArrowTable()
+	ts := table.TimeCol("timestamp")
+	price := table.DoubleCol("price")
+	sym := table.StringCol("symbol")
+	id := table.Int64Col("id")
+
+	var buf bytes.Buffer
+	aw, err := NewArrowStreamWriter(&buf, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, s := range []string{"btcusdt", "ethusdt", ""} {
+		ts.Time(base.Add(time.Duration(i) * time.Second))
+		price.Double(100.5 + float64(i))
+		sym.String(s)
+		id.Int64(-int64(i))
+	}
+	if err := aw.WriteBatch(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Time(base)
+	price.Double(1)
+	sym.String("solusdt")
+	id.Int64(1 << 62)
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+	rdr, err := ipc.NewReader(&buf, ipc.WithAllocator(mem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Release()
+
+	want := arrow.NewSchema([]arrow.Field{
+		{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}},
+		{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "symbol", Type: arrow.BinaryTypes.String},
+		{Name: "id", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	if !rdr.Schema().Equal(want) {
+		t.Fatalf("schema = %v, want %v", rdr.Schema(), want)
+	}
+
+	if !rdr.Next() {
+		t.Fatalf("no first batch: %v", rdr.Err())
+	}
+	first := rdr.Record()
+	if first.NumRows() != 3 {
+		t.Errorf("first batch length = %d, want 3", first.NumRows())
+	}
+	if got := first.Column(0).(*array.Timestamp).Value(2).ToTime(arrow.Microsecond); !got.Equal(base.Add(2 * time.Second)) {
+		t.Errorf("timestamp = %v", got)
+	}
+	if got := first.Column(1).(*array.Float64).Float64Values(); got[1] != 101.5 {
+		t.Errorf("prices = %v", got)
+	}
+	syms := first.Column(2).(*array.String)
+	if syms.Value(0) != "btcusdt" || syms.Value(1) != "ethusdt" || syms.Value(2) != "" || syms.NullN() != 0 {
+		t.Errorf("symbols = %v", syms)
+	}
+	if got := first.Column(3).(*array.Int64).Int64Values(); got[2] != -2 {
+		t.Errorf("ids = %v", got)
+	}
+
+	if !rdr.Next() {
+		t.Fatalf("no second batch: %v", rdr.Err())
+	}
+	second := rdr.Record()
+	if second.NumRows() != 1 || second.Column(2).(*array.String).Value(0) != "solusdt" || second.Column(3).(*array.Int64).Value(0) != 1<<62 {
+		t.Errorf("second batch = %v (offsets must restart after a batch)", second)
+	}
+	if rdr.Next() || rdr.Err() != nil {
+		t.Errorf("expected end of stream, err %v", rdr.Err())
+	}
+}
+
+func TestArrowStreamServer(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bus := NewEventBus()
+	srv := httptest.NewServer(NewArrowStreamServer(symbols, bus, ArrowStreamConfig{BatchInterval: 10 * time.Millisecond}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/arrow/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown stream status = %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/arrow/trades?symbols=btcusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/vnd.apache.arrow.stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	rdr, err := ipc.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Release()
+	var names []string
+	for _, f := range rdr.Schema().Fields() {
+		names = append(names, f.Name)
+	}
+	if got := fmt.Sprint(names); got != "[timestamp symbol id side price quantity]" {
+		t.Errorf("trade columns = %s", got)
+	}
+
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{Symbol: "btcusdt", ID: uint64(i + 1), Price: 100, Quantity: 1, Side: orderbook.Sell, Timestamp: ts}})
+	}
+	var rows int64
+	for rows < 3 && rdr.Next() {
+		rec := rdr.Record()
+		ids := rec.Column(2).(*array.Int64)
+		for i := 0; i < int(rec.NumRows()); i++ {
+			if ids.Value(i) != rows+1 || rec.Column(3).(*array.String).Value(i) != "SELL" || !rec.Column(0).(*array.Timestamp).Value(i).ToTime(arrow.Microsecond).Equal(ts) {
+				t.Errorf("row %d = id %d side %s", rows, ids.Value(i), rec.Column(3).(*array.String).Value(i))
+			}
+			rows++
+		}
+	}
+	if rows != 3 {
+		t.Errorf("read %d trades, want 3: %v", rows, rdr.Err())
+	}
+}
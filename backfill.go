@@ -0,0 +1,200 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CandleStore is a store that can read back and add to the closed candles
+// it persists, so a restart resumes the candle history instead of building
+// it again from trades.
+type CandleStore interface {
+	// LoadCandles returns symbol's bars of interval opened in [from, to),
+	// oldest first
+	LoadCandles(symbol string, interval time.Duration, from, to time.Time) ([]Candle, error)
+	SaveCandles(candles []Candle) error
+}
+
+// klinesLimit is the most bars the klines endpoint serves per request.
+const klinesLimit = 1000
+
+// klineIntervals names the bar lengths the klines endpoint serves that
+// candles can have.
+var klineIntervals = map[time.Duration]string{
+	time.Minute:      "1m",
+	3 * time.Minute:  "3m",
+	5 * time.Minute:  "5m",
+	15 * time.Minute: "15m",
+	30 * time.Minute: "30m",
+	time.Hour:        "1h",
+}
+
+// FetchKlines gets symbol's bars of interval opened in [from, to) from the
+// REST API at baseURL, paging through as many requests as it takes.
+func FetchKlines(ctx context.Context, client *http.Client, baseURL, symbol string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	name, ok := klineIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("klines: unsupported interval %v", interval)
+	}
+	var out []Candle
+	for from.Before(to) {
+		url := fmt.Sprintf("%s/api/v3/klines?symbol=%s&interval=%s&startTime=%d&endTime=%d&limit=%d",
+			baseURL, strings.ToUpper(symbol), name, from.UnixMilli(), to.UnixMilli()-1, klinesLimit)
+		page, err := fetchKlinePage(ctx, client, url, symbol, interval)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page {
+			if !c.OpenTime.Before(from) && c.OpenTime.Before(to) {
+				out = append(out, c)
+			}
+		}
+		if len(page) < klinesLimit {
+			break
+		}
+		from = page[len(page)-1].OpenTime.Add(interval)
+	}
+	return out, nil
+}
+
+func fetchKlinePage(ctx context.Context, client *http.Client, url, symbol string, interval time.Duration) ([]Candle, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("klines for %s: %s", symbol, resp.Status)
+	}
+	// Each kline is an array: open time, open, high, low, close, volume,
+	// close time, quote volume, trades, ...
+	var rows [][]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("klines for %s: %w", symbol, err)
+	}
+	candles := make([]Candle, 0, len(rows))
+	for _, row := range rows {
+		c, err := parseKline(row)
+		if err != nil {
+			return nil, fmt.Errorf("klines for %s: %w", symbol, err)
+		}
+		c.Symbol, c.Interval = strings.ToLower(symbol), interval
+		candles = append(candles, c)
+	}
+	return candles, nil
+}
+
+func parseKline(row []json.RawMessage) (Candle, error) {
+	if len(row) < 9 {
+		return Candle{}, fmt.Errorf("kline has %d fields, want at least 9", len(row))
+	}
+	var openMs, trades int64
+	var fields [5]string
+	if err := json.Unmarshal(row[0], &openMs); err != nil {
+		return Candle{}, fmt.Errorf("kline open time: %w", err)
+	}
+	if err := json.Unmarshal(row[8], &trades); err != nil {
+		return Candle{}, fmt.Errorf("kline trades: %w", err)
+	}
+	var values [5]float64
+	for i := range fields {
+		if err := json.Unmarshal(row[i+1], &fields[i]); err != nil {
+			return Candle{}, fmt.Errorf("kline field %d: %w", i+1, err)
+		}
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return Candle{}, fmt.Errorf("kline field %d: %w", i+1, err)
+		}
+		values[i] = v
+	}
+	return Candle{
+		OpenTime: time.UnixMilli(openMs).UTC(),
+		Open:     values[0],
+		High:     values[1],
+		Low:      values[2],
+		Close:    values[3],
+		Volume:   values[4],
+		Trades:   int(trades),
+	}, nil
+}
+
+// klineFetcher gets a symbol's bars of interval opened in [from, to).
+type klineFetcher func(ctx context.Context, symbol string, interval time.Duration, from, to time.Time) ([]Candle, error)
+
+func binanceKlines(ctx context.Context, symbol string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	return FetchKlines(ctx, restClient, binanceRESTURL, symbol, interval, from, to)
+}
+
+// BackfillCandles warms every symbol's candle history with the bars closed
+// in the lookback before now: those the store already has, and any it is
+// missing fetched with fetch and saved to it. It must run before the
+// workers start.
+func (m *Monitor) BackfillCandles(ctx context.Context, lookback time.Duration, now time.Time, fetch klineFetcher) error {
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		interval := state.Candles.Interval()
+		to := now.Truncate(interval) // the bar in progress is left to the feed
+		from := to.Add(-lookback).Truncate(interval)
+
+		var have []Candle
+		if m.candles != nil {
+			var err error
+			if have, err = m.candles.LoadCandles(sym, interval, from, to); err != nil {
+				return fmt.Errorf("loading %s candles: %w", sym, err)
+			}
+		}
+		// One fetch from the first missing bar on, rather than one per gap: a
+		// quiet symbol can have many
+		var fetched []Candle
+		if start, ok := firstMissingCandle(have, interval, from, to); ok {
+			bars, err := fetch(ctx, sym, interval, start, to)
+			if err != nil {
+				return fmt.Errorf("backfilling %s candles: %w", sym, err)
+			}
+			stored := make(map[int64]bool, len(have))
+			for _, c := range have {
+				stored[c.OpenTime.UnixMilli()] = true
+			}
+			for _, c := range bars {
+				if !stored[c.OpenTime.UnixMilli()] {
+					fetched = append(fetched, c)
+				}
+			}
+		}
+		if len(fetched) > 0 && m.candles != nil {
+			if err := m.candles.SaveCandles(fetched); err != nil {
+				return fmt.Errorf("saving %s candles: %w", sym, err)
+			}
+		}
+		bars := append(have, fetched...)
+		slices.SortFunc(bars, func(a, b Candle) int { return a.OpenTime.Compare(b.OpenTime) })
+		seeded := state.Candles.Seed(bars)
+		logger("main").Info("backfilled candles", "symbol", sym, "stored", len(have), "fetched", len(fetched), "seeded", seeded)
+	}
+	return nil
+}
+
+// firstMissingCandle returns the open time of the first bar of interval in
+// [from, to) that have, which is in order, lacks.
+func firstMissingCandle(have []Candle, interval time.Duration, from, to time.Time) (time.Time, bool) {
+	next := from
+	for _, c := range have {
+		if c.OpenTime.After(next) {
+			break
+		}
+		if end := c.OpenTime.Add(interval); end.After(next) {
+			next = end
+		}
+	}
+	return next, next.Before(to)
+}
@@ -0,0 +1,121 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchKlinesPages(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		q := r.URL.Query()
+		if r.URL.Path != "/api/v3/klines" || q.Get("symbol") != "BTCUSDT" || q.Get("interval") != "1m" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		start, _ := strconv.ParseInt(q.Get("startTime"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("endTime"), 10, 64)
+		var rows []string
+		for ms := start; ms <= end && len(rows) < klinesLimit; ms += 60000 {
+			i := (ms - base.UnixMilli()) / 60000
+			rows = append(rows, fmt.Sprintf(`[%d,"%d.0","%d.5","%d.0","%d.25","2.5",%d,"250.0",%d,"1.0","100.0","0"]`,
+				ms, 100+i, 101+i, 99+i, 100+i, ms+59999, i))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(rows, ","))
+	}))
+	defer srv.Close()
+
+	candles, err := FetchKlines(context.Background(), srv.Client(), srv.URL, "btcusdt", time.Minute, base, base.Add(1500*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 1500 || requests != 2 {
+		t.Fatalf("got %d candles in %d requests, want 1500 in 2", len(candles), requests)
+	}
+	c := candles[1200]
+	if !c.OpenTime.Equal(base.Add(1200*time.Minute)) || c.Symbol != "btcusdt" || c.Interval != time.Minute ||
+		c.Open != 1300 || c.High != 1301.5 || c.Low != 1299 || c.Close != 1300.25 || c.Volume != 2.5 || c.Trades != 1200 {
+		t.Errorf("candle 1200 = %+v", c)
+	}
+
+	if _, err := FetchKlines(context.Background(), srv.Client(), srv.URL, "btcusdt", 2*time.Minute, base, base.Add(time.Hour)); err == nil {
+		t.Error("fetched an interval the endpoint does not serve")
+	}
+}
+
+type memoryCandleStore struct {
+	candles []Candle
+	saved   []Candle
+}
+
+func (s *memoryCandleStore) LoadCandles(symbol string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	var out []Candle
+	for _, c := range s.candles {
+		if c.Symbol == symbol && c.Interval == interval && !c.OpenTime.Before(from) && c.OpenTime.Before(to) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryCandleStore) SaveCandles(candles []Candle) error {
+	s.saved = append(s.saved, candles...)
+	return nil
+}
+
+func TestBackfillCandles(t *testing.T) {
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, Limits: DefaultSymbolLimits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	now := time.Date(2024, 1, 1, 1, 0, 30, 0, time.UTC)
+	bar := func(i int) Candle {
+		return Candle{Symbol: "btcusdt", Interval: time.Minute, OpenTime: now.Truncate(time.Minute).Add(time.Duration(i-60) * time.Minute), Close: float64(100 + i)}
+	}
+	// The store has the first 40 minutes of the hour but for a gap
+	store := &memoryCandleStore{}
+	for i := 0; i < 40; i++ {
+		if i != 10 {
+			store.candles = append(store.candles, bar(i))
+		}
+	}
+	m.candles = store
+
+	var from, to time.Time
+	fetch := func(ctx context.Context, symbol string, interval time.Duration, f, tt time.Time) ([]Candle, error) {
+		from, to = f, tt
+		var out []Candle
+		for i := 0; i < 60; i++ {
+			if c := bar(i); !c.OpenTime.Before(f) && c.OpenTime.Before(tt) {
+				out = append(out, c)
+			}
+		}
+		return out, nil
+	}
+	if err := m.BackfillCandles(context.Background(), time.Hour, now, fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	if !from.Equal(bar(10).OpenTime) || !to.Equal(now.Truncate(time.Minute)) {
+		t.Errorf("fetched [%v, %v), want from the gap to the bar in progress", from, to)
+	}
+	if len(store.saved) != 21 {
+		t.Errorf("saved %d fetched bars, want the 21 the store lacked", len(store.saved))
+	}
+	state, _ := m.Symbols.Get("btcusdt")
+	history := state.Candles.History()
+	if len(history) != 60 || history[59].Close != 159 {
+		t.Fatalf("history has %d bars, want the full hour", len(history))
+	}
+	if _, ok := state.Candles.RSI(); !ok {
+		t.Error("RSI not warm after the backfill")
+	}
+}
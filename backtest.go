@@ -0,0 +1,160 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// BacktestReport summarizes a capture run through the pipeline: how often
+// each rule fired and where every symbol's book and signals ended up.
+type BacktestReport struct {
+	Messages int                           `json:"messages"`
+	Elapsed  time.Duration                 `json:"elapsed_ns"`
+	Alerts   map[string]map[string]int     `json:"alerts"` // rule, then symbol
+	Books    map[string]BookSnapshot       `json:"books"`
+	Signals  map[string]map[string]float64 `json:"signals"`
+	Strategy *StrategyReport               `json:"strategy,omitempty"`
+}
+
+// RunBacktest replays the capture at path through a pipeline built from
+// opts as fast as it can be processed, or until ctx is done. Alerts go to
+// the configured sinks as well as into the report.
+func RunBacktest(ctx context.Context, opts PipelineOptions, path string) (BacktestReport, error) {
+	return RunStrategyBacktest(ctx, opts, path, nil, StrategyOptions{})
+}
+
+// RunStrategyBacktest is RunBacktest with strategy trading against the
+// books. Strategy runs use a single worker, so the strategy sees the
+// capture in order and the same capture always gives the same fills.
+func RunStrategyBacktest(ctx context.Context, opts PipelineOptions, path string, strategy Strategy, strategyOpts StrategyOptions) (BacktestReport, error) {
+	opts.quietAlerts = true
+	opts.resolveClock(true)
+	if strategy != nil {
+		opts.Shards = 1
+	}
+	m, err := NewMonitor(time.Now(), opts)
+	if err != nil {
+		return BacktestReport{}, err
+	}
+	defer m.Close()
+	var sc *StrategyContext
+	if strategy != nil {
+		if sc, err = m.AttachStrategy(strategy, strategyOpts); err != nil {
+			return BacktestReport{}, err
+		}
+	}
+
+	report := BacktestReport{
+		Books:   make(map[string]BookSnapshot),
+		Signals: make(map[string]map[string]float64),
+	}
+	alerts := m.CountAlerts()
+
+	done := m.Run()
+	err = ReplayCapture(ctx, m, path, 0)
+	<-done
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return BacktestReport{}, err
+	}
+
+	report.Alerts = alerts()
+	report.Elapsed = time.Since(m.Start)
+	report.Messages = m.Stats.Snapshot().TotalMessages
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		report.Books[sym] = state.BookSnapshot(5)
+		report.Signals[sym] = state.Signals.Snapshot()
+	}
+	if sc != nil {
+		strategyReport := sc.Report()
+		report.Strategy = &strategyReport
+	}
+	return report, nil
+}
+
+func (r BacktestReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "messages  %d in %v\n", r.Messages, r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintln(w, "\nalerts")
+	if len(r.Alerts) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, rule := range sortedKeys(r.Alerts) {
+		for _, sym := range sortedKeys(r.Alerts[rule]) {
+			fmt.Fprintf(w, "  %-24s %-12s %d\n", rule, sym, r.Alerts[rule][sym])
+		}
+	}
+	for _, sym := range sortedKeys(r.Books) {
+		b := r.Books[sym]
+		fmt.Fprintf(w, "\n%s  last %.2f  vwap %.2f  volume %d\n", sym, b.LastTradePrice, b.VWAP, b.TotalVolume)
+		signals := r.Signals[sym]
+		names := make([]string, 0, len(signals))
+		for name := range signals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %-24s %.6g\n", name, signals[name])
+		}
+	}
+	if s := r.Strategy; s != nil {
+		fmt.Fprintf(w, "\nstrategy  %d orders, %d cancelled, %d fills, %d open\n", s.Orders, s.Cancels, s.Fills, len(s.OpenOrders))
+		for _, sym := range sortedKeys(s.Symbols) {
+			f, p := s.Symbols[sym], s.Positions[sym]
+			fmt.Fprintf(w, "  %-12s position %.6g @ %.2f  bought %.6g  sold %.6g  fills %d (%d maker)  pnl %.2f\n",
+				sym, p.Position.Quantity, p.Position.AvgPrice, f.Bought, f.Sold, f.Fills, f.MakerFills, p.PnL)
+		}
+		fmt.Fprintf(w, "  pnl %.2f (realized %.2f, unrealized %.2f at %s)  max drawdown %.2f\n", s.PnL, s.RealizedPnL, s.UnrealizedPnL, s.Mark, s.MaxDrawdown)
+		fmt.Fprintf(w, "  turnover %.2f  exposure %.2f gross, %.2f net\n", s.Turnover, s.GrossExposure, s.NetExposure)
+	}
+}
+
+func newBacktestCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var input string
+	var asJSON, paper bool
+	cmd := &cobra.Command{
+		Use:   "backtest",
+		Short: "Evaluate signals and alert rules over a capture file and report the results",
+		Args:  cobra.NoArgs,
+		Long: "Evaluates signals and alert rules over a capture file as fast as it can be processed.\n--symbol defaults to every symbol in the capture.\n" +
+			"With --strategy the named strategy trades against the books as the capture replays, e.g. --strategy mm.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := symbolsFromCapture(cmd, &pipeline, input); err != nil {
+				return err
+			}
+			var strategy Strategy
+			if pipeline.Strategy != "" {
+				var err error
+				if strategy, err = NewStrategy(pipeline.Strategy); err != nil {
+					return err
+				}
+			}
+			report, err := RunStrategyBacktest(cmd.Context(), pipeline, input, strategy, pipeline.strategyOptions(paper))
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(report)
+			}
+			report.Print(cmd.OutOrStdout())
+			return nil
+		},
+	}
+	pipeline.register(cmd.Flags())
+	cmd.Flags().StringVar(&input, "input", "", "capture file of raw aggTrade messages, one per line, as written by record, or compacted by compact")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	cmd.Flags().BoolVar(&paper, "paper", false, "keep the strategy's orders out of the books and fill them against the feed, as when paper trading (needed for mirrored books)")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
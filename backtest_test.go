@@ -0,0 +1,45 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunBacktest(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt", "ethusdt"}, 500)
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(rules, []byte(`[{"name":"any_trade","expr":"last_price > 0"}]`), 0o644)
+
+	report, err := RunBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: 2, FeedQueue: 64, RulesFile: rules}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Messages != 500 {
+		t.Errorf("messages = %d, want 500", report.Messages)
+	}
+	fired := report.Alerts["any_trade"]
+	if fired["btcusdt"] == 0 || fired["ethusdt"] == 0 {
+		t.Errorf("alerts = %v, want some for both symbols", report.Alerts)
+	}
+	if b := report.Books["btcusdt"]; b.LastTradePrice == 0 || report.Signals["btcusdt"]["vwap"] != b.VWAP {
+		t.Errorf("btcusdt book %+v, signals %v", b, report.Signals["btcusdt"])
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	if !strings.Contains(out.String(), "any_trade") || !strings.Contains(out.String(), "ethusdt") {
+		t.Errorf("report:\n%s", out.String())
+	}
+	if _, err := json.Marshal(report); err != nil {
+		t.Errorf("report does not marshal: %v", err)
+	}
+
+	if _, err := RunBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16}, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing capture accepted")
+	}
+}
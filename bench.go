@@ -0,0 +1,267 @@
+package apexlob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/feed"
+
+	"github.com/spf13/cobra"
+)
+
+// SyntheticFeed generates Binance aggTrade messages: a random walk in cent
+// ticks per symbol with a mix of aggressive buys and sells. Messages are
+// stamped with the current time so end-to-end latency stays meaningful.
+type SyntheticFeed struct {
+	rng     *rand.Rand
+	symbols [][]byte // upper case, as Binance sends them
+	prices  []float64
+	nextID  uint64
+}
+
+func NewSyntheticFeed(symbols []string, seed int64) *SyntheticFeed {
+	f := &SyntheticFeed{rng: rand.New(rand.NewSource(seed))}
+	for _, sym := range symbols {
+		f.symbols = append(f.symbols, []byte(strings.ToUpper(sym)))
+		f.prices = append(f.prices, 30000)
+	}
+	return f
+}
+
+// Next appends the next message to dst.
+func (f *SyntheticFeed) Next(dst []byte) []byte {
+	i := f.rng.Intn(len(f.symbols))
+	price := math.Round((f.prices[i]+float64(f.rng.Intn(5)-2)*0.01)*100) / 100
+	if price < 0.01 {
+		price = 0.01
+	}
+	f.prices[i] = price
+	f.nextID++
+	now := time.Now().UnixMilli()
+
+	dst = append(dst, `{"e":"aggTrade","E":`...)
+	dst = strconv.AppendInt(dst, now, 10)
+	dst = append(dst, `,"s":"`...)
+	dst = append(dst, f.symbols[i]...)
+	dst = append(dst, `","a":`...)
+	dst = strconv.AppendUint(dst, f.nextID, 10)
+	dst = append(dst, `,"p":"`...)
+	dst = strconv.AppendFloat(dst, price, 'f', 8, 64)
+	dst = append(dst, `","q":"`...)
+	dst = strconv.AppendFloat(dst, float64(1+f.rng.Intn(5000))/1e5, 'f', 8, 64)
+	dst = append(dst, `","f":`...)
+	dst = strconv.AppendUint(dst, f.nextID, 10)
+	dst = append(dst, `,"l":`...)
+	dst = strconv.AppendUint(dst, f.nextID, 10)
+	dst = append(dst, `,"T":`...)
+	dst = strconv.AppendInt(dst, now, 10)
+	dst = append(dst, `,"m":`...)
+	dst = strconv.AppendBool(dst, f.rng.Intn(2) == 0)
+	return append(dst, `,"M":true}`...)
+}
+
+type BenchConfig struct {
+	Symbols []string
+	// Input is a capture of raw feed messages, one per line; the synthetic
+	// feed is used when it is empty
+	Input string
+	// Messages is the number of synthetic messages, or the most replayed
+	// from Input (0 for all of it)
+	Messages int
+	Shards   int
+	Queue    int
+	Seed     int64
+	Workers  WorkerTuning
+}
+
+type BenchResult struct {
+	Messages     int
+	Dropped      int // malformed or for an unexpected symbol
+	Elapsed      time.Duration
+	MsgsPerSec   float64
+	AllocsPerMsg float64
+	BytesPerMsg  float64
+	AllocRate    float64 // bytes per second
+	GCCycles     uint32
+	Processing   LatencyPercentiles
+	EndToEnd     LatencyPercentiles // synthetic feed only
+}
+
+// RunBench pushes messages through the same parse, shard, match, signal,
+// publish and rules path as the live feed, as fast as the workers take
+// them. A capture is loaded into memory first so disk reads are not timed.
+func RunBench(cfg BenchConfig) (BenchResult, error) {
+	var capture [][]byte
+	if cfg.Input != "" {
+		var symbols []string
+		var err error
+		if capture, symbols, err = loadCapture(cfg.Input, cfg.Messages); err != nil {
+			return BenchResult{}, err
+		}
+		if len(cfg.Symbols) == 0 {
+			cfg.Symbols = symbols
+		}
+	}
+	if len(cfg.Symbols) == 0 {
+		return BenchResult{}, errors.New("no symbols to benchmark")
+	}
+
+	reg := NewMetricsRegistry()
+	bus := NewEventBus()
+	shards := NewShardSet(cfg.Symbols, cfg.Shards, cfg.Queue)
+	shards.Tune(cfg.Workers)
+	rules := make([]*RuleEngine, shards.Workers())
+	for i := range rules {
+		rules[i] = NewRuleEngine()
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	stats := NewTimingStats(start, shards.Workers())
+	pipelines := make(map[string]*symbolPipeline, len(cfg.Symbols))
+	for _, sym := range cfg.Symbols {
+		state := NewSymbolState(sym)
+		pipelines[state.Symbol] = newSymbolPipeline(state, bus,
+			NewMonitorMetrics(reg, sym, state.Book, state.Signals),
+			NewPipelineTracer(nil, reg, sym), rules[shards.Shard(sym)], stats)
+	}
+	done := shards.Run(func(shard int, batch []feed.Msg) {
+		dispatchBatch(pipelines, shard, batch)
+	})
+
+	var res BenchResult
+	var trade feed.AggTrade
+	ingest := func(msg []byte) {
+		received := time.Now()
+		stats.MessageReceived(received)
+		if ingestAggTrade(shards, msg, &trade, received, nil, nil) != nil {
+			res.Dropped++
+		}
+	}
+	if capture != nil {
+		for _, msg := range capture {
+			ingest(msg)
+		}
+	} else {
+		synthetic := NewSyntheticFeed(cfg.Symbols, cfg.Seed)
+		var buf []byte
+		for i := 0; i < cfg.Messages; i++ {
+			buf = synthetic.Next(buf[:0])
+			ingest(buf)
+		}
+	}
+	shards.Close()
+	<-done
+	res.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	snap := stats.Snapshot()
+	res.Messages = snap.TotalMessages
+	res.Processing = snap.Processing
+	if capture == nil {
+		res.EndToEnd = snap.EndToEnd
+	}
+	res.GCCycles = after.NumGC - before.NumGC
+	if secs := res.Elapsed.Seconds(); secs > 0 {
+		res.MsgsPerSec = float64(res.Messages) / secs
+		res.AllocRate = float64(after.TotalAlloc-before.TotalAlloc) / secs
+	}
+	if res.Messages > 0 {
+		res.AllocsPerMsg = float64(after.Mallocs-before.Mallocs) / float64(res.Messages)
+		res.BytesPerMsg = float64(after.TotalAlloc-before.TotalAlloc) / float64(res.Messages)
+	}
+	return res, nil
+}
+
+// loadCapture reads up to limit non-empty lines and the symbols they name.
+func loadCapture(path string, limit int) ([][]byte, []string, error) {
+	r, err := OpenCapture(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+
+	var lines [][]byte
+	var symbols []string
+	seen := make(map[string]bool)
+	var trade feed.AggTrade
+	for limit <= 0 || len(lines) < limit {
+		line, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		lines = append(lines, append([]byte(nil), line...))
+		if feed.ParseAggTrade(line, &trade) == nil && len(trade.Symbol) > 0 {
+			if sym := strings.ToLower(string(trade.Symbol)); !seen[sym] {
+				seen[sym] = true
+				symbols = append(symbols, sym)
+			}
+		}
+	}
+	return lines, symbols, nil
+}
+
+func (r BenchResult) Print(w io.Writer) {
+	fmt.Fprintf(w, "messages        %d (%d dropped)\n", r.Messages, r.Dropped)
+	fmt.Fprintf(w, "elapsed         %v\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput      %.0f msgs/sec\n", r.MsgsPerSec)
+	fmt.Fprintf(w, "allocations     %.2f allocs/msg, %.0f B/msg, %.1f MB/s, %d GC cycles\n",
+		r.AllocsPerMsg, r.BytesPerMsg, r.AllocRate/1e6, r.GCCycles)
+	printLatency := func(name string, p LatencyPercentiles) {
+		fmt.Fprintf(w, "%-15s p50 %.3fms  p90 %.3fms  p99 %.3fms  p99.9 %.3fms\n", name, p.P50, p.P90, p.P99, p.P999)
+	}
+	printLatency("processing", r.Processing)
+	if r.EndToEnd != (LatencyPercentiles{}) {
+		printLatency("end-to-end", r.EndToEnd)
+	}
+}
+
+func newBenchCommand() *cobra.Command {
+	var cfg BenchConfig
+	var symbols string
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run the synthetic feed or a capture through the full pipeline as fast as possible",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&symbols, "symbol", "btcusdt", "comma-separated symbols for the synthetic feed (default for --input: the symbols in the capture)")
+	fs.StringVar(&cfg.Input, "input", "", "capture file of raw aggTrade messages, one per line, or compacted by compact (synthetic feed when empty)")
+	fs.IntVar(&cfg.Messages, "messages", 1000000, "synthetic messages to generate, or the most to replay from --input (0 for all)")
+	fs.IntVar(&cfg.Shards, "shards", runtime.NumCPU(), "worker goroutines that symbols are hashed onto")
+	fs.IntVar(&cfg.Queue, "feed-queue", 4096, "messages buffered per shard worker")
+	fs.Int64Var(&cfg.Seed, "seed", 1, "random seed of the synthetic feed")
+	tuning := registerTuningFlags(fs)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		runtimeTuning, workerTuning, err := tuning.parse()
+		if err != nil {
+			return err
+		}
+		if err := runtimeTuning.Apply(); err != nil {
+			return err
+		}
+		cfg.Workers = workerTuning
+		if cfg.Input == "" || fs.Changed("symbol") {
+			cfg.Symbols = splitList(strings.ToLower(symbols))
+		}
+		res, err := RunBench(cfg)
+		if err != nil {
+			return err
+		}
+		res.Print(cmd.OutOrStdout())
+		return nil
+	}
+	return cmd
+}
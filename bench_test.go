@@ -0,0 +1,84 @@
+package apexlob
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"apexlob/pkg/feed"
+)
+
+func TestSyntheticFeedParses(t *testing.T) {
+	synthetic := NewSyntheticFeed([]string{"btcusdt", "ethusdt"}, 1)
+	var buf []byte
+	var trade feed.AggTrade
+	seen := map[string]bool{}
+	for i := 1; i <= 100; i++ {
+		buf = synthetic.Next(buf[:0])
+		if err := feed.ParseAggTrade(buf, &trade); err != nil {
+			t.Fatalf("message %d %s: %v", i, buf, err)
+		}
+		if trade.TradeID != uint64(i) {
+			t.Errorf("trade ID %d, want %d", trade.TradeID, i)
+		}
+		if p, err := feed.ParseDecimal(trade.Price); err != nil || p <= 0 {
+			t.Errorf("price %q: %v", trade.Price, err)
+		}
+		seen[string(trade.Symbol)] = true
+	}
+	if !seen["BTCUSDT"] || !seen["ETHUSDT"] {
+		t.Errorf("symbols seen: %v", seen)
+	}
+}
+
+func TestRunBenchSynthetic(t *testing.T) {
+	res, err := RunBench(BenchConfig{Symbols: []string{"btcusdt", "ethusdt"}, Messages: 5000, Shards: 2, Queue: 64, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Messages != 5000 || res.Dropped != 0 {
+		t.Errorf("processed %d, dropped %d; want 5000, 0", res.Messages, res.Dropped)
+	}
+	if res.MsgsPerSec <= 0 || res.Processing.P50 <= 0 || res.EndToEnd.P99 <= 0 {
+		t.Errorf("result %+v", res)
+	}
+
+	var out bytes.Buffer
+	res.Print(&out)
+	for _, want := range []string{"msgs/sec", "allocs/msg", "p99.9", "end-to-end"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunBenchCapture(t *testing.T) {
+	synthetic := NewSyntheticFeed([]string{"solusdt"}, 2)
+	var capture []byte
+	for i := 0; i < 50; i++ {
+		capture = append(synthetic.Next(capture), '\n')
+	}
+	capture = append(capture, "\nnot json\n"...)
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, capture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Symbols come from the capture when none are given
+	res, err := RunBench(BenchConfig{Input: path, Shards: 1, Queue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Messages != 50 || res.Dropped != 1 {
+		t.Errorf("processed %d, dropped %d; want 50, 1", res.Messages, res.Dropped)
+	}
+	if res.EndToEnd != (LatencyPercentiles{}) {
+		t.Errorf("end-to-end latency reported for a capture: %+v", res.EndToEnd)
+	}
+
+	if res, err = RunBench(BenchConfig{Input: path, Messages: 10, Shards: 1, Queue: 16}); err != nil || res.Messages != 10 {
+		t.Errorf("limited replay processed %d messages, err %v", res.Messages, err)
+	}
+}
@@ -0,0 +1,72 @@
+package apexlob
+
+import (
+	"fmt"
+	"strings"
+
+	"apexlob/pkg/orderbook"
+)
+
+// BookMode says what a symbol's book represents.
+//
+// A synthetic book is built out of the trade stream alone. Every aggTrade
+// becomes a limit order on its aggressor's side at the trade price, which
+// matches against earlier trades on the other side and rests if nothing is
+// left to match. The result is a model driven by trade flow: its levels
+// are where recent buying and selling happened, not orders anyone has
+// placed, and its trades and VWAP are those of the model's own fills.
+//
+// A mirrored book follows the exchange's own depth: a REST snapshot kept up
+// to date by the diff depth stream, one resting quantity per price level.
+// Trades do not change its levels (the depth stream already does), but are
+// recorded in its trade totals as they happened on the exchange.
+type BookMode string
+
+const (
+	BookSynthetic BookMode = "synthetic"
+	BookMirrored  BookMode = "mirrored"
+)
+
+// ParseBookModes parses a spec such as "synthetic" or
+// "mirrored,ethusdt=synthetic" into every symbol's mode, synthetic unless
+// the spec says otherwise.
+func ParseBookModes(spec string, symbols []string) (map[string]BookMode, error) {
+	fallback := BookSynthetic
+	overrides := make(map[string]BookMode)
+	for _, part := range splitList(spec) {
+		name, value, scoped := strings.Cut(part, "=")
+		if !scoped {
+			value = name
+		}
+		mode := BookMode(strings.TrimSpace(value))
+		if mode != BookSynthetic && mode != BookMirrored {
+			return nil, fmt.Errorf("invalid book mode %q: want synthetic or mirrored", value)
+		}
+		if scoped {
+			overrides[strings.ToLower(strings.TrimSpace(name))] = mode
+		} else {
+			fallback = mode
+		}
+	}
+	modes := make(map[string]BookMode, len(symbols))
+	for _, sym := range symbols {
+		modes[sym] = fallback
+		if mode, ok := overrides[sym]; ok {
+			modes[sym] = mode
+			delete(overrides, sym)
+		}
+	}
+	if len(overrides) > 0 {
+		return nil, fmt.Errorf("book mode given for %s, which is not streamed", strings.Join(sortedKeys(overrides), ", "))
+	}
+	return modes, nil
+}
+
+// aggressorSide is the side that crossed the spread in a trade: with the
+// buyer's order resting, the seller took it, and the other way round.
+func aggressorSide(buyerMaker bool) orderbook.Side {
+	if buyerMaker {
+		return orderbook.Sell
+	}
+	return orderbook.Buy
+}
@@ -0,0 +1,42 @@
+package apexlob
+
+import (
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestParseBookModes(t *testing.T) {
+	symbols := []string{"btcusdt", "ethusdt"}
+	for _, tc := range []struct {
+		spec     string
+		btc, eth BookMode
+	}{
+		{"", BookSynthetic, BookSynthetic},
+		{"synthetic", BookSynthetic, BookSynthetic},
+		{"mirrored", BookMirrored, BookMirrored},
+		{"mirrored,ethusdt=synthetic", BookMirrored, BookSynthetic},
+		{"BTCUSDT=mirrored", BookMirrored, BookSynthetic},
+	} {
+		modes, err := ParseBookModes(tc.spec, symbols)
+		if err != nil {
+			t.Errorf("ParseBookModes(%q): %v", tc.spec, err)
+			continue
+		}
+		if modes["btcusdt"] != tc.btc || modes["ethusdt"] != tc.eth {
+			t.Errorf("ParseBookModes(%q) = %v", tc.spec, modes)
+		}
+	}
+	for _, spec := range []string{"mirror", "btcusdt=", "solusdt=mirrored"} {
+		if _, err := ParseBookModes(spec, symbols); err == nil {
+			t.Errorf("ParseBookModes(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestAggressorSide(t *testing.T) {
+	// isBuyerMaker means the seller crossed the spread
+	if aggressorSide(true) != orderbook.Sell || aggressorSide(false) != orderbook.Buy {
+		t.Error("aggressor side reversed")
+	}
+}
@@ -0,0 +1,352 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+
+	"github.com/spf13/cobra"
+)
+
+// A book recording is a compacted capture (see CompactCapture) holding the
+// trades of every symbol as aggTrade messages and, at most once per
+// interval of event time, a bookSnapshot message of each symbol's top
+// levels:
+//
+//	{"e":"bookSnapshot","E":<ms>,"s":"BTCUSDT","b":[[price,volume,orders],...],"a":[...]}
+//
+// bids and asks best first. The book at any time is the last snapshot
+// before it, which BookAt finds by decompressing only the blocks around
+// it, rather than by replaying every depth update since the session began.
+// Replaying a recording feeds its trades and skips the snapshots.
+const bookSnapshotEvent = "bookSnapshot"
+
+// BookRecorder is a sink writing book recordings to <dir>/books-<time>.apexc,
+// one file per RotateInterval. A file is written as .tmp and renamed once
+// its index is written, so only complete recordings carry the extension.
+type BookRecorder struct {
+	cfg      BookRecorderConfig
+	last     map[string]time.Time // bucket of each symbol's last snapshot
+	trade    feed.Msg
+	line     []byte
+	path     string // of the file being written
+	file     *os.File
+	bw       *bufio.Writer
+	cw       *compactWriter
+	started  time.Time
+	now      func() time.Time
+	complete func(path string) // nil unless OnComplete was called
+}
+
+type BookRecorderConfig struct {
+	Dir            string
+	Interval       time.Duration // of event time between a symbol's snapshots
+	Depth          int           // levels per side
+	RotateInterval time.Duration
+	BlockLines     int // see CompactCapture
+}
+
+func NewBookRecorder(cfg BookRecorderConfig) (*BookRecorder, error) {
+	if cfg.Depth <= 0 {
+		return nil, fmt.Errorf("invalid book snapshot depth %d", cfg.Depth)
+	}
+	if cfg.Depth > publishedBookDepth {
+		return nil, fmt.Errorf("book snapshot depth %d is more than the %d levels book events carry", cfg.Depth, publishedBookDepth)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("invalid book snapshot interval %v", cfg.Interval)
+	}
+	if cfg.BlockLines <= 0 {
+		cfg.BlockLines = defaultCompactBlockLines
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &BookRecorder{cfg: cfg, last: make(map[string]time.Time), now: time.Now}, nil
+}
+
+func (r *BookRecorder) Name() string { return "books" }
+
+func (r *BookRecorder) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		r.trade = feed.Msg{Symbol: tr.Symbol, TradeID: tr.ID, Price: tr.Price, Quantity: tr.Quantity,
+			BuyerMaker: tr.Side == orderbook.Sell, EventMs: tr.Timestamp.UnixMilli()}
+		r.line = appendAggTradeLine(r.line[:0], &r.trade)
+		return r.add(r.line, r.trade.EventMs)
+	case EventBook:
+		// Buckets are aligned to the interval, so two recordings of one
+		// feed sample it at the same times
+		bucket := e.Timestamp.Truncate(r.cfg.Interval)
+		if last, ok := r.last[e.Symbol]; ok && !bucket.After(last) {
+			return nil
+		}
+		r.last[e.Symbol] = bucket
+		r.line = appendBookSnapshotLine(r.line[:0], e.Symbol, e.Timestamp, e.Book, r.cfg.Depth)
+		return r.add(r.line, e.Timestamp.UnixMilli())
+	}
+	return nil
+}
+
+func appendBookSnapshotLine(dst []byte, symbol string, at time.Time, book *BookSnapshot, depth int) []byte {
+	dst = append(dst, `{"e":"`+bookSnapshotEvent+`","E":`...)
+	dst = strconv.AppendInt(dst, at.UnixMilli(), 10)
+	dst = append(dst, `,"s":"`...)
+	dst = append(dst, strings.ToUpper(symbol)...)
+	dst = append(dst, '"')
+	for _, side := range []struct {
+		key    string
+		levels []orderbook.PriceLevel
+	}{{`,"b":[`, book.Bids}, {`,"a":[`, book.Asks}} {
+		dst = append(dst, side.key...)
+		for i, lvl := range side.levels[:min(depth, len(side.levels))] {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, '[')
+			dst = strconv.AppendFloat(dst, lvl.Price, 'f', -1, 64)
+			dst = append(dst, ',')
+			dst = strconv.AppendUint(dst, uint64(lvl.Volume), 10)
+			dst = append(dst, ',')
+			dst = strconv.AppendUint(dst, uint64(lvl.Orders), 10)
+			dst = append(dst, ']')
+		}
+		dst = append(dst, ']')
+	}
+	return append(dst, '}')
+}
+
+func (r *BookRecorder) add(line []byte, eventMs int64) error {
+	if r.cw == nil {
+		r.started = r.now()
+		path := rotatedPath(r.cfg.Dir, "books", ".apexc", r.started)
+		f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		r.path, r.file = path, f
+		r.bw = bufio.NewWriterSize(f, 256*1024)
+		r.cw = newCompactWriter(r.bw, r.cfg.BlockLines)
+	}
+	return r.cw.add(line, eventMs)
+}
+
+// finish writes the index of the file being written and moves it into
+// place.
+func (r *BookRecorder) finish() error {
+	if r.cw == nil {
+		return nil
+	}
+	err := r.cw.Close()
+	if ferr := r.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if serr := r.file.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(r.path+".tmp", r.path)
+	}
+	r.cw, r.bw, r.file = nil, nil, nil
+	if err == nil && r.complete != nil {
+		r.complete(r.path)
+	}
+	return err
+}
+
+// OnComplete calls fn with each recording the recorder has finished.
+func (r *BookRecorder) OnComplete(fn func(path string)) { r.complete = fn }
+
+// Flush completes the file being written once it is RotateInterval old.
+// Blocks are written as they fill; until a file is complete it has no
+// index and cannot be read.
+func (r *BookRecorder) Flush() error {
+	if r.cw == nil || r.cfg.RotateInterval <= 0 || r.now().Sub(r.started) < r.cfg.RotateInterval {
+		return nil
+	}
+	return r.finish()
+}
+
+func (r *BookRecorder) Close() error { return r.finish() }
+
+// bookSnapshotLine is the form of a bookSnapshot message.
+type bookSnapshotLine struct {
+	Type   string       `json:"e"`
+	Time   int64        `json:"E"`
+	Symbol string       `json:"s"`
+	Bids   [][3]float64 `json:"b"`
+	Asks   [][3]float64 `json:"a"`
+}
+
+func isBookSnapshotEvent(msg []byte) bool {
+	typ, _ := feed.EventType(msg)
+	return string(typ) == bookSnapshotEvent
+}
+
+// RecordedBook is a book as a recording last sampled it.
+type RecordedBook struct {
+	Symbol string                 `json:"symbol"`
+	Time   time.Time              `json:"time"`
+	Bids   []orderbook.PriceLevel `json:"bids"`
+	Asks   []orderbook.PriceLevel `json:"asks"`
+}
+
+// BookAt returns symbol's book as of at from the book recordings at paths:
+// the last snapshot taken at or before it. It reports false if there is
+// none.
+func BookAt(paths []string, symbol string, at time.Time) (RecordedBook, bool, error) {
+	type recording struct {
+		path  string
+		first int64
+	}
+	var recordings []recording
+	for _, path := range paths {
+		r, err := OpenCapture(path)
+		if err != nil {
+			return RecordedBook{}, false, err
+		}
+		index := r.Index()
+		r.Close()
+		if index == nil {
+			return RecordedBook{}, false, fmt.Errorf("%s is not a book recording", path)
+		}
+		if index.FirstMs > 0 && index.FirstMs <= at.UnixMilli() {
+			recordings = append(recordings, recording{path, index.FirstMs})
+		}
+	}
+	// Newest first: a quiet book's last snapshot may be in an earlier file
+	slices.SortFunc(recordings, func(a, b recording) int { return cmp.Compare(b.first, a.first) })
+	for _, rec := range recordings {
+		book, ok, err := bookAtIn(rec.path, symbol, at)
+		if err != nil || ok {
+			return book, ok, err
+		}
+	}
+	return RecordedBook{}, false, nil
+}
+
+// bookAtIn searches one recording, from the block holding at backwards.
+func bookAtIn(path, symbol string, at time.Time) (RecordedBook, bool, error) {
+	r, err := OpenCapture(path)
+	if err != nil {
+		return RecordedBook{}, false, err
+	}
+	defer r.Close()
+	atMs := at.UnixMilli()
+	blocks := r.index.Blocks
+	start := -1
+	for i, b := range blocks {
+		if b.MinMs > 0 && b.MinMs <= atMs {
+			start = i
+		}
+	}
+	var snap bookSnapshotLine
+	for i := start; i >= 0; i-- {
+		if err := r.readBlock(i); err != nil {
+			return RecordedBook{}, false, err
+		}
+		r.next = len(blocks) // read no further than this block
+		var found []byte
+		for len(r.rest) > 0 {
+			if err := r.read(); err != nil {
+				return RecordedBook{}, false, err
+			}
+			if r.eventMs > atMs {
+				continue
+			}
+			if bytes.HasPrefix(r.line, []byte(`{"e":"`+bookSnapshotEvent+`"`)) &&
+				json.Unmarshal(r.line, &snap) == nil && strings.EqualFold(snap.Symbol, symbol) {
+				found = append(found[:0], r.line...)
+			}
+		}
+		if found != nil {
+			json.Unmarshal(found, &snap)
+			book := RecordedBook{Symbol: strings.ToLower(snap.Symbol), Time: time.UnixMilli(snap.Time).UTC(),
+				Bids: recordedLevels(snap.Bids), Asks: recordedLevels(snap.Asks)}
+			return book, true, nil
+		}
+	}
+	return RecordedBook{}, false, nil
+}
+
+func recordedLevels(levels [][3]float64) []orderbook.PriceLevel {
+	out := make([]orderbook.PriceLevel, len(levels))
+	for i, l := range levels {
+		out[i] = orderbook.PriceLevel{Price: l[0], Volume: uint32(l[1]), Orders: int(l[2])}
+	}
+	return out
+}
+
+func newBookAtCommand() *cobra.Command {
+	var inputs []string
+	var symbol, at string
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "book-at",
+		Short: "Print a symbol's book at a point in time from book recordings",
+		Long: "Finds the last snapshot of a symbol's book taken at or before --at in recordings written\n" +
+			"with --book-snapshot-dir, decompressing only the blocks around that time.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := time.Parse(time.RFC3339Nano, at)
+			if err != nil {
+				return fmt.Errorf("invalid --at: %w", err)
+			}
+			book, ok, err := BookAt(inputs, symbol, t)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("no snapshot of %s at or before %s", symbol, t.UTC().Format(time.RFC3339Nano))
+			}
+			return printRecordedBook(cmd.OutOrStdout(), book, asJSON)
+		},
+	}
+	cmd.Flags().StringSliceVar(&inputs, "input", nil, "comma-separated book recordings")
+	cmd.Flags().StringVar(&symbol, "symbol", "", "symbol whose book to print")
+	cmd.Flags().StringVar(&at, "at", "", "time of the book, RFC 3339")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the book as JSON")
+	cmd.MarkFlagRequired("input")
+	cmd.MarkFlagRequired("symbol")
+	cmd.MarkFlagRequired("at")
+	return cmd
+}
+
+func printRecordedBook(w io.Writer, book RecordedBook, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(book)
+	}
+	fmt.Fprintf(w, "%s at %s\n", book.Symbol, book.Time.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "%14s %10s %6s   %-14s %10s %6s\n", "bid", "volume", "orders", "ask", "volume", "orders")
+	for i := 0; i < max(len(book.Bids), len(book.Asks)); i++ {
+		if i < len(book.Bids) {
+			b := book.Bids[i]
+			fmt.Fprintf(w, "%14s %10d %6d", strconv.FormatFloat(b.Price, 'f', -1, 64), b.Volume, b.Orders)
+		} else {
+			fmt.Fprintf(w, "%14s %10s %6s", "", "", "")
+		}
+		if i < len(book.Asks) {
+			a := book.Asks[i]
+			fmt.Fprintf(w, "   %-14s %10d %6d", strconv.FormatFloat(a.Price, 'f', -1, 64), a.Volume, a.Orders)
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestBookRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewBookRecorder(BookRecorderConfig{Dir: dir, Interval: 100 * time.Millisecond, Depth: 2, RotateInterval: time.Hour, BlockLines: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return clock }
+	var completed []string
+	r.OnComplete(func(path string) { completed = append(completed, path) })
+
+	base := time.UnixMilli(1714572000000)
+	book := func(ms int, bid float64) {
+		t.Helper()
+		snap := BookSnapshot{
+			Bids: []orderbook.PriceLevel{{Price: bid, Volume: 5, Orders: 2}, {Price: bid - 1, Volume: 7, Orders: 1}, {Price: bid - 2, Volume: 1, Orders: 1}},
+			Asks: []orderbook.PriceLevel{{Price: bid + 1, Volume: 3, Orders: 1}},
+		}
+		if err := r.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: base.Add(time.Duration(ms) * time.Millisecond), Book: &snap}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trade := func(ms int, id uint64) {
+		t.Helper()
+		tr := orderbook.Trade{Symbol: "btcusdt", ID: id, Price: 100, Quantity: 0.5, Side: orderbook.Sell, Timestamp: base.Add(time.Duration(ms) * time.Millisecond)}
+		if err := r.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: tr.Timestamp, Trade: &tr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		trade(i*50, uint64(i+1))
+		book(i*50, float64(100+i)) // two book events to each 100ms bucket
+	}
+	// The first file is completed once it is an hour old, and the book then
+	// goes quiet for a while
+	clock = clock.Add(time.Hour)
+	if err := r.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	trade(5000, 11)
+	book(5000, 120)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(completed) != 2 {
+		t.Fatalf("completed %v", completed)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("left behind %v", tmp)
+	}
+
+	for _, c := range []struct {
+		ms   int
+		want float64 // best bid, 0 for none
+	}{
+		{-1, 0},
+		{0, 100},
+		{99, 100},  // the second event of the bucket was not sampled
+		{120, 102}, // the last snapshot before it
+		{4000, 108},
+		{6000, 120},
+	} {
+		at := base.Add(time.Duration(c.ms) * time.Millisecond)
+		got, ok, err := BookAt(completed, "BTCUSDT", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.want == 0 {
+			if ok {
+				t.Errorf("a book at %dms: %+v", c.ms, got)
+			}
+			continue
+		}
+		if !ok || got.Bids[0].Price != c.want || len(got.Bids) != 2 || got.Time.After(at) || got.Symbol != "btcusdt" {
+			t.Errorf("book at %dms: %+v (%v), want best bid %v", c.ms, got, ok, c.want)
+		}
+	}
+	if _, ok, _ := BookAt(completed, "ethusdt", base.Add(time.Second)); ok {
+		t.Error("found a book of a symbol never recorded")
+	}
+
+	// A recording replays as the trades it holds
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, completed[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n, errs := m.Stats.Snapshot().TotalMessages, m.Snapshot().Errors; n != 10 || errs.ParseErrors != 0 {
+		t.Errorf("replayed %d messages with %+v errors, want the 10 trades", n, errs)
+	}
+
+	var out bytes.Buffer
+	book0, _, _ := BookAt(completed, "btcusdt", base)
+	printRecordedBook(&out, book0, false)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 4 || !strings.Contains(lines[2], "100") {
+		t.Errorf("printed\n%s", out.String())
+	}
+
+	if _, err := NewBookRecorder(BookRecorderConfig{Dir: dir, Interval: time.Second, Depth: 50}); err == nil {
+		t.Error("accepted more levels than book events carry")
+	}
+	raw := filepath.Join(dir, "raw.jsonl")
+	os.WriteFile(raw, []byte("{}\n"), 0o644)
+	if _, _, err := BookAt(append(completed, raw), "btcusdt", base); err == nil {
+		t.Error("searched a raw capture")
+	}
+}
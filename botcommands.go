@@ -0,0 +1,144 @@
+package apexlob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBotLevels caps the levels !book shows, to keep replies short enough
+// for a chat message.
+const maxBotLevels = 20
+
+// BotCommands answers chat commands about the live symbols:
+//
+//	!price btcusdt       last trade, best bid and ask, session high and low
+//	!vwap ethusdt [5m]   session VWAP, or over a window back from the newest trade
+//	!book btcusdt [10]   the top levels of the book
+//	!help                the commands
+type BotCommands struct {
+	symbols *SymbolRegistry
+}
+
+func NewBotCommands(symbols *SymbolRegistry) *BotCommands {
+	return &BotCommands{symbols: symbols}
+}
+
+// Answer returns the reply to text, or false if it is not a command.
+func (c *BotCommands) Answer(text string) (string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return "", false
+	}
+	cmd, args := strings.ToLower(fields[0][1:]), fields[1:]
+	switch cmd {
+	case "help":
+		return "Commands: !price <symbol>, !vwap <symbol> [window, e.g. 5m], !book <symbol> [levels]", true
+	case "price", "vwap", "book":
+	default:
+		return "", false
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("usage: !%s <symbol>", cmd), true
+	}
+	state, ok := c.symbols.Get(args[0])
+	if !ok {
+		return fmt.Sprintf("unknown symbol %q; streaming %s", args[0], strings.Join(c.symbols.List(), ", ")), true
+	}
+	switch cmd {
+	case "price":
+		return c.price(state), true
+	case "vwap":
+		return c.vwap(state, args[1:]), true
+	default:
+		return c.book(state, args[1:]), true
+	}
+}
+
+func (c *BotCommands) price(state *SymbolState) string {
+	totals := state.Book.TradeTotals()
+	if totals.LastPrice == 0 {
+		return fmt.Sprintf("%s: no trades yet", strings.ToUpper(state.Symbol))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s last %s", strings.ToUpper(state.Symbol), botNumber(totals.LastPrice))
+	bid, _, okBid := state.Book.GetBestBid()
+	ask, _, okAsk := state.Book.GetBestAsk()
+	if okBid && okAsk {
+		fmt.Fprintf(&b, ", bid %s ask %s (%.2f bps)", botNumber(bid), botNumber(ask), (ask-bid)/((ask+bid)/2)*1e4)
+	}
+	if r, ok := state.Range.Snapshot(); ok {
+		fmt.Fprintf(&b, ", session high %s low %s", botNumber(r.High), botNumber(r.Low))
+	}
+	return b.String()
+}
+
+// vwap answers from the book's session totals, or from the tape for a
+// window; a tape too short to cover the window says how far it reaches.
+func (c *BotCommands) vwap(state *SymbolState, args []string) string {
+	sym := strings.ToUpper(state.Symbol)
+	if len(args) == 0 {
+		totals := state.Book.TradeTotals()
+		if totals.Volume == 0 {
+			return sym + ": no trades yet"
+		}
+		return fmt.Sprintf("%s session VWAP %s, volume %d", sym, botNumber(totals.VWAP()), totals.Volume)
+	}
+	window, err := time.ParseDuration(args[0])
+	if err != nil || window <= 0 {
+		return fmt.Sprintf("invalid window %q, e.g. 5m", args[0])
+	}
+	trades := state.Tape.Recent(0)
+	if len(trades) == 0 {
+		return sym + ": no trades yet"
+	}
+	from := trades[0].Timestamp.Add(-window)
+	var volume, notional float64
+	n := 0
+	for _, tr := range trades {
+		if !tr.Timestamp.After(from) {
+			break
+		}
+		volume += tr.Quantity
+		notional += tr.Price * tr.Quantity
+		n++
+	}
+	reply := fmt.Sprintf("%s %s VWAP %s over %d trades, volume %s", sym, shortDuration(window), botNumber(notional/volume), n, botNumber(volume))
+	if n == len(trades) && n == state.Tape.Cap() {
+		covered := trades[0].Timestamp.Sub(trades[n-1].Timestamp)
+		reply += fmt.Sprintf(" (the tape only reaches back %s)", shortDuration(covered.Round(time.Second)))
+	}
+	return reply
+}
+
+func (c *BotCommands) book(state *SymbolState, args []string) string {
+	levels := 5
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Sprintf("invalid level count %q", args[0])
+		}
+		levels = min(n, maxBotLevels)
+	}
+	bids, asks := state.Book.Depth(levels)
+	if len(bids) == 0 && len(asks) == 0 {
+		return strings.ToUpper(state.Symbol) + ": the book is empty"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s book, %s\n```\n", strings.ToUpper(state.Symbol), state.Mode)
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "ask %14s %10d\n", botNumber(asks[i].Price), asks[i].Volume)
+	}
+	for _, l := range bids {
+		fmt.Fprintf(&b, "bid %14s %10d\n", botNumber(l.Price), l.Volume)
+	}
+	b.WriteString("```")
+	return b.String()
+}
+
+// botNumber formats v to eight significant digits without an exponent.
+func botNumber(v float64) string {
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 8, 64), 64)
+	return strconv.FormatFloat(r, 'f', -1, 64)
+}
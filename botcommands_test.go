@@ -0,0 +1,52 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestBotCommands(t *testing.T) {
+	state := NewSymbolStateWithLimits("btcusdt", SymbolLimits{Book: DefaultSymbolLimits.Book, TapeSize: 4})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 100, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 98, Quantity: 50, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 3, Price: 101, Quantity: 100, Side: orderbook.Sell})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 4, Price: 99, Quantity: 10, Side: orderbook.Sell})
+	start := time.Unix(1700000000, 0)
+	for i, tr := range []struct {
+		price, qty float64
+		ago        time.Duration
+	}{{100, 1, 10 * time.Minute}, {102, 1, 4 * time.Minute}, {104, 3, time.Minute}, {101, 1, 0}} {
+		trade := orderbook.Trade{Symbol: "btcusdt", ID: uint64(i), Price: tr.price, Quantity: tr.qty, Timestamp: start.Add(-tr.ago)}
+		state.Tape.Add(trade)
+		state.Range.Add(trade.Price, trade.Timestamp)
+	}
+	symbols := NewSymbolRegistry()
+	symbols.Add(state)
+	c := NewBotCommands(symbols)
+
+	for _, tc := range []struct{ text, want string }{
+		{"!price BTCUSDT", "BTCUSDT last 99, bid 99 ask 101 (200.00 bps), session high 104 low 100"},
+		{"!vwap btcusdt", "BTCUSDT session VWAP 99, volume 10"},
+		{"!vwap btcusdt 5m", "BTCUSDT 5m VWAP 103 over 3 trades, volume 5"},
+		{"!vwap btcusdt 1h", "BTCUSDT 1h VWAP 102.5 over 4 trades, volume 6 (the tape only reaches back 10m)"},
+		{"!vwap btcusdt soon", `invalid window "soon", e.g. 5m`},
+		{"!book btcusdt 1", "BTCUSDT book, synthetic\n```\nask            101        100\nbid             99         90\n```"},
+		{"!price", "usage: !price <symbol>"},
+		{"!price ethusdt", `unknown symbol "ethusdt"; streaming btcusdt`},
+	} {
+		if got, ok := c.Answer(tc.text); !ok || got != tc.want {
+			t.Errorf("%s = %q, %v\nwant %q", tc.text, got, ok, tc.want)
+		}
+	}
+	if got, _ := c.Answer("!book btcusdt 500"); strings.Count(got, "\n") != 5 {
+		t.Errorf("every level:\n%s", got)
+	}
+	for _, text := range []string{"", "hello", "!unknown btcusdt"} {
+		if _, ok := c.Answer(text); ok {
+			t.Errorf("%q answered", text)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package apexlob
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// BroadcastServer streams bus events to WebSocket clients as JSON. Clients
+// filter with query parameters, e.g. /ws?symbols=btcusdt&types=trade,book,
+// and may ask for encoding=sbe to receive binary frames of one SBE message
+// each (see sbe.go) instead.
+type BroadcastServer struct {
+	symbols  *SymbolRegistry
+	bus      *EventBus
+	upgrader websocket.Upgrader
+}
+
+func NewBroadcastServer(symbols *SymbolRegistry, bus *EventBus) *BroadcastServer {
+	return &BroadcastServer{
+		symbols: symbols,
+		bus:     bus,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 4096,
+		},
+	}
+}
+
+func (bs *BroadcastServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var symbols []string
+	for _, sym := range splitList(r.URL.Query().Get("symbols")) {
+		state, ok := bs.symbols.Get(sym)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown symbol "+sym)
+			return
+		}
+		symbols = append(symbols, state.Symbol)
+	}
+	var types []EventType
+	for _, t := range splitList(r.URL.Query().Get("types")) {
+		switch et := EventType(t); et {
+		case EventTrade, EventBook, EventCandle, EventSignal, EventOrder:
+			types = append(types, et)
+		default:
+			writeError(w, http.StatusBadRequest, "unknown event type "+t)
+			return
+		}
+	}
+
+	sbe := false
+	switch enc := r.URL.Query().Get("encoding"); enc {
+	case "", "json":
+	case "sbe":
+		sbe = true
+	default:
+		writeError(w, http.StatusBadRequest, "unknown encoding "+enc)
+		return
+	}
+
+	conn, err := bs.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an HTTP error
+	}
+	defer conn.Close()
+
+	events, cancel := bs.bus.SubscribeAs("ws:"+r.RemoteAddr, 1024, symbols, types)
+	defer cancel()
+
+	// Drain client frames so close and ping messages are processed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	var buf []byte
+	for {
+		select {
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if sbe {
+				if e.Type == EventOrder {
+					e.Release()
+					continue // order reports have no SBE template
+				}
+				if buf, err = AppendSBE(buf[:0], &e); err == nil {
+					err = conn.WriteMessage(websocket.BinaryMessage, buf)
+				}
+			} else {
+				err = conn.WriteJSON(e)
+			}
+			e.Release()
+			if err != nil {
+				logger("broadcast").Warn("dropping WebSocket client", "remote", r.RemoteAddr, "err", err)
+				return
+			}
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package apexlob
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBroadcastServerStreamsFilteredEvents(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bus := NewEventBus()
+	srv := httptest.NewServer(NewBroadcastServer(symbols, bus))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?symbols=BTCUSDT&types=trade"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for !bus.Wants(EventTrade) {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(Event{Type: EventCandle, Symbol: "btcusdt", Candle: &Candle{}})
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{ID: 5, Price: 100, Side: orderbook.Sell}})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got Event
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != EventTrade || got.Trade == nil || got.Trade.ID != 5 {
+		t.Errorf("received %+v, want trade 5", got)
+	}
+}
+
+func TestBroadcastServerEndsStreamOnCancel(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bus := NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewUnstartedServer(NewBroadcastServer(symbols, bus))
+	srv.Config.BaseContext = func(net.Listener) context.Context { return ctx }
+	srv.Start()
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for !bus.Wants(EventTrade) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil || strings.Contains(err.Error(), "timeout") {
+		t.Errorf("read after cancel = %v, want the server to close the stream", err)
+	}
+}
+
+func TestBroadcastServerSBEEncoding(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bus := NewEventBus()
+	srv := httptest.NewServer(NewBroadcastServer(symbols, bus))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?types=trade&encoding=sbe"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for !bus.Wants(EventTrade) {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{ID: 5, Price: 100, Side: orderbook.Sell}})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	typ, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := DecodeSBE(msg)
+	if typ != websocket.BinaryMessage || err != nil || got.Trade.ID != 5 || got.Trade.Side != orderbook.Sell {
+		t.Errorf("received frame type %d, event %+v, err %v", typ, got.Trade, err)
+	}
+}
+
+func TestBroadcastServerRejectsBadFilters(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bs := NewBroadcastServer(symbols, NewEventBus())
+
+	for path, want := range map[string]int{
+		"/ws?symbols=ethusdt": http.StatusNotFound,
+		"/ws?types=quotes":    http.StatusBadRequest,
+		"/ws?encoding=xml":    http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		bs.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestWebUIHandlerServesAssets(t *testing.T) {
+	for path, want := range map[string]string{
+		"/":       "<canvas id=\"price\"",
+		"/app.js": "new WebSocket",
+	} {
+		rec := httptest.NewRecorder()
+		WebUIHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET %s = %d, body missing %q", path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package apexlob
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+type Candle struct {
+	Symbol   string        `json:"symbol"`
+	OpenTime time.Time     `json:"open_time"`
+	Interval time.Duration `json:"interval"`
+	Open     float64       `json:"open"`
+	High     float64       `json:"high"`
+	Low      float64       `json:"low"`
+	Close    float64       `json:"close"`
+	Volume   float64       `json:"volume"`
+	Trades   int           `json:"trades"`
+}
+
+// candleHistory is how many closed bars a CandleBuilder keeps.
+const candleHistory = 1000
+
+// CandleBuilder aggregates trades into fixed-interval OHLCV bars aligned to
+// the interval boundary (e.g. whole minutes). It keeps the last closed bars
+// and the indicators computed from their closes.
+type CandleBuilder struct {
+	mu         sync.RWMutex
+	symbol     string
+	interval   time.Duration
+	current    *Candle
+	history    []Candle // closed, oldest first
+	indicators candleIndicators
+}
+
+func NewCandleBuilder(symbol string, interval time.Duration) *CandleBuilder {
+	return &CandleBuilder{symbol: symbol, interval: interval}
+}
+
+// Interval is the length of the builder's bars.
+func (cb *CandleBuilder) Interval() time.Duration { return cb.interval }
+
+// Add folds the trade into the current bar. When the trade belongs to a later
+// bucket, the finished bar is returned and a new one is started.
+func (cb *CandleBuilder) Add(tr *orderbook.Trade) *Candle {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	openTime := tr.Timestamp.Truncate(cb.interval)
+	var closed *Candle
+	if cb.current != nil && openTime.After(cb.current.OpenTime) {
+		closed = cb.current
+		cb.current = nil
+		cb.closeBar(*closed)
+	}
+	if cb.current == nil {
+		cb.current = &Candle{
+			Symbol:   cb.symbol,
+			OpenTime: openTime,
+			Interval: cb.interval,
+			Open:     tr.Price,
+			High:     tr.Price,
+			Low:      tr.Price,
+		}
+	}
+
+	c := cb.current
+	if tr.Price > c.High {
+		c.High = tr.Price
+	}
+	if tr.Price < c.Low {
+		c.Low = tr.Price
+	}
+	c.Close = tr.Price
+	c.Volume += tr.Quantity
+	c.Trades++
+	return closed
+}
+
+func (cb *CandleBuilder) closeBar(c Candle) {
+	if len(cb.history) == candleHistory {
+		cb.history = append(cb.history[:0], cb.history[1:]...)
+	}
+	cb.history = append(cb.history, c)
+	cb.indicators.update(c.Close)
+}
+
+// Seed adds closed bars from before the builder started, e.g. backfilled
+// from a store or the exchange, so the history and indicators start warm.
+// Bars must be in order; those not after the last closed bar and before
+// the bar in progress, or of another symbol or interval, are skipped. It
+// returns how many were added.
+func (cb *CandleBuilder) Seed(candles []Candle) int {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	n := 0
+	for _, c := range candles {
+		if c.Symbol != cb.symbol || c.Interval != cb.interval {
+			continue
+		}
+		if len(cb.history) > 0 && !c.OpenTime.After(cb.history[len(cb.history)-1].OpenTime) {
+			continue
+		}
+		if cb.current != nil && !c.OpenTime.Before(cb.current.OpenTime) {
+			continue
+		}
+		cb.closeBar(c)
+		n++
+	}
+	return n
+}
+
+// History returns a copy of the closed bars, oldest first.
+func (cb *CandleBuilder) History() []Candle {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return append([]Candle(nil), cb.history...)
+}
+
+// Current returns a copy of the bar in progress.
+func (cb *CandleBuilder) Current() (Candle, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	if cb.current == nil {
+		return Candle{}, false
+	}
+	return *cb.current, true
+}
+
+// Restore resumes c as the bar in progress if nothing has been added since
+// and c has this builder's symbol and interval. It reports whether it did.
+func (cb *CandleBuilder) Restore(c Candle) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.current != nil || c.Symbol != cb.symbol || c.Interval != cb.interval {
+		return false
+	}
+	cb.current = &c
+	return true
+}
+
+// Periods of the indicators computed from closed bars.
+const (
+	candleEMAPeriod = 20
+	candleRSIPeriod = 14
+)
+
+// candleIndicators follow an EMA and Wilder's RSI of bar closes. Both need
+// many bars to settle, which is what backfilling is for.
+type candleIndicators struct {
+	bars      int
+	ema       float64
+	lastClose float64
+	avgGain   float64
+	avgLoss   float64
+}
+
+func (ci *candleIndicators) update(close float64) {
+	ci.bars++
+	if ci.bars == 1 {
+		ci.ema, ci.lastClose = close, close
+		return
+	}
+	alpha := 2.0 / (candleEMAPeriod + 1)
+	ci.ema += alpha * (close - ci.ema)
+
+	gain, loss := 0.0, 0.0
+	if change := close - ci.lastClose; change > 0 {
+		gain = change
+	} else {
+		loss = -change
+	}
+	ci.lastClose = close
+	// The first averages are simple means of the first period's changes,
+	// then Wilder's smoothing
+	changes := ci.bars - 1
+	n := float64(min(changes, candleRSIPeriod))
+	ci.avgGain += (gain - ci.avgGain) / n
+	ci.avgLoss += (loss - ci.avgLoss) / n
+}
+
+// EMA returns the EMA of closes once a period of bars has closed.
+func (cb *CandleBuilder) EMA() (float64, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return cb.indicators.ema, cb.indicators.bars >= candleEMAPeriod
+}
+
+// RSI returns the RSI of closes once a period of changes is known.
+func (cb *CandleBuilder) RSI() (float64, bool) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	ci := &cb.indicators
+	if ci.bars <= candleRSIPeriod {
+		return 0, false
+	}
+	if ci.avgLoss == 0 {
+		return 100, true
+	}
+	return 100 - 100/(1+ci.avgGain/ci.avgLoss), true
+}
+
+// registerCandleSignals adds ema_20_1m and rsi_14_1m, the indicators of
+// candles' closed one-minute bars, unset until enough bars have closed.
+func registerCandleSignals(se *signals.Engine, candles *CandleBuilder) {
+	value := func(fn func() (float64, bool)) func(*signals.Input) float64 {
+		return func(*signals.Input) float64 {
+			if v, ok := fn(); ok {
+				return v
+			}
+			return math.NaN()
+		}
+	}
+	se.Register(signals.Func(fmt.Sprintf("ema_%d_1m", candleEMAPeriod), value(candles.EMA)))
+	se.Register(signals.Func(fmt.Sprintf("rsi_%d_1m", candleRSIPeriod), value(candles.RSI)))
+}
@@ -0,0 +1,103 @@
+package apexlob
+
+import (
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestCandleBuilder(t *testing.T) {
+	cb := NewCandleBuilder("btcusdt", time.Minute)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok := cb.Current(); ok {
+		t.Error("Current() before any trade should report no candle")
+	}
+
+	trades := []orderbook.Trade{
+		{Price: 100, Quantity: 1, Timestamp: base.Add(5 * time.Second)},
+		{Price: 103, Quantity: 2, Timestamp: base.Add(20 * time.Second)},
+		{Price: 99, Quantity: 1, Timestamp: base.Add(40 * time.Second)},
+		{Price: 101, Quantity: 0.5, Timestamp: base.Add(59 * time.Second)},
+	}
+	for i := range trades {
+		if closed := cb.Add(&trades[i]); closed != nil {
+			t.Fatalf("trade %d closed a candle unexpectedly", i)
+		}
+	}
+
+	closed := cb.Add(&orderbook.Trade{Price: 102, Quantity: 1, Timestamp: base.Add(61 * time.Second)})
+	if closed == nil {
+		t.Fatal("trade in the next minute should close the candle")
+	}
+	want := Candle{Symbol: "btcusdt", OpenTime: base, Interval: time.Minute, Open: 100, High: 103, Low: 99, Close: 101, Volume: 4.5, Trades: 4}
+	if *closed != want {
+		t.Errorf("closed candle = %+v, want %+v", *closed, want)
+	}
+
+	current, ok := cb.Current()
+	if !ok || current.Open != 102 || !current.OpenTime.Equal(base.Add(time.Minute)) || current.Trades != 1 {
+		t.Errorf("current candle = %+v", current)
+	}
+}
+
+func TestCandleBuilderRestore(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	saved := Candle{Symbol: "btcusdt", OpenTime: base, Interval: time.Minute, Open: 100, High: 105, Low: 99, Close: 101, Volume: 3, Trades: 4}
+
+	if NewCandleBuilder("ethusdt", time.Minute).Restore(saved) {
+		t.Error("restored another symbol's candle")
+	}
+	cb := NewCandleBuilder("btcusdt", time.Minute)
+	if !cb.Restore(saved) {
+		t.Fatal("candle not restored")
+	}
+	if cb.Restore(saved) {
+		t.Error("restored over a candle in progress")
+	}
+	cb.Add(&orderbook.Trade{Price: 106, Quantity: 1, Timestamp: base.Add(30 * time.Second)})
+	c, _ := cb.Current()
+	if c.Open != 100 || c.High != 106 || c.Volume != 4 || c.Trades != 5 {
+		t.Errorf("candle after resuming = %+v", c)
+	}
+}
+
+func TestCandleBuilderSeedWarmsIndicators(t *testing.T) {
+	cb := NewCandleBuilder("btcusdt", time.Minute)
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var bars []Candle
+	for i := 0; i < 30; i++ {
+		bars = append(bars, Candle{Symbol: "btcusdt", Interval: time.Minute, OpenTime: base.Add(time.Duration(i) * time.Minute), Close: 100 + float64(i)})
+	}
+	if _, ok := cb.EMA(); ok {
+		t.Error("EMA set before any bar closed")
+	}
+
+	// A trade in the last seeded minute's successor starts the live bar
+	cb.Add(&orderbook.Trade{Price: 130, Quantity: 1, Timestamp: base.Add(29*time.Minute + time.Second)})
+	other := Candle{Symbol: "ethusdt", Interval: time.Minute, OpenTime: base.Add(-time.Minute)}
+	if n := cb.Seed(append([]Candle{other}, bars...)); n != 29 {
+		t.Errorf("seeded %d bars, want the 29 before the bar in progress", n)
+	}
+	if n := cb.Seed(bars[:5]); n != 0 {
+		t.Errorf("seeded %d bars already in the history", n)
+	}
+	if h := cb.History(); len(h) != 29 || h[28].Close != 128 {
+		t.Errorf("history has %d bars ending %+v", len(h), h[len(h)-1])
+	}
+
+	// Closes only ever rose: RSI is 100 and the EMA lags the last close
+	if rsi, ok := cb.RSI(); !ok || rsi != 100 {
+		t.Errorf("RSI = %v, %v; want 100", rsi, ok)
+	}
+	if ema, ok := cb.EMA(); !ok || ema >= 128 || ema <= 118 {
+		t.Errorf("EMA = %v, %v; want between the last 10 closes", ema, ok)
+	}
+
+	cb.Add(&orderbook.Trade{Price: 120, Quantity: 1, Timestamp: base.Add(30 * time.Minute)})
+	cb.Add(&orderbook.Trade{Price: 120, Quantity: 1, Timestamp: base.Add(31 * time.Minute)})
+	if rsi, _ := cb.RSI(); rsi >= 100 {
+		t.Errorf("RSI = %v after a falling bar closed, want below 100", rsi)
+	}
+}
@@ -0,0 +1,541 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/feed"
+
+	"github.com/spf13/cobra"
+)
+
+// A compacted capture holds the lines of capture files in DEFLATE-compressed
+// blocks, followed by an index of each block's event times, so a replay can
+// start at any time by decompressing only from the block that holds it:
+//
+//	compactMagic | block ... | index (JSON CaptureIndex) | index offset (uint64) | compactIndexMagic
+//
+// little-endian. A block decompresses to a sequence of records, each the
+// line's event time in ms (varint, 0 if it has none) and the line's length
+// (uvarint) followed by the line.
+const (
+	compactMagic      = "APEXCMP1"
+	compactIndexMagic = "APEXIDX1"
+)
+
+// defaultCompactBlockLines is how many lines a block holds unless told
+// otherwise: a seek decompresses at most this many lines it then skips.
+const defaultCompactBlockLines = 4096
+
+// CaptureIndex is the table of contents of a compacted capture.
+type CaptureIndex struct {
+	Symbols []string       `json:"symbols"` // of the aggTrade lines, as CaptureSymbols lists them
+	Lines   int            `json:"lines"`
+	FirstMs int64          `json:"first_ms"` // earliest event time, 0 if no line has one
+	LastMs  int64          `json:"last_ms"`
+	Blocks  []CaptureBlock `json:"blocks"`
+}
+
+type CaptureBlock struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"` // compressed
+	CRC    uint32 `json:"crc"`  // CRC-32 of the compressed bytes
+	Lines  int    `json:"lines"`
+	MinMs  int64  `json:"min_ms"` // 0 if no line has an event time
+	MaxMs  int64  `json:"max_ms"`
+}
+
+// CompactResult summarizes a CompactCapture.
+type CompactResult struct {
+	Index    CaptureIndex
+	InBytes  int64
+	OutBytes int64
+	// Skipped counts write-ahead log events that are not trades: depth
+	// levels are logged after normalization and cannot be turned back
+	// into the messages they came from
+	Skipped int
+}
+
+// CompactCapture writes the lines of the captures at srcs, in that order, to
+// a compacted capture at dst, blockLines to a block (defaultCompactBlockLines
+// if 0). A source may be a capture written by record, a compacted capture
+// or a write-ahead log, whose trades become aggTrade lines. dst is replaced
+// only once it is complete.
+func CompactCapture(dst string, srcs []string, blockLines int) (CompactResult, error) {
+	if blockLines <= 0 {
+		blockLines = defaultCompactBlockLines
+	}
+	var result CompactResult
+	tmp := dst + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(tmp) // a no-op once renamed
+	w := newCompactWriter(f, blockLines)
+	for _, src := range srcs {
+		if err := compactSource(w, src, &result); err != nil {
+			f.Close()
+			return result, fmt.Errorf("%s: %w", src, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return result, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return result, err
+	}
+	if err := f.Close(); err != nil {
+		return result, err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return result, err
+	}
+	result.Index, result.OutBytes = w.index, w.offset
+	return result, nil
+}
+
+func compactSource(w *compactWriter, src string, result *CompactResult) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	result.InBytes += info.Size()
+	if isWAL(src) {
+		return compactWAL(w, src, result)
+	}
+	r, err := OpenCapture(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		line, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.add(line, r.EventMs()); err != nil {
+			return err
+		}
+	}
+}
+
+func isWAL(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(walMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == walMagic
+}
+
+// compactWAL adds a write-ahead log's trades as aggTrade lines. A log that
+// ends part-way through a record, as after a crash, is compacted up to it.
+func compactWAL(w *compactWriter, src string, result *CompactResult) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := NewWALReader(f)
+	if err != nil {
+		return err
+	}
+	interned := make(map[string]string)
+	var msg feed.Msg
+	var line []byte
+	for {
+		typ, payload, err := r.Next()
+		if errors.Is(err, errWALTruncated) {
+			logger("compact").Warn("write-ahead log ends in a partial record", "path", src)
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if typ != walEvent {
+			continue
+		}
+		if err := decodeWALEvent(payload, &msg, interned); err != nil {
+			return err
+		}
+		if msg.Kind != feed.KindTrade {
+			result.Skipped++
+			continue
+		}
+		line = appendAggTradeLine(line[:0], &msg)
+		if err := w.add(line, msg.EventMs); err != nil {
+			return err
+		}
+	}
+}
+
+// appendAggTradeLine encodes a trade as the aggTrade message it was parsed
+// from, less the fields the pipeline does not read.
+func appendAggTradeLine(dst []byte, m *feed.Msg) []byte {
+	dst = append(dst, `{"e":"aggTrade","E":`...)
+	dst = strconv.AppendInt(dst, m.EventMs, 10)
+	dst = append(dst, `,"s":"`...)
+	dst = append(dst, strings.ToUpper(m.Symbol)...)
+	dst = append(dst, `","a":`...)
+	dst = strconv.AppendUint(dst, m.TradeID, 10)
+	dst = append(dst, `,"p":"`...)
+	dst = strconv.AppendFloat(dst, m.Price, 'f', -1, 64)
+	dst = append(dst, `","q":"`...)
+	dst = strconv.AppendFloat(dst, m.Quantity, 'f', -1, 64)
+	dst = append(dst, `","T":`...)
+	dst = strconv.AppendInt(dst, m.EventMs, 10)
+	dst = append(dst, `,"m":`...)
+	dst = strconv.AppendBool(dst, m.BuyerMaker)
+	return append(dst, '}')
+}
+
+// compactWriter writes a compacted capture.
+type compactWriter struct {
+	w          io.Writer
+	offset     int64
+	blockLines int
+	index      CaptureIndex
+	seen       map[string]bool
+	trade      feed.AggTrade
+
+	records bytes.Buffer // of the block being filled
+	block   CaptureBlock
+	zbuf    bytes.Buffer
+	zw      *flate.Writer
+	err     error
+}
+
+func newCompactWriter(w io.Writer, blockLines int) *compactWriter {
+	cw := &compactWriter{w: w, blockLines: blockLines, seen: make(map[string]bool)}
+	cw.zw, _ = flate.NewWriter(&cw.zbuf, flate.DefaultCompression)
+	cw.write([]byte(compactMagic))
+	return cw
+}
+
+func (w *compactWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	w.err = err
+}
+
+func (w *compactWriter) add(line []byte, eventMs int64) error {
+	var head [2 * binary.MaxVarintLen64]byte
+	n := binary.PutVarint(head[:], eventMs)
+	n += binary.PutUvarint(head[n:], uint64(len(line)))
+	w.records.Write(head[:n])
+	w.records.Write(line)
+	w.block.Lines++
+	w.index.Lines++
+	if eventMs > 0 {
+		if w.block.MinMs == 0 || eventMs < w.block.MinMs {
+			w.block.MinMs = eventMs
+		}
+		w.block.MaxMs = max(w.block.MaxMs, eventMs)
+	}
+	if feed.ParseAggTrade(line, &w.trade) == nil && len(w.trade.Symbol) > 0 {
+		if sym := strings.ToLower(string(w.trade.Symbol)); !w.seen[sym] {
+			w.seen[sym] = true
+			w.index.Symbols = append(w.index.Symbols, sym)
+		}
+	}
+	if w.block.Lines >= w.blockLines {
+		w.flush()
+	}
+	return w.err
+}
+
+// flush compresses and writes the block being filled.
+func (w *compactWriter) flush() {
+	if w.block.Lines == 0 || w.err != nil {
+		return
+	}
+	w.zbuf.Reset()
+	w.zw.Reset(&w.zbuf)
+	w.zw.Write(w.records.Bytes())
+	if w.err = w.zw.Close(); w.err != nil {
+		return
+	}
+	w.block.Offset, w.block.Size = w.offset, int64(w.zbuf.Len())
+	w.block.CRC = crc32.ChecksumIEEE(w.zbuf.Bytes())
+	w.write(w.zbuf.Bytes())
+	if w.block.MinMs > 0 {
+		if w.index.FirstMs == 0 || w.block.MinMs < w.index.FirstMs {
+			w.index.FirstMs = w.block.MinMs
+		}
+		w.index.LastMs = max(w.index.LastMs, w.block.MaxMs)
+	}
+	w.index.Blocks = append(w.index.Blocks, w.block)
+	w.block = CaptureBlock{}
+	w.records.Reset()
+}
+
+// Close writes the last block and the index.
+func (w *compactWriter) Close() error {
+	w.flush()
+	if w.index.Symbols == nil {
+		w.index.Symbols = []string{}
+	}
+	index, err := json.Marshal(w.index)
+	if err != nil {
+		return err
+	}
+	at := w.offset
+	w.write(index)
+	w.write(binary.LittleEndian.AppendUint64(nil, uint64(at)))
+	w.write([]byte(compactIndexMagic))
+	return w.err
+}
+
+// CaptureReader reads the lines of a capture file, as written by record or
+// compacted by CompactCapture.
+type CaptureReader struct {
+	f     *os.File
+	sc    *bufio.Scanner // of a raw capture
+	index *CaptureIndex  // of a compacted one
+	next  int            // block to read when records run out
+	data  []byte         // the current block, decompressed
+	rest  []byte         // its records not yet read
+	from  int64          // lines before this event time are skipped
+
+	line    []byte
+	eventMs int64
+	timed   bool // eventMs is known for line
+	trade   feed.AggTrade
+	depth   feed.DepthUpdate
+}
+
+// OpenCapture opens the capture file at path for reading from its first
+// line.
+func OpenCapture(path string) (*CaptureReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &CaptureReader{f: f}
+	magic := make([]byte, len(compactMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != compactMagic {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.sc = bufio.NewScanner(f)
+		r.sc.Buffer(make([]byte, 64*1024), 1<<20)
+		return r, nil
+	}
+	if r.index, err = readCaptureIndex(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+func readCaptureIndex(f *os.File) (*CaptureIndex, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, 8+len(compactIndexMagic))
+	end := info.Size() - int64(len(trailer))
+	if end < int64(len(compactMagic)) {
+		return nil, errors.New("compacted capture has no index")
+	}
+	if _, err := f.ReadAt(trailer, end); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != compactIndexMagic {
+		return nil, errors.New("compacted capture has no index, it may have been cut short")
+	}
+	at := int64(binary.LittleEndian.Uint64(trailer))
+	if at < int64(len(compactMagic)) || at > end {
+		return nil, fmt.Errorf("compacted capture index at %d is out of range", at)
+	}
+	raw := make([]byte, end-at)
+	if _, err := f.ReadAt(raw, at); err != nil {
+		return nil, err
+	}
+	var index CaptureIndex
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("bad compacted capture index: %w", err)
+	}
+	return &index, nil
+}
+
+// Index returns the index of a compacted capture, or nil for a raw one.
+func (r *CaptureReader) Index() *CaptureIndex { return r.index }
+
+// Seek makes the reader skip to the first line whose event time is at or
+// after t; the lines before it are skipped whether they have an event time
+// or not. A compacted capture is read from the first block holding such a
+// line, a raw one from the start. It must be called before Next.
+func (r *CaptureReader) Seek(t time.Time) {
+	r.from = t.UnixMilli()
+	if r.index == nil {
+		return
+	}
+	r.next = len(r.index.Blocks)
+	for i, b := range r.index.Blocks {
+		if b.MaxMs >= r.from {
+			r.next = i
+			break
+		}
+	}
+}
+
+// Next returns the next non-empty line, trimmed, which is only valid until
+// the following call. It returns io.EOF at the end of the capture.
+func (r *CaptureReader) Next() ([]byte, error) {
+	for {
+		if err := r.read(); err != nil {
+			return nil, err
+		}
+		if r.from == 0 {
+			return r.line, nil
+		}
+		if r.EventMs() >= r.from {
+			r.from = 0
+			return r.line, nil
+		}
+	}
+}
+
+func (r *CaptureReader) read() error {
+	r.timed = false
+	if r.sc != nil {
+		for r.sc.Scan() {
+			if r.line = bytes.TrimSpace(r.sc.Bytes()); len(r.line) > 0 {
+				return nil
+			}
+		}
+		if err := r.sc.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for len(r.rest) == 0 {
+		if r.next >= len(r.index.Blocks) {
+			return io.EOF
+		}
+		if err := r.readBlock(r.next); err != nil {
+			return err
+		}
+		r.next++
+	}
+	eventMs, n := binary.Varint(r.rest)
+	size, m := uint64(0), 0
+	if n > 0 {
+		size, m = binary.Uvarint(r.rest[n:])
+	}
+	if n <= 0 || m <= 0 || size > uint64(len(r.rest)-n-m) {
+		return fmt.Errorf("corrupt record in block %d of the compacted capture", r.next-1)
+	}
+	r.rest = r.rest[n+m:]
+	r.line, r.rest = r.rest[:size], r.rest[size:]
+	r.eventMs, r.timed = eventMs, true
+	return nil
+}
+
+func (r *CaptureReader) readBlock(i int) error {
+	b := r.index.Blocks[i]
+	compressed := make([]byte, b.Size)
+	if _, err := r.f.ReadAt(compressed, b.Offset); err != nil {
+		return fmt.Errorf("block %d of the compacted capture: %w", i, err)
+	}
+	if crc32.ChecksumIEEE(compressed) != b.CRC {
+		return fmt.Errorf("block %d of the compacted capture fails its checksum", i)
+	}
+	zr := flate.NewReader(bytes.NewReader(compressed))
+	defer zr.Close()
+	buf := bytes.NewBuffer(r.data[:0])
+	if _, err := buf.ReadFrom(zr); err != nil {
+		return fmt.Errorf("block %d of the compacted capture: %w", i, err)
+	}
+	r.data = buf.Bytes()
+	r.rest = r.data
+	return nil
+}
+
+// EventMs is the exchange event time of the line Next last returned: of a
+// trade or depth update, or 0 for anything else.
+func (r *CaptureReader) EventMs() int64 {
+	if !r.timed {
+		r.eventMs, r.timed = captureEventMs(r.line, &r.trade, &r.depth), true
+	}
+	return r.eventMs
+}
+
+func (r *CaptureReader) Close() error { return r.f.Close() }
+
+func newCompactCommand() *cobra.Command {
+	var inputs []string
+	var output string
+	var blockLines int
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Compress capture files into one indexed by time, for replays that start mid-session",
+		Long: "Writes the lines of capture files, in the order given, to one compressed file with an\n" +
+			"index of event times, so replay --from seeks straight to the block holding that time\n" +
+			"instead of scanning the capture. Inputs may be captures written by record, compacted\n" +
+			"captures or write-ahead logs, whose trades are written as aggTrade messages; their\n" +
+			"depth events are skipped. replay, backtest and bench read compacted captures as they\n" +
+			"do raw ones.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := CompactCapture(output, inputs, blockLines)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(result.Index)
+			}
+			ratio := 0.0
+			if result.OutBytes > 0 {
+				ratio = float64(result.InBytes) / float64(result.OutBytes)
+			}
+			fmt.Fprintf(out, "compacted %d lines of %s into %d blocks: %d bytes to %d (%.1fx)\n",
+				result.Index.Lines, strings.Join(result.Index.Symbols, ", "), len(result.Index.Blocks), result.InBytes, result.OutBytes, ratio)
+			if result.Index.FirstMs > 0 {
+				fmt.Fprintf(out, "events from %s to %s\n",
+					time.UnixMilli(result.Index.FirstMs).UTC().Format(time.RFC3339Nano), time.UnixMilli(result.Index.LastMs).UTC().Format(time.RFC3339Nano))
+			}
+			if result.Skipped > 0 {
+				fmt.Fprintf(out, "skipped %d depth events of write-ahead logs\n", result.Skipped)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&inputs, "input", nil, "comma-separated capture files or write-ahead logs, in time order")
+	cmd.Flags().StringVarP(&output, "output", "o", "capture.apexc", "compacted capture to write, replaced if it exists")
+	cmd.Flags().IntVar(&blockLines, "block-lines", defaultCompactBlockLines, "lines per compressed block: smaller blocks seek faster and compress worse")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the index as JSON")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
@@ -0,0 +1,235 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+// writeTimedCapture writes n aggTrade lines of sym with trade IDs and event
+// times (in ms) counting up from first, and a blank line.
+func writeTimedCapture(t *testing.T, name, sym string, first, n int) string {
+	t.Helper()
+	var capture []byte
+	for i := first; i < first+n; i++ {
+		capture = fmt.Appendf(capture, `{"e":"aggTrade","E":%d,"s":"%s","a":%d,"p":"100.5","q":"0.25","T":%d,"m":true}`+"\n", i, sym, i, i)
+	}
+	capture = append(capture, '\n')
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, capture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readCapture returns the event times of every line a reader returns.
+func readCapture(t *testing.T, r *CaptureReader) []int64 {
+	t.Helper()
+	var times []int64
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			return times
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		times = append(times, r.EventMs())
+	}
+}
+
+func TestCompactCapture(t *testing.T) {
+	a := writeTimedCapture(t, "a.jsonl", "BTCUSDT", 1000, 50)
+	b := writeTimedCapture(t, "b.jsonl", "ETHUSDT", 1050, 50)
+	walPath := filepath.Join(t.TempDir(), "feed.wal")
+	wal, err := OpenWAL(walPath, WALHeader{Created: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1100; i < 1120; i++ {
+		wal.AppendEvent(&feed.Msg{Symbol: "solusdt", TradeID: uint64(i), Price: 20.125, Quantity: 3, EventMs: int64(i), Received: time.Now()})
+	}
+	wal.AppendEvent(&feed.Msg{Symbol: "solusdt", Kind: feed.KindLevel, Price: 20, Quantity: 1, EventMs: 1120, Last: true})
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "capture.apexc")
+	result, err := CompactCapture(out, []string{a, b, walPath}, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := result.Index
+	if index.Lines != 120 || len(index.Blocks) != 8 || index.FirstMs != 1000 || index.LastMs != 1119 || result.Skipped != 1 {
+		t.Errorf("index %+v, skipped %d", index, result.Skipped)
+	}
+	if !slices.Equal(index.Symbols, []string{"btcusdt", "ethusdt", "solusdt"}) {
+		t.Errorf("symbols %v", index.Symbols)
+	}
+	if result.OutBytes >= result.InBytes {
+		t.Errorf("compacted %d bytes to %d", result.InBytes, result.OutBytes)
+	}
+	if symbols, err := CaptureSymbols(out); err != nil || !slices.Equal(symbols, index.Symbols) {
+		t.Errorf("CaptureSymbols = %v, %v", symbols, err)
+	}
+
+	r, err := OpenCapture(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	times := readCapture(t, r)
+	r.Close()
+	if len(times) != 120 || times[0] != 1000 || times[119] != 1119 {
+		t.Fatalf("read %d lines, %v", len(times), times)
+	}
+	// The write-ahead log's trades read back as the messages they were
+	r, _ = OpenCapture(out)
+	r.Seek(time.UnixMilli(1100))
+	line, err := r.Next()
+	r.Close()
+	var trade feed.AggTrade
+	if err != nil || feed.ParseAggTrade(line, &trade) != nil || string(trade.Symbol) != "SOLUSDT" ||
+		trade.TradeID != 1100 || string(trade.Price) != "20.125" || string(trade.Quantity) != "3" || trade.BuyerMaker {
+		t.Errorf("WAL trade line %s (%v)", line, err)
+	}
+
+	// A seek lands mid-block in a compacted capture and a raw one alike
+	for _, path := range []string{out, a} {
+		r, err := OpenCapture(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Seek(time.UnixMilli(1037))
+		times := readCapture(t, r)
+		r.Close()
+		if len(times) == 0 || times[0] != 1037 {
+			t.Errorf("%s: seek to 1037 read from %v", filepath.Base(path), times)
+		}
+	}
+	r, _ = OpenCapture(out)
+	r.Seek(time.UnixMilli(5000))
+	if times := readCapture(t, r); len(times) != 0 {
+		t.Errorf("seek past the end read %v", times)
+	}
+	r.Close()
+
+	// A damaged block is reported, not replayed
+	data, _ := os.ReadFile(out)
+	data[index.Blocks[2].Offset+1] ^= 0xff
+	os.WriteFile(out, data, 0o644)
+	r, _ = OpenCapture(out)
+	for err == nil {
+		_, err = r.Next()
+	}
+	r.Close()
+	if err == io.EOF {
+		t.Error("read a damaged compacted capture to the end")
+	}
+	os.WriteFile(out, data[:len(data)-3], 0o644)
+	if _, err := OpenCapture(out); err == nil {
+		t.Error("opened a compacted capture without its index")
+	}
+}
+
+func TestReplayCompactedCaptureFrom(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "capture.apexc")
+	if _, err := CompactCapture(out, []string{writeTimedCapture(t, "a.jsonl", "BTCUSDT", 1000, 300)}, 0); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCaptureFrom(context.Background(), m, out, time.UnixMilli(1200), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 100 {
+		t.Errorf("processed %d messages from 1200, want 100", n)
+	}
+	if err := ReplayCapture(context.Background(), m, filepath.Join(t.TempDir(), "missing"), 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("replaying a missing capture: %v", err)
+	}
+}
+
+func TestWarmStart(t *testing.T) {
+	// A trade every 10s over the last five minutes, and one of a symbol
+	// that is not monitored
+	base := time.Now().Add(-5 * time.Minute).UnixMilli()
+	var capture []byte
+	for i := 0; i < 30; i++ {
+		ms := base + int64(i)*10000
+		capture = fmt.Appendf(capture, `{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"%d.5","q":"0.25","T":%d,"m":%v}`+"\n", ms, i+1, 100+i%3, ms, i%2 == 0)
+	}
+	capture = fmt.Appendf(capture, `{"e":"aggTrade","E":%d,"s":"SOLUSDT","a":1,"p":"20","q":"1","T":%[1]d,"m":true}`+"\n", base)
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	if err := os.WriteFile(path, capture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, Limits: DefaultSymbolLimits, quietAlerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	trades, cancel := m.Bus.Subscribe(64, nil, []EventType{EventTrade})
+	defer cancel()
+	done := m.Run()
+	result, err := m.WarmStart(context.Background(), path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Lines != 30 || result.Skipped != 1 || result.FirstMs != base || result.LastMs != base+290000 {
+		t.Errorf("warm start %+v", result)
+	}
+	// The live feed overlaps the history by three trades
+	now := time.Now()
+	for id := uint64(28); id <= 32; id++ {
+		m.Shards.Push([]byte("BTCUSDT"), &feed.Msg{TradeID: id, Price: 101, Quantity: 1, EventMs: now.UnixMilli(), Received: now, Parsed: now})
+	}
+	m.Shards.Close()
+	<-done
+
+	state, _ := m.Symbols.Get("btcusdt")
+	if n := m.Stats.Snapshot().TotalMessages; n != 2 || state.Messages() != 2 {
+		t.Errorf("processed %d live messages (%d for the symbol), want 2", n, state.Messages())
+	}
+	if n := state.Tape.Len(); n != 32 {
+		t.Errorf("tape holds %d trades, want 32", n)
+	}
+	// The history's event times span several one-minute bars
+	if bars := state.Candles.History(); len(bars) < 4 || bars[0].OpenTime.UnixMilli() > base {
+		t.Errorf("candles after warm start: %+v", bars)
+	}
+	if len(trades) != 2 {
+		t.Errorf("%d trades published, want only the live ones", len(trades))
+	}
+
+	// A window replays only the end of the history, of a compacted capture
+	// as of a raw one
+	compacted := filepath.Join(t.TempDir(), "history.apexc")
+	if _, err := CompactCapture(compacted, []string{path}, 8); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{path, compacted} {
+		m, _ := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, quietAlerts: true})
+		done := m.Run()
+		result, err := m.WarmStart(context.Background(), p, time.Minute)
+		m.Shards.Close()
+		<-done
+		m.Close()
+		if err != nil || result.Lines != 7 || result.FirstMs != base+230000 {
+			t.Errorf("%s: warm start over the last minute %+v, %v", filepath.Base(p), result, err)
+		}
+	}
+}
@@ -0,0 +1,779 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/spf13/cobra"
+)
+
+// NewRootCommand builds the apexlob command line.
+func NewRootCommand() *cobra.Command {
+	var logLevel, logFormat string
+	root := &cobra.Command{
+		Use:   "apexlob",
+		Short: "Limit order book and signal monitor for Binance trade feeds",
+		// Errors are printed by main; usage only for command-line mistakes
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			level, modules, err := ParseLogLevels(logLevel)
+			if err != nil {
+				return fmt.Errorf("invalid --log-level: %w", err)
+			}
+			if logFormat != "text" && logFormat != "json" {
+				return fmt.Errorf("invalid --log-format %q: want text or json", logFormat)
+			}
+			SetupLogging(LogConfig{Level: level, Modules: modules, JSON: logFormat == "json"})
+			return nil
+		},
+	}
+	root.PersistentFlags().StringVar(&logLevel, "log-level", "info", "log verbosity: a level, optionally followed by per-module overrides, e.g. warn,feed=debug,nats=error")
+	root.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log record format: text or json")
+	root.AddCommand(
+		newLiveCommand(),
+		newServeCommand(),
+		newExchangeCommand(),
+		newGatewayCommand(),
+		newReplayCommand(),
+		newBacktestCommand(),
+		newRegressCommand(),
+		newBenchCommand(),
+		newRecordCommand(),
+		newVerifyCommand(),
+		newCompactCommand(),
+		newHeatmapCommand(),
+		newBookAtCommand(),
+	)
+	return root
+}
+
+// displayOptions choose how a monitor shows progress on the terminal.
+type displayOptions struct {
+	TUI      bool
+	Refresh  time.Duration
+	Headless bool // logs only: no status line, banner or final report
+	NoColor  bool
+	// EventsPerMinute caps the notable events printed above the status
+	// line or shown in the dashboard, 0 for none
+	EventsPerMinute  float64
+	LargeTradeFactor float64
+}
+
+func (o *displayOptions) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&o.TUI, "tui", false, "full-screen dashboard instead of the status line (logs go to apexlob.log)")
+	cmd.Flags().DurationVar(&o.Refresh, "refresh", 250*time.Millisecond, "redraw interval of the status line and dashboard")
+	cmd.Flags().BoolVarP(&o.Headless, "quiet", "q", false, "logs only, no status line; metrics stay on the HTTP endpoints (default when stdout is not a terminal)")
+	cmd.Flags().BoolVar(&o.Headless, "no-display", false, "same as --quiet")
+	cmd.Flags().BoolVar(&o.NoColor, "no-color", false, "plain status line and dashboard without ANSI colours (also when NO_COLOR is set)")
+	cmd.Flags().Float64Var(&o.EventsPerMinute, "events-per-minute", DefaultConsoleEventRate, "print at most this many notable events a minute (large trades, alerts, reconnects) above the status line or in the dashboard (0 disables)")
+	cmd.Flags().Float64Var(&o.LargeTradeFactor, "large-trade-factor", DefaultLargeTradeFactor, "a trade is notable at this many times its symbol's average notional")
+	cmd.MarkFlagsMutuallyExclusive("tui", "quiet")
+	cmd.MarkFlagsMutuallyExclusive("tui", "no-display")
+}
+
+// resolve turns the status line off when stdout is not a terminal, as
+// under systemd or in a container, where carriage returns garble the logs.
+func (o *displayOptions) resolve(stdout io.Writer) {
+	if !o.TUI && !isTerminal(stdout) {
+		o.Headless = true
+	}
+}
+
+func newLiveCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var outputs OutputOptions
+	var display displayOptions
+	var run runOptions
+	cmd := &cobra.Command{
+		Use:   "live",
+		Short: "Stream the Binance feed with a live status line or dashboard",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			display.resolve(os.Stdout)
+			return runLive(cmd.Context(), pipeline, outputs, display, run)
+		},
+	}
+	pipeline.register(cmd.Flags())
+	outputs.register(cmd.Flags())
+	display.register(cmd)
+	run.register(cmd.Flags())
+	return cmd
+}
+
+func newServeCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var outputs OutputOptions
+	var run runOptions
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Stream the Binance feed headless, serving the APIs and sinks",
+		Long: "Runs the live pipeline as a service: nothing is drawn on the terminal, so it suits\n" +
+			"containers and process supervisors. The REST API listens on :8080 unless --api-addr says otherwise.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLive(cmd.Context(), pipeline, outputs, displayOptions{Headless: true}, run)
+		},
+	}
+	pipeline.register(cmd.Flags())
+	outputs.register(cmd.Flags())
+	run.register(cmd.Flags())
+	api := cmd.Flags().Lookup("api-addr")
+	api.DefValue = ":8080"
+	api.Value.Set(api.DefValue)
+	return cmd
+}
+
+func newExchangeCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var outputs OutputOptions
+	var run runOptions
+	var fixAddr, fixCompID string
+	cmd := &cobra.Command{
+		Use:   "exchange",
+		Short: "Serve the matching engine as a simulated venue for order entry",
+		Long: "Runs the books as a mini exchange with no Binance feed: clients enter, cancel and modify\n" +
+			"limit orders over REST and WebSocket at /orders and over the gRPC OrderEntry service, receive\n" +
+			"execution reports, and follow the resulting market data on /ws and the usual sinks.\n" +
+			"With --fix-addr, FIX 4.4 sessions can enter and cancel orders too.\n" +
+			"The REST API listens on :8080 unless --api-addr says otherwise.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := run.validate(); err != nil {
+				return err
+			}
+			m, err := NewMonitor(time.Now(), pipeline)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+			x, err := m.OpenExchange()
+			if err != nil {
+				return err
+			}
+			if err := m.StartOutputs(outputs); err != nil {
+				return err
+			}
+			if fixAddr != "" {
+				lis, err := net.Listen("tcp", fixAddr)
+				if err != nil {
+					return fmt.Errorf("failed to listen for FIX: %w", err)
+				}
+				acceptor := NewFIXAcceptor(x, fixCompID)
+				go func() {
+					if err := acceptor.Serve(lis); err != nil {
+						logger("main").Error("FIX acceptor stopped", "err", err)
+					}
+				}()
+				m.onShutdown(func(context.Context) { acceptor.Close() })
+				logger("main").Info("accepting FIX sessions", "addr", fixAddr, "comp_id", fixCompID)
+			}
+			logger("main").Info("serving order entry", "symbols", m.SymbolList)
+			return runMonitor(cmd.Context(), m, displayOptions{Headless: true}, run, func(ctx context.Context) {
+				// No feed: the books change only as orders come in
+				<-ctx.Done()
+				m.Shards.Close()
+			})
+		},
+	}
+	pipeline.register(cmd.Flags())
+	outputs.register(cmd.Flags())
+	run.register(cmd.Flags())
+	cmd.Flags().StringVar(&fixAddr, "fix-addr", "", "listen address for FIX 4.4 order entry sessions (e.g. :9878)")
+	cmd.Flags().StringVar(&fixCompID, "fix-comp-id", "APEXLOB", "the exchange's CompID, which FIX clients send as TargetCompID")
+	// Client orders are not to be evicted from the books
+	for _, name := range []string{"api-addr", "max-levels", "max-orders"} {
+		flag := cmd.Flags().Lookup(name)
+		if name == "api-addr" {
+			flag.DefValue = ":8080"
+		} else {
+			flag.DefValue = "0"
+		}
+		flag.Value.Set(flag.DefValue)
+	}
+	return cmd
+}
+
+func newGatewayCommand() *cobra.Command {
+	var addr string
+	var instances []string
+	var ttl, pollEvery time.Duration
+	cmd := &cobra.Command{
+		Use:   "gateway",
+		Short: "Route API queries across a cluster of monitor instances by symbol",
+		Long: "Serves one REST API in front of several monitor instances that each own a subset of the\n" +
+			"symbols. Per-symbol queries and /ws and /arrow streams are proxied to the owning instance.\n" +
+			"Instances are listed with --instances, or register themselves with --cluster-registry.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gateway, err := NewClusterGateway(instances, ttl, clusterHTTPClient)
+			if err != nil {
+				return err
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go gateway.Run(ctx, pollEvery)
+
+			srv := &http.Server{Addr: addr, Handler: gateway.Handler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+			errs := make(chan error, 1)
+			go func() { errs <- srv.ListenAndServe() }()
+			logger("main").Info("serving cluster gateway", "addr", addr, "instances", len(instances))
+			select {
+			case err := <-errs:
+				return err
+			case <-ctx.Done():
+			}
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return srv.Shutdown(shutdownCtx)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "api-addr", ":8000", "listen address of the gateway's REST API")
+	cmd.Flags().StringSliceVar(&instances, "instances", nil, "comma-separated API URLs of instances whose /symbols are polled, e.g. http://mon1:8080,http://mon2:8080")
+	cmd.Flags().DurationVar(&ttl, "cluster-ttl", 3*clusterRenewEvery, "forget a registered instance that has not renewed its registration for this long")
+	cmd.Flags().DurationVar(&pollEvery, "poll-interval", 10*time.Second, "how often the --instances are asked for their symbols")
+	return cmd
+}
+
+func newReplayCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var outputs OutputOptions
+	var display displayOptions
+	var input, from string
+	var speed float64
+	var run runOptions
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Feed a capture file through the pipeline and its outputs",
+		Long:  "Feeds a capture file through the pipeline and its outputs. --symbol defaults to every symbol in the capture.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := run.validate(); err != nil {
+				return err
+			}
+			var start time.Time
+			if from != "" {
+				var err error
+				if start, err = time.Parse(time.RFC3339Nano, from); err != nil {
+					return fmt.Errorf("invalid --from: %w", err)
+				}
+			}
+			if err := symbolsFromCapture(cmd, &pipeline, input); err != nil {
+				return err
+			}
+			pipeline.resolveClock(true)
+			m, err := startMonitor(pipeline, outputs)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+			if mirrored := m.MirroredSymbols(); !start.IsZero() && len(mirrored) > 0 {
+				// Their books need every depth update since the snapshot
+				return fmt.Errorf("--from cannot start mirrored books (%s) mid-capture", strings.Join(mirrored, ", "))
+			}
+			display.resolve(os.Stdout)
+			return runMonitor(cmd.Context(), m, display, run, func(ctx context.Context) {
+				if err := ReplayCaptureFrom(ctx, m, input, start, speed); err != nil {
+					logger("replay").Error("replay stopped", "err", err)
+				}
+			})
+		},
+	}
+	pipeline.register(cmd.Flags())
+	outputs.register(cmd.Flags())
+	display.register(cmd)
+	run.register(cmd.Flags())
+	cmd.Flags().StringVar(&input, "input", "", "capture file of raw aggTrade messages, one per line, as written by record, or compacted by compact")
+	cmd.Flags().StringVar(&from, "from", "", "start at the first message at or after this RFC 3339 time, e.g. 2024-05-01T14:30:00Z; a compacted capture seeks straight to it")
+	cmd.Flags().Float64Var(&speed, "speed", 0, "replay at this multiple of the recorded pace, from event times (0 for as fast as possible)")
+	cmd.Flags().BoolVar(&run.Wait, "wait", false, "keep serving the outputs after the capture ends until interrupted")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
+
+func newRecordCommand() *cobra.Command {
+	var symbols, output, rotateSize string
+	var messages int
+	var duration, rotateEvery time.Duration
+	var depth bool
+	var archive ArchiveConfig
+	cmd := &cobra.Command{
+		Use:   "record",
+		Short: "Save the raw Binance feed to a capture file for replay, backtest and bench",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			list := splitList(strings.ToLower(symbols))
+			if len(list) == 0 {
+				return errors.New("no symbol to record")
+			}
+			rotateBytes, err := parseByteSize(rotateSize)
+			if err != nil {
+				return fmt.Errorf("invalid --rotate-size: %w", err)
+			}
+			rotate := rotateBytes > 0 || rotateEvery > 0
+			if output == "-" && (rotate || archive.URL != "") {
+				return errors.New("rotating and archiving need an --output file")
+			}
+			var archiver *Archiver
+			if archive.URL != "" {
+				if archiver, err = NewArchiver(archive, NewMetricsRegistry()); err != nil {
+					return err
+				}
+				go archiver.Run()
+				defer archiver.Close(context.Background())
+			}
+			var w io.Writer = os.Stdout
+			switch {
+			case rotate:
+				// capture.jsonl becomes capture-<start time>.jsonl, ...
+				dir, ext := filepath.Dir(output), filepath.Ext(output)
+				prefix := strings.TrimSuffix(filepath.Base(output), ext)
+				rf, err := NewRotatingFile(dir, prefix, ext, rotateBytes, rotateEvery)
+				if err != nil {
+					return err
+				}
+				if archiver != nil {
+					archiver.Backlog(dir, []string{prefix}, ext)
+					rf.onClose = archiver.Enqueue
+				}
+				defer rf.Close()
+				w = rf
+			case output != "-":
+				f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					return err
+				}
+				defer func() {
+					if f.Close() == nil && archiver != nil {
+						archiver.Enqueue(output)
+					}
+				}()
+				w = f
+			}
+			var depthSymbols []string
+			if depth {
+				depthSymbols = list
+			}
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			if duration > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, duration)
+				defer cancel()
+			}
+			url := binanceStreamURL(list, depthSymbols)
+			conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+			if err != nil {
+				return fmt.Errorf("failed to connect: %w", err)
+			}
+			// Snapshots are taken once the stream is open, so the updates
+			// recorded after them cover everything since
+			for _, sym := range depthSymbols {
+				if err := recordDepthSnapshot(ctx, w, sym); err != nil {
+					conn.Close()
+					return err
+				}
+			}
+			recordLog := logger("record")
+			recordLog.Info("recording", "url", url, "output", output)
+			n, err := RecordFeed(ctx, conn, w, messages)
+			recordLog.Info("recording finished", "messages", n)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&symbols, "symbol", "btcusdt", "Binance symbol to record, or a comma-separated list")
+	cmd.Flags().StringVarP(&output, "output", "o", "capture.jsonl", "capture file, appended to; - for standard output")
+	cmd.Flags().IntVar(&messages, "messages", 0, "stop after this many messages (0 for no limit)")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "stop after this long (0 for no limit)")
+	cmd.Flags().BoolVar(&depth, "depth", false, "also record the diff depth stream, after a snapshot of each book, for replaying with --book-mode mirrored")
+	cmd.Flags().StringVar(&rotateSize, "rotate-size", "0", "start a new capture file, named after --output and its start time, after this size, e.g. 1GB (0 disables)")
+	cmd.Flags().DurationVar(&rotateEvery, "rotate-interval", 0, "start a new capture file after this long (0 disables)")
+	archive.register(cmd.Flags())
+	return cmd
+}
+
+// recordDepthSnapshot writes sym's book to a capture as a depthSnapshot
+// event.
+func recordDepthSnapshot(ctx context.Context, w io.Writer, sym string) error {
+	snap, err := FetchDepthSnapshot(ctx, restClient, binanceRESTURL, sym)
+	if err != nil {
+		return fmt.Errorf("failed to fetch depth snapshot: %w", err)
+	}
+	line, err := snap.MarshalCapture()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// symbolsFromCapture defaults --symbol to the symbols in a capture file.
+func symbolsFromCapture(cmd *cobra.Command, pipeline *PipelineOptions, path string) error {
+	if cmd.Flags().Changed("symbol") {
+		return nil
+	}
+	symbols, err := CaptureSymbols(path)
+	if err != nil {
+		return err
+	}
+	if len(symbols) == 0 {
+		return fmt.Errorf("no aggTrade messages in %s", path)
+	}
+	pipeline.Symbols = strings.Join(symbols, ",")
+	return nil
+}
+
+// startMonitor builds the pipeline and starts its outputs.
+func startMonitor(pipeline PipelineOptions, outputs OutputOptions) (*Monitor, error) {
+	m, err := NewMonitor(time.Now(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline.Strategy != "" {
+		strategy, err := NewStrategy(pipeline.Strategy)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.AttachStrategy(strategy, pipeline.strategyOptions(true))
+		logger("main").Info("paper trading", "strategy", pipeline.Strategy)
+	}
+	if err := m.StartOutputs(outputs); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func runLive(ctx context.Context, pipeline PipelineOptions, outputs OutputOptions, display displayOptions, run runOptions) error {
+	if err := run.validate(); err != nil {
+		return err
+	}
+	if pipeline.WarmStart != "" && outputs.CandleBackfill > 0 {
+		return errors.New("--warm-start and --candle-backfill both seed the candles; use one of them")
+	}
+	m, err := startMonitor(pipeline, outputs)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	if outputs.CandleBackfill > 0 {
+		// The monitor runs cold rather than not at all
+		if err := m.BackfillCandles(ctx, outputs.CandleBackfill, time.Now(), binanceKlines); err != nil {
+			logger("main").Error("candle backfill failed", "err", err)
+		}
+	}
+
+	if len(pipeline.FeedEndpoints) == 0 {
+		return errors.New("no --feed-endpoints to connect to")
+	}
+	bases := pipeline.FeedEndpoints
+	var probes []EndpointProbe
+	if pipeline.FeedProbe || pipeline.FeedProbeEvery > 0 {
+		probes = ProbeEndpoints(ctx, bases)
+		bases = fastestFirst(probes)
+	}
+	endpoints := NewFeedEndpoints(bases, binanceStreamPath(m.SymbolList, m.MirroredSymbols()), pipeline.FeedHealth, m.Registry)
+	endpoints.recordProbes(probes)
+	if !display.Headless {
+		if len(m.SymbolList) == 1 {
+			fmt.Printf("Connecting to Binance %s/USDT Live Feed...\n", m.SymbolList[0])
+		} else {
+			fmt.Printf("Connecting to Binance combined feed for %s...\n", strings.Join(m.SymbolList, ", "))
+		}
+		fmt.Printf("WebSocket URL: %s\n", endpoints.urls[0])
+		fmt.Println()
+	}
+
+	dialer := websocket.Dialer{}
+	dial := func(ctx context.Context) (*websocket.Conn, error) {
+		conn, err := endpoints.Dial(ctx, &dialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect: %w", err)
+		}
+		logger("main").Info("connected to Binance WebSocket", "endpoint", endpointName(endpoints.Active()), "connect_ms", time.Since(m.Start).Milliseconds())
+		return conn, nil
+	}
+	// Without a warm start, a feed that cannot connect fails before the
+	// display starts; with one, the connection would sit unread while the
+	// history replays
+	var conn *websocket.Conn
+	if pipeline.WarmStart == "" {
+		if conn, err = dial(ctx); err != nil {
+			return err
+		}
+	}
+
+	return runMonitor(ctx, m, display, run, func(ctx context.Context) {
+		if pipeline.WarmStart != "" {
+			mainLog := logger("main")
+			started := time.Now()
+			warmed, err := m.WarmStart(ctx, pipeline.WarmStart, pipeline.WarmStartWindow)
+			if err != nil {
+				// The monitor runs cold rather than not at all
+				mainLog.Error("warm start failed", "err", err)
+			}
+			mainLog.Info("warm start replayed", "path", pipeline.WarmStart, "messages", warmed.Lines, "skipped", warmed.Skipped,
+				"from", time.UnixMilli(warmed.FirstMs).UTC(), "to", time.UnixMilli(warmed.LastMs).UTC(), "took", time.Since(started))
+			if conn, err = dial(ctx); err != nil {
+				mainLog.Error("live feed", "err", err)
+				m.Shards.Close()
+				return
+			}
+		}
+		if pipeline.markPrices() {
+			go RunMarkPriceFeed(ctx, m, &dialer, binanceMarkPriceURL(m.SymbolList))
+		}
+		if pipeline.AccountStream {
+			keys := ListenKeys{Client: restClient, BaseURL: binanceRESTURL, APIKey: pipeline.BinanceAPIKey}
+			go RunUserDataStream(ctx, m, &dialer, keys)
+		}
+		if pipeline.ClockSyncEvery > 0 {
+			go m.clock.Run(ctx, restClient, binanceRESTURL, pipeline.ClockSyncEvery)
+		}
+		if pipeline.FeedProbeEvery > 0 && len(bases) > 1 {
+			go endpoints.RunProbes(ctx, pipeline.FeedProbeEvery)
+		}
+		RunBinanceFeed(ctx, m, &dialer, endpoints, conn)
+	})
+}
+
+// runMonitor starts m's workers and feed, draws progress as display asks,
+// and returns when the feed ends or hits run's limits (or, with run.Wait,
+// once interrupted after that) or the user interrupts it or ctx is done.
+// The feed is stopped by cancelling the context it is given. The final
+// report is written on every path out.
+func runMonitor(ctx context.Context, m *Monitor, display displayOptions, run runOptions, feed func(ctx context.Context)) error {
+	mainLog := logger("main")
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	quit := make(chan struct{})
+	restoreTerminal := func() {}
+	if display.TUI {
+		logFile, err := os.OpenFile("apexlob.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		defer logFile.Close()
+		console.SetLogOutput(logFile)
+
+		dashboard := NewDashboard(m.Symbols, m.Snapshot, os.Stdout, display.Refresh, colorWanted(display.NoColor))
+		if display.EventsPerMinute > 0 {
+			dashboard.events = NewConsoleEvents(display.EventsPerMinute, display.LargeTradeFactor, nil)
+			defer m.WatchConsoleEvents(dashboard.events)()
+		}
+		restoreTerminal, err = dashboard.ReadKeys(os.Stdin, quit)
+		if err != nil {
+			return fmt.Errorf("failed to enter raw terminal mode: %w", err)
+		}
+		go dashboard.Run(quit)
+	}
+
+	// The status line, or with several symbols a table of them, is redrawn
+	// on a ticker from a stats snapshot, so rendering cost stays out of
+	// processing time
+	stopDisplay := make(chan struct{})
+	displayDone := make(chan struct{})
+	switch {
+	case display.TUI || display.Headless:
+		close(displayDone)
+	case len(m.SymbolList) > 1:
+		states := make([]*SymbolState, len(m.SymbolList))
+		for i, sym := range m.SymbolList {
+			states[i], _ = m.Symbols.Get(sym)
+		}
+		go func() {
+			defer close(displayDone)
+			RunSymbolTable(states, m.Snapshot, display.Refresh, colorWanted(display.NoColor), stopDisplay)
+		}()
+	default:
+		primary, _ := m.Symbols.Get(m.SymbolList[0])
+		go func() {
+			defer close(displayDone)
+			RunDisplay(primary, m.Snapshot, display.Refresh, colorWanted(display.NoColor), stopDisplay)
+		}()
+	}
+	if !display.TUI && !display.Headless && display.EventsPerMinute > 0 {
+		defer m.WatchConsoleEvents(NewConsoleEvents(display.EventsPerMinute, display.LargeTradeFactor, console.Println))()
+	}
+	endStatus := func() {
+		close(stopDisplay)
+		<-displayDone
+		if !display.Headless {
+			console.EndStatus()
+		}
+	}
+
+	var alerts func() map[string]map[string]int
+	if run.Report != "" {
+		alerts = m.CountAlerts()
+	}
+	m.MaxMessages = run.MaxMessages
+	var deadline <-chan time.Time
+	if run.Duration > 0 {
+		timer := time.NewTimer(run.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	done := m.Run()
+	feedCtx, stopFeed := context.WithCancel(ctx)
+	defer stopFeed()
+	go feed(feedCtx)
+
+	reason := "feed ended"
+	select {
+	case <-done:
+		endStatus()
+		if m.LimitReached() {
+			reason = "message limit reached"
+		}
+		mainLog.Info(reason)
+		if run.Wait {
+			mainLog.Info("serving until interrupted")
+			select {
+			case <-interrupt:
+			case <-quit:
+			case <-ctx.Done():
+			}
+		}
+	case <-deadline:
+		endStatus()
+		reason = "duration reached"
+		mainLog.Info(reason, "duration", run.Duration)
+	case <-interrupt:
+		endStatus()
+		reason = "interrupted"
+		mainLog.Info("interrupted by user")
+	case <-ctx.Done():
+		endStatus()
+		reason = "cancelled"
+		mainLog.Info("cancelled", "err", ctx.Err())
+	case <-quit:
+		fmt.Print(ansiClear)
+		reason = "interrupted"
+		mainLog.Info("dashboard closed by user")
+	}
+	restoreTerminal()
+	shutdown(m, stopFeed, done, run.ShutdownTimeout, interrupt)
+
+	final := m.Snapshot()
+	if display.Headless {
+		logFinalStats(mainLog, final)
+	} else {
+		printFinalStats(final)
+	}
+	if m.strategy != nil {
+		r := m.strategy.Report()
+		mainLog.Info("paper trading result", "orders", r.Orders, "fills", r.Fills, "open_orders", len(r.OpenOrders),
+			"realized_pnl", r.RealizedPnL, "unrealized_pnl", r.UnrealizedPnL, "pnl", r.PnL)
+	}
+	if m.account != nil {
+		r := m.account.Report()
+		mainLog.Info("account result", "fills", r.Fills, "realized_pnl", r.RealizedPnL, "unrealized_pnl", r.UnrealizedPnL, "pnl", r.PnL)
+	}
+	if run.Report != "" {
+		report := NewRunReport(m, reason, alerts())
+		if err := report.WriteFile(run.Report); err != nil {
+			return fmt.Errorf("writing report: %w", err)
+		}
+		mainLog.Info("wrote report", "path", run.Report)
+	}
+	return nil
+}
+
+// shutdown stops the feed, lets the workers finish what it already queued
+// and closes m, which flushes and closes every sink. It stops waiting after
+// timeout (0 for never) or on another signal, so a stuck sink cannot hold
+// the process up, and reports whether everything completed.
+func shutdown(m *Monitor, stopFeed func(), done <-chan struct{}, timeout time.Duration, interrupt <-chan os.Signal) bool {
+	mainLog := logger("main")
+	mainLog.Info("shutting down: draining queues and flushing sinks")
+	stopFeed()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+	go func() {
+		select {
+		case <-interrupt:
+			mainLog.Warn("interrupted again, skipping the rest of shutdown")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	wait := func(finished <-chan struct{}) bool {
+		select {
+		case <-finished:
+			if ctx.Err() == nil {
+				return true
+			}
+		case <-ctx.Done():
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			mainLog.Warn("shutdown timed out, buffered data may be lost", "timeout", timeout)
+		}
+		return false
+	}
+	if !wait(done) {
+		return false
+	}
+	// Shutdown gives up on the sinks and servers once ctx is done, but a
+	// model or store closing may not, so it is waited for the same way
+	closed := make(chan struct{})
+	go func() {
+		m.Shutdown(ctx)
+		close(closed)
+	}()
+	return wait(closed)
+}
+
+func printFinalStats(final StatsSnapshot) {
+	fmt.Printf("[INFO] Connection duration: %.2f seconds\n", final.UptimeSeconds)
+	fmt.Printf("[INFO] Total messages processed: %d\n", final.TotalMessages)
+	fmt.Printf("[INFO] Messages per second: %.2f\n", final.MessagesPerSecond)
+	fmt.Printf("[INFO] Average processing time: %.3f ms\n", final.AvgProcessingMs)
+	for _, l := range []struct {
+		name string
+		p    LatencyPercentiles
+	}{{"Processing", final.Processing}, {"End-to-end", final.EndToEnd}} {
+		fmt.Printf("[INFO] %s latency p50/p90/p99/p99.9: %.3f/%.3f/%.3f/%.3f ms\n", l.name, l.p.P50, l.p.P90, l.p.P99, l.p.P999)
+	}
+	if e := final.Errors; e.Total() > 0 {
+		fmt.Printf("[WARN] Errors: %d parse, %d invalid, %d unknown symbol, %d reconnects, %d events dropped, %d sink failures\n",
+			e.ParseErrors, e.ValidationFailures, e.DroppedMessages, e.Reconnects, e.DroppedEvents, e.SinkFailures)
+	}
+}
+
+func logFinalStats(l *slog.Logger, final StatsSnapshot) {
+	l.Info("final statistics",
+		"uptime_s", final.UptimeSeconds,
+		"messages", final.TotalMessages,
+		"msgs_per_sec", final.MessagesPerSecond,
+		"avg_processing_ms", final.AvgProcessingMs,
+		"p99_processing_ms", final.Processing.P99,
+		"p99_end_to_end_ms", final.EndToEnd.P99,
+		"parse_errors", final.Errors.ParseErrors,
+		"validation_failures", final.Errors.ValidationFailures,
+		"dropped_messages", final.Errors.DroppedMessages,
+		"reconnects", final.Errors.Reconnects,
+		"dropped_events", final.Errors.DroppedEvents,
+		"sink_failures", final.Errors.SinkFailures)
+}
@@ -0,0 +1,189 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRootCommandHasSubcommands(t *testing.T) {
+	root := NewRootCommand()
+	for _, name := range []string{"live", "serve", "replay", "backtest", "bench", "record"} {
+		cmd, _, err := root.Find([]string{name})
+		if err != nil || cmd.Name() != name {
+			t.Errorf("subcommand %s not found: %v", name, err)
+		}
+	}
+
+	serve, _, _ := root.Find([]string{"serve"})
+	if addr := serve.Flags().Lookup("api-addr"); addr.Value.String() != ":8080" {
+		t.Errorf("serve --api-addr default = %q, want :8080", addr.Value.String())
+	}
+	live, _, _ := root.Find([]string{"live"})
+	if addr := live.Flags().Lookup("api-addr"); addr.Value.String() != "" {
+		t.Errorf("live --api-addr default = %q, want none", addr.Value.String())
+	}
+}
+
+func TestBacktestCommand(t *testing.T) {
+	path := writeCapture(t, []string{"solusdt"}, 100)
+	root := NewRootCommand()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	// --symbol defaults to the symbols in the capture
+	root.SetArgs([]string{"backtest", "--input", path, "--shards", "1", "--json", "--log-level", "error"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	var report BacktestReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("invalid JSON report: %v\n%s", err, out.String())
+	}
+	if report.Messages != 100 || report.Books["solusdt"].TotalVolume == 0 {
+		t.Errorf("report = %+v", report)
+	}
+
+	out.Reset()
+	root = NewRootCommand()
+	root.SetOut(&out)
+	root.SetArgs([]string{"backtest", "--input", path, "--strategy", "mm", "--log-level", "error"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "strategy  ") {
+		t.Errorf("backtest --strategy mm printed no strategy report:\n%s", out.String())
+	}
+	root = NewRootCommand()
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetArgs([]string{"backtest", "--input", path, "--strategy", "nosuch"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "mm, momentum") {
+		t.Errorf("backtest with an unknown strategy = %v", err)
+	}
+
+	root = NewRootCommand()
+	root.SetOut(&out)
+	root.SetErr(&out)
+	root.SetArgs([]string{"backtest"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "input") {
+		t.Errorf("backtest without --input = %v", err)
+	}
+}
+
+func TestDisplayQuietFlags(t *testing.T) {
+	for _, flag := range []string{"--quiet", "-q", "--no-display"} {
+		root := NewRootCommand()
+		root.SetArgs([]string{"live", "--tui", flag})
+		if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "tui") {
+			t.Errorf("live --tui %s: err = %v, want a conflict", flag, err)
+		}
+	}
+
+	// Anything but a terminal gets no status line
+	display := displayOptions{}
+	display.resolve(&bytes.Buffer{})
+	if !display.Headless {
+		t.Error("display is not headless when stdout is not a terminal")
+	}
+	display = displayOptions{TUI: true}
+	display.resolve(&bytes.Buffer{})
+	if display.Headless {
+		t.Error("--tui turned headless")
+	}
+}
+
+func TestReplayCommandLimitsAndReport(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt"}, 300)
+	report := filepath.Join(t.TempDir(), "report.json")
+	root := NewRootCommand()
+	root.SetArgs([]string{"replay", "--input", path, "--shards", "1", "--max-messages", "100", "--report", report, "--log-level", "error"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RunReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StopReason != "message limit reached" || got.Stats.TotalMessages != 100 {
+		t.Errorf("report stopped by %q after %d messages, want the limit of 100", got.StopReason, got.Stats.TotalMessages)
+	}
+
+	// A slow replay stops at --duration and still reports
+	var slow strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&slow, `{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"100.0","q":"1.0","m":true}`+"\n", 1700000000000+10000*i, i+1)
+	}
+	slowPath := filepath.Join(t.TempDir(), "slow.jsonl")
+	os.WriteFile(slowPath, []byte(slow.String()), 0o644)
+	root = NewRootCommand()
+	root.SetArgs([]string{"replay", "--input", slowPath, "--speed", "1", "--duration", "50ms", "--report", report, "--log-level", "error"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(report)
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.StopReason != "duration reached" {
+		t.Errorf("stop reason = %q, want duration reached", got.StopReason)
+	}
+
+	root = NewRootCommand()
+	root.SetArgs([]string{"replay", "--input", path, "--max-messages", "-1"})
+	if err := root.Execute(); err == nil {
+		t.Error("negative --max-messages accepted")
+	}
+}
+
+func TestShutdownDrainsAndFlushes(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt"}, 2000)
+	dir := t.TempDir()
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.StartOutputs(OutputOptions{ExportDir: dir, ExportFormat: "jsonl", ExportSignalEvery: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	done := m.Run()
+	ctx, stopFeed := context.WithCancel(context.Background())
+	defer stopFeed()
+	go ReplayCapture(ctx, m, path, 0)
+	// Stop mid-stream, with messages still queued for the worker
+	for m.Stats.Snapshot().TotalMessages == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if !shutdown(m, stopFeed, done, 5*time.Second, nil) {
+		t.Fatal("shutdown did not complete")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "trades*.jsonl"))
+	exported := 0
+	for _, f := range files {
+		data, _ := os.ReadFile(f)
+		exported += bytes.Count(data, []byte("\n"))
+	}
+	if processed := m.Stats.Snapshot().TotalMessages; exported != processed || processed == 0 {
+		t.Errorf("exported %d trades of %d processed", exported, processed)
+	}
+
+	// A worker that never finishes is abandoned after the timeout
+	start := time.Now()
+	if shutdown(m, func() {}, make(chan struct{}), 20*time.Millisecond, nil) {
+		t.Error("shutdown completed without the workers finishing")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown waited %v past its timeout", elapsed)
+	}
+}
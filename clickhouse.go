@@ -0,0 +1,192 @@
+package apexlob
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+//go:embed schema/clickhouse.sql
+var clickHouseSchema string
+
+type ClickHouseConfig struct {
+	URL          string // HTTP interface, e.g. http://localhost:8123
+	Database     string
+	User         string
+	Password     string
+	BatchSize    int           // rows per table buffered before an early insert
+	BookInterval time.Duration // minimum spacing of book snapshots per symbol
+}
+
+type clickHouseTable struct {
+	name string
+	buf  bytes.Buffer
+	rows int
+}
+
+// ClickHouseSink bulk-inserts trades and sampled book snapshots through the
+// HTTP interface as JSONEachRow. The tables are described in
+// schema/clickhouse.sql.
+type ClickHouseSink struct {
+	cfg      ClickHouseConfig
+	client   *http.Client
+	trades   *clickHouseTable
+	books    *clickHouseTable
+	lastBook map[string]time.Time
+	inserted *Counter
+	errors   *Counter
+}
+
+const clickHouseTime = "2006-01-02 15:04:05.000000"
+
+func NewClickHouseSink(cfg ClickHouseConfig, reg *MetricsRegistry) (*ClickHouseSink, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("clickhouse: url is required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 10000
+	}
+	return &ClickHouseSink{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		trades:   &clickHouseTable{name: "trades"},
+		books:    &clickHouseTable{name: "book_snapshots"},
+		lastBook: make(map[string]time.Time),
+		inserted: reg.Counter("apexlob_clickhouse_rows_total", "Rows inserted into ClickHouse.", nil),
+		errors:   reg.Counter("apexlob_clickhouse_errors_total", "Rows dropped after a failed ClickHouse insert.", nil),
+	}, nil
+}
+
+// CreateTables applies schema/clickhouse.sql, one statement per request.
+func (s *ClickHouseSink) CreateTables() error {
+	var lines []string
+	for _, line := range strings.Split(clickHouseSchema, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if sql := strings.TrimSpace(stmt); sql != "" {
+			if err := s.exec(sql, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ClickHouseSink) Name() string { return "clickhouse" }
+
+func (s *ClickHouseSink) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		s.row(s.trades, map[string]interface{}{
+			"timestamp": tr.Timestamp.UTC().Format(clickHouseTime),
+			"symbol":    tr.Symbol,
+			"id":        tr.ID,
+			"side":      tr.Side.String(),
+			"price":     tr.Price,
+			"quantity":  tr.Quantity,
+		})
+		if s.trades.rows >= s.cfg.BatchSize {
+			return s.insert(s.trades)
+		}
+	case EventBook:
+		if last, ok := s.lastBook[e.Symbol]; ok && e.Timestamp.Sub(last) < s.cfg.BookInterval {
+			return nil
+		}
+		s.lastBook[e.Symbol] = e.Timestamp
+		ts := e.Timestamp.UTC().Format(clickHouseTime)
+		for _, side := range []struct {
+			name   string
+			levels []orderbook.PriceLevel
+		}{{"BID", e.Book.Bids}, {"ASK", e.Book.Asks}} {
+			for i, lvl := range side.levels {
+				s.row(s.books, map[string]interface{}{
+					"timestamp": ts,
+					"symbol":    e.Symbol,
+					"side":      side.name,
+					"level":     i,
+					"price":     lvl.Price,
+					"volume":    lvl.Volume,
+					"orders":    lvl.Orders,
+				})
+			}
+		}
+		if s.books.rows >= s.cfg.BatchSize {
+			return s.insert(s.books)
+		}
+	}
+	return nil
+}
+
+func (s *ClickHouseSink) row(t *clickHouseTable, fields map[string]interface{}) {
+	b, _ := json.Marshal(fields)
+	t.buf.Write(b)
+	t.buf.WriteByte('\n')
+	t.rows++
+}
+
+func (s *ClickHouseSink) insert(t *clickHouseTable) error {
+	if t.rows == 0 {
+		return nil
+	}
+	body := append([]byte(nil), t.buf.Bytes()...)
+	rows := t.rows
+	t.buf.Reset()
+	t.rows = 0
+
+	if err := s.exec(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", t.name), body); err != nil {
+		s.errors.Add(uint64(rows))
+		return err
+	}
+	s.inserted.Add(uint64(rows))
+	return nil
+}
+
+// exec runs one statement; data, when present, is the INSERT payload.
+func (s *ClickHouseSink) exec(query string, data []byte) error {
+	q := url.Values{"query": {query}}
+	if s.cfg.Database != "" {
+		q.Set("database", s.cfg.Database)
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(s.cfg.URL, "/")+"/?"+q.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if s.cfg.User != "" {
+		req.Header.Set("X-ClickHouse-User", s.cfg.User)
+		req.Header.Set("X-ClickHouse-Key", s.cfg.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("clickhouse: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *ClickHouseSink) Flush() error {
+	err := s.insert(s.trades)
+	if berr := s.insert(s.books); err == nil {
+		err = berr
+	}
+	return err
+}
+
+func (s *ClickHouseSink) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,190 @@
+package apexlob
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+
+	"apexlob/pkg/orderbook"
+)
+
+type clickHouseRequest struct {
+	query    string
+	database string
+	rows     []map[string]interface{}
+}
+
+func newFakeClickHouse(t *testing.T) (*httptest.Server, func() []clickHouseRequest) {
+	var mu sync.Mutex
+	var reqs []clickHouseRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := clickHouseRequest{query: r.URL.Query().Get("query"), database: r.URL.Query().Get("database")}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			req.rows = append(req.rows, row)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []clickHouseRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]clickHouseRequest(nil), reqs...)
+	}
+}
+
+func TestClickHouseSinkBatchesInserts(t *testing.T) {
+	srv, requests := newFakeClickHouse(t)
+	sink, err := NewClickHouseSink(ClickHouseConfig{URL: srv.URL, Database: "md", BatchSize: 2, BookInterval: time.Second}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	for i := 0; i < 3; i++ {
+		sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts, Trade: &orderbook.Trade{Symbol: "btcusdt", ID: uint64(i), Price: 100, Quantity: 1, Side: orderbook.Buy, Timestamp: ts}})
+	}
+	book := &BookSnapshot{Bids: []orderbook.PriceLevel{{Price: 99, Volume: 5, Orders: 2}}}
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: ts, Book: book})
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: ts.Add(time.Millisecond), Book: book}) // sampled out
+
+	if got := len(requests()); got != 1 {
+		t.Fatalf("inserts before flush = %d, want 1 (batch of 2 trades)", got)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := requests()
+	if len(reqs) != 3 {
+		t.Fatalf("requests = %+v", reqs)
+	}
+	if reqs[0].query != "INSERT INTO trades FORMAT JSONEachRow" || reqs[0].database != "md" || len(reqs[0].rows) != 2 {
+		t.Errorf("first insert = %+v", reqs[0])
+	}
+	if reqs[0].rows[0]["timestamp"] != "2024-03-01 12:00:00.123456" || reqs[0].rows[0]["side"] != "BUY" {
+		t.Errorf("trade row = %v", reqs[0].rows[0])
+	}
+	if reqs[2].query != "INSERT INTO book_snapshots FORMAT JSONEachRow" || len(reqs[2].rows) != 1 || reqs[2].rows[0]["side"] != "BID" {
+		t.Errorf("book insert = %+v", reqs[2])
+	}
+	if sink.inserted.Value() != 4 {
+		t.Errorf("inserted = %d, want 4", sink.inserted.Value())
+	}
+}
+
+func TestClickHouseCreateTables(t *testing.T) {
+	srv, requests := newFakeClickHouse(t)
+	sink, _ := NewClickHouseSink(ClickHouseConfig{URL: srv.URL}, NewMetricsRegistry())
+	if err := sink.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	reqs := requests()
+	if len(reqs) != 2 {
+		t.Fatalf("statements = %d, want 2", len(reqs))
+	}
+	for _, r := range reqs {
+		if !strings.HasPrefix(r.query, "CREATE TABLE IF NOT EXISTS") {
+			t.Errorf("unexpected statement %q", r.query)
+		}
+	}
+}
+
+// Runs against a real server when APEXLOB_TEST_CLICKHOUSE holds the HTTP
+// interface's URL, e.g. http://default:@localhost:8123. The rows are read
+// back with the official Go client into the types schema/clickhouse.sql
+// declares.
+func TestClickHouseSinkIntegration(t *testing.T) {
+	raw := os.Getenv("APEXLOB_TEST_CLICKHOUSE")
+	if raw == "" {
+		t.Skip("APEXLOB_TEST_CLICKHOUSE not set")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := u.User.Password()
+	cfg := ClickHouseConfig{URL: (&url.URL{Scheme: u.Scheme, Host: u.Host}).String(), User: u.User.Username(), Password: password}
+
+	admin, _ := NewClickHouseSink(cfg, NewMetricsRegistry())
+	cfg.Database = "apexlob_test_" + time.Now().Format("150405_000000")
+	if err := admin.exec("CREATE DATABASE "+cfg.Database, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.exec("DROP DATABASE "+cfg.Database, nil) })
+
+	sink, _ := NewClickHouseSink(cfg, NewMetricsRegistry())
+	if err := sink.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC)
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts, Trade: &orderbook.Trade{Symbol: "btcusdt", ID: math.MaxUint64, Price: 64123.45, Quantity: 0.015, Side: orderbook.Sell, Timestamp: ts}})
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: ts, Book: &BookSnapshot{
+		Bids: []orderbook.PriceLevel{{Price: 64123.4, Volume: 5, Orders: 2}},
+		Asks: []orderbook.PriceLevel{{Price: 64123.5, Volume: 3, Orders: 1}, {Price: 64124, Volume: math.MaxUint32, Orders: 9}},
+	}})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := clickhouse.Open(&clickhouse.Options{
+		Addr:     []string{u.Host},
+		Protocol: clickhouse.HTTP,
+		Auth:     clickhouse.Auth{Database: cfg.Database, Username: cfg.User, Password: password},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := context.Background()
+
+	var (
+		at              time.Time
+		symbol, side    string
+		id              uint64
+		price, quantity float64
+	)
+	if err := conn.QueryRow(ctx, "SELECT timestamp, symbol, id, side, price, quantity FROM trades").Scan(&at, &symbol, &id, &side, &price, &quantity); err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(ts) || symbol != "btcusdt" || id != math.MaxUint64 || side != "SELL" || price != 64123.45 || quantity != 0.015 {
+		t.Errorf("trade row = %v %s %d %s %v %v", at, symbol, id, side, price, quantity)
+	}
+
+	rows, err := conn.Query(ctx, "SELECT side, level, price, volume, orders FROM book_snapshots ORDER BY side, level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var level uint16
+		var volume, orders uint32
+		if err := rows.Scan(&side, &level, &price, &volume, &orders); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %d %v %d %d", side, level, price, volume, orders))
+	}
+	want := []string{"BID 0 64123.4 5 2", "ASK 0 64123.5 3 1", "ASK 1 64124 4294967295 9"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("book rows = %q, want %q", got, want)
+	}
+}
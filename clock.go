@@ -0,0 +1,227 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clockSyncSamples is how many round trips a sync makes; the offset is
+// taken from the fastest, whose midpoint is the least uncertain.
+const clockSyncSamples = 5
+
+// ClockSync estimates how far the exchange's clock is ahead of the local
+// one, so latencies measured from exchange event times to local receipt are
+// not mostly skew. Two sources bound it:
+//
+//   - the REST time endpoint, as NTP does: the server's time is taken to
+//     be read at the midpoint of the fastest of a few round trips, give or
+//     take half that round trip;
+//   - event timestamps: nothing arrives before it happened, so the offset
+//     is at least the largest amount by which an event time is ahead of
+//     its receipt.
+//
+// The correction is the larger of the two. Both start at zero, so a
+// monitor that never syncs is not corrected unless its events arrive
+// before they happened. The zero ClockSync is not usable; a nil one
+// corrects nothing.
+type ClockSync struct {
+	rest  atomic.Int64 // ns, from the REST time endpoint
+	bound atomic.Int64 // ns, from event timestamps since the last sync
+
+	mu     sync.Mutex
+	rtt    time.Duration // of the round trip the REST offset was taken from
+	synced time.Time
+}
+
+func NewClockSync() *ClockSync {
+	c := &ClockSync{}
+	c.bound.Store(math.MinInt64)
+	return c
+}
+
+// Offset is how far the exchange's clock is estimated to be ahead of the
+// local one.
+func (c *ClockSync) Offset() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return time.Duration(max(c.rest.Load(), c.bound.Load()))
+}
+
+// Local converts an exchange timestamp in milliseconds to local time.
+func (c *ClockSync) Local(exchangeMs int64) time.Time {
+	return time.UnixMilli(exchangeMs).Add(-c.Offset())
+}
+
+// ObserveEvent raises the lower bound on the offset if an event stamped at
+// exchangeMs was received before, by the local clock, it happened.
+func (c *ClockSync) ObserveEvent(exchangeMs int64, received time.Time) {
+	if c == nil {
+		return
+	}
+	ahead := time.UnixMilli(exchangeMs).Sub(received).Nanoseconds()
+	for {
+		cur := c.bound.Load()
+		if ahead <= cur || c.bound.CompareAndSwap(cur, ahead) {
+			return
+		}
+	}
+}
+
+// Uncertainty is half the round trip of the last sync, plus the half
+// millisecond the server's time is truncated by; zero before the first.
+func (c *ClockSync) Uncertainty() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.synced.IsZero() {
+		return 0
+	}
+	return c.rtt/2 + time.Millisecond/2
+}
+
+// Sync measures the offset against the time endpoint of the Binance REST
+// API at baseURL. The event bound starts again from the new estimate, so a
+// clock that is stepped or drifts is followed.
+func (c *ClockSync) Sync(ctx context.Context, client *http.Client, baseURL string) error {
+	best := time.Duration(math.MaxInt64)
+	var offset time.Duration
+	var err error
+	for i := 0; i < clockSyncSamples; i++ {
+		var server int64
+		sent := time.Now()
+		if server, err = FetchServerTime(ctx, client, baseURL); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+		rtt := time.Since(sent)
+		if rtt < best {
+			// The server reports whole milliseconds, so its time is on
+			// average half of one later than it says
+			mid := sent.Add(rtt / 2)
+			best, offset = rtt, time.UnixMilli(server).Add(time.Millisecond/2).Sub(mid)
+		}
+	}
+	if best == math.MaxInt64 {
+		return err
+	}
+	c.mu.Lock()
+	c.rtt, c.synced = best, time.Now()
+	c.mu.Unlock()
+	c.rest.Store(offset.Nanoseconds())
+	c.bound.Store(math.MinInt64)
+	logger("clock").Info("clock synced", "offset", offset, "rtt", best)
+	return nil
+}
+
+// Run syncs now and then every interval until ctx is done, logging
+// failures; the last estimate stands until a sync succeeds.
+func (c *ClockSync) Run(ctx context.Context, client *http.Client, baseURL string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := c.Sync(ctx, client, baseURL); err != nil && ctx.Err() == nil {
+			logger("clock").Warn("clock sync failed", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// registerMetrics exports the estimate.
+func (c *ClockSync) registerMetrics(reg *MetricsRegistry) {
+	reg.GaugeFunc("apexlob_clock_offset_seconds", "Estimated offset of the exchange's clock ahead of the local one, by which end-to-end latencies are corrected.", func() []Sample {
+		return []Sample{{Value: c.Offset().Seconds()}}
+	})
+	reg.GaugeFunc("apexlob_clock_uncertainty_seconds", "Half the round trip of the last clock sync against the exchange's time endpoint (0 before the first).", func() []Sample {
+		return []Sample{{Value: c.Uncertainty().Seconds()}}
+	})
+}
+
+// FetchServerTime returns the time of the Binance REST API at baseURL, in
+// milliseconds since the epoch.
+func FetchServerTime(ctx context.Context, client *http.Client, baseURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/api/v3/time", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server time: %s", resp.Status)
+	}
+	var body struct {
+		ServerTime int64 `json:"serverTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("server time: %w", err)
+	}
+	return body.ServerTime, nil
+}
+
+// Clock is the time a pipeline goes by. It stamps the trades and the orders
+// a synthetic book builds from them, so it decides which candle a trade
+// falls in and what time windowed signals and rules see; latencies are
+// always measured on the local clock.
+type Clock interface {
+	// Observe is told the exchange event time, in ms (0 if it has none),
+	// of each message as the pipeline takes it, before Now is read for it
+	Observe(eventMs int64)
+	Now() time.Time
+}
+
+// Clock modes of PipelineOptions.Clock.
+const (
+	ClockAuto  = "auto"  // event for replay and backtest, wall otherwise
+	ClockWall  = "wall"  // WallClock
+	ClockEvent = "event" // a SimClock per symbol
+)
+
+// WallClock is the local clock: a message is stamped when it is processed.
+type WallClock struct{}
+
+func (WallClock) Observe(int64)  {}
+func (WallClock) Now() time.Time { return time.Now() }
+
+// SimClock is driven by the exchange event times of the messages it
+// observes, so whatever is computed on it depends only on the messages: a
+// replay or backtest computes the same windows and candles however fast it
+// runs, and as a live monitor on an event clock did from the same feed. It
+// never goes backwards, and messages without an event time are stamped with
+// the last one. Until it observes one it reads the zero time.
+type SimClock struct {
+	now atomic.Int64 // ns since the epoch, 0 until an event is observed
+}
+
+func (c *SimClock) Observe(eventMs int64) {
+	if eventMs <= 0 {
+		return
+	}
+	ns := eventMs * int64(time.Millisecond)
+	for {
+		cur := c.now.Load()
+		if ns <= cur || c.now.CompareAndSwap(cur, ns) {
+			return
+		}
+	}
+}
+
+func (c *SimClock) Now() time.Time {
+	if ns := c.now.Load(); ns > 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,161 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+func TestClockSync(t *testing.T) {
+	const skew = 3 * time.Second
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/time" {
+			http.NotFound(w, r)
+			return
+		}
+		calls++
+		if calls == 2 {
+			http.Error(w, "busy", http.StatusTooManyRequests) // a failed sample is skipped
+			return
+		}
+		fmt.Fprintf(w, `{"serverTime":%d}`, time.Now().Add(skew).UnixMilli())
+	}))
+	defer srv.Close()
+
+	c := NewClockSync()
+	if c.Offset() != 0 || c.Uncertainty() != 0 {
+		t.Fatalf("offset %v uncertainty %v before syncing", c.Offset(), c.Uncertainty())
+	}
+	if err := c.Sync(context.Background(), srv.Client(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if calls != clockSyncSamples {
+		t.Errorf("%d requests, want %d", calls, clockSyncSamples)
+	}
+	if d := c.Offset() - skew; d < -5*time.Millisecond || d > 5*time.Millisecond {
+		t.Errorf("offset %v, want about %v", c.Offset(), skew)
+	}
+	if u := c.Uncertainty(); u < time.Millisecond/2 || u > 100*time.Millisecond {
+		t.Errorf("uncertainty %v", u)
+	}
+	now := time.Now()
+	if d := now.Sub(c.Local(now.Add(skew).UnixMilli())); d < -5*time.Millisecond || d > 5*time.Millisecond {
+		t.Errorf("an event stamped now on the exchange is %v old locally", d)
+	}
+
+	// An event received before it happened raises the estimate; one that
+	// arrived later than the estimate allows does not lower it
+	c.ObserveEvent(now.Add(skew+time.Second).UnixMilli(), now)
+	if got := c.Offset(); got < skew+time.Second-time.Millisecond || got > skew+time.Second {
+		t.Errorf("offset %v after an early event", got)
+	}
+	c.ObserveEvent(now.UnixMilli(), now)
+	if got := c.Offset(); got < skew+time.Second-time.Millisecond {
+		t.Errorf("offset %v fell after a late event", got)
+	}
+	// and a new sync starts the bound over
+	if err := c.Sync(context.Background(), srv.Client(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.Offset() - skew; d < -5*time.Millisecond || d > 5*time.Millisecond {
+		t.Errorf("offset %v after resyncing", c.Offset())
+	}
+
+	var none *ClockSync
+	none.ObserveEvent(now.UnixMilli(), now)
+	if none.Offset() != 0 || !none.Local(now.UnixMilli()).Equal(time.UnixMilli(now.UnixMilli())) {
+		t.Error("a nil ClockSync corrects")
+	}
+
+	srv.Close()
+	if err := c.Sync(context.Background(), srv.Client(), srv.URL); err == nil {
+		t.Error("synced against a closed server")
+	}
+}
+
+func TestClockCorrectsEndToEnd(t *testing.T) {
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, quietAlerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.clock.rest.Store(int64(time.Hour)) // the exchange is an hour ahead
+	if got := m.Snapshot().ClockOffsetMs; got != 3600000 {
+		t.Errorf("clock_offset_ms %v", got)
+	}
+	done := m.Run()
+	now := time.Now()
+	m.Shards.Push([]byte("btcusdt"), &feed.Msg{Symbol: "btcusdt", TradeID: 1, Price: 100, Quantity: 1,
+		EventMs: now.Add(time.Hour - 20*time.Millisecond).UnixMilli(), Received: now, Parsed: now})
+	m.Shards.Close()
+	<-done
+	if e2e := m.Snapshot().EndToEnd; e2e.P50 < 19 || e2e.P50 > 1000 {
+		t.Errorf("end-to-end latency %+v, want about 20ms", e2e)
+	}
+}
+
+func TestSimClock(t *testing.T) {
+	c := &SimClock{}
+	if !c.Now().IsZero() {
+		t.Errorf("Now %v before any event", c.Now())
+	}
+	c.Observe(5000)
+	c.Observe(0) // no event time
+	c.Observe(4000)
+	if !c.Now().Equal(time.UnixMilli(5000)) {
+		t.Errorf("Now %v, want the latest event time", c.Now())
+	}
+}
+
+func TestEventClockReplaysDeterministically(t *testing.T) {
+	// A trade every 5s over four minutes, for two symbols
+	var capture []byte
+	for i := 0; i < 48; i++ {
+		ms := int64(1700000000000 + i*5000)
+		for _, sym := range []string{"BTCUSDT", "ETHUSDT"} {
+			capture = fmt.Appendf(capture, `{"e":"aggTrade","E":%d,"s":"%s","a":%d,"p":"%d","q":"1","T":%d,"m":%v}`+"\n", ms, sym, i+1, 100+i%7, ms, i%3 == 0)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, capture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	replay := func(shards int, speed float64) ([]Candle, map[string]float64) {
+		opts := PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: shards, FeedQueue: 4, Limits: DefaultSymbolLimits, quietAlerts: true}
+		opts.resolveClock(true)
+		m, err := NewMonitor(time.Now(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		done := m.Run()
+		if err := ReplayCapture(context.Background(), m, path, speed); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+		state, _ := m.Symbols.Get("ethusdt")
+		return state.Candles.History(), state.Signals.Snapshot()
+	}
+	candles, values := replay(1, 0)
+	if len(candles) != 4 || !candles[0].OpenTime.Equal(time.UnixMilli(1700000000000).Truncate(time.Minute)) {
+		t.Fatalf("candles bucketed by event time: %+v", candles)
+	}
+	// however the symbols are sharded and however fast the capture goes
+	again, againValues := replay(2, 1e6)
+	if !slices.Equal(candles, again) {
+		t.Errorf("candles differ between replays:\n%+v\n%+v", candles, again)
+	}
+	if !maps.Equal(values, againValues) {
+		t.Errorf("signals differ between replays:\n%v\n%v", values, againValues)
+	}
+}
@@ -0,0 +1,434 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A cluster spreads the symbols of a large watch list over several monitor
+// instances. Each instance owns a subset, picked with --cluster-shard from
+// a --symbol list they all share or given to it directly, and a gateway
+// routes API queries for a symbol to the instance that owns it. The gateway
+// learns the owners from the instances it is given, which it polls, and
+// from instances that register themselves with --cluster-registry.
+
+// clusterRenewEvery is how often an instance renews its registration with
+// the gateway, whose TTL should be a few times longer.
+const clusterRenewEvery = 10 * time.Second
+
+// clusterHTTPClient carries registrations and the gateway's polls.
+var clusterHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// clusterShardSymbols keeps the symbols that hash onto instance i of n,
+// given as "i/n". The hash is ShardIndex's, so every instance given the
+// same list agrees on who owns what.
+func clusterShardSymbols(symbols []string, shard string) ([]string, error) {
+	is, ns, ok := strings.Cut(shard, "/")
+	i, err1 := strconv.Atoi(is)
+	n, err2 := strconv.Atoi(ns)
+	if !ok || err1 != nil || err2 != nil || n <= 0 || i < 0 || i >= n {
+		return nil, fmt.Errorf("invalid --cluster-shard %q: want i/n with 0 <= i < n", shard)
+	}
+	var owned []string
+	for _, sym := range symbols {
+		if ShardIndex(sym, n) == i {
+			owned = append(owned, sym)
+		}
+	}
+	if len(owned) == 0 {
+		return nil, fmt.Errorf("cluster shard %s owns none of the %d symbols", shard, len(symbols))
+	}
+	return owned, nil
+}
+
+// clusterRegistration is what an instance posts to the gateway.
+type clusterRegistration struct {
+	URL     string   `json:"url"`
+	Symbols []string `json:"symbols,omitempty"`
+}
+
+// advertisedURL is the API URL an instance listening on addr registers
+// under, when --cluster-advertise does not give one.
+func advertisedURL(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid --api-addr %q: %w", addr, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
+
+// JoinCluster registers the instance at self, owning symbols, with the
+// gateway at registry, and renews the registration every interval until
+// ctx is done; it then deregisters. A registration the gateway has not
+// heard renewed within its TTL lapses, so an instance that dies without
+// deregistering stops receiving queries.
+func JoinCluster(ctx context.Context, client *http.Client, registry, self string, symbols []string, every time.Duration) {
+	clusterLog := logger("cluster")
+	post := func(ctx context.Context, path string, reg clusterRegistration) error {
+		body, _ := json.Marshal(reg)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(registry, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("cluster %s: %s", strings.TrimPrefix(path, "/cluster/"), resp.Status)
+		}
+		return nil
+	}
+
+	failing := false
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		err := post(ctx, "/cluster/register", clusterRegistration{URL: self, Symbols: symbols})
+		switch {
+		case err != nil && ctx.Err() == nil && !failing:
+			clusterLog.Warn("failed to register with the cluster gateway", "registry", registry, "err", err)
+			failing = true
+		case err == nil && failing:
+			clusterLog.Info("registered with the cluster gateway again", "registry", registry)
+			failing = false
+		}
+		select {
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := post(leaveCtx, "/cluster/deregister", clusterRegistration{URL: self}); err != nil {
+				clusterLog.Warn("failed to deregister from the cluster gateway", "err", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ClusterMember is a monitor instance known to the gateway.
+type ClusterMember struct {
+	URL     string   `json:"url"`
+	Symbols []string `json:"symbols"`
+	// Static members were given to the gateway, which polls their
+	// /symbols; the others registered themselves
+	Static   bool      `json:"static"`
+	Up       bool      `json:"up"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	proxy    *httputil.ReverseProxy
+}
+
+// ClusterGateway routes per-symbol API queries to the instance that owns
+// the symbol.
+type ClusterGateway struct {
+	mu      sync.Mutex
+	members map[string]*ClusterMember
+	ttl     time.Duration
+	client  *http.Client
+	now     func() time.Time
+}
+
+// NewClusterGateway starts a gateway that knows the instances at static.
+// Registered instances lapse after ttl without a renewal.
+func NewClusterGateway(static []string, ttl time.Duration, client *http.Client) (*ClusterGateway, error) {
+	g := &ClusterGateway{members: make(map[string]*ClusterMember), ttl: ttl, client: client, now: time.Now}
+	for _, u := range static {
+		m, err := newClusterMember(u)
+		if err != nil {
+			return nil, err
+		}
+		m.Static = true
+		g.members[m.URL] = m
+	}
+	return g, nil
+}
+
+func newClusterMember(raw string) (*ClusterMember, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid instance URL %q", raw)
+	}
+	return &ClusterMember{URL: u.String(), proxy: httputil.NewSingleHostReverseProxy(u)}, nil
+}
+
+// Register records that the instance at u owns symbols, until it lapses.
+func (g *ClusterGateway) Register(u string, symbols []string) error {
+	m, err := newClusterMember(u)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if known, ok := g.members[m.URL]; ok {
+		m = known
+	} else {
+		logger("cluster").Info("instance joined", "url", m.URL, "symbols", len(symbols))
+		g.members[m.URL] = m
+	}
+	m.Symbols = normalizeSymbols(symbols)
+	m.Up, m.LastSeen = true, g.now()
+	return nil
+}
+
+// Deregister forgets a registered instance at once.
+func (g *ClusterGateway) Deregister(u string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	u = strings.TrimSuffix(u, "/")
+	if m, ok := g.members[u]; ok && !m.Static {
+		delete(g.members, u)
+		logger("cluster").Info("instance left", "url", u)
+	}
+}
+
+func normalizeSymbols(symbols []string) []string {
+	out := splitList(strings.ToLower(strings.Join(symbols, ",")))
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// live reports whether m is taking queries. Lapsed registrations are
+// removed; the caller holds g.mu.
+func (g *ClusterGateway) live(m *ClusterMember) bool {
+	if !m.Static && g.now().Sub(m.LastSeen) > g.ttl {
+		logger("cluster").Warn("instance registration lapsed", "url", m.URL, "last_seen", m.LastSeen)
+		delete(g.members, m.URL)
+		return false
+	}
+	return m.Up
+}
+
+// Owner returns the instance that owns symbol. Should two claim it, the one
+// with the lower URL does, so every query goes to the same one.
+func (g *ClusterGateway) Owner(symbol string) (*ClusterMember, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	symbol = strings.ToLower(symbol)
+	var owner *ClusterMember
+	for _, m := range g.members {
+		if g.live(m) && slices.Contains(m.Symbols, symbol) && (owner == nil || m.URL < owner.URL) {
+			owner = m
+		}
+	}
+	return owner, owner != nil
+}
+
+// Members lists the instances by URL.
+func (g *ClusterGateway) Members() []ClusterMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]ClusterMember, 0, len(g.members))
+	for _, m := range g.members {
+		g.live(m)
+	}
+	for _, m := range g.members {
+		c := *m
+		c.Symbols = slices.Clone(m.Symbols)
+		c.proxy = nil
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b ClusterMember) int { return strings.Compare(a.URL, b.URL) })
+	return out
+}
+
+// Poll asks every static instance for its symbols. One that does not
+// answer is marked down, and its symbols are unrouted until it does.
+func (g *ClusterGateway) Poll(ctx context.Context) {
+	var static []string
+	g.mu.Lock()
+	for _, m := range g.members {
+		if m.Static {
+			static = append(static, m.URL)
+		}
+	}
+	g.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, u := range static {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			var symbols []string
+			err := g.get(ctx, u+"/symbols", &symbols)
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			m := g.members[u]
+			switch {
+			case err != nil && m.Up:
+				logger("cluster").Warn("instance is down", "url", u, "err", err)
+			case err == nil && !m.Up:
+				logger("cluster").Info("instance is up", "url", u, "symbols", len(symbols))
+			}
+			m.Up = err == nil
+			if err == nil {
+				m.Symbols, m.LastSeen = normalizeSymbols(symbols), g.now()
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+func (g *ClusterGateway) get(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Run polls the static instances every interval until ctx is done.
+func (g *ClusterGateway) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		g.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// clusterSymbolRoutes are the API paths whose segment after the prefix is a
+// symbol.
+var clusterSymbolRoutes = []string{"/book/", "/queue/", "/trades/", "/signals/", "/snapshot/", "/funding/"}
+
+// Handler serves the gateway's API:
+//
+//	GET  /cluster             the instances and the symbols each owns
+//	POST /cluster/register    {"url", "symbols"}: an instance joins or renews
+//	POST /cluster/deregister  {"url"}: an instance leaves
+//	GET  /symbols             every symbol the cluster owns
+//	GET  /stats               each instance's /stats, by URL
+//
+// /book/, /queue/, /trades/, /signals/, /snapshot/ and /funding/ queries
+// for a symbol are proxied to its owner, and so are /ws and /arrow/ streams
+// whose ?symbols= are all owned by one instance.
+func (g *ClusterGateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cluster", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, g.Members())
+	})
+	mux.HandleFunc("/cluster/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var reg clusterRegistration
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&reg); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid registration: "+err.Error())
+			return
+		}
+		switch r.URL.Path {
+		case "/cluster/register":
+			if err := g.Register(reg.URL, reg.Symbols); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		case "/cluster/deregister":
+			g.Deregister(reg.URL)
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/symbols", func(w http.ResponseWriter, r *http.Request) {
+		symbols := []string{}
+		for _, m := range g.Members() {
+			if m.Up {
+				symbols = append(symbols, m.Symbols...)
+			}
+		}
+		writeJSON(w, normalizeSymbols(symbols))
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		members := g.Members()
+		stats := make(map[string]json.RawMessage, len(members))
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, m := range members {
+			if !m.Up {
+				continue
+			}
+			wg.Add(1)
+			go func(u string) {
+				defer wg.Done()
+				var s json.RawMessage
+				if err := g.get(r.Context(), u+"/stats", &s); err != nil {
+					s, _ = json.Marshal(map[string]string{"error": err.Error()})
+				}
+				mu.Lock()
+				stats[u] = s
+				mu.Unlock()
+			}(m.URL)
+		}
+		wg.Wait()
+		writeJSON(w, stats)
+	})
+	for _, prefix := range clusterSymbolRoutes {
+		prefix := prefix
+		mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+			symbol, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+			g.route(w, r, []string{symbol})
+		})
+	}
+	stream := func(w http.ResponseWriter, r *http.Request) {
+		symbols := splitList(r.URL.Query().Get("symbols"))
+		if len(symbols) == 0 {
+			writeError(w, http.StatusBadRequest, "the gateway needs ?symbols= to route a stream")
+			return
+		}
+		g.route(w, r, symbols)
+	}
+	mux.HandleFunc("/ws", stream)
+	mux.HandleFunc("/arrow/", stream)
+	return mux
+}
+
+// route proxies r to the one instance that owns every symbol.
+func (g *ClusterGateway) route(w http.ResponseWriter, r *http.Request, symbols []string) {
+	var owner *ClusterMember
+	for _, sym := range symbols {
+		m, ok := g.Owner(sym)
+		switch {
+		case !ok:
+			writeError(w, http.StatusNotFound, "no instance owns symbol "+strconv.Quote(sym))
+			return
+		case owner != nil && m != owner:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("symbols %s are owned by different instances; stream them from each", strings.Join(symbols, ",")))
+			return
+		}
+		owner = m
+	}
+	owner.proxy.ServeHTTP(w, r)
+}
@@ -0,0 +1,116 @@
+package apexlob
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClusterShardSymbols(t *testing.T) {
+	symbols := []string{"btcusdt", "ethusdt", "bnbusdt", "solusdt", "xrpusdt", "adausdt", "dogeusdt"}
+	var all []string
+	for i := 0; i < 3; i++ {
+		owned, err := clusterShardSymbols(symbols, strconv.Itoa(i)+"/3")
+		if err != nil {
+			continue // a shard may own none of so few symbols
+		}
+		all = append(all, owned...)
+	}
+	slices.Sort(all)
+	want := slices.Clone(symbols)
+	slices.Sort(want)
+	if !slices.Equal(all, want) {
+		t.Errorf("shards own %v between them, want each of %v once", all, want)
+	}
+	for _, bad := range []string{"3/3", "-1/2", "1", "a/b", "0/0"} {
+		if _, err := clusterShardSymbols(symbols, bad); err == nil {
+			t.Errorf("accepted --cluster-shard %s", bad)
+		}
+	}
+}
+
+func TestClusterGateway(t *testing.T) {
+	newInstance := func(sym string) *httptest.Server {
+		symbols := NewSymbolRegistry()
+		symbols.Add(NewSymbolState(sym))
+		srv := httptest.NewServer(NewAPIServer(symbols, func() StatsSnapshot { return StatsSnapshot{TotalMessages: len(sym)} }))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	static, registered := newInstance("btcusdt"), newInstance("ethusdt")
+
+	g, err := NewClusterGateway([]string{static.URL}, time.Minute, clusterHTTPClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	gw := httptest.NewServer(g.Handler())
+	defer gw.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	joined := make(chan struct{})
+	go func() {
+		defer close(joined)
+		JoinCluster(ctx, clusterHTTPClient, gw.URL, registered.URL, []string{"ethusdt"}, time.Hour)
+	}()
+	g.Poll(context.Background())
+	for {
+		if _, ok := g.Owner("ethusdt"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var symbols []string
+	if code := getJSON(t, gw.Config.Handler, "/symbols", &symbols); code != http.StatusOK || !slices.Equal(symbols, []string{"btcusdt", "ethusdt"}) {
+		t.Errorf("/symbols: %d %v", code, symbols)
+	}
+	for sym, owner := range map[string]string{"btcusdt": static.URL, "ETHUSDT": registered.URL} {
+		resp, err := http.Get(gw.URL + "/signals/" + sym)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if m, _ := g.Owner(sym); resp.StatusCode != http.StatusOK || m.URL != owner {
+			t.Errorf("/signals/%s: %s, routed to %v", sym, resp.Status, m)
+		}
+	}
+	if code := getJSON(t, gw.Config.Handler, "/book/solusdt", nil); code != http.StatusNotFound {
+		t.Errorf("unowned symbol: %d", code)
+	}
+	if code := getJSON(t, gw.Config.Handler, "/ws?symbols=btcusdt,ethusdt", nil); code != http.StatusBadRequest {
+		t.Errorf("stream across instances: %d", code)
+	}
+	var stats map[string]StatsSnapshot
+	getJSON(t, gw.Config.Handler, "/stats", &stats)
+	if stats[static.URL].TotalMessages != 7 || stats[registered.URL].TotalMessages != 7 {
+		t.Errorf("/stats: %+v", stats)
+	}
+
+	// Leaving deregisters at once; a static instance that stops answering
+	// is marked down
+	cancel()
+	<-joined
+	if _, ok := g.Owner("ethusdt"); ok {
+		t.Error("ethusdt still routed after its instance left")
+	}
+	static.Close()
+	g.Poll(context.Background())
+	var members []ClusterMember
+	getJSON(t, gw.Config.Handler, "/cluster", &members)
+	if len(members) != 1 || members[0].URL != static.URL || members[0].Up || !members[0].Static {
+		t.Errorf("/cluster: %+v", members)
+	}
+
+	// Registrations that are not renewed lapse
+	g.Register(registered.URL, []string{"ethusdt"})
+	now = now.Add(2 * time.Minute)
+	if _, ok := g.Owner("ethusdt"); ok {
+		t.Error("lapsed registration still routed")
+	}
+}
@@ -0,0 +1,16 @@
+// Command apexlob monitors, records and replays exchange order books.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"apexlob"
+)
+
+func main() {
+	if err := apexlob.NewRootCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "apexlob:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,74 @@
+package apexlob
+
+import (
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiReverse = "\x1b[7m"
+)
+
+// palette colours display text with ANSI escapes, or leaves it alone when
+// colour is off.
+type palette bool
+
+// colorWanted reports whether output should be coloured: not with
+// --no-color, nor when NO_COLOR is set (https://no-color.org).
+func colorWanted(noColor bool) palette {
+	return palette(!noColor && os.Getenv("NO_COLOR") == "")
+}
+
+func (p palette) wrap(code, s string) string {
+	if !p || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+func (p palette) up(s string) string        { return p.wrap(ansiGreen, s) }
+func (p palette) down(s string) string      { return p.wrap(ansiRed, s) }
+func (p palette) warn(s string) string      { return p.wrap(ansiYellow, s) }
+func (p palette) highlight(s string) string { return p.wrap(ansiReverse, s) }
+
+// tick colours s green when price rose from prev, red when it fell.
+func (p palette) tick(s string, price, prev float64) string {
+	switch {
+	case prev == 0 || price == prev:
+		return s
+	case price > prev:
+		return p.up(s)
+	}
+	return p.down(s)
+}
+
+// visibleWidth is the number of characters s takes on screen, skipping
+// ANSI escape sequences.
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			if j := strings.IndexByte(s[i:], 'm'); j >= 0 {
+				i += j + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// padRight pads s with spaces to width visible characters.
+func padRight(s string, width int) string {
+	if n := visibleWidth(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestPalette(t *testing.T) {
+	on, off := palette(true), palette(false)
+	if got := on.tick("101", 101, 100); got != ansiGreen+"101"+ansiReset {
+		t.Errorf("uptick = %q, want green", got)
+	}
+	if got := on.tick("99", 99, 100); got != ansiRed+"99"+ansiReset {
+		t.Errorf("downtick = %q, want red", got)
+	}
+	if got := on.tick("100", 100, 100); got != "100" {
+		t.Errorf("unchanged price = %q, want plain", got)
+	}
+	if got := off.warn("STALE"); got != "STALE" {
+		t.Errorf("colour off = %q, want plain", got)
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if colorWanted(false) {
+		t.Error("NO_COLOR should turn colour off")
+	}
+	t.Setenv("NO_COLOR", "")
+	if !colorWanted(false) || colorWanted(true) {
+		t.Error("--no-color alone should decide when NO_COLOR is unset")
+	}
+}
+
+func TestPadRightSkipsEscapes(t *testing.T) {
+	s := padRight(palette(true).up("bid"), 6)
+	if visibleWidth(s) != 6 || !strings.HasSuffix(s, ansiReset+"   ") {
+		t.Errorf("padRight = %q, want the escapes not counted", s)
+	}
+}
+
+func TestDashboardColors(t *testing.T) {
+	d, symbols := newTestDashboard()
+	d.color = true
+	state, _ := symbols.Get("btcusdt")
+	now := time.Now()
+	for _, tr := range []orderbook.Trade{
+		{Price: 100.5, Quantity: 0.1, Side: orderbook.Buy},
+		{Price: 100.25, Quantity: 0.1, Side: orderbook.Sell},
+		{Price: 100.75, Quantity: 5, Side: orderbook.Buy},
+	} {
+		tr.Symbol, tr.Timestamp = "btcusdt", now
+		state.Tape.Add(tr)
+	}
+
+	frame := d.Frame()
+	for _, want := range []string{
+		ansiRed + "ask ",           // asks red
+		ansiGreen + "bid ",         // bids green
+		ansiGreen + "      100.50", // uptick on the tape
+		ansiRed + "      100.25",   // downtick
+		ansiReverse + now.Format("15:04:05") + " BUY        100.75", // the 5 lot trade is large
+	} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame missing %q:\n%q", want, frame)
+		}
+	}
+
+	// A feed that stops moving is flagged
+	d.seenAt = time.Now().Add(-feedStaleAfter)
+	if frame := d.Frame(); !strings.Contains(frame, ansiYellow+"[STALE") {
+		t.Errorf("frame does not warn of a stale feed:\n%q", frame)
+	}
+}
@@ -0,0 +1,137 @@
+package apexlob
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// Defaults of the console event stream, unless --events-per-minute and
+// --large-trade-factor set others.
+const (
+	DefaultConsoleEventRate   = 30
+	DefaultLargeTradeFactor   = 10
+	consoleEventsKept         = 50
+	largeTradeWarmup          = 20   // trades averaged before any is large
+	largeTradeSmoothing       = 0.02 // weight of a trade in the average
+	consoleEventsSubscription = 4096
+)
+
+// ConsoleEvents is the console's stream of notable events: large trades,
+// alerts and feed reconnects, one line each. It prints them above the
+// status line, or keeps them for the dashboard's events pane, at no more
+// than a set rate a minute; the next line printed counts the ones held
+// back in between.
+type ConsoleEvents struct {
+	mu         sync.Mutex
+	limiter    *tokenBucket
+	suppressed int
+	lines      []string     // newest last
+	print      func(string) // nil when the lines are only kept
+	factor     float64
+	sizes      map[string]*tradeSizeAverage
+}
+
+// tradeSizeAverage is a symbol's smoothed trade notional.
+type tradeSizeAverage struct {
+	mean   float64
+	trades int
+}
+
+// NewConsoleEvents passes perMinute events a minute to print, nil to only
+// keep them, and calls a trade large at factor times its symbol's average
+// notional.
+func NewConsoleEvents(perMinute, factor float64, print func(string)) *ConsoleEvents {
+	return &ConsoleEvents{limiter: newTokenBucket(perMinute), print: print, factor: factor, sizes: make(map[string]*tradeSizeAverage)}
+}
+
+// Add records an event of kind on symbol, dropping it if over the rate.
+func (c *ConsoleEvents) Add(at time.Time, kind, symbol, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.limiter.allow(time.Now()) {
+		c.suppressed++
+		return
+	}
+	line := fmt.Sprintf("%s %-9s %s", at.Format("15:04:05"), kind, text)
+	if symbol != "" {
+		line = fmt.Sprintf("%s %-9s %s %s", at.Format("15:04:05"), kind, strings.ToUpper(symbol), text)
+	}
+	if c.suppressed > 0 {
+		line += fmt.Sprintf(" (%d more events held back)", c.suppressed)
+		c.suppressed = 0
+	}
+	if len(c.lines) == consoleEventsKept {
+		c.lines = append(c.lines[:0], c.lines[1:]...)
+	}
+	c.lines = append(c.lines, line)
+	if c.print != nil {
+		c.print(line)
+	}
+}
+
+// Recent returns up to n of the latest lines, oldest first.
+func (c *ConsoleEvents) Recent(n int) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines[max(len(c.lines)-n, 0):]...)
+}
+
+// Trade adds tr if it is large for its symbol, then counts it towards the
+// symbol's average.
+func (c *ConsoleEvents) Trade(tr *orderbook.Trade) {
+	notional := tr.Price * tr.Quantity
+	c.mu.Lock()
+	avg, ok := c.sizes[tr.Symbol]
+	if !ok {
+		avg = &tradeSizeAverage{}
+		c.sizes[tr.Symbol] = avg
+	}
+	large := avg.trades >= largeTradeWarmup && avg.mean > 0 && notional >= c.factor*avg.mean
+	ratio := notional / avg.mean
+	if avg.trades++; avg.trades == 1 {
+		avg.mean = notional
+	} else {
+		avg.mean += largeTradeSmoothing * (notional - avg.mean)
+	}
+	c.mu.Unlock()
+	if large {
+		c.Add(tr.Timestamp, "TRADE", tr.Symbol, fmt.Sprintf("large %s %s @ %s, %s notional, %.0fx the average",
+			tr.Side, botNumber(tr.Quantity), botNumber(tr.Price), botNumber(notional), ratio))
+	}
+}
+
+// Alert adds an alert event.
+func (c *ConsoleEvents) Alert(e AlertEvent) {
+	text := fmt.Sprintf("%s %s: %s", strings.ToUpper(e.Severity), e.Rule, e.Expr)
+	if e.Escalated {
+		text += ", escalated"
+	}
+	c.Add(e.Timestamp, "ALERT", e.Symbol, text)
+}
+
+// WatchConsoleEvents feeds c from m's trades, alerts and reconnects and
+// returns a function that stops it.
+func (m *Monitor) WatchConsoleEvents(c *ConsoleEvents) (stop func()) {
+	m.consoleEvents.Store(c)
+	for _, rules := range m.Rules {
+		rules.OnAlert(c.Alert)
+	}
+	events, unsubscribe := m.Bus.SubscribeAs("console-events", consoleEventsSubscription, nil, []EventType{EventTrade})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range events {
+			c.Trade(e.Trade)
+			e.Release()
+		}
+	}()
+	return func() {
+		m.consoleEvents.Store(nil)
+		unsubscribe()
+		<-done
+	}
+}
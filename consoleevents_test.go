@@ -0,0 +1,60 @@
+package apexlob
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestConsoleEvents(t *testing.T) {
+	var printed []string
+	c := NewConsoleEvents(3, 10, func(line string) { printed = append(printed, line) })
+	at := time.Date(2024, 1, 2, 15, 4, 5, 0, time.Local)
+	for i := 0; i < largeTradeWarmup; i++ {
+		c.Trade(&orderbook.Trade{Symbol: "btcusdt", Price: 100, Quantity: 1, Timestamp: at})
+	}
+	if len(printed) != 0 {
+		t.Fatalf("ordinary trades printed: %q", printed)
+	}
+	c.Trade(&orderbook.Trade{Symbol: "btcusdt", Price: 100, Quantity: 12, Side: orderbook.Sell, Timestamp: at})
+	c.Alert(AlertEvent{Rule: "wide", Symbol: "btcusdt", Expr: "spread_bps > 5", Severity: "critical", Escalated: true, Timestamp: at})
+	c.Add(at, "RECONNECT", "", "feed reconnected")
+	want := []string{
+		"15:04:05 TRADE     BTCUSDT large SELL 12 @ 100, 1200 notional, 12x the average",
+		"15:04:05 ALERT     BTCUSDT CRITICAL wide: spread_bps > 5, escalated",
+		"15:04:05 RECONNECT feed reconnected",
+	}
+	if strings.Join(printed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("printed\n%s\nwant\n%s", strings.Join(printed, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Past the rate, events are held back and counted on the next line
+	c.Add(at, "RECONNECT", "", "again")
+	c.Add(at, "RECONNECT", "", "and again")
+	if len(printed) != 3 {
+		t.Errorf("printed over the rate: %q", printed[3:])
+	}
+	c.limiter.tokens = 1
+	c.Add(at, "ALERT", "ethusdt", "INFO flow: ofi_1m > 0.8")
+	if got := printed[len(printed)-1]; !strings.HasSuffix(got, "(2 more events held back)") {
+		t.Errorf("after holding back: %q", got)
+	}
+	if got := c.Recent(2); len(got) != 2 || got[0] != want[2] {
+		t.Errorf("recent = %q", got)
+	}
+}
+
+func TestConsolePrintln(t *testing.T) {
+	var out bytes.Buffer
+	c := NewConsole(&out, io.Discard)
+	c.Println("first")
+	c.Status("status")
+	c.Println("second")
+	if got := out.String(); got != "first\n\rstatus\r\x1b[Ksecond\n\rstatus" {
+		t.Errorf("console output %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package apexlob
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+)
+
+// NewDebugHandler serves net/http/pprof under /debug/pprof/ and expvar
+// (including the runtime's memstats) under /debug/vars. It is meant for a
+// separate, non-public listener.
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+var debugVarsOnce sync.Once
+
+// PublishDebugVars registers the monitor's expvars: message counters, event
+// bus queue depths and a heap summary. expvar names are process-global, so
+// only the first call has any effect.
+func PublishDebugVars(stats func() StatsSnapshot, bus *EventBus) {
+	debugVarsOnce.Do(func() {
+		expvar.Publish("apexlob_stats", expvar.Func(func() interface{} { return stats() }))
+		expvar.Publish("apexlob_queues", expvar.Func(func() interface{} { return bus.QueueStats() }))
+		expvar.Publish("apexlob_heap", expvar.Func(heapSummary))
+	})
+}
+
+func heapSummary() interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return map[string]uint64{
+		"heap_alloc_bytes":  m.HeapAlloc,
+		"heap_inuse_bytes":  m.HeapInuse,
+		"heap_objects":      m.HeapObjects,
+		"total_alloc_bytes": m.TotalAlloc,
+		"sys_bytes":         m.Sys,
+		"num_gc":            uint64(m.NumGC),
+		"pause_total_ns":    m.PauseTotalNs,
+		"goroutines":        uint64(runtime.NumGoroutine()),
+	}
+}
@@ -0,0 +1,52 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	bus := NewEventBus()
+	_, cancel := bus.SubscribeAs("sink:test", 4, nil, nil)
+	defer cancel()
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt"})
+	PublishDebugVars(func() StatsSnapshot { return StatsSnapshot{TotalMessages: 12} }, bus)
+
+	srv := httptest.NewServer(NewDebugHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		Stats    StatsSnapshot     `json:"apexlob_stats"`
+		Queues   []QueueStat       `json:"apexlob_queues"`
+		Heap     map[string]uint64 `json:"apexlob_heap"`
+		MemStats json.RawMessage   `json:"memstats"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Stats.TotalMessages != 12 {
+		t.Errorf("stats = %+v", vars.Stats)
+	}
+	if len(vars.Queues) != 1 || vars.Queues[0] != (QueueStat{Name: "sink:test", Depth: 1, Capacity: 4}) {
+		t.Errorf("queues = %+v", vars.Queues)
+	}
+	if vars.Heap["heap_alloc_bytes"] == 0 || vars.Heap["goroutines"] == 0 || len(vars.MemStats) == 0 {
+		t.Errorf("heap = %v", vars.Heap)
+	}
+
+	resp, err = http.Get(srv.URL + "/debug/pprof/goroutine?debug=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("pprof status = %d", resp.StatusCode)
+	}
+}
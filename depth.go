@@ -0,0 +1,321 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+// DepthSnapshot is a full book as served by Binance's REST depth endpoint.
+// Captures recorded with depth hold one per mirrored symbol as a
+// "depthSnapshot" event, so a replay can start from it too.
+type DepthSnapshot struct {
+	Symbol       string      `json:"s"`
+	LastUpdateID uint64      `json:"lastUpdateId"`
+	Bids         [][2]string `json:"bids"`
+	Asks         [][2]string `json:"asks"`
+}
+
+const binanceRESTURL = "https://api.binance.com"
+
+// depthSnapshotLimit is how many levels a side of a fetched snapshot holds,
+// the most the endpoint serves.
+const depthSnapshotLimit = 5000
+
+// FetchDepthSnapshot gets symbol's book from the REST API at baseURL,
+// giving up when ctx is done.
+func FetchDepthSnapshot(ctx context.Context, client *http.Client, baseURL, symbol string) (DepthSnapshot, error) {
+	url := fmt.Sprintf("%s/api/v3/depth?symbol=%s&limit=%d", baseURL, strings.ToUpper(symbol), depthSnapshotLimit)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return DepthSnapshot{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return DepthSnapshot{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return DepthSnapshot{}, fmt.Errorf("depth snapshot for %s: %s", symbol, resp.Status)
+	}
+	var snap DepthSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		return DepthSnapshot{}, fmt.Errorf("depth snapshot for %s: %w", symbol, err)
+	}
+	snap.Symbol = strings.ToUpper(symbol)
+	return snap, nil
+}
+
+// MarshalCapture encodes s as a capture line.
+func (s DepthSnapshot) MarshalCapture() ([]byte, error) {
+	return json.Marshal(struct {
+		Event string `json:"e"`
+		DepthSnapshot
+	}{"depthSnapshot", s})
+}
+
+// maxPendingDepth bounds the updates a symbol buffers while it waits for a
+// snapshot; beyond it the oldest are dropped, and the snapshot that
+// eventually arrives is refetched if they turn out to be needed.
+const maxPendingDepth = 1000
+
+// depthSync keeps the mirrored books in step with the exchange from the
+// feed reader. It follows Binance's procedure for a local book: buffer the
+// diff stream, fetch a snapshot, drop the updates it already contains and
+// apply the rest, each of which must start right after the one before.
+// A gap means an update was lost, and the book is fetched again. Without a
+// fetcher, as when replaying a capture, books start from a depthSnapshot
+// event in the capture or else from the first update, and gaps are only
+// logged. Only the feed reader may call its methods.
+type depthSync struct {
+	shards    *ShardSet
+	wal       *WALWriter
+	books     map[string]*depthBook // mirrored symbols only
+	fetch     func(ctx context.Context, symbol string) (DepthSnapshot, error)
+	snapshots chan DepthSnapshot
+	ctx       context.Context // ends the fetches
+	update    feed.DepthUpdate
+	msgs      []feed.Msg // one update's levels, parsed before any is pushed
+	gaps      *Counter   // nil when they are not counted
+}
+
+type depthBook struct {
+	synced   bool
+	lastID   uint64   // final update ID of the last update applied
+	fetching bool     // a snapshot has been requested
+	pending  [][]byte // updates received while waiting for it
+}
+
+func newDepthSync(ctx context.Context, shards *ShardSet, wal *WALWriter, symbols []string, fetch func(context.Context, string) (DepthSnapshot, error)) *depthSync {
+	d := &depthSync{
+		shards:    shards,
+		wal:       wal,
+		books:     make(map[string]*depthBook, len(symbols)),
+		fetch:     fetch,
+		snapshots: make(chan DepthSnapshot, len(symbols)),
+		ctx:       ctx,
+	}
+	for _, sym := range symbols {
+		d.books[sym] = &depthBook{}
+	}
+	return d
+}
+
+// poll applies the snapshots fetched since the last call.
+func (d *depthSync) poll(received time.Time) {
+	for {
+		select {
+		case snap := <-d.snapshots:
+			if err := d.applySnapshot(snap, received); err != nil {
+				logger("depth").Error("dropping depth snapshot", "symbol", snap.Symbol, "err", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// requestSnapshot fetches sym's book in the background, retrying until it
+// succeeds or the feed stops. One symbol has at most one fetch under way,
+// so delivering it never blocks.
+func (d *depthSync) requestSnapshot(sym string, book *depthBook) {
+	if d.fetch == nil || book.fetching {
+		return
+	}
+	book.fetching = true
+	go func() {
+		backoff := time.Second
+		for {
+			snap, err := d.fetch(d.ctx, sym)
+			if err == nil {
+				d.snapshots <- snap
+				return
+			}
+			if d.ctx.Err() != nil {
+				return
+			}
+			logger("depth").Warn("depth snapshot failed", "symbol", sym, "err", err, "retry_in", backoff)
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+// ingestUpdate handles a depthUpdate message read at received.
+func (d *depthSync) ingestUpdate(msg []byte, received time.Time) error {
+	if err := feed.ParseDepthUpdate(msg, &d.update); err != nil {
+		return err
+	}
+	u := &d.update
+	sym, ok := d.shards.Canonical(u.Symbol)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownSymbol, u.Symbol)
+	}
+	book, ok := d.books[sym]
+	if !ok {
+		return nil // a synthetic book has no use for depth
+	}
+	switch {
+	case !book.synced && d.fetch != nil:
+		d.buffer(sym, book, msg)
+		return nil
+	case !book.synced:
+		// Replaying without a snapshot: levels appear as they change
+		d.msgs = append(d.msgs[:0], feed.Msg{Kind: feed.KindReset, Symbol: sym, EventMs: u.EventMs, Received: received, Parsed: time.Now()})
+		d.pushMsgs()
+		book.synced = true
+	case u.FinalUpdateID <= book.lastID:
+		return nil
+	case u.FirstUpdateID > book.lastID+1:
+		logger("depth").Warn("gap in depth updates", "symbol", sym, "expected", book.lastID+1, "got", u.FirstUpdateID)
+		if d.gaps != nil {
+			d.gaps.Inc()
+		}
+		if d.fetch != nil {
+			book.synced = false
+			d.buffer(sym, book, msg)
+			return nil
+		}
+	}
+	if err := d.pushUpdate(sym, received); err != nil {
+		return err
+	}
+	book.lastID = u.FinalUpdateID
+	return nil
+}
+
+// buffer keeps a copy of an update until sym's snapshot arrives.
+func (d *depthSync) buffer(sym string, book *depthBook, msg []byte) {
+	if len(book.pending) == maxPendingDepth {
+		book.pending = append(book.pending[:0], book.pending[1:]...)
+	}
+	book.pending = append(book.pending, append([]byte(nil), msg...))
+	d.requestSnapshot(sym, book)
+}
+
+// ingestSnapshot handles a depthSnapshot event from a capture.
+func (d *depthSync) ingestSnapshot(msg []byte, received time.Time) error {
+	var snap DepthSnapshot
+	if err := json.Unmarshal(msg, &snap); err != nil {
+		return fmt.Errorf("malformed depth snapshot: %w", err)
+	}
+	return d.applySnapshot(snap, received)
+}
+
+// applySnapshot replaces a book with snap, then applies the updates that
+// were buffered waiting for it.
+func (d *depthSync) applySnapshot(snap DepthSnapshot, received time.Time) error {
+	sym, ok := d.shards.Canonical([]byte(snap.Symbol))
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownSymbol, snap.Symbol)
+	}
+	book, ok := d.books[sym]
+	if !ok {
+		return nil
+	}
+	book.fetching = false
+	d.msgs = append(d.msgs[:0], feed.Msg{Kind: feed.KindReset, Symbol: sym, TradeID: snap.LastUpdateID, Received: received, Parsed: time.Now()})
+	for _, side := range []struct {
+		levels [][2]string
+		bid    bool
+	}{{snap.Bids, true}, {snap.Asks, false}} {
+		for _, level := range side.levels {
+			m, err := depthLevel(sym, snap.LastUpdateID, side.bid, []byte(level[0]), []byte(level[1]))
+			if err != nil {
+				return err
+			}
+			m.Received = received
+			d.msgs = append(d.msgs, m)
+		}
+	}
+	d.pushMsgs()
+	book.synced, book.lastID = true, snap.LastUpdateID
+	logger("depth").Info("mirrored book synced", "symbol", sym, "last_update_id", snap.LastUpdateID,
+		"bids", len(snap.Bids), "asks", len(snap.Asks), "buffered", len(book.pending))
+
+	pending := book.pending
+	book.pending = nil
+	for i, msg := range pending {
+		if err := feed.ParseDepthUpdate(msg, &d.update); err != nil {
+			continue
+		}
+		u := &d.update
+		if u.FinalUpdateID <= book.lastID {
+			continue
+		}
+		if u.FirstUpdateID > book.lastID+1 {
+			// The snapshot is older than what was buffered, or an update
+			// was lost in between: start over
+			book.synced = false
+			book.pending = pending[i:]
+			d.requestSnapshot(sym, book)
+			return nil
+		}
+		if err := d.pushUpdate(sym, received); err != nil {
+			return err
+		}
+		book.lastID = u.FinalUpdateID
+	}
+	return nil
+}
+
+// pushUpdate routes the levels of d.update to sym's shard, parsing every
+// one first so a malformed update changes nothing.
+func (d *depthSync) pushUpdate(sym string, received time.Time) error {
+	u := &d.update
+	d.msgs = d.msgs[:0]
+	for _, side := range []struct {
+		levels []feed.DepthLevel
+		bid    bool
+	}{{u.Bids, true}, {u.Asks, false}} {
+		for _, level := range side.levels {
+			m, err := depthLevel(sym, u.FinalUpdateID, side.bid, level.Price, level.Quantity)
+			if err != nil {
+				return err
+			}
+			m.EventMs, m.Received = u.EventMs, received
+			d.msgs = append(d.msgs, m)
+		}
+	}
+	d.pushMsgs()
+	return nil
+}
+
+// pushMsgs marks the last of d.msgs and routes them all, logging each to
+// the WAL first.
+func (d *depthSync) pushMsgs() {
+	if len(d.msgs) == 0 {
+		return
+	}
+	d.msgs[len(d.msgs)-1].Last = true
+	for i := range d.msgs {
+		m := &d.msgs[i]
+		if d.wal != nil {
+			d.wal.AppendEvent(m)
+		}
+		d.shards.Push([]byte(m.Symbol), m)
+	}
+}
+
+func depthLevel(sym string, updateID uint64, bid bool, price, quantity []byte) (feed.Msg, error) {
+	p, err := feed.ParseDecimal(price)
+	if err != nil {
+		return feed.Msg{}, invalidf("invalid depth price %q: %w", price, err)
+	}
+	q, err := feed.ParseDecimal(quantity)
+	if err != nil {
+		return feed.Msg{}, invalidf("invalid depth quantity %q: %w", quantity, err)
+	}
+	return feed.Msg{Kind: feed.KindLevel, Symbol: sym, TradeID: updateID, Price: p, Quantity: q, Bid: bid, Parsed: time.Now()}, nil
+}
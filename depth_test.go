@@ -0,0 +1,166 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+func depthUpdate(first, final uint64, bids, asks string) []byte {
+	return []byte(fmt.Sprintf(`{"e":"depthUpdate","E":1700000000000,"s":"BTCUSDT","U":%d,"u":%d,"b":[%s],"a":[%s]}`, first, final, bids, asks))
+}
+
+// collectShards runs shards, handing back everything they were pushed once
+// the returned func is called.
+func collectShards(shards *ShardSet) func() []feed.Msg {
+	var mu sync.Mutex
+	var got []feed.Msg
+	done := shards.Run(func(_ int, batch []feed.Msg) {
+		mu.Lock()
+		got = append(got, batch...)
+		mu.Unlock()
+	})
+	return func() []feed.Msg {
+		shards.Close()
+		<-done
+		return got
+	}
+}
+
+func TestDepthSyncBuffersUntilSnapshot(t *testing.T) {
+	shards := NewShardSet([]string{"btcusdt", "ethusdt"}, 1, 64)
+	collect := collectShards(shards)
+	var fetches int
+	fetch := func(ctx context.Context, sym string) (DepthSnapshot, error) {
+		fetches++
+		return DepthSnapshot{Symbol: "BTCUSDT", LastUpdateID: 100,
+			Bids: [][2]string{{"99", "1"}}, Asks: [][2]string{{"101", "2"}}}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := newDepthSync(ctx, shards, nil, []string{"btcusdt"}, fetch)
+
+	now := time.Now()
+	for _, msg := range [][]byte{
+		depthUpdate(95, 99, `["99","5"]`, ""),   // already in the snapshot
+		depthUpdate(100, 102, `["99","3"]`, ""), // straddles it
+		depthUpdate(103, 103, "", `["101","0"],["102","1"]`),
+	} {
+		if err := d.ingestUpdate(msg, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.books["btcusdt"].synced || len(d.books["btcusdt"].pending) != 3 {
+		t.Fatal("updates applied before the snapshot")
+	}
+	if err := d.applySnapshot(<-d.snapshots, now); err != nil {
+		t.Fatal(err)
+	}
+	if book := d.books["btcusdt"]; !book.synced || book.lastID != 103 || len(book.pending) != 0 {
+		t.Fatalf("after snapshot: %+v", book)
+	}
+	// Stale updates are ignored; a gap refetches
+	d.ingestUpdate(depthUpdate(101, 103, `["99","7"]`, ""), now)
+	d.ingestUpdate(depthUpdate(110, 111, `["98","1"]`, ""), now)
+	if book := d.books["btcusdt"]; book.synced || len(book.pending) != 1 {
+		t.Errorf("gap left book %+v", book)
+	}
+	<-d.snapshots
+	if fetches != 2 {
+		t.Errorf("fetched %d snapshots, want 2", fetches)
+	}
+	// Depth for a synthetic book is dropped
+	if err := d.ingestUpdate([]byte(`{"e":"depthUpdate","s":"ETHUSDT","U":1,"u":2,"b":[["1","1"]],"a":[]}`), now); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := collect()
+	ob := orderbook.New()
+	ob.ApplyMirrored(msgs)
+	if p, q, _ := ob.GetBestBid(); p != 99 || q != 3000 {
+		t.Errorf("best bid = %v x %d, want 99 x 3000", p, q)
+	}
+	if p, q, _ := ob.GetBestAsk(); p != 102 || q != 1000 {
+		t.Errorf("best ask = %v x %d, want 102 x 1000", p, q)
+	}
+	var last int
+	for _, m := range msgs {
+		if m.Symbol != "btcusdt" {
+			t.Errorf("routed %+v", m)
+		}
+		if m.Last {
+			last++
+		}
+	}
+	// The snapshot and the two updates after it
+	if last != 3 {
+		t.Errorf("%d messages marked last, want 3", last)
+	}
+}
+
+func TestDepthSyncWithoutFetcher(t *testing.T) {
+	shards := NewShardSet([]string{"btcusdt"}, 1, 64)
+	collect := collectShards(shards)
+	d := newDepthSync(context.Background(), shards, nil, []string{"btcusdt"}, nil)
+	now := time.Now()
+	d.ingestUpdate(depthUpdate(50, 52, `["99","1"]`, `["101","1"]`), now)
+	// A gap is only logged when there is nothing to refetch from
+	d.ingestUpdate(depthUpdate(60, 60, `["99","2"]`, ""), now)
+	if err := d.ingestUpdate(depthUpdate(61, 61, `["99","x"]`, ""), now); err == nil {
+		t.Error("malformed quantity accepted")
+	}
+
+	ob := orderbook.New()
+	ob.ApplyMirrored(collect())
+	if p, q, _ := ob.GetBestBid(); p != 99 || q != 2000 {
+		t.Errorf("best bid = %v x %d, want 99 x 2000", p, q)
+	}
+	if _, _, ok := ob.GetBestAsk(); !ok {
+		t.Error("ask lost")
+	}
+}
+
+func TestFetchDepthSnapshot(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") != "BTCUSDT" {
+			http.Error(w, "unknown symbol", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"lastUpdateId":1027024,"bids":[["4.00000000","431.00000000"]],"asks":[["4.00000200","12.00000000"]]}`))
+	}))
+	defer srv.Close()
+
+	snap, err := FetchDepthSnapshot(context.Background(), srv.Client(), srv.URL, "btcusdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Symbol != "BTCUSDT" || snap.LastUpdateID != 1027024 || len(snap.Bids) != 1 || snap.Asks[0][1] != "12.00000000" {
+		t.Errorf("snapshot = %+v", snap)
+	}
+	if _, err := FetchDepthSnapshot(context.Background(), srv.Client(), srv.URL, "nosuch"); err == nil {
+		t.Error("error status accepted")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FetchDepthSnapshot(ctx, srv.Client(), srv.URL, "btcusdt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("fetch after cancel = %v, want context.Canceled", err)
+	}
+
+	line, err := snap.MarshalCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back DepthSnapshot
+	if typ, _ := feed.EventType(line); string(typ) != "depthSnapshot" || json.Unmarshal(line, &back) != nil || back.LastUpdateID != snap.LastUpdateID {
+		t.Errorf("capture line %s", line)
+	}
+}
@@ -0,0 +1,97 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"apexlob/pkg/signals"
+)
+
+// DerivedConfig defines a signal computed from other signals by an
+// expression, so simple composites need no Go code:
+//
+//	{"name": "spread_z", "expr": "zscore(spread_bps, 5m)"}
+//	{"name": "ofi_trend", "expr": "ofi_1m - mean(ofi_1m, 15m)"}
+type DerivedConfig struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+}
+
+// LoadDerivedSignals reads a JSON array of derived signals and checks that
+// each one parses.
+func LoadDerivedSignals(path string) ([]DerivedConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfgs []DerivedConfig
+	if err := json.Unmarshal(data, &cfgs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("derived signal with expression %q has no name", cfg.Expr)
+		}
+		if seen[cfg.Name] {
+			return nil, fmt.Errorf("derived signal %s defined twice", cfg.Name)
+		}
+		seen[cfg.Name] = true
+		if _, err := newDerivedSignal(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfgs, nil
+}
+
+// derivedSignal evaluates a DerivedConfig on every update, after the
+// signals registered before it. It is unset while a signal it reads has
+// no value.
+type derivedSignal struct {
+	name string
+	expr Expr
+	now  time.Time // of the update, for the expression's windows
+}
+
+func newDerivedSignal(cfg DerivedConfig) (*derivedSignal, error) {
+	d := &derivedSignal{name: cfg.Name}
+	expr, err := parseExpr(cfg.Expr, &d.now)
+	if err != nil {
+		return nil, fmt.Errorf("derived signal %s: %w", cfg.Name, err)
+	}
+	d.expr = expr
+	return d, nil
+}
+
+// registerDerivedSignals registers cfgs with the symbol's engine, each with
+// windows of its own.
+func registerDerivedSignals(state *SymbolState, cfgs []DerivedConfig) error {
+	names := state.Signals.Names()
+	for _, cfg := range cfgs {
+		for _, name := range names {
+			if name == cfg.Name {
+				return fmt.Errorf("derived signal %s has the name of a built-in signal", cfg.Name)
+			}
+		}
+		d, err := newDerivedSignal(cfg)
+		if err != nil {
+			return err
+		}
+		state.Signals.Register(d)
+	}
+	return nil
+}
+
+func (d *derivedSignal) Name() string { return d.name }
+
+func (d *derivedSignal) Update(in *signals.Input) float64 {
+	d.now = in.Trade.Timestamp
+	v, err := d.expr.Eval(in.Values)
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
@@ -0,0 +1,58 @@
+package apexlob
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestDerivedSignals(t *testing.T) {
+	state := NewSymbolState("btcusdt")
+	err := registerDerivedSignals(state, []DerivedConfig{
+		{Name: "price_z", Expr: "zscore(last_price, 10s)"},
+		{Name: "price_delta", Expr: "delta(last_price, 10s)"},
+		{Name: "price_mean", Expr: "mean(last_price, 10s) - price_delta"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	for i, price := range []float64{100, 102, 104, 106, 110} {
+		tr := orderbook.Trade{Price: price, Quantity: 1, Timestamp: start.Add(time.Duration(i) * 4 * time.Second)}
+		state.Signals.OnTrade(&tr, state.Book)
+	}
+	// The window holds the trades at 8s, 12s and 16s: 104, 106 and 110
+	values := state.Signals.Snapshot()
+	mean := 320.0 / 3
+	std := math.Sqrt((math.Pow(104-mean, 2) + math.Pow(106-mean, 2) + math.Pow(110-mean, 2)) / 3)
+	want := map[string]float64{"price_delta": 6, "price_mean": mean - 6, "price_z": (110 - mean) / std}
+	for name, v := range want {
+		if math.Abs(values[name]-v) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, values[name], v)
+		}
+	}
+
+	if err := registerDerivedSignals(state, []DerivedConfig{{Name: "vwap", Expr: "1"}}); err == nil {
+		t.Error("a derived signal took the name of a built-in one")
+	}
+}
+
+func TestLoadDerivedSignals(t *testing.T) {
+	dir := t.TempDir()
+	for body, ok := range map[string]bool{
+		`[{"name": "spread_z", "expr": "zscore(spread_bps, 5m)"}]`: true,
+		`[{"name": "a", "expr": "1"}, {"name": "a", "expr": "2"}]`: false,
+		`[{"expr": "1"}]`: false,
+		`[{"name": "spread_z", "expr": "zscore(spread_bps)"}]`: false,
+	} {
+		path := filepath.Join(dir, "derived.json")
+		os.WriteFile(path, []byte(body), 0o644)
+		if _, err := LoadDerivedSignals(path); (err == nil) != ok {
+			t.Errorf("LoadDerivedSignals(%s) error = %v", body, err)
+		}
+	}
+}
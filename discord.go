@@ -0,0 +1,219 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const discordAPI = "https://discord.com/api/v10"
+
+// Gateway opcodes and intents the bot uses.
+const (
+	discordDispatch       = 0
+	discordHeartbeat      = 1
+	discordIdentify       = 2
+	discordReconnect      = 7
+	discordInvalidSession = 9
+	discordHello          = 10
+	discordHeartbeatAck   = 11
+
+	// Guild and direct messages, and their content
+	discordIntents = 1<<9 | 1<<12 | 1<<15
+)
+
+// DiscordBot answers BotCommands posted in the channels its bot can read.
+// It holds a Discord gateway connection for the messages and replies
+// through the REST API. A dropped session is started over rather than
+// resumed, so commands sent while it reconnects go unanswered.
+type DiscordBot struct {
+	token    string
+	apiBase  string
+	commands *BotCommands
+	dialer   *websocket.Dialer
+}
+
+func NewDiscordBot(token string, commands *BotCommands) *DiscordBot {
+	return &DiscordBot{token: token, apiBase: discordAPI, commands: commands, dialer: websocket.DefaultDialer}
+}
+
+type discordPayload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  *int64          `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+type discordMessage struct {
+	ChannelID string `json:"channel_id"`
+	Content   string `json:"content"`
+	Author    struct {
+		Bot bool `json:"bot"`
+	} `json:"author"`
+}
+
+// Run keeps a gateway session open until ctx is done, reconnecting with
+// backoff. It gives up, returning why, if Discord rejects the token or
+// the intents.
+func (b *DiscordBot) Run(ctx context.Context) error {
+	log := logger("discord")
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Codes from 4004 on are for a bad token, intents or version, all
+		// but the three for a session gone stale
+		var closed *websocket.CloseError
+		if errors.As(err, &closed) && closed.Code >= 4004 && closed.Code != 4007 && closed.Code != 4008 && closed.Code != 4009 {
+			return fmt.Errorf("discord closed the session: %w", err)
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Warn("discord session ended, reconnecting", "err", err, "after", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// session identifies to the gateway and answers messages until the
+// connection drops or Discord asks for a new session.
+func (b *DiscordBot) session(ctx context.Context) error {
+	gateway, err := b.gatewayURL(ctx)
+	if err != nil {
+		return err
+	}
+	conn, _, err := b.dialer.DialContext(ctx, gateway+"?v=10&encoding=json", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	var hello struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	var p discordPayload
+	if err := conn.ReadJSON(&p); err != nil {
+		return err
+	}
+	if p.Op != discordHello || json.Unmarshal(p.Data, &hello) != nil || hello.HeartbeatInterval <= 0 {
+		return fmt.Errorf("expected hello, got op %d", p.Op)
+	}
+
+	var mu sync.Mutex // writes, and seq
+	var seq *int64
+	send := func(op int, data any) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if op == discordHeartbeat {
+			data = seq
+		}
+		return conn.WriteJSON(map[string]any{"op": op, "d": data})
+	}
+	identify := map[string]any{
+		"token":      b.token,
+		"intents":    discordIntents,
+		"properties": map[string]string{"os": runtime.GOOS, "browser": "apexlob", "device": "apexlob"},
+	}
+	if err := send(discordIdentify, identify); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Duration(hello.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if send(discordHeartbeat, nil) != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		p = discordPayload{}
+		if err := conn.ReadJSON(&p); err != nil {
+			return err
+		}
+		if p.Seq != nil {
+			mu.Lock()
+			seq = p.Seq
+			mu.Unlock()
+		}
+		switch p.Op {
+		case discordHeartbeat:
+			if err := send(discordHeartbeat, nil); err != nil {
+				return err
+			}
+		case discordReconnect, discordInvalidSession:
+			return fmt.Errorf("gateway asked to reconnect (op %d)", p.Op)
+		case discordDispatch:
+			switch p.Type {
+			case "READY":
+				logger("discord").Info("connected to the Discord gateway")
+			case "MESSAGE_CREATE":
+				var msg discordMessage
+				if json.Unmarshal(p.Data, &msg) != nil || msg.Author.Bot {
+					continue
+				}
+				if reply, ok := b.commands.Answer(msg.Content); ok {
+					if err := b.reply(msg.ChannelID, reply); err != nil {
+						logger("discord").Warn("failed to reply", "channel", msg.ChannelID, "err", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func (b *DiscordBot) gatewayURL(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.apiBase+"/gateway", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var gateway struct {
+		URL string `json:"url"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gateway lookup: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gateway); err != nil || gateway.URL == "" {
+		return "", fmt.Errorf("gateway lookup: no url")
+	}
+	return gateway.URL, nil
+}
+
+func (b *DiscordBot) reply(channel, text string) error {
+	body, err := json.Marshal(map[string]string{"content": text})
+	if err != nil {
+		return err
+	}
+	return postJSON(b.apiBase+"/channels/"+url.PathEscape(channel)+"/messages", map[string]string{"Authorization": "Bot " + b.token}, body)
+}
@@ -0,0 +1,127 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/gorilla/websocket"
+)
+
+// The fake gateway and REST API speak in discordgo's types, so frames and
+// bodies have the shape Discord's own Go library sends and expects.
+func TestDiscordBot(t *testing.T) {
+	if discordIntents != discordgo.IntentsGuildMessages|discordgo.IntentsDirectMessages|discordgo.IntentsMessageContent {
+		t.Errorf("intents = %b", discordIntents)
+	}
+	replies := make(chan string, 4)
+	identified := make(chan json.RawMessage, 1)
+	heartbeat := make(chan string, 1)
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	mux.HandleFunc("/api/gateway", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"url": "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"})
+	})
+	mux.HandleFunc("/api/channels/42/messages", func(w http.ResponseWriter, r *http.Request) {
+		var msg discordgo.MessageSend
+		json.NewDecoder(r.Body).Decode(&msg)
+		if r.Header.Get("Authorization") != "Bot T0KEN" || r.Header.Get("Content-Type") != "application/json" {
+			msg.Content = "unauthorized"
+		}
+		replies <- msg.Content
+		writeJSON(w, &discordgo.Message{ID: "100", ChannelID: "42", Content: msg.Content})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("v") != "10" || r.URL.Query().Get("encoding") != "json" {
+			t.Errorf("gateway query = %s", r.URL.RawQuery)
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(&discordgo.Event{Operation: discordHello, RawData: json.RawMessage(`{"heartbeat_interval":20}`)})
+		var identify discordgo.Event
+		if conn.ReadJSON(&identify) != nil || identify.Operation != discordIdentify {
+			return
+		}
+		identified <- identify.RawData
+		message := func(seq int64, content string, bot bool) {
+			data, _ := json.Marshal(&discordgo.MessageCreate{Message: &discordgo.Message{
+				ID: strconv.FormatInt(900+seq, 10), ChannelID: "42", GuildID: "7", Content: content,
+				Timestamp: time.Now(), Author: &discordgo.User{ID: "5", Username: "alice", Bot: bot},
+			}})
+			conn.WriteJSON(&discordgo.Event{Operation: discordDispatch, Sequence: seq, Type: "MESSAGE_CREATE", RawData: data})
+		}
+		message(1, "hello", false)
+		message(2, "!price btcusdt", true)
+		message(3, "!price btcusdt", false)
+		for { // until the bot goes away
+			var e discordgo.Event
+			if conn.ReadJSON(&e) != nil {
+				return
+			}
+			if e.Operation == discordHeartbeat && string(e.RawData) == "3" {
+				select {
+				case heartbeat <- string(e.RawData):
+				default:
+				}
+			}
+			conn.WriteJSON(&discordgo.Event{Operation: discordHeartbeatAck})
+		}
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	symbols := NewSymbolRegistry()
+	symbols.Add(NewSymbolState("btcusdt"))
+	bot := NewDiscordBot("T0KEN", NewBotCommands(symbols))
+	bot.apiBase = srv.URL + "/api"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- bot.Run(ctx) }()
+
+	select {
+	case raw := <-identified:
+		var identify discordgo.Identify
+		json.Unmarshal(raw, &identify)
+		if identify.Token != "T0KEN" || identify.Intents != discordIntents {
+			t.Errorf("identify = %s", raw)
+		}
+		// API v10 dropped the $ from the connection properties, which
+		// discordgo's IdentifyProperties still carries.
+		var props struct{ Properties map[string]string }
+		json.Unmarshal(raw, &props)
+		if p := props.Properties; p["os"] == "" || p["browser"] != "apexlob" || p["device"] != "apexlob" {
+			t.Errorf("identify properties = %v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no identify")
+	}
+	select {
+	case reply := <-replies:
+		if reply != "BTCUSDT: no trades yet" {
+			t.Errorf("reply = %q", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+	select {
+	case <-heartbeat:
+	case <-time.After(5 * time.Second):
+		t.Error("no heartbeat carrying the last sequence number")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v", err)
+	}
+	if len(replies) != 0 {
+		t.Errorf("answered a bot or a non-command: %q", <-replies)
+	}
+}
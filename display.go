@@ -0,0 +1,207 @@
+package apexlob
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// feedStaleAfter is how long without a processed message before the
+// displays warn that the feed has gone quiet.
+const feedStaleAfter = 5 * time.Second
+
+// statusLine draws a symbol's one-line summary. The last price is green or
+// red as it ticks up or down from the previous line, and a feed that has
+// gone quiet is flagged in yellow.
+type statusLine struct {
+	state     *SymbolState
+	color     palette
+	prevPrice float64
+}
+
+func (s *statusLine) draw(stats StatsSnapshot, stale time.Duration) {
+	totals := s.state.Book.TradeTotals()
+	last := s.color.tick(fmt.Sprintf("%.2f", totals.LastPrice), totals.LastPrice, s.prevPrice)
+	if totals.LastPrice != s.prevPrice && totals.LastPrice != 0 {
+		s.prevPrice = totals.LastPrice
+	}
+	line := fmt.Sprintf("[LOB] Last: %s | VWAP: %.2f | Vol: %d", last, totals.VWAP(), totals.Volume)
+	if r, ok := s.state.Range.Snapshot(); ok {
+		line += " | " + rangeText(r)
+	}
+	if stats.TotalMessages > 0 {
+		p := stats.Processing
+		line += fmt.Sprintf(" | Msg: %d | AvgProc: %.3fms | p50/p90/p99/p99.9: %.3f/%.3f/%.3f/%.3fms",
+			stats.TotalMessages, stats.AvgProcessingMs, p.P50, p.P90, p.P99, p.P999)
+	}
+	if n := stats.Errors.Total(); n > 0 {
+		line += " | " + s.color.warn(fmt.Sprintf("Err: %d", n))
+	}
+	if stale > 0 {
+		line += " | " + s.color.warn(fmt.Sprintf("STALE %ds", int(stale.Seconds())))
+	}
+	console.Status(line + "\x1b[K")
+}
+
+// rangeText is a symbol's session and rolling high and low, and the
+// rolling range.
+func rangeText(r PriceRangeSnapshot) string {
+	return fmt.Sprintf("H/L: %.2f/%.2f | %s H/L: %.2f/%.2f (%.2f)",
+		r.High, r.Low, shortDuration(time.Duration(r.Window)), r.WindowHigh, r.WindowLow, r.WindowRange)
+}
+
+// RunDisplay redraws state's status line every refresh until stop is closed,
+// keeping terminal output off the message path. It draws only when new
+// messages have been processed, or each second once none have for
+// feedStaleAfter, and once more on the way out.
+func RunDisplay(state *SymbolState, stats func() StatsSnapshot, refresh time.Duration, color palette, stop <-chan struct{}) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	line := &statusLine{state: state, color: color}
+	drawn, lastChange := 0, time.Now()
+	var shownStale time.Duration
+	draw := func() {
+		s := stats()
+		if s.TotalMessages != drawn {
+			drawn, lastChange, shownStale = s.TotalMessages, time.Now(), 0
+			line.draw(s, 0)
+			return
+		}
+		if quiet := time.Since(lastChange).Truncate(time.Second); quiet >= feedStaleAfter && quiet != shownStale {
+			shownStale = quiet
+			line.draw(s, quiet)
+		}
+	}
+	for {
+		select {
+		case <-stop:
+			draw()
+			return
+		case <-ticker.C:
+			draw()
+		}
+	}
+}
+
+// symbolTable draws one row per symbol when the monitor streams several,
+// in place of a status line that could only show one of them. Message
+// rates are measured between draws.
+type symbolTable struct {
+	states    []*SymbolState
+	color     palette
+	prevPrice map[string]float64
+	prevCount map[string]uint64
+	prevAt    time.Time
+	rates     map[string]float64
+}
+
+func newSymbolTable(states []*SymbolState, color palette) *symbolTable {
+	return &symbolTable{
+		states:    states,
+		color:     color,
+		prevPrice: make(map[string]float64),
+		prevCount: make(map[string]uint64),
+		rates:     make(map[string]float64),
+	}
+}
+
+func (t *symbolTable) render(stats StatsSnapshot, stale time.Duration, now time.Time) string {
+	elapsed := now.Sub(t.prevAt).Seconds()
+	for _, s := range t.states {
+		count := s.Messages()
+		if !t.prevAt.IsZero() && elapsed > 0 {
+			t.rates[s.Symbol] = float64(count-t.prevCount[s.Symbol]) / elapsed
+		}
+		t.prevCount[s.Symbol] = count
+	}
+	t.prevAt = now
+
+	window := "-"
+	if len(t.states) > 0 {
+		window = strings.ToUpper(shortDuration(t.states[0].Range.Window()))
+	}
+	lines := []string{fmt.Sprintf("%-12s %12s %12s %12s %12s %10s %12s %10s %8s %8s", "SYMBOL", "LAST", "VWAP",
+		"HIGH "+window, "LOW "+window, "RANGE", "VOL 1M", "SPREAD", "IMBAL", "MSG/S")}
+	for _, s := range t.states {
+		totals := s.Book.TradeTotals()
+		last := t.color.tick(fmt.Sprintf("%12.2f", totals.LastPrice), totals.LastPrice, t.prevPrice[s.Symbol])
+		if totals.LastPrice != 0 {
+			t.prevPrice[s.Symbol] = totals.LastPrice
+		}
+		spread, imbalance := "-", "-"
+		if v, ok := s.Signals.Value("spread_bps"); ok {
+			spread = fmt.Sprintf("%.2fbp", v)
+		}
+		if v, ok := s.Signals.Value("imbalance"); ok {
+			imbalance = fmt.Sprintf("%+.3f", v)
+		}
+		high, low, width := "-", "-", "-"
+		if r, ok := s.Range.Snapshot(); ok {
+			high, low, width = fmt.Sprintf("%.2f", r.WindowHigh), fmt.Sprintf("%.2f", r.WindowLow), fmt.Sprintf("%.2f", r.WindowRange)
+		}
+		lines = append(lines, fmt.Sprintf("%-12s %s %12.2f %12s %12s %10s %12.4f %10s %8s %8.1f",
+			strings.ToUpper(s.Symbol), last, totals.VWAP(), high, low, width, minuteVolume(s.Tape), spread, imbalance, t.rates[s.Symbol]))
+	}
+	footer := fmt.Sprintf("[LOB] Msg: %d | %.1f msgs/sec", stats.TotalMessages, stats.MessagesPerSecond)
+	if stats.TotalMessages > 0 {
+		p := stats.Processing
+		footer += fmt.Sprintf(" | AvgProc: %.3fms | p99: %.3fms", stats.AvgProcessingMs, p.P99)
+	}
+	if n := stats.Errors.Total(); n > 0 {
+		footer += " | " + t.color.warn(fmt.Sprintf("Err: %d", n))
+	}
+	if stale > 0 {
+		footer += " | " + t.color.warn(fmt.Sprintf("STALE %ds", int(stale.Seconds())))
+	}
+	lines = append(lines, footer)
+	return strings.Join(lines, "\x1b[K\n") + "\x1b[K"
+}
+
+// minuteVolume is the quantity traded in the minute up to the tape's newest
+// trade, so replays show the volume of their own minute. A tape too short
+// to cover the minute undercounts it.
+func minuteVolume(tape *TradeTape) float64 {
+	trades := tape.Recent(tape.Len())
+	if len(trades) == 0 {
+		return 0
+	}
+	from := trades[0].Timestamp.Add(-time.Minute)
+	var volume float64
+	for _, tr := range trades {
+		if !tr.Timestamp.After(from) {
+			break
+		}
+		volume += tr.Quantity
+	}
+	return volume
+}
+
+// RunSymbolTable redraws the per-symbol table until stop is closed: every
+// refresh, but no more than once a second so the rates stay readable, and
+// once more on the way out.
+func RunSymbolTable(states []*SymbolState, stats func() StatsSnapshot, refresh time.Duration, color palette, stop <-chan struct{}) {
+	ticker := time.NewTicker(max(refresh, time.Second))
+	defer ticker.Stop()
+	table := newSymbolTable(states, color)
+	seen, lastChange := 0, time.Now()
+	draw := func() {
+		s := stats()
+		var stale time.Duration
+		if s.TotalMessages != seen {
+			seen, lastChange = s.TotalMessages, time.Now()
+		} else if quiet := time.Since(lastChange).Truncate(time.Second); quiet >= feedStaleAfter {
+			stale = quiet
+		}
+		console.Status(table.render(s, stale, time.Now()))
+	}
+	draw()
+	for {
+		select {
+		case <-stop:
+			draw()
+			return
+		case <-ticker.C:
+			draw()
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package apexlob
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestRunDisplayRedrawsOnlyOnNewMessages(t *testing.T) {
+	var out bytes.Buffer
+	saved := console
+	console = NewConsole(&out, io.Discard)
+	defer func() { console = saved }()
+
+	state := NewSymbolState("btcusdt")
+	state.Range.Add(101, time.Now())
+	var messages int64
+	var calls int64
+	stats := func() StatsSnapshot {
+		atomic.AddInt64(&calls, 1)
+		return StatsSnapshot{TotalMessages: int(atomic.LoadInt64(&messages))}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunDisplay(state, stats, time.Millisecond, palette(false), stop)
+		close(done)
+	}()
+
+	for atomic.LoadInt64(&calls) < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt64(&messages, 7)
+	close(stop)
+	<-done
+
+	// Idle ticks draw nothing; the final draw on stop shows the new count
+	if n := strings.Count(out.String(), "[LOB]"); n != 1 {
+		t.Errorf("drew %d status lines, want 1: %q", n, out.String())
+	}
+	if !strings.Contains(out.String(), "Msg: 7") {
+		t.Errorf("status line %q does not show the message count", out.String())
+	}
+	if !strings.Contains(out.String(), "1h H/L: 101.00/101.00 (0.00)") {
+		t.Errorf("status line %q does not show the range", out.String())
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	var states []*SymbolState
+	now := time.Now()
+	for i, sym := range []string{"btcusdt", "ethusdt"} {
+		state := NewSymbolState(sym)
+		state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 100, Side: orderbook.Buy})
+		state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 101, Quantity: 100, Side: orderbook.Sell})
+		for _, tr := range []orderbook.Trade{
+			{Price: 100, Quantity: 4, Timestamp: now.Add(-2 * time.Minute)}, // outside the minute
+			{Price: 100, Quantity: 1.5, Timestamp: now.Add(-30 * time.Second)},
+			{Price: 100, Quantity: 0.5 * float64(i+1), Timestamp: now},
+		} {
+			tr.Symbol = sym
+			state.Tape.Add(tr)
+			state.Range.Add(tr.Price, tr.Timestamp)
+			state.Signals.OnTrade(&tr, state.Book)
+		}
+		states = append(states, state)
+	}
+	states[0].Range.Add(103, now)
+	table := newSymbolTable(states, palette(false))
+	table.render(StatsSnapshot{}, 0, now)
+	states[0].messages.Add(30)
+	out := table.render(StatsSnapshot{TotalMessages: 30}, 6*time.Second, now.Add(2*time.Second))
+
+	lines := strings.Split(out, "\x1b[K\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want header, two symbols and a footer:\n%s", len(lines), out)
+	}
+	for i, want := range [][]string{
+		{"SYMBOL", "HIGH 1H", "VOL 1M", "MSG/S"},
+		{"BTCUSDT", "103.00", "3.00", "2.0000", "200.00bp", "15.0"},
+		{"ETHUSDT", "0.00", "2.5000", "0.0"},
+		{"Msg: 30", "STALE 6s"},
+	} {
+		for _, w := range want {
+			if !strings.Contains(lines[i], w) {
+				t.Errorf("line %d %q missing %q", i, lines[i], w)
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// StateDump is everything the monitor holds in memory at one instant, for
+// debugging a live incident offline. Books are dumped at full depth.
+type StateDump struct {
+	Time        time.Time             `json:"time"`
+	Reason      string                `json:"reason"`
+	Stats       StatsSnapshot         `json:"stats"`
+	Memory      MemoryReport          `json:"memory"`
+	Symbols     map[string]SymbolDump `json:"symbols"`
+	FeedQueues  []FeedQueueDepth      `json:"feed_queues"`
+	EventQueues []QueueStat           `json:"event_queues"`
+	Sinks       map[string]SinkCount  `json:"sinks"`
+	Panics      map[string]uint64     `json:"panics"` // recovered, by component
+}
+
+type SymbolDump struct {
+	Book         BookSnapshot       `json:"book"`
+	BookStats    orderbook.Stats    `json:"book_stats"`
+	Signals      map[string]float64 `json:"signals"`
+	RecentTrades []orderbook.Trade  `json:"recent_trades"`
+}
+
+// FeedQueueDepth is the backlog of one shard worker's feed queue.
+type FeedQueueDepth struct {
+	Shard    int `json:"shard"`
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+// dumpTrades is how much of each tape a dump includes.
+const dumpTrades = 100
+
+// Dump snapshots m's state. Each book is read under its own lock, so the
+// books are consistent individually but not with each other.
+func (m *Monitor) Dump(reason string) StateDump {
+	d := StateDump{
+		Time:        time.Now().UTC(),
+		Reason:      reason,
+		Stats:       m.Snapshot(),
+		Memory:      ReadMemoryReport(m.Symbols),
+		Symbols:     make(map[string]SymbolDump, len(m.SymbolList)),
+		EventQueues: m.Bus.QueueStats(),
+		Sinks:       m.SinkCounts(),
+		Panics:      m.Supervisor.Panics(),
+	}
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		d.Symbols[sym] = SymbolDump{
+			Book:         state.BookSnapshot(0),
+			BookStats:    state.Book.Stats(),
+			Signals:      state.Signals.Snapshot(),
+			RecentTrades: state.Tape.Recent(dumpTrades),
+		}
+	}
+	for shard, depth := range m.Shards.QueueDepths() {
+		d.FeedQueues = append(d.FeedQueues, FeedQueueDepth{Shard: shard, Depth: depth, Capacity: m.Shards.QueueCapacity()})
+	}
+	return d
+}
+
+// WriteStateDump writes d to a file in dir named after its time and returns
+// the file's path.
+func WriteStateDump(dir string, d StateDump) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "apexlob-dump-"+d.Time.Format("20060102T150405.000Z")+".json")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(d)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", fmt.Errorf("writing %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// dumpToFile writes a dump to dir and logs where it went.
+func (m *Monitor) dumpToFile(dir, reason string) (string, error) {
+	path, err := WriteStateDump(dir, m.Dump(reason))
+	if err != nil {
+		logger("main").Error("state dump failed", "err", err)
+		return "", err
+	}
+	logger("main").Info("wrote state dump", "path", path, "reason", reason)
+	return path, nil
+}
+
+// DumpOnSignal writes a dump to dir on every SIGUSR1 until m is closed.
+// It does nothing where SIGUSR1 does not exist.
+func (m *Monitor) DumpOnSignal(dir string) {
+	signals, stop := notifyDumpSignal()
+	if signals == nil {
+		return
+	}
+	go func() {
+		defer stop()
+		for {
+			select {
+			case <-signals:
+				m.dumpToFile(dir, "signal")
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// DumpHandler serves the dump as JSON on GET and writes it to a file in dir
+// on POST, answering with the file's path.
+func (m *Monitor) DumpHandler(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, m.Dump("http"))
+		case http.MethodPost:
+			path, err := m.dumpToFile(dir, "http")
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, map[string]string{"path": path})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
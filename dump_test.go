@@ -0,0 +1,97 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// replayedMonitor runs a synthetic capture through a fresh monitor.
+func replayedMonitor(t *testing.T, symbols []string, n int) *Monitor {
+	t.Helper()
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: strings.Join(symbols, ","), Shards: 2, FeedQueue: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, writeCapture(t, symbols, n), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	return m
+}
+
+func TestStateDump(t *testing.T) {
+	m := replayedMonitor(t, []string{"btcusdt", "ethusdt"}, 400)
+	d := m.Dump("test")
+	if d.Reason != "test" || d.Stats.TotalMessages != 400 {
+		t.Errorf("dump reason %q with %d messages", d.Reason, d.Stats.TotalMessages)
+	}
+	for _, sym := range []string{"btcusdt", "ethusdt"} {
+		s, ok := d.Symbols[sym]
+		if !ok || len(s.RecentTrades) == 0 || len(s.Signals) == 0 {
+			t.Fatalf("%s dump = %+v", sym, s)
+		}
+		// Full depth: every resting level is in the dump
+		if len(s.Book.Bids) != s.BookStats.BidLevels || len(s.Book.Asks) != s.BookStats.AskLevels {
+			t.Errorf("%s dumped %d/%d levels of %d/%d", sym, len(s.Book.Bids), len(s.Book.Asks), s.BookStats.BidLevels, s.BookStats.AskLevels)
+		}
+	}
+	if len(d.FeedQueues) != 2 || d.FeedQueues[0].Capacity != 64 {
+		t.Errorf("feed queues = %+v", d.FeedQueues)
+	}
+
+	dir := filepath.Join(t.TempDir(), "dumps")
+	path, err := WriteStateDump(dir, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "apexlob-dump-") || filepath.Ext(path) != ".json" {
+		t.Errorf("dump written to %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	var decoded StateDump
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Stats.TotalMessages != 400 || len(decoded.Symbols) != 2 {
+		t.Errorf("decoded dump = %+v", decoded)
+	}
+}
+
+func TestDumpHandler(t *testing.T) {
+	m := replayedMonitor(t, []string{"btcusdt"}, 100)
+	dir := t.TempDir()
+	h := m.DumpHandler(dir)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/dump", nil))
+	var d StateDump
+	if err := json.Unmarshal(rec.Body.Bytes(), &d); err != nil || d.Reason != "http" {
+		t.Fatalf("GET dump: %v, %+v", err, d)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/dump", nil))
+	var resp map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if filepath.Dir(resp["path"]) != dir {
+		t.Fatalf("POST dump answered %s", rec.Body)
+	}
+	if _, err := os.Stat(resp["path"]); err != nil {
+		t.Error(err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/debug/dump", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE answered %d", rec.Code)
+	}
+}
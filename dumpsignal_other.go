@@ -0,0 +1,9 @@
+//go:build !unix
+
+package apexlob
+
+import "os"
+
+func notifyDumpSignal() (<-chan os.Signal, func()) {
+	return nil, func() {}
+}
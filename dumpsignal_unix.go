@@ -0,0 +1,15 @@
+//go:build unix
+
+package apexlob
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func notifyDumpSignal() (<-chan os.Signal, func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	return ch, func() { signal.Stop(ch) }
+}
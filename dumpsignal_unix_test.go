@@ -0,0 +1,34 @@
+//go:build unix
+
+package apexlob
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDumpOnSignal(t *testing.T) {
+	m := replayedMonitor(t, []string{"btcusdt"}, 50)
+	dir := t.TempDir()
+	m.DumpOnSignal(dir)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// Wait for the whole file, not just its creation
+		if files, _ := filepath.Glob(filepath.Join(dir, "apexlob-dump-*.json")); len(files) == 1 {
+			if data, _ := os.ReadFile(files[0]); json.Valid(data) {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no dump written after SIGUSR1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
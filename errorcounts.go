@@ -0,0 +1,177 @@
+package apexlob
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrorCounts tallies what went wrong without stopping the monitor, so it
+// shows up on the displays, in the final report and in Prometheus rather
+// than only in the log.
+type ErrorCounts struct {
+	// ParseErrors are feed messages that were not valid JSON or not a
+	// known event
+	ParseErrors uint64 `json:"parse_errors"`
+	// ValidationFailures parsed but carried missing or unusable fields
+	ValidationFailures uint64 `json:"validation_failures"`
+	Reconnects         uint64 `json:"reconnects"`
+	// DroppedMessages were for symbols that are not streamed
+	DroppedMessages uint64 `json:"dropped_messages"`
+	// DroppedEvents were lost by sinks and subscribers that fell behind
+	DroppedEvents uint64 `json:"dropped_events"`
+	SinkFailures  uint64 `json:"sink_failures"`
+}
+
+// Total adds up every count.
+func (c ErrorCounts) Total() uint64 {
+	return c.ParseErrors + c.ValidationFailures + c.Reconnects + c.DroppedMessages + c.DroppedEvents + c.SinkFailures
+}
+
+// Since returns the counts added after base. Dropped events can go down,
+// when a subscriber that dropped some goes away; they then count from 0.
+func (c ErrorCounts) Since(base ErrorCounts) ErrorCounts {
+	sub := func(a, b uint64) uint64 {
+		if a < b {
+			return 0
+		}
+		return a - b
+	}
+	return ErrorCounts{
+		ParseErrors:        sub(c.ParseErrors, base.ParseErrors),
+		ValidationFailures: sub(c.ValidationFailures, base.ValidationFailures),
+		Reconnects:         sub(c.Reconnects, base.Reconnects),
+		DroppedMessages:    sub(c.DroppedMessages, base.DroppedMessages),
+		DroppedEvents:      sub(c.DroppedEvents, base.DroppedEvents),
+		SinkFailures:       sub(c.SinkFailures, base.SinkFailures),
+	}
+}
+
+type errorCount struct {
+	name string
+	n    uint64
+}
+
+// nonZero lists the counts that are not zero, for the displays.
+func (c ErrorCounts) nonZero() []errorCount {
+	var out []errorCount
+	for _, e := range []errorCount{
+		{"parse errors", c.ParseErrors},
+		{"invalid", c.ValidationFailures},
+		{"reconnects", c.Reconnects},
+		{"unknown symbol", c.DroppedMessages},
+		{"events dropped", c.DroppedEvents},
+		{"sink failures", c.SinkFailures},
+	} {
+		if e.n > 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Reasons the feed reader drops a message, as reported in the reason label
+// of apexlob_feed_errors_total.
+const (
+	feedErrorParse         = "parse"
+	feedErrorInvalid       = "invalid"
+	feedErrorUnknownSymbol = "unknown_symbol"
+)
+
+// invalidError marks a message that parsed but failed validation, so it is
+// counted apart from malformed ones.
+type invalidError struct{ err error }
+
+func (e *invalidError) Error() string { return e.err.Error() }
+func (e *invalidError) Unwrap() error { return e.err }
+
+// invalidf is fmt.Errorf for validation failures.
+func invalidf(format string, args ...any) error {
+	return &invalidError{fmt.Errorf(format, args...)}
+}
+
+// errorCounters are the monitor's counts that nothing else keeps: sink
+// failures and dropped events are read back from the sinks and the bus.
+type errorCounters struct {
+	parse, invalid, unknown *Counter
+	depthGaps               *Counter
+	reconnects              atomic.Uint64
+}
+
+func newErrorCounters(reg *MetricsRegistry) *errorCounters {
+	counter := func(reason string) *Counter {
+		return reg.Counter("apexlob_feed_errors_total", "Feed messages the reader dropped, by reason.", Labels{"reason": reason})
+	}
+	return &errorCounters{
+		parse:     counter(feedErrorParse),
+		invalid:   counter(feedErrorInvalid),
+		unknown:   counter(feedErrorUnknownSymbol),
+		depthGaps: reg.Counter("apexlob_depth_gaps_total", "Gaps found in the depth updates of mirrored books.", nil),
+	}
+}
+
+// ingestFailed counts and logs a message the reader could not route. It
+// does nothing for a nil err.
+func (m *Monitor) ingestFailed(err error) {
+	var invalid *invalidError
+	switch {
+	case err == nil:
+	case errors.Is(err, errUnknownSymbol):
+		m.errors.unknown.Inc()
+		logger("feed").Warn("dropping message", "err", err)
+	case errors.As(err, &invalid):
+		m.errors.invalid.Inc()
+		logger("feed").Error("dropping invalid message", "err", err)
+	default:
+		m.errors.parse.Inc()
+		logger("feed").Error("dropping malformed message", "err", err)
+	}
+}
+
+// Errors returns what has gone wrong so far.
+func (m *Monitor) Errors() ErrorCounts {
+	c := ErrorCounts{
+		ParseErrors:        m.errors.parse.Value(),
+		ValidationFailures: m.errors.invalid.Value(),
+		Reconnects:         m.errors.reconnects.Load(),
+		DroppedMessages:    m.errors.unknown.Value(),
+	}
+	for _, q := range m.Bus.QueueStats() {
+		c.DroppedEvents += q.Dropped
+	}
+	for _, s := range m.sinkRunners() {
+		c.SinkFailures += s.Failed()
+	}
+	return c
+}
+
+// Snapshot is m.Stats.Snapshot with the error counts and clock offset
+// filled in.
+func (m *Monitor) Snapshot() StatsSnapshot {
+	s := m.Stats.Snapshot()
+	s.Errors = m.Errors()
+	s.ClockOffsetMs = float64(m.clock.Offset().Microseconds()) / 1000
+	return s
+}
+
+// registerErrorMetrics exports the counts the sinks and the bus keep.
+func registerErrorMetrics(m *Monitor) {
+	m.Registry.CounterFunc("apexlob_sink_write_failures_total", "Events a sink failed to write.", func() []Sample {
+		sinks := m.sinkRunners()
+		samples := make([]Sample, len(sinks))
+		for i, s := range sinks {
+			samples[i] = Sample{Labels: Labels{"sink": s.Name()}, Value: float64(s.Failed())}
+		}
+		return samples
+	})
+	m.Registry.CounterFunc("apexlob_events_dropped_total", "Events dropped because a subscriber fell behind.", func() []Sample {
+		queues := m.Bus.QueueStats()
+		samples := make([]Sample, 0, len(queues))
+		for _, q := range queues {
+			if q.Name != "" {
+				samples = append(samples, Sample{Labels: Labels{"subscriber": q.Name}, Value: float64(q.Dropped)})
+			}
+		}
+		return samples
+	})
+}
@@ -0,0 +1,62 @@
+package apexlob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayCountsErrors(t *testing.T) {
+	capture := strings.Join([]string{
+		`{"e":"aggTrade","E":1700000000001,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":false}`,
+		`{"e":"aggTrade",`, // cut off
+		`{"e":"aggTrade","E":1700000000002,"s":"BTCUSDT","a":2,"p":"abc","q":"1.0","m":false}`,
+		`{"e":"aggTrade","E":1700000000003,"s":"BTCUSDT","a":3,"p":"","q":"1.0","m":false}`,
+		`{"e":"aggTrade","E":1700000000004,"s":"DOGEUSDT","a":4,"p":"0.1","q":"1.0","m":false}`,
+		`{"e":"aggTrade","E":1700000000005,"s":"BTCUSDT","a":5,"p":"101.0","q":"1.0","m":false}`,
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, []byte(capture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, Limits: DefaultSymbolLimits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	m.Reconnected()
+
+	want := ErrorCounts{ParseErrors: 1, ValidationFailures: 2, DroppedMessages: 1, Reconnects: 1}
+	snap := m.Snapshot()
+	if snap.Errors != want {
+		t.Errorf("errors = %+v, want %+v", snap.Errors, want)
+	}
+	if snap.TotalMessages != 2 {
+		t.Errorf("processed %d messages, want 2", snap.TotalMessages)
+	}
+
+	var b strings.Builder
+	m.Registry.WritePrometheus(&b)
+	for _, line := range []string{
+		`apexlob_feed_errors_total{reason="parse"} 1`,
+		`apexlob_feed_errors_total{reason="invalid"} 2`,
+		`apexlob_feed_errors_total{reason="unknown_symbol"} 1`,
+		"# TYPE apexlob_sink_write_failures_total counter",
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+
+	if since := snap.Errors.Since(ErrorCounts{ParseErrors: 1, DroppedEvents: 3}); since.ParseErrors != 0 || since.DroppedEvents != 0 || since.Total() != 4 {
+		t.Errorf("Since = %+v", since)
+	}
+}
@@ -0,0 +1,275 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+type EventType string
+
+const (
+	EventTrade     EventType = "trade"
+	EventBook      EventType = "book"
+	EventCandle    EventType = "candle"
+	EventSignal    EventType = "signal"
+	EventExecution EventType = "execution"
+	EventOrder     EventType = "order"
+)
+
+// Event is the normalized unit fanned out to streaming APIs and sinks.
+// Exactly one payload field is set, matching Type.
+type Event struct {
+	Type      EventType            `json:"type"`
+	Symbol    string               `json:"symbol"`
+	Timestamp time.Time            `json:"timestamp"`
+	Trade     *orderbook.Trade     `json:"trade,omitempty"`
+	Book      *BookSnapshot        `json:"book,omitempty"`
+	Candle    *Candle              `json:"candle,omitempty"`
+	Signals   map[string]float64   `json:"signals,omitempty"`
+	Execution *orderbook.Execution `json:"execution,omitempty"`
+	Order     *OrderReport         `json:"order,omitempty"`
+
+	trace SpanContext // message trace the event derives from, if sampled
+	ref   eventRef    // set when the payload is pooled, see pool.go
+}
+
+// EventSchemaVersion is the version of the JSON events the sinks and the
+// WebSocket stream emit, carried in every one as schema_version. Fields are
+// only ever added within a version, so consumers must ignore ones they do
+// not know; renaming, retyping or removing a field, or changing what one
+// means, bumps it.
+const EventSchemaVersion = 1
+
+// eventJSON has Event's fields without its MarshalJSON.
+type eventJSON Event
+
+// MarshalJSON encodes e with its schema version first.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schema_version"`
+		*eventJSON
+	}{EventSchemaVersion, (*eventJSON)(&e)})
+}
+
+// versionedTrade is a trade sent on its own, outside an Event.
+type versionedTrade struct {
+	SchemaVersion int `json:"schema_version"`
+	*orderbook.Trade
+}
+
+// versionedOrder is an order report sent on its own, outside an Event.
+type versionedOrder struct {
+	SchemaVersion int `json:"schema_version"`
+	*OrderReport
+}
+
+type subscription struct {
+	name    string
+	ch      chan Event
+	types   map[EventType]bool // nil means all types
+	symbols map[string]bool    // nil means all symbols
+	dropped uint64
+}
+
+func (s *subscription) wants(e *Event) bool {
+	if s.types != nil && !s.types[e.Type] {
+		return false
+	}
+	return s.symbols == nil || s.symbols[e.Symbol]
+}
+
+// EventBus fans events out to subscribers without ever blocking the
+// publisher; a subscriber that falls behind loses events instead.
+type EventBus struct {
+	mu     sync.RWMutex
+	subs   map[*subscription]struct{}
+	counts map[EventType]int
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subs:   make(map[*subscription]struct{}),
+		counts: make(map[EventType]int),
+	}
+}
+
+// Subscribe returns a channel of matching events and a cancel function that
+// must be called to release it. Empty symbols or types match everything.
+func (b *EventBus) Subscribe(buffer int, symbols []string, types []EventType) (<-chan Event, func()) {
+	return b.SubscribeAs("", buffer, symbols, types)
+}
+
+// SubscribeAs is Subscribe with a name identifying the consumer in
+// QueueStats.
+func (b *EventBus) SubscribeAs(name string, buffer int, symbols []string, types []EventType) (<-chan Event, func()) {
+	sub := &subscription{name: name, ch: make(chan Event, buffer)}
+	if len(types) > 0 {
+		sub.types = make(map[EventType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	if len(symbols) > 0 {
+		sub.symbols = make(map[string]bool, len(symbols))
+		for _, s := range symbols {
+			sub.symbols[s] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.adjustCounts(sub, 1)
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, sub)
+			b.adjustCounts(sub, -1)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+	return sub.ch, cancel
+}
+
+func (b *EventBus) adjustCounts(sub *subscription, delta int) {
+	for _, t := range []EventType{EventTrade, EventBook, EventCandle, EventSignal, EventExecution, EventOrder} {
+		if sub.types == nil || sub.types[t] {
+			b.counts[t] += delta
+		}
+	}
+}
+
+// Wants reports whether anyone is subscribed to the event type, so callers
+// can skip building expensive payloads such as book snapshots.
+func (b *EventBus) Wants(t EventType) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.counts[t] > 0
+}
+
+// QueueStat describes one subscriber's backlog.
+type QueueStat struct {
+	Name     string `json:"name"`
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// QueueStats reports the backlog of every subscriber, sorted by name.
+func (b *EventBus) QueueStats() []QueueStat {
+	b.mu.RLock()
+	stats := make([]QueueStat, 0, len(b.subs))
+	for sub := range b.subs {
+		stats = append(stats, QueueStat{
+			Name:     sub.name,
+			Depth:    len(sub.ch),
+			Capacity: cap(sub.ch),
+			Dropped:  atomic.LoadUint64(&sub.dropped),
+		})
+	}
+	b.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+func (b *EventBus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.wants(&e) {
+			continue
+		}
+		if e.ref != nil {
+			e.ref.retain()
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			if e.ref != nil {
+				e.ref.release()
+			}
+		}
+	}
+}
+
+// PublishTradeEvents emits the trade plus any derived events (closed candle,
+// signal values, book snapshot) for a trade already applied to state.
+func PublishTradeEvents(bus *EventBus, state *SymbolState, tr *orderbook.Trade, trace SpanContext) {
+	pt := acquireTrade(tr)
+	bus.Publish(Event{Type: EventTrade, Symbol: state.Symbol, Timestamp: tr.Timestamp, Trade: &pt.Trade, trace: trace, ref: pt})
+	pt.release()
+	if closed := state.Candles.Add(tr); closed != nil {
+		bus.Publish(Event{Type: EventCandle, Symbol: state.Symbol, Timestamp: tr.Timestamp, Candle: closed, trace: trace})
+	}
+	if bus.Wants(EventSignal) {
+		bus.Publish(Event{Type: EventSignal, Symbol: state.Symbol, Timestamp: tr.Timestamp, Signals: state.Signals.Snapshot(), trace: trace})
+	}
+	PublishBook(bus, state, tr.Timestamp, trace)
+}
+
+// publishedBookDepth is how many levels a side book events carry.
+const publishedBookDepth = 20
+
+// PublishBook emits the top of state's book if anyone wants it.
+func PublishBook(bus *EventBus, state *SymbolState, ts time.Time, trace SpanContext) {
+	if bus.Wants(EventBook) {
+		snap := state.BookSnapshot(publishedBookDepth)
+		snap.Timestamp = ts
+		bus.Publish(Event{Type: EventBook, Symbol: state.Symbol, Timestamp: ts, Book: &snap, trace: trace})
+	}
+}
+
+// PublishExecution emits a fill from a pooled copy of ex.
+func PublishExecution(bus *EventBus, ex *orderbook.Execution, trace SpanContext) {
+	pe := acquireExecution(ex)
+	bus.Publish(Event{Type: EventExecution, Symbol: ex.Symbol, Timestamp: ex.Timestamp, Execution: &pe.Execution, trace: trace, ref: pe})
+	pe.release()
+}
+
+// LevelChange is one entry of a book delta. Volume 0 means the level was
+// removed (or moved outside the snapshot depth).
+type LevelChange struct {
+	Side   orderbook.Side `json:"side"`
+	Price  float64        `json:"price"`
+	Volume uint32         `json:"volume"`
+	Orders int            `json:"orders"`
+}
+
+// DiffBook returns the levels that differ between two snapshots of the same
+// book, bids first. A nil prev yields every level of cur.
+func DiffBook(prev, cur *BookSnapshot) []LevelChange {
+	var changes []LevelChange
+	diff := func(side orderbook.Side, before, after []orderbook.PriceLevel) {
+		old := make(map[float64]orderbook.PriceLevel, len(before))
+		for _, lvl := range before {
+			old[lvl.Price] = lvl
+		}
+		for _, lvl := range after {
+			if o, ok := old[lvl.Price]; !ok || o != lvl {
+				changes = append(changes, LevelChange{Side: side, Price: lvl.Price, Volume: lvl.Volume, Orders: lvl.Orders})
+			}
+			delete(old, lvl.Price)
+		}
+		for _, lvl := range before {
+			if _, gone := old[lvl.Price]; gone {
+				changes = append(changes, LevelChange{Side: side, Price: lvl.Price})
+			}
+		}
+	}
+	var prevBids, prevAsks []orderbook.PriceLevel
+	if prev != nil {
+		prevBids, prevAsks = prev.Bids, prev.Asks
+	}
+	diff(orderbook.Buy, prevBids, cur.Bids)
+	diff(orderbook.Sell, prevAsks, cur.Asks)
+	return changes
+}
@@ -0,0 +1,121 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestEventBusFiltering(t *testing.T) {
+	bus := NewEventBus()
+	trades, cancelTrades := bus.Subscribe(10, nil, []EventType{EventTrade})
+	defer cancelTrades()
+	eth, cancelEth := bus.Subscribe(10, []string{"ethusdt"}, nil)
+	defer cancelEth()
+
+	if !bus.Wants(EventTrade) || !bus.Wants(EventBook) {
+		t.Error("Wants() should report subscribed types")
+	}
+
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt"})
+	bus.Publish(Event{Type: EventBook, Symbol: "ethusdt"})
+
+	if len(trades) != 1 || len(eth) != 1 {
+		t.Errorf("trades sub got %d events, eth sub got %d; want 1 and 1", len(trades), len(eth))
+	}
+	if e := <-eth; e.Type != EventBook {
+		t.Errorf("eth sub received %v, want book", e.Type)
+	}
+}
+
+func TestEventBusDropsForSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	ch, cancel := bus.Subscribe(1, nil, nil)
+
+	bus.Publish(Event{Type: EventTrade})
+	bus.Publish(Event{Type: EventTrade}) // must not block
+
+	if len(ch) != 1 {
+		t.Errorf("buffered events = %d, want 1", len(ch))
+	}
+	cancel()
+	cancel() // idempotent
+	if bus.Wants(EventTrade) {
+		t.Error("Wants() should be false after the only subscriber cancels")
+	}
+}
+
+func TestPublishTradeEvents(t *testing.T) {
+	bus := NewEventBus()
+	state := NewSymbolState("btcusdt")
+	ch, cancel := bus.Subscribe(16, nil, nil)
+	defer cancel()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, ts := range []time.Time{base, base.Add(time.Minute)} {
+		tr := &orderbook.Trade{Symbol: "btcusdt", Price: 100, Quantity: 1, Timestamp: ts}
+		state.Signals.OnTrade(tr, state.Book)
+		PublishTradeEvents(bus, state, tr, SpanContext{})
+	}
+
+	counts := make(map[EventType]int)
+	for len(ch) > 0 {
+		counts[(<-ch).Type]++
+	}
+	if counts[EventTrade] != 2 || counts[EventCandle] != 1 || counts[EventSignal] != 2 || counts[EventBook] != 2 {
+		t.Errorf("event counts = %v", counts)
+	}
+}
+
+func TestDiffBook(t *testing.T) {
+	prev := &BookSnapshot{
+		Bids: []orderbook.PriceLevel{{Price: 100, Volume: 5, Orders: 1}, {Price: 99, Volume: 3, Orders: 2}},
+		Asks: []orderbook.PriceLevel{{Price: 101, Volume: 4, Orders: 1}},
+	}
+	cur := &BookSnapshot{
+		Bids: []orderbook.PriceLevel{{Price: 100, Volume: 5, Orders: 1}, {Price: 98, Volume: 1, Orders: 1}},
+		Asks: []orderbook.PriceLevel{{Price: 101, Volume: 2, Orders: 1}},
+	}
+	got := DiffBook(prev, cur)
+	want := []LevelChange{
+		{Side: orderbook.Buy, Price: 98, Volume: 1, Orders: 1},
+		{Side: orderbook.Buy, Price: 99},
+		{Side: orderbook.Sell, Price: 101, Volume: 2, Orders: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("DiffBook = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if n := len(DiffBook(nil, cur)); n != 3 {
+		t.Errorf("DiffBook(nil) returned %d changes, want all 3 levels", n)
+	}
+}
+
+func TestEventJSONCarriesSchemaVersion(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	e := Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts,
+		Trade: &orderbook.Trade{Symbol: "btcusdt", ID: 7, Price: 42000, Quantity: 0.5, Side: orderbook.Buy, Timestamp: ts}}
+	b, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte(`{"schema_version":1,"type":"trade","symbol":"btcusdt"`)) {
+		t.Errorf("event JSON = %s, want schema_version first", b)
+	}
+
+	// Consumers decode through the version and ignore fields they do not know
+	var back Event
+	if err := json.Unmarshal(append(b[:len(b)-1], `,"venue":"binance"}`...), &back); err != nil {
+		t.Fatal(err)
+	}
+	if back.Type != EventTrade || back.Trade == nil || back.Trade.ID != 7 {
+		t.Errorf("decoded %+v, want the trade back", back)
+	}
+}
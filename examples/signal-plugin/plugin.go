@@ -0,0 +1,49 @@
+//go:build ignore
+
+// An example signal plugin. Build it with
+//
+//	go build -buildmode=plugin -o spread_z.so examples/signal-plugin/plugin.go
+//
+// and load it with --signal-plugin spread_z.so.
+package main
+
+import (
+	"math"
+
+	"apexlob/pkg/signals"
+)
+
+func NewSignals() []signals.Signal {
+	return []signals.Signal{&spreadZ{window: 100}}
+}
+
+// spreadZ is how many standard deviations the spread is from its mean over
+// the last window updates.
+type spreadZ struct {
+	window  int
+	spreads []float64
+}
+
+func (s *spreadZ) Name() string { return "spread_z" }
+
+func (s *spreadZ) Update(in *signals.Input) float64 {
+	spread, ok := in.Values["spread_bps"]
+	if !ok {
+		return math.NaN()
+	}
+	if s.spreads = append(s.spreads, spread); len(s.spreads) > s.window {
+		s.spreads = s.spreads[1:]
+	}
+	var sum, sq float64
+	for _, v := range s.spreads {
+		sum += v
+		sq += v * v
+	}
+	n := float64(len(s.spreads))
+	mean := sum / n
+	sd := math.Sqrt(sq/n - mean*mean)
+	if sd == 0 {
+		return 0
+	}
+	return (spread - mean) / sd
+}
@@ -0,0 +1,335 @@
+package apexlob
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// OrderStatus is where an order entered on the exchange stands.
+type OrderStatus string
+
+const (
+	OrderNew             OrderStatus = "NEW"
+	OrderPartiallyFilled OrderStatus = "PARTIALLY_FILLED"
+	OrderFilled          OrderStatus = "FILLED"
+	OrderCanceled        OrderStatus = "CANCELED"
+	OrderRejected        OrderStatus = "REJECTED"
+)
+
+// ExecType says what an OrderReport reports.
+type ExecType string
+
+const (
+	ExecNew      ExecType = "NEW"
+	ExecTrade    ExecType = "TRADE"
+	ExecCanceled ExecType = "CANCELED"
+	ExecReplaced ExecType = "REPLACED"
+	ExecRejected ExecType = "REJECTED"
+)
+
+// NewOrder is a limit order entered on the exchange.
+type NewOrder struct {
+	Symbol        string         `json:"symbol"`
+	Side          orderbook.Side `json:"side"`
+	Price         float64        `json:"price"`
+	Quantity      float64        `json:"quantity"`
+	ClientOrderID string         `json:"client_order_id,omitempty"`
+	// Account groups orders whose reports are streamed together
+	Account string `json:"account,omitempty"`
+	// owner is the FIX session that entered the order, which alone
+	// receives its reports
+	owner string
+}
+
+// OrderReport is an execution report: an order was accepted, traded,
+// cancelled, replaced or rejected. The Last fields are set on trades.
+type OrderReport struct {
+	OrderID       uint64         `json:"order_id"`
+	ClientOrderID string         `json:"client_order_id,omitempty"`
+	Account       string         `json:"account,omitempty"`
+	Symbol        string         `json:"symbol"`
+	Side          orderbook.Side `json:"side"`
+	ExecType      ExecType       `json:"exec_type,omitempty"` // empty when the order is looked up
+	Status        OrderStatus    `json:"status"`
+	Price         float64        `json:"price"`
+	Quantity      float64        `json:"quantity"`
+	Filled        float64        `json:"filled"`
+	Remaining     float64        `json:"remaining"`
+	TradeID       uint64         `json:"trade_id,omitempty"`
+	LastPrice     float64        `json:"last_price,omitempty"`
+	LastQuantity  float64        `json:"last_quantity,omitempty"`
+	Maker         bool           `json:"maker,omitempty"`
+	Reason        string         `json:"reason,omitempty"`
+	Timestamp     time.Time      `json:"timestamp"`
+	owner         string
+}
+
+var errUnknownOrder = errors.New("unknown order")
+
+// exchangeOrder is an order entered on the exchange, in the book's integer
+// quantity units.
+type exchangeOrder struct {
+	NewOrder
+	id       uint64
+	quantity uint32
+	filled   uint32
+	status   OrderStatus
+}
+
+func (o *exchangeOrder) report(exec ExecType, at time.Time) OrderReport {
+	return OrderReport{
+		OrderID:       o.id,
+		ClientOrderID: o.ClientOrderID,
+		Account:       o.Account,
+		Symbol:        o.Symbol,
+		Side:          o.Side,
+		ExecType:      exec,
+		Status:        o.status,
+		Price:         o.Price,
+		Quantity:      float64(o.quantity) / 1000,
+		Filled:        float64(o.filled) / 1000,
+		Remaining:     float64(o.quantity-o.filled) / 1000,
+		Timestamp:     at,
+		owner:         o.owner,
+	}
+}
+
+func (o *exchangeOrder) open() bool {
+	return o.status == OrderNew || o.status == OrderPartiallyFilled
+}
+
+// Exchange runs the monitor's books as a venue: clients enter, cancel and
+// modify limit orders, the books match them, every change to an order is
+// published as an OrderReport event and the trades go through the tape,
+// signals, candles and rules as feed trades do. It serves the exchange
+// command, which has no feed, so nothing else changes the books.
+type Exchange struct {
+	mu        sync.Mutex // one order at a time across every book
+	symbols   *SymbolRegistry
+	bus       *EventBus
+	rules     func(symbol string) *RuleEngine
+	orders    map[uint64]*exchangeOrder
+	nextOrder uint64
+	nextTrade uint64
+	fills     []orderbook.Execution // of the order being matched
+	now       func() time.Time
+}
+
+// OpenExchange makes m a venue for order entry. It must be called before
+// Run, and not with a strategy attached or with mirrored books.
+func (m *Monitor) OpenExchange() (*Exchange, error) {
+	if mirrored := m.MirroredSymbols(); len(mirrored) > 0 {
+		return nil, fmt.Errorf("the exchange cannot match mirrored books (%s)", strings.Join(mirrored, ", "))
+	}
+	if m.strategy != nil {
+		return nil, errors.New("the exchange cannot share its books with a strategy")
+	}
+	m.exchange = &Exchange{
+		symbols: m.Symbols,
+		bus:     m.Bus,
+		rules:   func(sym string) *RuleEngine { return m.Rules[m.Shards.Shard(sym)] },
+		orders:  make(map[uint64]*exchangeOrder),
+		now:     time.Now,
+	}
+	return m.exchange, nil
+}
+
+// execution collects a fill of the order being matched. The books call it
+// under the exchange's lock.
+func (x *Exchange) execution(ex *orderbook.Execution) {
+	x.fills = append(x.fills, *ex)
+}
+
+// Submit enters a limit order, matching it against the book straight away
+// and resting what is left. It returns the order's last report, which is a
+// rejection if the order was invalid.
+func (x *Exchange) Submit(req NewOrder) OrderReport {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	req.Symbol = strings.ToLower(strings.TrimSpace(req.Symbol))
+	state, ok := x.symbols.Get(req.Symbol)
+	switch {
+	case !ok:
+		return x.reject(req, fmt.Sprintf("unknown symbol %q", req.Symbol), now)
+	case req.Side != orderbook.Buy && req.Side != orderbook.Sell:
+		return x.reject(req, "invalid side", now)
+	case !(req.Price > 0):
+		return x.reject(req, fmt.Sprintf("invalid price %v", req.Price), now)
+	}
+	qty := orderbook.ScaleQuantity(req.Quantity)
+	if qty == 0 {
+		return x.reject(req, fmt.Sprintf("invalid quantity %v", req.Quantity), now)
+	}
+	x.nextOrder++
+	o := &exchangeOrder{NewOrder: req, id: x.nextOrder, quantity: qty, status: OrderNew}
+	x.orders[o.id] = o
+	accepted := o.report(ExecNew, now)
+	x.publish(accepted)
+	return x.match(state, o, accepted, now)
+}
+
+// Reject publishes the rejection of an order request that could not be
+// read, so its account's stream sees it.
+func (x *Exchange) Reject(req NewOrder, reason string) OrderReport {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.reject(req, reason, x.now())
+}
+
+func (x *Exchange) reject(req NewOrder, reason string, at time.Time) OrderReport {
+	o := exchangeOrder{NewOrder: req, quantity: orderbook.ScaleQuantity(req.Quantity), status: OrderRejected}
+	r := o.report(ExecRejected, at)
+	r.Remaining, r.Reason = 0, reason
+	x.publish(r)
+	return r
+}
+
+// match submits what is left of o to its book and reports the trades. It
+// returns o's last report: its last trade, or last if it did not trade.
+func (x *Exchange) match(state *SymbolState, o *exchangeOrder, last OrderReport, now time.Time) OrderReport {
+	order := orderbook.AcquireOrder()
+	order.ID, order.Price, order.Quantity, order.Side, order.EntryTime = o.id, o.Price, o.quantity-o.filled, o.Side, now
+	x.fills = x.fills[:0]
+	if !state.Book.SubmitOrder(order) {
+		orderbook.ReleaseOrder(order)
+	}
+
+	for _, ex := range x.fills {
+		x.nextTrade++
+		last = x.fill(o, ex, false, now)
+		if maker, ok := x.orders[ex.MakerID]; ok {
+			x.fill(maker, ex, true, now)
+		}
+		tr := orderbook.Trade{
+			Symbol:    state.Symbol,
+			ID:        x.nextTrade,
+			Price:     ex.Price,
+			Quantity:  float64(ex.Quantity) / 1000,
+			Side:      o.Side,
+			Timestamp: now,
+		}
+		state.Tape.Add(tr)
+		state.Range.Add(tr.Price, tr.Timestamp)
+		state.TradeSizes.Observe(tr.Price * tr.Quantity)
+		state.Signals.OnTrade(&tr, state.Book)
+		PublishTradeEvents(x.bus, state, &tr, SpanContext{})
+		x.rules(state.Symbol).Evaluate(state.Symbol, state.Signals.Snapshot(), now)
+	}
+	if len(x.fills) == 0 {
+		PublishBook(x.bus, state, now, SpanContext{})
+	}
+	return last
+}
+
+// fill applies one execution to o and reports it.
+func (x *Exchange) fill(o *exchangeOrder, ex orderbook.Execution, maker bool, now time.Time) OrderReport {
+	o.filled += ex.Quantity
+	o.status = OrderPartiallyFilled
+	if o.filled >= o.quantity {
+		o.status = OrderFilled
+	}
+	r := o.report(ExecTrade, now)
+	r.TradeID, r.LastPrice, r.LastQuantity, r.Maker = x.nextTrade, ex.Price, float64(ex.Quantity)/1000, maker
+	x.publish(r)
+	return r
+}
+
+// Cancel withdraws what is left of an open order.
+func (x *Exchange) Cancel(id uint64) (OrderReport, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	o, state, err := x.openOrder(id)
+	if err != nil {
+		return OrderReport{}, err
+	}
+	state.Book.CancelOrder(id)
+	o.status = OrderCanceled
+	r := o.report(ExecCanceled, now)
+	r.Remaining = 0
+	x.publish(r)
+	PublishBook(x.bus, state, now, SpanContext{})
+	return r, nil
+}
+
+// Modify changes an open order's price and total quantity; zero keeps
+// either as it is. The order keeps its ID but is cancelled and entered
+// again, so it loses its time priority and may trade at once.
+func (x *Exchange) Modify(id uint64, price, quantity float64) (OrderReport, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	o, state, err := x.openOrder(id)
+	if err != nil {
+		return OrderReport{}, err
+	}
+	if price < 0 || quantity < 0 {
+		return OrderReport{}, fmt.Errorf("invalid price %v or quantity %v", price, quantity)
+	}
+	qty := o.quantity
+	if quantity > 0 {
+		qty = orderbook.ScaleQuantity(quantity)
+	}
+	if qty <= o.filled {
+		return OrderReport{}, fmt.Errorf("quantity %v is not above the %v already filled", quantity, float64(o.filled)/1000)
+	}
+	state.Book.CancelOrder(id)
+	if price > 0 {
+		o.Price = price
+	}
+	o.quantity = qty
+	replaced := o.report(ExecReplaced, now)
+	x.publish(replaced)
+	return x.match(state, o, replaced, now), nil
+}
+
+func (x *Exchange) openOrder(id uint64) (*exchangeOrder, *SymbolState, error) {
+	o, ok := x.orders[id]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %d", errUnknownOrder, id)
+	}
+	if !o.open() {
+		return nil, nil, fmt.Errorf("order %d is %s", id, strings.ToLower(string(o.status)))
+	}
+	state, _ := x.symbols.Get(o.Symbol)
+	return o, state, nil
+}
+
+// Order returns an order's current state.
+func (x *Exchange) Order(id uint64) (OrderReport, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	o, ok := x.orders[id]
+	if !ok {
+		return OrderReport{}, false
+	}
+	return o.report("", x.now()), true
+}
+
+// OpenOrders lists the open orders of account in symbol, oldest first. An
+// empty account or symbol matches every one.
+func (x *Exchange) OpenOrders(account, symbol string) []OrderReport {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	out := []OrderReport{}
+	for _, o := range x.orders {
+		if o.open() && (account == "" || o.Account == account) && (symbol == "" || o.Symbol == symbol) {
+			out = append(out, o.report("", now))
+		}
+	}
+	slices.SortFunc(out, func(a, b OrderReport) int { return cmp.Compare(a.OrderID, b.OrderID) })
+	return out
+}
+
+func (x *Exchange) publish(r OrderReport) {
+	x.bus.Publish(Event{Type: EventOrder, Symbol: r.Symbol, Timestamp: r.Timestamp, Order: &r})
+}
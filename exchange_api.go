@@ -0,0 +1,204 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ExchangeHandler serves order entry over HTTP, mounted at /orders:
+//
+//	POST   /orders        enter a NewOrder
+//	GET    /orders        open orders, filtered by ?account= and ?symbol=
+//	GET    /orders/{id}   an order's current state
+//	PATCH  /orders/{id}   modify its {"price", "quantity"}
+//	DELETE /orders/{id}   cancel it
+//	GET    /orders/ws     a WebSocket session, see serveSession
+//
+// Every answer is an OrderReport carrying schema_version, or {"error": ...}
+// for a request that names no open order.
+func (x *Exchange) Handler() http.Handler {
+	upgrader := websocket.Upgrader{ReadBufferSize: 4096, WriteBufferSize: 4096}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/orders"), "/")
+		switch {
+		case path == "ws":
+			x.serveSession(w, r, &upgrader)
+		case path == "" && r.Method == http.MethodPost:
+			var req NewOrder
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid order: "+err.Error())
+				return
+			}
+			report := x.Submit(req)
+			if report.Status == OrderRejected {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(versionedOrder{EventSchemaVersion, &report})
+				return
+			}
+			writeJSON(w, versionedOrder{EventSchemaVersion, &report})
+		case path == "" && r.Method == http.MethodGet:
+			q := r.URL.Query()
+			open := x.OpenOrders(q.Get("account"), strings.ToLower(q.Get("symbol")))
+			reports := make([]versionedOrder, len(open))
+			for i := range open {
+				reports[i] = versionedOrder{EventSchemaVersion, &open[i]}
+			}
+			writeJSON(w, reports)
+		case path == "":
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			id, err := strconv.ParseUint(path, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusNotFound, "invalid order ID "+strconv.Quote(path))
+				return
+			}
+			x.serveOrder(w, r, id)
+		}
+	})
+}
+
+func (x *Exchange) serveOrder(w http.ResponseWriter, r *http.Request, id uint64) {
+	var report OrderReport
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		var ok bool
+		if report, ok = x.Order(id); !ok {
+			writeError(w, http.StatusNotFound, "unknown order "+strconv.FormatUint(id, 10))
+			return
+		}
+	case http.MethodDelete:
+		report, err = x.Cancel(id)
+	case http.MethodPatch:
+		var req struct {
+			Price    float64 `json:"price"`
+			Quantity float64 `json:"quantity"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid modification: "+err.Error())
+			return
+		}
+		report, err = x.Modify(id, req.Price, req.Quantity)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch {
+	case errors.Is(err, errUnknownOrder):
+		writeError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSON(w, versionedOrder{EventSchemaVersion, &report})
+	}
+}
+
+// sessionRequest is a message from a WebSocket order entry client. Op is
+// new, cancel or modify; new orders carry the NewOrder fields.
+type sessionRequest struct {
+	Op string `json:"op"`
+	NewOrder
+	OrderID uint64 `json:"order_id"`
+}
+
+// serveSession runs a WebSocket order entry session. The client sends
+// sessionRequests and receives the OrderReports of ?account= (of every
+// account if it is not given) as they happen, its own included; a cancel or
+// modify that names no open order is answered with {"error", "order_id"}.
+// Both carry schema_version.
+func (x *Exchange) serveSession(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader) {
+	account := r.URL.Query().Get("account")
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already replied with an HTTP error
+	}
+	defer conn.Close()
+
+	// Subscribed before reading requests, so no report of theirs is missed
+	reports, cancel := x.bus.SubscribeAs("orders:"+r.RemoteAddr, 1024, nil, []EventType{EventOrder})
+	defer cancel()
+
+	type sessionError struct {
+		SchemaVersion int    `json:"schema_version"`
+		Error         string `json:"error"`
+		OrderID       uint64 `json:"order_id,omitempty"`
+	}
+	errs := make(chan sessionError, 16)
+	closed := make(chan struct{})
+	// done is closed once the write loop returns, so a reader blocked on a
+	// full errs gives up instead of leaking
+	done := make(chan struct{})
+	defer close(done)
+	sendErr := func(e sessionError) bool {
+		select {
+		case errs <- e:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	go func() {
+		defer close(closed)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req sessionRequest
+			if err := json.Unmarshal(msg, &req); err != nil {
+				if !sendErr(sessionError{SchemaVersion: EventSchemaVersion, Error: "invalid request: " + err.Error()}) {
+					return
+				}
+				continue
+			}
+			if req.Account == "" {
+				req.Account = account
+			}
+			switch req.Op {
+			case "new":
+				x.Submit(req.NewOrder)
+			case "cancel":
+				_, err = x.Cancel(req.OrderID)
+			case "modify":
+				_, err = x.Modify(req.OrderID, req.Price, req.Quantity)
+			default:
+				err = errors.New("unknown op " + strconv.Quote(req.Op))
+			}
+			if err != nil && !sendErr(sessionError{SchemaVersion: EventSchemaVersion, Error: err.Error(), OrderID: req.OrderID}) {
+				return
+			}
+		}
+	}()
+
+	for {
+		var msg any
+		select {
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case e := <-errs:
+			msg = e
+		case e, ok := <-reports:
+			if !ok {
+				return
+			}
+			if account != "" && e.Order.Account != account {
+				continue
+			}
+			msg = versionedOrder{EventSchemaVersion, e.Order}
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteJSON(msg); err != nil {
+			logger("exchange").Warn("dropping order entry client", "remote", r.RemoteAddr, "err", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestExchange(t *testing.T) (*Monitor, *Exchange) {
+	t.Helper()
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, quietAlerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	x, err := m.OpenExchange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := m.Run()
+	t.Cleanup(func() {
+		m.Shards.Close()
+		<-done
+	})
+	return m, x
+}
+
+func TestExchangeMatchesOrders(t *testing.T) {
+	m, x := newTestExchange(t)
+	events, cancel := m.Bus.Subscribe(64, nil, []EventType{EventOrder, EventTrade})
+	defer cancel()
+
+	ask := x.Submit(NewOrder{Symbol: "BTCUSDT", Side: orderbook.Sell, Price: 100, Quantity: 2, Account: "maker"})
+	if ask.Status != OrderNew || ask.OrderID != 1 || ask.Remaining != 2 {
+		t.Fatalf("resting ask: %+v", ask)
+	}
+	bid := x.Submit(NewOrder{Symbol: "btcusdt", Side: orderbook.Buy, Price: 101, Quantity: 3, ClientOrderID: "b1", Account: "taker"})
+	if bid.ExecType != ExecTrade || bid.Status != OrderPartiallyFilled || bid.LastPrice != 100 || bid.LastQuantity != 2 || bid.Remaining != 1 || bid.ClientOrderID != "b1" {
+		t.Errorf("crossing bid: %+v", bid)
+	}
+	if r, _ := x.Order(1); r.Status != OrderFilled {
+		t.Errorf("ask after the cross: %+v", r)
+	}
+	if open := x.OpenOrders("taker", ""); len(open) != 1 || open[0].OrderID != 2 {
+		t.Errorf("taker's open orders: %+v", open)
+	}
+
+	var got []string
+	for len(events) > 0 {
+		e := <-events
+		if e.Type == EventTrade {
+			got = append(got, "trade")
+		} else {
+			got = append(got, string(e.Order.ExecType)+"/"+e.Order.Account)
+		}
+	}
+	want := "NEW/maker NEW/taker TRADE/taker TRADE/maker trade"
+	if strings.Join(got, " ") != want {
+		t.Errorf("events %v, want %s", got, want)
+	}
+	state, _ := m.Symbols.Get("btcusdt")
+	if trades := state.Tape.Recent(10); len(trades) != 1 || trades[0].Price != 100 || trades[0].Quantity != 2 || trades[0].Side != orderbook.Buy {
+		t.Errorf("tape %+v", trades)
+	}
+
+	// A modify re-enters the order, which can trade on its new price
+	x.Submit(NewOrder{Symbol: "btcusdt", Side: orderbook.Sell, Price: 105, Quantity: 1, Account: "maker"})
+	r, err := x.Modify(2, 0, 5)
+	if err != nil || r.ExecType != ExecReplaced || r.Quantity != 5 || r.Remaining != 3 {
+		t.Errorf("modify quantity: %+v, %v", r, err)
+	}
+	if r, err = x.Modify(2, 105, 0); err != nil || r.ExecType != ExecTrade || r.Filled != 3 || r.Remaining != 2 {
+		t.Errorf("modify price: %+v, %v", r, err)
+	}
+	if r, err = x.Cancel(2); err != nil || r.Status != OrderCanceled || r.Remaining != 0 {
+		t.Errorf("cancel: %+v, %v", r, err)
+	}
+	if bid, ask, ok := state.Book.GetBestBid(); ok {
+		t.Errorf("bid %v x %v still in the book after the cancel", bid, ask)
+	}
+	if _, err := x.Cancel(2); err == nil {
+		t.Error("cancelled an order twice")
+	}
+	if _, err := x.Cancel(99); err == nil {
+		t.Error("cancelled an unknown order")
+	}
+	if r := x.Submit(NewOrder{Symbol: "ethusdt", Side: orderbook.Buy, Price: 1, Quantity: 1}); r.Status != OrderRejected || r.Reason == "" {
+		t.Errorf("order for an unknown symbol: %+v", r)
+	}
+}
+
+func TestExchangeHandler(t *testing.T) {
+	_, x := newTestExchange(t)
+	srv := httptest.NewServer(x.Handler())
+	defer srv.Close()
+
+	do := func(method, path, body string, want int) OrderReport {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: %s, want %d", method, path, resp.Status, want)
+		}
+		var r struct {
+			SchemaVersion int `json:"schema_version"`
+			OrderReport
+		}
+		json.NewDecoder(resp.Body).Decode(&r)
+		if r.SchemaVersion != EventSchemaVersion && want < 300 {
+			t.Errorf("%s %s: schema_version %d", method, path, r.SchemaVersion)
+		}
+		return r.OrderReport
+	}
+	if r := do("POST", "/orders", `{"symbol":"btcusdt","side":"BUY","price":99.5,"quantity":1.5}`, 200); r.OrderID != 1 || r.Status != OrderNew {
+		t.Errorf("new order: %+v", r)
+	}
+	do("POST", "/orders", `{"symbol":"btcusdt","side":"BUY","price":-1,"quantity":1}`, 400)
+	if r := do("PATCH", "/orders/1", `{"price":99}`, 200); r.Price != 99 || r.Quantity != 1.5 {
+		t.Errorf("modified order: %+v", r)
+	}
+	if r := do("DELETE", "/orders/1", "", 200); r.Status != OrderCanceled {
+		t.Errorf("cancelled order: %+v", r)
+	}
+	do("DELETE", "/orders/1", "", 409)
+	do("GET", "/orders/7", "", 404)
+	if r := do("GET", "/orders/1", "", 200); r.Status != OrderCanceled {
+		t.Errorf("order lookup: %+v", r)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/orders/ws?account=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for !x.bus.Wants(EventOrder) {
+		time.Sleep(time.Millisecond)
+	}
+	x.Submit(NewOrder{Symbol: "btcusdt", Side: orderbook.Sell, Price: 100, Quantity: 1, Account: "bob"})
+	conn.WriteJSON(map[string]any{"op": "new", "symbol": "btcusdt", "side": "BUY", "price": 100, "quantity": 1, "client_order_id": "a1"})
+	conn.WriteJSON(map[string]any{"op": "cancel", "order_id": 42})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var got []string
+	for len(got) < 3 {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r struct {
+			SchemaVersion int `json:"schema_version"`
+			OrderReport
+			Error string `json:"error"`
+		}
+		json.Unmarshal(msg, &r)
+		if !bytes.Contains(msg, []byte(`"error"`)) && r.Account != "alice" {
+			t.Errorf("alice received %s", msg)
+		}
+		if r.SchemaVersion != EventSchemaVersion {
+			t.Errorf("session message without schema_version: %s", msg)
+		}
+		got = append(got, string(r.ExecType)+r.Error)
+	}
+	// Errors and reports reach the client in either order
+	slices.Sort(got)
+	if want := "NEW,TRADE,unknown order 42"; strings.Join(got, ",") != want {
+		t.Errorf("session received %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+type ExportConfig struct {
+	Dir            string
+	Format         string // csv, jsonl or sbe
+	RotateBytes    int64
+	RotateInterval time.Duration
+	SignalInterval time.Duration // minimum spacing of signal snapshots per symbol
+}
+
+// FileExporter writes trades and sampled signal snapshots to rotating CSV,
+// JSON Lines or framed SBE files. Signals are written in long form (one row per value) so
+// the column set never changes as signals are added.
+type FileExporter struct {
+	cfg         ExportConfig
+	trades      *RotatingFile
+	signals     *RotatingFile
+	lastSignals map[string]time.Time
+}
+
+var (
+	tradeCSVHeader  = []string{"timestamp", "symbol", "id", "side", "price", "quantity"}
+	signalCSVHeader = []string{"timestamp", "symbol", "signal", "value"}
+)
+
+func NewFileExporter(cfg ExportConfig) (*FileExporter, error) {
+	ext := ".jsonl"
+	switch cfg.Format {
+	case "csv":
+		ext = ".csv"
+	case "jsonl":
+	case "sbe":
+		ext = ".sbe"
+	default:
+		return nil, fmt.Errorf("unknown export format %q (want csv, jsonl or sbe)", cfg.Format)
+	}
+
+	trades, err := NewRotatingFile(cfg.Dir, "trades", ext, cfg.RotateBytes, cfg.RotateInterval)
+	if err != nil {
+		return nil, err
+	}
+	signals, err := NewRotatingFile(cfg.Dir, "signals", ext, cfg.RotateBytes, cfg.RotateInterval)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Format == "csv" {
+		trades.onOpen = csvHeader(tradeCSVHeader)
+		signals.onOpen = csvHeader(signalCSVHeader)
+	}
+	return &FileExporter{cfg: cfg, trades: trades, signals: signals, lastSignals: make(map[string]time.Time)}, nil
+}
+
+func csvHeader(cols []string) func(w *bufio.Writer) error {
+	return func(w *bufio.Writer) error {
+		cw := csv.NewWriter(w)
+		cw.Write(cols)
+		cw.Flush()
+		return cw.Error()
+	}
+}
+
+// OnComplete calls fn with each file the exporter has finished writing.
+func (fe *FileExporter) OnComplete(fn func(path string)) {
+	fe.trades.onClose, fe.signals.onClose = fn, fn
+}
+
+func (fe *FileExporter) Name() string { return "file-" + fe.cfg.Format }
+
+func (fe *FileExporter) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		if fe.cfg.Format == "sbe" {
+			return writeSBEFrame(fe.trades, e)
+		}
+		return fe.writeTrade(e.Trade)
+	case EventSignal:
+		if last, ok := fe.lastSignals[e.Symbol]; ok && e.Timestamp.Sub(last) < fe.cfg.SignalInterval {
+			return nil
+		}
+		fe.lastSignals[e.Symbol] = e.Timestamp
+		if fe.cfg.Format == "sbe" {
+			return writeSBEFrame(fe.signals, e)
+		}
+		return fe.writeSignals(e)
+	}
+	return nil
+}
+
+func (fe *FileExporter) writeTrade(tr *orderbook.Trade) error {
+	if fe.cfg.Format == "jsonl" {
+		return writeJSONLine(fe.trades, versionedTrade{EventSchemaVersion, tr})
+	}
+	cw := csv.NewWriter(fe.trades)
+	cw.Write([]string{
+		tr.Timestamp.UTC().Format(time.RFC3339Nano),
+		tr.Symbol,
+		strconv.FormatUint(tr.ID, 10),
+		tr.Side.String(),
+		strconv.FormatFloat(tr.Price, 'f', -1, 64),
+		strconv.FormatFloat(tr.Quantity, 'f', -1, 64),
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+func (fe *FileExporter) writeSignals(e *Event) error {
+	if fe.cfg.Format == "jsonl" {
+		return writeJSONLine(fe.signals, struct {
+			SchemaVersion int                `json:"schema_version"`
+			Timestamp     time.Time          `json:"timestamp"`
+			Symbol        string             `json:"symbol"`
+			Signals       map[string]float64 `json:"signals"`
+		}{EventSchemaVersion, e.Timestamp, e.Symbol, e.Signals})
+	}
+	ts := e.Timestamp.UTC().Format(time.RFC3339Nano)
+	cw := csv.NewWriter(fe.signals)
+	for _, name := range sortedKeys(e.Signals) {
+		cw.Write([]string{ts, e.Symbol, name, strconv.FormatFloat(e.Signals[name], 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONLine(rf *RotatingFile, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = rf.Write(append(b, '\n'))
+	return err
+}
+
+// writeSBEFrame writes e as one SBE message behind a Simple Open Framing
+// Header, so files can be read back with ReadSBEFrame.
+func writeSBEFrame(rf *RotatingFile, e *Event) error {
+	msg, err := AppendSBE(make([]byte, sofhSize, 128), e)
+	if err != nil {
+		return err
+	}
+	AppendSOFH(msg[:0], len(msg)-sofhSize)
+	_, err = rf.Write(msg)
+	return err
+}
+
+func (fe *FileExporter) Flush() error {
+	if err := fe.trades.Flush(); err != nil {
+		return err
+	}
+	return fe.signals.Flush()
+}
+
+func (fe *FileExporter) Close() error {
+	err := fe.trades.Close()
+	if serr := fe.signals.Close(); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func readLines(t *testing.T, pattern string) []string {
+	t.Helper()
+	f, err := os.Open(mustGlob(t, pattern))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+func TestFileExporterCSV(t *testing.T) {
+	dir := t.TempDir()
+	fe, err := NewFileExporter(ExportConfig{Dir: dir, Format: "csv", SignalInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tr := &orderbook.Trade{Symbol: "btcusdt", ID: 7, Price: 42000.5, Quantity: 0.25, Side: orderbook.Sell, Timestamp: base}
+	fe.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: base, Trade: tr})
+	fe.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: base, Signals: map[string]float64{"vwap": 42000, "imbalance": 0.1}})
+	// Within the signal interval: sampled out
+	fe.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: base.Add(500 * time.Millisecond), Signals: map[string]float64{"vwap": 1}})
+	fe.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: base.Add(time.Second), Signals: map[string]float64{"vwap": 42001}})
+	if err := fe.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	trades := readLines(t, filepath.Join(dir, "trades-*.csv"))
+	want := []string{"timestamp,symbol,id,side,price,quantity", "2024-01-02T03:04:05Z,btcusdt,7,SELL,42000.5,0.25"}
+	if len(trades) != 2 || trades[0] != want[0] || trades[1] != want[1] {
+		t.Errorf("trades file = %q, want %q", trades, want)
+	}
+
+	f, err := os.Open(mustGlob(t, filepath.Join(dir, "signals-*.csv")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// header + two values from the first snapshot + one from the third
+	if len(rows) != 4 {
+		t.Fatalf("signal rows = %v, want 4", rows)
+	}
+	if rows[1][2] != "imbalance" || rows[2][2] != "vwap" || rows[3][3] != "42001" {
+		t.Errorf("unexpected signal rows %v", rows)
+	}
+}
+
+func mustGlob(t *testing.T, pattern string) string {
+	t.Helper()
+	paths, _ := filepath.Glob(pattern)
+	if len(paths) != 1 {
+		t.Fatalf("files matching %s = %v, want exactly one", pattern, paths)
+	}
+	return paths[0]
+}
+
+func TestFileExporterJSONL(t *testing.T) {
+	dir := t.TempDir()
+	fe, err := NewFileExporter(ExportConfig{Dir: dir, Format: "jsonl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	fe.Write(&Event{Type: EventTrade, Symbol: "ethusdt", Timestamp: now, Trade: &orderbook.Trade{Symbol: "ethusdt", ID: 1, Price: 3000, Quantity: 1, Side: orderbook.Buy, Timestamp: now}})
+	fe.Write(&Event{Type: EventSignal, Symbol: "ethusdt", Timestamp: now, Signals: map[string]float64{"rsi_14": 55}})
+	fe.Close()
+
+	var tr orderbook.Trade
+	if err := json.Unmarshal([]byte(readLines(t, filepath.Join(dir, "trades-*.jsonl"))[0]), &tr); err != nil {
+		t.Fatal(err)
+	}
+	if tr.ID != 1 || tr.Side != orderbook.Buy || tr.Price != 3000 {
+		t.Errorf("decoded trade = %+v", tr)
+	}
+
+	var snap struct {
+		Symbol  string             `json:"symbol"`
+		Signals map[string]float64 `json:"signals"`
+	}
+	if err := json.Unmarshal([]byte(readLines(t, filepath.Join(dir, "signals-*.jsonl"))[0]), &snap); err != nil {
+		t.Fatal(err)
+	}
+	if snap.Symbol != "ethusdt" || snap.Signals["rsi_14"] != 55 {
+		t.Errorf("decoded snapshot = %+v", snap)
+	}
+}
+
+func TestFileExporterRejectsUnknownFormat(t *testing.T) {
+	if _, err := NewFileExporter(ExportConfig{Dir: t.TempDir(), Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestFileExporterSBE(t *testing.T) {
+	dir := t.TempDir()
+	fe, err := NewFileExporter(ExportConfig{Dir: dir, Format: "sbe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	fe.Write(&Event{Type: EventTrade, Symbol: "ethusdt", Timestamp: now, Trade: &orderbook.Trade{Symbol: "ethusdt", ID: 1, Price: 3000, Quantity: 1, Side: orderbook.Buy, Timestamp: now}})
+	fe.Write(&Event{Type: EventTrade, Symbol: "ethusdt", Timestamp: now, Trade: &orderbook.Trade{Symbol: "ethusdt", ID: 2, Price: 3001, Quantity: 2, Side: orderbook.Sell, Timestamp: now}})
+	fe.Close()
+
+	f, err := os.Open(mustGlob(t, filepath.Join(dir, "trades-*.sbe")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for id := uint64(1); id <= 2; id++ {
+		e, err := ReadSBEFrame(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Trade.ID != id || !e.Timestamp.Equal(now) {
+			t.Errorf("trade %d decoded as %+v", id, e.Trade)
+		}
+	}
+}
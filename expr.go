@@ -0,0 +1,434 @@
+package apexlob
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// Expressions are evaluated over signal values. Booleans are represented as
+// 1 (true) and 0 (false), so comparisons and arithmetic compose freely:
+//
+//	ofi_1m > 0.8 && spread_bps < 2
+//	(sma_10 - sma_30) / sma_30 * 100 >= 0.5 || !(rsi_14 < 70)
+//	abs(imbalance) > max(0.5, ofi_1m)
+//
+// Derived signals may also call the functions of windowFuncs, which
+// remember the values an expression took over a trailing window of trade
+// time, as in zscore(spread_bps, 5m).
+type Expr interface {
+	Eval(values map[string]float64) (float64, error)
+}
+
+type numberExpr float64
+
+type identExpr string
+
+type unaryExpr struct {
+	op string
+	x  Expr
+}
+
+type binaryExpr struct {
+	op   string
+	l, r Expr
+}
+
+type callExpr struct {
+	fn   func(args []float64) float64
+	args []Expr
+	vals []float64 // scratch
+}
+
+// exprFuncs are the functions any expression can call, by name.
+var exprFuncs = map[string]struct {
+	arity int
+	fn    func(args []float64) float64
+}{
+	"abs":  {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt": {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"log":  {1, func(a []float64) float64 { return math.Log(a[0]) }},
+	"min":  {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":  {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+// windowFuncs are the functions only derived signals can call, of an
+// expression and a window, as in mean(ofi_1m, 10m). Each call keeps the
+// values its expression took over the window, as of the trades the
+// signal was updated with: mean and stddev are theirs, zscore is how many
+// standard deviations the latest is from their mean and delta is the
+// latest less the oldest.
+var windowFuncs = map[string]bool{"mean": true, "stddev": true, "zscore": true, "delta": true}
+
+type windowExpr struct {
+	name       string
+	x          Expr
+	window     time.Duration
+	now        *time.Time // of the trade being evaluated
+	samples    []windowSample
+	sum, sumSq float64
+}
+
+type windowSample struct {
+	at    time.Time
+	value float64
+}
+
+func (n numberExpr) Eval(map[string]float64) (float64, error) { return float64(n), nil }
+
+func (id identExpr) Eval(values map[string]float64) (float64, error) {
+	v, ok := values[string(id)]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", string(id))
+	}
+	return v, nil
+}
+
+func (u *unaryExpr) Eval(values map[string]float64) (float64, error) {
+	x, err := u.x.Eval(values)
+	if err != nil {
+		return 0, err
+	}
+	if u.op == "!" {
+		return boolValue(x == 0), nil
+	}
+	return -x, nil
+}
+
+func (b *binaryExpr) Eval(values map[string]float64) (float64, error) {
+	l, err := b.l.Eval(values)
+	if err != nil {
+		return 0, err
+	}
+	// Short-circuit logical operators
+	switch b.op {
+	case "&&":
+		if l == 0 {
+			return 0, nil
+		}
+	case "||":
+		if l != 0 {
+			return 1, nil
+		}
+	}
+	r, err := b.r.Eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return boolValue(r != 0), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return math.NaN(), nil
+		}
+		return l / r, nil
+	case ">":
+		return boolValue(l > r), nil
+	case ">=":
+		return boolValue(l >= r), nil
+	case "<":
+		return boolValue(l < r), nil
+	case "<=":
+		return boolValue(l <= r), nil
+	case "==":
+		return boolValue(l == r), nil
+	case "!=":
+		return boolValue(l != r), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", b.op)
+}
+
+func (c *callExpr) Eval(values map[string]float64) (float64, error) {
+	for i, arg := range c.args {
+		v, err := arg.Eval(values)
+		if err != nil {
+			return 0, err
+		}
+		c.vals[i] = v
+	}
+	return c.fn(c.vals), nil
+}
+
+func (w *windowExpr) Eval(values map[string]float64) (float64, error) {
+	v, err := w.x.Eval(values)
+	if err != nil {
+		return 0, err
+	}
+	now := *w.now
+	if !math.IsNaN(v) && !math.IsInf(v, 0) {
+		w.samples = append(w.samples, windowSample{at: now, value: v})
+		w.sum += v
+		w.sumSq += v * v
+	}
+	cutoff := now.Add(-w.window)
+	i := 0
+	for i < len(w.samples) && w.samples[i].at.Before(cutoff) {
+		w.sum -= w.samples[i].value
+		w.sumSq -= w.samples[i].value * w.samples[i].value
+		i++
+	}
+	w.samples = w.samples[i:]
+	n := float64(len(w.samples))
+	if n == 0 {
+		w.sum, w.sumSq = 0, 0 // rather than carry rounding errors on
+		return math.NaN(), nil
+	}
+
+	mean := w.sum / n
+	std := math.Sqrt(math.Max(w.sumSq/n-mean*mean, 0))
+	switch w.name {
+	case "mean":
+		return mean, nil
+	case "stddev":
+		return std, nil
+	case "zscore":
+		if std == 0 {
+			return math.NaN(), nil
+		}
+		return (v - mean) / std, nil
+	default: // delta
+		return w.samples[len(w.samples)-1].value - w.samples[0].value, nil
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Binary operator precedence, lowest first
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	">": 4, ">=": 4, "<": 4, "<=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6,
+}
+
+type exprToken struct {
+	kind string // "num", "dur", "ident", "op", "eof"
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src    string
+	tokens []exprToken
+	pos    int
+	clock  *time.Time // for windowFuncs; nil rejects them
+}
+
+func ParseExpr(src string) (Expr, error) {
+	return parseExpr(src, nil)
+}
+
+// parseExpr parses src, whose calls to windowFuncs, if clock is set, take
+// the time of each evaluation from it.
+func parseExpr(src string, clock *time.Time) (Expr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens, clock: clock}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at offset %d in %q", tok.text, tok.pos, src)
+	}
+	return e, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != "eof" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *exprParser) parseBinary(minPrec int) (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := exprPrecedence[tok.text]
+		if tok.kind != "op" || !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: tok.text, l: left, r: right}
+	}
+}
+
+func (p *exprParser) parseUnary() (Expr, error) {
+	tok := p.peek()
+	if tok.kind == "op" && (tok.text == "!" || tok.text == "-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: tok.text, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case "num":
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return numberExpr(v), nil
+	case "ident":
+		if next := p.peek(); next.kind == "op" && next.text == "(" {
+			return p.parseCall(tok)
+		}
+		return identExpr(tok.text), nil
+	case "dur":
+		return nil, fmt.Errorf("unexpected duration %q at offset %d in %q", tok.text, tok.pos, p.src)
+	case "op":
+		if tok.text == "(" {
+			e, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			if closing := p.next(); closing.text != ")" {
+				return nil, fmt.Errorf("expected ) at offset %d in %q", closing.pos, p.src)
+			}
+			return e, nil
+		}
+	case "eof":
+		return nil, fmt.Errorf("unexpected end of expression %q", p.src)
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d in %q", tok.text, tok.pos, p.src)
+}
+
+// parseCall parses the arguments of a call to the function fn, whose
+// opening parenthesis is next.
+func (p *exprParser) parseCall(fn exprToken) (Expr, error) {
+	p.next()
+	var args []Expr
+	var window time.Duration
+	for {
+		if tok := p.peek(); tok.kind == "dur" {
+			p.next()
+			d, err := time.ParseDuration(tok.text)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid window %q at offset %d in %q", tok.text, tok.pos, p.src)
+			}
+			window = d
+		} else {
+			arg, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		tok := p.next()
+		if tok.text == ")" {
+			break
+		}
+		if tok.text != "," {
+			return nil, fmt.Errorf("expected , or ) at offset %d in %q", tok.pos, p.src)
+		}
+	}
+
+	if windowFuncs[fn.text] {
+		switch {
+		case p.clock == nil:
+			return nil, fmt.Errorf("%s() at offset %d keeps a window of values and can only be used in derived signals", fn.text, fn.pos)
+		case len(args) != 1 || window == 0:
+			return nil, fmt.Errorf("%s() at offset %d takes an expression and a window, as in %s(spread_bps, 5m)", fn.text, fn.pos, fn.text)
+		}
+		return &windowExpr{name: fn.text, x: args[0], window: window, now: p.clock}, nil
+	}
+	f, ok := exprFuncs[fn.text]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("unknown function %s() at offset %d in %q", fn.text, fn.pos, p.src)
+	case len(args) != f.arity || window != 0:
+		return nil, fmt.Errorf("%s() at offset %d takes %d arguments", fn.text, fn.pos, f.arity)
+	}
+	return &callExpr{fn: f.fn, args: args, vals: make([]float64, len(args))}, nil
+}
+
+func tokenizeExpr(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(src)
+	i := 0
+	for i < len(runes) {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' || runes[i] == 'E' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			// A number with a unit, such as 5m, is a duration
+			kind := "num"
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || (kind == "dur" && unicode.IsDigit(runes[i]))) {
+				kind = "dur"
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: kind, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: string(runes[start:i]), pos: start})
+		default:
+			start := i
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "&&", "||", ">=", "<=", "==", "!=":
+				tokens = append(tokens, exprToken{kind: "op", text: two, pos: start})
+				i += 2
+				continue
+			}
+			switch c {
+			case '+', '-', '*', '/', '>', '<', '!', '(', ')', ',':
+				tokens = append(tokens, exprToken{kind: "op", text: string(c), pos: start})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character %q at offset %d in %q", c, start, src)
+			}
+		}
+	}
+	tokens = append(tokens, exprToken{kind: "eof", pos: len(runes)})
+	return tokens, nil
+}
@@ -0,0 +1,73 @@
+package apexlob
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseExprEval(t *testing.T) {
+	values := map[string]float64{"ofi_1m": 0.9, "spread_bps": 1.5, "rsi_14": 75, "sma_10": 101, "sma_30": 100}
+
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"ofi_1m > 0.8 && spread_bps < 2", 1},
+		{"ofi_1m > 0.95 || spread_bps >= 2", 0},
+		{"!(rsi_14 < 70)", 1},
+		{"(sma_10 - sma_30) / sma_30 * 100", 1},
+		{"1 + 2 * 3", 7},
+		{"-spread_bps", -1.5},
+		{"2 - 1 - 1", 0},
+		{"1e-3 * 1000 == 1", 1},
+		{"spread_bps != 1.5", 0},
+		{"abs(-spread_bps) + max(1, min(rsi_14, 2))", 3.5},
+		{"sqrt(4) * log(1)", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpr(%q) error: %v", tt.expr, err)
+			}
+			got, err := e.Eval(values)
+			if err != nil {
+				t.Fatalf("Eval error: %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{"", "a >", "(a > 1", "a > 1)", "a $ b", "a b",
+		"abs(a, b)", "nope(a)", "abs(a", "a > 5m", "abs(a, 5m)", "zscore(a, 5m)"} {
+		if _, err := ParseExpr(src); err == nil {
+			t.Errorf("ParseExpr(%q) expected error", src)
+		}
+	}
+}
+
+func TestExprUnknownSignal(t *testing.T) {
+	e, err := ParseExpr("missing > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Eval(map[string]float64{}); err == nil {
+		t.Error("Eval with unknown signal should return an error")
+	}
+}
+
+func TestExprShortCircuit(t *testing.T) {
+	// The right-hand side references an unknown signal and must not be evaluated
+	e, err := ParseExpr("0 && missing > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := e.Eval(map[string]float64{}); err != nil || got != 0 {
+		t.Errorf("Eval() = %v, %v; want 0, nil", got, err)
+	}
+}
@@ -0,0 +1,495 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/feed"
+
+	"github.com/gorilla/websocket"
+)
+
+// binanceStreamURL is the aggTrade stream of every symbol, plus the diff
+// depth stream of depth's, combined unless there is only one stream.
+func binanceStreamURL(symbols, depth []string) string {
+	return binanceStreamBase + binanceStreamPath(symbols, depth)
+}
+
+// binanceStreamPath is binanceStreamURL without the endpoint.
+func binanceStreamPath(symbols, depth []string) string {
+	var streams []string
+	for _, sym := range symbols {
+		streams = append(streams, sym+"@aggTrade")
+	}
+	for _, sym := range depth {
+		streams = append(streams, sym+"@depth@100ms")
+	}
+	if len(streams) == 1 {
+		return "/ws/" + streams[0]
+	}
+	return "/stream?streams=" + strings.Join(streams, "/")
+}
+
+// feedIngester parses what the feed reader reads and routes it to the
+// shards: trades for every symbol, and depth updates and snapshots for the
+// mirrored ones. Mark prices in a capture go straight to their symbols.
+type feedIngester struct {
+	shards  *ShardSet
+	symbols *SymbolRegistry
+	wal     *WALWriter
+	filter  *TradeFilter // nil unless trades are filtered
+	trade   feed.AggTrade
+	mark    feed.MarkPriceUpdate
+	depth   *depthSync // nil unless a symbol is mirrored
+}
+
+// newFeedIngester prepares to ingest m's feed, fetching the snapshots of
+// mirrored books with fetch (nil when replaying) until ctx is done.
+func newFeedIngester(ctx context.Context, m *Monitor, fetch func(context.Context, string) (DepthSnapshot, error)) *feedIngester {
+	in := &feedIngester{shards: m.Shards, symbols: m.Symbols, wal: m.wal, filter: m.filter}
+	if mirrored := m.MirroredSymbols(); len(mirrored) > 0 {
+		in.depth = newDepthSync(ctx, m.Shards, m.wal, mirrored, fetch)
+		in.depth.gaps = m.errors.depthGaps
+	}
+	return in
+}
+
+func (in *feedIngester) ingest(msg []byte, received time.Time) error {
+	if in.depth == nil {
+		err := ingestAggTrade(in.shards, msg, &in.trade, received, in.wal, in.filter)
+		if err != nil && (isDepthEvent(msg) || isBookSnapshotEvent(msg)) {
+			return nil // e.g. replaying a capture recorded with depth
+		}
+		if err != nil && isMarkPriceEvent(msg) {
+			return ingestMarkPrice(in.shards, in.symbols, msg, &in.mark, received)
+		}
+		return err
+	}
+	in.depth.poll(received)
+	typ, err := feed.EventType(msg)
+	if err != nil {
+		return err
+	}
+	switch string(typ) {
+	case "aggTrade":
+		return ingestAggTrade(in.shards, msg, &in.trade, received, in.wal, in.filter)
+	case "depthUpdate":
+		return in.depth.ingestUpdate(msg, received)
+	case "depthSnapshot":
+		return in.depth.ingestSnapshot(msg, received)
+	case "markPriceUpdate":
+		return ingestMarkPrice(in.shards, in.symbols, msg, &in.mark, received)
+	case bookSnapshotEvent:
+		return nil // a book recording's samples
+	}
+	return fmt.Errorf("binance: unexpected event type %q", typ)
+}
+
+func isDepthEvent(msg []byte) bool {
+	typ, _ := feed.EventType(msg)
+	return string(typ) == "depthUpdate" || string(typ) == "depthSnapshot"
+}
+
+func isMarkPriceEvent(msg []byte) bool {
+	typ, _ := feed.EventType(msg)
+	return string(typ) == "markPriceUpdate"
+}
+
+// RunBinanceFeed reads the WebSocket conn to the active one of endpoints
+// until it fails for good, m's message limit is reached or ctx is done,
+// failing over between the endpoints as their health demands, and routes
+// every message to m's shards, which it closes on return. The read path
+// parses into reused buffers and takes no locks; a slow consumer never
+// holds up the socket.
+func RunBinanceFeed(ctx context.Context, m *Monitor, dialer *websocket.Dialer, endpoints *FeedEndpoints, conn *websocket.Conn) {
+	feedLog := logger("feed")
+	defer m.Shards.Close()
+
+	// Closing the connection is what unblocks a pending read when ctx ends
+	var mu sync.Mutex
+	stopped := false
+	current := conn
+	defer context.AfterFunc(ctx, func() {
+		mu.Lock()
+		stopped = true
+		current.Close()
+		mu.Unlock()
+	})()
+	defer func() {
+		mu.Lock()
+		current.Close()
+		mu.Unlock()
+	}()
+
+	in := newFeedIngester(ctx, m, func(ctx context.Context, sym string) (DepthSnapshot, error) {
+		return FetchDepthSnapshot(ctx, restClient, binanceRESTURL, sym)
+	})
+	// replace swaps in a new connection; it reports false if the feed was
+	// stopped meanwhile
+	replace := func(next *websocket.Conn) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			next.Close()
+			return false
+		}
+		conn.Close()
+		conn, current = next, next
+		return true
+	}
+
+	health := endpoints.health
+	var lag feedLag
+	var message []byte
+	var err error
+	// A panic loses the message being ingested; the reader carries on with
+	// the next one on the same connection
+	m.Supervisor.Run(ctx, "feed", func() {
+		for {
+			if health.StallTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(health.StallTimeout))
+			}
+			message, err = feed.ReadMessage(conn, message)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				reason := "error"
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					reason = "stall"
+					feedLog.Warn("feed stalled", "endpoint", endpointName(endpoints.Active()), "timeout", health.StallTimeout)
+				} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					feedLog.Error("WebSocket error", "err", err)
+				}
+				conn.Close()
+				next, err := endpoints.reconnect(ctx, dialer, reason)
+				if err != nil {
+					if ctx.Err() == nil {
+						feedLog.Error("giving up reconnecting", "err", err)
+					}
+					return
+				}
+				if !replace(next) {
+					return
+				}
+				lag.reset()
+				m.Reconnected()
+				feedLog.Info("reconnected to Binance WebSocket", "endpoint", endpointName(endpoints.Active()))
+				continue
+			}
+
+			received := time.Now()
+			first, ok := m.admit(received)
+			if !ok {
+				return
+			}
+			if first {
+				feedLog.Info("first message received", "since_connect_ms", received.Sub(m.Start).Milliseconds())
+			}
+			in.trade.EventMs = 0
+			m.ingestFailed(in.ingest(message, received))
+			if in.trade.EventMs > 0 {
+				m.clock.ObserveEvent(in.trade.EventMs, received)
+			}
+
+			if health.MaxLag > 0 && in.trade.EventMs > 0 && len(endpoints.urls) > 1 &&
+				lag.observe(received.Sub(m.clock.Local(in.trade.EventMs)), health.MaxLag) {
+				feedLog.Warn("feed lagging", "endpoint", endpointName(endpoints.Active()), "lag", time.Duration(lag.ewma*float64(time.Second)), "max", health.MaxLag)
+				// The lagging connection is kept until another one answers
+				if next := endpoints.standby(ctx, dialer, "lag"); next != nil {
+					if !replace(next) {
+						return
+					}
+					m.Reconnected()
+				}
+				lag.reset()
+			}
+			if want := endpoints.wanted.Load(); want >= 0 {
+				endpoints.wanted.Store(-1)
+				if int(want) != int(endpoints.active.Load()) {
+					if next := endpoints.moveTo(ctx, dialer, int(want), "latency"); next != nil {
+						if !replace(next) {
+							return
+						}
+						lag.reset()
+						m.Reconnected()
+					}
+				}
+			}
+		}
+	})
+}
+
+// restClient fetches depth snapshots for mirrored books and klines to
+// backfill candles.
+var restClient = &http.Client{Timeout: 10 * time.Second}
+
+func redial(ctx context.Context, dialer *websocket.Dialer, url string) (*websocket.Conn, error) {
+	backoff := time.Second
+	var err error
+	for attempt := 1; attempt <= 5; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		var conn *websocket.Conn
+		if conn, _, err = dialer.DialContext(ctx, url, nil); err == nil {
+			return conn, nil
+		}
+		logger("feed").Warn("reconnect attempt failed", "attempt", attempt, "err", err)
+		backoff *= 2
+	}
+	return nil, err
+}
+
+// ReplayCapture routes the messages of a capture file (raw feed messages,
+// one per line, as written by RecordFeed, or compacted by CompactCapture)
+// to m's shards and closes them at the end of the file or when ctx is done.
+// With speed > 0 messages are paced by their event times, sped up by that
+// factor; otherwise they go as fast as the workers take them.
+func ReplayCapture(ctx context.Context, m *Monitor, path string, speed float64) error {
+	return ReplayCaptureFrom(ctx, m, path, time.Time{}, speed)
+}
+
+// ReplayCaptureFrom is ReplayCapture starting at the first message whose
+// event time is at or after from, unless from is zero. A compacted capture
+// seeks straight to it.
+func ReplayCaptureFrom(ctx context.Context, m *Monitor, path string, from time.Time, speed float64) error {
+	defer m.Shards.Close()
+	r, err := OpenCapture(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if !from.IsZero() {
+		r.Seek(from)
+	}
+
+	in := newFeedIngester(ctx, m, nil)
+	var firstEvent int64
+	var firstWall time.Time
+	var readErr error
+	// The reader lives outside the supervised loop, so a restart resumes
+	// after the line that panicked
+	m.Supervisor.Run(ctx, "feed", func() {
+		for {
+			line, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			var eventMs int64
+			if speed > 0 {
+				eventMs = r.EventMs()
+			}
+			if eventMs > 0 {
+				if firstEvent == 0 {
+					firstEvent, firstWall = eventMs, time.Now()
+				}
+				offset := time.Duration(float64(eventMs-firstEvent) * float64(time.Millisecond) / speed)
+				if wait := time.Until(firstWall.Add(offset)); wait > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(wait):
+					}
+				}
+			}
+			received := time.Now()
+			if _, ok := m.admit(received); !ok {
+				return
+			}
+			m.ingestFailed(in.ingest(line, received))
+		}
+	})
+	if readErr != nil {
+		return fmt.Errorf("reading %s: %w", path, readErr)
+	}
+	return nil
+}
+
+// WarmStartResult summarizes a WarmStart.
+type WarmStartResult struct {
+	Lines   int   // routed to the shards
+	Skipped int   // of symbols not being monitored
+	FirstMs int64 // event times of the history replayed, 0 if none had one
+	LastMs  int64
+}
+
+// WarmStart routes the messages of a capture to m's shards as history, as
+// fast as the workers take them, ahead of the live feed: see
+// symbolPipeline.warm. With window > 0 only the messages in the window
+// before the capture's last event time are replayed. The shards are left
+// open for the live feed, whose trades the history already holds are
+// dropped. It stops early, with what it replayed so far, when ctx is done.
+func (m *Monitor) WarmStart(ctx context.Context, path string, window time.Duration) (WarmStartResult, error) {
+	var result WarmStartResult
+	r, err := OpenCapture(path)
+	if err != nil {
+		return result, err
+	}
+	defer r.Close()
+	if window > 0 {
+		if len(m.MirroredSymbols()) > 0 {
+			return result, errors.New("--warm-start-window cannot be used with mirrored books: they need every depth update since the capture's snapshot")
+		}
+		last, err := captureLastMs(path, r)
+		if err != nil {
+			return result, err
+		}
+		if last > 0 {
+			r.Seek(time.UnixMilli(last).Add(-window))
+		}
+	}
+
+	in := newFeedIngester(ctx, m, nil)
+	m.Shards.SetHistorical(true)
+	defer m.Shards.SetHistorical(false)
+	for ctx.Err() == nil {
+		line, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := in.ingest(line, time.Now()); errors.Is(err, errUnknownSymbol) {
+			result.Skipped++
+			continue
+		} else if err != nil {
+			m.ingestFailed(err)
+			continue
+		}
+		result.Lines++
+		if ms := r.EventMs(); ms > 0 {
+			if result.FirstMs == 0 {
+				result.FirstMs = ms
+			}
+			result.LastMs = max(result.LastMs, ms)
+		}
+	}
+	return result, nil
+}
+
+// captureLastMs is the latest event time in the capture r reads from path,
+// from the index of a compacted capture and otherwise by reading through a
+// second copy.
+func captureLastMs(path string, r *CaptureReader) (int64, error) {
+	if index := r.Index(); index != nil {
+		return index.LastMs, nil
+	}
+	scan, err := OpenCapture(path)
+	if err != nil {
+		return 0, err
+	}
+	defer scan.Close()
+	var last int64
+	for {
+		if _, err := scan.Next(); err == io.EOF {
+			return last, nil
+		} else if err != nil {
+			return 0, err
+		}
+		last = max(last, scan.EventMs())
+	}
+}
+
+// captureEventMs is the exchange event time of a trade or depth update in a
+// capture, or 0 for anything else.
+func captureEventMs(line []byte, trade *feed.AggTrade, depth *feed.DepthUpdate) int64 {
+	if feed.ParseAggTrade(line, trade) == nil {
+		return trade.EventMs
+	}
+	if feed.ParseDepthUpdate(line, depth) == nil {
+		return depth.EventMs
+	}
+	return 0
+}
+
+// CaptureSymbols lists the symbols of the aggTrade messages in a capture
+// file, lower-cased, in order of first appearance. A compacted capture
+// lists them in its index.
+func CaptureSymbols(path string) ([]string, error) {
+	r, err := OpenCapture(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if index := r.Index(); index != nil {
+		return index.Symbols, nil
+	}
+	var symbols []string
+	seen := make(map[string]bool)
+	var trade feed.AggTrade
+	for {
+		line, err := r.Next()
+		if err == io.EOF {
+			return symbols, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if feed.ParseAggTrade(line, &trade) != nil || len(trade.Symbol) == 0 {
+			continue
+		}
+		if sym := strings.ToLower(string(trade.Symbol)); !seen[sym] {
+			seen[sym] = true
+			symbols = append(symbols, sym)
+		}
+	}
+}
+
+// RecordFeed writes every message read from conn to w, one per line, until
+// the connection fails, max messages have been written (0 for no limit) or
+// ctx is done. It returns the number of messages written.
+func RecordFeed(ctx context.Context, conn *websocket.Conn, w io.Writer, max int) (int, error) {
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+	bw := bufio.NewWriter(w)
+	var message []byte
+	var compact bytes.Buffer
+	n := 0
+	var err error
+	for max <= 0 || n < max {
+		if message, err = feed.ReadMessage(conn, message); err != nil {
+			if ctx.Err() != nil {
+				err = nil
+			}
+			break
+		}
+		// Binance sends single-line JSON, but a line per message is the
+		// file format, so never let a newline through
+		if bytes.IndexByte(message, '\n') >= 0 {
+			compact.Reset()
+			if json.Compact(&compact, message) != nil {
+				continue
+			}
+			message = append(message[:0], compact.Bytes()...)
+		}
+		bw.Write(message)
+		if err = bw.WriteByte('\n'); err != nil {
+			break
+		}
+		n++
+	}
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
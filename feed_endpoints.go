@@ -0,0 +1,211 @@
+package apexlob
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// binanceStreamBase is the WebSocket endpoint of Binance's spot market
+// streams. data-stream.binance.vision serves the same streams.
+const binanceStreamBase = "wss://stream.binance.com:443"
+
+// FeedHealth says when the feed gives up on an endpoint that is still
+// connected.
+type FeedHealth struct {
+	// StallTimeout reconnects when no message has arrived for this long
+	// (0 never does)
+	StallTimeout time.Duration
+	// MaxLag moves to another endpoint when the smoothed delay from the
+	// exchange's event time to receipt passes it (0 never does)
+	MaxLag time.Duration
+}
+
+// feedLagSamples is how many trades the lag is smoothed over, and how many
+// a connection has to deliver before it can be judged too slow.
+const feedLagSamples = 50
+
+// FeedEndpoints are redundant WebSocket endpoints serving the same streams,
+// in order of preference. The feed reads from one of them at a time, the
+// active one, and fails over to the next when it drops, stalls or lags.
+type FeedEndpoints struct {
+	bases     []string
+	urls      []string
+	health    FeedHealth
+	active    atomic.Int32
+	wanted    atomic.Int32        // an endpoint the feed should move to, or -1
+	failovers map[string]*Counter // by reason
+
+	probeMu sync.Mutex
+	probes  map[string]EndpointProbe // the latest, by base
+}
+
+// NewFeedEndpoints serves path (the streams, see binanceStreamPath) from
+// each of bases, the first being active until it fails.
+func NewFeedEndpoints(bases []string, path string, health FeedHealth, reg *MetricsRegistry) *FeedEndpoints {
+	e := &FeedEndpoints{bases: bases, health: health, failovers: make(map[string]*Counter), probes: make(map[string]EndpointProbe)}
+	e.wanted.Store(-1)
+	for _, base := range bases {
+		e.urls = append(e.urls, base+path)
+	}
+	for _, reason := range []string{"error", "stall", "lag", "latency"} {
+		e.failovers[reason] = reg.Counter("apexlob_feed_failovers_total", "Times the feed left its endpoint, by reason: the connection failed, stalled or lagged, or a probe found a faster endpoint.", Labels{"reason": reason})
+	}
+	reg.GaugeFunc("apexlob_feed_endpoint_connect_seconds", "Time to connect to each WebSocket endpoint at its latest probe, by phase: tcp and tls.", func() []Sample {
+		e.probeMu.Lock()
+		defer e.probeMu.Unlock()
+		var samples []Sample
+		for _, base := range e.bases {
+			if p, ok := e.probes[base]; ok && p.Err == nil {
+				samples = append(samples,
+					Sample{Labels: Labels{"endpoint": endpointName(base), "phase": "tcp"}, Value: p.TCP.Seconds()},
+					Sample{Labels: Labels{"endpoint": endpointName(base), "phase": "tls"}, Value: p.TLS.Seconds()})
+			}
+		}
+		return samples
+	})
+	reg.GaugeFunc("apexlob_feed_endpoint_active", "1 for the WebSocket endpoint the feed is reading from, 0 for the standbys.", func() []Sample {
+		active := int(e.active.Load())
+		samples := make([]Sample, len(e.bases))
+		for i, base := range e.bases {
+			samples[i] = Sample{Labels: Labels{"endpoint": endpointName(base)}}
+			if i == active {
+				samples[i].Value = 1
+			}
+		}
+		return samples
+	})
+	return e
+}
+
+// endpointName is base without its scheme, as it is labelled and logged.
+func endpointName(base string) string {
+	if u, err := url.Parse(base); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return base
+}
+
+// Active returns the base URL of the endpoint being read from.
+func (e *FeedEndpoints) Active() string {
+	return e.bases[e.active.Load()]
+}
+
+// Dial connects to the first endpoint that answers, in order of preference,
+// and makes it the active one.
+func (e *FeedEndpoints) Dial(ctx context.Context, dialer *websocket.Dialer) (*websocket.Conn, error) {
+	var err error
+	for i, u := range e.urls {
+		var conn *websocket.Conn
+		if conn, _, err = dialer.DialContext(ctx, u, nil); err == nil {
+			e.active.Store(int32(i))
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if len(e.urls) > 1 {
+			logger("feed").Warn("endpoint unavailable", "endpoint", endpointName(e.bases[i]), "err", err)
+		}
+	}
+	return nil, err
+}
+
+// reconnect replaces a connection that failed for reason. It tries the
+// other endpoints straight away, then goes round them all again with
+// backoff, five times in all; with a single endpoint it waits before each
+// attempt.
+func (e *FeedEndpoints) reconnect(ctx context.Context, dialer *websocket.Dialer, reason string) (*websocket.Conn, error) {
+	e.failovers[reason].Inc()
+	n := len(e.urls)
+	start := int(e.active.Load())
+	backoff := time.Second
+	var err error
+	for round := 1; round <= 5; round++ {
+		if round > 1 || n == 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		for k := 1; k <= n; k++ {
+			i := (start + k) % n
+			var conn *websocket.Conn
+			if conn, _, err = dialer.DialContext(ctx, e.urls[i], nil); err == nil {
+				e.switchTo(i, reason)
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger("feed").Warn("reconnect attempt failed", "endpoint", endpointName(e.bases[i]), "round", round, "err", err)
+		}
+	}
+	return nil, err
+}
+
+// standby connects to the next endpoint that answers, other than the active
+// one, while the active connection is still open. It returns nil if there
+// is none.
+func (e *FeedEndpoints) standby(ctx context.Context, dialer *websocket.Dialer, reason string) *websocket.Conn {
+	n := len(e.urls)
+	start := int(e.active.Load())
+	for k := 1; k < n; k++ {
+		i := (start + k) % n
+		conn, _, err := dialer.DialContext(ctx, e.urls[i], nil)
+		if err == nil {
+			e.failovers[reason].Inc()
+			e.switchTo(i, reason)
+			return conn
+		}
+		logger("feed").Warn("standby endpoint unavailable", "endpoint", endpointName(e.bases[i]), "err", err)
+	}
+	return nil
+}
+
+// moveTo connects to endpoint i while the active connection is still open,
+// returning nil if it does not answer.
+func (e *FeedEndpoints) moveTo(ctx context.Context, dialer *websocket.Dialer, i int, reason string) *websocket.Conn {
+	conn, _, err := dialer.DialContext(ctx, e.urls[i], nil)
+	if err != nil {
+		logger("feed").Warn("endpoint unavailable", "endpoint", endpointName(e.bases[i]), "err", err)
+		return nil
+	}
+	e.failovers[reason].Inc()
+	e.switchTo(i, reason)
+	return conn
+}
+
+func (e *FeedEndpoints) switchTo(i int, reason string) {
+	if from := int(e.active.Swap(int32(i))); from != i {
+		logger("feed").Warn("failed over to another endpoint", "from", endpointName(e.bases[from]), "to", endpointName(e.bases[i]), "reason", reason)
+	}
+}
+
+// feedLag smooths the delay from the exchange's event time to receipt over
+// a connection's trades.
+type feedLag struct {
+	ewma    float64 // seconds
+	samples int
+}
+
+func (l *feedLag) reset() { *l = feedLag{} }
+
+// observe adds a trade's delay and reports whether the connection has
+// delivered enough trades to be judged and lags by more than max.
+func (l *feedLag) observe(delay, max time.Duration) bool {
+	const alpha = 2.0 / (feedLagSamples + 1)
+	if l.samples == 0 {
+		l.ewma = delay.Seconds()
+	} else {
+		l.ewma += alpha * (delay.Seconds() - l.ewma)
+	}
+	l.samples++
+	return l.samples >= feedLagSamples && l.ewma > max.Seconds()
+}
@@ -0,0 +1,90 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// tradeServer streams n aggTrades, their event times lagging by lag, then
+// hangs up or, with quiet, stays connected and silent.
+func tradeServer(t *testing.T, n int, lag time.Duration, quiet bool) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < n; i++ {
+			msg := fmt.Sprintf(`{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"100.0","q":"1.0","m":true}`, time.Now().Add(-lag).UnixMilli(), i+1)
+			if conn.WriteMessage(websocket.TextMessage, []byte(msg)) != nil {
+				return
+			}
+		}
+		if quiet {
+			conn.ReadMessage()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestFeedFailover(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		primary  string
+		health   FeedHealth
+		reason   string
+		messages int
+	}{
+		{"dropped", tradeServer(t, 3, 0, false), FeedHealth{}, "error", 3 + 4},
+		{"stalled", tradeServer(t, 3, 0, true), FeedHealth{StallTimeout: 50 * time.Millisecond}, "stall", 3 + 4},
+		{"lagging", tradeServer(t, 100, 10*time.Second, true), FeedHealth{MaxLag: time.Second}, "lag", feedLagSamples + 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 256})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			// The standby's fifth message is past the limit and ends the feed
+			m.MaxMessages = tc.messages
+			standby := tradeServer(t, 5, 0, true)
+			endpoints := NewFeedEndpoints([]string{tc.primary, standby}, "/ws/btcusdt@aggTrade", tc.health, m.Registry)
+			done := m.Run()
+			conn, err := endpoints.Dial(context.Background(), websocket.DefaultDialer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			RunBinanceFeed(ctx, m, websocket.DefaultDialer, endpoints, conn)
+			<-done
+
+			if endpoints.Active() != standby {
+				t.Errorf("active endpoint %s, want the standby", endpoints.Active())
+			}
+			if n := endpoints.failovers[tc.reason].Value(); n != 1 {
+				t.Errorf("%d failovers for %s", n, tc.reason)
+			}
+			if n := m.Stats.Snapshot().TotalMessages; n != tc.messages {
+				t.Errorf("processed %d messages, want %d", n, tc.messages)
+			}
+			var metrics bytes.Buffer
+			m.Registry.WritePrometheus(&metrics)
+			want := fmt.Sprintf(`apexlob_feed_endpoint_active{endpoint="%s"} 1`, endpointName(standby))
+			if !strings.Contains(metrics.String(), want) {
+				t.Errorf("metrics lack %s:\n%s", want, metrics.String())
+			}
+		})
+	}
+}
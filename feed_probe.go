@@ -0,0 +1,188 @@
+package apexlob
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// EndpointProbe is how long an endpoint took to reach: the TCP connect and,
+// for wss, the TLS handshake, the best of feedProbeAttempts tries.
+type EndpointProbe struct {
+	Endpoint string
+	TCP      time.Duration
+	TLS      time.Duration
+	Err      error // set if no attempt got through
+}
+
+// Total is the time to a connection ready for the WebSocket handshake.
+func (p EndpointProbe) Total() time.Duration { return p.TCP + p.TLS }
+
+const (
+	feedProbeAttempts = 3
+	feedProbeTimeout  = 5 * time.Second
+	// feedProbeMargin is how much faster another endpoint has to be before
+	// a periodic probe moves the feed to it, so it does not flap between
+	// two that are about as fast
+	feedProbeMargin = 0.2
+)
+
+// probeEndpoint times connections to base without speaking WebSocket.
+func probeEndpoint(ctx context.Context, base string) EndpointProbe {
+	p := EndpointProbe{Endpoint: base}
+	u, err := url.Parse(base)
+	if err != nil || u.Host == "" {
+		p.Err = fmt.Errorf("invalid endpoint %q", base)
+		return p
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	for attempt := 0; attempt < feedProbeAttempts; attempt++ {
+		tcp, handshake, err := probeOnce(ctx, addr, u.Hostname(), u.Scheme == "wss")
+		if err != nil {
+			if p.Err == nil && p.TCP == 0 {
+				p.Err = err
+			}
+			continue
+		}
+		if p.Err != nil || p.TCP == 0 || tcp+handshake < p.Total() {
+			p.TCP, p.TLS, p.Err = tcp, handshake, nil
+		}
+	}
+	return p
+}
+
+func probeOnce(ctx context.Context, addr, host string, secure bool) (tcp, handshake time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, feedProbeTimeout)
+	defer cancel()
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	tcp = time.Since(start)
+	if !secure {
+		return tcp, 0, nil
+	}
+	start = time.Now()
+	if err := tls.Client(conn, &tls.Config{ServerName: host}).HandshakeContext(ctx); err != nil {
+		return 0, 0, err
+	}
+	return tcp, time.Since(start), nil
+}
+
+// ProbeEndpoints probes every endpoint at once and logs what it measured.
+// The probes come back in the order of bases.
+func ProbeEndpoints(ctx context.Context, bases []string) []EndpointProbe {
+	probes := make([]EndpointProbe, len(bases))
+	var wg sync.WaitGroup
+	for i, base := range bases {
+		wg.Add(1)
+		go func(i int, base string) {
+			defer wg.Done()
+			probes[i] = probeEndpoint(ctx, base)
+		}(i, base)
+	}
+	wg.Wait()
+	feedLog := logger("feed")
+	for _, p := range probes {
+		if p.Err != nil {
+			feedLog.Warn("endpoint probe failed", "endpoint", endpointName(p.Endpoint), "err", p.Err)
+		} else {
+			feedLog.Info("endpoint probed", "endpoint", endpointName(p.Endpoint), "tcp_ms", millis(p.TCP), "tls_ms", millis(p.TLS), "total_ms", millis(p.Total()))
+		}
+	}
+	return probes
+}
+
+func millis(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+
+// fastestFirst orders the probed endpoints by their time to connect, those
+// that could not be reached last and in their original order.
+func fastestFirst(probes []EndpointProbe) []string {
+	sorted := slices.Clone(probes)
+	slices.SortStableFunc(sorted, func(a, b EndpointProbe) int {
+		switch {
+		case a.Err != nil || b.Err != nil:
+			return boolCompare(a.Err != nil, b.Err != nil)
+		case a.Total() < b.Total():
+			return -1
+		case a.Total() > b.Total():
+			return 1
+		}
+		return 0
+	})
+	bases := make([]string, len(sorted))
+	for i, p := range sorted {
+		bases[i] = p.Endpoint
+	}
+	return bases
+}
+
+// boolCompare orders false before true.
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// recordProbes keeps the latest probes for the RTT metric.
+func (e *FeedEndpoints) recordProbes(probes []EndpointProbe) {
+	e.probeMu.Lock()
+	defer e.probeMu.Unlock()
+	for _, p := range probes {
+		e.probes[p.Endpoint] = p
+	}
+}
+
+// RunProbes probes the endpoints every interval until ctx is done, and asks
+// the feed to move to the fastest one when it is more than feedProbeMargin
+// faster than the active one, or the active one could not be reached.
+func (e *FeedEndpoints) RunProbes(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		probes := ProbeEndpoints(ctx, e.bases)
+		if ctx.Err() != nil {
+			return
+		}
+		e.recordProbes(probes)
+		active := int(e.active.Load())
+		best := -1
+		for i, p := range probes {
+			if p.Err == nil && (best < 0 || p.Total() < probes[best].Total()) {
+				best = i
+			}
+		}
+		if best < 0 || best == active {
+			continue
+		}
+		if cur := probes[active]; cur.Err == nil && float64(probes[best].Total()) > float64(cur.Total())*(1-feedProbeMargin) {
+			continue
+		}
+		logger("feed").Info("a faster endpoint is available", "endpoint", endpointName(e.bases[best]), "active", endpointName(e.bases[active]))
+		e.wanted.Store(int32(best))
+	}
+}
@@ -0,0 +1,82 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestProbeEndpoints(t *testing.T) {
+	live := tradeServer(t, 0, 0, true)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "ws://" + lis.Addr().String()
+	lis.Close()
+
+	probes := ProbeEndpoints(context.Background(), []string{dead, live, "ws://"})
+	if probes[0].Err == nil || probes[1].Err != nil || probes[1].TCP <= 0 || probes[1].TLS != 0 || probes[2].Err == nil {
+		t.Errorf("probes %+v", probes)
+	}
+	if got := fastestFirst(probes); !slices.Equal(got, []string{live, dead, "ws://"}) {
+		t.Errorf("ordered %v", got)
+	}
+	ordered := fastestFirst([]EndpointProbe{
+		{Endpoint: "a", TCP: 30 * time.Millisecond},
+		{Endpoint: "b", Err: errors.New("refused")},
+		{Endpoint: "c", TCP: 10 * time.Millisecond, TLS: 25 * time.Millisecond},
+		{Endpoint: "d", TCP: 5 * time.Millisecond, TLS: 10 * time.Millisecond},
+	})
+	if !slices.Equal(ordered, []string{"d", "a", "c", "b"}) {
+		t.Errorf("ordered %v", ordered)
+	}
+
+	// A periodic probe that finds the active endpoint unreachable asks the
+	// feed to move
+	endpoints := NewFeedEndpoints([]string{dead, live}, "", FeedHealth{}, NewMetricsRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go endpoints.RunProbes(ctx, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for endpoints.wanted.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the probes never asked to move to the live endpoint")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFeedMovesToFasterEndpoint(t *testing.T) {
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	// One message from the primary, then four from the faster endpoint;
+	// its fifth ends the feed
+	m.MaxMessages = 5
+	primary, faster := tradeServer(t, 3, 0, true), tradeServer(t, 5, 0, true)
+	endpoints := NewFeedEndpoints([]string{primary, faster}, "/ws/btcusdt@aggTrade", FeedHealth{}, m.Registry)
+	done := m.Run()
+	conn, err := endpoints.Dial(context.Background(), websocket.DefaultDialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints.wanted.Store(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	RunBinanceFeed(ctx, m, websocket.DefaultDialer, endpoints, conn)
+	<-done
+	if endpoints.Active() != faster || endpoints.failovers["latency"].Value() != 1 {
+		t.Errorf("active %s after %d moves", endpoints.Active(), endpoints.failovers["latency"].Value())
+	}
+	if n := m.Stats.Snapshot().TotalMessages; n != 5 {
+		t.Errorf("processed %d messages, want 5", n)
+	}
+}
@@ -0,0 +1,300 @@
+package apexlob
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeCapture writes n synthetic messages for symbols to a capture file.
+func writeCapture(t *testing.T, symbols []string, n int) string {
+	t.Helper()
+	synthetic := NewSyntheticFeed(symbols, 3)
+	var capture []byte
+	for i := 0; i < n; i++ {
+		capture = append(synthetic.Next(capture), '\n')
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, capture, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBinanceStreamURL(t *testing.T) {
+	if got := binanceStreamURL([]string{"btcusdt"}, nil); got != "wss://stream.binance.com:443/ws/btcusdt@aggTrade" {
+		t.Errorf("single stream URL = %s", got)
+	}
+	if got := binanceStreamURL([]string{"btcusdt", "ethusdt"}, nil); got != "wss://stream.binance.com:443/stream?streams=btcusdt@aggTrade/ethusdt@aggTrade" {
+		t.Errorf("combined stream URL = %s", got)
+	}
+	if got := binanceStreamURL([]string{"btcusdt"}, []string{"btcusdt"}); got != "wss://stream.binance.com:443/stream?streams=btcusdt@aggTrade/btcusdt@depth@100ms" {
+		t.Errorf("depth stream URL = %s", got)
+	}
+}
+
+func TestCaptureSymbols(t *testing.T) {
+	path := writeCapture(t, []string{"ethusdt", "btcusdt"}, 200)
+	symbols, err := CaptureSymbols(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(symbols) != 2 || strings.Join(symbols, ",") == "" || symbols[0] == symbols[1] {
+		t.Errorf("symbols = %v, want ethusdt and btcusdt", symbols)
+	}
+	if _, err := CaptureSymbols(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing capture accepted")
+	}
+}
+
+func TestReplayCapture(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt", "ethusdt"}, 300)
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: 2, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 300 {
+		t.Errorf("processed %d messages, want 300", n)
+	}
+	for _, sym := range m.SymbolList {
+		if state, _ := m.Symbols.Get(sym); state.Tape.Len() == 0 {
+			t.Errorf("no trades reached %s", sym)
+		}
+	}
+	if m.LimitReached() {
+		t.Error("limit reached without a limit")
+	}
+
+	m, _ = NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: 2, FeedQueue: 16})
+	defer m.Close()
+	m.MaxMessages = 120
+	done = m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 120 || !m.LimitReached() {
+		t.Errorf("processed %d messages with a limit of 120 (reached %v)", n, m.LimitReached())
+	}
+}
+
+// writeDepthCapture writes a capture recorded with depth: a snapshot of
+// btcusdt's book, then n rounds of a depth update and a trade.
+func writeDepthCapture(t *testing.T, n int) string {
+	t.Helper()
+	snap := DepthSnapshot{Symbol: "BTCUSDT", LastUpdateID: 100,
+		Bids: [][2]string{{"99.0", "1.0"}, {"98.0", "2.0"}}, Asks: [][2]string{{"101.0", "1.5"}}}
+	line, err := snap.MarshalCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := string(line) + "\n"
+	for i := 0; i < n; i++ {
+		id := 101 + i
+		capture += fmt.Sprintf(`{"e":"depthUpdate","E":%d,"s":"BTCUSDT","U":%d,"u":%d,"b":[["99.0","%d.0"]],"a":[["%d.0","1.0"]]}`+"\n",
+			1700000000000+i, id, id, 1+i%5, 101+i%3)
+		capture += fmt.Sprintf(`{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"100.0","q":"0.5","m":%v}`+"\n", 1700000000000+i, i+1, i%2 == 0)
+	}
+	path := filepath.Join(t.TempDir(), "depth.jsonl")
+	if err := os.WriteFile(path, []byte(capture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReplayCaptureMirrored(t *testing.T) {
+	path := writeDepthCapture(t, 10)
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, BookModes: "mirrored", Limits: DefaultSymbolLimits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	state, _ := m.Symbols.Get("btcusdt")
+	if state.Mode != BookMirrored {
+		t.Fatalf("mode = %s", state.Mode)
+	}
+	// The last update set the 99 bid to 5 and added an ask at 103
+	if p, q, _ := state.Book.GetBestBid(); p != 99 || q != 5000 {
+		t.Errorf("best bid = %v x %d, want 99 x 5000", p, q)
+	}
+	if _, asks := state.Book.Depth(10); len(asks) != 3 {
+		t.Errorf("asks = %v, want 101, 102 and 103", asks)
+	}
+	if totals := state.Book.TradeTotals(); totals.Volume != 5000 || totals.LastPrice != 100 {
+		t.Errorf("totals = %+v, want the 10 trades' volume", totals)
+	}
+	if state.Tape.Len() != 10 {
+		t.Errorf("tape holds %d trades, want 10", state.Tape.Len())
+	}
+
+	// A synthetic book skips the depth in the same capture
+	m, _ = NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	defer m.Close()
+	done = m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 10 {
+		t.Errorf("synthetic book processed %d messages, want the 10 trades", n)
+	}
+}
+
+func TestReplayCapturePacedByEventTime(t *testing.T) {
+	// Three messages 100ms apart replayed at 5x take about 40ms
+	var capture strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&capture, `{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"100.0","q":"1.0","m":true}`+"\n", 1700000000000+100*i, i+1)
+	}
+	path := filepath.Join(t.TempDir(), "paced.jsonl")
+	os.WriteFile(path, []byte(capture.String()), 0o644)
+
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	start := time.Now()
+	if err := ReplayCapture(context.Background(), m, path, 5); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("paced replay took %v, want about 40ms", elapsed)
+	}
+	if n := m.Stats.Snapshot().TotalMessages; n != 3 {
+		t.Errorf("processed %d messages, want 3", n)
+	}
+
+	// Cancelling ends a slow replay early
+	m, _ = NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	defer m.Close()
+	done = m.Run()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ReplayCapture(ctx, m, path, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestRecordFeed(t *testing.T) {
+	messages := []string{
+		`{"e":"aggTrade","s":"BTCUSDT"}`,
+		"{\n  \"e\": \"aggTrade\",\n  \"s\": \"ETHUSDT\"\n}",
+		`{"e":"aggTrade","s":"SOLUSDT"}`,
+	}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, m := range messages {
+			conn.WriteMessage(websocket.TextMessage, []byte(m))
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	n, err := RecordFeed(context.Background(), conn, &out, 2)
+	if err != nil || n != 2 {
+		t.Fatalf("RecordFeed = %d, %v; want 2 messages", n, err)
+	}
+	sc := bufio.NewScanner(strings.NewReader(out.String()))
+	var lines []string
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	if len(lines) != 2 || lines[0] != messages[0] || lines[1] != `{"e":"aggTrade","s":"ETHUSDT"}` {
+		t.Errorf("recorded lines = %q", lines)
+	}
+
+	// Cancelling ends the recording without an error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := RecordFeed(ctx, conn, &out, 0); err != nil {
+		t.Errorf("stopped recording returned %v", err)
+	}
+}
+
+func TestRunBinanceFeed(t *testing.T) {
+	var capture strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&capture, `{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"100.0","q":"1.0","m":true}`+"\n", 1700000000000+i, i+1)
+	}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, line := range strings.Split(strings.TrimSpace(capture.String()), "\n") {
+			conn.WriteMessage(websocket.TextMessage, []byte(line))
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	// The feed stops itself at the message limit
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.MaxMessages = 4
+	done := m.Run()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunBinanceFeed(context.Background(), m, websocket.DefaultDialer, NewFeedEndpoints([]string{url}, "", FeedHealth{}, m.Registry), conn)
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 4 {
+		t.Errorf("processed %d messages, want 4", n)
+	}
+
+	// and when cancelled, even while blocked on a quiet socket
+	m, _ = NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	defer m.Close()
+	done = m.Run()
+	if conn, _, err = websocket.DefaultDialer.Dial(url, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	RunBinanceFeed(ctx, m, websocket.DefaultDialer, NewFeedEndpoints([]string{url}, "", FeedHealth{}, m.Registry), conn)
+	<-done
+	if n := m.Stats.Snapshot().TotalMessages; n != 10 {
+		t.Errorf("processed %d messages before stopping, want 10", n)
+	}
+}
@@ -0,0 +1,157 @@
+package apexlob
+
+import (
+	"math"
+	"sort"
+)
+
+// TradeFilterConfig describes which trades are kept off the books. A zero
+// config keeps everything.
+type TradeFilterConfig struct {
+	// Symbols lets only these symbols' trades through; empty lets all
+	Symbols []string
+	// MinNotional drops trades worth less than this, price times quantity
+	MinNotional float64
+	// BandBps drops trades further than this from the median price of the
+	// symbol's last MedianWindow trades, in basis points (0 disables)
+	BandBps      float64
+	MedianWindow int
+}
+
+// Enabled reports whether the config drops anything.
+func (c TradeFilterConfig) Enabled() bool {
+	return len(c.Symbols) > 0 || c.MinNotional > 0 || c.BandBps > 0
+}
+
+// Reasons a trade is dropped, as reported in the reason label of
+// apexlob_filtered_trades_total.
+const (
+	FilterSymbol    = "symbol"
+	FilterNotional  = "notional"
+	FilterPriceBand = "price_band"
+)
+
+// minMedianTrades is how many trades a symbol needs before the price band
+// applies, so the first trades are not judged against a single price.
+const minMedianTrades = 5
+
+// TradeFilter checks trades on the feed reader before they are queued for
+// the books, so a fat-fingered print or a bad tick never reaches the book,
+// the signals or the totals. Only the feed reader may use it.
+type TradeFilter struct {
+	cfg     TradeFilterConfig
+	allowed map[string]bool
+	medians map[string]*rollingMedian
+	reg     *MetricsRegistry
+	dropped map[filterKey]*Counter
+}
+
+type filterKey struct{ symbol, reason string }
+
+// NewTradeFilter returns a filter for cfg that counts what it drops in reg.
+func NewTradeFilter(cfg TradeFilterConfig, reg *MetricsRegistry) *TradeFilter {
+	if cfg.MedianWindow <= 0 {
+		cfg.MedianWindow = 101
+	}
+	f := &TradeFilter{
+		cfg:     cfg,
+		medians: make(map[string]*rollingMedian),
+		reg:     reg,
+		dropped: make(map[filterKey]*Counter),
+	}
+	if len(cfg.Symbols) > 0 {
+		f.allowed = make(map[string]bool, len(cfg.Symbols))
+		for _, sym := range cfg.Symbols {
+			f.allowed[sym] = true
+		}
+	}
+	return f
+}
+
+// Check returns why a trade of symbol should be dropped, or "" to keep it.
+// Every trade, kept or not, moves the rolling median, so a real move in
+// the price is let through once it has lasted half the window.
+func (f *TradeFilter) Check(symbol string, price, quantity float64) string {
+	reason := f.check(symbol, price, quantity)
+	if reason != "" {
+		key := filterKey{symbol, reason}
+		c, ok := f.dropped[key]
+		if !ok {
+			c = f.reg.Counter("apexlob_filtered_trades_total", "Trades dropped before reaching the book, by reason.",
+				Labels{"symbol": symbol, "reason": reason})
+			f.dropped[key] = c
+		}
+		c.Inc()
+	}
+	return reason
+}
+
+func (f *TradeFilter) check(symbol string, price, quantity float64) string {
+	if f.allowed != nil && !f.allowed[symbol] {
+		return FilterSymbol
+	}
+	if f.cfg.BandBps > 0 {
+		m, ok := f.medians[symbol]
+		if !ok {
+			m = newRollingMedian(f.cfg.MedianWindow)
+			f.medians[symbol] = m
+		}
+		median, n := m.median(), m.len()
+		m.push(price)
+		if n >= minMedianTrades && math.Abs(price-median) > median*f.cfg.BandBps/1e4 {
+			return FilterPriceBand
+		}
+	}
+	if price*quantity < f.cfg.MinNotional {
+		return FilterNotional
+	}
+	return ""
+}
+
+// Dropped returns how many trades of symbol were dropped for reason.
+func (f *TradeFilter) Dropped(symbol, reason string) uint64 {
+	if c, ok := f.dropped[filterKey{symbol, reason}]; ok {
+		return c.Value()
+	}
+	return 0
+}
+
+// rollingMedian keeps the last n values in arrival order and sorted.
+type rollingMedian struct {
+	ring   []float64
+	next   int
+	sorted []float64
+}
+
+func newRollingMedian(n int) *rollingMedian {
+	return &rollingMedian{ring: make([]float64, 0, n), sorted: make([]float64, 0, n)}
+}
+
+func (r *rollingMedian) len() int { return len(r.sorted) }
+
+func (r *rollingMedian) push(v float64) {
+	if len(r.ring) < cap(r.ring) {
+		r.ring = append(r.ring, v)
+	} else {
+		old := r.ring[r.next]
+		r.ring[r.next] = v
+		r.next = (r.next + 1) % len(r.ring)
+		i := sort.SearchFloat64s(r.sorted, old)
+		r.sorted = append(r.sorted[:i], r.sorted[i+1:]...)
+	}
+	i := sort.SearchFloat64s(r.sorted, v)
+	r.sorted = append(r.sorted, 0)
+	copy(r.sorted[i+1:], r.sorted[i:])
+	r.sorted[i] = v
+}
+
+func (r *rollingMedian) median() float64 {
+	n := len(r.sorted)
+	switch {
+	case n == 0:
+		return math.NaN()
+	case n%2 == 1:
+		return r.sorted[n/2]
+	}
+	return (r.sorted[n/2-1] + r.sorted[n/2]) / 2
+}
@@ -0,0 +1,106 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRollingMedian(t *testing.T) {
+	r := newRollingMedian(3)
+	if !math.IsNaN(r.median()) {
+		t.Error("median of nothing is not NaN")
+	}
+	for i, tc := range []struct{ push, want float64 }{{5, 5}, {1, 3}, {9, 5}, {2, 2}, {2, 2}, {8, 2}} {
+		r.push(tc.push)
+		if got := r.median(); got != tc.want {
+			t.Errorf("after %d pushes median = %v, want %v", i+1, got, tc.want)
+		}
+	}
+}
+
+func TestTradeFilter(t *testing.T) {
+	reg := NewMetricsRegistry()
+	f := NewTradeFilter(TradeFilterConfig{Symbols: []string{"btcusdt"}, MinNotional: 10, BandBps: 100, MedianWindow: 9}, reg)
+	if got := f.Check("ethusdt", 100, 1); got != FilterSymbol {
+		t.Errorf("ethusdt trade: %q", got)
+	}
+	for i := 0; i < minMedianTrades; i++ {
+		if got := f.Check("btcusdt", 100, 1); got != "" {
+			t.Fatalf("trade %d dropped: %q", i, got)
+		}
+	}
+	if got := f.Check("btcusdt", 100, 0.05); got != FilterNotional {
+		t.Errorf("5 notional trade: %q", got)
+	}
+	if got := f.Check("btcusdt", 102, 1); got != FilterPriceBand {
+		t.Errorf("trade 200bps away: %q", got)
+	}
+	if got := f.Check("btcusdt", 100.5, 1); got != "" {
+		t.Errorf("trade 50bps away: %q", got)
+	}
+	// A move that lasts shifts the median and is let through
+	var dropped int
+	for i := 0; i < 10; i++ {
+		if f.Check("btcusdt", 110, 1) != "" {
+			dropped++
+		}
+	}
+	if dropped != 5 {
+		t.Errorf("dropped %d trades of a lasting move, want the 5 before the median moved", dropped)
+	}
+	if f.Dropped("btcusdt", FilterPriceBand) != 6 || f.Dropped("ethusdt", FilterSymbol) != 1 {
+		t.Errorf("dropped %d out of band, %d ethusdt", f.Dropped("btcusdt", FilterPriceBand), f.Dropped("ethusdt", FilterSymbol))
+	}
+	var out strings.Builder
+	reg.WritePrometheus(&out)
+	if !strings.Contains(out.String(), `apexlob_filtered_trades_total{reason="notional",symbol="btcusdt"} 1`) {
+		t.Errorf("metrics:\n%s", out.String())
+	}
+}
+
+func TestReplayFiltersTrades(t *testing.T) {
+	var capture strings.Builder
+	for i := 1; i <= 20; i++ {
+		price := "100.0"
+		if i == 10 {
+			price = "1.0" // a bad tick
+		}
+		fmt.Fprintf(&capture, `{"e":"aggTrade","E":%d,"s":"BTCUSDT","a":%d,"p":"%s","q":"1.0","m":false}`+"\n", 1700000000000+i, i, price)
+		fmt.Fprintf(&capture, `{"e":"aggTrade","E":%d,"s":"ETHUSDT","a":%d,"p":"10.0","q":"1.0","m":false}`+"\n", 1700000000000+i, i)
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	if err := os.WriteFile(path, []byte(capture.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: 1, FeedQueue: 16, Limits: DefaultSymbolLimits,
+		Filter: TradeFilterConfig{Symbols: []string{"BTCUSDT"}, BandBps: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	btc, _ := m.Symbols.Get("btcusdt")
+	eth, _ := m.Symbols.Get("ethusdt")
+	if btc.Tape.Len() != 19 || eth.Tape.Len() != 0 {
+		t.Errorf("tapes hold %d btcusdt and %d ethusdt trades, want 19 and 0", btc.Tape.Len(), eth.Tape.Len())
+	}
+	for _, tr := range btc.Tape.Recent(20) {
+		if tr.Price != 100 {
+			t.Errorf("trade at %v reached the tape", tr.Price)
+		}
+	}
+
+	if _, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Filter: TradeFilterConfig{Symbols: []string{"ethusdt"}}}); err == nil {
+		t.Error("filtered a symbol that is not streamed")
+	}
+}
@@ -0,0 +1,672 @@
+package apexlob
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// FIX 4.4 order entry (https://www.fixtrading.org/standards/fix-4-4/),
+// implemented just far enough for a trading system to log on to the
+// exchange, enter and cancel limit orders and receive their execution
+// reports. Sessions are not persisted: both sides start from sequence
+// number 1 on every logon and nothing is ever resent.
+
+const (
+	fixBeginString = "FIX.4.4"
+	fixSOH         = '\x01'
+	fixMaxBody     = 16 << 10
+	fixTimeFormat  = "20060102-15:04:05.000"
+)
+
+// FIX tags used by the acceptor.
+const (
+	fixAccount          = 1
+	fixAvgPx            = 6
+	fixBeginStr         = 8
+	fixClOrdID          = 11
+	fixCumQty           = 14
+	fixExecID           = 17
+	fixMsgSeqNum        = 34
+	fixMsgType          = 35
+	fixNewSeqNo         = 36
+	fixOrderID          = 37
+	fixOrderQty         = 38
+	fixOrdStatus        = 39
+	fixOrdType          = 40
+	fixOrigClOrdID      = 41
+	fixPossDupFlag      = 43
+	fixPrice            = 44
+	fixRefSeqNum        = 45
+	fixSenderCompID     = 49
+	fixSendingTime      = 52
+	fixSide             = 54
+	fixSymbol           = 55
+	fixTargetCompID     = 56
+	fixText             = 58
+	fixTransactTime     = 60
+	fixEncryptMethod    = 98
+	fixCxlRejReason     = 102
+	fixOrdRejReason     = 103
+	fixHeartBtInt       = 108
+	fixTestReqID        = 112
+	fixResetSeqNumFlag  = 141
+	fixExecType         = 150
+	fixLeavesQty        = 151
+	fixLastQty          = 32
+	fixLastPx           = 31
+	fixRefTagID         = 371
+	fixRefMsgType       = 372
+	fixSessionRejReason = 373
+	fixBusinessRejReas  = 380
+	fixCxlRejResponseTo = 434
+)
+
+type fixField struct {
+	tag   int
+	value string
+}
+
+// fixMessage is a message's fields in order, from BeginString to the last
+// body field; the BodyLength and CheckSum are checked as it is read and
+// left out.
+type fixMessage []fixField
+
+// Get returns the value of the first field with tag, or "" if there is none.
+func (m fixMessage) Get(tag int) string {
+	for _, f := range m {
+		if f.tag == tag {
+			return f.value
+		}
+	}
+	return ""
+}
+
+func (m fixMessage) Type() string { return m.Get(fixMsgType) }
+
+// errFIXGarbled is a message that was framed correctly but whose checksum
+// is wrong. It is dropped and the session goes on.
+var errFIXGarbled = errors.New("fix: checksum mismatch")
+
+func fixChecksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// readFIXField reads one tag=value field, which must have tag.
+func readFIXField(r *bufio.Reader, tag string) ([]byte, string, error) {
+	raw, err := r.ReadSlice(fixSOH)
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			err = fmt.Errorf("fix: field %s too long", tag)
+		}
+		return nil, "", err
+	}
+	value, ok := strings.CutPrefix(string(raw[:len(raw)-1]), tag+"=")
+	if !ok {
+		return nil, "", fmt.Errorf("fix: expected tag %s, got %q", tag, raw)
+	}
+	return raw, value, nil
+}
+
+// readFIXMessage reads one message. An error other than errFIXGarbled means
+// the stream cannot be framed any more.
+func readFIXMessage(r *bufio.Reader) (fixMessage, error) {
+	begin, beginString, err := readFIXField(r, "8")
+	if err != nil {
+		return nil, err
+	}
+	sum := fixChecksum(begin)
+	length, value, err := readFIXField(r, "9")
+	if err != nil {
+		return nil, err
+	}
+	sum += fixChecksum(length)
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 || n > fixMaxBody {
+		return nil, fmt.Errorf("fix: invalid BodyLength %q", value)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	sum += fixChecksum(body)
+	_, value, err = readFIXField(r, "10")
+	if err != nil {
+		return nil, err
+	}
+	if body[n-1] != fixSOH {
+		return nil, errors.New("fix: BodyLength does not end on a field")
+	}
+	if want, err := strconv.Atoi(value); err != nil || want != sum%256 {
+		return nil, errFIXGarbled
+	}
+
+	msg := fixMessage{{fixBeginStr, beginString}}
+	for _, raw := range strings.Split(string(body[:n-1]), string(fixSOH)) {
+		tag, value, ok := strings.Cut(raw, "=")
+		t, err := strconv.Atoi(tag)
+		if !ok || err != nil || t <= 0 {
+			return nil, fmt.Errorf("fix: invalid field %q", raw)
+		}
+		msg = append(msg, fixField{t, value})
+	}
+	return msg, nil
+}
+
+// encodeFIX frames fields, which start at MsgType, into a message.
+func encodeFIX(fields []fixField) []byte {
+	var body []byte
+	for _, f := range fields {
+		body = strconv.AppendInt(body, int64(f.tag), 10)
+		body = append(body, '=')
+		body = append(body, f.value...)
+		body = append(body, fixSOH)
+	}
+	out := fmt.Appendf(nil, "8=%s\x019=%d\x01", fixBeginString, len(body))
+	out = append(out, body...)
+	return fmt.Appendf(out, "10=%03d\x01", fixChecksum(out))
+}
+
+// FIXAcceptor accepts FIX 4.4 sessions for an Exchange. Clients log on with
+// the acceptor's CompID as their TargetCompID, enter limit orders with
+// NewOrderSingle (D) and withdraw them with OrderCancelRequest (F); every
+// change to their orders comes back as an ExecutionReport (8), fills of
+// their resting orders included. Modifications, other order types and
+// market data are not offered: those are on the REST, WebSocket and gRPC
+// APIs.
+type FIXAcceptor struct {
+	exchange *Exchange
+	compID   string
+	execIDs  atomic.Uint64
+	sessions atomic.Uint64
+
+	mu     sync.Mutex
+	lis    net.Listener
+	conns  map[*fixSession]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewFIXAcceptor(x *Exchange, compID string) *FIXAcceptor {
+	return &FIXAcceptor{exchange: x, compID: compID, conns: make(map[*fixSession]struct{})}
+}
+
+// Serve accepts sessions on lis until Close.
+func (a *FIXAcceptor) Serve(lis net.Listener) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		lis.Close()
+		return net.ErrClosed
+	}
+	a.lis = lis
+	a.mu.Unlock()
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			a.mu.Lock()
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s := &fixSession{
+			acceptor: a,
+			conn:     conn,
+			r:        bufio.NewReaderSize(conn, 4096),
+			w:        bufio.NewWriter(conn),
+			clOrdIDs: make(map[string]uint64),
+			cancels:  make(map[uint64]string),
+		}
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		a.conns[s] = struct{}{}
+		a.wg.Add(1)
+		a.mu.Unlock()
+		go func() {
+			defer a.wg.Done()
+			s.serve()
+			a.mu.Lock()
+			delete(a.conns, s)
+			a.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting, logs every session out and waits for them to end.
+func (a *FIXAcceptor) Close() error {
+	a.mu.Lock()
+	a.closed = true
+	if a.lis != nil {
+		a.lis.Close()
+	}
+	for s := range a.conns {
+		s.logout("exchange shutting down")
+		s.conn.Close()
+	}
+	a.mu.Unlock()
+	a.wg.Wait()
+	return nil
+}
+
+// fixSession is one client connection.
+type fixSession struct {
+	acceptor  *FIXAcceptor
+	conn      net.Conn
+	r         *bufio.Reader
+	heartbeat time.Duration
+	peer      string // the client's SenderCompID
+	owner     string
+	inSeq     int // next expected from the client
+
+	wmu       sync.Mutex
+	w         *bufio.Writer
+	outSeq    int
+	lastSent  time.Time
+	loggedOut bool
+
+	clOrdIDs map[string]uint64 // every ClOrdID used in the session; the reader's alone
+
+	mu      sync.Mutex
+	cancels map[uint64]string // order ID → ClOrdID of the cancel in flight
+}
+
+func (s *fixSession) serve() {
+	defer s.conn.Close()
+	log := logger("fix").With("remote", s.conn.RemoteAddr().String())
+
+	s.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	logon, err := readFIXMessage(s.r)
+	if err != nil {
+		log.Warn("dropping FIX connection before logon", "err", err)
+		return
+	}
+	// Under the write lock, as Close may be logging the session out
+	s.wmu.Lock()
+	err = s.checkLogon(logon)
+	if err != nil {
+		s.peer = logon.Get(fixSenderCompID)
+	}
+	s.wmu.Unlock()
+	if err != nil {
+		log.Warn("rejecting FIX logon", "err", err)
+		s.logout(err.Error())
+		return
+	}
+	log = log.With("sender_comp_id", s.peer)
+	s.owner = fmt.Sprintf("fix:%s#%d", s.peer, s.acceptor.sessions.Add(1))
+
+	// Subscribed before the logon is answered, so no report is missed
+	x := s.acceptor.exchange
+	reports, cancel := x.bus.SubscribeAs(s.owner, 1024, nil, []EventType{EventOrder})
+	defer cancel()
+	logonReply := []fixField{{fixEncryptMethod, "0"}, {fixHeartBtInt, strconv.Itoa(int(s.heartbeat / time.Second))}}
+	if logon.Get(fixResetSeqNumFlag) == "Y" {
+		logonReply = append(logonReply, fixField{fixResetSeqNumFlag, "Y"})
+	}
+	if err := s.send("A", logonReply...); err != nil {
+		return
+	}
+	log.Info("FIX session logged on", "heartbeat", s.heartbeat)
+	defer log.Info("FIX session ended")
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.writeReports(reports, done)
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(2 * s.heartbeat))
+		msg, err := readFIXMessage(s.r)
+		if errors.Is(err, errFIXGarbled) {
+			log.Warn("dropping garbled FIX message")
+			continue
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				s.logout("heartbeat timeout")
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Warn("dropping FIX connection", "err", err)
+			}
+			return
+		}
+		if !s.handle(msg, log) {
+			return
+		}
+	}
+}
+
+func (s *fixSession) checkLogon(m fixMessage) error {
+	switch {
+	case m.Type() != "A":
+		return fmt.Errorf("expected Logon, got MsgType %q", m.Type())
+	case m.Get(fixBeginStr) != fixBeginString:
+		return fmt.Errorf("unsupported BeginString %q", m.Get(fixBeginStr))
+	case m.Get(fixTargetCompID) != s.acceptor.compID:
+		return fmt.Errorf("unknown TargetCompID %q", m.Get(fixTargetCompID))
+	case m.Get(fixSenderCompID) == "":
+		return errors.New("missing SenderCompID")
+	}
+	hb, err := strconv.Atoi(m.Get(fixHeartBtInt))
+	if err != nil || hb <= 0 {
+		return fmt.Errorf("invalid HeartBtInt %q", m.Get(fixHeartBtInt))
+	}
+	seq, err := strconv.Atoi(m.Get(fixMsgSeqNum))
+	if err != nil || seq <= 0 {
+		return fmt.Errorf("invalid MsgSeqNum %q", m.Get(fixMsgSeqNum))
+	}
+	s.peer, s.heartbeat, s.inSeq = m.Get(fixSenderCompID), time.Duration(hb)*time.Second, seq+1
+	return nil
+}
+
+// handle answers one message after the logon. It returns false when the
+// session is over.
+func (s *fixSession) handle(m fixMessage, log *slog.Logger) bool {
+	seq, err := strconv.Atoi(m.Get(fixMsgSeqNum))
+	switch {
+	case err != nil:
+		s.logout("invalid MsgSeqNum")
+		return false
+	case m.Get(fixSenderCompID) != s.peer || m.Get(fixTargetCompID) != s.acceptor.compID:
+		s.logout("CompID problem")
+		return false
+	case seq < s.inSeq && m.Get(fixPossDupFlag) != "Y":
+		s.logout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.inSeq, seq))
+		return false
+	case seq < s.inSeq:
+		return true // a resend of something already handled
+	case seq > s.inSeq:
+		// Nothing is resent on either side, so the gap is only noted
+		log.Warn("FIX sequence gap", "expected", s.inSeq, "received", seq)
+	}
+	s.inSeq = seq + 1
+
+	switch m.Type() {
+	case "0": // Heartbeat
+	case "1": // TestRequest
+		s.send("0", fixField{fixTestReqID, m.Get(fixTestReqID)})
+	case "2": // ResendRequest: there is nothing to resend, so skip it all
+		s.wmu.Lock()
+		if !s.loggedOut {
+			// Past the SequenceReset itself
+			s.sendLocked("4", []fixField{{fixNewSeqNo, strconv.Itoa(s.outSeq + 2)}})
+		}
+		s.wmu.Unlock()
+	case "4": // SequenceReset
+		if next, err := strconv.Atoi(m.Get(fixNewSeqNo)); err == nil && next > s.inSeq {
+			s.inSeq = next
+		}
+	case "5": // Logout
+		s.logout("")
+		return false
+	case "D":
+		s.newOrderSingle(m, seq)
+	case "F":
+		s.orderCancelRequest(m, seq)
+	default:
+		s.send("j",
+			fixField{fixRefSeqNum, strconv.Itoa(seq)},
+			fixField{fixRefMsgType, m.Type()},
+			fixField{fixBusinessRejReas, "3"}, // unsupported message type
+			fixField{fixText, "unsupported MsgType " + m.Type()},
+		)
+	}
+	return true
+}
+
+// rejectMessage is a session-level Reject (3) of a message missing a
+// required field or carrying one that cannot be read.
+func (s *fixSession) rejectMessage(seq, tag int, reason int, text string) {
+	s.send("3",
+		fixField{fixRefSeqNum, strconv.Itoa(seq)},
+		fixField{fixRefTagID, strconv.Itoa(tag)},
+		fixField{fixSessionRejReason, strconv.Itoa(reason)},
+		fixField{fixText, text},
+	)
+}
+
+func (s *fixSession) newOrderSingle(m fixMessage, seq int) {
+	for _, tag := range []int{fixClOrdID, fixSymbol, fixSide, fixOrderQty, fixOrdType} {
+		if m.Get(tag) == "" {
+			s.rejectMessage(seq, tag, 1, "required tag missing")
+			return
+		}
+	}
+	req := NewOrder{
+		Symbol:        m.Get(fixSymbol),
+		ClientOrderID: m.Get(fixClOrdID),
+		Account:       m.Get(fixAccount),
+		owner:         s.owner,
+	}
+	var err error
+	if req.Quantity, err = strconv.ParseFloat(m.Get(fixOrderQty), 64); err != nil {
+		s.rejectMessage(seq, fixOrderQty, 6, "incorrect data format for value")
+		return
+	}
+	if p := m.Get(fixPrice); p != "" {
+		if req.Price, err = strconv.ParseFloat(p, 64); err != nil {
+			s.rejectMessage(seq, fixPrice, 6, "incorrect data format for value")
+			return
+		}
+	}
+
+	switch m.Get(fixSide) {
+	case "1":
+		req.Side = orderbook.Buy
+	case "2":
+		req.Side = orderbook.Sell
+	default:
+		s.rejectMessage(seq, fixSide, 5, "value is incorrect (out of range) for this tag")
+		return
+	}
+	x := s.acceptor.exchange
+	if _, dup := s.clOrdIDs[req.ClientOrderID]; dup {
+		x.Reject(req, "duplicate ClOrdID")
+		return
+	}
+	if m.Get(fixOrdType) != "2" {
+		x.Reject(req, "only limit orders are supported")
+		return
+	}
+	r := x.Submit(req)
+	s.clOrdIDs[req.ClientOrderID] = r.OrderID
+}
+
+func (s *fixSession) orderCancelRequest(m fixMessage, seq int) {
+	for _, tag := range []int{fixClOrdID, fixOrigClOrdID} {
+		if m.Get(tag) == "" {
+			s.rejectMessage(seq, tag, 1, "required tag missing")
+			return
+		}
+	}
+	clOrdID, orig := m.Get(fixClOrdID), m.Get(fixOrigClOrdID)
+	id, ok := s.clOrdIDs[orig]
+	if !ok || id == 0 {
+		s.cancelReject(clOrdID, orig, 0, "1", "unknown order") // 1: unknown order
+		return
+	}
+	s.clOrdIDs[clOrdID] = 0
+	s.mu.Lock()
+	s.cancels[id] = clOrdID
+	s.mu.Unlock()
+	if _, err := s.acceptor.exchange.Cancel(id); err != nil {
+		s.mu.Lock()
+		delete(s.cancels, id)
+		s.mu.Unlock()
+		s.cancelReject(clOrdID, orig, id, "0", err.Error()) // 0: too late to cancel
+	}
+}
+
+// cancelReject is an OrderCancelReject (9) of a cancel request.
+func (s *fixSession) cancelReject(clOrdID, orig string, id uint64, reason, text string) {
+	orderID := "NONE"
+	if id != 0 {
+		orderID = strconv.FormatUint(id, 10)
+	}
+	s.send("9",
+		fixField{fixOrderID, orderID},
+		fixField{fixClOrdID, clOrdID},
+		fixField{fixOrigClOrdID, orig},
+		fixField{fixOrdStatus, "8"},
+		fixField{fixCxlRejResponseTo, "1"}, // to an OrderCancelRequest
+		fixField{fixCxlRejReason, reason},
+		fixField{fixText, text},
+	)
+}
+
+var (
+	fixExecTypes = map[ExecType]string{ExecNew: "0", ExecCanceled: "4", ExecReplaced: "5", ExecRejected: "8", ExecTrade: "F"}
+	fixStatuses  = map[OrderStatus]string{OrderNew: "0", OrderPartiallyFilled: "1", OrderFilled: "2", OrderCanceled: "4", OrderRejected: "8"}
+)
+
+// writeReports sends the session's order reports as ExecutionReports, and
+// heartbeats while there are none, until done.
+func (s *fixSession) writeReports(reports <-chan Event, done <-chan struct{}) {
+	heartbeat := time.NewTicker(s.heartbeat / 2)
+	defer heartbeat.Stop()
+	notional := make(map[uint64]float64) // of each open order's fills, for AvgPx
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			s.wmu.Lock()
+			idle := time.Since(s.lastSent)
+			s.wmu.Unlock()
+			if idle >= s.heartbeat {
+				s.send("0")
+			}
+		case e, ok := <-reports:
+			if !ok {
+				s.logout("report stream lagged")
+				s.conn.Close()
+				return
+			}
+			if r := e.Order; r.owner == s.owner {
+				if s.executionReport(r, notional) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *fixSession) executionReport(r *OrderReport, notional map[uint64]float64) error {
+	orderID := "NONE"
+	if r.OrderID != 0 {
+		orderID = strconv.FormatUint(r.OrderID, 10)
+	}
+	notional[r.OrderID] += r.LastPrice * r.LastQuantity
+	avg := 0.0
+	if r.Filled > 0 {
+		avg = notional[r.OrderID] / r.Filled
+	}
+	if r.Status != OrderNew && r.Status != OrderPartiallyFilled {
+		delete(notional, r.OrderID)
+	}
+
+	fields := []fixField{{fixOrderID, orderID}, {fixClOrdID, r.ClientOrderID}}
+	if r.ExecType == ExecCanceled {
+		s.mu.Lock()
+		if clOrdID, ok := s.cancels[r.OrderID]; ok {
+			fields = []fixField{{fixOrderID, orderID}, {fixClOrdID, clOrdID}, {fixOrigClOrdID, r.ClientOrderID}}
+			delete(s.cancels, r.OrderID)
+		}
+		s.mu.Unlock()
+	}
+	fields = append(fields,
+		fixField{fixExecID, strconv.FormatUint(s.acceptor.execIDs.Add(1), 10)},
+		fixField{fixExecType, fixExecTypes[r.ExecType]},
+		fixField{fixOrdStatus, fixStatuses[r.Status]},
+	)
+	if r.Account != "" {
+		fields = append(fields, fixField{fixAccount, r.Account})
+	}
+	fields = append(fields,
+		fixField{fixSymbol, strings.ToUpper(r.Symbol)},
+		fixField{fixSide, map[orderbook.Side]string{orderbook.Buy: "1", orderbook.Sell: "2"}[r.Side]},
+		fixField{fixOrdType, "2"},
+		fixField{fixPrice, formatFIXFloat(r.Price)},
+		fixField{fixOrderQty, formatFIXFloat(r.Quantity)},
+	)
+	if r.ExecType == ExecTrade {
+		fields = append(fields, fixField{fixLastQty, formatFIXFloat(r.LastQuantity)}, fixField{fixLastPx, formatFIXFloat(r.LastPrice)})
+	}
+	fields = append(fields,
+		fixField{fixLeavesQty, formatFIXFloat(r.Remaining)},
+		fixField{fixCumQty, formatFIXFloat(r.Filled)},
+		fixField{fixAvgPx, formatFIXFloat(avg)},
+		fixField{fixTransactTime, r.Timestamp.UTC().Format(fixTimeFormat)},
+	)
+	if r.ExecType == ExecRejected {
+		fields = append(fields, fixField{fixOrdRejReason, "99"}, fixField{fixText, r.Reason}) // 99: other
+	}
+	return s.send("8", fields...)
+}
+
+func formatFIXFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// send writes a message with the session's header. Nothing is sent once
+// the session has logged out.
+func (s *fixSession) send(msgType string, body ...fixField) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.loggedOut {
+		return net.ErrClosed
+	}
+	return s.sendLocked(msgType, body)
+}
+
+func (s *fixSession) sendLocked(msgType string, body []fixField) error {
+	s.outSeq++
+	fields := append([]fixField{
+		{fixMsgType, msgType},
+		{fixSenderCompID, s.acceptor.compID},
+		{fixTargetCompID, s.peer},
+		{fixMsgSeqNum, strconv.Itoa(s.outSeq)},
+		{fixSendingTime, time.Now().UTC().Format(fixTimeFormat)},
+	}, body...)
+	s.lastSent = time.Now()
+	s.conn.SetWriteDeadline(s.lastSent.Add(5 * time.Second))
+	if _, err := s.w.Write(encodeFIX(fields)); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// logout sends a Logout (5) with text, once; nothing is sent after it.
+func (s *fixSession) logout(text string) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.loggedOut {
+		return
+	}
+	s.loggedOut = true
+	var body []fixField
+	if text != "" {
+		body = append(body, fixField{fixText, text})
+	}
+	s.sendLocked("5", body)
+}
@@ -0,0 +1,144 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFIXFraming(t *testing.T) {
+	raw := encodeFIX([]fixField{{fixMsgType, "0"}, {fixSenderCompID, "CLIENT"}, {fixTestReqID, "a=b"}})
+	if want := "8=FIX.4.4\x019=23\x0135=0\x0149=CLIENT\x01112=a=b\x01"; !bytes.HasPrefix(raw, []byte(want)) {
+		t.Fatalf("encoded %q, want it to start with %q", raw, want)
+	}
+	m, err := readFIXMessage(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type() != "0" || m.Get(fixSenderCompID) != "CLIENT" || m.Get(fixTestReqID) != "a=b" || m.Get(fixBeginStr) != "FIX.4.4" {
+		t.Errorf("decoded %v", m)
+	}
+
+	garbled := append([]byte{}, raw...)
+	garbled[len(garbled)-2]++
+	if _, err := readFIXMessage(bufio.NewReader(bytes.NewReader(garbled))); err != errFIXGarbled {
+		t.Errorf("checksum mismatch: %v", err)
+	}
+	short := bytes.Replace(raw, []byte("9=23"), []byte("9=21"), 1)
+	if _, err := readFIXMessage(bufio.NewReader(bytes.NewReader(short))); err == nil || err == errFIXGarbled {
+		t.Errorf("wrong BodyLength: %v", err)
+	}
+}
+
+// fixClient is the initiator side of a test session.
+type fixClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	comp string
+	seq  int
+}
+
+func dialFIX(t *testing.T, addr, comp string) *fixClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &fixClient{t: t, conn: conn, r: bufio.NewReader(conn), comp: comp}
+	c.send("A", fixField{fixEncryptMethod, "0"}, fixField{fixHeartBtInt, "30"})
+	if m := c.read(); m.Type() != "A" || m.Get(fixHeartBtInt) != "30" {
+		t.Fatalf("logon answered with %v", m)
+	}
+	return c
+}
+
+func (c *fixClient) send(msgType string, body ...fixField) {
+	c.seq++
+	fields := append([]fixField{
+		{fixMsgType, msgType},
+		{fixSenderCompID, c.comp},
+		{fixTargetCompID, "APEXLOB"},
+		{fixMsgSeqNum, strconv.Itoa(c.seq)},
+		{fixSendingTime, time.Now().UTC().Format(fixTimeFormat)},
+	}, body...)
+	if _, err := c.conn.Write(encodeFIX(fields)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *fixClient) read() fixMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readFIXMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+// expect reads a message and checks its type and fields.
+func (c *fixClient) expect(msgType string, want map[int]string) fixMessage {
+	c.t.Helper()
+	m := c.read()
+	if m.Type() != msgType {
+		c.t.Fatalf("%s received %v, want MsgType %s", c.comp, m, msgType)
+	}
+	for tag, v := range want {
+		if got := m.Get(tag); got != v {
+			c.t.Errorf("%s received %v: tag %d is %q, want %q", c.comp, m, tag, got, v)
+		}
+	}
+	return m
+}
+
+func TestFIXAcceptor(t *testing.T) {
+	_, x := newTestExchange(t)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptor := NewFIXAcceptor(x, "APEXLOB")
+	go acceptor.Serve(lis)
+	defer acceptor.Close()
+
+	maker := dialFIX(t, lis.Addr().String(), "MAKER")
+	taker := dialFIX(t, lis.Addr().String(), "TAKER")
+	order := func(c *fixClient, clOrdID, side, qty, price string) {
+		c.send("D", fixField{fixClOrdID, clOrdID}, fixField{fixSymbol, "BTCUSDT"}, fixField{fixSide, side},
+			fixField{fixOrderQty, qty}, fixField{fixOrdType, "2"}, fixField{fixPrice, price}, fixField{fixTransactTime, "20240305-12:00:00"})
+	}
+
+	order(maker, "m1", "2", "2", "100")
+	maker.expect("8", map[int]string{fixOrderID: "1", fixClOrdID: "m1", fixExecType: "0", fixOrdStatus: "0", fixSymbol: "BTCUSDT", fixSide: "2", fixLeavesQty: "2"})
+
+	order(taker, "t1", "1", "0.5", "101")
+	taker.expect("8", map[int]string{fixClOrdID: "t1", fixExecType: "0"})
+	taker.expect("8", map[int]string{fixClOrdID: "t1", fixExecType: "F", fixOrdStatus: "2", fixLastPx: "100", fixLastQty: "0.5", fixCumQty: "0.5", fixLeavesQty: "0", fixAvgPx: "100"})
+	maker.expect("8", map[int]string{fixClOrdID: "m1", fixExecType: "F", fixOrdStatus: "1", fixLastQty: "0.5", fixCumQty: "0.5", fixLeavesQty: "1.5"})
+
+	maker.send("F", fixField{fixClOrdID, "m2"}, fixField{fixOrigClOrdID, "m1"}, fixField{fixSymbol, "BTCUSDT"}, fixField{fixSide, "2"})
+	maker.expect("8", map[int]string{fixClOrdID: "m2", fixOrigClOrdID: "m1", fixExecType: "4", fixOrdStatus: "4", fixCumQty: "0.5"})
+	maker.send("F", fixField{fixClOrdID, "m3"}, fixField{fixOrigClOrdID, "m1"})
+	maker.expect("9", map[int]string{fixOrderID: "1", fixClOrdID: "m3", fixCxlRejReason: "0"})
+	maker.send("F", fixField{fixClOrdID, "m4"}, fixField{fixOrigClOrdID, "t1"})
+	maker.expect("9", map[int]string{fixOrderID: "NONE", fixCxlRejReason: "1"})
+
+	// Rejections: a market order, a reused ClOrdID, a missing field and an
+	// unsupported message
+	taker.send("D", fixField{fixClOrdID, "t2"}, fixField{fixSymbol, "BTCUSDT"}, fixField{fixSide, "1"}, fixField{fixOrderQty, "1"}, fixField{fixOrdType, "1"})
+	taker.expect("8", map[int]string{fixOrderID: "NONE", fixClOrdID: "t2", fixExecType: "8", fixOrdStatus: "8", fixText: "only limit orders are supported"})
+	order(taker, "t1", "1", "1", "99")
+	taker.expect("8", map[int]string{fixExecType: "8", fixText: "duplicate ClOrdID"})
+	taker.send("D", fixField{fixClOrdID, "t3"}, fixField{fixSymbol, "BTCUSDT"}, fixField{fixSide, "1"}, fixField{fixOrdType, "2"})
+	taker.expect("3", map[int]string{fixRefTagID: "38", fixSessionRejReason: "1"})
+	taker.send("G", fixField{fixClOrdID, "t4"})
+	taker.expect("j", map[int]string{fixRefMsgType: "G", fixBusinessRejReas: "3"})
+
+	taker.send("1", fixField{fixTestReqID, "ping"})
+	taker.expect("0", map[int]string{fixTestReqID: "ping", fixMsgSeqNum: "8"})
+}
@@ -0,0 +1,129 @@
+package apexlob
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/signals"
+)
+
+// fundingInterval is how often Binance perpetuals settle funding, for the
+// daily and yearly projections. Most symbols settle every 8 hours.
+const fundingInterval = 8 * time.Hour
+
+// FundingProjection is what a hypothetical perpetual position of Notional
+// (in the quote currency, positive long, negative short) would pay or
+// receive in funding if the current rate held. A positive rate has longs
+// pay shorts. Payments are from the position's side: positive when it
+// receives. BreakevenBps is how far the price must move in the position's
+// favour each funding period to cover what it pays, negative when funding
+// pays it instead.
+type FundingProjection struct {
+	Symbol       string    `json:"symbol"`
+	Notional     float64   `json:"notional"`
+	Mark         float64   `json:"mark"`
+	Index        float64   `json:"index"`
+	BasisBps     float64   `json:"basis_bps"` // mark premium over the index
+	FundingRate  float64   `json:"funding_rate"`
+	NextFunding  time.Time `json:"next_funding"`
+	UntilFunding Duration  `json:"until_funding"`
+	NextPayment  float64   `json:"next_payment"`
+	DailyPayment float64   `json:"daily_payment"`
+	// AnnualRatePct is the rate compounded over a year of periods, as a
+	// percentage a long pays
+	AnnualRatePct float64 `json:"annual_rate_pct"`
+	BreakevenBps  float64 `json:"breakeven_bps"`
+}
+
+// ProjectFunding projects p's funding rate onto a position of notional at
+// now.
+func ProjectFunding(symbol string, p ReferencePrices, notional float64, now time.Time) FundingProjection {
+	periodsPerDay := float64(24*time.Hour) / float64(fundingInterval)
+	f := FundingProjection{
+		Symbol:        symbol,
+		Notional:      notional,
+		Mark:          p.Mark,
+		Index:         p.Index,
+		FundingRate:   p.FundingRate,
+		NextFunding:   p.NextFunding,
+		NextPayment:   -notional * p.FundingRate,
+		AnnualRatePct: (math.Pow(1+p.FundingRate, periodsPerDay*365) - 1) * 100,
+	}
+	f.DailyPayment = f.NextPayment * periodsPerDay
+	if p.Index > 0 {
+		f.BasisBps = (p.Mark - p.Index) / p.Index * 1e4
+	}
+	if until := p.NextFunding.Sub(now); until > 0 {
+		f.UntilFunding = Duration(until.Truncate(time.Second))
+	}
+	if notional != 0 {
+		f.BreakevenBps = -f.NextPayment / math.Abs(notional) * 1e4
+	}
+	return f
+}
+
+// registerFundingSignals adds funding_payment and funding_breakeven_bps to
+// state's signals: the next funding payment and break-even move of a
+// position of notional, unset until a funding rate has arrived.
+func registerFundingSignals(state *SymbolState, notional float64) {
+	projected := func(fn func(*FundingProjection) float64) func(*signals.Input) float64 {
+		return func(in *signals.Input) float64 {
+			p, ok := state.ReferencePrices()
+			if !ok || p.NextFunding.IsZero() {
+				return math.NaN()
+			}
+			f := ProjectFunding(state.Symbol, p, notional, in.Trade.Timestamp)
+			return fn(&f)
+		}
+	}
+	state.Signals.Register(signals.Func("funding_payment", projected(func(f *FundingProjection) float64 { return f.NextPayment })))
+	state.Signals.Register(signals.Func("funding_breakeven_bps", projected(func(f *FundingProjection) float64 { return f.BreakevenBps })))
+}
+
+// FundingHandler serves GET /funding, the projection of every symbol with a
+// funding rate, and GET /funding/{symbol}. The position is notional unless
+// the request sets ?notional=.
+func FundingHandler(symbols *SymbolRegistry, notional float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		n := notional
+		if v := r.URL.Query().Get("notional"); v != "" {
+			var err error
+			if n, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				writeError(w, http.StatusBadRequest, "invalid notional parameter")
+				return
+			}
+		}
+		now := time.Now()
+		symbol := strings.Trim(strings.TrimPrefix(r.URL.Path, "/funding"), "/")
+		if symbol == "" {
+			out := []FundingProjection{}
+			for _, sym := range symbols.List() {
+				if state, ok := symbols.Get(sym); ok {
+					if p, ok := state.ReferencePrices(); ok && !p.NextFunding.IsZero() {
+						out = append(out, ProjectFunding(sym, p, n, now))
+					}
+				}
+			}
+			writeJSON(w, out)
+			return
+		}
+		state, ok := symbols.Get(symbol)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown symbol "+strconv.Quote(symbol))
+			return
+		}
+		p, ok := state.ReferencePrices()
+		if !ok || p.NextFunding.IsZero() {
+			writeError(w, http.StatusServiceUnavailable, "no funding rate for "+state.Symbol+" yet")
+			return
+		}
+		writeJSON(w, ProjectFunding(state.Symbol, p, n, now))
+	})
+}
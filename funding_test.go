@@ -0,0 +1,78 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProjectFunding(t *testing.T) {
+	now := time.Date(2026, 10, 16, 6, 0, 0, 0, time.UTC)
+	p := ReferencePrices{Mark: 100.1, Index: 100, FundingRate: 0.0001, NextFunding: now.Add(2*time.Hour + 500*time.Millisecond)}
+	long := ProjectFunding("btcusdt", p, 10000, now)
+	if long.NextPayment != -1 || math.Abs(long.DailyPayment+3) > 1e-9 || math.Abs(long.BreakevenBps-1) > 1e-9 {
+		t.Errorf("long pays %v next, %v a day, breaks even at %v bps", long.NextPayment, long.DailyPayment, long.BreakevenBps)
+	}
+	if math.Abs(long.BasisBps-10) > 1e-9 || time.Duration(long.UntilFunding) != 2*time.Hour {
+		t.Errorf("basis %v bps, %v until funding", long.BasisBps, time.Duration(long.UntilFunding))
+	}
+	// 1bp three times a day compounds to about 11.6% a year
+	if long.AnnualRatePct < 11.5 || long.AnnualRatePct > 11.7 {
+		t.Errorf("annual rate = %v%%", long.AnnualRatePct)
+	}
+	short := ProjectFunding("btcusdt", p, -5000, now)
+	if short.NextPayment != 0.5 || math.Abs(short.BreakevenBps+1) > 1e-9 {
+		t.Errorf("short receives %v, breaks even at %v bps", short.NextPayment, short.BreakevenBps)
+	}
+}
+
+func TestReplayFunding(t *testing.T) {
+	capture := `{"e":"markPriceUpdate","E":1700000000000,"s":"BTCUSDT","p":"100.00","i":"100.00","r":"-0.00020000","T":1700006400000}` + "\n" +
+		`{"e":"aggTrade","E":1700000000100,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":false}` + "\n"
+	path := filepath.Join(t.TempDir(), "funding.jsonl")
+	if err := os.WriteFile(path, []byte(capture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, FundingNotional: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	state, _ := m.Symbols.Get("btcusdt")
+	// Shorts pay longs at a negative rate
+	for name, want := range map[string]float64{"funding_rate_bps": -2, "funding_payment": 0.4, "funding_breakeven_bps": -2} {
+		if v, _ := state.Signals.Value(name); math.Abs(v-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+
+	h := FundingHandler(m.Symbols, m.funding)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/funding/BTCUSDT?notional=-1000", nil))
+	var f FundingProjection
+	if err := json.Unmarshal(rec.Body.Bytes(), &f); err != nil || f.Notional != -1000 || math.Abs(f.NextPayment+0.2) > 1e-9 {
+		t.Errorf("GET /funding/BTCUSDT = %s (%v)", rec.Body, err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/funding", nil))
+	var all []FundingProjection
+	if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil || len(all) != 1 || all[0].Notional != 2000 {
+		t.Errorf("GET /funding = %s (%v)", rec.Body, err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/funding/btcusdt?notional=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("bad notional answered %d", rec.Code)
+	}
+}
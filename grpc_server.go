@@ -0,0 +1,304 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/proto/apexlobpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer implements the MarketData service from proto/apexlob.proto.
+type GRPCServer struct {
+	apexlobpb.UnimplementedMarketDataServer
+	symbols *SymbolRegistry
+	bus     *EventBus
+}
+
+func NewGRPCServer(symbols *SymbolRegistry, bus *EventBus) *grpc.Server {
+	srv := grpc.NewServer()
+	apexlobpb.RegisterMarketDataServer(srv, &GRPCServer{symbols: symbols, bus: bus})
+	return srv
+}
+
+func (s *GRPCServer) Subscribe(req *apexlobpb.SubscribeRequest, stream apexlobpb.MarketData_SubscribeServer) error {
+	var symbols []string
+	for _, sym := range req.GetSymbols() {
+		state, ok := s.symbols.Get(sym)
+		if !ok {
+			return status.Errorf(codes.NotFound, "unknown symbol %q", sym)
+		}
+		symbols = append(symbols, state.Symbol)
+	}
+	var types []EventType
+	for _, t := range req.GetTypes() {
+		et, ok := eventTypeFromProto(t)
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "unsupported event type %v", t)
+		}
+		types = append(types, et)
+	}
+
+	events, cancel := s.bus.SubscribeAs("grpc", 1024, symbols, types)
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			pe := eventToProto(&e)
+			e.Release()
+			if pe.Payload == nil {
+				continue // no protobuf form (e.g. executions)
+			}
+			if err := stream.Send(pe); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *GRPCServer) GetSnapshot(ctx context.Context, req *apexlobpb.SnapshotRequest) (*apexlobpb.Snapshot, error) {
+	state, ok := s.symbols.Get(strings.TrimSpace(req.GetSymbol()))
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown symbol %q", req.GetSymbol())
+	}
+	depth := int(req.GetDepth())
+	if depth == 0 {
+		depth = 20
+	}
+	limit := int(req.GetTradeLimit())
+	if limit == 0 {
+		limit = 100
+	}
+
+	book := state.BookSnapshot(depth)
+	snap := &apexlobpb.Snapshot{
+		Book: bookToProto(&book),
+		Signals: &apexlobpb.SignalUpdate{
+			Symbol:      state.Symbol,
+			TimestampNs: book.Timestamp.UnixNano(),
+			Values:      state.Signals.Snapshot(),
+		},
+	}
+	for _, tr := range state.Tape.Recent(limit) {
+		tr := tr
+		snap.RecentTrades = append(snap.RecentTrades, tradeToProto(&tr))
+	}
+	if c, ok := state.Candles.Current(); ok {
+		snap.CurrentCandle = candleToProto(&c)
+	}
+	return snap, nil
+}
+
+// OrderEntryServer implements the OrderEntry service from
+// proto/apexlob.proto on an Exchange.
+type OrderEntryServer struct {
+	apexlobpb.UnimplementedOrderEntryServer
+	exchange *Exchange
+}
+
+func NewOrderEntryServer(x *Exchange) *OrderEntryServer {
+	return &OrderEntryServer{exchange: x}
+}
+
+func (s *OrderEntryServer) NewOrder(ctx context.Context, req *apexlobpb.NewOrderRequest) (*apexlobpb.OrderReport, error) {
+	order := NewOrder{
+		Symbol:        req.GetSymbol(),
+		Price:         req.GetPrice(),
+		Quantity:      req.GetQuantity(),
+		ClientOrderID: req.GetClientOrderId(),
+		Account:       req.GetAccount(),
+	}
+	switch req.GetSide() {
+	case apexlobpb.Side_SIDE_BUY:
+		order.Side = orderbook.Buy
+	case apexlobpb.Side_SIDE_SELL:
+		order.Side = orderbook.Sell
+	default:
+		return orderReportToProto(s.exchange.Reject(order, "invalid side")), nil
+	}
+	return orderReportToProto(s.exchange.Submit(order)), nil
+}
+
+func (s *OrderEntryServer) CancelOrder(ctx context.Context, req *apexlobpb.CancelOrderRequest) (*apexlobpb.OrderReport, error) {
+	r, err := s.exchange.Cancel(req.GetOrderId())
+	if err != nil {
+		return nil, orderEntryStatus(err)
+	}
+	return orderReportToProto(r), nil
+}
+
+func (s *OrderEntryServer) ModifyOrder(ctx context.Context, req *apexlobpb.ModifyOrderRequest) (*apexlobpb.OrderReport, error) {
+	r, err := s.exchange.Modify(req.GetOrderId(), req.GetPrice(), req.GetQuantity())
+	if err != nil {
+		return nil, orderEntryStatus(err)
+	}
+	return orderReportToProto(r), nil
+}
+
+func (s *OrderEntryServer) StreamReports(req *apexlobpb.ReportsRequest, stream apexlobpb.OrderEntry_StreamReportsServer) error {
+	var symbols []string
+	for _, sym := range req.GetSymbols() {
+		state, ok := s.exchange.symbols.Get(sym)
+		if !ok {
+			return status.Errorf(codes.NotFound, "unknown symbol %q", sym)
+		}
+		symbols = append(symbols, state.Symbol)
+	}
+	reports, cancel := s.exchange.bus.SubscribeAs("grpc-orders", 1024, symbols, []EventType{EventOrder})
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-reports:
+			if !ok {
+				return nil
+			}
+			if req.GetAccount() != "" && e.Order.Account != req.GetAccount() {
+				continue
+			}
+			if err := stream.Send(orderReportToProto(*e.Order)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func orderEntryStatus(err error) error {
+	if errors.Is(err, errUnknownOrder) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.FailedPrecondition, err.Error())
+}
+
+var (
+	orderStatusToProto = map[OrderStatus]apexlobpb.OrderStatus{
+		OrderNew:             apexlobpb.OrderStatus_ORDER_STATUS_NEW,
+		OrderPartiallyFilled: apexlobpb.OrderStatus_ORDER_STATUS_PARTIALLY_FILLED,
+		OrderFilled:          apexlobpb.OrderStatus_ORDER_STATUS_FILLED,
+		OrderCanceled:        apexlobpb.OrderStatus_ORDER_STATUS_CANCELED,
+		OrderRejected:        apexlobpb.OrderStatus_ORDER_STATUS_REJECTED,
+	}
+	execTypeToProto = map[ExecType]apexlobpb.ExecType{
+		ExecNew:      apexlobpb.ExecType_EXEC_TYPE_NEW,
+		ExecTrade:    apexlobpb.ExecType_EXEC_TYPE_TRADE,
+		ExecCanceled: apexlobpb.ExecType_EXEC_TYPE_CANCELED,
+		ExecReplaced: apexlobpb.ExecType_EXEC_TYPE_REPLACED,
+		ExecRejected: apexlobpb.ExecType_EXEC_TYPE_REJECTED,
+	}
+)
+
+func orderReportToProto(r OrderReport) *apexlobpb.OrderReport {
+	side := apexlobpb.Side_SIDE_SELL
+	if r.Side == orderbook.Buy {
+		side = apexlobpb.Side_SIDE_BUY
+	}
+	return &apexlobpb.OrderReport{
+		OrderId:       r.OrderID,
+		ClientOrderId: r.ClientOrderID,
+		Account:       r.Account,
+		Symbol:        r.Symbol,
+		Side:          side,
+		ExecType:      execTypeToProto[r.ExecType],
+		Status:        orderStatusToProto[r.Status],
+		Price:         r.Price,
+		Quantity:      r.Quantity,
+		Filled:        r.Filled,
+		Remaining:     r.Remaining,
+		TradeId:       r.TradeID,
+		LastPrice:     r.LastPrice,
+		LastQuantity:  r.LastQuantity,
+		Maker:         r.Maker,
+		Reason:        r.Reason,
+		TimestampNs:   r.Timestamp.UnixNano(),
+	}
+}
+
+func eventTypeFromProto(t apexlobpb.EventType) (EventType, bool) {
+	switch t {
+	case apexlobpb.EventType_EVENT_TYPE_TRADE:
+		return EventTrade, true
+	case apexlobpb.EventType_EVENT_TYPE_BOOK:
+		return EventBook, true
+	case apexlobpb.EventType_EVENT_TYPE_CANDLE:
+		return EventCandle, true
+	case apexlobpb.EventType_EVENT_TYPE_SIGNAL:
+		return EventSignal, true
+	}
+	return "", false
+}
+
+func eventToProto(e *Event) *apexlobpb.Event {
+	out := &apexlobpb.Event{}
+	switch e.Type {
+	case EventTrade:
+		out.Payload = &apexlobpb.Event_Trade{Trade: tradeToProto(e.Trade)}
+	case EventBook:
+		out.Payload = &apexlobpb.Event_Book{Book: bookToProto(e.Book)}
+	case EventCandle:
+		out.Payload = &apexlobpb.Event_Candle{Candle: candleToProto(e.Candle)}
+	case EventSignal:
+		out.Payload = &apexlobpb.Event_Signals{Signals: &apexlobpb.SignalUpdate{
+			Symbol:      e.Symbol,
+			TimestampNs: e.Timestamp.UnixNano(),
+			Values:      e.Signals,
+		}}
+	}
+	return out
+}
+
+func tradeToProto(tr *orderbook.Trade) *apexlobpb.Trade {
+	side := apexlobpb.Side_SIDE_SELL
+	if tr.Side == orderbook.Buy {
+		side = apexlobpb.Side_SIDE_BUY
+	}
+	return &apexlobpb.Trade{
+		Symbol:      tr.Symbol,
+		Id:          tr.ID,
+		Price:       tr.Price,
+		Quantity:    tr.Quantity,
+		Side:        side,
+		TimestampNs: tr.Timestamp.UnixNano(),
+	}
+}
+
+func bookToProto(b *BookSnapshot) *apexlobpb.BookUpdate {
+	out := &apexlobpb.BookUpdate{
+		Symbol:         b.Symbol,
+		TimestampNs:    b.Timestamp.UnixNano(),
+		LastTradePrice: b.LastTradePrice,
+		Vwap:           b.VWAP,
+		TotalVolume:    b.TotalVolume,
+	}
+	for _, l := range b.Bids {
+		out.Bids = append(out.Bids, &apexlobpb.PriceLevel{Price: l.Price, Volume: l.Volume, Orders: uint32(l.Orders)})
+	}
+	for _, l := range b.Asks {
+		out.Asks = append(out.Asks, &apexlobpb.PriceLevel{Price: l.Price, Volume: l.Volume, Orders: uint32(l.Orders)})
+	}
+	return out
+}
+
+func candleToProto(c *Candle) *apexlobpb.Candle {
+	return &apexlobpb.Candle{
+		Symbol:     c.Symbol,
+		OpenTimeNs: c.OpenTime.UnixNano(),
+		IntervalNs: int64(c.Interval),
+		Open:       c.Open,
+		High:       c.High,
+		Low:        c.Low,
+		Close:      c.Close,
+		Volume:     c.Volume,
+		Trades:     uint32(c.Trades),
+	}
+}
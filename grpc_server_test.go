@@ -0,0 +1,95 @@
+package apexlob
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/proto/apexlobpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startTestGRPC(t *testing.T) (apexlobpb.MarketDataClient, *SymbolState, *EventBus) {
+	t.Helper()
+	state := NewSymbolState("btcusdt")
+	symbols := NewSymbolRegistry()
+	symbols.Add(state)
+	bus := NewEventBus()
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(symbols, bus)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return apexlobpb.NewMarketDataClient(conn), state, bus
+}
+
+func TestGRPCGetSnapshot(t *testing.T) {
+	client, state, _ := startTestGRPC(t)
+	state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99.0, Quantity: 100, Side: orderbook.Buy})
+	tr := orderbook.Trade{Symbol: "btcusdt", ID: 9, Price: 100, Quantity: 1, Side: orderbook.Sell, Timestamp: time.Now()}
+	state.Tape.Add(tr)
+	state.Candles.Add(&tr)
+
+	snap, err := client.GetSnapshot(context.Background(), &apexlobpb.SnapshotRequest{Symbol: "BTCUSDT", Depth: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Book.Bids) != 1 || snap.Book.Bids[0].Price != 99.0 {
+		t.Errorf("snapshot bids = %v", snap.Book.Bids)
+	}
+	if len(snap.RecentTrades) != 1 || snap.RecentTrades[0].Side != apexlobpb.Side_SIDE_SELL {
+		t.Errorf("snapshot trades = %v", snap.RecentTrades)
+	}
+	if snap.CurrentCandle == nil || snap.CurrentCandle.Close != 100 {
+		t.Errorf("snapshot candle = %v", snap.CurrentCandle)
+	}
+
+	_, err = client.GetSnapshot(context.Background(), &apexlobpb.SnapshotRequest{Symbol: "nope"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("unknown symbol error = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	client, _, bus := startTestGRPC(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Subscribe(ctx, &apexlobpb.SubscribeRequest{
+		Symbols: []string{"btcusdt"},
+		Types:   []apexlobpb.EventType{apexlobpb.EventType_EVENT_TYPE_TRADE},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the server side to register the subscription
+	for !bus.Wants(EventTrade) {
+		time.Sleep(time.Millisecond)
+	}
+	bus.Publish(Event{Type: EventSignal, Symbol: "btcusdt", Signals: map[string]float64{"x": 1}})
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{Symbol: "btcusdt", ID: 3, Price: 101, Side: orderbook.Buy}})
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.GetTrade() == nil || ev.GetTrade().Id != 3 || ev.GetTrade().Side != apexlobpb.Side_SIDE_BUY {
+		t.Errorf("received %v, want trade 3", ev)
+	}
+}
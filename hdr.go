@@ -0,0 +1,124 @@
+package apexlob
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// HDRHistogram records durations into log-linear buckets in the style of
+// HdrHistogram: every power-of-two range is split into the same number of
+// linear sub-buckets, so any recorded value is reported within a fixed
+// relative error (about 0.4% here) from nanoseconds up to the maximum.
+// Recording is a single atomic add and safe for concurrent use.
+type HDRHistogram struct {
+	max    int64
+	counts []uint64
+	total  uint64
+}
+
+const (
+	hdrSubBucketBits = 8 // 256 sub-buckets: two significant decimal digits
+	hdrSubBuckets    = 1 << hdrSubBucketBits
+	hdrHalfBits      = hdrSubBucketBits - 1
+	hdrHalfCount     = hdrSubBuckets / 2
+)
+
+// NewHDRHistogram tracks values from 1ns to max; larger values are recorded
+// as max.
+func NewHDRHistogram(max time.Duration) *HDRHistogram {
+	if max < hdrSubBuckets {
+		max = hdrSubBuckets
+	}
+	buckets := 1
+	for trackable := int64(hdrSubBuckets - 1); trackable < int64(max); trackable = trackable<<1 | 1 {
+		buckets++
+	}
+	return &HDRHistogram{max: int64(max), counts: make([]uint64, (buckets+1)*hdrHalfCount)}
+}
+
+func hdrIndex(v int64) int {
+	bucket := 64 - bits.LeadingZeros64(uint64(v)|(hdrSubBuckets-1)) - hdrSubBucketBits
+	sub := int(v >> uint(bucket))
+	return (bucket+1)<<hdrHalfBits + sub - hdrHalfCount
+}
+
+// hdrValue is the highest value that maps to counts index i.
+func hdrValue(i int) int64 {
+	bucket := i>>hdrHalfBits - 1
+	sub := int64(i&(hdrHalfCount-1)) + hdrHalfCount
+	if bucket < 0 {
+		sub -= hdrHalfCount
+		bucket = 0
+	}
+	return (sub+1)<<uint(bucket) - 1
+}
+
+func (h *HDRHistogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	} else if v > h.max {
+		v = h.max
+	}
+	atomic.AddUint64(&h.counts[hdrIndex(v)], 1)
+	atomic.AddUint64(&h.total, 1)
+}
+
+func (h *HDRHistogram) Count() uint64 { return atomic.LoadUint64(&h.total) }
+
+// Merge adds the counts of other, which must have the same maximum.
+func (h *HDRHistogram) Merge(other *HDRHistogram) {
+	for i := range other.counts {
+		if n := atomic.LoadUint64(&other.counts[i]); n > 0 {
+			atomic.AddUint64(&h.counts[i], n)
+		}
+	}
+	atomic.AddUint64(&h.total, other.Count())
+}
+
+// Quantiles returns the value at each quantile q (0 < q <= 1) in a single
+// pass; qs must be ascending. An empty histogram reports zeros.
+func (h *HDRHistogram) Quantiles(qs ...float64) []time.Duration {
+	out := make([]time.Duration, len(qs))
+	total := h.Count()
+	if total == 0 {
+		return out
+	}
+	var seen uint64
+	next := 0
+	for i := range h.counts {
+		seen += atomic.LoadUint64(&h.counts[i])
+		for next < len(qs) && float64(seen) >= qs[next]*float64(total) {
+			v := hdrValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			out[next] = time.Duration(v)
+			next++
+		}
+		if next == len(qs) {
+			break
+		}
+	}
+	for ; next < len(qs); next++ {
+		out[next] = time.Duration(h.max)
+	}
+	return out
+}
+
+// LatencyPercentiles summarizes a latency distribution in milliseconds.
+type LatencyPercentiles struct {
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+}
+
+var latencyQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+func (h *HDRHistogram) Percentiles() LatencyPercentiles {
+	q := h.Quantiles(latencyQuantiles...)
+	ms := func(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1e6 }
+	return LatencyPercentiles{P50: ms(q[0]), P90: ms(q[1]), P99: ms(q[2]), P999: ms(q[3])}
+}
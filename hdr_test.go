@@ -0,0 +1,75 @@
+package apexlob
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestHDRHistogramIndexRoundTrip(t *testing.T) {
+	h := NewHDRHistogram(time.Hour)
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, 257, 1000, 123456, 987654321, int64(time.Hour)} {
+		i := hdrIndex(v)
+		if i >= len(h.counts) {
+			t.Fatalf("index %d of %d out of range (%d slots)", i, v, len(h.counts))
+		}
+		hi := hdrValue(i)
+		if hi < v || float64(hi-v) > float64(v)/128+1 {
+			t.Errorf("value %d maps to bucket ending at %d", v, hi)
+		}
+	}
+}
+
+func TestHDRHistogramQuantiles(t *testing.T) {
+	h := NewHDRHistogram(time.Minute)
+	if q := h.Quantiles(0.5); q[0] != 0 {
+		t.Errorf("empty histogram p50 = %v", q[0])
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	values := make([]time.Duration, 100000)
+	for i := range values {
+		// Long-tailed: mostly microseconds, some milliseconds
+		values[i] = time.Duration(rng.ExpFloat64() * float64(50*time.Microsecond))
+		h.Record(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	got := h.Quantiles(latencyQuantiles...)
+	for i, q := range latencyQuantiles {
+		want := values[int(q*float64(len(values)))-1]
+		if diff := float64(got[i]-want) / float64(want); diff < -0.01 || diff > 0.01 {
+			t.Errorf("q%.3f = %v, want %v", q, got[i], want)
+		}
+	}
+
+	// Values past the maximum are clamped, not dropped
+	h.Record(time.Hour)
+	if q := h.Quantiles(1); q[0] != time.Minute {
+		t.Errorf("max = %v, want %v", q[0], time.Minute)
+	}
+}
+
+func TestHDRHistogramMerge(t *testing.T) {
+	a, b := NewHDRHistogram(time.Second), NewHDRHistogram(time.Second)
+	for i := 0; i < 90; i++ {
+		a.Record(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		b.Record(100 * time.Millisecond)
+	}
+	a.Merge(b)
+	p := a.Percentiles()
+	if a.Count() != 100 || p.P50 < 0.99 || p.P50 > 1.01 || p.P99 < 99 || p.P99 > 101 {
+		t.Errorf("merged: count %d, %+v", a.Count(), p)
+	}
+}
+
+func BenchmarkHDRHistogramRecord(b *testing.B) {
+	h := NewHDRHistogram(time.Minute)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Record(time.Duration(i&0xffff) * time.Microsecond)
+	}
+}
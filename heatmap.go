@@ -0,0 +1,555 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/orderbook"
+
+	"github.com/spf13/cobra"
+)
+
+// A heatmap file holds one symbol's sampled depth, for rendering liquidity
+// heatmaps. It starts with heatmapMagic and the symbol (uvarint length and
+// bytes), followed by frames, each a uvarint payload length and a payload
+// of columns:
+//
+//	time (uvarint Unix ms) | bid count (uvarint) | ask count (uvarint) |
+//	prices (zigzag varint deltas) | volumes (uvarint)
+//
+// Prices are in units of 1e-8, bids best first and then asks best first,
+// each a delta from the one before, so a frame costs a few bytes a level.
+const heatmapMagic = "APEXHMP1"
+
+// heatmapPriceScale is the price unit of a frame, Binance's finest tick.
+const heatmapPriceScale = 1e8
+
+var errHeatmapTruncated = errors.New("heatmap ends in a partial frame")
+
+// HeatmapFrame is one depth sample.
+type HeatmapFrame struct {
+	Time time.Time
+	Bids []orderbook.PriceLevel
+	Asks []orderbook.PriceLevel
+}
+
+func appendHeatmapFrame(dst []byte, f *HeatmapFrame) []byte {
+	body := binary.AppendUvarint(nil, uint64(f.Time.UnixMilli()))
+	body = binary.AppendUvarint(body, uint64(len(f.Bids)))
+	body = binary.AppendUvarint(body, uint64(len(f.Asks)))
+	var prev int64
+	for _, side := range [][]orderbook.PriceLevel{f.Bids, f.Asks} {
+		for _, lvl := range side {
+			p := int64(math.Round(lvl.Price * heatmapPriceScale))
+			body = binary.AppendVarint(body, p-prev)
+			prev = p
+		}
+	}
+	for _, side := range [][]orderbook.PriceLevel{f.Bids, f.Asks} {
+		for _, lvl := range side {
+			body = binary.AppendUvarint(body, uint64(lvl.Volume))
+		}
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(body)))
+	return append(dst, body...)
+}
+
+func decodeHeatmapFrame(b []byte) (HeatmapFrame, error) {
+	var f HeatmapFrame
+	r := bytes.NewReader(b)
+	ms, err := binary.ReadUvarint(r)
+	if err != nil {
+		return f, err
+	}
+	nb, err := binary.ReadUvarint(r)
+	if err != nil {
+		return f, err
+	}
+	na, err := binary.ReadUvarint(r)
+	if err != nil {
+		return f, err
+	}
+	// Every level takes at least two bytes
+	if nb+na > uint64(len(b)) {
+		return f, errors.New("heatmap frame level count exceeds its size")
+	}
+	f.Time = time.UnixMilli(int64(ms)).UTC()
+	levels := make([]orderbook.PriceLevel, nb+na)
+	var prev int64
+	for i := range levels {
+		d, err := binary.ReadVarint(r)
+		if err != nil {
+			return f, err
+		}
+		prev += d
+		levels[i].Price = float64(prev) / heatmapPriceScale
+	}
+	for i := range levels {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return f, err
+		}
+		levels[i].Volume = uint32(v)
+	}
+	f.Bids, f.Asks = levels[:nb:nb], levels[nb:]
+	return f, nil
+}
+
+// HeatmapRecorder is a sink that samples every symbol's depth at most once
+// per interval of event time, on book events, and appends the samples to
+// <dir>/<symbol>.heatmap.
+type HeatmapRecorder struct {
+	dir      string
+	interval time.Duration
+	depth    int
+	symbols  *SymbolRegistry
+	files    map[string]*heatmapFile
+	last     map[string]time.Time
+	buf      []byte
+}
+
+type heatmapFile struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func NewHeatmapRecorder(dir string, interval time.Duration, depth int, symbols *SymbolRegistry) (*HeatmapRecorder, error) {
+	if depth <= 0 {
+		return nil, fmt.Errorf("invalid heatmap depth %d", depth)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &HeatmapRecorder{
+		dir:      dir,
+		interval: interval,
+		depth:    depth,
+		symbols:  symbols,
+		files:    make(map[string]*heatmapFile),
+		last:     make(map[string]time.Time),
+	}, nil
+}
+
+func (h *HeatmapRecorder) Name() string { return "heatmap" }
+
+func (h *HeatmapRecorder) Write(e *Event) error {
+	if e.Type != EventBook {
+		return nil
+	}
+	if last, ok := h.last[e.Symbol]; ok && e.Timestamp.Sub(last) < h.interval {
+		return nil
+	}
+	state, ok := h.symbols.Get(e.Symbol)
+	if !ok {
+		return nil
+	}
+	h.last[e.Symbol] = e.Timestamp
+	hf, err := h.file(e.Symbol)
+	if err != nil {
+		return err
+	}
+	// The event carries the top 20 levels; heatmaps want more
+	bids, asks := state.Book.Depth(h.depth)
+	h.buf = appendHeatmapFrame(h.buf[:0], &HeatmapFrame{Time: e.Timestamp, Bids: bids, Asks: asks})
+	_, err = hf.w.Write(h.buf)
+	return err
+}
+
+// file opens the symbol's heatmap for appending, writing the header to a
+// new file and checking it in an existing one.
+func (h *HeatmapRecorder) file(symbol string) (*heatmapFile, error) {
+	if hf := h.files[symbol]; hf != nil {
+		return hf, nil
+	}
+	path := filepath.Join(h.dir, symbol+".heatmap")
+	if existing, err := os.Open(path); err == nil {
+		got, err := readHeatmapHeader(bufio.NewReader(existing))
+		existing.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if err == nil && got != symbol {
+			return nil, fmt.Errorf("%s holds %s, not %s", path, got, symbol)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	hf := &heatmapFile{f: f, w: bufio.NewWriter(f)}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		hf.w.WriteString(heatmapMagic)
+		hf.w.Write(binary.AppendUvarint(nil, uint64(len(symbol))))
+		hf.w.WriteString(symbol)
+	}
+	h.files[symbol] = hf
+	return hf, nil
+}
+
+func (h *HeatmapRecorder) Flush() error {
+	var first error
+	for _, hf := range h.files {
+		if err := hf.w.Flush(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (h *HeatmapRecorder) Close() error {
+	first := h.Flush()
+	for _, hf := range h.files {
+		if err := hf.f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func readHeatmapHeader(r *bufio.Reader) (string, error) {
+	magic := make([]byte, len(heatmapMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", err
+	}
+	if string(magic) != heatmapMagic {
+		return "", errors.New("not a heatmap file")
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > 64 {
+		return "", errors.New("bad heatmap header")
+	}
+	symbol := make([]byte, n)
+	if _, err := io.ReadFull(r, symbol); err != nil {
+		return "", errors.New("bad heatmap header")
+	}
+	return string(symbol), nil
+}
+
+// HeatmapReader reads the frames of a heatmap file in order.
+type HeatmapReader struct {
+	Symbol string
+	r      *bufio.Reader
+	buf    []byte
+}
+
+func NewHeatmapReader(r io.Reader) (*HeatmapReader, error) {
+	br := bufio.NewReader(r)
+	symbol, err := readHeatmapHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return &HeatmapReader{Symbol: symbol, r: br}, nil
+}
+
+// Next returns the next frame, io.EOF at the end of the file, or
+// errHeatmapTruncated if it ends part-way through a frame, as after a crash.
+func (hr *HeatmapReader) Next() (HeatmapFrame, error) {
+	n, err := binary.ReadUvarint(hr.r)
+	if err == io.EOF {
+		return HeatmapFrame{}, io.EOF
+	}
+	if err != nil || n > maxWALRecord {
+		return HeatmapFrame{}, errHeatmapTruncated
+	}
+	if cap(hr.buf) < int(n) {
+		hr.buf = make([]byte, n)
+	}
+	hr.buf = hr.buf[:n]
+	if _, err := io.ReadFull(hr.r, hr.buf); err != nil {
+		return HeatmapFrame{}, errHeatmapTruncated
+	}
+	return decodeHeatmapFrame(hr.buf)
+}
+
+// HeatmapMatrix is resting volume gridded by time and price: Cells[row][col]
+// is the mean volume in price bucket row, from the top of the range down,
+// over the frames in time bucket col.
+type HeatmapMatrix struct {
+	Symbol   string
+	From, To time.Time
+	Low      float64 // price range covered
+	High     float64
+	Times    []time.Time // start of each column
+	Prices   []float64   // lower edge of each row, highest first
+	Cells    [][]float64
+}
+
+// HeatmapOptions choose the window and resolution of a matrix. Zero From or
+// To leave that end open; a zero Low or High takes the range from the
+// levels in the window.
+type HeatmapOptions struct {
+	From, To  time.Time
+	Rows      int
+	Cols      int
+	Low, High float64
+}
+
+// BuildHeatmap reads a heatmap file into a matrix.
+func BuildHeatmap(path string, opts HeatmapOptions) (HeatmapMatrix, error) {
+	if opts.Rows <= 0 || opts.Cols <= 0 {
+		return HeatmapMatrix{}, fmt.Errorf("invalid heatmap size %dx%d", opts.Cols, opts.Rows)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return HeatmapMatrix{}, err
+	}
+	defer f.Close()
+	hr, err := NewHeatmapReader(f)
+	if err != nil {
+		return HeatmapMatrix{}, fmt.Errorf("%s: %w", path, err)
+	}
+	var frames []HeatmapFrame
+	for {
+		frame, err := hr.Next()
+		if err == io.EOF || errors.Is(err, errHeatmapTruncated) {
+			break
+		}
+		if err != nil {
+			return HeatmapMatrix{}, fmt.Errorf("%s: %w", path, err)
+		}
+		if (!opts.From.IsZero() && frame.Time.Before(opts.From)) || (!opts.To.IsZero() && !frame.Time.Before(opts.To)) {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) == 0 {
+		return HeatmapMatrix{}, fmt.Errorf("%s: no depth samples in the time range", path)
+	}
+	return gridHeatmap(hr.Symbol, frames, opts), nil
+}
+
+func gridHeatmap(symbol string, frames []HeatmapFrame, opts HeatmapOptions) HeatmapMatrix {
+	m := HeatmapMatrix{Symbol: symbol, From: frames[0].Time, To: frames[len(frames)-1].Time, Low: opts.Low, High: opts.High}
+	if m.Low == 0 || m.High == 0 {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, f := range frames {
+			for _, side := range [][]orderbook.PriceLevel{f.Bids, f.Asks} {
+				for _, lvl := range side {
+					low, high = math.Min(low, lvl.Price), math.Max(high, lvl.Price)
+				}
+			}
+		}
+		if math.IsInf(low, 1) {
+			low, high = 0, 1
+		}
+		if m.Low == 0 {
+			m.Low = low
+		}
+		if m.High == 0 {
+			m.High = high
+		}
+	}
+	if m.High <= m.Low {
+		m.High = m.Low + 1/heatmapPriceScale
+	}
+	// The last frame falls in the last column
+	span := m.To.Sub(m.From) + time.Millisecond
+	step := (m.High - m.Low) / float64(opts.Rows)
+	m.Prices = make([]float64, opts.Rows)
+	for r := range m.Prices {
+		m.Prices[r] = m.High - float64(r+1)*step
+	}
+	m.Times = make([]time.Time, opts.Cols)
+	for c := range m.Times {
+		m.Times[c] = m.From.Add(span * time.Duration(c) / time.Duration(opts.Cols))
+	}
+	m.Cells = make([][]float64, opts.Rows)
+	for r := range m.Cells {
+		m.Cells[r] = make([]float64, opts.Cols)
+	}
+	samples := make([]int, opts.Cols)
+	for _, f := range frames {
+		col := int(int64(f.Time.Sub(m.From)) * int64(opts.Cols) / int64(span))
+		samples[col]++
+		for _, side := range [][]orderbook.PriceLevel{f.Bids, f.Asks} {
+			for _, lvl := range side {
+				if lvl.Price < m.Low || lvl.Price > m.High {
+					continue
+				}
+				row := int((m.High - lvl.Price) / step)
+				if row >= opts.Rows {
+					row = opts.Rows - 1
+				}
+				m.Cells[row][col] += float64(lvl.Volume)
+			}
+		}
+	}
+	for c, n := range samples {
+		for r := range m.Cells {
+			if n > 0 {
+				m.Cells[r][c] /= float64(n)
+			}
+		}
+	}
+	return m
+}
+
+func (m HeatmapMatrix) max() float64 {
+	var top float64
+	for _, row := range m.Cells {
+		for _, v := range row {
+			top = math.Max(top, v)
+		}
+	}
+	return top
+}
+
+// heat maps a volume to a colour on a log scale from black through blue and
+// red to yellow, so thin levels still show next to walls.
+func heat(v, max float64) color.RGBA {
+	if v <= 0 || max <= 0 {
+		return color.RGBA{A: 255}
+	}
+	x := math.Log1p(v) / math.Log1p(max)
+	stops := []color.RGBA{{0, 0, 0, 255}, {20, 40, 200, 255}, {220, 30, 30, 255}, {255, 240, 60, 255}}
+	pos := x * float64(len(stops)-1)
+	i := int(pos)
+	if i >= len(stops)-1 {
+		return stops[len(stops)-1]
+	}
+	t := pos - float64(i)
+	lerp := func(a, b uint8) uint8 { return uint8(float64(a) + t*(float64(b)-float64(a))) }
+	a, b := stops[i], stops[i+1]
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), 255}
+}
+
+// WritePNG draws the matrix one pixel per cell, time left to right and
+// price top to bottom.
+func (m HeatmapMatrix) WritePNG(w io.Writer) error {
+	rows, cols := len(m.Cells), len(m.Times)
+	img := image.NewRGBA(image.Rect(0, 0, cols, rows))
+	top := m.max()
+	for r, row := range m.Cells {
+		for c, v := range row {
+			img.SetRGBA(c, r, heat(v, top))
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// WriteSVG draws the matrix as a rectangle per non-empty cell on a black
+// background, with the price range and time window in the title.
+func (m HeatmapMatrix) WriteSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	rows, cols := len(m.Cells), len(m.Times)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n", cols*4, rows*4, cols, rows)
+	fmt.Fprintf(bw, "<title>%s %s to %s, %g to %g</title>\n", m.Symbol, m.From.Format(time.RFC3339), m.To.Format(time.RFC3339), m.Low, m.High)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#000"/>`+"\n", cols, rows)
+	top := m.max()
+	for r, row := range m.Cells {
+		for c, v := range row {
+			if v <= 0 {
+				continue
+			}
+			col := heat(v, top)
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="1" height="1" fill="#%02x%02x%02x"/>`+"\n", c, r, col.R, col.G, col.B)
+		}
+	}
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+// WriteCSV writes the matrix with a header row of column start times and a
+// row per price bucket, labelled with its lower edge.
+func (m HeatmapMatrix) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(m.Times)+1)
+	header[0] = "price"
+	for c, t := range m.Times {
+		header[c+1] = t.UTC().Format(time.RFC3339Nano)
+	}
+	cw.Write(header)
+	record := make([]string, len(header))
+	for r, row := range m.Cells {
+		record[0] = strconv.FormatFloat(m.Prices[r], 'f', -1, 64)
+		for c, v := range row {
+			record[c+1] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteFile writes the matrix as PNG, SVG or CSV by path's extension.
+func (m HeatmapMatrix) WriteFile(path string) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		write = m.WritePNG
+	case ".svg":
+		write = m.WriteSVG
+	case ".csv":
+		write = m.WriteCSV
+	default:
+		return fmt.Errorf("unknown heatmap format %q (want .png, .svg or .csv)", filepath.Ext(path))
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func newHeatmapCommand() *cobra.Command {
+	var input, output, from, to string
+	var opts HeatmapOptions
+	cmd := &cobra.Command{
+		Use:   "heatmap",
+		Short: "Render depth recorded with --heatmap-dir as a PNG, SVG or CSV matrix",
+		Long: "Grids the depth samples of a heatmap file into time columns and price rows, each cell\n" +
+			"the mean volume resting there, and writes it as an image or a CSV matrix chosen by the\n" +
+			"extension of --output.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if from != "" {
+				if opts.From, err = time.Parse(time.RFC3339, from); err != nil {
+					return fmt.Errorf("invalid --from: %w", err)
+				}
+			}
+			if to != "" {
+				if opts.To, err = time.Parse(time.RFC3339, to); err != nil {
+					return fmt.Errorf("invalid --to: %w", err)
+				}
+			}
+			m, err := BuildHeatmap(input, opts)
+			if err != nil {
+				return err
+			}
+			if err := m.WriteFile(output); err != nil {
+				return err
+			}
+			logger("main").Info("wrote heatmap", "path", output, "symbol", m.Symbol, "from", m.From, "to", m.To, "low", m.Low, "high", m.High)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&input, "input", "", "heatmap file written by live, serve or replay with --heatmap-dir")
+	cmd.Flags().StringVarP(&output, "output", "o", "heatmap.png", "output file: .png, .svg or .csv")
+	cmd.Flags().StringVar(&from, "from", "", "start of the time range, RFC 3339 (default: the first sample)")
+	cmd.Flags().StringVar(&to, "to", "", "end of the time range, RFC 3339, exclusive (default: the last sample)")
+	cmd.Flags().IntVar(&opts.Cols, "cols", 600, "time columns")
+	cmd.Flags().IntVar(&opts.Rows, "rows", 300, "price rows")
+	cmd.Flags().Float64Var(&opts.Low, "price-low", 0, "bottom of the price range (default: the lowest level recorded)")
+	cmd.Flags().Float64Var(&opts.High, "price-high", 0, "top of the price range (default: the highest level recorded)")
+	cmd.MarkFlagRequired("input")
+	return cmd
+}
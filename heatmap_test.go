@@ -0,0 +1,122 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/csv"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestHeatmapFrameRoundTrip(t *testing.T) {
+	frame := HeatmapFrame{
+		Time: time.UnixMilli(1700000000123).UTC(),
+		Bids: []orderbook.PriceLevel{{Price: 43250.12, Volume: 5}, {Price: 43250.01, Volume: 700}},
+		Asks: []orderbook.PriceLevel{{Price: 43250.13, Volume: 1}, {Price: 0.00000001, Volume: 2}},
+	}
+	b := appendHeatmapFrame(nil, &frame)
+	if len(b) > 60 {
+		t.Errorf("frame of 4 levels took %d bytes", len(b))
+	}
+	got, err := decodeHeatmapFrame(b[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Time.Equal(frame.Time) || len(got.Bids) != 2 || len(got.Asks) != 2 {
+		t.Fatalf("decoded %+v", got)
+	}
+	for i, lvl := range append(got.Bids, got.Asks...) {
+		want := append(frame.Bids, frame.Asks...)[i]
+		if lvl.Price != want.Price || lvl.Volume != want.Volume {
+			t.Errorf("level %d = %+v, want %+v", i, lvl, want)
+		}
+	}
+}
+
+func TestHeatmapRecordAndRender(t *testing.T) {
+	dir := t.TempDir()
+	symbols := NewSymbolRegistry()
+	state := NewSymbolState("btcusdt")
+	symbols.Add(state)
+	state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 100, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 101, Quantity: 10, Side: orderbook.Sell})
+
+	rec, err := NewHeatmapRecorder(dir, time.Second, 50, symbols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	// Ten seconds of book events every 100ms, sampled once a second
+	for i := 0; i < 100; i++ {
+		if i == 50 {
+			state.Book.SubmitOrder(&orderbook.Order{ID: 3, Price: 100, Quantity: 50, Side: orderbook.Buy})
+		}
+		rec.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: base.Add(time.Duration(i) * 100 * time.Millisecond)})
+	}
+	rec.Close()
+
+	path := filepath.Join(dir, "btcusdt.heatmap")
+	f, _ := os.Open(path)
+	hr, err := NewHeatmapReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frames int
+	for ; ; frames++ {
+		if _, err := hr.Next(); err != nil {
+			break
+		}
+	}
+	f.Close()
+	if hr.Symbol != "btcusdt" || frames != 10 {
+		t.Fatalf("read %d frames of %s, want 10 of btcusdt", frames, hr.Symbol)
+	}
+
+	// A crash leaves a partial frame, which is skipped
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, data[:len(data)-3], 0o644)
+	m, err := BuildHeatmap(path, HeatmapOptions{Rows: 3, Cols: 2, From: base.Add(2 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Low != 99 || m.High != 101 || !m.From.Equal(base.Add(2*time.Second)) {
+		t.Errorf("matrix covers %v to %v from %v", m.Low, m.High, m.From)
+	}
+	// Rows are 100.33-101, 99.67-100.33 and 99-99.67. The first column
+	// averages 2s to 5s, and the bid at 100 rested only from 5s
+	want := [][]float64{{10, 10}, {12.5, 50}, {100, 100}}
+	for r := range want {
+		for c := range want[r] {
+			if m.Cells[r][c] != want[r][c] {
+				t.Errorf("cell %d,%d = %v, want %v", r, c, m.Cells[r][c], want[r][c])
+			}
+		}
+	}
+
+	for _, name := range []string{"h.png", "h.svg", "h.csv"} {
+		if err := m.WriteFile(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	img, _ := os.ReadFile(filepath.Join(dir, "h.png"))
+	if cfg, err := png.DecodeConfig(bytes.NewReader(img)); err != nil || cfg.Width != 2 || cfg.Height != 3 {
+		t.Errorf("PNG is %dx%d (%v), want 2x3", cfg.Width, cfg.Height, err)
+	}
+	svg, _ := os.ReadFile(filepath.Join(dir, "h.svg"))
+	if !strings.HasPrefix(string(svg), "<svg") || strings.Count(string(svg), `width="1"`) != 6 {
+		t.Errorf("SVG = %s", svg)
+	}
+	table, _ := os.ReadFile(filepath.Join(dir, "h.csv"))
+	rows, err := csv.NewReader(bytes.NewReader(table)).ReadAll()
+	if err != nil || len(rows) != 4 || rows[3][0] != "99" || rows[3][1] != "100" {
+		t.Errorf("CSV = %q (%v)", rows, err)
+	}
+	if err := m.WriteFile(filepath.Join(dir, "h.gif")); err == nil {
+		t.Error("unknown format written")
+	}
+}
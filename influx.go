@@ -0,0 +1,188 @@
+package apexlob
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type InfluxConfig struct {
+	URL            string // e.g. http://localhost:8086
+	Org            string
+	Bucket         string
+	Token          string
+	BatchSize      int           // points buffered before an early write
+	SignalInterval time.Duration // minimum spacing of signal points per symbol
+}
+
+// InfluxSink writes trades, closed candles and signal values as line protocol
+// to the InfluxDB v2 write API. Batches that fail are dropped and counted.
+type InfluxSink struct {
+	cfg         InfluxConfig
+	endpoint    string
+	client      *http.Client
+	buf         bytes.Buffer
+	points      int
+	lastSignals map[string]time.Time
+	written     *Counter
+	errors      *Counter
+}
+
+func NewInfluxSink(cfg InfluxConfig, reg *MetricsRegistry) (*InfluxSink, error) {
+	if cfg.URL == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("influx: url and bucket are required")
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	q := url.Values{"bucket": {cfg.Bucket}, "precision": {"ns"}}
+	if cfg.Org != "" {
+		q.Set("org", cfg.Org)
+	}
+	return &InfluxSink{
+		cfg:         cfg,
+		endpoint:    strings.TrimRight(cfg.URL, "/") + "/api/v2/write?" + q.Encode(),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		lastSignals: make(map[string]time.Time),
+		written:     reg.Counter("apexlob_influx_points_total", "Points accepted by InfluxDB.", nil),
+		errors:      reg.Counter("apexlob_influx_errors_total", "Points dropped after a failed InfluxDB write.", nil),
+	}, nil
+}
+
+func (s *InfluxSink) Name() string { return "influx" }
+
+func (s *InfluxSink) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		s.point("trades", []string{"symbol", tr.Symbol, "side", tr.Side.String()}, tr.Timestamp,
+			"price", formatInfluxFloat(tr.Price),
+			"quantity", formatInfluxFloat(tr.Quantity),
+			"id", strconv.FormatUint(tr.ID, 10)+"u")
+	case EventCandle:
+		c := e.Candle
+		s.point("candles", []string{"symbol", c.Symbol, "interval", c.Interval.String()}, c.OpenTime,
+			"open", formatInfluxFloat(c.Open),
+			"high", formatInfluxFloat(c.High),
+			"low", formatInfluxFloat(c.Low),
+			"close", formatInfluxFloat(c.Close),
+			"volume", formatInfluxFloat(c.Volume),
+			"trades", strconv.Itoa(c.Trades)+"i")
+	case EventSignal:
+		if last, ok := s.lastSignals[e.Symbol]; ok && e.Timestamp.Sub(last) < s.cfg.SignalInterval {
+			return nil
+		}
+		s.lastSignals[e.Symbol] = e.Timestamp
+		var fields []string
+		for _, name := range sortedKeys(e.Signals) {
+			// Line protocol has no representation for NaN or Inf
+			if v := e.Signals[name]; !math.IsNaN(v) && !math.IsInf(v, 0) {
+				fields = append(fields, name, formatInfluxFloat(v))
+			}
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		s.point("signals", []string{"symbol", e.Symbol}, e.Timestamp, fields...)
+	default:
+		return nil
+	}
+	if s.points >= s.cfg.BatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+// point appends one line: measurement,tags fields timestamp. Tags and fields
+// are alternating key/value pairs; field values are already formatted.
+func (s *InfluxSink) point(measurement string, tags []string, ts time.Time, fields ...string) {
+	s.buf.WriteString(influxEscape(measurement, ", "))
+	for i := 0; i+1 < len(tags); i += 2 {
+		s.buf.WriteByte(',')
+		s.buf.WriteString(influxEscape(tags[i], ",= "))
+		s.buf.WriteByte('=')
+		s.buf.WriteString(influxEscape(tags[i+1], ",= "))
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if i == 0 {
+			s.buf.WriteByte(' ')
+		} else {
+			s.buf.WriteByte(',')
+		}
+		s.buf.WriteString(influxEscape(fields[i], ",= "))
+		s.buf.WriteByte('=')
+		s.buf.WriteString(fields[i+1])
+	}
+	s.buf.WriteByte(' ')
+	s.buf.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+	s.buf.WriteByte('\n')
+	s.points++
+}
+
+// influxEscape backslash-escapes the special characters in a name. Line
+// protocol cannot carry a line break in a name, or a backslash at its end,
+// which would escape the separator after it, so those are dropped rather
+// than corrupt the whole batch.
+func influxEscape(s, special string) string {
+	if strings.ContainsAny(s, "\r\n") {
+		s = strings.NewReplacer("\r", "", "\n", "").Replace(s)
+	}
+	s = strings.TrimRight(s, "\\")
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func formatInfluxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (s *InfluxSink) Flush() error {
+	if s.points == 0 {
+		return nil
+	}
+	body := append([]byte(nil), s.buf.Bytes()...)
+	points := s.points
+	s.buf.Reset()
+	s.points = 0
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.errors.Add(uint64(points))
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		s.errors.Add(uint64(points))
+		return fmt.Errorf("influx: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	s.written.Add(uint64(points))
+	return nil
+}
+
+func (s *InfluxSink) Close() error {
+	return s.Flush()
+}
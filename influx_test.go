@@ -0,0 +1,133 @@
+package apexlob
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	protocol "github.com/influxdata/line-protocol"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestInfluxSinkLineProtocol(t *testing.T) {
+	var body, auth, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, auth, query = string(b), r.Header.Get("Authorization"), r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, err := NewInfluxSink(InfluxConfig{URL: srv.URL, Org: "desk", Bucket: "ticks", Token: "tok", SignalInterval: time.Second}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 5)
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts, Trade: &orderbook.Trade{Symbol: "btcusdt", ID: 9, Price: 42000.5, Quantity: 0.1, Side: orderbook.Sell, Timestamp: ts}})
+	sink.Write(&Event{Type: EventCandle, Symbol: "btcusdt", Timestamp: ts, Candle: &Candle{Symbol: "btcusdt", OpenTime: ts, Interval: time.Minute, Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 3, Trades: 4}})
+	sink.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: ts, Signals: map[string]float64{"vwap": 42000, "rsi_14": math.NaN()}})
+	sink.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: ts.Add(time.Millisecond), Signals: map[string]float64{"vwap": 1}}) // sampled out
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"trades,symbol=btcusdt,side=SELL price=42000.5,quantity=0.1,id=9u 1700000000000000005",
+		"candles,symbol=btcusdt,interval=1m0s open=1,high=2,low=0.5,close=1.5,volume=3,trades=4i 1700000000000000005",
+		"signals,symbol=btcusdt vwap=42000 1700000000000000005",
+	}
+	if got := strings.Split(strings.TrimSpace(body), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("body =\n%s\nwant\n%s", body, strings.Join(want, "\n"))
+	}
+	if auth != "Token tok" || !strings.Contains(query, "bucket=ticks") || !strings.Contains(query, "org=desk") {
+		t.Errorf("auth = %q, query = %q", auth, query)
+	}
+	if sink.written.Value() != 3 {
+		t.Errorf("written = %d, want 3", sink.written.Value())
+	}
+}
+
+// TestInfluxSinkParsesAsLineProtocol feeds the sink's batches to
+// InfluxData's line protocol parser, with names that need escaping.
+func TestInfluxSinkParsesAsLineProtocol(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, _ := NewInfluxSink(InfluxConfig{URL: srv.URL, Bucket: "ticks"}, NewMetricsRegistry())
+	ts := time.Unix(1700000000, 5)
+	symbol := "odd sym,bol=x\\\n" // the trailing backslash and newline are dropped
+	sink.Write(&Event{Type: EventTrade, Symbol: symbol, Timestamp: ts, Trade: &orderbook.Trade{Symbol: symbol, ID: math.MaxUint64, Price: 1e-9, Quantity: 1e21, Side: orderbook.Buy, Timestamp: ts}})
+	sink.Write(&Event{Type: EventSignal, Symbol: symbol, Timestamp: ts, Signals: map[string]float64{"a b,c=d": -0.25, `back\slash`: 3}})
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics, err := protocol.NewParser(protocol.NewMetricHandler()).Parse(body)
+	if err != nil {
+		t.Fatalf("line protocol parser rejected the batch: %v\n%s", err, body)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("parsed %d points, want 2:\n%s", len(metrics), body)
+	}
+	for _, m := range metrics {
+		if !m.Time().Equal(ts) {
+			t.Errorf("%s time = %v, want %v", m.Name(), m.Time(), ts)
+		}
+		tags := map[string]string{}
+		for _, tag := range m.TagList() {
+			tags[tag.Key] = tag.Value
+		}
+		if tags["symbol"] != "odd sym,bol=x" {
+			t.Errorf("%s tags = %v, want symbol %q", m.Name(), tags, "odd sym,bol=x")
+		}
+	}
+	fields := func(m protocol.Metric) map[string]interface{} {
+		out := map[string]interface{}{}
+		for _, f := range m.FieldList() {
+			out[f.Key] = f.Value
+		}
+		return out
+	}
+	trade := fields(metrics[0])
+	if metrics[0].Name() != "trades" || trade["price"] != 1e-9 || trade["quantity"] != 1e21 || trade["id"] != uint64(math.MaxUint64) {
+		t.Errorf("trade point %s %v", metrics[0].Name(), trade)
+	}
+	signals := fields(metrics[1])
+	if metrics[1].Name() != "signals" || signals["a b,c=d"] != -0.25 || signals[`back\slash`] != 3.0 {
+		t.Errorf("signal point %s %v", metrics[1].Name(), signals)
+	}
+}
+
+func TestInfluxSinkCountsRejectedBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	sink, _ := NewInfluxSink(InfluxConfig{URL: srv.URL, Bucket: "ticks"}, NewMetricsRegistry())
+	sink.Write(&Event{Type: EventTrade, Trade: &orderbook.Trade{Symbol: "btcusdt"}})
+	if err := sink.Close(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("error = %v, want HTTP 401", err)
+	}
+	if sink.errors.Value() != 1 {
+		t.Errorf("errors = %d, want 1", sink.errors.Value())
+	}
+}
+
+func TestInfluxEscape(t *testing.T) {
+	if got := influxEscape("a b,c=d", ",= "); got != `a\ b\,c\=d` {
+		t.Errorf("influxEscape = %q", got)
+	}
+	if got := influxEscape("two\nlines\\", ",= "); got != "twolines" {
+		t.Errorf("influxEscape = %q, want the line break and trailing backslash dropped", got)
+	}
+}
@@ -0,0 +1,558 @@
+package apexlob
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A minimal Kafka producer speaking Metadata v1 and Produce v3 with v2 record
+// batches (Kafka 0.11+). It has no compression, idempotence or transactions;
+// the sink is best effort and counts what it fails to deliver.
+
+const (
+	kafkaAPIProduce  int16 = 0
+	kafkaAPIMetadata int16 = 3
+)
+
+type KafkaConfig struct {
+	Brokers   []string
+	ClientID  string
+	Topics    map[EventType]string // event types without a topic are not published
+	BatchSize int                  // records buffered before an early flush
+	Acks      int16                // 0, 1 or -1 (all in-sync replicas)
+	Timeout   time.Duration
+}
+
+type kafkaRecord struct {
+	key   []byte
+	value []byte
+	ts    time.Time
+}
+
+// KafkaSink publishes trades, book deltas and signal snapshots as JSON with
+// the symbol as record key, so each symbol stays ordered within a partition.
+type KafkaSink struct {
+	cfg      KafkaConfig
+	client   *kafkaClient
+	pending  map[string][]kafkaRecord
+	count    int
+	lastBook map[string]*BookSnapshot
+	produced *Counter
+	errors   *Counter
+}
+
+func NewKafkaSink(cfg KafkaConfig, reg *MetricsRegistry) (*KafkaSink, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka: no brokers configured")
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "apexlob"
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &KafkaSink{
+		cfg:      cfg,
+		client:   newKafkaClient(cfg.Brokers, cfg.ClientID, cfg.Timeout),
+		pending:  make(map[string][]kafkaRecord),
+		lastBook: make(map[string]*BookSnapshot),
+		produced: reg.Counter("apexlob_kafka_produced_total", "Records acknowledged by Kafka.", nil),
+		errors:   reg.Counter("apexlob_kafka_errors_total", "Records Kafka failed to accept.", nil),
+	}, nil
+}
+
+func (s *KafkaSink) Name() string { return "kafka" }
+
+func (s *KafkaSink) Write(e *Event) error {
+	topic := s.cfg.Topics[e.Type]
+	if topic == "" {
+		return nil
+	}
+
+	var payload interface{}
+	switch e.Type {
+	case EventTrade:
+		payload = versionedTrade{EventSchemaVersion, e.Trade}
+	case EventBook:
+		changes := DiffBook(s.lastBook[e.Symbol], e.Book)
+		s.lastBook[e.Symbol] = e.Book
+		if len(changes) == 0 {
+			return nil
+		}
+		payload = struct {
+			SchemaVersion int           `json:"schema_version"`
+			Symbol        string        `json:"symbol"`
+			Timestamp     time.Time     `json:"timestamp"`
+			Changes       []LevelChange `json:"changes"`
+		}{EventSchemaVersion, e.Symbol, e.Timestamp, changes}
+	default:
+		payload = e
+	}
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	s.pending[topic] = append(s.pending[topic], kafkaRecord{key: []byte(e.Symbol), value: value, ts: e.Timestamp})
+	s.count++
+	if s.count >= s.cfg.BatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush sends every buffered record, one Produce request per partition leader.
+// Failed records are counted and dropped rather than retried.
+func (s *KafkaSink) Flush() error {
+	if s.count == 0 {
+		return nil
+	}
+	pending, total := s.pending, s.count
+	s.pending = make(map[string][]kafkaRecord)
+	s.count = 0
+
+	topics := make([]string, 0, len(pending))
+	for topic := range pending {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if err := s.client.ensureMetadata(topics); err != nil {
+		s.errors.Add(uint64(total))
+		return err
+	}
+
+	// leader -> topic -> partition -> records
+	requests := make(map[int32]map[string]map[int32][]kafkaRecord)
+	for _, topic := range topics {
+		leaders := s.client.leaders[topic]
+		for _, rec := range pending[topic] {
+			p := kafkaPartition(rec.key, len(leaders))
+			leader := leaders[p]
+			if requests[leader] == nil {
+				requests[leader] = make(map[string]map[int32][]kafkaRecord)
+			}
+			if requests[leader][topic] == nil {
+				requests[leader][topic] = make(map[int32][]kafkaRecord)
+			}
+			requests[leader][topic][p] = append(requests[leader][topic][p], rec)
+		}
+	}
+
+	var firstErr error
+	for leader, byTopic := range requests {
+		delivered, failed, err := s.client.produce(leader, s.cfg.Acks, byTopic)
+		s.produced.Add(uint64(delivered))
+		s.errors.Add(uint64(failed))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *KafkaSink) Close() error {
+	err := s.Flush()
+	s.client.close()
+	return err
+}
+
+// kafkaPartition matches the Java client's default partitioner so records
+// land where other producers would put the same key.
+func kafkaPartition(key []byte, partitions int) int32 {
+	return int32(uint32(murmur2(key)&0x7fffffff) % uint32(partitions))
+}
+
+func murmur2(data []byte) int32 {
+	const m = 0x5bd1e995
+	length := len(data)
+	h := uint32(0x9747b28c) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+type kafkaClient struct {
+	seeds    []string
+	clientID string
+	timeout  time.Duration
+	brokers  map[int32]string
+	conns    map[int32]net.Conn
+	leaders  map[string][]int32 // topic -> leader node per partition
+	stale    bool
+	corr     int32
+}
+
+func newKafkaClient(seeds []string, clientID string, timeout time.Duration) *kafkaClient {
+	return &kafkaClient{
+		seeds:    seeds,
+		clientID: clientID,
+		timeout:  timeout,
+		brokers:  make(map[int32]string),
+		conns:    make(map[int32]net.Conn),
+		leaders:  make(map[string][]int32),
+	}
+}
+
+func (c *kafkaClient) ensureMetadata(topics []string) error {
+	if !c.stale {
+		known := true
+		for _, t := range topics {
+			if len(c.leaders[t]) == 0 {
+				known = false
+				break
+			}
+		}
+		if known {
+			return nil
+		}
+	}
+
+	var enc kafkaEncoder
+	enc.int32(int32(len(topics)))
+	for _, t := range topics {
+		enc.string(t)
+	}
+
+	var lastErr error
+	for _, addr := range c.seeds {
+		conn, err := net.DialTimeout("tcp", addr, c.timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := c.roundTrip(conn, kafkaAPIMetadata, 1, enc.buf, true)
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if err := c.parseMetadata(resp); err != nil {
+			return err
+		}
+		for _, t := range topics {
+			if len(c.leaders[t]) == 0 {
+				return fmt.Errorf("kafka: topic %s has no available partitions", t)
+			}
+		}
+		c.stale = false
+		return nil
+	}
+	return fmt.Errorf("kafka: metadata from %v: %w", c.seeds, lastErr)
+}
+
+func (c *kafkaClient) parseMetadata(b []byte) error {
+	d := kafkaDecoder{b: b}
+	for n := d.int32(); n > 0; n-- {
+		node := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		addr := net.JoinHostPort(host, strconv.Itoa(int(port)))
+		if old, ok := c.brokers[node]; ok && old != addr {
+			c.dropConn(node)
+		}
+		c.brokers[node] = addr
+	}
+	d.int32() // controller
+	for n := d.int32(); n > 0; n-- {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // is_internal
+		var leaders []int32
+		for p := d.int32(); p > 0; p-- {
+			d.int16() // partition error
+			id := d.int32()
+			leader := d.int32()
+			d.skipInt32Array() // replicas
+			d.skipInt32Array() // isr
+			for int(id) >= len(leaders) {
+				leaders = append(leaders, -1)
+			}
+			leaders[id] = leader
+		}
+		if topicErr == 0 {
+			c.leaders[name] = leaders
+		}
+	}
+	return d.err
+}
+
+func (c *kafkaClient) produce(node int32, acks int16, byTopic map[string]map[int32][]kafkaRecord) (delivered, failed int, err error) {
+	var enc kafkaEncoder
+	enc.int16(-1) // transactional id
+	enc.int16(acks)
+	enc.int32(int32(c.timeout / time.Millisecond))
+	enc.int32(int32(len(byTopic)))
+	counts := make(map[string]map[int32]int)
+	for topic, parts := range byTopic {
+		enc.string(topic)
+		enc.int32(int32(len(parts)))
+		counts[topic] = make(map[int32]int)
+		for p, recs := range parts {
+			enc.int32(p)
+			batch := encodeRecordBatch(recs)
+			enc.int32(int32(len(batch)))
+			enc.buf = append(enc.buf, batch...)
+			counts[topic][p] = len(recs)
+			failed += len(recs)
+		}
+	}
+
+	conn, err := c.conn(node)
+	if err != nil {
+		c.stale = true
+		return 0, failed, err
+	}
+	resp, err := c.roundTrip(conn, kafkaAPIProduce, 3, enc.buf, acks != 0)
+	if err != nil {
+		c.dropConn(node)
+		c.stale = true
+		return 0, failed, err
+	}
+	if acks == 0 {
+		return failed, 0, nil
+	}
+
+	failed = 0
+	d := kafkaDecoder{b: resp}
+	for n := d.int32(); n > 0; n-- {
+		topic := d.string()
+		for p := d.int32(); p > 0; p-- {
+			partition := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if code != 0 {
+				failed += counts[topic][partition]
+				c.stale = true
+				if err == nil {
+					err = fmt.Errorf("kafka: produce to %s/%d failed with error code %d", topic, partition, code)
+				}
+			} else {
+				delivered += counts[topic][partition]
+			}
+		}
+	}
+	if d.err != nil {
+		return delivered, failed, d.err
+	}
+	return delivered, failed, err
+}
+
+func (c *kafkaClient) conn(node int32) (net.Conn, error) {
+	if conn, ok := c.conns[node]; ok {
+		return conn, nil
+	}
+	addr, ok := c.brokers[node]
+	if !ok {
+		return nil, fmt.Errorf("kafka: unknown broker %d", node)
+	}
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[node] = conn
+	return conn, nil
+}
+
+func (c *kafkaClient) dropConn(node int32) {
+	if conn, ok := c.conns[node]; ok {
+		conn.Close()
+		delete(c.conns, node)
+	}
+}
+
+func (c *kafkaClient) close() {
+	for node := range c.conns {
+		c.dropConn(node)
+	}
+}
+
+// roundTrip sends one request and, when expected, reads the matching
+// response body (after the correlation id).
+func (c *kafkaClient) roundTrip(conn net.Conn, apiKey, version int16, body []byte, expectResponse bool) ([]byte, error) {
+	c.corr++
+	var enc kafkaEncoder
+	enc.int32(0) // size placeholder
+	enc.int16(apiKey)
+	enc.int16(version)
+	enc.int32(c.corr)
+	enc.string(c.clientID)
+	enc.buf = append(enc.buf, body...)
+	binary.BigEndian.PutUint32(enc.buf, uint32(len(enc.buf)-4))
+
+	conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := conn.Write(enc.buf); err != nil {
+		return nil, err
+	}
+	if !expectResponse {
+		return nil, nil
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 4 || int32(binary.BigEndian.Uint32(resp)) != c.corr {
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+	return resp[4:], nil
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeRecordBatch(recs []kafkaRecord) []byte {
+	first := recs[0].ts.UnixMilli()
+	maxTS := first
+	var records kafkaEncoder
+	for i, r := range recs {
+		ts := r.ts.UnixMilli()
+		if ts > maxTS {
+			maxTS = ts
+		}
+		var rec kafkaEncoder
+		rec.int8(0) // attributes
+		rec.varint(ts - first)
+		rec.varint(int64(i))
+		rec.varint(int64(len(r.key)))
+		rec.buf = append(rec.buf, r.key...)
+		rec.varint(int64(len(r.value)))
+		rec.buf = append(rec.buf, r.value...)
+		rec.varint(0) // headers
+		records.varint(int64(len(rec.buf)))
+		records.buf = append(records.buf, rec.buf...)
+	}
+
+	// Everything from attributes onwards is covered by the CRC
+	var tail kafkaEncoder
+	tail.int16(0) // attributes: no compression, create time
+	tail.int32(int32(len(recs) - 1))
+	tail.int64(first)
+	tail.int64(maxTS)
+	tail.int64(-1) // producer id
+	tail.int16(-1) // producer epoch
+	tail.int32(-1) // base sequence
+	tail.int32(int32(len(recs)))
+	tail.buf = append(tail.buf, records.buf...)
+
+	var b kafkaEncoder
+	b.int64(0) // base offset
+	b.int32(int32(4 + 1 + 4 + len(tail.buf)))
+	b.int32(-1) // partition leader epoch
+	b.int8(2)   // magic
+	b.int32(int32(crc32.Checksum(tail.buf, crc32c)))
+	b.buf = append(b.buf, tail.buf...)
+	return b.buf
+}
+
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+func (e *kafkaEncoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errors.New("kafka: truncated response")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) skipInt32Array() {
+	n := d.int32()
+	if n > 0 {
+		d.take(int(n) * 4)
+	}
+}
@@ -0,0 +1,242 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+
+	"apexlob/pkg/orderbook"
+)
+
+type producedRecord struct {
+	topic     string
+	partition int32
+	key       string
+	value     []byte
+	ts        time.Time
+}
+
+// fakeKafka is a broker built on kafka-go's protocol codec, so requests are
+// decoded, and record batch CRCs checked, by a client library used against
+// real clusters rather than by this package's own decoder. It answers
+// Metadata with two partitions per topic, all led by itself, and records
+// every Produce request.
+type fakeKafka struct {
+	ln       net.Listener
+	mu       sync.Mutex
+	records  []producedRecord
+	versions map[protocol.ApiKey]int16
+	err      error // first request kafka-go could not decode
+}
+
+func newFakeKafka(t *testing.T) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fk := &fakeKafka{ln: ln, versions: make(map[protocol.ApiKey]int16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go fk.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return fk
+}
+
+func (fk *fakeKafka) fail(err error) {
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	if fk.err == nil {
+		fk.err = err
+	}
+}
+
+func (fk *fakeKafka) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		if _, err := r.Peek(1); err != nil {
+			return // the client hung up between requests
+		}
+		version, corr, _, msg, err := protocol.ReadRequest(r)
+		if err != nil {
+			fk.fail(err)
+			return
+		}
+		fk.mu.Lock()
+		fk.versions[msg.ApiKey()] = version
+		fk.mu.Unlock()
+
+		var resp protocol.Message
+		switch req := msg.(type) {
+		case *metadata.Request:
+			host, portStr, _ := net.SplitHostPort(fk.ln.Addr().String())
+			port, _ := strconv.Atoi(portStr)
+			md := &metadata.Response{Brokers: []metadata.ResponseBroker{{NodeID: 0, Host: host, Port: int32(port)}}}
+			for _, topic := range req.TopicNames {
+				rt := metadata.ResponseTopic{Name: topic}
+				for p := int32(0); p < 2; p++ {
+					rt.Partitions = append(rt.Partitions, metadata.ResponsePartition{PartitionIndex: p, ReplicaNodes: []int32{0}, IsrNodes: []int32{0}})
+				}
+				md.Topics = append(md.Topics, rt)
+			}
+			resp = md
+		case *produce.Request:
+			pr := &produce.Response{}
+			for _, topic := range req.Topics {
+				rt := produce.ResponseTopic{Topic: topic.Topic}
+				for _, part := range topic.Partitions {
+					if err := fk.decodeRecords(topic.Topic, part); err != nil {
+						fk.fail(err)
+						return
+					}
+					rt.Partitions = append(rt.Partitions, produce.ResponsePartition{Partition: part.Partition, LogAppendTime: -1})
+				}
+				pr.Topics = append(pr.Topics, rt)
+			}
+			if req.Acks == 0 {
+				continue
+			}
+			resp = pr
+		default:
+			fk.fail(fmt.Errorf("unexpected request %T", msg))
+			return
+		}
+		if err := protocol.WriteResponse(conn, version, corr, resp); err != nil {
+			return
+		}
+	}
+}
+
+func (fk *fakeKafka) decodeRecords(topic string, part produce.RequestPartition) error {
+	if part.RecordSet.Version != 2 {
+		return fmt.Errorf("record set version %d, want 2", part.RecordSet.Version)
+	}
+	for {
+		rec, err := part.RecordSet.Records.ReadRecord()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key, err := protocol.ReadAll(rec.Key)
+		if err != nil {
+			return err
+		}
+		value, err := protocol.ReadAll(rec.Value)
+		if err != nil {
+			return err
+		}
+		fk.mu.Lock()
+		fk.records = append(fk.records, producedRecord{topic, part.Partition, string(key), value, rec.Time})
+		fk.mu.Unlock()
+	}
+}
+
+func TestKafkaSinkProduces(t *testing.T) {
+	fk := newFakeKafka(t)
+	reg := NewMetricsRegistry()
+	sink, err := NewKafkaSink(KafkaConfig{
+		Brokers:   []string{fk.ln.Addr().String()},
+		Topics:    map[EventType]string{EventTrade: "trades", EventBook: "book"},
+		BatchSize: 100,
+		Acks:      1,
+	}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, sym := range []string{"btcusdt", "ethusdt", "btcusdt"} {
+		sink.Write(&Event{Type: EventTrade, Symbol: sym, Timestamp: now, Trade: &orderbook.Trade{Symbol: sym, Price: 10, Quantity: 1, Timestamp: now}})
+	}
+	book := &BookSnapshot{Bids: []orderbook.PriceLevel{{Price: 9, Volume: 1, Orders: 1}}}
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: now, Book: book})
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: now, Book: book}) // unchanged: no delta
+	sink.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: now})           // no topic configured
+
+	closeErr := sink.Close()
+
+	fk.mu.Lock()
+	defer fk.mu.Unlock()
+	if fk.err != nil {
+		t.Fatalf("kafka-go rejected a request: %v", fk.err)
+	}
+	if closeErr != nil {
+		t.Fatal(closeErr)
+	}
+	if fk.versions[protocol.Metadata] != 1 || fk.versions[protocol.Produce] != 3 {
+		t.Errorf("API versions = %v, want Metadata v1 and Produce v3", fk.versions)
+	}
+	if len(fk.records) != 4 {
+		t.Fatalf("broker received %d records, want 4", len(fk.records))
+	}
+	partitions := map[string]int32{}
+	for _, r := range fk.records {
+		if r.topic != "trades" {
+			continue
+		}
+		if p, seen := partitions[r.key]; seen && p != r.partition {
+			t.Errorf("key %s spread over partitions %d and %d", r.key, p, r.partition)
+		}
+		partitions[r.key] = r.partition
+		var tr orderbook.Trade
+		if err := json.Unmarshal(r.value, &tr); err != nil || tr.Symbol != r.key {
+			t.Errorf("trade record %q decoded as %+v (%v)", r.value, tr, err)
+		}
+		if !r.ts.Equal(now.Truncate(time.Millisecond)) {
+			t.Errorf("trade record timestamp = %v, want %v", r.ts, now.Truncate(time.Millisecond))
+		}
+	}
+	if sink.produced.Value() != 4 || sink.errors.Value() != 0 {
+		t.Errorf("produced = %d, errors = %d; want 4, 0", sink.produced.Value(), sink.errors.Value())
+	}
+}
+
+func TestKafkaSinkCountsUndeliverable(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close() // nothing listening
+
+	sink, _ := NewKafkaSink(KafkaConfig{Brokers: []string{addr}, Topics: map[EventType]string{EventTrade: "trades"}, Timeout: time.Second}, NewMetricsRegistry())
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{}})
+	if err := sink.Flush(); err == nil {
+		t.Error("expected error with no reachable broker")
+	}
+	if sink.errors.Value() != 1 {
+		t.Errorf("errors = %d, want 1", sink.errors.Value())
+	}
+}
+
+func TestMurmur2MatchesJavaClient(t *testing.T) {
+	// Reference values from org.apache.kafka.common.utils.Utils.murmur2
+	tests := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+	}
+	for in, want := range tests {
+		if got := murmur2([]byte(in)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// LogConfig selects the log format and verbosity. Modules overrides Level
+// for loggers obtained with logger(name).
+type LogConfig struct {
+	Level   slog.Level
+	Modules map[string]slog.Level
+	JSON    bool
+	Output  io.Writer
+}
+
+// ParseLogLevels parses a verbosity spec such as "info" or
+// "warn,nats=debug,sink=error" into a default level and per-module overrides.
+func ParseLogLevels(spec string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	modules := make(map[string]slog.Level)
+	for _, part := range splitList(spec) {
+		name, value, scoped := strings.Cut(part, "=")
+		if !scoped {
+			value = name
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(value)); err != nil {
+			return 0, nil, fmt.Errorf("invalid log level %q", value)
+		}
+		if scoped {
+			modules[strings.TrimSpace(name)] = l
+		} else {
+			level = l
+		}
+	}
+	return level, modules, nil
+}
+
+// SetupLogging installs the structured logger as the slog and log package
+// default and returns it.
+func SetupLogging(cfg LogConfig) *slog.Logger {
+	out := cfg.Output
+	if out == nil {
+		out = console
+	}
+	lowest := cfg.Level
+	for _, l := range cfg.Modules {
+		if l < lowest {
+			lowest = l
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lowest}
+	var inner slog.Handler
+	if cfg.JSON {
+		inner = slog.NewJSONHandler(out, opts)
+	} else {
+		inner = slog.NewTextHandler(out, opts)
+	}
+	l := slog.New(&moduleHandler{inner: inner, level: cfg.Level, modules: cfg.Modules})
+	slog.SetDefault(l)
+	return l
+}
+
+// logger returns the default logger tagged with module=name, which also
+// selects the module's verbosity override.
+func logger(name string) *slog.Logger {
+	return slog.Default().With("module", name)
+}
+
+type moduleHandler struct {
+	inner   slog.Handler
+	level   slog.Level
+	modules map[string]slog.Level
+	module  string
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, l slog.Level) bool {
+	if threshold, ok := h.modules[h.module]; ok {
+		return l >= threshold
+	}
+	return l >= h.level
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == "module" {
+			c.module = a.Value.String()
+		}
+	}
+	return &c
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.inner = h.inner.WithGroup(name)
+	return &c
+}
+
+// Console keeps the \r status line and log output apart. When both go to
+// the same terminal, a log write erases the status line first and redraws
+// it afterwards so records never land in the middle of it. The status may
+// span several lines, as the per-symbol table does.
+type Console struct {
+	mu     sync.Mutex
+	out    io.Writer
+	logs   io.Writer
+	shared bool
+	status string
+}
+
+var console = NewConsole(os.Stdout, os.Stderr)
+
+func NewConsole(out, logs io.Writer) *Console {
+	c := &Console{out: out}
+	c.SetLogOutput(logs)
+	return c
+}
+
+// SetLogOutput redirects log records, e.g. to a file while the TUI owns
+// the terminal.
+func (c *Console) SetLogOutput(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = w
+	c.shared = w == c.out || (isTerminal(c.out) && isTerminal(w))
+}
+
+// Status replaces the current status line.
+func (c *Console) Status(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status != "" && (strings.Contains(c.status, "\n") || strings.Contains(line, "\n")) {
+		io.WriteString(c.out, c.eraseStatus())
+	}
+	c.status = line
+	io.WriteString(c.out, "\r"+line)
+}
+
+// eraseStatus returns the escapes that move back to the start of the
+// status and clear it.
+func (c *Console) eraseStatus() string {
+	if n := strings.Count(c.status, "\n"); n > 0 {
+		return fmt.Sprintf("\r\x1b[%dA\x1b[J", n)
+	}
+	return "\r\x1b[K"
+}
+
+// Println writes line above the status line.
+func (c *Console) Println(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status == "" {
+		io.WriteString(c.out, line+"\n")
+		return
+	}
+	io.WriteString(c.out, c.eraseStatus()+line+"\n\r"+c.status)
+}
+
+// EndStatus moves past the status line so further output starts on a
+// fresh line.
+func (c *Console) EndStatus() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status != "" {
+		io.WriteString(c.out, "\n")
+		c.status = ""
+	}
+}
+
+// Write implements io.Writer for log records.
+func (c *Console) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.shared || c.status == "" {
+		return c.logs.Write(p)
+	}
+	io.WriteString(c.out, c.eraseStatus())
+	n, err := c.logs.Write(p)
+	io.WriteString(c.out, "\r"+c.status)
+	return n, err
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+// fatal logs msg at error level and exits, like log.Fatal for slog.
+func fatal(l *slog.Logger, msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
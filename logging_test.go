@@ -0,0 +1,96 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	level, modules, err := ParseLogLevels("warn, feed=debug,nats=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != slog.LevelWarn || modules["feed"] != slog.LevelDebug || modules["nats"] != slog.LevelError {
+		t.Errorf("got %v %v", level, modules)
+	}
+	if level, _, _ := ParseLogLevels(""); level != slog.LevelInfo {
+		t.Errorf("empty spec level = %v, want info", level)
+	}
+	if _, _, err := ParseLogLevels("sink=loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestSetupLoggingModuleLevels(t *testing.T) {
+	defer func(l *slog.Logger, w io.Writer, flags int) {
+		slog.SetDefault(l)
+		log.SetOutput(w)
+		log.SetFlags(flags)
+	}(slog.Default(), log.Writer(), log.Flags())
+	var buf bytes.Buffer
+	SetupLogging(LogConfig{
+		Level:   slog.LevelWarn,
+		Modules: map[string]slog.Level{"feed": slog.LevelDebug},
+		JSON:    true,
+		Output:  &buf,
+	})
+
+	logger("main").Info("dropped")
+	logger("main").Warn("kept", "n", 1)
+	logger("feed").Debug("parsed", "price", "42.5")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(lines), buf.String())
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec["module"] != "feed" || rec["level"] != "DEBUG" || rec["msg"] != "parsed" || rec["price"] != "42.5" {
+		t.Errorf("record = %v", rec)
+	}
+}
+
+func TestConsoleMultiLineStatus(t *testing.T) {
+	var term bytes.Buffer
+	c := NewConsole(&term, &term)
+	c.Status("a\nb\nc")
+	c.Write([]byte("record\n"))
+	c.Status("x")
+	c.EndStatus()
+
+	// The cursor goes back up two lines before erasing or replacing the table
+	want := "\ra\nb\nc" + "\r\x1b[2A\x1b[J" + "record\n" + "\ra\nb\nc" + "\r\x1b[2A\x1b[J" + "\rx\n"
+	if term.String() != want {
+		t.Errorf("output = %q, want %q", term.String(), want)
+	}
+}
+
+func TestConsoleKeepsStatusLineIntact(t *testing.T) {
+	var term bytes.Buffer
+	c := NewConsole(&term, &term)
+	c.Write([]byte("before\n"))
+	c.Status("[LOB] Last: 1.00")
+	c.Write([]byte("level=ERROR msg=boom\n"))
+	c.Status("[LOB] Last: 2.00")
+	c.EndStatus()
+
+	want := "before\n\r[LOB] Last: 1.00\r\x1b[Klevel=ERROR msg=boom\n\r[LOB] Last: 1.00\r[LOB] Last: 2.00\n"
+	if term.String() != want {
+		t.Errorf("output = %q, want %q", term.String(), want)
+	}
+
+	var out, logs bytes.Buffer
+	c = NewConsole(&out, &logs)
+	c.Status("status")
+	c.Write([]byte("record\n"))
+	if out.String() != "\rstatus" || logs.String() != "record\n" {
+		t.Errorf("separate outputs: out=%q logs=%q", out.String(), logs.String())
+	}
+}
@@ -0,0 +1,110 @@
+package apexlob
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// SymbolMemory is what one symbol's state holds.
+type SymbolMemory struct {
+	orderbook.Stats
+	TapeTrades int `json:"tape_trades"`
+}
+
+// MemoryReport combines the Go heap with the per-symbol sizes that drive it,
+// so growth can be traced to the book that caused it.
+type MemoryReport struct {
+	HeapAllocBytes uint64                  `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64                  `json:"heap_inuse_bytes"`
+	SysBytes       uint64                  `json:"sys_bytes"`
+	NumGC          uint32                  `json:"num_gc"`
+	Symbols        map[string]SymbolMemory `json:"symbols"`
+}
+
+// ReadMemoryReport briefly stops the world to read the heap statistics, so
+// call it at most every few seconds.
+func ReadMemoryReport(symbols *SymbolRegistry) MemoryReport {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	report := MemoryReport{
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapInuseBytes: ms.HeapInuse,
+		SysBytes:       ms.Sys,
+		NumGC:          ms.NumGC,
+		Symbols:        make(map[string]SymbolMemory),
+	}
+	for _, sym := range symbols.List() {
+		if state, ok := symbols.Get(sym); ok {
+			report.Symbols[sym] = SymbolMemory{Stats: state.Book.Stats(), TapeTrades: state.Tape.Len()}
+		}
+	}
+	return report
+}
+
+func RegisterMemoryMetrics(reg *MetricsRegistry, symbols *SymbolRegistry) {
+	perSymbol := func(fn func(sym string, s *SymbolState) []Sample) func() []Sample {
+		return func() []Sample {
+			var samples []Sample
+			for _, sym := range symbols.List() {
+				if state, ok := symbols.Get(sym); ok {
+					samples = append(samples, fn(sym, state)...)
+				}
+			}
+			return samples
+		}
+	}
+	reg.GaugeFunc("apexlob_book_levels", "Price levels resting on each side of the book.", perSymbol(func(sym string, s *SymbolState) []Sample {
+		stats := s.Book.Stats()
+		return []Sample{
+			{Labels: Labels{"symbol": sym, "side": "bid"}, Value: float64(stats.BidLevels)},
+			{Labels: Labels{"symbol": sym, "side": "ask"}, Value: float64(stats.AskLevels)},
+		}
+	}))
+	reg.GaugeFunc("apexlob_resting_orders", "Orders resting in the book.", perSymbol(func(sym string, s *SymbolState) []Sample {
+		return []Sample{{Labels: Labels{"symbol": sym}, Value: float64(s.Book.Stats().RestingOrders)}}
+	}))
+	reg.GaugeFunc("apexlob_evicted_orders", "Resting orders dropped by the book caps since startup.", perSymbol(func(sym string, s *SymbolState) []Sample {
+		return []Sample{{Labels: Labels{"symbol": sym}, Value: float64(s.Book.Stats().EvictedOrders)}}
+	}))
+	reg.GaugeFunc("apexlob_tape_trades", "Trades held on the tape.", perSymbol(func(sym string, s *SymbolState) []Sample {
+		return []Sample{{Labels: Labels{"symbol": sym}, Value: float64(s.Tape.Len())}}
+	}))
+	reg.GaugeFunc("apexlob_heap_bytes", "Go heap size: alloc for live objects, inuse for spans holding them, sys from the OS.", func() []Sample {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return []Sample{
+			{Labels: Labels{"kind": "alloc"}, Value: float64(ms.HeapAlloc)},
+			{Labels: Labels{"kind": "inuse"}, Value: float64(ms.HeapInuse)},
+			{Labels: Labels{"kind": "sys"}, Value: float64(ms.Sys)},
+		}
+	})
+}
+
+// LogMemory logs a memory report every interval until ctx is done.
+func LogMemory(ctx context.Context, log *slog.Logger, symbols *SymbolRegistry, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		report := ReadMemoryReport(symbols)
+		log.Info("memory",
+			"heap_alloc_mb", report.HeapAllocBytes>>20,
+			"sys_mb", report.SysBytes>>20,
+			"num_gc", report.NumGC)
+		for _, sym := range symbols.List() {
+			m := report.Symbols[sym]
+			log.Debug("book memory", "symbol", sym,
+				"bid_levels", m.BidLevels, "ask_levels", m.AskLevels,
+				"resting_orders", m.RestingOrders, "tape_trades", m.TapeTrades,
+				"evicted_levels", m.EvictedLevels, "evicted_orders", m.EvictedOrders)
+		}
+	}
+}
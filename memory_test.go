@@ -0,0 +1,35 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestMemoryMetrics(t *testing.T) {
+	state := NewSymbolStateWithLimits("ethusdt", SymbolLimits{Book: orderbook.Limits{MaxLevels: 1}, TapeSize: 10})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 10, Side: orderbook.Buy})
+	state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 98, Quantity: 10, Side: orderbook.Buy})
+	symbols := NewSymbolRegistry()
+	symbols.Add(state)
+
+	reg := NewMetricsRegistry()
+	RegisterMemoryMetrics(reg, symbols)
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		`apexlob_book_levels{side="bid",symbol="ethusdt"} 1`,
+		`apexlob_resting_orders{symbol="ethusdt"} 1`,
+		`apexlob_evicted_orders{symbol="ethusdt"} 1`,
+		`apexlob_tape_trades{symbol="ethusdt"} 0`,
+		`apexlob_heap_bytes{kind="alloc"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %s:\n%s", want, out)
+		}
+	}
+}
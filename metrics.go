@@ -0,0 +1,394 @@
+package apexlob
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+// A minimal Prometheus text-format registry. The monitor only needs counters,
+// gauges and fixed-bucket histograms, which keeps the binary free of the
+// client_golang dependency tree.
+
+type Labels map[string]string
+
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc()          { atomic.AddUint64(&c.v, 1) }
+func (c *Counter) Add(n uint64)  { atomic.AddUint64(&c.v, n) }
+func (c *Counter) Value() uint64 { return atomic.LoadUint64(&c.v) }
+
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64)  { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	counts  []uint64 // per bucket, non-cumulative; last entry is +Inf
+	sum     float64
+	samples uint64
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	b := append([]float64(nil), bounds...)
+	sort.Float64s(b)
+	return &Histogram{bounds: b, counts: make([]uint64, len(b)+1)}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.samples++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.samples
+}
+
+// HistogramBucket counts the samples above the previous bucket's bound up
+// to UpTo. The last bucket has no bound, and an UpTo of 0.
+type HistogramBucket struct {
+	UpTo  float64 `json:"up_to,omitempty"`
+	Count uint64  `json:"count"`
+}
+
+// Buckets returns the histogram's counts, per bucket rather than
+// cumulative.
+func (h *Histogram) Buckets() []HistogramBucket {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i, n := range h.counts {
+		buckets[i].Count = n
+		if i < len(h.bounds) {
+			buckets[i].UpTo = h.bounds[i]
+		}
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count bounds starting at start, each factor times the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	b := make([]float64, count)
+	for i := range b {
+		b[i] = start
+		start *= factor
+	}
+	return b
+}
+
+type metricFamily struct {
+	name   string
+	help   string
+	typ    string
+	series []metricSeries
+	funcs  []func() []Sample
+}
+
+type metricSeries struct {
+	labels Labels
+	value  interface{} // *Counter, *Gauge or *Histogram
+}
+
+type MetricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{families: make(map[string]*metricFamily)}
+}
+
+func (r *MetricsRegistry) family(name, help, typ string) *metricFamily {
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, typ: typ}
+		r.families[name] = f
+	} else if f.typ != typ {
+		panic(fmt.Sprintf("metric %s registered as %s and %s", name, f.typ, typ))
+	}
+	return f
+}
+
+func (r *MetricsRegistry) Counter(name, help string, labels Labels) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &Counter{}
+	f := r.family(name, help, "counter")
+	f.series = append(f.series, metricSeries{labels: labels, value: c})
+	return c
+}
+
+func (r *MetricsRegistry) Gauge(name, help string, labels Labels) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g := &Gauge{}
+	f := r.family(name, help, "gauge")
+	f.series = append(f.series, metricSeries{labels: labels, value: g})
+	return g
+}
+
+func (r *MetricsRegistry) Histogram(name, help string, labels Labels, bounds []float64) *Histogram {
+	h := NewHistogram(bounds)
+	r.RegisterHistogram(name, help, labels, h)
+	return h
+}
+
+// RegisterHistogram exports a histogram kept elsewhere, such as a symbol's
+// trade sizes.
+func (r *MetricsRegistry) RegisterHistogram(name, help string, labels Labels, h *Histogram) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, help, "histogram")
+	f.series = append(f.series, metricSeries{labels: labels, value: h})
+}
+
+// GaugeFunc registers samples computed at scrape time, for values that
+// already live elsewhere (book aggregates, signal values).
+func (r *MetricsRegistry) GaugeFunc(name, help string, fn func() []Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, help, "gauge")
+	f.funcs = append(f.funcs, fn)
+}
+
+// CounterFunc is GaugeFunc for counts kept elsewhere that only go up.
+func (r *MetricsRegistry) CounterFunc(name, help string, fn func() []Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f := r.family(name, help, "counter")
+	f.funcs = append(f.funcs, fn)
+}
+
+func (r *MetricsRegistry) sortedFamilies() []*metricFamily {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]*metricFamily, len(names))
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	return families
+}
+
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) error {
+	var b strings.Builder
+	for _, f := range r.sortedFamilies() {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, s := range f.series {
+			switch v := s.value.(type) {
+			case *Counter:
+				writeSample(&b, f.name, s.labels, float64(v.Value()))
+			case *Gauge:
+				writeSample(&b, f.name, s.labels, v.Value())
+			case *Histogram:
+				writeHistogram(&b, f.name, s.labels, v)
+			}
+		}
+		for _, fn := range f.funcs {
+			for _, sample := range fn() {
+				writeSample(&b, f.name, sample.Labels, sample.Value)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHistogram(b *strings.Builder, name string, labels Labels, h *Histogram) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, samples := h.sum, h.samples
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		writeSample(b, name+"_bucket", withLabel(labels, "le", formatFloat(bound)), float64(cumulative))
+	}
+	writeSample(b, name+"_bucket", withLabel(labels, "le", "+Inf"), float64(samples))
+	writeSample(b, name+"_sum", labels, sum)
+	writeSample(b, name+"_count", labels, float64(samples))
+}
+
+func withLabel(labels Labels, key, value string) Labels {
+	out := make(Labels, len(labels)+1)
+	for k, v := range labels {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+func writeSample(b *strings.Builder, name string, labels Labels, value float64) {
+	b.WriteString(name)
+	if len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for k := range labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(k)
+			b.WriteString(`="`)
+			labelEscaper.WriteString(b, labels[k])
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+}
+
+// labelEscaper escapes a label value as the text format wants: only
+// backslash, double quote and newline. Go's %q would also escape other
+// control and non-ASCII characters in a way Prometheus reads literally.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func (r *MetricsRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.WritePrometheus(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// MonitorMetrics holds the instruments updated by the feed loop.
+type MonitorMetrics struct {
+	Messages   *Counter
+	Reconnects *Counter
+	Processing *Histogram
+}
+
+func NewMonitorMetrics(reg *MetricsRegistry, symbol string, ob *orderbook.Book, engine *signals.Engine) *MonitorMetrics {
+	labels := Labels{"symbol": symbol}
+	bookGauge := func(fn func() float64) func() []Sample {
+		return func() []Sample { return []Sample{{Labels: labels, Value: fn()}} }
+	}
+
+	reg.GaugeFunc("apexlob_last_trade_price", "Price of the most recent trade.", bookGauge(ob.GetLastTradePrice))
+	reg.GaugeFunc("apexlob_vwap", "Session volume-weighted average trade price.", bookGauge(ob.GetVWAP))
+	reg.GaugeFunc("apexlob_traded_volume", "Session traded volume in scaled quantity units.", bookGauge(func() float64 {
+		return float64(ob.GetTotalVolume())
+	}))
+	reg.GaugeFunc("apexlob_book_vwap", "Volume-weighted average price of the volume resting in the best levels of each side of the book, and of both.", func() []Sample {
+		v := ob.BookVWAP()
+		var samples []Sample
+		for _, s := range []struct {
+			side  string
+			value float64
+		}{{"bid", v.Bid}, {"ask", v.Ask}, {"both", v.Both}} {
+			if s.value != 0 {
+				samples = append(samples, Sample{Labels: Labels{"symbol": symbol, "side": s.side}, Value: s.value})
+			}
+		}
+		return samples
+	})
+	reg.GaugeFunc("apexlob_spread_bps", "Best ask minus best bid, in basis points of mid.", func() []Sample {
+		v, ok := engine.Value("spread_bps")
+		if !ok {
+			return nil
+		}
+		return []Sample{{Labels: labels, Value: v}}
+	})
+	reg.GaugeFunc("apexlob_signal", "Latest value of each registered signal.", func() []Sample {
+		snapshot := engine.Snapshot()
+		samples := make([]Sample, 0, len(snapshot))
+		for _, name := range sortedKeys(snapshot) {
+			samples = append(samples, Sample{Labels: Labels{"symbol": symbol, "signal": name}, Value: snapshot[name]})
+		}
+		return samples
+	})
+
+	return &MonitorMetrics{
+		Messages:   reg.Counter("apexlob_messages_total", "Feed messages processed.", labels),
+		Reconnects: reg.Counter("apexlob_reconnects_total", "WebSocket reconnections after a dropped feed.", labels),
+		Processing: reg.Histogram("apexlob_processing_seconds", "Per-message processing latency.", labels,
+			ExponentialBuckets(1e-6, 2, 20)),
+	}
+}
+
+// RegisterQualityMetrics exports the market-quality signals of mirrored
+// books, labelled by window, with the activity they are computed from.
+func RegisterQualityMetrics(reg *MetricsRegistry, symbols *SymbolRegistry) {
+	mirrored := func(fn func(sym string, s *SymbolState) []Sample) func() []Sample {
+		return func() []Sample {
+			var samples []Sample
+			for _, sym := range symbols.List() {
+				if state, ok := symbols.Get(sym); ok && state.Mode == BookMirrored {
+					samples = append(samples, fn(sym, state)...)
+				}
+			}
+			return samples
+		}
+	}
+	ratio := func(prefix string) func() []Sample {
+		return mirrored(func(sym string, s *SymbolState) []Sample {
+			var samples []Sample
+			snapshot := s.Signals.Snapshot()
+			for _, name := range sortedKeys(snapshot) {
+				if window, ok := strings.CutPrefix(name, prefix); ok {
+					samples = append(samples, Sample{Labels: Labels{"symbol": sym, "window": window}, Value: snapshot[name]})
+				}
+			}
+			return samples
+		})
+	}
+	reg.GaugeFunc("apexlob_order_to_trade_ratio", "Volume added to the mirrored book per unit traded over each window.", ratio("order_to_trade_"))
+	reg.GaugeFunc("apexlob_cancel_to_fill_ratio", "Volume withdrawn from the mirrored book without trading per unit traded over each window.", ratio("cancel_to_fill_"))
+	reg.GaugeFunc("apexlob_mirror_volume", "Scaled volume the exchange added to, removed from and traded against the mirrored book since startup.", mirrored(func(sym string, s *SymbolState) []Sample {
+		a := s.Book.MirrorActivity()
+		return []Sample{
+			{Labels: Labels{"symbol": sym, "kind": "added"}, Value: float64(a.Added)},
+			{Labels: Labels{"symbol": sym, "kind": "removed"}, Value: float64(a.Removed)},
+			{Labels: Labels{"symbol": sym, "kind": "traded"}, Value: float64(a.TradedVolume)},
+		}
+	}))
+}
@@ -0,0 +1,102 @@
+package apexlob
+
+import (
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+func TestMetricsRegistryExposition(t *testing.T) {
+	reg := NewMetricsRegistry()
+	c := reg.Counter("test_total", "A counter.", Labels{"symbol": "btcusdt"})
+	g := reg.Gauge("test_gauge", "A gauge.", nil)
+	h := reg.Histogram("test_seconds", "A histogram.", nil, []float64{0.1, 1})
+	reg.GaugeFunc("test_func", "A gauge func.", func() []Sample {
+		return []Sample{
+			{Labels: Labels{"b": "2", "a": "1"}, Value: 7},
+			{Labels: Labels{"path": "C:\\tmp\n\"é\"\t"}, Value: 1},
+		}
+	})
+
+	c.Add(3)
+	g.Set(1.5)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+
+	for _, want := range []string{
+		"# TYPE test_total counter\n",
+		`test_total{symbol="btcusdt"} 3` + "\n",
+		"test_gauge 1.5\n",
+		`test_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_seconds_bucket{le="1"} 2` + "\n",
+		`test_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_seconds_sum 5.55\n",
+		"test_seconds_count 3\n",
+		`test_func{a="1",b="2"} 7` + "\n",
+		`test_func{path="C:\\tmp\n\"é\"` + "\t\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition missing %q:\n%s", want, out)
+		}
+	}
+	want := []HistogramBucket{{UpTo: 0.1, Count: 1}, {UpTo: 1, Count: 1}, {Count: 1}}
+	if got := h.Buckets(); !slices.Equal(got, want) {
+		t.Errorf("buckets = %+v, want %+v", got, want)
+	}
+}
+
+func TestMetricsRegistryTypeConflict(t *testing.T) {
+	reg := NewMetricsRegistry()
+	reg.Counter("dup", "", nil)
+	defer func() {
+		if recover() == nil {
+			t.Error("registering dup as a gauge should panic")
+		}
+	}()
+	reg.Gauge("dup", "", nil)
+}
+
+func TestMonitorMetricsHandler(t *testing.T) {
+	ob := orderbook.New()
+	se := signals.NewEngine()
+	signals.RegisterDefaults(se)
+	reg := NewMetricsRegistry()
+	m := NewMonitorMetrics(reg, "btcusdt", ob, se)
+
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 100.0, Quantity: 500, Side: orderbook.Buy})
+	ob.SubmitOrder(&orderbook.Order{ID: 2, Price: 100.0, Quantity: 500, Side: orderbook.Sell})
+	ob.SubmitOrder(&orderbook.Order{ID: 3, Price: 101.0, Quantity: 200, Side: orderbook.Sell})
+	se.OnTrade(&orderbook.Trade{Price: 100.0, Quantity: 0.5, Side: orderbook.Sell, Timestamp: time.Now()}, ob)
+	m.Messages.Inc()
+
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		`apexlob_last_trade_price{symbol="btcusdt"} 100`,
+		`apexlob_traded_volume{symbol="btcusdt"} 500`,
+		`apexlob_book_vwap{side="ask",symbol="btcusdt"} 101`,
+		`apexlob_messages_total{symbol="btcusdt"} 1`,
+		`apexlob_signal{signal="ofi_1m",symbol="btcusdt"} -1`,
+		`apexlob_reconnects_total{symbol="btcusdt"} 0`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("/metrics missing %q", want)
+		}
+	}
+}
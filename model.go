@@ -0,0 +1,114 @@
+package apexlob
+
+import (
+	"math"
+	"strings"
+
+	"apexlob/pkg/signals"
+)
+
+type ModelConfig struct {
+	ModelPath   string
+	LibraryPath string   // onnxruntime shared library; empty uses the platform default
+	Features    []string // signal names fed to the model, in input order
+	InputName   string
+	OutputName  string
+	SignalName  string
+	AlertAbove  float64 // alert when the score rises above this; 0 disables
+}
+
+type modelScorer interface {
+	Score(features []float32) (float32, error)
+	Close() error
+}
+
+// ModelSignal publishes a learned model's score as an ordinary signal. It
+// must be registered after the signals it consumes.
+type ModelSignal struct {
+	cfg      ModelConfig
+	scorer   modelScorer
+	features []float32
+	alerting bool
+	ready    bool // every feature had a warmed-up value at the last update
+	failed   bool // the last inference failed
+	errors   int
+}
+
+func NewModelSignal(cfg ModelConfig) (*ModelSignal, error) {
+	if cfg.SignalName == "" {
+		cfg.SignalName = "model_score"
+	}
+	scorer, err := newModelScorer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newModelSignalWithScorer(cfg, scorer), nil
+}
+
+func newModelSignalWithScorer(cfg ModelConfig, scorer modelScorer) *ModelSignal {
+	return &ModelSignal{
+		cfg:      cfg,
+		scorer:   scorer,
+		features: make([]float32, len(cfg.Features)),
+	}
+}
+
+func (m *ModelSignal) Name() string { return m.cfg.SignalName }
+
+// Update scores the current features. Until every one of them has a value
+// and is warmed up it skips inference and reports no score: a missing
+// feature would go in as 0, and a cold one as a placeholder such as
+// rsi_14's 50, inputs the model was not trained on.
+func (m *ModelSignal) Update(in *signals.Input) float64 {
+	m.ready, m.failed = false, false
+	for i, name := range m.cfg.Features {
+		v, ok := in.Values[name]
+		if !ok || in.Cold[name] {
+			return math.NaN()
+		}
+		m.features[i] = float32(v)
+	}
+	m.ready = true
+
+	score, err := m.scorer.Score(m.features)
+	m.failed = err != nil
+	if err != nil {
+		m.errors++
+		if m.errors == 1 || m.errors%1000 == 0 {
+			logger("model").Error("inference failed", "errors", m.errors, "err", err)
+		}
+		return math.NaN()
+	}
+
+	value := float64(score)
+	if m.cfg.AlertAbove != 0 {
+		above := value > m.cfg.AlertAbove
+		if above && !m.alerting {
+			logger("model").Warn("alert", "signal", m.cfg.SignalName, "value", value, "above", m.cfg.AlertAbove)
+		}
+		m.alerting = above
+	}
+	return value
+}
+
+// Failed reports whether the last update produced no score, because a
+// feature was not ready or inference failed, so the engine drops the
+// previous score instead of serving it as current.
+func (m *ModelSignal) Failed() bool { return !m.ready || m.failed }
+
+// Ready reports whether every feature was warmed up at the last update.
+func (m *ModelSignal) Ready() bool { return m.ready }
+
+func (m *ModelSignal) Close() error {
+	return m.scorer.Close()
+}
+
+func parseFeatureList(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,125 @@
+package apexlob
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+type fakeScorer struct {
+	last  []float32
+	score float32
+	err   error
+}
+
+func (f *fakeScorer) Score(features []float32) (float32, error) {
+	f.last = append(f.last[:0], features...)
+	return f.score, f.err
+}
+
+func (f *fakeScorer) Close() error { return nil }
+
+func TestModelSignalFeedsFeatures(t *testing.T) {
+	scorer := &fakeScorer{score: 0.75}
+	se := signals.NewEngine()
+	se.Register(signals.Func("x", func(*signals.Input) float64 { return 2 }))
+	se.Register(signals.Func("y", func(*signals.Input) float64 { return 3 }))
+	se.Register(newModelSignalWithScorer(ModelConfig{SignalName: "score", Features: []string{"y", "x"}}, scorer))
+
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, orderbook.New())
+
+	if len(scorer.last) != 2 || scorer.last[0] != 3 || scorer.last[1] != 2 {
+		t.Errorf("model features = %v, want [3 2]", scorer.last)
+	}
+	if v, ok := se.Value("score"); !ok || v != 0.75 {
+		t.Errorf("score = %v (ok=%v), want 0.75", v, ok)
+	}
+}
+
+func TestModelSignalErrorLeavesValueUnset(t *testing.T) {
+	se := signals.NewEngine()
+	se.Register(newModelSignalWithScorer(ModelConfig{SignalName: "score"}, &fakeScorer{err: errors.New("boom")}))
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, orderbook.New())
+
+	if _, ok := se.Value("score"); ok {
+		t.Error("score should be unset after an inference error")
+	}
+}
+
+func TestModelSignalErrorClearsPreviousValue(t *testing.T) {
+	scorer := &fakeScorer{score: 0.5}
+	se := signals.NewEngine()
+	se.Register(newModelSignalWithScorer(ModelConfig{SignalName: "score"}, scorer))
+	ob := orderbook.New()
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, ob)
+	if v, ok := se.Value("score"); !ok || v != 0.5 {
+		t.Fatalf("score = %v (ok=%v), want 0.5", v, ok)
+	}
+
+	scorer.err = errors.New("boom")
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, ob)
+	if v, ok := se.Value("score"); ok {
+		t.Errorf("score = %v after an inference error, want unset", v)
+	}
+	if _, ok := se.Snapshot()["score"]; ok {
+		t.Error("snapshot still holds the score after an inference error")
+	}
+
+	scorer.err = nil
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, ob)
+	if v, ok := se.Value("score"); !ok || v != 0.5 {
+		t.Errorf("score = %v (ok=%v) after recovering, want 0.5", v, ok)
+	}
+}
+
+func TestModelSignalWaitsForWarmFeatures(t *testing.T) {
+	scorer := &fakeScorer{score: 0.5}
+	se := signals.NewEngine()
+	signals.RegisterDefaults(se)
+	model := newModelSignalWithScorer(ModelConfig{SignalName: "score", Features: []string{"rsi_14", "spread_bps"}}, scorer)
+	se.Register(model)
+
+	ob := orderbook.New()
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 10, Side: orderbook.Buy})
+	ob.SubmitOrder(&orderbook.Order{ID: 2, Price: 101, Quantity: 10, Side: orderbook.Sell})
+	now := time.Now()
+	for i := 0; i < 14; i++ {
+		se.OnTrade(&orderbook.Trade{Price: 100 + float64(i%3), Quantity: 1, Timestamp: now}, ob)
+		if scorer.last != nil {
+			t.Fatalf("trade %d: model scored %v before rsi_14 warmed up", i+1, scorer.last)
+		}
+		if _, ok := se.Value("score"); ok || model.Ready() {
+			t.Fatalf("trade %d: score published before rsi_14 warmed up", i+1)
+		}
+	}
+
+	se.OnTrade(&orderbook.Trade{Price: 100, Quantity: 1, Timestamp: now}, ob)
+	if v, ok := se.Value("score"); !ok || v != 0.5 || !model.Ready() {
+		t.Errorf("score = %v (ok=%v) once warm, want 0.5", v, ok)
+	}
+}
+
+func TestModelSignalSkipsMissingFeature(t *testing.T) {
+	scorer := &fakeScorer{score: 0.5}
+	se := signals.NewEngine()
+	se.Register(signals.Func("x", func(*signals.Input) float64 { return 1 }))
+	se.Register(newModelSignalWithScorer(ModelConfig{SignalName: "score", Features: []string{"x", "spread_bps"}}, scorer))
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, orderbook.New())
+
+	if scorer.last != nil {
+		t.Errorf("model scored %v with spread_bps missing", scorer.last)
+	}
+	if _, ok := se.Value("score"); ok {
+		t.Error("score should be unset while a feature is missing")
+	}
+}
+
+func TestParseFeatureList(t *testing.T) {
+	got := parseFeatureList(" a, b,,c ")
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("parseFeatureList() = %v, want [a b c]", got)
+	}
+}
@@ -0,0 +1,1260 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+	"apexlob/proto/apexlobpb"
+
+	"github.com/spf13/pflag"
+)
+
+// PipelineOptions configure the symbols, books, signals and rules of every
+// command that runs the pipeline.
+type PipelineOptions struct {
+	Symbols string
+	// ClusterShard, as i/n, keeps only the symbols that hash onto cluster
+	// instance i of n
+	ClusterShard string
+	Shards       int
+	FeedQueue    int
+	Limits       SymbolLimits
+	BookModes    string
+	ONNXModel    string
+	ONNXLib      string
+	ONNXFeatures string
+	ONNXAlert    float64
+	// BookVWAPLevels is how many levels per side the resting book VWAP
+	// covers; 0 keeps the book's default
+	BookVWAPLevels int
+	// RangeWindow is the rolling window of each symbol's high and low; 0
+	// keeps DefaultRangeWindow
+	RangeWindow time.Duration
+	// SignalPlugins lists Go plugins whose signals every book computes
+	SignalPlugins string
+	// QualityWindows are the rolling windows of the order-to-trade and
+	// cancel-to-fill signals computed for mirrored books
+	QualityWindows []time.Duration
+	// RollWindows are the rolling windows of the roll_spread_bps signals
+	RollWindows []time.Duration
+	// ReturnInterval, ReturnWindow and VarianceHorizons set up the
+	// return_autocorr and variance_ratio_* signals, see
+	// signals.ReturnStats; a zero interval or window keeps the default
+	ReturnInterval   time.Duration
+	ReturnWindow     time.Duration
+	VarianceHorizons []time.Duration
+	// DriftHorizon is how far ahead the drift_prob model predicts the
+	// mid, from the DriftFeatures signals; 0 disables it
+	DriftHorizon  time.Duration
+	DriftFeatures string
+	// DerivedSignals is a JSON file of signals computed from others by
+	// expressions, see DerivedConfig
+	DerivedSignals string
+	// SignalHistory is how far back each signal's values are kept, at one
+	// per SignalHistoryStep, for GET /signals/{symbol}/{name}; zero keeps
+	// the defaults
+	SignalHistory     time.Duration
+	SignalHistoryStep time.Duration
+	// ImbalanceTiers are the depth tiers of the imbalance_* signals, see
+	// signals.ParseDepthTiers
+	ImbalanceTiers string
+	// MarkPrice follows the futures mark and index price of every symbol;
+	// MarkDeviation alerts when trades stray further from them, in bps
+	MarkPrice     bool
+	MarkDeviation float64
+	// FundingNotional is the hypothetical perpetual position, in the quote
+	// currency and negative for a short, whose funding is projected
+	FundingNotional float64
+	// AccountStream follows the user-data stream of the Binance account
+	// whose API key is BinanceAPIKey, feeding its fills to a PnL tracker
+	AccountStream bool
+	BinanceAPIKey string
+	// FeedEndpoints are the market data WebSocket endpoints live fails
+	// over between, in order of preference
+	FeedEndpoints []string
+	FeedHealth    FeedHealth
+	// FeedProbe orders FeedEndpoints by their measured connect time at
+	// startup, and re-measures them every FeedProbeEvery if it is set
+	FeedProbe      bool
+	FeedProbeEvery time.Duration
+	// ClockSyncEvery is how often live measures the offset of the local
+	// clock against the exchange's time endpoint (0 never does)
+	ClockSyncEvery time.Duration
+	// WarmStart is a capture whose messages live runs through the books
+	// and signals before connecting, only the last WarmStartWindow of it
+	// if that is set
+	WarmStart       string
+	WarmStartWindow time.Duration
+	// Clock is the ClockAuto, ClockWall or ClockEvent mode of the clock
+	// trades are stamped with; see resolveClock
+	Clock string
+	// Filter keeps bad trades off the books
+	Filter TradeFilterConfig
+	// Consolidate groups symbols quoting one instrument on different
+	// venues, see ParseConsolidatedGroups
+	Consolidate string
+	RulesFile   string
+	SinksFile   string
+	AlertLog    string // appends every alert to this JSON lines file
+	// Strategy names a registered strategy to run on the feed
+	Strategy              string
+	StrategyTimer         time.Duration
+	StrategyLatency       time.Duration
+	StrategyCancelLatency time.Duration
+	StrategyQueuePosition bool
+	StrategyMark          string
+	tuning                *tuningFlags
+	quietAlerts           bool // don't log every alert
+}
+
+func (o *PipelineOptions) register(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Symbols, "symbol", "btcusdt", "Binance symbol to stream, or a comma-separated list")
+	fs.StringVar(&o.ClusterShard, "cluster-shard", "", "as i/n: stream only the --symbol entries owned by cluster instance i of n, so n instances given the same list split it between them")
+	fs.IntVar(&o.Shards, "shards", runtime.NumCPU(), "worker goroutines that symbols are hashed onto; each owns its symbols' books and signals")
+	fs.IntVar(&o.FeedQueue, "feed-queue", 4096, "parsed messages buffered between the feed reader and each shard worker")
+	fs.IntVar(&o.Limits.Book.MaxLevels, "max-levels", DefaultSymbolLimits.Book.MaxLevels, "price levels kept per book side; the furthest from the touch are evicted beyond it (0 for no cap)")
+	fs.IntVar(&o.Limits.Book.MaxOrders, "max-orders", DefaultSymbolLimits.Book.MaxOrders, "resting orders kept per book, evicting the furthest levels beyond it (0 for no cap)")
+	fs.StringVar(&o.BookModes, "book-mode", string(BookSynthetic), "what each book represents: synthetic (built from trades) or mirrored (exchange depth), optionally per symbol, e.g. mirrored,ethusdt=synthetic")
+	fs.IntVar(&o.BookVWAPLevels, "book-vwap-levels", orderbook.DefaultVWAPLevels, "levels per side the volume-weighted price of the resting book is taken over")
+	fs.DurationVar(&o.RangeWindow, "range-window", DefaultRangeWindow, "rolling window of the high, low and range shown for each symbol")
+	fs.IntVar(&o.Limits.TapeSize, "tape-size", DefaultSymbolLimits.TapeSize, "recent trades kept per symbol")
+	fs.StringVar(&o.ONNXModel, "onnx-model", "", "ONNX model scored on every update (requires -tags onnx)")
+	fs.StringVar(&o.ONNXLib, "onnx-lib", "", "path to the onnxruntime shared library")
+	fs.StringVar(&o.ONNXFeatures, "onnx-features", "spread_bps,imbalance,ofi_1m,rsi_14,momentum_10,volatility_20", "comma-separated signal names fed to the model")
+	fs.Float64Var(&o.ONNXAlert, "onnx-alert", 0, "alert when the model score rises above this value (0 disables)")
+	fs.StringVar(&o.SignalPlugins, "signal-plugin", "", "comma-separated Go plugins (.so) exporting NewSignals, whose signals run after the built-in ones")
+	fs.StringVar(&o.DerivedSignals, "derived-signals", "", "JSON file of signals computed from other signals by expressions, e.g. [{\"name\": \"spread_z\", \"expr\": \"zscore(spread_bps, 5m)\"}]")
+	fs.StringVar(&o.ImbalanceTiers, "imbalance-tiers", signals.DefaultDepthTiers, "depth tiers of the imbalance_* signals: numbers of levels per side, or distances from the mid such as 10bps (empty for none)")
+	fs.DurationSliceVar(&o.QualityWindows, "quality-windows", []time.Duration{time.Minute, 5 * time.Minute}, "rolling windows of the order_to_trade and cancel_to_fill signals of mirrored books")
+	fs.DurationSliceVar(&o.RollWindows, "roll-windows", []time.Duration{time.Minute, 5 * time.Minute}, "rolling windows of the roll_spread_bps signals, the spread implied by trade prices alone")
+	fs.DurationVar(&o.ReturnInterval, "return-interval", signals.DefaultReturnInterval, "interval of the returns the return_autocorr and variance_ratio_* signals are computed from")
+	fs.DurationVar(&o.ReturnWindow, "return-window", signals.DefaultReturnWindow, "rolling window of returns the return_autocorr and variance_ratio_* signals are computed over")
+	fs.DurationSliceVar(&o.VarianceHorizons, "variance-horizons", []time.Duration{5 * time.Second, 30 * time.Second}, "horizons of the variance_ratio_* signals, multiples of --return-interval (empty for none)")
+	fs.DurationVar(&o.DriftHorizon, "drift-horizon", 5*time.Second, "how far ahead the online drift_prob model predicts the direction of the mid (0 disables)")
+	fs.DurationVar(&o.SignalHistory, "signal-history", signals.DefaultHistoryStep*signals.DefaultHistorySize, "how far back each signal's values are kept for GET /signals/{symbol}/{name}")
+	fs.DurationVar(&o.SignalHistoryStep, "signal-history-step", signals.DefaultHistoryStep, "the signal history keeps a value per this long")
+	fs.StringVar(&o.DriftFeatures, "drift-features", signals.DefaultDriftFeatures, "comma-separated signal names the drift_prob model learns from")
+	fs.BoolVar(&o.MarkPrice, "mark-price", false, "follow each symbol's Binance futures mark and index price, for the mark_dev_bps and index_dev_bps signals")
+	fs.Float64Var(&o.MarkDeviation, "mark-deviation-bps", 0, "alert when a trade is more than this many bps from the mark or index price (0 disables; implies --mark-price)")
+	fs.Float64Var(&o.FundingNotional, "funding-notional", 0, "project the funding a perpetual position of this notional would pay (negative for short) in the funding_payment and funding_breakeven_bps signals (implies --mark-price)")
+	fs.BoolVar(&o.AccountStream, "account-stream", false, "live: follow the Binance user-data stream of the account with --binance-api-key and track the PnL of its real fills")
+	fs.StringVar(&o.BinanceAPIKey, "binance-api-key", os.Getenv("BINANCE_API_KEY"), "Binance API key of the account followed by --account-stream (defaults to $BINANCE_API_KEY)")
+	fs.StringSliceVar(&o.FeedEndpoints, "feed-endpoints", []string{binanceStreamBase}, "live: comma-separated WebSocket endpoints serving the same streams, in order of preference, e.g. wss://stream.binance.com:443,wss://data-stream.binance.vision:443")
+	fs.DurationVar(&o.FeedHealth.StallTimeout, "feed-stall-timeout", 0, "live: reconnect, to the next endpoint if there is one, when no message has arrived for this long (0 disables)")
+	fs.BoolVar(&o.FeedProbe, "feed-probe", false, "live: time the TCP and TLS handshakes of each --feed-endpoints entry at startup and prefer the fastest")
+	fs.DurationVar(&o.FeedProbeEvery, "feed-probe-interval", 0, "live: probe the endpoints again this often and move to one that has become 20% faster (0 for startup only; implies --feed-probe)")
+	fs.DurationVar(&o.ClockSyncEvery, "clock-sync-interval", 5*time.Minute, "live: measure the local clock's offset against Binance server time this often and correct end-to-end latencies for it (0 disables; event timestamps still bound it)")
+	fs.StringVar(&o.Clock, "clock", ClockAuto, "time trades are stamped with, which candles, windowed signals and rules go by: wall for when they are processed, event for their exchange event times, so a replay computes what live did; auto is event for replay and backtest and wall otherwise")
+	fs.StringVar(&o.WarmStart, "warm-start", "", "live: before connecting, run this capture (raw or compacted) through the books, tape, candles and signals, stamped with its event times, so indicators are warm when the live feed takes over")
+	fs.DurationVar(&o.WarmStartWindow, "warm-start-window", 0, "live: only warm up on this much history before the end of the --warm-start capture (0 for all of it)")
+	fs.DurationVar(&o.FeedHealth.MaxLag, "feed-max-lag", 0, "live: fail over to the next endpoint when the smoothed delay from trade event time to receipt passes this (0 disables)")
+	fs.StringSliceVar(&o.Filter.Symbols, "filter-symbols", nil, "only let these symbols' trades reach the books, though every --symbol is streamed")
+	fs.Float64Var(&o.Filter.MinNotional, "filter-min-notional", 0, "drop trades worth less than this in the quote currency (0 keeps all)")
+	fs.Float64Var(&o.Filter.BandBps, "filter-band-bps", 0, "drop trades further than this many bps from the median of the symbol's recent trades (0 disables)")
+	fs.IntVar(&o.Filter.MedianWindow, "filter-median-window", 101, "recent trades the --filter-band-bps median is taken over")
+	fs.StringVar(&o.Consolidate, "nbbo", "", "consolidate the books of one instrument across venues, e.g. btc=btcusdt,btcfdusd;eth=ethusdt,ethfdusd")
+	fs.StringVar(&o.RulesFile, "rules", "", "JSON file of alert rules evaluated on every update")
+	fs.StringVar(&o.SinksFile, "alert-sinks", "", "JSON file of alert sinks (webhook, slack, telegram, email, pagerduty, opsgenie)")
+	fs.StringVar(&o.AlertLog, "alert-log", "", "append every alert fired, escalated or acknowledged to this JSON lines file")
+	fs.StringVar(&o.Strategy, "strategy", "", "run this registered strategy (mm, momentum or your own) against the feed; live, serve and replay paper trade it")
+	fs.DurationVar(&o.StrategyTimer, "strategy-timer", time.Second, "how often the strategy's OnTimer runs, in feed time (0 for never)")
+	fs.DurationVar(&o.StrategyLatency, "strategy-latency", 0, "feed time each strategy order takes to reach the book")
+	fs.DurationVar(&o.StrategyCancelLatency, "strategy-cancel-latency", 0, "feed time each strategy cancel takes to reach the book; the order can fill until then")
+	fs.BoolVar(&o.StrategyQueuePosition, "strategy-queue-position", false, "fill paper orders at their price only after the volume queued ahead of them has traded")
+	fs.StringVar(&o.StrategyMark, "strategy-mark", string(MarkLast), "price the strategy's open positions are valued at: last (trade) or mid")
+	o.tuning = registerTuningFlags(fs)
+}
+
+// markPrices reports whether the mark price stream is wanted.
+func (o *PipelineOptions) markPrices() bool {
+	return o.MarkPrice || o.MarkDeviation > 0 || o.FundingNotional != 0
+}
+
+// resolveClock settles ClockAuto for a command that replays a capture
+// (offline) or not.
+func (o *PipelineOptions) resolveClock(offline bool) {
+	if o.Clock == "" || o.Clock == ClockAuto {
+		o.Clock = ClockWall
+		if offline {
+			o.Clock = ClockEvent
+		}
+	}
+}
+
+// strategyOptions are the strategy settings given on the command line.
+func (o *PipelineOptions) strategyOptions(paper bool) StrategyOptions {
+	return StrategyOptions{
+		Timer:         o.StrategyTimer,
+		Paper:         paper,
+		EntryLatency:  o.StrategyLatency,
+		CancelLatency: o.StrategyCancelLatency,
+		QueuePosition: o.StrategyQueuePosition,
+		Mark:          MarkPrice(o.StrategyMark),
+	}
+}
+
+// OutputOptions configure the servers and sinks fed by a running pipeline.
+type OutputOptions struct {
+	GRPCAddr            string
+	APIAddr             string
+	MetricsAddr         string
+	DebugAddr           string
+	DumpDir             string
+	SessionFile         string
+	SessionSaveEvery    time.Duration
+	SessionLength       time.Duration
+	WALFile             string
+	WALCheckpointEvery  time.Duration
+	MemoryLogEvery      time.Duration
+	SummaryEvery        time.Duration
+	SummaryFile         string
+	SummarySinks        string
+	OpsSinks            string
+	OpsChecks           OpsChecks
+	DiscordToken        string
+	ExportDir           string
+	ExportFormat        string
+	ExportRotateSize    string
+	ExportRotateEvery   time.Duration
+	ExportSignalEvery   time.Duration
+	HeatmapDir          string
+	HeatmapEvery        time.Duration
+	HeatmapDepth        int
+	BookSnapshotDir     string
+	BookSnapshotEvery   time.Duration
+	BookSnapshotDepth   int
+	BookSnapshotRotate  time.Duration
+	ParquetDir          string
+	ParquetRotate       time.Duration
+	ParquetRowGroup     int
+	ParquetBookEvery    time.Duration
+	ParquetGzip         bool
+	KafkaBrokers        string
+	KafkaTrades         string
+	KafkaBook           string
+	KafkaSignals        string
+	KafkaBatch          int
+	KafkaAcks           int
+	NATSURL             string
+	NATSPrefix          string
+	NATSStream          string
+	MQTTURL             string
+	MQTTPrefix          string
+	MQTTQoS             int
+	MQTTRetain          bool
+	MQTTTypes           string
+	MQTTSignalEvery     time.Duration
+	RedisURL            string
+	RedisPrefix         string
+	InfluxURL           string
+	InfluxOrg           string
+	InfluxBucket        string
+	InfluxToken         string
+	InfluxSignalEvery   time.Duration
+	ClickHouseURL       string
+	ClickHouseDB        string
+	ClickHouseUser      string
+	ClickHousePassword  string
+	ClickHouseBatch     int
+	ClickHouseInterval  time.Duration
+	ClickHouseBookEvery time.Duration
+	ClickHouseCreate    bool
+	PostgresDSN         string
+	PostgresBookEvery   time.Duration
+	Store               string
+	CandleBackfill      time.Duration
+	OTelEndpoint        string
+	OTelSample          float64
+	OTelInterval        time.Duration
+	ClusterRegistry     string
+	ClusterAdvertise    string
+	Archive             ArchiveConfig
+}
+
+func (o *OutputOptions) register(fs *pflag.FlagSet) {
+	fs.StringVar(&o.GRPCAddr, "grpc-addr", "", "listen address for the gRPC MarketData service (e.g. :9090)")
+	fs.StringVar(&o.APIAddr, "api-addr", "", "listen address for the HTTP REST API, web dashboard, /ws event stream and /arrow IPC streams (e.g. :8080)")
+	fs.StringVar(&o.MetricsAddr, "metrics-addr", "", "listen address for the Prometheus /metrics endpoint (e.g. :9100)")
+	fs.StringVar(&o.DebugAddr, "debug-addr", "", "listen address for /debug/pprof and /debug/vars; keep it private (e.g. localhost:6060)")
+	fs.StringVar(&o.DumpDir, "dump-dir", ".", "directory for state dumps written on SIGUSR1 or POST /debug/dump")
+	fs.StringVar(&o.SessionFile, "session-file", "", "save VWAP, volume and candle aggregates here and resume them on a restart within the same session (disabled when empty)")
+	fs.DurationVar(&o.SessionSaveEvery, "session-save-interval", 10*time.Second, "how often the session file is saved; it is also saved on exit")
+	fs.DurationVar(&o.SessionLength, "session-length", 24*time.Hour, "trading session length, aligned to UTC midnight; a session file saved in an earlier session is not resumed")
+	fs.StringVar(&o.WALFile, "wal", "", "append every normalized feed event and periodic book checkpoints to this write-ahead log, for the verify command (disabled when empty)")
+	fs.DurationVar(&o.WALCheckpointEvery, "wal-checkpoint-interval", 10*time.Second, "how often every book is checkpointed to the WAL; a final checkpoint is written on exit")
+	fs.DurationVar(&o.MemoryLogEvery, "memory-log-interval", time.Minute, "how often heap and book sizes are logged (0 disables)")
+	fs.DurationVar(&o.SummaryEvery, "summary-interval", 0, "summarize volume, VWAP, high/low, spread and alert counts every period this long, e.g. 1h or 24h, aligned to UTC midnight (0 disables)")
+	fs.StringVar(&o.SummaryFile, "summary-file", "", "append each period summary to this file as a JSON line")
+	fs.StringVar(&o.SummarySinks, "summary-sinks", "", "comma-separated alert sinks from --alert-sinks to post each period summary to")
+	fs.StringVar(&o.OpsSinks, "ops-sinks", "", "comma-separated alert sinks from --alert-sinks to raise and resolve operational alerts on: feed down, depth gaps and subscriber backlog")
+	fs.DurationVar(&o.OpsChecks.Every, "ops-check-interval", 10*time.Second, "how often the operational checks run")
+	fs.DurationVar(&o.OpsChecks.FeedTimeout, "ops-feed-timeout", time.Minute, "raise feed_down after this long without a feed message (0 disables)")
+	fs.BoolVar(&o.OpsChecks.DepthGaps, "ops-depth-gaps", true, "raise depth_gaps while mirrored books see gaps in their depth updates")
+	fs.Float64Var(&o.OpsChecks.MaxBacklog, "ops-max-backlog", 0.8, "raise sink_backlog when an event subscriber's queue is this full, or it drops events (0 disables)")
+	fs.StringVar(&o.DiscordToken, "discord-token", os.Getenv("DISCORD_BOT_TOKEN"), "run a Discord bot with this token that answers !price, !vwap and !book in the channels it can read (defaults to $DISCORD_BOT_TOKEN)")
+	fs.StringVar(&o.ExportDir, "export-dir", "", "directory for trade and signal export files (disabled when empty)")
+	fs.StringVar(&o.ExportFormat, "export-format", "csv", "export file format: csv, jsonl or sbe")
+	fs.StringVar(&o.ExportRotateSize, "export-rotate-size", "100MB", "start a new export file after this size (0 disables)")
+	fs.DurationVar(&o.ExportRotateEvery, "export-rotate-interval", time.Hour, "start a new export file after this long (0 disables)")
+	fs.DurationVar(&o.ExportSignalEvery, "export-signal-interval", time.Second, "minimum spacing of exported signal snapshots per symbol")
+	fs.StringVar(&o.HeatmapDir, "heatmap-dir", "", "directory for <symbol>.heatmap depth samples, rendered with the heatmap command (disabled when empty)")
+	fs.DurationVar(&o.HeatmapEvery, "heatmap-interval", time.Second, "minimum spacing of depth samples per symbol, in event time")
+	fs.IntVar(&o.HeatmapDepth, "heatmap-depth", 200, "price levels sampled per book side")
+	fs.StringVar(&o.BookSnapshotDir, "book-snapshot-dir", "", "directory for time-indexed recordings of trades and sampled book snapshots, queried with the book-at command (disabled when empty)")
+	fs.DurationVar(&o.BookSnapshotEvery, "book-snapshot-interval", 100*time.Millisecond, "spacing of recorded book snapshots per symbol, in event time")
+	fs.IntVar(&o.BookSnapshotDepth, "book-snapshot-depth", publishedBookDepth, "price levels recorded per book side, at most 20")
+	fs.DurationVar(&o.BookSnapshotRotate, "book-snapshot-rotate-interval", time.Hour, "complete each book recording and start a new one after this long")
+	fs.StringVar(&o.ParquetDir, "parquet-dir", "", "directory for Parquet captures of trades, book snapshots and candles (disabled when empty)")
+	fs.DurationVar(&o.ParquetRotate, "parquet-rotate-interval", time.Hour, "complete each Parquet file and start a new one after this long")
+	fs.IntVar(&o.ParquetRowGroup, "parquet-row-group", 50000, "rows buffered per Parquet row group")
+	fs.DurationVar(&o.ParquetBookEvery, "parquet-book-interval", time.Second, "minimum spacing of captured book snapshots per symbol")
+	fs.BoolVar(&o.ParquetGzip, "parquet-gzip", true, "gzip-compress Parquet column data")
+	fs.StringVar(&o.KafkaBrokers, "kafka-brokers", "", "comma-separated Kafka bootstrap brokers (disabled when empty)")
+	fs.StringVar(&o.KafkaTrades, "kafka-topic-trades", "apexlob.trades", "Kafka topic for trades (empty disables)")
+	fs.StringVar(&o.KafkaBook, "kafka-topic-book", "apexlob.book", "Kafka topic for book deltas (empty disables)")
+	fs.StringVar(&o.KafkaSignals, "kafka-topic-signals", "apexlob.signals", "Kafka topic for signal snapshots (empty disables)")
+	fs.IntVar(&o.KafkaBatch, "kafka-batch", 500, "records buffered before a Kafka produce request")
+	fs.IntVar(&o.KafkaAcks, "kafka-acks", 1, "required acks: 0, 1 or -1 for all in-sync replicas")
+	fs.StringVar(&o.NATSURL, "nats-url", "", "NATS server to publish events to, e.g. nats://localhost:4222 (disabled when empty)")
+	fs.StringVar(&o.NATSPrefix, "nats-prefix", "apexlob", "NATS subject prefix; subjects are <prefix>.<symbol>.<type>")
+	fs.StringVar(&o.NATSStream, "nats-stream", "", "JetStream stream to persist published subjects in (empty for core NATS)")
+	fs.StringVar(&o.MQTTURL, "mqtt-url", "", "MQTT broker to publish events to, e.g. mqtt://localhost:1883 or mqtts:// (disabled when empty)")
+	fs.StringVar(&o.MQTTPrefix, "mqtt-prefix", "apexlob", "MQTT topic prefix; topics are <prefix>/<symbol>/<type>")
+	fs.IntVar(&o.MQTTQoS, "mqtt-qos", 0, "MQTT publish QoS: 0, 1 or 2")
+	fs.BoolVar(&o.MQTTRetain, "mqtt-retain", true, "publish retained messages so new subscribers see the latest values")
+	fs.StringVar(&o.MQTTTypes, "mqtt-types", "signal,candle", "comma-separated event types to publish over MQTT")
+	fs.DurationVar(&o.MQTTSignalEvery, "mqtt-signal-interval", time.Second, "minimum spacing of signal messages per symbol")
+	fs.StringVar(&o.RedisURL, "redis-url", "", "Redis server for pub/sub ticks and per-symbol state hashes, e.g. redis://localhost:6379/0 (disabled when empty)")
+	fs.StringVar(&o.RedisPrefix, "redis-prefix", "apexlob", "Redis key and channel prefix")
+	fs.StringVar(&o.InfluxURL, "influx-url", "", "InfluxDB v2 base URL for trades, candles and signals, e.g. http://localhost:8086 (disabled when empty)")
+	fs.StringVar(&o.InfluxOrg, "influx-org", "", "InfluxDB organization")
+	fs.StringVar(&o.InfluxBucket, "influx-bucket", "apexlob", "InfluxDB bucket")
+	fs.StringVar(&o.InfluxToken, "influx-token", os.Getenv("INFLUX_TOKEN"), "InfluxDB API token (defaults to $INFLUX_TOKEN)")
+	fs.DurationVar(&o.InfluxSignalEvery, "influx-signal-interval", time.Second, "minimum spacing of signal points per symbol")
+	fs.StringVar(&o.ClickHouseURL, "clickhouse-url", "", "ClickHouse HTTP interface for trade and book inserts, e.g. http://localhost:8123 (disabled when empty)")
+	fs.StringVar(&o.ClickHouseDB, "clickhouse-db", "default", "ClickHouse database")
+	fs.StringVar(&o.ClickHouseUser, "clickhouse-user", "", "ClickHouse user")
+	fs.StringVar(&o.ClickHousePassword, "clickhouse-password", os.Getenv("CLICKHOUSE_PASSWORD"), "ClickHouse password (defaults to $CLICKHOUSE_PASSWORD)")
+	fs.IntVar(&o.ClickHouseBatch, "clickhouse-batch", 10000, "rows per table buffered before an insert")
+	fs.DurationVar(&o.ClickHouseInterval, "clickhouse-flush-interval", 5*time.Second, "insert buffered rows at least this often")
+	fs.DurationVar(&o.ClickHouseBookEvery, "clickhouse-book-interval", time.Second, "minimum spacing of stored book snapshots per symbol")
+	fs.BoolVar(&o.ClickHouseCreate, "clickhouse-create", false, "create the ClickHouse tables from schema/clickhouse.sql on startup")
+	fs.StringVar(&o.PostgresDSN, "postgres-dsn", os.Getenv("APEXLOB_POSTGRES_DSN"), "Postgres/TimescaleDB connection string for persisting trades, executions and book snapshots (defaults to $APEXLOB_POSTGRES_DSN)")
+	fs.DurationVar(&o.PostgresBookEvery, "postgres-book-interval", 5*time.Second, "minimum spacing of stored book snapshots per symbol")
+	fs.StringVar(&o.Store, "store", "", "persistent store: sqlite://path.db (build with -tags sqlite) or postgres://...")
+	fs.DurationVar(&o.CandleBackfill, "candle-backfill", 0, "live: on startup, load this much one-minute candle history from the store and fetch the missing bars from Binance klines, so candle indicators start warm (0 disables)")
+	fs.StringVar(&o.OTelEndpoint, "otel-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OTLP/HTTP endpoint for traces and metrics, e.g. http://localhost:4318 (defaults to $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	fs.Float64Var(&o.OTelSample, "otel-sample", 0.1, "fraction of feed messages traced end to end")
+	fs.DurationVar(&o.OTelInterval, "otel-interval", 5*time.Second, "OTLP export interval")
+	fs.StringVar(&o.ClusterRegistry, "cluster-registry", "", "cluster gateway to register this instance's symbols with, e.g. http://gateway:8000 (requires --api-addr)")
+	fs.StringVar(&o.ClusterAdvertise, "cluster-advertise", "", "API URL the cluster gateway reaches this instance at (defaults to http://<hostname>:<api port>)")
+	o.Archive.register(fs)
+}
+
+// Monitor is a running pipeline: per-symbol state, the shard workers that
+// own it, and whatever servers and sinks were started around it. Feed
+// sources push into Shards and close it at the end of their input.
+type Monitor struct {
+	Start      time.Time
+	SymbolList []string
+	Symbols    *SymbolRegistry
+	Shards     *ShardSet
+	Stats      *TimingStats
+	Bus        *EventBus
+	Registry   *MetricsRegistry
+	Supervisor *Supervisor   // restarts the feed, workers and sinks if they panic
+	Rules      []*RuleEngine // one per shard
+	// MaxMessages stops the feed after that many messages; 0 for no limit
+	MaxMessages int
+
+	received  int64
+	errors    *errorCounters
+	pipelines map[string]*symbolPipeline
+	sinksMu   sync.Mutex // sinks are added while the metrics are served
+	sinks     []*SinkRunner
+	wal       *WALWriter        // nil unless StartWAL was called
+	candles   CandleStore       // nil unless the store keeps candles
+	nbbo      *ConsolidatedView // nil unless instruments are consolidated
+	refPrices bool              // mark and index prices are followed
+	funding   float64           // notional of the /funding projections
+	filter    *TradeFilter      // nil unless trades are filtered
+	alerts    *AlertDispatcher  // nil without alert sinks
+	alertLog  *AlertLog         // nil without --alert-log
+	strategy  *StrategyContext  // nil unless AttachStrategy was called
+	digest    *RegressionDigest // nil unless RecordDigest was called
+	account   *AccountTracker   // nil unless the account stream is followed
+	exchange  *Exchange         // nil unless OpenExchange was called
+	clock     *ClockSync        // corrects latencies from exchange event times
+	simClock  bool              // trades are stamped with event times; see Clock
+	closers   []func(ctx context.Context)
+	ctx       context.Context // done once Shutdown starts, for background loops
+	cancel    context.CancelFunc
+	// consoleEvents is told of reconnects while the console shows them
+	consoleEvents atomic.Pointer[ConsoleEvents]
+}
+
+// NewMonitor builds the pipeline state for opts. The shard workers are not
+// started until Run, so sinks and servers can be attached first.
+func NewMonitor(start time.Time, opts PipelineOptions) (*Monitor, error) {
+	var workerTuning WorkerTuning
+	if opts.tuning != nil {
+		var runtimeTuning RuntimeTuning
+		var err error
+		if runtimeTuning, workerTuning, err = opts.tuning.parse(); err != nil {
+			return nil, err
+		}
+		if err := runtimeTuning.Apply(); err != nil {
+			return nil, err
+		}
+	}
+
+	registry := NewMetricsRegistry()
+	m := &Monitor{
+		Start:      start,
+		SymbolList: splitList(strings.ToLower(opts.Symbols)),
+		Symbols:    NewSymbolRegistry(),
+		Bus:        NewEventBus(),
+		Registry:   registry,
+		Supervisor: NewSupervisor(registry),
+		errors:     newErrorCounters(registry),
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	if len(m.SymbolList) == 0 {
+		return nil, fmt.Errorf("no symbol to stream")
+	}
+	if opts.ClusterShard != "" {
+		owned, err := clusterShardSymbols(m.SymbolList, opts.ClusterShard)
+		if err != nil {
+			return nil, err
+		}
+		m.SymbolList = owned
+	}
+	modes, err := ParseBookModes(opts.BookModes, m.SymbolList)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Clock {
+	case "", ClockAuto, ClockWall:
+	case ClockEvent:
+		m.simClock = true
+	default:
+		return nil, fmt.Errorf("invalid --clock %q: want %s, %s or %s", opts.Clock, ClockAuto, ClockWall, ClockEvent)
+	}
+	mainLog := logger("main")
+	if opts.Filter.Enabled() {
+		cfg := opts.Filter
+		cfg.Symbols = splitList(strings.ToLower(strings.Join(cfg.Symbols, ",")))
+		for _, sym := range cfg.Symbols {
+			if !slices.Contains(m.SymbolList, sym) {
+				return nil, fmt.Errorf("filtered symbol %s is not streamed", sym)
+			}
+		}
+		m.filter = NewTradeFilter(cfg, registry)
+		mainLog.Info("filtering trades", "symbols", cfg.Symbols, "min_notional", cfg.MinNotional, "band_bps", cfg.BandBps)
+	}
+	tiers, err := signals.ParseDepthTiers(opts.ImbalanceTiers)
+	if err != nil {
+		return nil, fmt.Errorf("--imbalance-tiers: %w", err)
+	}
+	returnInterval, returnWindow := signals.DefaultReturnInterval, signals.DefaultReturnWindow
+	if opts.ReturnInterval > 0 {
+		returnInterval = opts.ReturnInterval
+	}
+	if opts.ReturnWindow > 0 {
+		returnWindow = opts.ReturnWindow
+	}
+	var derived []DerivedConfig
+	if opts.DerivedSignals != "" {
+		if derived, err = LoadDerivedSignals(opts.DerivedSignals); err != nil {
+			return nil, fmt.Errorf("--derived-signals: %w", err)
+		}
+	}
+	pluginPaths := splitList(opts.SignalPlugins)
+	plugins := make([]signals.Factory, len(pluginPaths))
+	for i, path := range pluginPaths {
+		if plugins[i], err = signals.LoadPlugin(path); err != nil {
+			return nil, err
+		}
+		mainLog.Info("loaded signal plugin", "path", path)
+	}
+	for _, sym := range m.SymbolList {
+		state := NewSymbolStateWithLimits(sym, opts.Limits)
+		state.Mode = modes[sym]
+		if opts.BookVWAPLevels > 0 {
+			state.Book.SetVWAPLevels(opts.BookVWAPLevels)
+		}
+		if opts.RangeWindow > 0 {
+			state.Range.SetWindow(opts.RangeWindow)
+		}
+		if opts.SignalHistory > 0 || opts.SignalHistoryStep > 0 {
+			step, length := signals.DefaultHistoryStep, signals.DefaultHistoryStep*signals.DefaultHistorySize
+			if opts.SignalHistoryStep > 0 {
+				step = opts.SignalHistoryStep
+			}
+			if opts.SignalHistory > 0 {
+				length = opts.SignalHistory
+			}
+			state.Signals.SetHistory(step, int(length/step))
+		}
+		if state.Mode == BookMirrored {
+			for _, w := range opts.QualityWindows {
+				signals.RegisterMarketQuality(state.Signals, w)
+			}
+		}
+		for _, w := range opts.RollWindows {
+			signals.RegisterRollSpread(state.Signals, w)
+		}
+		if err := signals.RegisterReturnStats(state.Signals, returnInterval, returnWindow, opts.VarianceHorizons); err != nil {
+			m.Close()
+			return nil, err
+		}
+		signals.RegisterDepthImbalance(state.Signals, tiers)
+		if opts.markPrices() {
+			registerMarkSignals(state)
+		}
+		if opts.FundingNotional != 0 {
+			registerFundingSignals(state, opts.FundingNotional)
+		}
+		// Before the model, which may take plugin signals as features
+		for i, f := range plugins {
+			if err := signals.RegisterFactory(state.Signals, f); err != nil {
+				m.Close()
+				return nil, fmt.Errorf("signal plugin %s: %w", pluginPaths[i], err)
+			}
+		}
+		// After the plugins, and before the models, which may take derived
+		// signals as features
+		if err := registerDerivedSignals(state, derived); err != nil {
+			m.Close()
+			return nil, err
+		}
+		if opts.DriftHorizon > 0 {
+			signals.RegisterDriftModel(state.Signals, parseFeatureList(opts.DriftFeatures), opts.DriftHorizon)
+		}
+		if opts.ONNXModel != "" {
+			// One model per symbol: the signal engines run on different
+			// shard goroutines and a session is not safe to share.
+			model, err := NewModelSignal(ModelConfig{
+				ModelPath:   opts.ONNXModel,
+				LibraryPath: opts.ONNXLib,
+				Features:    parseFeatureList(opts.ONNXFeatures),
+				AlertAbove:  opts.ONNXAlert,
+			})
+			if err != nil {
+				m.Close()
+				return nil, fmt.Errorf("failed to load model: %w", err)
+			}
+			m.onClose(func() { model.Close() })
+			state.Signals.Register(model)
+			mainLog.Info("loaded model", "path", opts.ONNXModel, "signal", model.Name(), "symbol", sym)
+		}
+		m.Symbols.Add(state)
+	}
+	if mirrored := m.MirroredSymbols(); len(mirrored) > 0 {
+		mainLog.Info("mirroring exchange depth", "symbols", mirrored)
+	}
+	groups, err := ParseConsolidatedGroups(opts.Consolidate, m.SymbolList)
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("invalid --nbbo: %w", err)
+	}
+	if len(groups) > 0 {
+		if m.nbbo, err = NewConsolidatedView(groups, m.Symbols); err != nil {
+			m.Close()
+			return nil, err
+		}
+		mainLog.Info("consolidating books across venues", "instruments", m.nbbo.Instruments())
+	}
+	m.Shards = NewShardSet(m.SymbolList, opts.Shards, opts.FeedQueue)
+	m.Shards.Tune(workerTuning)
+	m.Shards.Supervise(m.Supervisor)
+	m.Stats = NewTimingStats(start, m.Shards.Workers())
+
+	var alertHandlers []AlertHandler
+	if !opts.quietAlerts {
+		alertHandlers = append(alertHandlers, logAlert)
+	}
+	if opts.SinksFile != "" {
+		cfgs, err := LoadAlertSinks(opts.SinksFile)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to load alert sinks: %w", err)
+		}
+		dispatcher := NewAlertDispatcher()
+		m.alerts = dispatcher
+		m.onClose(dispatcher.Close)
+		for _, cfg := range cfgs {
+			sink, err := NewAlertSink(cfg)
+			if err != nil {
+				m.Close()
+				return nil, fmt.Errorf("invalid alert sink: %w", err)
+			}
+			dispatcher.AddSink(sink, cfg.RatePerMinute, cfg.MaxRetries)
+		}
+		alertHandlers = append(alertHandlers, dispatcher.Dispatch)
+		mainLog.Info("configured alert sinks", "count", len(cfgs), "file", opts.SinksFile)
+	}
+	if opts.AlertLog != "" {
+		if m.alertLog, err = OpenAlertLog(opts.AlertLog); err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to open alert log: %w", err)
+		}
+		m.onClose(func() { m.alertLog.Close() })
+		alertHandlers = append(alertHandlers, m.alertLog.Record)
+	}
+	var ruleConfigs []RuleConfig
+	if opts.RulesFile != "" {
+		cfgs, err := LoadRules(opts.RulesFile)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to load rules: %w", err)
+		}
+		ruleConfigs = cfgs
+		mainLog.Info("loaded alert rules", "count", len(cfgs), "file", opts.RulesFile)
+	}
+	if opts.MarkDeviation > 0 {
+		ruleConfigs = append(ruleConfigs, markDeviationRules(opts.MarkDeviation)...)
+	}
+	// Each shard evaluates rules for its own symbols with its own engine
+	m.Rules = make([]*RuleEngine, m.Shards.Workers())
+	for i := range m.Rules {
+		m.Rules[i] = NewRuleEngine()
+		for _, h := range alertHandlers {
+			m.Rules[i].OnAlert(h)
+		}
+		for _, cfg := range ruleConfigs {
+			if err := m.Rules[i].AddRule(cfg); err != nil {
+				m.Close()
+				return nil, fmt.Errorf("invalid rule: %w", err)
+			}
+		}
+	}
+
+	m.Registry.GaugeFunc("apexlob_message_rate", "Messages per second since the connection started.", func() []Sample {
+		return []Sample{{Labels: Labels{"symbol": m.SymbolList[0]}, Value: m.Stats.Snapshot().MessagesPerSecond}}
+	})
+	m.Registry.GaugeFunc("apexlob_latency_seconds", "Message latency quantiles by stage: processing from receipt, end_to_end from the exchange event time.", m.Stats.LatencySamples)
+	registerErrorMetrics(m)
+	m.clock = NewClockSync()
+	m.clock.registerMetrics(m.Registry)
+	RegisterMemoryMetrics(m.Registry, m.Symbols)
+	RegisterQualityMetrics(m.Registry, m.Symbols)
+	if opts.markPrices() {
+		registerReferencePriceMetrics(m.Registry, m.Symbols)
+		m.refPrices, m.funding = true, opts.FundingNotional
+	}
+	if m.nbbo != nil {
+		RegisterConsolidatedMetrics(m.Registry, m.nbbo)
+	}
+	if opts.AccountStream {
+		if opts.BinanceAPIKey == "" {
+			m.Close()
+			return nil, fmt.Errorf("--account-stream needs --binance-api-key or $BINANCE_API_KEY")
+		}
+		m.account = NewAccountTracker(m.Symbols, MarkLast)
+		registerAccountMetrics(m.Registry, m.account)
+	}
+	m.Registry.GaugeFunc("apexlob_feed_queue_depth", "Parsed feed messages waiting for a shard worker.", func() []Sample {
+		depths := m.Shards.QueueDepths()
+		samples := make([]Sample, len(depths))
+		for i, d := range depths {
+			samples[i] = Sample{Labels: Labels{"shard": strconv.Itoa(i)}, Value: float64(d)}
+		}
+		return samples
+	})
+	return m, nil
+}
+
+// onClose registers stop to run on Close.
+func (m *Monitor) onClose(stop func()) {
+	m.onShutdown(func(context.Context) { stop() })
+}
+
+// onShutdown registers stop to run on Shutdown, which should return early
+// once ctx is done.
+func (m *Monitor) onShutdown(stop func(ctx context.Context)) {
+	m.closers = append(m.closers, stop)
+}
+
+// StartOutputs starts the servers, tracer and sinks selected by opts.
+func (m *Monitor) StartOutputs(opts OutputOptions) error {
+	mainLog := logger("main")
+	listen := func(name, addr string, h http.Handler) {
+		// Requests are cancelled as shutdown starts, which is what ends the
+		// WebSocket and Arrow streams
+		srv := &http.Server{Addr: addr, Handler: h, BaseContext: func(net.Listener) context.Context { return m.ctx }}
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				mainLog.Error(name+" server stopped", "err", err)
+			}
+		}()
+		m.onShutdown(func(ctx context.Context) {
+			// Requests still running when ctx ends are cut off
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+			}
+		})
+	}
+	if opts.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Registry.Handler())
+		listen("metrics", opts.MetricsAddr, mux)
+		mainLog.Info("serving Prometheus metrics", "addr", opts.MetricsAddr, "path", "/metrics")
+	}
+
+	if opts.DebugAddr != "" {
+		PublishDebugVars(m.Snapshot, m.Bus)
+		mux := http.NewServeMux()
+		mux.Handle("/", NewDebugHandler())
+		mux.Handle("/debug/dump", m.DumpHandler(opts.DumpDir))
+		listen("debug", opts.DebugAddr, mux)
+		mainLog.Info("serving pprof, expvar and state dumps", "addr", opts.DebugAddr)
+	}
+
+	if opts.APIAddr != "" {
+		api := NewAPIServer(m.Symbols, m.Snapshot)
+		api.Handle("/metrics", m.Registry.Handler())
+		api.Handle("/ws", NewBroadcastServer(m.Symbols, m.Bus))
+		api.Handle("/arrow/", NewArrowStreamServer(m.Symbols, m.Bus, ArrowStreamConfig{}))
+		api.Handle("/alerts", m.AlertsHandler())
+		api.Handle("/alerts/", m.AlertsHandler())
+		if m.refPrices {
+			api.Handle("/funding", FundingHandler(m.Symbols, m.funding))
+			api.Handle("/funding/", FundingHandler(m.Symbols, m.funding))
+		}
+		if m.nbbo != nil {
+			api.Handle("/nbbo", m.nbbo.Handler())
+			api.Handle("/nbbo/", m.nbbo.Handler())
+		}
+		if m.strategy != nil {
+			api.Handle("/strategy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, m.strategy.Report())
+			}))
+			api.Handle("/strategy/pnl", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, m.strategy.PnL())
+			}))
+		}
+		if m.exchange != nil {
+			api.Handle("/orders", m.exchange.Handler())
+			api.Handle("/orders/", m.exchange.Handler())
+		}
+		if m.account != nil {
+			api.Handle("/account", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, m.account.Report())
+			}))
+		}
+		api.Handle("/", WebUIHandler())
+		listen("API", opts.APIAddr, api)
+		mainLog.Info("serving REST API and web dashboard", "addr", opts.APIAddr)
+	}
+
+	if opts.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", opts.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for gRPC: %w", err)
+		}
+		grpcServer := NewGRPCServer(m.Symbols, m.Bus)
+		if m.exchange != nil {
+			apexlobpb.RegisterOrderEntryServer(grpcServer, NewOrderEntryServer(m.exchange))
+		}
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				mainLog.Error("gRPC server stopped", "err", err)
+			}
+		}()
+		m.onShutdown(func(ctx context.Context) {
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+			}
+		})
+		mainLog.Info("serving gRPC MarketData", "addr", opts.GRPCAddr)
+	}
+
+	if opts.OTelEndpoint != "" {
+		t, err := NewTracer(OTelConfig{
+			Endpoint:    opts.OTelEndpoint,
+			ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+			SampleRatio: opts.OTelSample,
+			Interval:    opts.OTelInterval,
+		}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to start OpenTelemetry exporter: %w", err)
+		}
+		otelTracer = t
+		m.onClose(t.Close)
+		mainLog.Info("exporting traces and metrics over OTLP", "endpoint", opts.OTelEndpoint, "sample", opts.OTelSample)
+	}
+
+	m.DumpOnSignal(opts.DumpDir)
+
+	if opts.SessionFile != "" {
+		if opts.SessionLength <= 0 {
+			return fmt.Errorf("invalid --session-length %v", opts.SessionLength)
+		}
+		if err := m.PersistSession(opts.SessionFile, opts.SessionSaveEvery, opts.SessionLength); err != nil {
+			return fmt.Errorf("failed to resume session: %w", err)
+		}
+	}
+
+	// After the session is resumed, so the WAL header records the totals
+	// the books start from
+	if opts.WALFile != "" {
+		if err := m.StartWAL(opts.WALFile, opts.WALCheckpointEvery); err != nil {
+			return fmt.Errorf("failed to open WAL: %w", err)
+		}
+		mainLog.Info("logging feed events to WAL", "file", opts.WALFile)
+	}
+
+	if opts.ClusterRegistry != "" {
+		if opts.APIAddr == "" {
+			return errors.New("--cluster-registry requires --api-addr, which the gateway routes queries to")
+		}
+		self := opts.ClusterAdvertise
+		if self == "" {
+			var err error
+			if self, err = advertisedURL(opts.APIAddr); err != nil {
+				return err
+			}
+		}
+		joined := make(chan struct{})
+		go func() {
+			defer close(joined)
+			JoinCluster(m.ctx, clusterHTTPClient, opts.ClusterRegistry, self, m.SymbolList, clusterRenewEvery)
+		}()
+		m.onShutdown(func(ctx context.Context) {
+			// Deregistered, so the gateway stops routing here at once
+			select {
+			case <-joined:
+			case <-ctx.Done():
+			}
+		})
+		mainLog.Info("joining cluster", "registry", opts.ClusterRegistry, "advertise", self, "symbols", len(m.SymbolList))
+	}
+
+	if opts.MemoryLogEvery > 0 {
+		go LogMemory(m.ctx, logger("memory"), m.Symbols, opts.MemoryLogEvery)
+	}
+
+	if opts.SummaryEvery > 0 {
+		if err := m.StartSummaries(opts.SummaryEvery, opts.SummaryFile, splitList(opts.SummarySinks)); err != nil {
+			return err
+		}
+		mainLog.Info("scheduling period summaries", "interval", opts.SummaryEvery, "file", opts.SummaryFile, "sinks", opts.SummarySinks)
+	}
+
+	if opts.OpsSinks != "" {
+		if err := m.StartOpsChecks(opts.OpsChecks, splitList(opts.OpsSinks)); err != nil {
+			return err
+		}
+		mainLog.Info("running operational checks", "interval", opts.OpsChecks.Every, "sinks", opts.OpsSinks)
+	}
+
+	if opts.DiscordToken != "" {
+		bot := NewDiscordBot(opts.DiscordToken, NewBotCommands(m.Symbols))
+		go func() {
+			if err := bot.Run(m.ctx); err != nil {
+				logger("discord").Error("Discord bot stopped", "err", err)
+			}
+		}()
+	}
+
+	startSink := func(sink Sink, types []EventType, flushEvery time.Duration) {
+		runner := StartSink(m.Bus, sink, types, flushEvery, m.Supervisor)
+		m.sinksMu.Lock()
+		m.sinks = append(m.sinks, runner)
+		m.sinksMu.Unlock()
+		m.onShutdown(func(ctx context.Context) {
+			if err := runner.Shutdown(ctx); err != nil {
+				logger("sink").Error("failed to close sink", "sink", sink.Name(), "err", err)
+			}
+			logger("sink").Info("sink closed", "sink", sink.Name(), "written", runner.Written(), "failed", runner.Failed())
+		})
+	}
+
+	// The archiver is closed after the sinks, so it uploads the files
+	// they complete on the way out
+	var archiver *Archiver
+	if opts.Archive.URL != "" {
+		var err error
+		if archiver, err = NewArchiver(opts.Archive, m.Registry); err != nil {
+			return err
+		}
+		go archiver.Run()
+		m.onShutdown(archiver.Close)
+		mainLog.Info("archiving completed files", "url", opts.Archive.URL, "endpoint", opts.Archive.Endpoint)
+	}
+
+	if opts.ExportDir != "" {
+		rotateBytes, err := parseByteSize(opts.ExportRotateSize)
+		if err != nil {
+			return fmt.Errorf("invalid --export-rotate-size: %w", err)
+		}
+		exporter, err := NewFileExporter(ExportConfig{
+			Dir:            opts.ExportDir,
+			Format:         opts.ExportFormat,
+			RotateBytes:    rotateBytes,
+			RotateInterval: opts.ExportRotateEvery,
+			SignalInterval: opts.ExportSignalEvery,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create exporter: %w", err)
+		}
+		if archiver != nil {
+			archiver.Backlog(opts.ExportDir, []string{"trades", "signals"}, exporter.trades.ext)
+			exporter.OnComplete(archiver.Enqueue)
+		}
+		startSink(exporter, []EventType{EventTrade, EventSignal}, time.Second)
+		mainLog.Info("exporting trades and signals", "format", opts.ExportFormat, "dir", opts.ExportDir)
+	}
+
+	if m.nbbo != nil {
+		startSink(NewCrossedMarketWatcher(m.nbbo, m.Registry), []EventType{EventTrade, EventBook}, time.Second)
+	}
+
+	if opts.HeatmapDir != "" {
+		recorder, err := NewHeatmapRecorder(opts.HeatmapDir, opts.HeatmapEvery, opts.HeatmapDepth, m.Symbols)
+		if err != nil {
+			return fmt.Errorf("failed to create heatmap recorder: %w", err)
+		}
+		startSink(recorder, []EventType{EventBook}, time.Second)
+		mainLog.Info("recording depth heatmaps", "dir", opts.HeatmapDir, "interval", opts.HeatmapEvery, "depth", opts.HeatmapDepth)
+	}
+
+	if opts.BookSnapshotDir != "" {
+		recorder, err := NewBookRecorder(BookRecorderConfig{
+			Dir:            opts.BookSnapshotDir,
+			Interval:       opts.BookSnapshotEvery,
+			Depth:          opts.BookSnapshotDepth,
+			RotateInterval: opts.BookSnapshotRotate,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create book recorder: %w", err)
+		}
+		if archiver != nil {
+			archiver.Backlog(opts.BookSnapshotDir, []string{"books"}, ".apexc")
+			recorder.OnComplete(archiver.Enqueue)
+		}
+		startSink(recorder, []EventType{EventTrade, EventBook}, time.Second)
+		mainLog.Info("recording book snapshots", "dir", opts.BookSnapshotDir, "interval", opts.BookSnapshotEvery, "depth", opts.BookSnapshotDepth)
+	}
+
+	if opts.ParquetDir != "" {
+		sink, err := NewParquetSink(ParquetConfig{
+			Dir:            opts.ParquetDir,
+			RowGroupRows:   opts.ParquetRowGroup,
+			RotateInterval: opts.ParquetRotate,
+			BookInterval:   opts.ParquetBookEvery,
+			Compress:       opts.ParquetGzip,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create Parquet sink: %w", err)
+		}
+		if archiver != nil {
+			archiver.Backlog(opts.ParquetDir, []string{"trades", "book", "candles"}, ".parquet")
+			sink.OnComplete(archiver.Enqueue)
+		}
+		startSink(sink, []EventType{EventTrade, EventBook, EventCandle}, time.Second)
+		mainLog.Info("capturing Parquet files", "dir", opts.ParquetDir)
+	}
+
+	if opts.KafkaBrokers != "" {
+		topics := map[EventType]string{EventTrade: opts.KafkaTrades, EventBook: opts.KafkaBook, EventSignal: opts.KafkaSignals}
+		var types []EventType
+		for t, topic := range topics {
+			if topic != "" {
+				types = append(types, t)
+			}
+		}
+		sink, err := NewKafkaSink(KafkaConfig{
+			Brokers:   splitList(opts.KafkaBrokers),
+			Topics:    topics,
+			BatchSize: opts.KafkaBatch,
+			Acks:      int16(opts.KafkaAcks),
+		}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create Kafka sink: %w", err)
+		}
+		startSink(sink, types, 200*time.Millisecond)
+		mainLog.Info("publishing events to Kafka", "brokers", opts.KafkaBrokers)
+	}
+
+	if opts.NATSURL != "" {
+		sink, err := NewNATSSink(NATSConfig{URL: opts.NATSURL, Prefix: opts.NATSPrefix, Stream: opts.NATSStream}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create NATS sink: %w", err)
+		}
+		startSink(sink, nil, 100*time.Millisecond)
+		mainLog.Info("publishing events to NATS", "url", opts.NATSURL)
+	}
+
+	if opts.MQTTURL != "" {
+		var types []EventType
+		for _, t := range splitList(opts.MQTTTypes) {
+			types = append(types, EventType(t))
+		}
+		sink, err := NewMQTTSink(MQTTConfig{
+			URL:            opts.MQTTURL,
+			Prefix:         opts.MQTTPrefix,
+			QoS:            byte(opts.MQTTQoS),
+			Retain:         opts.MQTTRetain,
+			SignalInterval: opts.MQTTSignalEvery,
+		}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create MQTT sink: %w", err)
+		}
+		startSink(sink, types, 100*time.Millisecond)
+		mainLog.Info("publishing events to MQTT", "url", opts.MQTTURL, "types", opts.MQTTTypes, "qos", opts.MQTTQoS)
+	}
+
+	if opts.RedisURL != "" {
+		sink, err := NewRedisSink(RedisConfig{URL: opts.RedisURL, Prefix: opts.RedisPrefix}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create Redis sink: %w", err)
+		}
+		startSink(sink, []EventType{EventTrade, EventSignal}, 100*time.Millisecond)
+		mainLog.Info("publishing trades and signals to Redis", "url", opts.RedisURL)
+	}
+
+	if opts.InfluxURL != "" {
+		sink, err := NewInfluxSink(InfluxConfig{
+			URL:            opts.InfluxURL,
+			Org:            opts.InfluxOrg,
+			Bucket:         opts.InfluxBucket,
+			Token:          opts.InfluxToken,
+			SignalInterval: opts.InfluxSignalEvery,
+		}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create InfluxDB sink: %w", err)
+		}
+		startSink(sink, []EventType{EventTrade, EventCandle, EventSignal}, time.Second)
+		mainLog.Info("writing line protocol to InfluxDB", "url", opts.InfluxURL, "bucket", opts.InfluxBucket)
+	}
+
+	if opts.ClickHouseURL != "" {
+		sink, err := NewClickHouseSink(ClickHouseConfig{
+			URL:          opts.ClickHouseURL,
+			Database:     opts.ClickHouseDB,
+			User:         opts.ClickHouseUser,
+			Password:     opts.ClickHousePassword,
+			BatchSize:    opts.ClickHouseBatch,
+			BookInterval: opts.ClickHouseBookEvery,
+		}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to create ClickHouse sink: %w", err)
+		}
+		if opts.ClickHouseCreate {
+			if err := sink.CreateTables(); err != nil {
+				return fmt.Errorf("failed to create ClickHouse tables: %w", err)
+			}
+		}
+		startSink(sink, []EventType{EventTrade, EventBook}, opts.ClickHouseInterval)
+		mainLog.Info("inserting trades and book snapshots into ClickHouse", "url", opts.ClickHouseURL)
+	}
+
+	if opts.PostgresDSN != "" {
+		store, err := NewPostgresStore(PostgresConfig{DSN: opts.PostgresDSN, BookInterval: opts.PostgresBookEvery}, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to open Postgres store: %w", err)
+		}
+		startSink(store, []EventType{EventTrade, EventExecution, EventBook, EventCandle}, time.Second)
+		m.candles = store
+		mainLog.Info("persisting trades, executions, book snapshots and candles to Postgres")
+	}
+
+	if opts.Store != "" {
+		store, types, err := OpenStore(opts.Store, m.Symbols.List(), m.Snapshot, m.Registry)
+		if err != nil {
+			return fmt.Errorf("failed to open store: %w", err)
+		}
+		startSink(store, types, time.Second)
+		if candles, ok := store.(CandleStore); ok {
+			m.candles = candles
+		}
+		mainLog.Info("persisting to store", "store", opts.Store)
+	}
+	return nil
+}
+
+// Run builds a pipeline per symbol and starts the shard workers. The
+// returned channel is closed once the feed source has closed Shards and the
+// workers have drained their queues.
+func (m *Monitor) Run() <-chan struct{} {
+	m.pipelines = make(map[string]*symbolPipeline, len(m.SymbolList))
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		m.Registry.RegisterHistogram("apexlob_trade_notional", "Trades by notional, price times quantity in the quote currency.",
+			Labels{"symbol": sym}, state.TradeSizes)
+		m.pipelines[sym] = newSymbolPipeline(state, m.Bus,
+			NewMonitorMetrics(m.Registry, sym, state.Book, state.Signals),
+			NewPipelineTracer(otelTracer, m.Registry, sym),
+			m.Rules[m.Shards.Shard(sym)], m.Stats)
+		m.pipelines[sym].strategy = m.strategy
+		m.pipelines[sym].exchange = m.exchange
+		if m.digest != nil {
+			m.pipelines[sym].digest = m.digest.symbol(sym)
+		}
+		m.pipelines[sym].clockSync = m.clock
+		if m.simClock {
+			// A clock each, so how the shards interleave cannot change
+			// what a symbol's pipeline sees
+			m.pipelines[sym].clock = &SimClock{}
+		}
+	}
+	if len(m.SymbolList) > 1 {
+		logger("main").Info("sharding symbols", "symbols", len(m.SymbolList), "workers", m.Shards.Workers())
+	}
+
+	batchSizes := make([]*Histogram, m.Shards.Workers())
+	for i := range batchSizes {
+		batchSizes[i] = m.Registry.Histogram("apexlob_feed_batch_size", "Messages a shard worker drained from its queue per wakeup.",
+			Labels{"shard": strconv.Itoa(i)}, ExponentialBuckets(1, 2, 13))
+	}
+	return m.Shards.Run(func(shard int, batch []feed.Msg) {
+		batchSizes[shard].Observe(float64(len(batch)))
+		dispatchBatch(m.pipelines, shard, batch)
+	})
+}
+
+// AttachStrategy runs s after every trade the workers process. It must be
+// called before Run. Only paper strategies can trade mirrored books: a
+// mirrored book is replaced level by level from the exchange and would drop
+// orders resting in it.
+func (m *Monitor) AttachStrategy(s Strategy, opts StrategyOptions) (*StrategyContext, error) {
+	if mirrored := m.MirroredSymbols(); len(mirrored) > 0 && !opts.Paper {
+		return nil, fmt.Errorf("only paper strategies can trade mirrored books (%s)", strings.Join(mirrored, ", "))
+	}
+	mark, err := ParseMarkPrice(string(opts.Mark))
+	if err != nil {
+		return nil, err
+	}
+	opts.Mark = mark
+	m.strategy = newStrategyContext(s, m.Symbols, opts)
+	RegisterPnLMetrics(m.Registry, m.strategy.PnL)
+	return m.strategy, nil
+}
+
+// MirroredSymbols lists the symbols whose books mirror exchange depth.
+func (m *Monitor) MirroredSymbols() []string {
+	var mirrored []string
+	for _, sym := range m.SymbolList {
+		if state, _ := m.Symbols.Get(sym); state.Mode == BookMirrored {
+			mirrored = append(mirrored, sym)
+		}
+	}
+	return mirrored
+}
+
+// Reconnected counts a feed reconnection against every symbol.
+func (m *Monitor) Reconnected() {
+	m.errors.reconnects.Add(1)
+	for _, p := range m.pipelines {
+		p.metrics.Reconnects.Inc()
+	}
+	if c := m.consoleEvents.Load(); c != nil {
+		c.Add(time.Now(), "RECONNECT", "", fmt.Sprintf("feed reconnected, %d reconnects so far", m.errors.reconnects.Load()))
+	}
+}
+
+// admit counts a message read off the feed and reports whether it was the
+// first one and whether it is within MaxMessages. The feed stops at the
+// first message that is not.
+func (m *Monitor) admit(at time.Time) (first, ok bool) {
+	if n := atomic.AddInt64(&m.received, 1); m.MaxMessages > 0 && n > int64(m.MaxMessages) {
+		return false, false
+	}
+	return m.Stats.MessageReceived(at), true
+}
+
+// LimitReached reports whether the feed stopped at MaxMessages.
+func (m *Monitor) LimitReached() bool {
+	return m.MaxMessages > 0 && atomic.LoadInt64(&m.received) > int64(m.MaxMessages)
+}
+
+// CountAlerts tallies the alerts every rule engine raises from now on and
+// returns a function reading the counts, by rule and then symbol.
+func (m *Monitor) CountAlerts() func() map[string]map[string]int {
+	var mu sync.Mutex
+	counts := make(map[string]map[string]int)
+	// Shards evaluate rules concurrently
+	for _, rules := range m.Rules {
+		rules.OnAlert(func(e AlertEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if counts[e.Rule] == nil {
+				counts[e.Rule] = make(map[string]int)
+			}
+			counts[e.Rule][e.Symbol]++
+		})
+	}
+	return func() map[string]map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]map[string]int, len(counts))
+		for rule, bySymbol := range counts {
+			copied[rule] = make(map[string]int, len(bySymbol))
+			for sym, n := range bySymbol {
+				copied[rule][sym] = n
+			}
+		}
+		return copied
+	}
+}
+
+// sinkRunners returns the sinks started so far.
+func (m *Monitor) sinkRunners() []*SinkRunner {
+	m.sinksMu.Lock()
+	defer m.sinksMu.Unlock()
+	return slices.Clone(m.sinks)
+}
+
+// SinkCounts reports how many events each sink has written and failed to
+// write, by sink name.
+func (m *Monitor) SinkCounts() map[string]SinkCount {
+	sinks := m.sinkRunners()
+	counts := make(map[string]SinkCount, len(sinks))
+	for _, r := range sinks {
+		counts[r.Name()] = SinkCount{Written: r.Written(), Failed: r.Failed()}
+	}
+	return counts
+}
+
+// Close stops the sinks, servers and models in the reverse order they were
+// started. The feed source must have stopped first.
+func (m *Monitor) Close() {
+	m.Shutdown(context.Background())
+}
+
+// Shutdown is Close, cutting short whatever is still draining once ctx is
+// done: servers drop their remaining requests and sinks are left unflushed.
+// It returns ctx's error if ctx ended first.
+func (m *Monitor) Shutdown(ctx context.Context) error {
+	if m.ctx.Err() != nil {
+		return nil
+	}
+	m.cancel()
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		m.closers[i](ctx)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,351 @@
+package apexlob
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MQTT 3.1.1 (https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html),
+// implemented just far enough to publish at QoS 0, 1 or 2 and keep the
+// connection alive.
+
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttPubRec     = 5
+	mqttPubRel     = 6
+	mqttPubComp    = 7
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+)
+
+type MQTTConfig struct {
+	URL            string // mqtt://[user:pass@]host:port, or mqtts:// for TLS
+	Prefix         string // topics are <prefix>/<symbol>/<event type>
+	QoS            byte
+	Retain         bool // keep the last message per topic for new subscribers
+	ClientID       string
+	KeepAlive      time.Duration
+	Timeout        time.Duration
+	SignalInterval time.Duration // minimum spacing of signal messages per symbol
+}
+
+// MQTTSink publishes events as JSON on per-symbol, per-type topics for
+// small dashboards. At QoS 1 and 2 the broker's acknowledgements are read
+// asynchronously and counted.
+type MQTTSink struct {
+	cfg        MQTTConfig
+	addr       string
+	useTLS     bool
+	user       *url.Userinfo
+	mu         sync.Mutex // serializes writes between Write and the reader's PUBRELs
+	conn       net.Conn
+	w          *bufio.Writer
+	done       chan struct{}
+	lastWrite  time.Time
+	packetID   uint16
+	unacked    int64
+	lastSignal map[string]time.Time
+	published  *Counter
+	errors     *Counter
+}
+
+func NewMQTTSink(cfg MQTTConfig, reg *MetricsRegistry) (*MQTTSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "mqtt" && u.Scheme != "mqtts" && u.Scheme != "tcp") {
+		return nil, fmt.Errorf("mqtt: invalid url %q", cfg.URL)
+	}
+	if cfg.QoS > 2 {
+		return nil, fmt.Errorf("mqtt: QoS must be 0, 1 or 2, got %d", cfg.QoS)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "apexlob"
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = fmt.Sprintf("apexlob-%d", time.Now().UnixNano()%1e9)
+	}
+	if cfg.KeepAlive <= 0 {
+		cfg.KeepAlive = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	s := &MQTTSink{
+		cfg:        cfg,
+		addr:       u.Host,
+		useTLS:     u.Scheme == "mqtts",
+		user:       u.User,
+		lastSignal: make(map[string]time.Time),
+		published:  reg.Counter("apexlob_mqtt_published_total", "Messages published to MQTT (acknowledged, at QoS 1 and 2).", nil),
+		errors:     reg.Counter("apexlob_mqtt_errors_total", "MQTT publish and connection failures.", nil),
+	}
+	if u.Port() == "" {
+		port := "1883"
+		if s.useTLS {
+			port = "8883"
+		}
+		s.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return s, nil
+}
+
+func (s *MQTTSink) Name() string { return "mqtt" }
+
+func (s *MQTTSink) topic(e *Event) string {
+	return s.cfg.Prefix + "/" + e.Symbol + "/" + string(e.Type)
+}
+
+func (s *MQTTSink) Write(e *Event) error {
+	if e.Type == EventSignal {
+		if last, ok := s.lastSignal[e.Symbol]; ok && e.Timestamp.Sub(last) < s.cfg.SignalInterval {
+			return nil
+		}
+		s.lastSignal[e.Symbol] = e.Timestamp
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			s.errors.Inc()
+			return err
+		}
+	}
+
+	topic := s.topic(e)
+	var body []byte
+	body = appendMQTTString(body, topic)
+	if s.cfg.QoS > 0 {
+		if s.packetID++; s.packetID == 0 {
+			s.packetID = 1
+		}
+		body = binary.BigEndian.AppendUint16(body, s.packetID)
+	}
+	body = append(body, payload...)
+	flags := s.cfg.QoS << 1
+	if s.cfg.Retain {
+		flags |= 1
+	}
+	if err := s.packet(mqttPublish, flags, body); err != nil {
+		s.errors.Inc()
+		s.disconnect()
+		return err
+	}
+	if s.cfg.QoS > 0 {
+		atomic.AddInt64(&s.unacked, 1)
+	} else {
+		s.published.Inc()
+	}
+	return nil
+}
+
+// packet writes one control packet. Called with mu held.
+func (s *MQTTSink) packet(typ, flags byte, body []byte) error {
+	s.w.WriteByte(typ<<4 | flags)
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		if n /= 128; n > 0 {
+			b |= 0x80
+		}
+		s.w.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	_, err := s.w.Write(body)
+	s.lastWrite = time.Now()
+	return err
+}
+
+func appendMQTTString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// connect sends CONNECT and waits for the CONNACK before starting the
+// background reader. Called with mu held.
+func (s *MQTTSink) connect() error {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if s.useTLS {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	r := bufio.NewReader(conn)
+	s.conn, s.w = conn, bufio.NewWriter(conn)
+
+	flags := byte(0x02) // clean session
+	var payload []byte
+	payload = appendMQTTString(payload, s.cfg.ClientID)
+	if s.user != nil {
+		flags |= 0x80
+		payload = appendMQTTString(payload, s.user.Username())
+		if pass, ok := s.user.Password(); ok {
+			flags |= 0x40
+			payload = appendMQTTString(payload, pass)
+		}
+	}
+	var body []byte
+	body = appendMQTTString(body, "MQTT")
+	body = append(body, 4, flags) // protocol level 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(s.cfg.KeepAlive/time.Second))
+	body = append(body, payload...)
+	s.packet(mqttConnect, 0, body)
+	if err := s.w.Flush(); err != nil {
+		s.disconnect()
+		return err
+	}
+
+	typ, ack, err := readMQTTPacket(r)
+	if err == nil && (typ != mqttConnAck || len(ack) != 2) {
+		err = fmt.Errorf("mqtt: expected CONNACK, got packet type %d", typ)
+	} else if err == nil && ack[1] != 0 {
+		err = fmt.Errorf("mqtt: connection refused: %s", mqttConnectError(ack[1]))
+	}
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+
+	conn.SetDeadline(time.Time{})
+	s.done = make(chan struct{})
+	go s.read(conn, r, s.done)
+	return nil
+}
+
+func mqttConnectError(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorized"
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("mqtt: malformed remaining length")
+		}
+	}
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	return header >> 4, body, err
+}
+
+func (s *MQTTSink) read(conn net.Conn, r *bufio.Reader, done chan struct{}) {
+	defer close(done)
+	for {
+		typ, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case mqttPubAck, mqttPubComp:
+			atomic.AddInt64(&s.unacked, -1)
+			s.published.Inc()
+		case mqttPubRec:
+			s.mu.Lock()
+			if s.conn == conn && len(body) >= 2 {
+				s.packet(mqttPubRel, 0x02, body[:2])
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *MQTTSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.w = nil, nil
+		atomic.StoreInt64(&s.unacked, 0)
+	}
+}
+
+// Flush sends buffered publishes, plus a PINGREQ when the connection has
+// been idle for half the keep-alive period.
+func (s *MQTTSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	if time.Since(s.lastWrite) > s.cfg.KeepAlive/2 {
+		s.packet(mqttPingReq, 0, nil)
+	}
+	if err := s.w.Flush(); err != nil {
+		s.errors.Inc()
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+// Close flushes pending publishes, waits up to the timeout for outstanding
+// acknowledgements, then disconnects cleanly.
+func (s *MQTTSink) Close() error {
+	err := s.Flush()
+	deadline := time.Now().Add(s.cfg.Timeout)
+	for atomic.LoadInt64(&s.unacked) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	done := s.done
+	if s.w != nil {
+		s.packet(mqttDisconnect, 0, nil)
+		s.w.Flush()
+	}
+	s.disconnect()
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// mqttRecorder is a broker hook that keeps every PUBLISH the broker has
+// accepted, as its packet decoder parsed it.
+type mqttRecorder struct {
+	mqtt.HookBase
+	mu        sync.Mutex
+	published []packets.Packet
+}
+
+func (h *mqttRecorder) ID() string { return "recorder" }
+
+func (h *mqttRecorder) Provides(b byte) bool { return b == mqtt.OnPublished }
+
+func (h *mqttRecorder) OnPublished(cl *mqtt.Client, pk packets.Packet) {
+	h.mu.Lock()
+	h.published = append(h.published, pk)
+	h.mu.Unlock()
+}
+
+func (h *mqttRecorder) packets() []packets.Packet {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]packets.Packet(nil), h.published...)
+}
+
+// runMQTTBroker starts an in-process Mochi MQTT broker on a free port that
+// lets user dash, password secret, publish under apexlob/.
+func runMQTTBroker(t *testing.T) (*mqtt.Server, *mqttRecorder) {
+	t.Helper()
+	server := mqtt.New(&mqtt.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	err := server.AddHook(new(auth.Hook), &auth.Options{Ledger: &auth.Ledger{
+		Users: auth.Users{"dash": {Username: "dash", Password: "secret", ACL: auth.Filters{"apexlob/#": auth.WriteOnly}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := new(mqttRecorder)
+	if err := server.AddHook(recorder, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, recorder
+}
+
+func mqttBrokerAddr(server *mqtt.Server) string {
+	l, _ := server.Listeners.Get("tcp")
+	return l.Address()
+}
+
+func TestMQTTSinkQoS2(t *testing.T) {
+	server, recorder := runMQTTBroker(t)
+	reg := NewMetricsRegistry()
+	sink, err := NewMQTTSink(MQTTConfig{
+		URL:            "mqtt://dash:secret@" + mqttBrokerAddr(server),
+		QoS:            2,
+		Retain:         true,
+		SignalInterval: time.Second,
+	}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	events := []Event{
+		{Type: EventSignal, Symbol: "btcusdt", Timestamp: base, Signals: map[string]float64{"rsi_14": 55}},
+		{Type: EventSignal, Symbol: "btcusdt", Timestamp: base.Add(100 * time.Millisecond)}, // throttled
+		{Type: EventCandle, Symbol: "btcusdt", Timestamp: base, Candle: &Candle{Symbol: "btcusdt"}},
+		{Type: EventSignal, Symbol: "btcusdt", Timestamp: base.Add(time.Second), Signals: map[string]float64{"rsi_14": 61}},
+	}
+	for i := range events {
+		if err := sink.Write(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	published := recorder.packets()
+	want := []string{"apexlob/btcusdt/signal", "apexlob/btcusdt/candle", "apexlob/btcusdt/signal"}
+	if len(published) != len(want) {
+		t.Fatalf("broker accepted %d publishes, want %d", len(published), len(want))
+	}
+	for i, pk := range published {
+		if pk.TopicName != want[i] || pk.FixedHeader.Qos != 2 || !pk.FixedHeader.Retain || pk.PacketID == 0 {
+			t.Errorf("publish %d: topic %s qos %d retain %v id %d", i, pk.TopicName, pk.FixedHeader.Qos, pk.FixedHeader.Retain, pk.PacketID)
+		}
+		if !json.Valid(pk.Payload) {
+			t.Errorf("publish %d payload is not JSON: %q", i, pk.Payload)
+		}
+	}
+	if sink.published.Value() != 3 || sink.errors.Value() != 0 {
+		t.Errorf("published %d (PUBCOMPs), errors %d", sink.published.Value(), sink.errors.Value())
+	}
+
+	// The broker keeps the last message on each topic for new subscribers.
+	retained := server.Topics.Messages("apexlob/#")
+	if len(retained) != 2 {
+		t.Fatalf("retained %d messages, want signal and candle", len(retained))
+	}
+	for _, pk := range retained {
+		if pk.TopicName == "apexlob/btcusdt/signal" && !strings.Contains(string(pk.Payload), "61") {
+			t.Errorf("retained signal = %s, want the latest", pk.Payload)
+		}
+	}
+}
+
+func TestMQTTSinkConnectRefused(t *testing.T) {
+	server, _ := runMQTTBroker(t)
+	sink, err := NewMQTTSink(MQTTConfig{URL: "mqtt://dash:wrong@" + mqttBrokerAddr(server)}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt"})
+	if err == nil || !strings.Contains(err.Error(), "mqtt: connection refused") || sink.errors.Value() != 1 {
+		t.Errorf("Write error = %v, errors = %d", err, sink.errors.Value())
+	}
+	sink.Close()
+
+	if _, err := NewMQTTSink(MQTTConfig{URL: "mqtt://localhost", QoS: 3}, NewMetricsRegistry()); err == nil {
+		t.Error("expected error for QoS 3")
+	}
+	if _, err := NewMQTTSink(MQTTConfig{URL: "http://localhost"}, NewMetricsRegistry()); err == nil {
+		t.Error("expected error for non-MQTT scheme")
+	}
+}
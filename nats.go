@@ -0,0 +1,300 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NATS client protocol (https://docs.nats.io/reference/reference-protocols/nats-protocol),
+// implemented just far enough to publish, answer PINGs and read JetStream
+// publish acks.
+
+type NATSConfig struct {
+	URL     string // nats://[user:pass@|token@]host:port
+	Prefix  string // subjects are <prefix>.<symbol>.<event type>
+	Stream  string // JetStream stream to persist into; empty publishes core NATS only
+	Timeout time.Duration
+}
+
+// NATSSink publishes every event as JSON on a per-symbol, per-type subject.
+// With a stream configured, subjects are captured by JetStream and each
+// publish is acknowledged asynchronously.
+type NATSSink struct {
+	cfg       NATSConfig
+	addr      string
+	user      string
+	pass      string
+	token     string
+	inbox     string
+	mu        sync.Mutex // serializes writes between Write and the reader's PONGs
+	conn      net.Conn
+	w         *bufio.Writer
+	done      chan struct{}
+	unacked   int64
+	published *Counter
+	errors    *Counter
+}
+
+func NewNATSSink(cfg NATSConfig, reg *MetricsRegistry) (*NATSSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("nats: invalid url %q", cfg.URL)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "apexlob"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	s := &NATSSink{
+		cfg:       cfg,
+		addr:      u.Host,
+		inbox:     fmt.Sprintf("_INBOX.apexlob.%d", time.Now().UnixNano()),
+		published: reg.Counter("apexlob_nats_published_total", "Messages published to NATS (acknowledged, when using JetStream).", nil),
+		errors:    reg.Counter("apexlob_nats_errors_total", "NATS publish failures and negative JetStream acks.", nil),
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			s.user, s.pass = u.User.Username(), pass
+		} else {
+			s.token = u.User.Username()
+		}
+	}
+	return s, nil
+}
+
+func (s *NATSSink) Name() string { return "nats" }
+
+func (s *NATSSink) subject(e *Event) string {
+	return fmt.Sprintf("%s.%s.%s", s.cfg.Prefix, e.Symbol, e.Type)
+}
+
+func (s *NATSSink) Write(e *Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			s.errors.Inc()
+			return err
+		}
+	}
+
+	if s.cfg.Stream != "" {
+		fmt.Fprintf(s.w, "PUB %s %s.ack %d\r\n", s.subject(e), s.inbox, len(payload))
+		atomic.AddInt64(&s.unacked, 1)
+	} else {
+		fmt.Fprintf(s.w, "PUB %s %d\r\n", s.subject(e), len(payload))
+	}
+	s.w.Write(payload)
+	if _, err := s.w.WriteString("\r\n"); err != nil {
+		s.errors.Inc()
+		s.disconnect()
+		return err
+	}
+	if s.cfg.Stream == "" {
+		s.published.Inc()
+	}
+	return nil
+}
+
+// connect performs the handshake and, for JetStream, makes sure the stream
+// exists before starting the background reader. Called with mu held.
+func (s *NATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("nats: expected INFO from %s: %v", s.addr, err)
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "apexlob", "lang": "go", "version": "1", "protocol": 1}
+	if s.user != "" {
+		opts["user"], opts["pass"] = s.user, s.pass
+	}
+	if s.token != "" {
+		opts["auth_token"] = s.token
+	}
+	connect, _ := json.Marshal(opts)
+	fmt.Fprintf(w, "CONNECT %s\r\n", connect)
+	if s.cfg.Stream != "" {
+		fmt.Fprintf(w, "SUB %s.* 1\r\n", s.inbox)
+		cfg, _ := json.Marshal(map[string]interface{}{
+			"name":     s.cfg.Stream,
+			"subjects": []string{s.cfg.Prefix + ".>"},
+			"storage":  "file",
+		})
+		fmt.Fprintf(w, "PUB $JS.API.STREAM.CREATE.%s %s.create %d\r\n%s\r\n", s.cfg.Stream, s.inbox, len(cfg), cfg)
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// Wait for the PONG (and the stream reply) so auth errors surface here
+	pending := 1
+	if s.cfg.Stream != "" {
+		pending = 2
+	}
+	for pending > 0 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("nats: handshake: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			pending--
+		case strings.HasPrefix(line, "-ERR"):
+			conn.Close()
+			return fmt.Errorf("nats: %s", line)
+		case strings.HasPrefix(line, "MSG "):
+			payload, err := readNATSPayload(r, line)
+			if err != nil {
+				conn.Close()
+				return err
+			}
+			if err := jetStreamError(payload); err != nil && !strings.Contains(err.Error(), "10058") {
+				conn.Close()
+				return fmt.Errorf("nats: create stream %s: %w", s.cfg.Stream, err)
+			}
+			pending--
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	s.conn, s.w = conn, w
+	s.done = make(chan struct{})
+	go s.read(conn, r, s.done)
+	return nil
+}
+
+func (s *NATSSink) read(conn net.Conn, r *bufio.Reader, done chan struct{}) {
+	defer close(done)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PING":
+			s.mu.Lock()
+			if s.conn == conn {
+				s.w.WriteString("PONG\r\n")
+				s.w.Flush()
+			}
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "MSG "):
+			payload, err := readNATSPayload(r, line)
+			if err != nil {
+				return
+			}
+			atomic.AddInt64(&s.unacked, -1)
+			if err := jetStreamError(payload); err != nil {
+				if s.errors.Value() == 0 {
+					logger("nats").Error("JetStream rejected publish", "err", err)
+				}
+				s.errors.Inc()
+			} else {
+				s.published.Inc()
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			logger("nats").Error("server error", "line", line)
+			s.errors.Inc()
+		}
+	}
+}
+
+// readNATSPayload reads the body announced by a "MSG <subject> <sid> [reply] <#bytes>" line.
+func readNATSPayload(r *bufio.Reader, line string) ([]byte, error) {
+	fields := strings.Fields(line)
+	n, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return nil, fmt.Errorf("nats: bad MSG line %q", line)
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func jetStreamError(payload []byte) error {
+	var resp struct {
+		Error *struct {
+			Code        int    `json:"code"`
+			ErrCode     int    `json:"err_code"`
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return fmt.Errorf("nats: bad JetStream response %q", payload)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("%s (code %d, err_code %d)", resp.Error.Description, resp.Error.Code, resp.Error.ErrCode)
+	}
+	return nil
+}
+
+func (s *NATSSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.w = nil, nil
+		atomic.StoreInt64(&s.unacked, 0)
+	}
+}
+
+func (s *NATSSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	if err := s.w.Flush(); err != nil {
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+// Close flushes pending publishes and, for JetStream, waits up to the
+// timeout for outstanding acks before disconnecting.
+func (s *NATSSink) Close() error {
+	err := s.Flush()
+	deadline := time.Now().Add(s.cfg.Timeout)
+	for atomic.LoadInt64(&s.unacked) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	done := s.done
+	s.disconnect()
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"apexlob/pkg/orderbook"
+)
+
+// runNATSServer starts an in-process nats-server, the real server rather
+// than a fake, on a free port.
+func runNATSServer(t *testing.T, opts *server.Options) *server.Server {
+	t.Helper()
+	opts.Host, opts.Port = "127.0.0.1", -1
+	opts.NoLog, opts.NoSigs = true, true
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats-server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestNATSSinkCorePublish(t *testing.T) {
+	srv := runNATSServer(t, &server.Options{Authorization: "secret"})
+	nc, err := nats.Connect(srv.ClientURL(), nats.Token("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("apexlob.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Flush()
+
+	sink, err := NewNATSSink(NATSConfig{URL: "nats://secret@" + srv.Addr().String()}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{Symbol: "btcusdt", Price: 10}}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(&Event{Type: EventSignal, Symbol: "ethusdt"})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"apexlob.btcusdt.trade", "apexlob.ethusdt.signal"} {
+		msg, err := sub.NextMsg(2 * time.Second)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		var e Event
+		if msg.Subject != want || json.Unmarshal(msg.Data, &e) != nil || !strings.HasPrefix(msg.Subject, "apexlob."+e.Symbol+".") {
+			t.Errorf("received %s %q, want %s", msg.Subject, msg.Data, want)
+		}
+	}
+	if sink.published.Value() != 2 {
+		t.Errorf("published = %d, want 2", sink.published.Value())
+	}
+}
+
+func TestNATSSinkRejectedToken(t *testing.T) {
+	srv := runNATSServer(t, &server.Options{Authorization: "secret"})
+	sink, _ := NewNATSSink(NATSConfig{URL: "nats://wrong@" + srv.Addr().String()}, NewMetricsRegistry())
+	err := sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{}})
+	if err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("write with a bad token: %v", err)
+	}
+	if sink.errors.Value() != 1 {
+		t.Errorf("errors = %d, want 1", sink.errors.Value())
+	}
+}
+
+func TestNATSSinkJetStreamAcks(t *testing.T) {
+	srv := runNATSServer(t, &server.Options{JetStream: true, StoreDir: t.TempDir()})
+	cfg := NATSConfig{URL: "nats://" + srv.Addr().String(), Stream: "MD"}
+
+	sink, _ := NewNATSSink(cfg, NewMetricsRegistry())
+	for i := 0; i < 3; i++ {
+		if err := sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sink.published.Value() != 3 || sink.errors.Value() != 0 {
+		t.Errorf("acked = %d, errors = %d; want 3, 0", sink.published.Value(), sink.errors.Value())
+	}
+
+	// A second run finds the stream already there
+	again, _ := NewNATSSink(cfg, NewMetricsRegistry())
+	if err := again.Write(&Event{Type: EventSignal, Symbol: "ethusdt"}); err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := nc.JetStream()
+	info, err := js.StreamInfo("MD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 4 || len(info.Config.Subjects) != 1 || info.Config.Subjects[0] != "apexlob.>" || info.Config.Storage != nats.FileStorage {
+		t.Errorf("stream holds %d messages with config %+v, want 4 on apexlob.> in file storage", info.State.Msgs, info.Config)
+	}
+}
+
+func TestJetStreamError(t *testing.T) {
+	if err := jetStreamError([]byte(`{"stream":"MD","seq":4}`)); err != nil {
+		t.Errorf("ack reported as error: %v", err)
+	}
+	err := jetStreamError([]byte(`{"error":{"code":503,"err_code":10039,"description":"jetstream not enabled"}}`))
+	if err == nil || !strings.Contains(err.Error(), "10039") {
+		t.Errorf("error = %v, want err_code 10039", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,291 @@
+package apexlob
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// A consolidated view joins the books of one instrument traded on several
+// venues into a single best bid and offer, like a US equities NBBO. Each
+// venue is one of the monitor's books; every book here comes off the
+// Binance feed, so today a venue is a symbol quoting the same asset (e.g.
+// btcusdt and btcfdusd), but the view only needs a book per venue.
+
+// ConsolidatedGroup names an instrument and the symbols of its venues.
+type ConsolidatedGroup struct {
+	Instrument string
+	Venues     []string
+}
+
+// ParseConsolidatedGroups parses --nbbo, groups separated by semicolons of
+// instrument=symbol,symbol..., e.g. btc=btcusdt,btcfdusd;eth=ethusdt,ethfdusd.
+// Every symbol must be streamed and every group needs two venues.
+func ParseConsolidatedGroups(s string, symbols []string) ([]ConsolidatedGroup, error) {
+	var groups []ConsolidatedGroup
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(part, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid consolidated group %q, want instrument=symbol,symbol", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("instrument %s consolidated twice", name)
+		}
+		seen[name] = true
+		venues := splitList(strings.ToLower(list))
+		if len(venues) < 2 {
+			return nil, fmt.Errorf("instrument %s needs at least two venues", name)
+		}
+		for _, v := range venues {
+			if !slices.Contains(symbols, v) {
+				return nil, fmt.Errorf("instrument %s: symbol %s is not streamed", name, v)
+			}
+		}
+		groups = append(groups, ConsolidatedGroup{Instrument: name, Venues: venues})
+	}
+	return groups, nil
+}
+
+// VenueQuote is one venue's top of book.
+type VenueQuote struct {
+	Venue   string  `json:"venue"`
+	Bid     float64 `json:"bid,omitempty"`
+	BidSize uint32  `json:"bid_size,omitempty"`
+	Ask     float64 `json:"ask,omitempty"`
+	AskSize uint32  `json:"ask_size,omitempty"`
+}
+
+// ConsolidatedQuote is the best bid and offer across an instrument's
+// venues. Sizes add up every venue quoting the best price, and BidVenues
+// and AskVenues list them, largest first. The market is crossed when a
+// venue bids above another's offer, and locked when they are equal; the
+// spread is then zero or negative. It is zero while either side is empty.
+type ConsolidatedQuote struct {
+	Instrument string       `json:"instrument"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Bid        float64      `json:"bid,omitempty"`
+	BidSize    uint32       `json:"bid_size,omitempty"`
+	BidVenues  []string     `json:"bid_venues,omitempty"`
+	Ask        float64      `json:"ask,omitempty"`
+	AskSize    uint32       `json:"ask_size,omitempty"`
+	AskVenues  []string     `json:"ask_venues,omitempty"`
+	Spread     float64      `json:"spread"`
+	SpreadBps  float64      `json:"spread_bps"`
+	Crossed    bool         `json:"crossed"`
+	Locked     bool         `json:"locked"`
+	Venues     []VenueQuote `json:"venues"`
+}
+
+// TwoSided reports whether some venue bids and some venue offers.
+func (q *ConsolidatedQuote) TwoSided() bool { return q.BidSize > 0 && q.AskSize > 0 }
+
+type consolidatedVenue struct {
+	name string
+	book *orderbook.Book
+}
+
+// ConsolidatedView computes the consolidated quotes of its instruments from
+// their venues' books on demand, so it is always as fresh as the books.
+type ConsolidatedView struct {
+	groups []ConsolidatedGroup
+	venues map[string][]consolidatedVenue // by instrument
+}
+
+func NewConsolidatedView(groups []ConsolidatedGroup, symbols *SymbolRegistry) (*ConsolidatedView, error) {
+	v := &ConsolidatedView{groups: groups, venues: make(map[string][]consolidatedVenue, len(groups))}
+	for _, g := range groups {
+		for _, sym := range g.Venues {
+			state, ok := symbols.Get(sym)
+			if !ok {
+				return nil, fmt.Errorf("instrument %s: unknown symbol %s", g.Instrument, sym)
+			}
+			v.venues[g.Instrument] = append(v.venues[g.Instrument], consolidatedVenue{name: sym, book: state.Book})
+		}
+	}
+	return v, nil
+}
+
+// Instruments lists the consolidated instruments in configuration order.
+func (v *ConsolidatedView) Instruments() []string {
+	out := make([]string, len(v.groups))
+	for i, g := range v.groups {
+		out[i] = g.Instrument
+	}
+	return out
+}
+
+// InstrumentsOf lists the instruments symbol is a venue of.
+func (v *ConsolidatedView) InstrumentsOf(symbol string) []string {
+	var out []string
+	for _, g := range v.groups {
+		if slices.Contains(g.Venues, symbol) {
+			out = append(out, g.Instrument)
+		}
+	}
+	return out
+}
+
+// Quote returns instrument's consolidated quote.
+func (v *ConsolidatedView) Quote(instrument string) (ConsolidatedQuote, bool) {
+	venues, ok := v.venues[instrument]
+	if !ok {
+		return ConsolidatedQuote{}, false
+	}
+	q := ConsolidatedQuote{Instrument: instrument, Timestamp: time.Now(), Venues: make([]VenueQuote, len(venues))}
+	for i, venue := range venues {
+		vq := VenueQuote{Venue: venue.name}
+		if price, size, ok := venue.book.GetBestBid(); ok {
+			vq.Bid, vq.BidSize = price, size
+		}
+		if price, size, ok := venue.book.GetBestAsk(); ok {
+			vq.Ask, vq.AskSize = price, size
+		}
+		q.Venues[i] = vq
+	}
+	type side struct {
+		price  float64
+		size   uint32
+		venues []string
+	}
+	best := func(price func(VenueQuote) (float64, uint32), better func(a, b float64) bool) side {
+		var s side
+		for _, vq := range q.Venues {
+			p, size := price(vq)
+			switch {
+			case size == 0:
+			case s.size == 0 || better(p, s.price):
+				s = side{price: p, size: size, venues: []string{vq.Venue}}
+			case p == s.price:
+				s.size += size
+				s.venues = append(s.venues, vq.Venue)
+			}
+		}
+		// Largest first: the venue most of the best price rests on
+		slices.SortStableFunc(s.venues, func(a, b string) int {
+			return cmp.Compare(venueSize(q.Venues, b, price), venueSize(q.Venues, a, price))
+		})
+		return s
+	}
+	bid := best(func(vq VenueQuote) (float64, uint32) { return vq.Bid, vq.BidSize }, func(a, b float64) bool { return a > b })
+	ask := best(func(vq VenueQuote) (float64, uint32) { return vq.Ask, vq.AskSize }, func(a, b float64) bool { return a < b })
+	q.Bid, q.BidSize, q.BidVenues = bid.price, bid.size, bid.venues
+	q.Ask, q.AskSize, q.AskVenues = ask.price, ask.size, ask.venues
+	if q.TwoSided() {
+		q.Spread = q.Ask - q.Bid
+		q.SpreadBps = q.Spread / ((q.Ask + q.Bid) / 2) * 1e4
+		q.Crossed, q.Locked = q.Bid > q.Ask, q.Bid == q.Ask
+	}
+	return q, true
+}
+
+func venueSize(quotes []VenueQuote, venue string, price func(VenueQuote) (float64, uint32)) uint32 {
+	for _, vq := range quotes {
+		if vq.Venue == venue {
+			_, size := price(vq)
+			return size
+		}
+	}
+	return 0
+}
+
+// Quotes returns every instrument's consolidated quote.
+func (v *ConsolidatedView) Quotes() []ConsolidatedQuote {
+	out := make([]ConsolidatedQuote, 0, len(v.groups))
+	for _, g := range v.groups {
+		q, _ := v.Quote(g.Instrument)
+		out = append(out, q)
+	}
+	return out
+}
+
+// Handler serves GET /nbbo, every consolidated quote, and
+// GET /nbbo/{instrument}.
+func (v *ConsolidatedView) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		instrument := strings.Trim(strings.TrimPrefix(r.URL.Path, "/nbbo"), "/")
+		if instrument == "" {
+			writeJSON(w, v.Quotes())
+			return
+		}
+		q, ok := v.Quote(strings.ToLower(instrument))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown instrument "+strconv.Quote(instrument))
+			return
+		}
+		writeJSON(w, q)
+	})
+}
+
+// CrossedMarketWatcher is a sink that rechecks the consolidated quotes a
+// book update or trade can change and logs and counts every time one
+// becomes crossed, when a venue would sell below what another pays.
+type CrossedMarketWatcher struct {
+	view      *ConsolidatedView
+	reg       *MetricsRegistry
+	crossed   map[string]bool
+	crossings map[string]*Counter
+}
+
+func NewCrossedMarketWatcher(view *ConsolidatedView, reg *MetricsRegistry) *CrossedMarketWatcher {
+	w := &CrossedMarketWatcher{view: view, reg: reg, crossed: make(map[string]bool), crossings: make(map[string]*Counter)}
+	for _, instrument := range view.Instruments() {
+		w.crossings[instrument] = reg.Counter("apexlob_nbbo_crossings_total", "Times the consolidated market became crossed.", Labels{"instrument": instrument})
+	}
+	return w
+}
+
+func (w *CrossedMarketWatcher) Name() string { return "nbbo" }
+
+func (w *CrossedMarketWatcher) Write(e *Event) error {
+	for _, instrument := range w.view.InstrumentsOf(e.Symbol) {
+		q, _ := w.view.Quote(instrument)
+		if q.Crossed && !w.crossed[instrument] {
+			w.crossings[instrument].Inc()
+			logger("nbbo").Warn("crossed market", "instrument", instrument,
+				"bid", q.Bid, "bid_venues", q.BidVenues, "ask", q.Ask, "ask_venues", q.AskVenues)
+		}
+		w.crossed[instrument] = q.Crossed
+	}
+	return nil
+}
+
+func (w *CrossedMarketWatcher) Flush() error { return nil }
+func (w *CrossedMarketWatcher) Close() error { return nil }
+
+// Crossed reports whether instrument was crossed at the last event.
+func (w *CrossedMarketWatcher) Crossed(instrument string) bool { return w.crossed[instrument] }
+
+// RegisterConsolidatedMetrics exports each instrument's consolidated
+// spread and whether it is crossed or locked.
+func RegisterConsolidatedMetrics(reg *MetricsRegistry, view *ConsolidatedView) {
+	perQuote := func(fn func(q *ConsolidatedQuote) float64) func() []Sample {
+		return func() []Sample {
+			quotes := view.Quotes()
+			samples := make([]Sample, 0, len(quotes))
+			for i := range quotes {
+				if quotes[i].TwoSided() {
+					samples = append(samples, Sample{Labels: Labels{"instrument": quotes[i].Instrument}, Value: fn(&quotes[i])})
+				}
+			}
+			return samples
+		}
+	}
+	reg.GaugeFunc("apexlob_nbbo_spread_bps", "Consolidated best offer minus best bid across venues, in basis points of mid.", perQuote(func(q *ConsolidatedQuote) float64 { return q.SpreadBps }))
+	reg.GaugeFunc("apexlob_nbbo_crossed", "1 while a venue bids above another venue's offer.", perQuote(func(q *ConsolidatedQuote) float64 { return boolValue(q.Crossed) }))
+	reg.GaugeFunc("apexlob_nbbo_locked", "1 while the best bid on one venue equals the best offer on another.", perQuote(func(q *ConsolidatedQuote) float64 { return boolValue(q.Locked) }))
+}
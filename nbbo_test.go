@@ -0,0 +1,83 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestParseConsolidatedGroups(t *testing.T) {
+	symbols := []string{"btcusdt", "btcfdusd", "ethusdt", "ethfdusd"}
+	groups, err := ParseConsolidatedGroups("BTC=btcusdt, btcfdusd; eth=ethusdt,ethfdusd;", symbols)
+	if err != nil || len(groups) != 2 || groups[0].Instrument != "btc" || !slices.Equal(groups[0].Venues, []string{"btcusdt", "btcfdusd"}) {
+		t.Fatalf("groups = %+v, %v", groups, err)
+	}
+	for _, bad := range []string{"btc", "btc=btcusdt", "btc=btcusdt,solusdt", "btc=btcusdt,btcfdusd;btc=btcusdt,btcfdusd"} {
+		if _, err := ParseConsolidatedGroups(bad, symbols); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestConsolidatedView(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	books := make(map[string]*orderbook.Book)
+	for _, sym := range []string{"btcusdt", "btcfdusd", "btcusdc"} {
+		state := NewSymbolState(sym)
+		symbols.Add(state)
+		books[sym] = state.Book
+	}
+	id := uint64(0)
+	rest := func(sym string, side orderbook.Side, price float64, qty uint32) {
+		id++
+		books[sym].SubmitOrder(&orderbook.Order{ID: id, Price: price, Quantity: qty, Side: side})
+	}
+	rest("btcusdt", orderbook.Buy, 99, 10)
+	rest("btcusdt", orderbook.Sell, 101, 10)
+	rest("btcfdusd", orderbook.Buy, 99, 30)
+	rest("btcfdusd", orderbook.Sell, 100.5, 5)
+	rest("btcusdc", orderbook.Buy, 98, 100)
+
+	view, err := NewConsolidatedView([]ConsolidatedGroup{{Instrument: "btc", Venues: []string{"btcusdt", "btcfdusd", "btcusdc"}}}, symbols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, ok := view.Quote("btc")
+	if !ok || q.Bid != 99 || q.BidSize != 40 || !slices.Equal(q.BidVenues, []string{"btcfdusd", "btcusdt"}) {
+		t.Errorf("bid = %v x %d at %v", q.Bid, q.BidSize, q.BidVenues)
+	}
+	if q.Ask != 100.5 || q.AskSize != 5 || !slices.Equal(q.AskVenues, []string{"btcfdusd"}) || q.Spread != 1.5 || q.Crossed {
+		t.Errorf("ask = %v x %d at %v, spread %v", q.Ask, q.AskSize, q.AskVenues, q.Spread)
+	}
+
+	reg := NewMetricsRegistry()
+	watcher := NewCrossedMarketWatcher(view, reg)
+	watcher.Write(&Event{Type: EventBook, Symbol: "btcusdc"})
+	// A bid on one venue above another's offer crosses the market
+	rest("btcusdc", orderbook.Buy, 100.6, 1)
+	for i := 0; i < 2; i++ {
+		watcher.Write(&Event{Type: EventBook, Symbol: "btcusdc"})
+	}
+	if q, _ := view.Quote("btc"); !q.Crossed || q.SpreadBps >= 0 || !watcher.Crossed("btc") {
+		t.Errorf("quote %+v not crossed", q)
+	}
+	if n := watcher.crossings["btc"].Value(); n != 1 {
+		t.Errorf("counted %d crossings, want 1", n)
+	}
+
+	rec := httptest.NewRecorder()
+	view.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nbbo/BTC", nil))
+	var got ConsolidatedQuote
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.Bid != 100.6 || len(got.Venues) != 3 {
+		t.Errorf("GET /nbbo/BTC = %s (%v)", rec.Body, err)
+	}
+	rec = httptest.NewRecorder()
+	view.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nbbo/eth", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /nbbo/eth = %d", rec.Code)
+	}
+}
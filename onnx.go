@@ -0,0 +1,74 @@
+//go:build onnx
+
+package apexlob
+
+import (
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+var ortInit sync.Once
+var ortInitErr error
+
+type onnxScorer struct {
+	session *ort.AdvancedSession
+	input   *ort.Tensor[float32]
+	output  *ort.Tensor[float32]
+}
+
+func newModelScorer(cfg ModelConfig) (modelScorer, error) {
+	ortInit.Do(func() {
+		if cfg.LibraryPath != "" {
+			ort.SetSharedLibraryPath(cfg.LibraryPath)
+		}
+		ortInitErr = ort.InitializeEnvironment()
+	})
+	if ortInitErr != nil {
+		return nil, fmt.Errorf("initialize onnxruntime: %w", ortInitErr)
+	}
+
+	inputName, outputName := cfg.InputName, cfg.OutputName
+	if inputName == "" {
+		inputName = "input"
+	}
+	if outputName == "" {
+		outputName = "output"
+	}
+
+	input, err := ort.NewEmptyTensor[float32](ort.NewShape(1, int64(len(cfg.Features))))
+	if err != nil {
+		return nil, fmt.Errorf("create input tensor: %w", err)
+	}
+	output, err := ort.NewEmptyTensor[float32](ort.NewShape(1, 1))
+	if err != nil {
+		input.Destroy()
+		return nil, fmt.Errorf("create output tensor: %w", err)
+	}
+	session, err := ort.NewAdvancedSession(cfg.ModelPath,
+		[]string{inputName}, []string{outputName},
+		[]ort.Value{input}, []ort.Value{output}, nil)
+	if err != nil {
+		input.Destroy()
+		output.Destroy()
+		return nil, fmt.Errorf("load model %s: %w", cfg.ModelPath, err)
+	}
+
+	return &onnxScorer{session: session, input: input, output: output}, nil
+}
+
+func (s *onnxScorer) Score(features []float32) (float32, error) {
+	copy(s.input.GetData(), features)
+	if err := s.session.Run(); err != nil {
+		return 0, err
+	}
+	return s.output.GetData()[0], nil
+}
+
+func (s *onnxScorer) Close() error {
+	s.session.Destroy()
+	s.input.Destroy()
+	s.output.Destroy()
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !onnx
+
+package apexlob
+
+import "errors"
+
+func newModelScorer(cfg ModelConfig) (modelScorer, error) {
+	return nil, errors.New("ONNX support not compiled in; rebuild with -tags onnx")
+}
@@ -0,0 +1,187 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Operational checks, as named in OpsAlert.Check.
+const (
+	opsFeedDown    = "feed_down"
+	opsDepthGaps   = "depth_gaps"
+	opsSinkBacklog = "sink_backlog"
+)
+
+// OpsAlert is a problem with the monitor itself rather than the market: the
+// feed going quiet, gaps in the depth updates of mirrored books, or an
+// event subscriber falling behind. A check raises one when it starts
+// failing and sends it again with Resolved set once it passes.
+type OpsAlert struct {
+	Check     string    `json:"check"`
+	Severity  string    `json:"severity"`
+	Summary   string    `json:"summary"`
+	Source    string    `json:"source"` // the host the monitor runs on
+	Resolved  bool      `json:"resolved,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DedupKey identifies the incident a check raises, across its trigger and
+// its resolution.
+func (a OpsAlert) DedupKey() string {
+	return "apexlob:" + a.Source + ":" + a.Check
+}
+
+// OpsSink is an AlertSink that tracks operational alerts as incidents,
+// resolving them as well as raising them.
+type OpsSink interface {
+	SendOps(OpsAlert) error
+}
+
+// sendOps delivers alert to sink. A sink that only takes alert events is
+// sent the failure, as the event of a rule named after the check, and not
+// its resolution.
+func sendOps(sink AlertSink, alert OpsAlert) error {
+	if s, ok := sink.(OpsSink); ok {
+		return s.SendOps(alert)
+	}
+	if alert.Resolved {
+		return nil
+	}
+	return sink.Send(AlertEvent{Rule: alert.Check, Symbol: alert.Source, Expr: alert.Summary, Severity: alert.Severity, Timestamp: alert.Timestamp})
+}
+
+// OpsChecks configures the operational checks. A zero field turns its
+// check off.
+type OpsChecks struct {
+	// Every is how often the checks run
+	Every time.Duration
+	// FeedTimeout fails feed_down once no message has been processed for
+	// this long
+	FeedTimeout time.Duration
+	// DepthGaps fails depth_gaps on a check that finds gaps in the depth
+	// updates since the last one
+	DepthGaps bool
+	// MaxBacklog fails sink_backlog once a subscriber's queue is this full,
+	// as a fraction of its capacity, or it has dropped events since the
+	// last check
+	MaxBacklog float64
+}
+
+// opsChecker runs the checks against what the monitor reports.
+type opsChecker struct {
+	checks   OpsChecks
+	source   string
+	messages func() int
+	gaps     func() uint64
+	queues   func() []QueueStat
+	send     func(OpsAlert)
+
+	lastMessages int
+	progress     time.Time // when the message count last moved
+	lastGaps     uint64
+	dropped      map[string]uint64
+	failing      map[string]bool
+}
+
+func newOpsChecker(checks OpsChecks, source string, start time.Time) *opsChecker {
+	return &opsChecker{checks: checks, source: source, progress: start, dropped: make(map[string]uint64), failing: make(map[string]bool)}
+}
+
+// run checks every c.checks.Every until ctx is done.
+func (c *opsChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(c.checks.Every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.check(now)
+		}
+	}
+}
+
+// check runs every check once, sending an alert for each that changed
+// between failing and passing.
+func (c *opsChecker) check(now time.Time) {
+	if c.checks.FeedTimeout > 0 {
+		if n := c.messages(); n != c.lastMessages {
+			c.lastMessages, c.progress = n, now
+		}
+		quiet := now.Sub(c.progress)
+		c.set(now, opsFeedDown, "critical", quiet >= c.checks.FeedTimeout,
+			fmt.Sprintf("no feed messages for %s", quiet.Round(time.Second)), "feed messages are flowing again")
+	}
+	if c.checks.DepthGaps {
+		gaps := c.gaps()
+		n := gaps - c.lastGaps
+		c.lastGaps = gaps
+		c.set(now, opsDepthGaps, "error", n > 0,
+			fmt.Sprintf("%d gaps in depth updates, mirrored books are resyncing", n), "no gaps in depth updates since the last check")
+	}
+	if c.checks.MaxBacklog > 0 {
+		var behind []string
+		for _, q := range c.queues() {
+			dropped := q.Dropped - c.dropped[q.Name]
+			if q.Dropped < c.dropped[q.Name] {
+				dropped = q.Dropped // a subscriber of the same name came back
+			}
+			c.dropped[q.Name] = q.Dropped
+			if q.Capacity > 0 && float64(q.Depth) >= c.checks.MaxBacklog*float64(q.Capacity) || dropped > 0 {
+				name := q.Name
+				if name == "" {
+					name = "unnamed"
+				}
+				behind = append(behind, fmt.Sprintf("%s (%d/%d queued, %d dropped)", name, q.Depth, q.Capacity, dropped))
+			}
+		}
+		c.set(now, opsSinkBacklog, "error", len(behind) > 0,
+			"event subscribers falling behind: "+strings.Join(behind, ", "), "event subscribers have caught up")
+	}
+}
+
+func (c *opsChecker) set(now time.Time, check, severity string, failing bool, problem, recovery string) {
+	if failing == c.failing[check] {
+		return
+	}
+	c.failing[check] = failing
+	alert := OpsAlert{Check: check, Severity: severity, Summary: problem, Source: c.source, Timestamp: now}
+	if !failing {
+		alert.Summary, alert.Resolved = recovery, true
+	}
+	logger("ops").Warn("operational alert", "check", check, "resolved", alert.Resolved, "summary", alert.Summary)
+	c.send(alert)
+}
+
+// StartOpsChecks runs the operational checks until the monitor stops,
+// sending what they find to the named alert sinks.
+func (m *Monitor) StartOpsChecks(checks OpsChecks, sinks []string) error {
+	if checks.Every <= 0 {
+		return fmt.Errorf("invalid ops check interval %v", checks.Every)
+	}
+	if len(sinks) == 0 {
+		return fmt.Errorf("operational checks need --ops-sinks")
+	}
+	if m.alerts == nil {
+		return fmt.Errorf("ops sinks %s need --alert-sinks", strings.Join(sinks, ", "))
+	}
+	for _, name := range sinks {
+		if !m.alerts.HasSink(name) {
+			return fmt.Errorf("unknown alert sink %q for operational alerts", name)
+		}
+	}
+	source, err := os.Hostname()
+	if err != nil {
+		source = "apexlob"
+	}
+	c := newOpsChecker(checks, source, time.Now())
+	c.messages = func() int { n, _ := m.Stats.Totals(); return n }
+	c.gaps = m.errors.depthGaps.Value
+	c.queues = m.Bus.QueueStats
+	c.send = func(alert OpsAlert) { m.alerts.DispatchOps(alert, sinks) }
+	go m.Supervisor.Run(m.ctx, "ops-checks", func() { c.run(m.ctx) })
+	return nil
+}
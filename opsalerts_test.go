@@ -0,0 +1,66 @@
+package apexlob
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpsChecker(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := newOpsChecker(OpsChecks{Every: 10 * time.Second, FeedTimeout: 30 * time.Second, DepthGaps: true, MaxBacklog: 0.8}, "host1", start)
+	messages, gaps := 0, uint64(0)
+	queues := []QueueStat{{Name: "kafka", Capacity: 100}}
+	var sent []OpsAlert
+	c.messages = func() int { return messages }
+	c.gaps = func() uint64 { return gaps }
+	c.queues = func() []QueueStat { return queues }
+	c.send = func(alert OpsAlert) { sent = append(sent, alert) }
+	step := func(i int) time.Time { return start.Add(time.Duration(i) * 10 * time.Second) }
+
+	want := func(at string, alerts ...string) {
+		t.Helper()
+		var got []string
+		for _, a := range sent {
+			s := a.Check
+			if a.Resolved {
+				s += " resolved"
+			}
+			got = append(got, s)
+		}
+		if len(got) != len(alerts) {
+			t.Fatalf("%s: sent %v, want %v", at, got, alerts)
+		}
+		for i := range got {
+			if got[i] != alerts[i] {
+				t.Fatalf("%s: sent %v, want %v", at, got, alerts)
+			}
+		}
+		sent = nil
+	}
+
+	messages = 5
+	c.check(step(1))
+	want("flowing")
+	c.check(step(2))
+	c.check(step(3))
+	want("quiet for 20s")
+	c.check(step(4))
+	want("quiet for 30s", opsFeedDown)
+	c.check(step(5))
+	want("still quiet")
+	messages = 6
+	c.check(step(6))
+	want("flowing again", opsFeedDown+" resolved")
+
+	gaps = 2
+	queues[0].Depth = 80
+	c.check(step(7))
+	want("gaps and a backlog", opsDepthGaps, opsSinkBacklog)
+	queues[0].Depth, queues[0].Dropped = 10, 3
+	messages = 7
+	c.check(step(8))
+	want("drops", opsDepthGaps+" resolved")
+	messages = 8
+	c.check(step(9))
+	want("caught up", opsSinkBacklog+" resolved")
+}
@@ -0,0 +1,510 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal OpenTelemetry exporter speaking OTLP/HTTP with JSON encoding,
+// which Jaeger, Tempo and the OpenTelemetry Collector accept on :4318. Each
+// sampled feed message becomes a trace: a root span with one child per
+// pipeline stage, plus a span per sink write of the events it produced.
+// Metrics from the MetricsRegistry are exported alongside.
+
+type OTelConfig struct {
+	Endpoint    string // OTLP/HTTP base URL, e.g. http://localhost:4318
+	ServiceName string
+	SampleRatio float64 // fraction of messages traced
+	Interval    time.Duration
+}
+
+// SpanContext identifies a span so work on other goroutines can be
+// parented to it. The zero value means "not traced".
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+func (sc SpanContext) Valid() bool { return sc.SpanID != [8]byte{} }
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+// Span is one timed operation. All methods are no-ops on a nil *Span, which
+// is what an unsampled or disabled tracer hands out.
+type Span struct {
+	tracer *Tracer
+	name   string
+	kind   int
+	ctx    SpanContext
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  []spanAttr
+}
+
+const (
+	spanKindInternal = 1
+	spanKindConsumer = 5
+)
+
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s != nil {
+		s.attrs = append(s.attrs, spanAttr{key, value})
+	}
+}
+
+// Child starts a span under s.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(name, s.ctx)
+}
+
+func (s *Span) End() { s.EndAt(time.Now()) }
+
+func (s *Span) EndAt(t time.Time) {
+	if s == nil {
+		return
+	}
+	s.end = t
+	s.tracer.enqueue(s)
+}
+
+// Tracer samples, buffers and exports spans and metrics. A nil *Tracer is
+// valid and records nothing.
+type Tracer struct {
+	cfg      OTelConfig
+	client   *http.Client
+	reg      *MetricsRegistry
+	started  time.Time
+	spans    chan *Span
+	stop     chan struct{}
+	done     chan struct{}
+	exported *Counter
+	dropped  *Counter
+	errors   *Counter
+	failing  bool
+}
+
+const otelBatchSize = 512
+
+// otelTracer is set by main when -otel-endpoint is given; sink runners use
+// it to trace the writes of events from sampled messages.
+var otelTracer *Tracer
+
+func NewTracer(cfg OTelConfig, reg *MetricsRegistry) (*Tracer, error) {
+	if !strings.HasPrefix(cfg.Endpoint, "http://") && !strings.HasPrefix(cfg.Endpoint, "https://") {
+		return nil, fmt.Errorf("otel: endpoint must be an http(s) URL, got %q", cfg.Endpoint)
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "apexlob"
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	t := &Tracer{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		reg:      reg,
+		started:  time.Now(),
+		spans:    make(chan *Span, 8192),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		exported: reg.Counter("apexlob_otel_spans_exported_total", "Spans accepted by the OTLP endpoint.", nil),
+		dropped:  reg.Counter("apexlob_otel_spans_dropped_total", "Spans dropped because the export queue was full.", nil),
+		errors:   reg.Counter("apexlob_otel_export_errors_total", "Failed OTLP export requests.", nil),
+	}
+	go t.run()
+	return t, nil
+}
+
+// Start begins a span. With a valid parent the span joins the parent's
+// trace; otherwise it starts a new trace, subject to sampling.
+func (t *Tracer) Start(name string, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, name: name, kind: spanKindInternal, start: time.Now()}
+	if parent.Valid() {
+		s.ctx.TraceID = parent.TraceID
+		s.parent = parent.SpanID
+	} else {
+		if rand.Float64() >= t.cfg.SampleRatio {
+			return nil
+		}
+		s.kind = spanKindConsumer
+		putUint64(s.ctx.TraceID[:8], rand.Uint64())
+		putUint64(s.ctx.TraceID[8:], rand.Uint64())
+	}
+	for s.ctx.SpanID == [8]byte{} {
+		putUint64(s.ctx.SpanID[:], rand.Uint64())
+	}
+	return s
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		t.dropped.Inc()
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case s := <-t.spans:
+			if batch = append(batch, s); len(batch) >= otelBatchSize {
+				t.exportSpans(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.exportSpans(batch)
+			batch = nil
+			t.exportMetrics()
+		case <-t.stop:
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			t.exportSpans(batch)
+			t.exportMetrics()
+			return
+		}
+	}
+}
+
+// Close exports everything still queued.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+}
+
+func (t *Tracer) exportSpans(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = otlpSpan{
+			TraceID:    hex.EncodeToString(s.ctx.TraceID[:]),
+			SpanID:     hex.EncodeToString(s.ctx.SpanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      otlpTime(s.start),
+			End:        otlpTime(s.end),
+			Attributes: otlpAttrs(s.attrs),
+		}
+		if s.parent != [8]byte{} {
+			spans[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+	}
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   t.resource(),
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": otlpScope, "spans": spans}},
+		}},
+	}
+	if t.post("/v1/traces", body) {
+		t.exported.Add(uint64(len(batch)))
+	}
+}
+
+func (t *Tracer) exportMetrics() {
+	metrics := otlpMetrics(t.reg, t.started, time.Now())
+	if len(metrics) == 0 {
+		return
+	}
+	body := map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     t.resource(),
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": otlpScope, "metrics": metrics}},
+		}},
+	}
+	t.post("/v1/metrics", body)
+}
+
+func (t *Tracer) resource() map[string]interface{} {
+	return map[string]interface{}{"attributes": otlpAttrs([]spanAttr{{"service.name", t.cfg.ServiceName}})}
+}
+
+var otlpScope = map[string]string{"name": "apexlob"}
+
+func (t *Tracer) post(path string, body interface{}) bool {
+	payload, err := json.Marshal(body)
+	if err == nil {
+		var resp *http.Response
+		resp, err = t.client.Post(t.cfg.Endpoint+path, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+			}
+		}
+	}
+	if err != nil {
+		t.errors.Inc()
+		if !t.failing {
+			logger("otel").Error("export failed", "path", path, "err", err)
+		}
+		t.failing = true
+		return false
+	}
+	t.failing = false
+	return true
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         int            `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"` // int64 is a JSON string in OTLP
+	Double *float64 `json:"doubleValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+}
+
+func otlpAttrs(attrs []spanAttr) []otlpKeyValue {
+	out := make([]otlpKeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpAnyValue
+		switch x := a.value.(type) {
+		case string:
+			v.String = &x
+		case bool:
+			v.Bool = &x
+		case int:
+			s := strconv.Itoa(x)
+			v.Int = &s
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.Int = &s
+		case uint64:
+			// intValue is an int64; larger values go as strings rather
+			// than fail the whole export.
+			s := strconv.FormatUint(x, 10)
+			if x > math.MaxInt64 {
+				v.String = &s
+			} else {
+				v.Int = &s
+			}
+		case float64:
+			v.Double = &x
+		default:
+			s := fmt.Sprint(x)
+			v.String = &s
+		}
+		out = append(out, otlpKeyValue{Key: a.key, Value: v})
+	}
+	return out
+}
+
+func otlpTime(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+
+func otlpLabels(labels Labels) []otlpKeyValue {
+	attrs := make([]spanAttr, 0, len(labels))
+	for k, v := range labels {
+		attrs = append(attrs, spanAttr{k, v})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].key < attrs[j].key })
+	return otlpAttrs(attrs)
+}
+
+// otlpMetrics converts the registry to OTLP metrics with cumulative
+// temporality: counters as monotonic sums, gauges and gauge funcs as gauges,
+// histograms with their explicit bucket bounds.
+func otlpMetrics(reg *MetricsRegistry, start, now time.Time) []map[string]interface{} {
+	startNs, nowNs := otlpTime(start), otlpTime(now)
+	var out []map[string]interface{}
+	for _, f := range reg.sortedFamilies() {
+		var points []map[string]interface{}
+		point := func(labels Labels) map[string]interface{} {
+			return map[string]interface{}{"attributes": otlpLabels(labels), "startTimeUnixNano": startNs, "timeUnixNano": nowNs}
+		}
+		for _, s := range f.series {
+			p := point(s.labels)
+			switch v := s.value.(type) {
+			case *Counter:
+				p["asInt"] = strconv.FormatUint(v.Value(), 10)
+			case *Gauge:
+				p["asDouble"] = v.Value()
+			case *Histogram:
+				v.mu.Lock()
+				counts := make([]string, len(v.counts))
+				for i, c := range v.counts {
+					counts[i] = strconv.FormatUint(c, 10)
+				}
+				p["count"] = strconv.FormatUint(v.samples, 10)
+				p["sum"] = v.sum
+				v.mu.Unlock()
+				p["bucketCounts"] = counts
+				p["explicitBounds"] = v.bounds
+			}
+			points = append(points, p)
+		}
+		for _, fn := range f.funcs {
+			for _, sample := range fn() {
+				p := point(sample.Labels)
+				p["asDouble"] = sample.Value
+				points = append(points, p)
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		m := map[string]interface{}{"name": f.name, "description": f.help}
+		switch f.typ {
+		case "counter":
+			m["sum"] = map[string]interface{}{"aggregationTemporality": 2, "isMonotonic": true, "dataPoints": points}
+		case "gauge":
+			m["gauge"] = map[string]interface{}{"dataPoints": points}
+		case "histogram":
+			m["histogram"] = map[string]interface{}{"aggregationTemporality": 2, "dataPoints": points}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// PipelineTracer times the stages of each feed message, as child spans of a
+// per-message root span and as apexlob_stage_seconds observations. The
+// histograms are recorded for every message; spans only when sampled.
+type PipelineTracer struct {
+	tracer *Tracer
+	reg    *MetricsRegistry
+	labels Labels
+	mu     sync.Mutex
+	stages map[string]*Histogram
+}
+
+func NewPipelineTracer(tracer *Tracer, reg *MetricsRegistry, symbol string) *PipelineTracer {
+	return &PipelineTracer{tracer: tracer, reg: reg, labels: Labels{"symbol": symbol}, stages: make(map[string]*Histogram)}
+}
+
+func (p *PipelineTracer) histogram(stage string) *Histogram {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h, ok := p.stages[stage]
+	if !ok {
+		h = p.reg.Histogram("apexlob_stage_seconds", "Per-message latency of each pipeline stage.",
+			withLabel(p.labels, "stage", stage), ExponentialBuckets(1e-7, 2, 24))
+		p.stages[stage] = h
+	}
+	return h
+}
+
+// Begin starts timing a message received at start.
+func (p *PipelineTracer) Begin(start time.Time) MessageTrace {
+	root := p.tracer.Start("message", SpanContext{})
+	if root != nil {
+		root.start = start
+	}
+	return MessageTrace{p: p, root: root, at: start}
+}
+
+// MessageTrace tracks the stages of one message. Stage closes the current
+// stage and opens the next, so stages tile the message's processing time.
+type MessageTrace struct {
+	p     *PipelineTracer
+	root  *Span
+	stage string
+	span  *Span
+	at    time.Time
+}
+
+func (m *MessageTrace) Context() SpanContext { return m.root.Context() }
+
+func (m *MessageTrace) SetAttr(key string, value interface{}) { m.root.SetAttr(key, value) }
+
+func (m *MessageTrace) Stage(name string) {
+	now := time.Now()
+	m.finish(now)
+	m.stage = name
+	m.at = now
+	if m.root != nil {
+		m.span = m.root.Child(name)
+		m.span.start = now
+	}
+}
+
+// Record adds a stage that happened outside the processing loop, such as
+// network transit before the message was read.
+func (m *MessageTrace) Record(name string, start, end time.Time) {
+	if end.Before(start) {
+		return
+	}
+	m.p.histogram(name).Observe(end.Sub(start).Seconds())
+	if m.root != nil {
+		s := m.root.Child(name)
+		s.start = start
+		s.EndAt(end)
+		if start.Before(m.root.start) {
+			m.root.start = start
+		}
+	}
+}
+
+func (m *MessageTrace) finish(now time.Time) {
+	if m.stage == "" {
+		return
+	}
+	m.p.histogram(m.stage).Observe(now.Sub(m.at).Seconds())
+	m.span.EndAt(now)
+	m.stage, m.span = "", nil
+}
+
+func (m *MessageTrace) End() {
+	now := time.Now()
+	m.finish(now)
+	m.root.EndAt(now)
+}
@@ -0,0 +1,206 @@
+package apexlob
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// fakeCollector decodes exports with the OpenTelemetry Collector's own
+// OTLP/JSON unmarshalers, the code its OTLP/HTTP receiver runs.
+type fakeCollector struct {
+	mu      sync.Mutex
+	spans   []ptrace.Span
+	service []string
+	metrics []pmetric.Metric
+}
+
+func newFakeCollector(t *testing.T) (*fakeCollector, *httptest.Server) {
+	c := &fakeCollector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Content-Type = %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/traces":
+			traces, err := (&ptrace.JSONUnmarshaler{}).UnmarshalTraces(body)
+			if err != nil {
+				t.Errorf("bad OTLP traces: %v\n%s", err, body)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for i := 0; i < traces.ResourceSpans().Len(); i++ {
+				rs := traces.ResourceSpans().At(i)
+				name, _ := rs.Resource().Attributes().Get("service.name")
+				c.service = append(c.service, name.Str())
+				for j := 0; j < rs.ScopeSpans().Len(); j++ {
+					spans := rs.ScopeSpans().At(j).Spans()
+					for k := 0; k < spans.Len(); k++ {
+						c.spans = append(c.spans, spans.At(k))
+					}
+				}
+			}
+		case "/v1/metrics":
+			metrics, err := (&pmetric.JSONUnmarshaler{}).UnmarshalMetrics(body)
+			if err != nil {
+				t.Errorf("bad OTLP metrics: %v\n%s", err, body)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for i := 0; i < metrics.ResourceMetrics().Len(); i++ {
+				rm := metrics.ResourceMetrics().At(i)
+				for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+					ms := rm.ScopeMetrics().At(j).Metrics()
+					for k := 0; k < ms.Len(); k++ {
+						c.metrics = append(c.metrics, ms.At(k))
+					}
+				}
+			}
+		default:
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+	}))
+	return c, srv
+}
+
+func (c *fakeCollector) metric(name string) (pmetric.Metric, bool) {
+	for _, m := range c.metrics {
+		if m.Name() == name {
+			return m, true
+		}
+	}
+	return pmetric.Metric{}, false
+}
+
+func TestTracerExportsPipelineTrace(t *testing.T) {
+	collector, srv := newFakeCollector(t)
+	defer srv.Close()
+	reg := NewMetricsRegistry()
+	tracer, err := NewTracer(OTelConfig{Endpoint: srv.URL, ServiceName: "apexlob-test", SampleRatio: 1, Interval: time.Hour}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prev *Tracer) { otelTracer = prev }(otelTracer)
+	otelTracer = tracer
+	reg.Counter("apexlob_test_total", "Test counter.", Labels{"feed": "spot"}).Add(3)
+
+	bus := NewEventBus()
+	runner := StartSink(bus, &recordingSink{}, nil, time.Hour, nil)
+
+	pipeline := NewPipelineTracer(tracer, reg, "btcusdt")
+	start := time.Now()
+	msg := pipeline.Begin(start)
+	msg.SetAttr("trade_id", uint64(7))
+	msg.SetAttr("price", 64123.5)
+	msg.SetAttr("update_id", uint64(math.MaxUint64))
+	msg.Record("receive", start.Add(-5*time.Millisecond), start)
+	msg.Stage("match")
+	msg.Stage("publish")
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: start, trace: msg.Context()})
+	msg.End()
+
+	runner.Stop()
+	tracer.Close()
+
+	byName := make(map[string]ptrace.Span)
+	for _, s := range collector.spans {
+		byName[s.Name()] = s
+	}
+	root, ok := byName["message"]
+	if !ok || len(collector.spans) != 5 {
+		t.Fatalf("exported %d spans, want message and 4 children", len(collector.spans))
+	}
+	if len(collector.service) == 0 || collector.service[0] != "apexlob-test" {
+		t.Errorf("service.name = %v", collector.service)
+	}
+	if !root.ParentSpanID().IsEmpty() || root.TraceID().IsEmpty() || root.Kind() != ptrace.SpanKindConsumer {
+		t.Errorf("root span parent %v, trace %v, kind %v", root.ParentSpanID(), root.TraceID(), root.Kind())
+	}
+	if id, ok := root.Attributes().Get("trade_id"); !ok || id.Type() != pcommon.ValueTypeInt || id.Int() != 7 {
+		t.Errorf("trade_id = %v", id.AsRaw())
+	}
+	if price, ok := root.Attributes().Get("price"); !ok || price.Double() != 64123.5 {
+		t.Errorf("price = %v", price.AsRaw())
+	}
+	if id, ok := root.Attributes().Get("update_id"); !ok || id.Str() != "18446744073709551615" {
+		t.Errorf("update_id = %v, want the uint64 as a string", id.AsRaw())
+	}
+	for _, name := range []string{"receive", "match", "publish", "sink.write"} {
+		s, ok := byName[name]
+		if !ok || s.TraceID() != root.TraceID() || s.ParentSpanID() != root.SpanID() {
+			t.Errorf("span %s is not a child of %v", name, root.SpanID())
+		}
+	}
+	if root.StartTimestamp() != byName["receive"].StartTimestamp() || root.StartTimestamp().AsTime().UnixNano() != start.Add(-5*time.Millisecond).UnixNano() {
+		t.Error("root span should be extended back to the start of the receive stage")
+	}
+	if root.EndTimestamp() < root.StartTimestamp() {
+		t.Errorf("root span ends at %v, before it starts", root.EndTimestamp())
+	}
+
+	stage, ok := collector.metric("apexlob_stage_seconds")
+	if !ok || stage.Type() != pmetric.MetricTypeHistogram {
+		t.Fatal("apexlob_stage_seconds not exported as a histogram")
+	}
+	if stage.Histogram().AggregationTemporality() != pmetric.AggregationTemporalityCumulative {
+		t.Errorf("temporality = %v", stage.Histogram().AggregationTemporality())
+	}
+	points := stage.Histogram().DataPoints()
+	if points.Len() != 3 {
+		t.Fatalf("stage histogram has %d series, want receive, match and publish", points.Len())
+	}
+	for i := 0; i < points.Len(); i++ {
+		p := points.At(i)
+		if p.Count() != 1 || p.BucketCounts().Len() != p.ExplicitBounds().Len()+1 {
+			t.Errorf("point %d: count %d, %d buckets for %d bounds", i, p.Count(), p.BucketCounts().Len(), p.ExplicitBounds().Len())
+		}
+	}
+
+	counter, ok := collector.metric("apexlob_test_total")
+	if !ok || counter.Type() != pmetric.MetricTypeSum || !counter.Sum().IsMonotonic() {
+		t.Fatal("apexlob_test_total not exported as a monotonic sum")
+	}
+	p := counter.Sum().DataPoints().At(0)
+	if feed, _ := p.Attributes().Get("feed"); p.IntValue() != 3 || feed.Str() != "spot" {
+		t.Errorf("counter point = %d %v", p.IntValue(), p.Attributes().AsRaw())
+	}
+	if got := tracer.exported.Value(); got != 5 {
+		t.Errorf("exported counter = %d, want 5", got)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	var nilTracer *Tracer
+	if s := nilTracer.Start("message", SpanContext{}); s != nil {
+		t.Error("nil tracer should not create spans")
+	}
+	nilTracer.Close()
+
+	reg := NewMetricsRegistry()
+	tracer, err := NewTracer(OTelConfig{Endpoint: "http://127.0.0.1:1", SampleRatio: 0, Interval: time.Hour}, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+	msg := NewPipelineTracer(tracer, reg, "btcusdt").Begin(time.Now())
+	msg.Stage("match")
+	msg.End()
+	if msg.Context().Valid() {
+		t.Error("unsampled message should carry no trace")
+	}
+
+	if _, err := NewTracer(OTelConfig{Endpoint: "localhost:4318"}, reg); err == nil {
+		t.Error("expected error for endpoint without scheme")
+	}
+}
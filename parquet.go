@@ -0,0 +1,569 @@
+package apexlob
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// A minimal Parquet writer: flat schemas of required INT64, DOUBLE and UTF8
+// columns, one PLAIN-encoded data page per column chunk, optionally gzip
+// compressed. That is all the capture files need, and it keeps the Arrow and
+// Thrift dependency trees out of the binary; only the tests, which read the
+// files back with Arrow's Parquet reader, import them. Spec:
+// https://github.com/apache/parquet-format
+
+const parquetMagic = "PAR1"
+
+// Physical types, converted types, codecs and encodings from parquet.thrift
+const (
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	parquetUTF8            int32 = 0
+	parquetTimestampMicros int32 = 10
+
+	parquetUncompressed int32 = 0
+	parquetGzip         int32 = 2
+
+	parquetPlain int32 = 0
+	parquetRLE   int32 = 3
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 for none
+	values    bytes.Buffer
+	n         int
+}
+
+func (c *parquetColumn) Int64(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	c.values.Write(b[:])
+	c.n++
+}
+
+func (c *parquetColumn) Time(t time.Time) { c.Int64(t.UnixMicro()) }
+
+func (c *parquetColumn) Double(v float64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	c.values.Write(b[:])
+	c.n++
+}
+
+func (c *parquetColumn) String(s string) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(len(s)))
+	c.values.Write(b[:])
+	c.values.WriteString(s)
+	c.n++
+}
+
+// parquetTable buffers rows column by column until a row group is written.
+type parquetTable struct {
+	columns []*parquetColumn
+	byName  map[string]*parquetColumn
+}
+
+func newParquetTable() *parquetTable {
+	return &parquetTable{byName: make(map[string]*parquetColumn)}
+}
+
+func (t *parquetTable) add(name string, typ, converted int32) *parquetColumn {
+	c := &parquetColumn{name: name, typ: typ, converted: converted}
+	t.columns = append(t.columns, c)
+	t.byName[name] = c
+	return c
+}
+
+func (t *parquetTable) Int64Col(name string) *parquetColumn {
+	return t.add(name, parquetInt64, -1)
+}
+func (t *parquetTable) TimeCol(name string) *parquetColumn {
+	return t.add(name, parquetInt64, parquetTimestampMicros)
+}
+func (t *parquetTable) DoubleCol(name string) *parquetColumn {
+	return t.add(name, parquetDouble, -1)
+}
+func (t *parquetTable) StringCol(name string) *parquetColumn {
+	return t.add(name, parquetByteArray, parquetUTF8)
+}
+
+func (t *parquetTable) Col(name string) *parquetColumn { return t.byName[name] }
+
+func (t *parquetTable) Rows() int {
+	if len(t.columns) == 0 {
+		return 0
+	}
+	return t.columns[0].n
+}
+
+type parquetChunkMeta struct {
+	column           *parquetColumn
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+	numValues        int64
+}
+
+type parquetRowGroupMeta struct {
+	chunks    []parquetChunkMeta
+	totalSize int64
+	numRows   int64
+}
+
+// ParquetWriter streams row groups of a parquetTable to w and writes the
+// footer on Close. The file is unreadable until Close succeeds.
+type ParquetWriter struct {
+	w         io.Writer
+	offset    int64
+	table     *parquetTable
+	codec     int32
+	rowGroups []parquetRowGroupMeta
+}
+
+func NewParquetWriter(w io.Writer, table *parquetTable, compress bool) (*ParquetWriter, error) {
+	pw := &ParquetWriter{w: w, table: table, codec: parquetUncompressed}
+	if compress {
+		pw.codec = parquetGzip
+	}
+	return pw, pw.write([]byte(parquetMagic))
+}
+
+func (pw *ParquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+// WriteRowGroup flushes the table's buffered rows as one row group and resets
+// the columns.
+func (pw *ParquetWriter) WriteRowGroup() error {
+	rows := pw.table.Rows()
+	if rows == 0 {
+		return nil
+	}
+	rg := parquetRowGroupMeta{numRows: int64(rows)}
+	for _, c := range pw.table.columns {
+		if c.n != rows {
+			return fmt.Errorf("parquet column %s has %d values, want %d", c.name, c.n, rows)
+		}
+		raw := c.values.Bytes()
+		data := raw
+		if pw.codec == parquetGzip {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write(raw)
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		}
+
+		var h thriftWriter
+		h.i32(1, 0) // DATA_PAGE
+		h.i32(2, int32(len(raw)))
+		h.i32(3, int32(len(data)))
+		h.beginStruct(5)
+		h.i32(1, int32(rows))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.endStruct()
+		h.stop()
+
+		chunk := parquetChunkMeta{
+			column:           c,
+			offset:           pw.offset,
+			uncompressedSize: int64(len(h.buf) + len(raw)),
+			compressedSize:   int64(len(h.buf) + len(data)),
+			numValues:        int64(rows),
+		}
+		if err := pw.write(h.buf); err != nil {
+			return err
+		}
+		if err := pw.write(data); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+		rg.totalSize += chunk.uncompressedSize
+		c.values.Reset()
+		c.n = 0
+	}
+	pw.rowGroups = append(pw.rowGroups, rg)
+	return nil
+}
+
+// Close writes any buffered rows and the file footer.
+func (pw *ParquetWriter) Close() error {
+	if err := pw.WriteRowGroup(); err != nil {
+		return err
+	}
+
+	var numRows int64
+	for _, rg := range pw.rowGroups {
+		numRows += rg.numRows
+	}
+
+	var m thriftWriter
+	m.i32(1, 1) // version
+	m.listHeader(2, thriftStruct, len(pw.table.columns)+1)
+	m.beginElem()
+	m.binary(4, "schema")
+	m.i32(5, int32(len(pw.table.columns)))
+	m.endStruct()
+	for _, c := range pw.table.columns {
+		m.beginElem()
+		m.i32(1, c.typ)
+		m.i32(3, 0) // REQUIRED
+		m.binary(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, c.converted)
+		}
+		m.endStruct()
+	}
+	m.i64(3, numRows)
+	m.listHeader(4, thriftStruct, len(pw.rowGroups))
+	for _, rg := range pw.rowGroups {
+		m.beginElem()
+		m.listHeader(1, thriftStruct, len(rg.chunks))
+		for _, ch := range rg.chunks {
+			m.beginElem()
+			m.i64(2, ch.offset)
+			m.beginStruct(3)
+			m.i32(1, ch.column.typ)
+			m.listHeader(2, thriftI32, 2)
+			m.varint(zigzag(int64(parquetPlain)))
+			m.varint(zigzag(int64(parquetRLE)))
+			m.listHeader(3, thriftBinary, 1)
+			m.varint(uint64(len(ch.column.name)))
+			m.buf = append(m.buf, ch.column.name...)
+			m.i32(4, pw.codec)
+			m.i64(5, ch.numValues)
+			m.i64(6, ch.uncompressedSize)
+			m.i64(7, ch.compressedSize)
+			m.i64(9, ch.offset)
+			m.endStruct()
+			m.endStruct()
+		}
+		m.i64(2, rg.totalSize)
+		m.i64(3, rg.numRows)
+		m.endStruct()
+	}
+	m.binary(6, "apexlob")
+	m.stop()
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(m.buf)))
+	if err := pw.write(m.buf); err != nil {
+		return err
+	}
+	if err := pw.write(size[:]); err != nil {
+		return err
+	}
+	return pw.write([]byte(parquetMagic))
+}
+
+// Thrift compact protocol, just enough to encode the Parquet footer and page
+// headers.
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // previous field id per open struct; top-level is implicit
+}
+
+func zigzag(v int64) uint64 { return uint64((v << 1) ^ (v >> 63)) }
+
+func (t *thriftWriter) varint(v uint64) {
+	t.buf = binary.AppendUvarint(t.buf, v)
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	top := &t.last[len(t.last)-1]
+	if delta := id - *top; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(zigzag(int64(id)))
+	}
+	*top = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) listHeader(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+	} else {
+		t.buf = append(t.buf, 0xf0|elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+// beginElem starts a struct that is a list element (no field header).
+func (t *thriftWriter) beginElem() {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() { t.buf = append(t.buf, 0) }
+
+type ParquetConfig struct {
+	Dir            string
+	RowGroupRows   int
+	RotateInterval time.Duration
+	BookInterval   time.Duration // minimum spacing of book snapshots per symbol
+	Compress       bool
+}
+
+// parquetSeries is one table's sequence of rolled-over files.
+type parquetSeries struct {
+	prefix  string
+	table   *parquetTable
+	file    *os.File
+	bw      *bufio.Writer
+	pw      *ParquetWriter
+	path    string
+	started time.Time // first row of the current file
+}
+
+// ParquetSink captures trades, book snapshots and closed candles into
+// separate Parquet files that roll over on a fixed interval.
+type ParquetSink struct {
+	cfg      ParquetConfig
+	trades   *parquetSeries
+	books    *parquetSeries
+	candles  *parquetSeries
+	lastBook map[string]time.Time
+	now      func() time.Time
+	complete func(path string) // nil unless OnComplete was called
+}
+
+func NewParquetSink(cfg ParquetConfig) (*ParquetSink, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	if cfg.RowGroupRows <= 0 {
+		cfg.RowGroupRows = 50000
+	}
+
+	trades := newParquetTable()
+	trades.TimeCol("timestamp")
+	trades.StringCol("symbol")
+	trades.Int64Col("id")
+	trades.StringCol("side")
+	trades.DoubleCol("price")
+	trades.DoubleCol("quantity")
+
+	books := newParquetTable()
+	books.TimeCol("timestamp")
+	books.StringCol("symbol")
+	books.StringCol("side")
+	books.Int64Col("level")
+	books.DoubleCol("price")
+	books.Int64Col("volume")
+	books.Int64Col("orders")
+
+	candles := newParquetTable()
+	candles.TimeCol("open_time")
+	candles.StringCol("symbol")
+	candles.Int64Col("interval_seconds")
+	candles.DoubleCol("open")
+	candles.DoubleCol("high")
+	candles.DoubleCol("low")
+	candles.DoubleCol("close")
+	candles.DoubleCol("volume")
+	candles.Int64Col("trades")
+
+	return &ParquetSink{
+		cfg:      cfg,
+		trades:   &parquetSeries{prefix: "trades", table: trades},
+		books:    &parquetSeries{prefix: "book", table: books},
+		candles:  &parquetSeries{prefix: "candles", table: candles},
+		lastBook: make(map[string]time.Time),
+		now:      time.Now,
+	}, nil
+}
+
+func (s *ParquetSink) Name() string { return "parquet" }
+
+func (s *ParquetSink) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		t, tr := s.trades.table, e.Trade
+		t.Col("timestamp").Time(tr.Timestamp)
+		t.Col("symbol").String(tr.Symbol)
+		t.Col("id").Int64(int64(tr.ID))
+		t.Col("side").String(tr.Side.String())
+		t.Col("price").Double(tr.Price)
+		t.Col("quantity").Double(tr.Quantity)
+		return s.rowAdded(s.trades)
+	case EventBook:
+		if last, ok := s.lastBook[e.Symbol]; ok && e.Timestamp.Sub(last) < s.cfg.BookInterval {
+			return nil
+		}
+		s.lastBook[e.Symbol] = e.Timestamp
+		t := s.books.table
+		addSide := func(side string, levels []orderbook.PriceLevel) {
+			for i, lvl := range levels {
+				t.Col("timestamp").Time(e.Timestamp)
+				t.Col("symbol").String(e.Symbol)
+				t.Col("side").String(side)
+				t.Col("level").Int64(int64(i))
+				t.Col("price").Double(lvl.Price)
+				t.Col("volume").Int64(int64(lvl.Volume))
+				t.Col("orders").Int64(int64(lvl.Orders))
+			}
+		}
+		addSide("BID", e.Book.Bids)
+		addSide("ASK", e.Book.Asks)
+		return s.rowAdded(s.books)
+	case EventCandle:
+		t, c := s.candles.table, e.Candle
+		t.Col("open_time").Time(c.OpenTime)
+		t.Col("symbol").String(c.Symbol)
+		t.Col("interval_seconds").Int64(int64(c.Interval / time.Second))
+		t.Col("open").Double(c.Open)
+		t.Col("high").Double(c.High)
+		t.Col("low").Double(c.Low)
+		t.Col("close").Double(c.Close)
+		t.Col("volume").Double(c.Volume)
+		t.Col("trades").Int64(int64(c.Trades))
+		return s.rowAdded(s.candles)
+	}
+	return nil
+}
+
+func (s *ParquetSink) rowAdded(ps *parquetSeries) error {
+	if ps.started.IsZero() {
+		ps.started = s.now()
+	}
+	if ps.table.Rows() < s.cfg.RowGroupRows {
+		return nil
+	}
+	if err := s.open(ps); err != nil {
+		return err
+	}
+	return ps.pw.WriteRowGroup()
+}
+
+func (s *ParquetSink) open(ps *parquetSeries) error {
+	if ps.pw != nil {
+		return nil
+	}
+	ps.path = rotatedPath(s.cfg.Dir, ps.prefix, ".parquet", ps.started)
+	f, err := os.OpenFile(ps.path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	ps.file = f
+	ps.bw = bufio.NewWriterSize(f, 256*1024)
+	ps.pw, err = NewParquetWriter(ps.bw, ps.table, s.cfg.Compress)
+	return err
+}
+
+// finish writes buffered rows and the footer, completing the current file.
+func (s *ParquetSink) finish(ps *parquetSeries) error {
+	if ps.started.IsZero() {
+		return nil
+	}
+	if err := s.open(ps); err != nil {
+		return err
+	}
+	err := ps.pw.Close()
+	if ferr := ps.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := ps.file.Close(); err == nil {
+		err = cerr
+	}
+	ps.file, ps.bw, ps.pw = nil, nil, nil
+	ps.started = time.Time{}
+	if err == nil && s.complete != nil {
+		s.complete(ps.path)
+	}
+	return err
+}
+
+// OnComplete calls fn with each file the sink has finished writing.
+func (s *ParquetSink) OnComplete(fn func(path string)) { s.complete = fn }
+
+func (s *ParquetSink) series() []*parquetSeries {
+	return []*parquetSeries{s.trades, s.books, s.candles}
+}
+
+// Flush rolls over files that have reached the rotation interval. Rows stay
+// buffered otherwise, since Parquet favours large row groups.
+func (s *ParquetSink) Flush() error {
+	if s.cfg.RotateInterval <= 0 {
+		return nil
+	}
+	now := s.now()
+	for _, ps := range s.series() {
+		if !ps.started.IsZero() && now.Sub(ps.started) >= s.cfg.RotateInterval {
+			if err := s.finish(ps); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ParquetSink) Close() error {
+	var err error
+	for _, ps := range s.series() {
+		if ferr := s.finish(ps); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
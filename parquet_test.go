@@ -0,0 +1,210 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+	"github.com/apache/arrow/go/v17/parquet/file"
+	"github.com/apache/arrow/go/v17/parquet/pqarrow"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestParquetWriterLayout(t *testing.T) {
+	table := newParquetTable()
+	ts := table.TimeCol("timestamp")
+	sym := table.StringCol("symbol")
+	price := table.DoubleCol("price")
+	for i := 0; i < 3; i++ {
+		ts.Time(time.Unix(int64(i), 0))
+		sym.String("btcusdt")
+		price.Double(100.25 + float64(i))
+	}
+
+	var buf bytes.Buffer
+	pw, err := NewParquetWriter(&buf, table, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing PAR1 magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footerLen <= 0 || footerLen > len(data)-12 {
+		t.Fatalf("footer length %d out of range for %d byte file", footerLen, len(data))
+	}
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	for _, name := range []string{"timestamp", "symbol", "price"} {
+		if !bytes.Contains(footer, []byte(name)) {
+			t.Errorf("footer does not name column %s", name)
+		}
+	}
+
+	var want [8]byte
+	binary.LittleEndian.PutUint64(want[:], math.Float64bits(102.25))
+	if !bytes.Contains(data, want[:]) {
+		t.Error("PLAIN-encoded price value not found in file")
+	}
+	if table.Rows() != 0 {
+		t.Errorf("table not reset after row group, rows = %d", table.Rows())
+	}
+}
+
+// TestParquetReadByArrow reads files from the writer back with the Apache
+// Arrow Parquet reader, which checks the footer, page headers and values
+// against the format rather than against this writer's own idea of it.
+func TestParquetReadByArrow(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		table := newParquetTable()
+		ts := table.TimeCol("timestamp")
+		sym := table.StringCol("symbol")
+		id := table.Int64Col("id")
+		price := table.DoubleCol("price")
+
+		var buf bytes.Buffer
+		pw, err := NewParquetWriter(&buf, table, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := time.Date(2024, 1, 1, 0, 0, 0, 123456000, time.UTC)
+		for i := 0; i < 5; i++ {
+			ts.Time(base.Add(time.Duration(i) * time.Second))
+			sym.String([]string{"btcusdt", "ethusdt"}[i%2])
+			id.Int64(int64(1000 + i))
+			price.Double(100.25 + float64(i))
+			if i == 2 {
+				if err := pw.WriteRowGroup(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := pw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		rdr, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("compress=%v: arrow cannot open the file: %v", compress, err)
+		}
+		if rdr.NumRowGroups() != 2 || rdr.NumRows() != 5 {
+			t.Errorf("compress=%v: %d row groups, %d rows, want 2 and 5", compress, rdr.NumRowGroups(), rdr.NumRows())
+		}
+		fr, err := pqarrow.NewFileReader(rdr, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, err := fr.ReadTable(context.Background())
+		if err != nil {
+			t.Fatalf("compress=%v: arrow cannot read the file: %v", compress, err)
+		}
+
+		schema := tbl.Schema()
+		for i, want := range []arrow.DataType{
+			&arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"},
+			arrow.BinaryTypes.String,
+			arrow.PrimitiveTypes.Int64,
+			arrow.PrimitiveTypes.Float64,
+		} {
+			if got := schema.Field(i).Type; !arrow.TypeEqual(got, want) {
+				t.Errorf("compress=%v: column %s is %v, want %v", compress, schema.Field(i).Name, got, want)
+			}
+		}
+
+		rec := array.NewTableReader(tbl, 5)
+		row := 0
+		for rec.Next() {
+			r := rec.Record()
+			times := r.Column(0).(*array.Timestamp)
+			syms := r.Column(1).(*array.String)
+			ids := r.Column(2).(*array.Int64)
+			prices := r.Column(3).(*array.Float64)
+			for i := 0; i < int(r.NumRows()); i, row = i+1, row+1 {
+				if got, want := times.Value(i).ToTime(arrow.Microsecond), base.Add(time.Duration(row)*time.Second); !got.Equal(want) {
+					t.Errorf("compress=%v: row %d timestamp = %v, want %v", compress, row, got, want)
+				}
+				if got, want := syms.Value(i), []string{"btcusdt", "ethusdt"}[row%2]; got != want {
+					t.Errorf("compress=%v: row %d symbol = %q, want %q", compress, row, got, want)
+				}
+				if ids.Value(i) != int64(1000+row) || prices.Value(i) != 100.25+float64(row) {
+					t.Errorf("compress=%v: row %d = %d, %v", compress, row, ids.Value(i), prices.Value(i))
+				}
+			}
+		}
+		if row != 5 {
+			t.Errorf("compress=%v: read %d rows, want 5", compress, row)
+		}
+		rec.Release()
+		tbl.Release()
+	}
+}
+
+func TestThriftCompactFieldHeaders(t *testing.T) {
+	var w thriftWriter
+	w.i32(1, 5)
+	w.i64(20, -1) // delta > 15 uses the long form
+	w.stop()
+	want := []byte{0x15, 0x0a, 0x06, 0x28, 0x01, 0x00}
+	if !bytes.Equal(w.buf, want) {
+		t.Errorf("encoded % x, want % x", w.buf, want)
+	}
+}
+
+func TestParquetSinkRollsOver(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewParquetSink(ParquetConfig{Dir: dir, RowGroupRows: 2, RotateInterval: time.Minute, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.now = func() time.Time { return now }
+
+	trade := func() {
+		tr := &orderbook.Trade{Symbol: "btcusdt", ID: 1, Price: 1, Quantity: 1, Timestamp: now}
+		if err := sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: now, Trade: tr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trade()
+	trade()
+	trade()
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: now, Book: &BookSnapshot{
+		Bids: []orderbook.PriceLevel{{Price: 99, Volume: 1, Orders: 1}},
+		Asks: []orderbook.PriceLevel{{Price: 101, Volume: 2, Orders: 1}},
+	}})
+
+	now = now.Add(2 * time.Minute)
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	trade()
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	trades, _ := filepath.Glob(filepath.Join(dir, "trades-*.parquet"))
+	books, _ := filepath.Glob(filepath.Join(dir, "book-*.parquet"))
+	candles, _ := filepath.Glob(filepath.Join(dir, "candles-*.parquet"))
+	if len(trades) != 2 || len(books) != 1 || len(candles) != 0 {
+		t.Fatalf("trades = %v, books = %v, candles = %v", trades, books, candles)
+	}
+	for _, path := range append(trades, books...) {
+		data, _ := os.ReadFile(path)
+		if !bytes.HasSuffix(data, []byte("PAR1")) {
+			t.Errorf("%s has no footer", path)
+		}
+	}
+}
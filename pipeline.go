@@ -0,0 +1,392 @@
+package apexlob
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+var errUnknownSymbol = errors.New("message for an unexpected symbol")
+
+// ingestAggTrade parses an aggTrade message read off the feed at received
+// and routes it to the shard owning its symbol, logging it to wal first if
+// that is not nil. Trades that filter (if not nil) drops go no further.
+// Only the feed reader may call it.
+func ingestAggTrade(shards *ShardSet, msg []byte, trade *feed.AggTrade, received time.Time, wal *WALWriter, filter *TradeFilter) error {
+	if err := feed.ParseAggTrade(msg, trade); err != nil {
+		return err
+	}
+	if len(trade.Price) == 0 || len(trade.Quantity) == 0 {
+		return invalidf("missing required fields in message")
+	}
+	price, err := feed.ParseDecimal(trade.Price)
+	if err != nil {
+		return invalidf("invalid price %q: %w", trade.Price, err)
+	}
+	quantity, err := feed.ParseDecimal(trade.Quantity)
+	if err != nil {
+		return invalidf("invalid quantity %q: %w", trade.Quantity, err)
+	}
+	m := feed.Msg{
+		TradeID:    trade.TradeID,
+		Price:      price,
+		Quantity:   quantity,
+		BuyerMaker: trade.BuyerMaker,
+		EventMs:    trade.EventMs,
+		Received:   received,
+		Parsed:     time.Now(),
+	}
+	if wal != nil || filter != nil {
+		sym, ok := shards.Canonical(trade.Symbol)
+		if !ok {
+			return fmt.Errorf("%w: %q", errUnknownSymbol, trade.Symbol)
+		}
+		if filter != nil && filter.Check(sym, price, quantity) != "" {
+			return nil
+		}
+		m.Symbol = sym
+		if wal != nil {
+			wal.AppendEvent(&m)
+		}
+	}
+	if !shards.Push(trade.Symbol, &m) {
+		return fmt.Errorf("%w: %q", errUnknownSymbol, trade.Symbol)
+	}
+	return nil
+}
+
+// orderFromFeed turns a trade into the pooled order a synthetic book
+// submits for it at time at: the aggressor's side, price and quantity. A
+// verifying replay must build orders the same way.
+func orderFromFeed(m *feed.Msg, at time.Time) *orderbook.Order {
+	order := orderbook.AcquireOrder()
+	order.ID = m.TradeID
+	order.Price = m.Price
+	order.Quantity = orderbook.ScaleQuantity(m.Quantity)
+	order.Side = aggressorSide(m.BuyerMaker)
+	order.EntryTime = at
+	return order
+}
+
+// dispatchBatch splits a shard's batch into runs of consecutive messages for
+// one symbol, each matched under a single book lock.
+func dispatchBatch(pipelines map[string]*symbolPipeline, shard int, batch []feed.Msg) {
+	for len(batch) > 0 {
+		n := 1
+		for n < len(batch) && batch[n].Symbol == batch[0].Symbol {
+			n++
+		}
+		pipelines[batch[0].Symbol].processRun(shard, batch[:n])
+		batch = batch[n:]
+	}
+}
+
+// symbolPipeline is what a shard worker needs to process one symbol's
+// messages. Only the owning worker touches it after startup.
+type symbolPipeline struct {
+	state   *SymbolState
+	metrics *MonitorMetrics
+	tracer  *PipelineTracer
+	rules   *RuleEngine // shared by the symbols of one shard
+	stats   PipelineStats
+	bus     *EventBus
+
+	strategy  *StrategyContext // the monitor's, if one is attached
+	exchange  *Exchange        // the monitor's, if it is a venue
+	digest    *SymbolDigest    // records the outputs, if a digest is taken
+	clock     Clock            // stamps trades; the wall clock unless set
+	clockSync *ClockSync       // the monitor's; nil corrects nothing
+
+	// Of a warm start: the last historical trade and its event time, until
+	// the live feed passes it, and the live trades dropped meanwhile as
+	// already seen
+	warmedTo uint64
+	warmedMs int64
+	overlap  int
+	warming  bool // a historical run is being matched
+
+	// Scratch space for one run, reused between runs
+	orders []*orderbook.Order
+	rested []bool
+	trades []orderbook.Trade
+	traces []MessageTrace
+
+	// Trade IDs and traces of the sampled messages in the current run, so
+	// fills can be parented to the message whose order caused them
+	sampledIDs []uint64
+	sampled    []SpanContext
+}
+
+func newSymbolPipeline(state *SymbolState, bus *EventBus, metrics *MonitorMetrics, tracer *PipelineTracer, rules *RuleEngine, stats PipelineStats) *symbolPipeline {
+	p := &symbolPipeline{state: state, metrics: metrics, tracer: tracer, rules: rules, stats: stats, bus: bus, clock: WallClock{}}
+	state.Book.SetExecutionHandler(func(ex orderbook.Execution) {
+		if p.warming {
+			return
+		}
+		if p.strategy != nil {
+			p.strategy.execution(state.Symbol, &ex)
+		}
+		if p.exchange != nil {
+			p.exchange.execution(&ex)
+		}
+		if p.digest != nil {
+			p.digest.execution(&ex)
+		}
+		if bus.Wants(EventExecution) {
+			ex.Symbol = state.Symbol
+			PublishExecution(bus, &ex, p.traceFor(ex.TakerID))
+		}
+	})
+	return p
+}
+
+func (p *symbolPipeline) traceFor(takerID uint64) SpanContext {
+	for i, id := range p.sampledIDs {
+		if id == takerID {
+			return p.sampled[i]
+		}
+	}
+	return SpanContext{}
+}
+
+// processRun handles consecutive messages for the pipeline's symbol taken
+// from a shard queue in one wakeup. They are applied to the book under a
+// single lock (a synthetic book matches their orders, a mirrored one takes
+// its depth levels), then each trade goes through signals, publishing and
+// rules in turn, so during a burst signals see the book after the whole
+// run. Only mirrored books are sent depth.
+func (p *symbolPipeline) processRun(shard int, run []feed.Msg) {
+	for i := 1; i < len(run); i++ {
+		if run[i].Historical != run[0].Historical {
+			p.processRun(shard, run[:i])
+			p.processRun(shard, run[i:])
+			return
+		}
+	}
+	if len(run) > 0 && run[0].Historical {
+		p.warm(run)
+		return
+	}
+	if p.warmedTo > 0 {
+		if run = p.dropOverlap(run); len(run) == 0 {
+			return
+		}
+	}
+	if (p.strategy != nil || p.digest != nil) && len(run) > 1 {
+		// A strategy sees the book after every message, and its orders
+		// go in before the next one; a digest must not depend on how
+		// messages were batched
+		for i := range run {
+			p.processRun(shard, run[i:i+1])
+		}
+		return
+	}
+	if p.strategy != nil {
+		p.strategy.beforeMessage(&run[0])
+	}
+	state, ob, signals := p.state, p.state.Book, p.state.Signals
+	mirrored := state.Mode == BookMirrored
+	p.orders, p.rested, p.trades, p.traces = p.orders[:0], p.rested[:0], p.trades[:0], p.traces[:0]
+	p.sampledIDs, p.sampled = p.sampledIDs[:0], p.sampled[:0]
+
+	dequeued := time.Now()
+	for i := range run {
+		m := &run[i]
+		msg := p.tracer.Begin(m.Received)
+		msg.SetAttr("symbol", m.Symbol)
+		msg.SetAttr("trade_id", m.TradeID)
+		if m.EventMs > 0 {
+			msg.Record("receive", p.clockSync.Local(m.EventMs), m.Received)
+		}
+		msg.Record("parse", m.Received, m.Parsed)
+		msg.Record("queue", m.Parsed, dequeued)
+		if ctx := msg.Context(); ctx.Valid() {
+			p.sampledIDs = append(p.sampledIDs, m.TradeID)
+			p.sampled = append(p.sampled, ctx)
+		}
+		p.traces = append(p.traces, msg)
+
+		p.clock.Observe(m.EventMs)
+		tr := orderbook.Trade{
+			Symbol:    m.Symbol,
+			ID:        m.TradeID,
+			Price:     m.Price,
+			Quantity:  m.Quantity,
+			Side:      aggressorSide(m.BuyerMaker),
+			Timestamp: p.clock.Now(),
+		}
+		if !mirrored {
+			p.orders = append(p.orders, orderFromFeed(m, tr.Timestamp))
+		}
+		// Built now: a resting order may be filled or evicted, and so
+		// released, by a later order of the run
+		p.trades = append(p.trades, tr)
+	}
+
+	// Match the run; once an order rests the book owns it
+	matchStart := time.Now()
+	if mirrored {
+		ob.ApplyMirrored(run)
+	} else {
+		p.rested = ob.SubmitOrders(p.orders, p.rested)
+	}
+	matchEnd := time.Now()
+
+	for i := range run {
+		m, tr, msg := &run[i], p.trades[i], &p.traces[i]
+		msg.Record("match", matchStart, matchEnd)
+		if !mirrored {
+			if !p.rested[i] {
+				orderbook.ReleaseOrder(p.orders[i])
+			}
+			p.orders[i] = nil
+		}
+		if m.Kind != feed.KindTrade {
+			// A depth update counts as one message once all its levels
+			// are in
+			if m.Last {
+				msg.Stage("publish")
+				PublishBook(p.bus, state, tr.Timestamp, msg.Context())
+				if p.strategy != nil {
+					msg.Stage("strategy")
+					p.strategy.onMessage(m, nil)
+				}
+				msg.End()
+				p.observe(shard, m)
+			} else {
+				msg.End()
+			}
+			continue
+		}
+
+		// Update signals
+		msg.Stage("signals")
+		state.Tape.Add(tr)
+		state.Range.Add(tr.Price, tr.Timestamp)
+		state.TradeSizes.Observe(tr.Price * tr.Quantity)
+		signals.OnTrade(&tr, ob)
+		if p.digest != nil {
+			p.digest.trade(&tr, signals.Snapshot)
+		}
+		msg.Stage("publish")
+		PublishTradeEvents(p.bus, state, &tr, msg.Context())
+		msg.Stage("rules")
+		p.rules.Evaluate(m.Symbol, signals.Snapshot(), tr.Timestamp)
+		if p.strategy != nil {
+			msg.Stage("strategy")
+			p.strategy.onMessage(m, &tr)
+		}
+		msg.End()
+		p.observe(shard, m)
+	}
+	for i := range p.traces {
+		p.traces[i] = MessageTrace{}
+	}
+}
+
+// warm applies a run of history replayed by a warm start. The book, tape,
+// candles and signals take it, stamped with the exchange's event times so
+// their time windows span the history, but nothing is published, no rule or
+// strategy sees it and it is not counted as processed.
+func (p *symbolPipeline) warm(run []feed.Msg) {
+	state, ob := p.state, p.state.Book
+	_, wall := p.clock.(WallClock)
+	p.trades = p.trades[:0]
+	for i := range run {
+		m := &run[i]
+		tr := orderbook.Trade{
+			Symbol:   m.Symbol,
+			ID:       m.TradeID,
+			Price:    m.Price,
+			Quantity: m.Quantity,
+			Side:     aggressorSide(m.BuyerMaker),
+		}
+		// An event clock stamps history as it does any message; on the
+		// wall clock the event time is converted to local time
+		switch {
+		case !wall:
+			p.clock.Observe(m.EventMs)
+			tr.Timestamp = p.clock.Now()
+		case m.EventMs > 0:
+			tr.Timestamp = p.clockSync.Local(m.EventMs)
+		default:
+			tr.Timestamp = m.Received
+		}
+		p.trades = append(p.trades, tr)
+	}
+
+	p.warming = true
+	if state.Mode == BookMirrored {
+		ob.ApplyMirrored(run)
+	} else {
+		p.orders = p.orders[:0]
+		for i := range run {
+			p.orders = append(p.orders, orderFromFeed(&run[i], p.trades[i].Timestamp))
+		}
+		p.rested = ob.SubmitOrders(p.orders, p.rested[:0])
+		for i, order := range p.orders {
+			if !p.rested[i] {
+				orderbook.ReleaseOrder(order)
+			}
+			p.orders[i] = nil
+		}
+	}
+	p.warming = false
+	for i := range run {
+		m, tr := &run[i], &p.trades[i]
+		if m.Kind != feed.KindTrade {
+			continue
+		}
+		state.Tape.Add(*tr)
+		state.Range.Add(tr.Price, tr.Timestamp)
+		state.TradeSizes.Observe(tr.Price * tr.Quantity)
+		state.Signals.OnTrade(tr, ob)
+		state.Candles.Add(tr)
+		p.warmedTo = max(p.warmedTo, m.TradeID)
+		p.warmedMs = max(p.warmedMs, m.EventMs)
+	}
+}
+
+// dropOverlap removes the live trades a warm start already replayed, which
+// the exchange numbers no higher than the last of them, until the first
+// one it has not.
+func (p *symbolPipeline) dropOverlap(run []feed.Msg) []feed.Msg {
+	n := 0
+	for i := range run {
+		if m := &run[i]; p.warmedTo > 0 && m.Kind == feed.KindTrade {
+			if m.TradeID <= p.warmedTo {
+				p.overlap++
+				continue
+			}
+			var gap time.Duration
+			if m.EventMs > 0 && p.warmedMs > 0 {
+				gap = time.Duration(m.EventMs-p.warmedMs) * time.Millisecond
+			}
+			logger("pipeline").Info("live feed took over from warm start", "symbol", m.Symbol,
+				"last_warm_trade", p.warmedTo, "overlap", p.overlap, "gap", gap)
+			p.warmedTo, p.warmedMs, p.overlap = 0, 0, 0
+		}
+		run[n] = run[i]
+		n++
+	}
+	return run[:n]
+}
+
+// observe records a message's latency. Processing time runs from receipt
+// to the end of the pipeline, end-to-end latency from the exchange's event
+// time, converted to local time.
+func (p *symbolPipeline) observe(shard int, m *feed.Msg) {
+	msgEnd := time.Now()
+	elapsed := msgEnd.Sub(m.Received)
+	var endToEnd time.Duration
+	if m.EventMs > 0 {
+		endToEnd = msgEnd.Sub(p.clockSync.Local(m.EventMs))
+	}
+	p.metrics.Messages.Inc()
+	p.state.messages.Add(1)
+	p.metrics.Processing.Observe(elapsed.Seconds())
+	p.stats.MessageProcessed(shard, elapsed, endToEnd)
+}
@@ -0,0 +1,58 @@
+package apexlob
+
+import (
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+func TestSymbolPipelineProcessRun(t *testing.T) {
+	reg := NewMetricsRegistry()
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(16, nil, []EventType{EventTrade, EventExecution})
+	defer cancel()
+
+	state := NewSymbolState("btcusdt")
+	stats := NewTimingStats(time.Now(), 1)
+	p := newSymbolPipeline(state, bus, NewMonitorMetrics(reg, "btcusdt", state.Book, state.Signals),
+		NewPipelineTracer(nil, reg, "btcusdt"), NewRuleEngine(), stats)
+
+	now := time.Now()
+	run := []feed.Msg{
+		{Symbol: "btcusdt", TradeID: 1, Price: 100, Quantity: 1, BuyerMaker: true, Received: now, Parsed: now},
+		{Symbol: "btcusdt", TradeID: 2, Price: 101, Quantity: 1, BuyerMaker: true, Received: now, Parsed: now},
+		{Symbol: "btcusdt", TradeID: 3, Price: 100, Quantity: 0.5, Received: now, Parsed: now},
+	}
+	p.processRun(0, run)
+
+	// The buy took half of the resting sell at 100
+	if price, vol, ok := state.Book.GetBestAsk(); !ok || price != 100 || vol != 500 {
+		t.Errorf("best ask = %v, %v, %v; want 100, 500, true", price, vol, ok)
+	}
+	var trades []uint64
+	var fills []orderbook.Execution
+	for len(events) > 0 {
+		e := <-events
+		switch e.Type {
+		case EventTrade:
+			trades = append(trades, e.Trade.ID)
+		case EventExecution:
+			fills = append(fills, *e.Execution)
+		}
+		e.Release()
+	}
+	if len(trades) != 3 || trades[0] != 1 || trades[2] != 3 {
+		t.Errorf("trade events %v, want 1, 2, 3", trades)
+	}
+	if len(fills) != 1 || fills[0].TakerID != 3 || fills[0].MakerID != 1 || fills[0].Symbol != "btcusdt" {
+		t.Errorf("executions %+v, want taker 3 against maker 1", fills)
+	}
+	if n, _ := stats.Totals(); n != 3 {
+		t.Errorf("stats counted %d messages, want 3", n)
+	}
+	if got := state.Tape.Len(); got != 3 {
+		t.Errorf("tape holds %d trades, want 3", got)
+	}
+}
@@ -0,0 +1,288 @@
+// Package bookcheck tests limit order book implementations. Run drives a
+// book through a random sequence of orders and cancels, follows what it
+// reports against a model of the orders it was given, and fails on the
+// first operation after which the two disagree. Implementations other than
+// orderbook.Book can be checked by satisfying Book.
+package bookcheck
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+
+	"apexlob/pkg/orderbook"
+)
+
+// Book is what Run needs of a book: a price-time priority matching engine
+// with no limits on its size, so that nothing leaves it but fills and
+// cancels. orderbook.Book satisfies it.
+//
+// The execution handler must be called for every fill before SubmitOrder
+// returns. Depth(0) must return every level of each side, best price first.
+type Book interface {
+	SubmitOrder(order *orderbook.Order) (rested bool)
+	CancelOrder(id uint64) bool
+	SetExecutionHandler(h func(orderbook.Execution))
+	Depth(n int) (bids, asks []orderbook.PriceLevel)
+}
+
+// Checker is implemented by books that can check their own internal
+// consistency, as orderbook.Book does. Run calls it after every operation.
+type Checker interface {
+	CheckInvariants() error
+}
+
+// Config shapes the operations Run generates. Zero fields take the
+// defaults in parentheses.
+type Config struct {
+	Seed        int64
+	Ops         int     // orders and cancels (10000)
+	Prices      int     // distinct prices, Tick apart around 100 (20)
+	Tick        float64 // (0.5)
+	MaxQuantity uint32  // of an order, from 1 (100)
+	CancelRatio float64 // share of the operations that are cancels (0.25)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Ops <= 0 {
+		c.Ops = 10000
+	}
+	if c.Prices <= 0 {
+		c.Prices = 20
+	}
+	if c.Tick <= 0 {
+		c.Tick = 0.5
+	}
+	if c.MaxQuantity == 0 {
+		c.MaxQuantity = 100
+	}
+	if c.CancelRatio <= 0 {
+		c.CancelRatio = 0.25
+	}
+	return c
+}
+
+// Totals accounts for the volume of a run. Every unit of volume submitted
+// is matched, still resting or cancelled, so In == Matched + Resting +
+// Cancelled. Matched counts both sides of each fill: a fill of 5 takes 5
+// from the taker and 5 from the maker.
+type Totals struct {
+	Orders     int    `json:"orders"`
+	Cancels    int    `json:"cancels"` // that found a resting order
+	Executions int    `json:"executions"`
+	In         uint64 `json:"in"`
+	Matched    uint64 `json:"matched"`
+	Resting    uint64 `json:"resting"`
+	Cancelled  uint64 `json:"cancelled"`
+}
+
+// modelOrder is what the model knows of an order the book was given.
+type modelOrder struct {
+	id        uint64
+	side      orderbook.Side
+	price     float64
+	remaining uint32
+}
+
+// Run checks book, which must start empty, over cfg.Ops random operations.
+// After each one it checks that:
+//
+//   - every fill is at a resting order's price, against the best-priced and
+//     then the oldest order the taker's price crosses, for no more than
+//     either has left;
+//   - SubmitOrder reports an order as rested exactly when it was not
+//     filled in full, and CancelOrder finds exactly the orders still
+//     resting;
+//   - the book's depth is the model's, and is not crossed;
+//   - In == Matched + Resting + Cancelled;
+//   - the book's own Checker, if it has one, passes.
+//
+// It returns the run's totals, and the first failure with the seed and
+// the operation that brought it about.
+func Run(book Book, cfg Config) (Totals, error) {
+	cfg = cfg.withDefaults()
+	r := &run{book: book, cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed)), orders: make(map[uint64]*modelOrder)}
+	book.SetExecutionHandler(func(e orderbook.Execution) { r.fills = append(r.fills, e) })
+	defer book.SetExecutionHandler(nil)
+	for op := 1; op <= cfg.Ops; op++ {
+		if err := r.step(uint64(op)); err != nil {
+			return r.totals, fmt.Errorf("bookcheck: seed %d, operation %d: %w", cfg.Seed, op, err)
+		}
+	}
+	return r.totals, nil
+}
+
+type run struct {
+	book   Book
+	cfg    Config
+	rng    *rand.Rand
+	orders map[uint64]*modelOrder // submitted, by ID
+	bids   []*modelOrder          // resting, in time order
+	asks   []*modelOrder
+	fills  []orderbook.Execution
+	totals Totals
+}
+
+// step carries out operation id, a cancel or the order with that ID, and
+// checks the book after it.
+func (r *run) step(id uint64) error {
+	if r.rng.Float64() < r.cfg.CancelRatio {
+		if err := r.cancel(uint64(r.rng.Int63n(int64(id))) + 1); err != nil {
+			return err
+		}
+	} else if err := r.submit(id); err != nil {
+		return err
+	}
+	return r.check()
+}
+
+func (r *run) cancel(id uint64) error {
+	want := r.orders[id] != nil && r.orders[id].remaining > 0
+	if got := r.book.CancelOrder(id); got != want {
+		return fmt.Errorf("cancel of order %d returned %v, want %v", id, got, want)
+	}
+	if want {
+		o := r.orders[id]
+		r.totals.Cancels++
+		r.totals.Cancelled += uint64(o.remaining)
+		o.remaining = 0
+		r.prune()
+	}
+	return nil
+}
+
+func (r *run) submit(id uint64) error {
+	side := orderbook.Buy
+	if r.rng.Intn(2) == 1 {
+		side = orderbook.Sell
+	}
+	price := 100 + float64(r.rng.Intn(r.cfg.Prices)-r.cfg.Prices/2)*r.cfg.Tick
+	quantity := uint32(r.rng.Int63n(int64(r.cfg.MaxQuantity))) + 1
+	taker := &modelOrder{id: id, side: side, price: price, remaining: quantity}
+	r.orders[id] = taker
+	r.totals.Orders++
+	r.totals.In += uint64(quantity)
+
+	r.fills = r.fills[:0]
+	rested := r.book.SubmitOrder(&orderbook.Order{ID: id, Price: price, Quantity: quantity, Side: side})
+	for _, e := range r.fills {
+		if err := r.fill(taker, e); err != nil {
+			return fmt.Errorf("order %d, a %s of %d at %v: %w", id, side, quantity, price, err)
+		}
+	}
+	if rested != (taker.remaining > 0) {
+		return fmt.Errorf("order %d, a %s of %d at %v, has %d left but rested is %v", id, side, quantity, price, taker.remaining, rested)
+	}
+	if taker.remaining > 0 {
+		if side == orderbook.Buy {
+			r.bids = append(r.bids, taker)
+		} else {
+			r.asks = append(r.asks, taker)
+		}
+	}
+	return nil
+}
+
+// fill checks an execution of taker against the model and applies it.
+func (r *run) fill(taker *modelOrder, e orderbook.Execution) error {
+	queue := r.asks
+	if taker.side == orderbook.Sell {
+		queue = r.bids
+	}
+	next := r.nextMaker(queue, taker.side)
+	maker := r.orders[e.MakerID]
+	switch {
+	case e.TakerID != taker.id || e.Side != taker.side:
+		return fmt.Errorf("execution %+v is not the order's", e)
+	case next == nil || taker.side == orderbook.Buy && next.price > taker.price || taker.side == orderbook.Sell && next.price < taker.price:
+		return fmt.Errorf("execution %+v with no resting order its price crosses", e)
+	case maker != next:
+		return fmt.Errorf("execution %+v against maker %d, ahead of which is order %d at %v", e, e.MakerID, next.id, next.price)
+	case e.Price != maker.price:
+		return fmt.Errorf("execution %+v is not at its maker's price of %v", e, maker.price)
+	case e.Quantity == 0 || e.Quantity > maker.remaining || e.Quantity > taker.remaining:
+		return fmt.Errorf("execution %+v for more than the maker's %d or the taker's %d left", e, maker.remaining, taker.remaining)
+	}
+	maker.remaining -= e.Quantity
+	taker.remaining -= e.Quantity
+	r.totals.Executions++
+	r.totals.Matched += 2 * uint64(e.Quantity)
+	r.prune()
+	return nil
+}
+
+// nextMaker returns the order a taker on side should fill against next:
+// the best-priced in queue, and the oldest at that price.
+func (r *run) nextMaker(queue []*modelOrder, side orderbook.Side) *modelOrder {
+	var next *modelOrder
+	for _, o := range queue {
+		if next == nil || side == orderbook.Buy && o.price < next.price || side == orderbook.Sell && o.price > next.price {
+			next = o
+		}
+	}
+	return next
+}
+
+// prune drops the orders with nothing left from the resting queues.
+func (r *run) prune() {
+	done := func(o *modelOrder) bool { return o.remaining == 0 }
+	r.bids = slices.DeleteFunc(r.bids, done)
+	r.asks = slices.DeleteFunc(r.asks, done)
+}
+
+// check compares the book's depth with the model's, and the run's volume
+// in with where it went.
+func (r *run) check() error {
+	bids, asks := r.book.Depth(0)
+	r.totals.Resting = 0
+	for _, side := range []struct {
+		side   orderbook.Side
+		got    []orderbook.PriceLevel
+		orders []*modelOrder
+	}{{orderbook.Buy, bids, r.bids}, {orderbook.Sell, asks, r.asks}} {
+		want := depth(side.orders, side.side)
+		if !slices.Equal(side.got, want) {
+			return fmt.Errorf("%s depth is %v, want %v", side.side, side.got, want)
+		}
+		for _, l := range side.got {
+			r.totals.Resting += uint64(l.Volume)
+		}
+	}
+	if len(bids) > 0 && len(asks) > 0 && bids[0].Price >= asks[0].Price {
+		return fmt.Errorf("book is crossed: best bid %v, best ask %v", bids[0].Price, asks[0].Price)
+	}
+	if t := r.totals; t.In != t.Matched+t.Resting+t.Cancelled {
+		return fmt.Errorf("volume in is %d, but %d matched, %d resting and %d cancelled", t.In, t.Matched, t.Resting, t.Cancelled)
+	}
+	if c, ok := r.book.(Checker); ok {
+		if err := c.CheckInvariants(); err != nil {
+			return fmt.Errorf("book's own check: %w", err)
+		}
+	}
+	return nil
+}
+
+// depth sums orders, all on side, into levels, best price first.
+func depth(orders []*modelOrder, side orderbook.Side) []orderbook.PriceLevel {
+	byPrice := make(map[float64]*orderbook.PriceLevel)
+	var prices []float64
+	for _, o := range orders {
+		l := byPrice[o.price]
+		if l == nil {
+			l = &orderbook.PriceLevel{Price: o.price}
+			byPrice[o.price] = l
+			prices = append(prices, o.price)
+		}
+		l.Volume += o.remaining
+		l.Orders++
+	}
+	slices.Sort(prices)
+	if side == orderbook.Buy {
+		slices.Reverse(prices)
+	}
+	levels := make([]orderbook.PriceLevel, 0, len(prices))
+	for _, p := range prices {
+		levels = append(levels, *byPrice[p])
+	}
+	return levels
+}
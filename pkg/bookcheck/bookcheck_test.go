@@ -0,0 +1,77 @@
+package bookcheck
+
+import (
+	"strings"
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestRunOrderBook(t *testing.T) {
+	for _, cfg := range []Config{
+		{Seed: 1},
+		{Seed: 2, Prices: 3, MaxQuantity: 5},
+		{Seed: 3, Prices: 200, Tick: 0.01, CancelRatio: 0.6},
+	} {
+		totals, err := Run(orderbook.New(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totals.Orders == 0 || totals.Cancels == 0 || totals.Executions == 0 || totals.Resting == 0 {
+			t.Errorf("%+v exercised too little: %+v", cfg, totals)
+		}
+	}
+}
+
+// silentCancel cancels orders but reports that it found none.
+type silentCancel struct{ *orderbook.Book }
+
+func (b silentCancel) CancelOrder(id uint64) bool {
+	b.Book.CancelOrder(id)
+	return false
+}
+
+// shrunkDepth under-reports the volume at the best bid.
+type shrunkDepth struct{ *orderbook.Book }
+
+func (b shrunkDepth) Depth(n int) (bids, asks []orderbook.PriceLevel) {
+	bids, asks = b.Book.Depth(n)
+	if len(bids) > 0 {
+		bids[0].Volume--
+	}
+	return bids, asks
+}
+
+// takerPriced reports fills at the taker's price rather than the maker's.
+type takerPriced struct {
+	*orderbook.Book
+	price float64
+}
+
+func (b *takerPriced) SubmitOrder(order *orderbook.Order) bool {
+	b.price = order.Price
+	return b.Book.SubmitOrder(order)
+}
+
+func (b *takerPriced) SetExecutionHandler(h func(orderbook.Execution)) {
+	b.Book.SetExecutionHandler(func(e orderbook.Execution) {
+		e.Price = b.price
+		h(e)
+	})
+}
+
+func TestRunCatchesBrokenBooks(t *testing.T) {
+	for name, tc := range map[string]struct {
+		book Book
+		want string
+	}{
+		"silent cancel": {silentCancel{orderbook.New()}, "cancel of order"},
+		"shrunk depth":  {shrunkDepth{orderbook.New()}, "BUY depth is"},
+		"taker priced":  {&takerPriced{Book: orderbook.New()}, "maker's price"},
+	} {
+		_, err := Run(tc.book, Config{Seed: 1, Ops: 2000})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want an error about %q", name, err, tc.want)
+		}
+	}
+}
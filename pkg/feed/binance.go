@@ -0,0 +1,480 @@
+// Package feed parses Binance market data streams and carries the parsed
+// trades and depth levels from the socket reader to the books.
+package feed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/gorilla/websocket"
+)
+
+// AggTrade is an aggTrade stream message. Symbol, Price and Quantity
+// are the exchange's strings and alias the message buffer, so they are only
+// valid until the next read.
+type AggTrade struct {
+	Symbol     []byte // "s", upper case
+	Price      []byte // "p"
+	Quantity   []byte // "q"
+	BuyerMaker bool   // "m", isBuyerMaker
+	TradeID    uint64 // "a"
+	EventMs    int64  // "E", exchange event time
+}
+
+// DepthLevel is one [price, quantity] pair of a depth update, aliasing the
+// message buffer like AggTrade.
+type DepthLevel struct {
+	Price    []byte
+	Quantity []byte
+}
+
+// DepthUpdate is a diff depth stream (depthUpdate) message. Bids and
+// Asks are reused between calls to ParseDepthUpdate.
+type DepthUpdate struct {
+	EventMs       int64  // "E"
+	Symbol        []byte // "s"
+	FirstUpdateID uint64 // "U"
+	FinalUpdateID uint64 // "u"
+	Bids          []DepthLevel
+	Asks          []DepthLevel
+}
+
+// MarkPriceUpdate is a futures markPrice stream message, aliasing the
+// message buffer like AggTrade.
+type MarkPriceUpdate struct {
+	Symbol []byte // "s"
+	Mark   []byte // "p"
+	Index  []byte // "i"
+	// FundingRate is the rate due at NextFundingMs, as a fraction
+	FundingRate   []byte // "r"
+	NextFundingMs int64  // "T"
+	EventMs       int64  // "E"
+}
+
+// The parsers below scan the known Binance schemas field by field instead of
+// going through encoding/json, which allocates for every message. Unknown
+// fields are skipped; string values are returned raw, without unescaping,
+// which is safe for the numeric and symbol fields Binance sends.
+
+var errJSONSyntax = errors.New("binance: malformed JSON")
+
+// ParseAggTrade parses an aggTrade message into t without allocating. It
+// accepts both raw stream messages and the {"stream":...,"data":{...}}
+// envelope of combined streams.
+func ParseAggTrade(msg []byte, t *AggTrade) error {
+	*t = AggTrade{}
+	s := jsonScanner{b: msg}
+	var field func(key []byte)
+	field = func(key []byte) {
+		switch string(key) {
+		case "data":
+			s.object(field)
+		case "s":
+			t.Symbol = s.str()
+		case "e":
+			if ev := s.str(); s.err == nil && string(ev) != "aggTrade" {
+				s.err = fmt.Errorf("binance: unexpected event type %q", ev)
+			}
+		case "E":
+			t.EventMs = s.int()
+		case "a":
+			t.TradeID = uint64(s.int())
+		case "p":
+			t.Price = s.str()
+		case "q":
+			t.Quantity = s.str()
+		case "m":
+			t.BuyerMaker = s.bool()
+		default:
+			s.skip()
+		}
+	}
+	s.object(field)
+	return s.finish()
+}
+
+// errEventTypeFound stops EventType's scan once it has the type.
+var errEventTypeFound = errors.New("binance: event type found")
+
+// EventType returns the "e" field of a raw or combined stream message
+// without scanning past it. Binance sends it first, so telling message
+// types apart costs little more than a few bytes.
+func EventType(msg []byte) ([]byte, error) {
+	s := jsonScanner{b: msg}
+	var typ []byte
+	var field func(key []byte)
+	field = func(key []byte) {
+		switch string(key) {
+		case "data":
+			s.object(field)
+		case "e":
+			if typ = s.str(); s.err == nil {
+				s.err = errEventTypeFound
+			}
+		default:
+			s.skip()
+		}
+	}
+	s.object(field)
+	switch s.err {
+	case errEventTypeFound:
+		return typ, nil
+	case nil:
+		return nil, errors.New("binance: message has no event type")
+	}
+	return nil, s.err
+}
+
+// ParseDepthUpdate parses a depthUpdate message into u, reusing the capacity
+// of its level slices so steady-state parsing does not allocate.
+func ParseDepthUpdate(msg []byte, u *DepthUpdate) error {
+	bids, asks := u.Bids[:0], u.Asks[:0]
+	*u = DepthUpdate{}
+	s := jsonScanner{b: msg}
+	s.object(func(key []byte) {
+		switch string(key) {
+		case "e":
+			if ev := s.str(); s.err == nil && string(ev) != "depthUpdate" {
+				s.err = fmt.Errorf("binance: unexpected event type %q", ev)
+			}
+		case "E":
+			u.EventMs = s.int()
+		case "s":
+			u.Symbol = s.str()
+		case "U":
+			u.FirstUpdateID = uint64(s.int())
+		case "u":
+			u.FinalUpdateID = uint64(s.int())
+		case "b":
+			bids = s.levels(bids)
+		case "a":
+			asks = s.levels(asks)
+		default:
+			s.skip()
+		}
+	})
+	u.Bids, u.Asks = bids, asks
+	return s.finish()
+}
+
+// ParseMarkPrice parses a markPriceUpdate message into u, raw or in a
+// combined stream envelope, without allocating.
+func ParseMarkPrice(msg []byte, u *MarkPriceUpdate) error {
+	*u = MarkPriceUpdate{}
+	s := jsonScanner{b: msg}
+	var field func(key []byte)
+	field = func(key []byte) {
+		switch string(key) {
+		case "data":
+			s.object(field)
+		case "e":
+			if ev := s.str(); s.err == nil && string(ev) != "markPriceUpdate" {
+				s.err = fmt.Errorf("binance: unexpected event type %q", ev)
+			}
+		case "E":
+			u.EventMs = s.int()
+		case "s":
+			u.Symbol = s.str()
+		case "p":
+			u.Mark = s.str()
+		case "i":
+			u.Index = s.str()
+		case "r":
+			u.FundingRate = s.str()
+		case "T":
+			u.NextFundingMs = s.int()
+		default:
+			s.skip()
+		}
+	}
+	s.object(field)
+	return s.finish()
+}
+
+// float64pow10 holds the powers of ten that float64 represents exactly.
+var float64pow10 = [...]float64{
+	1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11,
+	1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22,
+}
+
+// ParseDecimal parses a price or quantity such as "67123.45000000" straight
+// from the message bytes. Plain decimals with at most 15 significant digits
+// are exact integers divided by an exact power of ten, which is correctly
+// rounded; anything else goes through strconv.ParseFloat. NaN and the
+// infinities, which strconv accepts, are refused.
+func ParseDecimal(b []byte) (float64, error) {
+	s := b
+	// Trailing zeros after the one point are dropped; a second point is
+	// left for the slow path to reject
+	if i := bytes.IndexByte(s, '.'); i >= 0 && bytes.IndexByte(s[i+1:], '.') < 0 {
+		s = bytes.TrimRight(s, "0")
+		s = bytes.TrimSuffix(s, []byte{'.'})
+	}
+	neg := len(s) > 0 && s[0] == '-'
+	if neg {
+		s = s[1:]
+	}
+	var mant uint64
+	digits, frac := 0, -1
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			mant = mant*10 + uint64(c-'0')
+			if mant > 0 {
+				digits++
+			}
+			if frac >= 0 {
+				frac++
+			}
+		case c == '.' && frac < 0:
+			frac = 0
+		default:
+			return parseDecimalSlow(b)
+		}
+	}
+	if len(s) == 0 || frac == 0 || digits > 15 || frac >= len(float64pow10) {
+		return parseDecimalSlow(b)
+	}
+	v := float64(mant)
+	if frac > 0 {
+		v /= float64pow10[frac]
+	}
+	if neg {
+		v = -v
+	}
+	return v, nil
+}
+
+var errNotFinite = errors.New("binance: decimal is not finite")
+
+func parseDecimalSlow(b []byte) (float64, error) {
+	v, err := strconv.ParseFloat(string(b), 64)
+	if err == nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return 0, fmt.Errorf("%w: %q", errNotFinite, b)
+	}
+	return v, err
+}
+
+// ReadMessage reads the next WebSocket message into buf, reusing its
+// capacity where conn.ReadMessage allocates a new slice per message. The
+// result aliases buf, so it is only valid until the next call.
+func ReadMessage(conn *websocket.Conn, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return buf, err
+	}
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF {
+			return buf, nil
+		}
+		if err != nil {
+			return buf, err
+		}
+	}
+}
+
+type jsonScanner struct {
+	b   []byte
+	i   int
+	err error
+}
+
+func (s *jsonScanner) ws() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\n', '\r':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of input.
+func (s *jsonScanner) peek() byte {
+	s.ws()
+	if s.err != nil || s.i >= len(s.b) {
+		return 0
+	}
+	return s.b[s.i]
+}
+
+func (s *jsonScanner) expect(c byte) {
+	if s.peek() != c {
+		s.fail()
+		return
+	}
+	s.i++
+}
+
+func (s *jsonScanner) fail() {
+	if s.err == nil {
+		s.err = fmt.Errorf("%w at offset %d", errJSONSyntax, s.i)
+	}
+}
+
+func (s *jsonScanner) finish() error {
+	if s.err == nil && s.peek() != 0 {
+		s.fail()
+	}
+	return s.err
+}
+
+// object calls field for each key, which must consume the value.
+func (s *jsonScanner) object(field func(key []byte)) {
+	s.expect('{')
+	if s.peek() == '}' {
+		s.i++
+		return
+	}
+	for s.err == nil {
+		key := s.str()
+		s.expect(':')
+		if s.err != nil {
+			return
+		}
+		field(key)
+		switch s.peek() {
+		case ',':
+			s.i++
+		case '}':
+			s.i++
+			return
+		default:
+			s.fail()
+		}
+	}
+}
+
+// array calls elem for each element, which must consume it.
+func (s *jsonScanner) array(elem func()) {
+	s.expect('[')
+	if s.peek() == ']' {
+		s.i++
+		return
+	}
+	for s.err == nil {
+		elem()
+		switch s.peek() {
+		case ',':
+			s.i++
+		case ']':
+			s.i++
+			return
+		default:
+			s.fail()
+		}
+	}
+}
+
+func (s *jsonScanner) str() []byte {
+	s.expect('"')
+	start := s.i
+	for s.err == nil && s.i < len(s.b) {
+		switch s.b[s.i] {
+		case '"':
+			s.i++
+			return s.b[start : s.i-1]
+		case '\\':
+			s.i++
+		}
+		s.i++
+	}
+	s.fail()
+	return nil
+}
+
+func (s *jsonScanner) int() int64 {
+	s.ws()
+	neg := s.i < len(s.b) && s.b[s.i] == '-'
+	if neg {
+		s.i++
+	}
+	start := s.i
+	var n int64
+	for s.i < len(s.b) && s.b[s.i] >= '0' && s.b[s.i] <= '9' {
+		n = n*10 + int64(s.b[s.i]-'0')
+		s.i++
+	}
+	if s.i == start || s.i-start > 18 {
+		s.fail()
+	}
+	if neg {
+		return -n
+	}
+	return n
+}
+
+func (s *jsonScanner) bool() bool {
+	switch s.peek() {
+	case 't':
+		return s.literal("true")
+	case 'f':
+		return !s.literal("false")
+	}
+	s.fail()
+	return false
+}
+
+func (s *jsonScanner) literal(lit string) bool {
+	if len(s.b)-s.i < len(lit) || string(s.b[s.i:s.i+len(lit)]) != lit {
+		s.fail()
+		return false
+	}
+	s.i += len(lit)
+	return true
+}
+
+// skip consumes any value.
+func (s *jsonScanner) skip() {
+	switch s.peek() {
+	case '{':
+		s.object(func([]byte) { s.skip() })
+	case '[':
+		s.array(s.skip)
+	case '"':
+		s.str()
+	case 't':
+		s.literal("true")
+	case 'f':
+		s.literal("false")
+	case 'n':
+		s.literal("null")
+	default:
+		start := s.i
+		for s.i < len(s.b) {
+			c := s.b[s.i]
+			if (c < '0' || c > '9') && c != '-' && c != '+' && c != '.' && c != 'e' && c != 'E' {
+				break
+			}
+			s.i++
+		}
+		if s.i == start {
+			s.fail()
+		}
+	}
+}
+
+// levels appends the [["price","qty"], ...] pairs of a depth message.
+func (s *jsonScanner) levels(dst []DepthLevel) []DepthLevel {
+	s.array(func() {
+		var lvl DepthLevel
+		s.expect('[')
+		lvl.Price = s.str()
+		s.expect(',')
+		lvl.Quantity = s.str()
+		s.expect(']')
+		dst = append(dst, lvl)
+	})
+	return dst
+}
@@ -0,0 +1,346 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	aggTradeMsg    = []byte(`{"e":"aggTrade","E":1700000000123,"s":"BTCUSDT","a":26129,"p":"0.01633102","q":"4.70443515","f":27781,"l":27781,"T":1700000000120,"m":false,"M":true}`)
+	depthUpdateMsg = []byte(`{"e":"depthUpdate","E":1700000000123,"s":"BNBBTC","U":157,"u":160,"b":[["0.0024","10"],["0.0023","0.5"]],"a":[["0.0026","100"]]}`)
+)
+
+func TestParseAggTradeMatchesEncodingJSON(t *testing.T) {
+	var got AggTrade
+	if err := ParseAggTrade(aggTradeMsg, &got); err != nil {
+		t.Fatal(err)
+	}
+	var want struct {
+		Price      string `json:"p"`
+		Quantity   string `json:"q"`
+		BuyerMaker bool   `json:"m"`
+		TradeID    uint64 `json:"a"`
+		EventMs    int64  `json:"E"`
+		// encoding/json matches keys case-insensitively, so without these
+		// "e" and "M" would be folded onto "E" and "m"
+		Event  string `json:"e"`
+		Ignore bool   `json:"M"`
+	}
+	if err := json.Unmarshal(aggTradeMsg, &want); err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Price) != want.Price || string(got.Quantity) != want.Quantity ||
+		got.BuyerMaker != want.BuyerMaker || got.TradeID != want.TradeID || got.EventMs != want.EventMs {
+		t.Errorf("parsed %+v, want %+v", got, want)
+	}
+
+	// Field order, whitespace and unknown nested values don't matter
+	msg := []byte(" {\n \"x\": {\"y\": [1, -2.5e3, null, \"a\\\"b\"]}, \"m\": false, \"q\": \"1\", \"p\": \"2\" } ")
+	if err := ParseAggTrade(msg, &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Price) != "2" || string(got.Quantity) != "1" || got.BuyerMaker || got.TradeID != 0 {
+		t.Errorf("parsed %+v", got)
+	}
+}
+
+func TestParseAggTradeErrors(t *testing.T) {
+	for _, msg := range []string{
+		``,
+		`{"p":"1"`,
+		`{"p":1.5.}`,
+		`{"a":"12"}`,
+		`{"m":tru}`,
+		`{"p":"1"} trailing`,
+		`{"e":"depthUpdate"}`,
+	} {
+		var tr AggTrade
+		if err := ParseAggTrade([]byte(msg), &tr); err == nil {
+			t.Errorf("ParseAggTrade(%q) succeeded", msg)
+		}
+	}
+}
+
+func TestParseDepthUpdate(t *testing.T) {
+	var u DepthUpdate
+	if err := ParseDepthUpdate(depthUpdateMsg, &u); err != nil {
+		t.Fatal(err)
+	}
+	if string(u.Symbol) != "BNBBTC" || u.FirstUpdateID != 157 || u.FinalUpdateID != 160 || len(u.Bids) != 2 || len(u.Asks) != 1 {
+		t.Fatalf("parsed %+v", u)
+	}
+	if string(u.Bids[1].Price) != "0.0023" || string(u.Bids[1].Quantity) != "0.5" || string(u.Asks[0].Quantity) != "100" {
+		t.Errorf("levels = %s %s", u.Bids, u.Asks)
+	}
+}
+
+func TestParseMarkPrice(t *testing.T) {
+	msg := []byte(`{"stream":"btcusdt@markPrice@1s","data":{"e":"markPriceUpdate","E":1700000000123,"s":"BTCUSDT","p":"43251.20000000","P":"43260.1","i":"43249.98123457","r":"0.00010000","T":1700006400000}}`)
+	var u MarkPriceUpdate
+	if err := ParseMarkPrice(msg, &u); err != nil {
+		t.Fatal(err)
+	}
+	if string(u.Symbol) != "BTCUSDT" || string(u.Mark) != "43251.20000000" || string(u.Index) != "43249.98123457" || u.EventMs != 1700000000123 ||
+		string(u.FundingRate) != "0.00010000" || u.NextFundingMs != 1700006400000 {
+		t.Errorf("parsed %+v", u)
+	}
+	if err := ParseMarkPrice(aggTradeMsg, &u); err == nil {
+		t.Error("parsed an aggTrade as a mark price")
+	}
+	if n := testing.AllocsPerRun(100, func() { ParseMarkPrice(msg, &u) }); n != 0 {
+		t.Errorf("ParseMarkPrice allocs = %v, want 0", n)
+	}
+}
+
+func TestBinanceEventType(t *testing.T) {
+	for msg, want := range map[string]string{
+		string(aggTradeMsg):    "aggTrade",
+		string(depthUpdateMsg): "depthUpdate",
+		`{"stream":"btcusdt@depth","data":{"e":"depthUpdate","E":1}}`: "depthUpdate",
+		// Everything after the type goes unread
+		`{"e":"aggTrade","p":`: "aggTrade",
+	} {
+		if typ, err := EventType([]byte(msg)); err != nil || string(typ) != want {
+			t.Errorf("EventType(%s) = %q, %v; want %s", msg, typ, err, want)
+		}
+	}
+	for _, msg := range []string{`{"result":null,"id":1}`, `{"e":`, `[]`} {
+		if _, err := EventType([]byte(msg)); err == nil {
+			t.Errorf("EventType(%s) succeeded", msg)
+		}
+	}
+}
+
+func TestBinanceParsersDoNotAllocate(t *testing.T) {
+	var tr AggTrade
+	if n := testing.AllocsPerRun(100, func() {
+		ParseAggTrade(aggTradeMsg, &tr)
+		ParseDecimal(tr.Price)
+		ParseDecimal(tr.Quantity)
+	}); n != 0 {
+		t.Errorf("ParseAggTrade allocs = %v, want 0", n)
+	}
+	var u DepthUpdate
+	ParseDepthUpdate(depthUpdateMsg, &u)
+	if n := testing.AllocsPerRun(100, func() { ParseDepthUpdate(depthUpdateMsg, &u) }); n != 0 {
+		t.Errorf("ParseDepthUpdate allocs = %v, want 0", n)
+	}
+}
+
+func TestParseDecimalMatchesStrconv(t *testing.T) {
+	cases := []string{
+		"0", "1", "-1", "0.01633102", "4.70443515", "67123.45000000", "100.", ".5",
+		"0.00000001", "123456789012345", "1234567890123456789", "0.1234567890123456789",
+		"1e5", "-2.5E-3", "00012.3400", "-0.0",
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		// Binance-style fixed eight decimals across a range of magnitudes
+		cases = append(cases, strconv.FormatFloat(rng.Float64()*float64(int64(1)<<uint(rng.Intn(40))), 'f', 8, 64))
+	}
+	for _, c := range cases {
+		want, wantErr := strconv.ParseFloat(c, 64)
+		got, err := ParseDecimal([]byte(c))
+		if (err != nil) != (wantErr != nil) || got != want {
+			t.Errorf("ParseDecimal(%q) = %v, %v; want %v, %v", c, got, err, want, wantErr)
+		}
+	}
+	for _, c := range []string{"", "-", ".", "1.2.3", "12a", "--1", "0.0.", "1.20.", "NaN", "nan", "Inf", "-Inf", "+Infinity"} {
+		if _, err := ParseDecimal([]byte(c)); err == nil {
+			t.Errorf("ParseDecimal(%q) succeeded", c)
+		}
+	}
+}
+
+func TestReadFeedMessageReusesBuffer(t *testing.T) {
+	messages := [][]byte{aggTradeMsg, bytes.Repeat([]byte("x"), 5000), []byte("{}")}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, m := range messages {
+			conn.WriteMessage(websocket.TextMessage, m)
+		}
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 0, 16)
+	for i, want := range messages {
+		if buf, err = ReadMessage(conn, buf); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !bytes.Equal(buf, want) {
+			t.Fatalf("message %d = %d bytes, want %d", i, len(buf), len(want))
+		}
+	}
+	// The large message grew the buffer; the small one after it reused it
+	if cap(buf) < 5000 {
+		t.Errorf("buffer capacity %d was not kept", cap(buf))
+	}
+	if _, err := ReadMessage(conn, buf); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("read after close = %v, want normal closure", err)
+	}
+}
+
+func BenchmarkParseAggTrade(b *testing.B) {
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(aggTradeMsg)))
+		var tr struct {
+			Price      string `json:"p"`
+			Quantity   string `json:"q"`
+			BuyerMaker bool   `json:"m"`
+			TradeID    uint64 `json:"a"`
+			EventMs    int64  `json:"E"`
+			Event      string `json:"e"`
+		}
+		for i := 0; i < b.N; i++ {
+			json.Unmarshal(aggTradeMsg, &tr)
+		}
+	})
+	b.Run("scanner", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(aggTradeMsg)))
+		var tr AggTrade
+		for i := 0; i < b.N; i++ {
+			ParseAggTrade(aggTradeMsg, &tr)
+		}
+	})
+}
+
+func BenchmarkParseDepthUpdate(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(depthUpdateMsg)))
+	var u DepthUpdate
+	for i := 0; i < b.N; i++ {
+		ParseDepthUpdate(depthUpdateMsg, &u)
+	}
+}
+
+func TestParseAggTradeCombinedStream(t *testing.T) {
+	msg := []byte(`{"stream":"ethusdt@aggTrade","data":{"e":"aggTrade","s":"ETHUSDT","a":5,"p":"3000.1","q":"2","m":true}}`)
+	var tr AggTrade
+	if err := ParseAggTrade(msg, &tr); err != nil {
+		t.Fatal(err)
+	}
+	if string(tr.Symbol) != "ETHUSDT" || tr.TradeID != 5 || string(tr.Price) != "3000.1" || !tr.BuyerMaker {
+		t.Errorf("parsed %+v", tr)
+	}
+}
+
+func BenchmarkParseDecimal(b *testing.B) {
+	price := []byte("67123.45000000")
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ParseDecimal(price)
+		}
+	})
+	b.Run("strconv", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			strconv.ParseFloat(string(price), 64)
+		}
+	})
+}
+
+// checkDecimal checks ParseDecimal, on a field of a fuzzed message, against
+// strconv wherever it accepts the field, and that it never returns NaN or an
+// infinity.
+func checkDecimal(t *testing.T, field []byte) {
+	got, err := ParseDecimal(field)
+	if err != nil {
+		return
+	}
+	if math.IsNaN(got) || math.IsInf(got, 0) {
+		t.Errorf("ParseDecimal(%q) = %v", field, got)
+	}
+	if want, err := strconv.ParseFloat(string(field), 64); err != nil || got != want {
+		t.Errorf("ParseDecimal(%q) = %v; strconv gives %v, %v", field, got, want, err)
+	}
+}
+
+// FuzzParseAggTrade checks that ParseAggTrade never panics on raw bytes,
+// that what it parses aliases the message, and that parsing is the same
+// into a used AggTrade as into a new one.
+//
+//	go test ./pkg/feed -run '^$' -fuzz FuzzParseAggTrade
+func FuzzParseAggTrade(f *testing.F) {
+	f.Add(aggTradeMsg)
+	f.Add([]byte(`{"stream":"ethusdt@aggTrade","data":{"e":"aggTrade","s":"ETHUSDT","a":5,"p":"3000.1","q":"2","m":true}}`))
+	f.Add([]byte(" {\n \"x\": {\"y\": [1, -2.5e3, null, \"a\\\"b\"]}, \"m\": false, \"q\": \"1\", \"p\": \"2\" } "))
+	f.Add([]byte(`{"p":1.5.}`))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var tr AggTrade
+		err := ParseAggTrade(msg, &tr)
+		used := AggTrade{Symbol: []byte("X"), Price: []byte("1"), BuyerMaker: true, TradeID: 9, EventMs: 9}
+		if err2 := ParseAggTrade(msg, &used); (err != nil) != (err2 != nil) || !reflect.DeepEqual(tr, used) {
+			t.Fatalf("parsed %+v, %v into a new AggTrade but %+v, %v into a used one", tr, err, used, err2)
+		}
+		if err != nil {
+			return
+		}
+		for _, field := range [][]byte{tr.Symbol, tr.Price, tr.Quantity} {
+			if !bytes.Contains(msg, field) {
+				t.Fatalf("parsed field %q is not in the message", field)
+			}
+		}
+		checkDecimal(t, tr.Price)
+		checkDecimal(t, tr.Quantity)
+	})
+}
+
+// FuzzParseDepth is FuzzParseAggTrade for ParseDepthUpdate, whose level
+// slices are reused between calls.
+//
+//	go test ./pkg/feed -run '^$' -fuzz FuzzParseDepth
+func FuzzParseDepth(f *testing.F) {
+	f.Add(depthUpdateMsg)
+	f.Add([]byte(`{"e":"depthUpdate","b":[],"a":[["1","0"]],"x":[[[]]]}`))
+	f.Add([]byte(`{"b":[["0.0024"]]}`))
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		var u DepthUpdate
+		err := ParseDepthUpdate(msg, &u)
+		var used DepthUpdate
+		ParseDepthUpdate(depthUpdateMsg, &used)
+		err2 := ParseDepthUpdate(msg, &used)
+		if (err != nil) != (err2 != nil) || fmt.Sprint(u) != fmt.Sprint(used) {
+			t.Fatalf("parsed %+v, %v into a new DepthUpdate but %+v, %v into a used one", u, err, used, err2)
+		}
+		if err != nil {
+			return
+		}
+		if !bytes.Contains(msg, u.Symbol) {
+			t.Fatalf("parsed symbol %q is not in the message", u.Symbol)
+		}
+		for _, l := range append(u.Bids, u.Asks...) {
+			if !bytes.Contains(msg, l.Price) || !bytes.Contains(msg, l.Quantity) {
+				t.Fatalf("parsed level %q is not in the message", l)
+			}
+			checkDecimal(t, l.Price)
+			checkDecimal(t, l.Quantity)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package feed
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Msg is one parsed feed event handed from the WebSocket reader to the
+// shard worker owning its symbol: a trade or, for mirrored books, one price
+// level of a depth update.
+type Msg struct {
+	Kind     Kind
+	Symbol   string
+	TradeID  uint64 // for depth, the final update ID of the level's update
+	Price    float64
+	Quantity float64 // for depth, the level's new total; 0 removes it
+	// BuyerMaker is Binance's isBuyerMaker: the buyer's order was resting,
+	// so the seller was the aggressor
+	BuyerMaker bool
+	Bid        bool      // depth level side
+	Last       bool      // last level of its depth update or snapshot
+	EventMs    int64     // exchange event time
+	Received   time.Time // read off the socket
+	Parsed     time.Time
+	// Historical marks a message replayed to warm the books and signals up
+	// before the live feed starts
+	Historical bool
+}
+
+type Kind uint8
+
+const (
+	KindTrade Kind = iota
+	KindLevel      // a depth level, see Bid and Last
+	KindReset      // empties a mirrored book ahead of a snapshot
+)
+
+// Ring is a bounded single-producer/single-consumer queue of feed
+// messages. The reader only writes tail and the matcher only writes head, so
+// neither side takes a lock; the channels are used only to park a side that
+// finds the ring empty (consumer) or full (producer).
+type Ring struct {
+	head   uint64 // next slot to read, written by the consumer
+	_      [56]byte
+	tail   uint64 // next slot to write, written by the producer
+	_      [56]byte
+	closed uint32
+	mask   uint64
+	buf    []Msg
+	wake   chan struct{}
+	space  chan struct{}
+	spin   time.Duration
+}
+
+// NewRing returns a ring holding size messages, rounded up to a power of
+// two.
+func NewRing(size int) *Ring {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &Ring{
+		mask:  uint64(n - 1),
+		buf:   make([]Msg, n),
+		wake:  make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+	}
+}
+
+func wakeup(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Push copies m into the ring, waiting while it is full. It returns false if
+// the ring was closed. Only one goroutine may push.
+func (r *Ring) Push(m *Msg) bool {
+	tail := atomic.LoadUint64(&r.tail)
+	for tail-atomic.LoadUint64(&r.head) == uint64(len(r.buf)) {
+		if atomic.LoadUint32(&r.closed) == 1 {
+			return false
+		}
+		<-r.space
+	}
+	r.buf[tail&r.mask] = *m
+	atomic.StoreUint64(&r.tail, tail+1)
+	wakeup(r.wake)
+	return true
+}
+
+// Pop moves the oldest message into m, waiting while the ring is empty. It
+// returns false once the ring is closed and drained. Only one goroutine may
+// pop.
+func (r *Ring) Pop(m *Msg) bool {
+	var one [1]Msg
+	if r.PopBatch(one[:]) == 0 {
+		return false
+	}
+	*m = one[0]
+	return true
+}
+
+// PopBatch moves up to len(dst) queued messages into dst, waiting while the
+// ring is empty, and returns how many it moved: during a burst the consumer
+// takes everything queued for the cost of one wakeup. It returns 0 once the
+// ring is closed and drained. Only one goroutine may pop.
+func (r *Ring) PopBatch(dst []Msg) int {
+	for {
+		head := atomic.LoadUint64(&r.head)
+		tail := atomic.LoadUint64(&r.tail)
+		if head != tail {
+			n := int(tail - head)
+			if n > len(dst) {
+				n = len(dst)
+			}
+			for i := 0; i < n; i++ {
+				slot := &r.buf[(head+uint64(i))&r.mask]
+				dst[i] = *slot
+				*slot = Msg{}
+			}
+			atomic.StoreUint64(&r.head, head+uint64(n))
+			wakeup(r.space)
+			return n
+		}
+		if atomic.LoadUint32(&r.closed) == 1 {
+			if head == atomic.LoadUint64(&r.tail) {
+				return 0
+			}
+			continue
+		}
+		if r.spin > 0 && r.spinWhileEmpty(head) {
+			continue
+		}
+		<-r.wake
+	}
+}
+
+// SetBusyPoll makes the consumer spin on an empty ring for up to d before
+// parking, so a message arriving soon after is picked up without a
+// scheduler wakeup. It must be set before the consumer starts.
+func (r *Ring) SetBusyPoll(d time.Duration) { r.spin = d }
+
+// spinWhileEmpty reports whether something was pushed, or the ring closed,
+// within the spin time.
+func (r *Ring) spinWhileEmpty(head uint64) bool {
+	deadline := time.Now().Add(r.spin)
+	for i := 1; ; i++ {
+		if atomic.LoadUint64(&r.tail) != head || atomic.LoadUint32(&r.closed) == 1 {
+			return true
+		}
+		// Reading the clock costs more than a load, so check it rarely
+		if i%64 == 0 && time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// Len reports the number of queued messages.
+func (r *Ring) Len() int {
+	return int(atomic.LoadUint64(&r.tail) - atomic.LoadUint64(&r.head))
+}
+
+func (r *Ring) Cap() int { return len(r.buf) }
+
+// Close marks the end of input; the consumer drains what is queued first.
+// It must be called by the producer.
+func (r *Ring) Close() {
+	atomic.StoreUint32(&r.closed, 1)
+	wakeup(r.wake)
+	wakeup(r.space)
+}
@@ -0,0 +1,148 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFeedRingPreservesOrderAcrossWraps(t *testing.T) {
+	r := NewRing(5)
+	if r.Cap() != 8 {
+		t.Fatalf("Cap = %d, want 8", r.Cap())
+	}
+	const n = 10000
+	go func() {
+		defer r.Close()
+		for i := uint64(1); i <= n; i++ {
+			r.Push(&Msg{TradeID: i})
+		}
+	}()
+
+	var m Msg
+	next := uint64(1)
+	for r.Pop(&m) {
+		if m.TradeID != next {
+			t.Fatalf("popped %d, want %d", m.TradeID, next)
+		}
+		next++
+	}
+	if next != n+1 {
+		t.Errorf("ring closed after %d messages, want %d", next-1, n)
+	}
+}
+
+func TestFeedRingCloseDrainsAndUnblocks(t *testing.T) {
+	r := NewRing(2)
+	r.Push(&Msg{TradeID: 1})
+	r.Push(&Msg{TradeID: 2})
+	if r.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", r.Len())
+	}
+	r.Close()
+	if r.Push(&Msg{TradeID: 3}) {
+		t.Error("Push into a full, closed ring succeeded")
+	}
+
+	var m Msg
+	for _, want := range []uint64{1, 2} {
+		if !r.Pop(&m) || m.TradeID != want {
+			t.Fatalf("Pop = %d, want %d", m.TradeID, want)
+		}
+	}
+	if r.Pop(&m) {
+		t.Error("Pop after drain succeeded")
+	}
+
+	// A consumer parked on an empty ring wakes when it is closed
+	r = NewRing(2)
+	popped := make(chan bool)
+	go func() { popped <- r.Pop(&Msg{}) }()
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Error("Pop on a closed empty ring succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pop did not return after Close")
+	}
+}
+
+func TestFeedRingPopBatch(t *testing.T) {
+	r := NewRing(8)
+	for i := uint64(1); i <= 5; i++ {
+		r.Push(&Msg{TradeID: i})
+	}
+	batch := make([]Msg, 3)
+	if n := r.PopBatch(batch); n != 3 || batch[0].TradeID != 1 || batch[2].TradeID != 3 {
+		t.Fatalf("first batch = %d messages %+v", n, batch[:n])
+	}
+	if n := r.PopBatch(batch); n != 2 || batch[0].TradeID != 4 || batch[1].TradeID != 5 {
+		t.Fatalf("second batch = %d messages %+v", n, batch[:n])
+	}
+	if r.Len() != 0 {
+		t.Errorf("Len = %d after draining", r.Len())
+	}
+	r.Close()
+	if n := r.PopBatch(batch); n != 0 {
+		t.Errorf("PopBatch on a closed empty ring = %d", n)
+	}
+}
+
+func BenchmarkFeedRing(b *testing.B) {
+	r := NewRing(4096)
+	go func() {
+		defer r.Close()
+		m := Msg{Price: 100, Quantity: 1}
+		for i := 0; i < b.N; i++ {
+			r.Push(&m)
+		}
+	}()
+	b.ReportAllocs()
+	var m Msg
+	for r.Pop(&m) {
+	}
+}
+
+func BenchmarkFeedRingBatch(b *testing.B) {
+	r := NewRing(4096)
+	go func() {
+		defer r.Close()
+		m := Msg{Price: 100, Quantity: 1}
+		for i := 0; i < b.N; i++ {
+			r.Push(&m)
+		}
+	}()
+	b.ReportAllocs()
+	batch := make([]Msg, r.Cap())
+	for r.PopBatch(batch) > 0 {
+	}
+}
+
+func TestFeedRingBusyPoll(t *testing.T) {
+	r := NewRing(4)
+	r.SetBusyPoll(time.Millisecond)
+	go func() {
+		defer r.Close()
+		for i := uint64(1); i <= 1000; i++ {
+			r.Push(&Msg{TradeID: i})
+			if i%100 == 0 {
+				// Longer than the spin, so the consumer also parks
+				time.Sleep(2 * time.Millisecond)
+			}
+		}
+	}()
+
+	var m Msg
+	next := uint64(1)
+	for r.Pop(&m) {
+		if m.TradeID != next {
+			t.Fatalf("popped %d, want %d", m.TradeID, next)
+		}
+		next++
+	}
+	if next != 1001 {
+		t.Errorf("ring closed after %d messages, want 1000", next-1)
+	}
+}
@@ -0,0 +1,114 @@
+package orderbook
+
+import "time"
+
+// AgeBounds are the upper bounds of the buckets QueueAges counts resting
+// orders into by age; one more bucket holds the orders older than the last.
+var AgeBounds = []time.Duration{time.Second, 10 * time.Second, time.Minute, 10 * time.Minute, time.Hour}
+
+// LevelAge describes the queue resting at one price. The ages are of the
+// orders whose entry time is known, in seconds, and 0 if there are none.
+type LevelAge struct {
+	Price   float64 `json:"price"`
+	Orders  int     `json:"orders"`
+	MeanAge float64 `json:"mean_age_seconds"`
+	MaxAge  float64 `json:"max_age_seconds"`
+}
+
+// AgeBucket counts the resting orders younger than Under and at least as
+// old as the bucket before it. The last bucket's Under is 0.
+type AgeBucket struct {
+	Under  float64 `json:"under_seconds,omitempty"`
+	Orders int     `json:"orders"`
+}
+
+// QueueAges describes how long the orders resting in a book have waited.
+type QueueAges struct {
+	Bids      []LevelAge  `json:"bids"` // best first
+	Asks      []LevelAge  `json:"asks"`
+	Histogram []AgeBucket `json:"histogram"` // every resting order, by AgeBounds
+	Unknown   int         `json:"unknown"`   // resting orders without an entry time
+}
+
+// QueueAges returns the ages of up to depth levels per side, all of them
+// for depth 0, and of every resting order, as of now.
+func (ob *Book) QueueAges(depth int, now time.Time) QueueAges {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	ages := QueueAges{Histogram: make([]AgeBucket, len(AgeBounds)+1)}
+	for i, bound := range AgeBounds {
+		ages.Histogram[i].Under = bound.Seconds()
+	}
+	for _, side := range []struct {
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+		out    *[]LevelAge
+	}{{ob.bids, &ob.bidLadder, &ages.Bids}, {ob.asks, &ob.askLadder, &ages.Asks}} {
+		prices := side.ladder.prices
+		*side.out = make([]LevelAge, 0, min(depth, len(prices)))
+		for i := len(prices) - 1; i >= 0; i-- {
+			level := side.levels[prices[i]]
+			if depth <= 0 || len(*side.out) < depth {
+				mean, oldest, _ := levelAge(level, now)
+				*side.out = append(*side.out, LevelAge{Price: level.Price, Orders: len(level.Orders),
+					MeanAge: mean.Seconds(), MaxAge: oldest.Seconds()})
+			}
+			for _, o := range level.Orders {
+				if o.EntryTime.IsZero() {
+					ages.Unknown++
+					continue
+				}
+				age := now.Sub(o.EntryTime)
+				b := 0
+				for b < len(AgeBounds) && age >= AgeBounds[b] {
+					b++
+				}
+				ages.Histogram[b].Orders++
+			}
+		}
+	}
+	return ages
+}
+
+// TouchAge returns the mean age as of now of the orders resting at the best
+// bid and ask, false if none of them has an entry time.
+func (ob *Book) TouchAge(now time.Time) (time.Duration, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	var total time.Duration
+	var known int
+	for _, side := range []struct {
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+	}{{ob.bids, &ob.bidLadder}, {ob.asks, &ob.askLadder}} {
+		if price, ok := side.ladder.best(); ok {
+			mean, _, n := levelAge(side.levels[price], now)
+			total += mean * time.Duration(n)
+			known += n
+		}
+	}
+	if known == 0 {
+		return 0, false
+	}
+	return total / time.Duration(known), true
+}
+
+// levelAge returns the mean and greatest age as of now of the orders at
+// level with an entry time, and how many there are. An order entered after
+// now is of age 0.
+func levelAge(level *LimitLevel, now time.Time) (mean, oldest time.Duration, known int) {
+	var total time.Duration
+	for _, o := range level.Orders {
+		if o.EntryTime.IsZero() {
+			continue
+		}
+		age := max(now.Sub(o.EntryTime), 0)
+		total += age
+		oldest = max(oldest, age)
+		known++
+	}
+	if known == 0 {
+		return 0, 0, 0
+	}
+	return total / time.Duration(known), oldest, known
+}
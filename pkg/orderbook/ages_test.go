@@ -0,0 +1,68 @@
+package orderbook
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+func TestQueueAges(t *testing.T) {
+	ob := New()
+	now := time.Unix(1700000000, 0)
+	at := func(ago time.Duration) time.Time { return now.Add(-ago) }
+	ob.SubmitOrder(&Order{ID: 1, Price: 99, Quantity: 100, Side: Buy, EntryTime: at(30 * time.Second)})
+	ob.SubmitOrder(&Order{ID: 2, Price: 99, Quantity: 100, Side: Buy, EntryTime: at(10 * time.Second)})
+	ob.SubmitOrder(&Order{ID: 3, Price: 98, Quantity: 100, Side: Buy, EntryTime: at(2 * time.Hour)})
+	ob.SubmitOrder(&Order{ID: 4, Price: 101, Quantity: 100, Side: Sell, EntryTime: at(500 * time.Millisecond)})
+	ob.SubmitOrder(&Order{ID: 5, Price: 101, Quantity: 100, Side: Sell})
+
+	if age, ok := ob.TouchAge(now); !ok || age != 13500*time.Millisecond {
+		t.Errorf("touch age = %v, %v, want 13.5s", age, ok)
+	}
+	// A partial fill leaves the front order its place and its age, and a
+	// cancel takes the order's age with it
+	ob.SubmitOrder(&Order{ID: 6, Price: 99, Quantity: 50, Side: Sell, EntryTime: now})
+	ob.CancelOrder(2)
+	ages := ob.QueueAges(1, now)
+	want := []LevelAge{{Price: 99, Orders: 1, MeanAge: 30, MaxAge: 30}}
+	if !slices.Equal(ages.Bids, want) || len(ages.Asks) != 1 || ages.Asks[0].MeanAge != 0.5 {
+		t.Errorf("queue ages %+v", ages)
+	}
+	var counts []int
+	for _, b := range ages.Histogram {
+		counts = append(counts, b.Orders)
+	}
+	if !slices.Equal(counts, []int{1, 0, 1, 0, 0, 1}) || ages.Unknown != 1 || ages.Histogram[5].Under != 0 {
+		t.Errorf("histogram %+v, %d unknown", ages.Histogram, ages.Unknown)
+	}
+	if ages := ob.QueueAges(0, now); len(ages.Bids) != 2 {
+		t.Errorf("all levels: %+v", ages.Bids)
+	}
+	if _, ok := New().TouchAge(now); ok {
+		t.Error("an empty book has a touch age")
+	}
+}
+
+func TestMirroredQueueAges(t *testing.T) {
+	ob := New()
+	base := int64(1700000000000)
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindReset, EventMs: base},
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 1, EventMs: base, Last: true},
+	})
+	// A level keeps the time it appeared while it is updated
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindLevel, Price: 101, Quantity: 1, EventMs: base + 1000},
+		{Kind: feed.KindLevel, Price: 101, Quantity: 3, EventMs: base + 4000},
+	})
+	now := time.UnixMilli(base + 5000)
+	ages := ob.QueueAges(0, now)
+	if ages.Asks[0].MeanAge != 4 || ages.Bids[0].MeanAge != 0 || ages.Unknown != 1 {
+		t.Errorf("mirrored queue ages %+v", ages)
+	}
+	if age, ok := ob.TouchAge(now); !ok || age != 4*time.Second {
+		t.Errorf("touch age = %v, %v, want the ask's 4s", age, ok)
+	}
+}
@@ -0,0 +1,125 @@
+package orderbook
+
+// DefaultVWAPLevels is how many levels per side a new book's BookVWAP
+// covers.
+const DefaultVWAPLevels = 10
+
+// BookVWAP is the volume-weighted average price of the volume resting in
+// the best Levels levels of each side, and of both sides together. A side
+// without volume has a VWAP of 0.
+type BookVWAP struct {
+	Levels int     `json:"levels"`
+	Bid    float64 `json:"bid"`
+	Ask    float64 `json:"ask"`
+	Both   float64 `json:"both"`
+}
+
+// depthTotals sums the volume, and the price times volume, of the best
+// levels of one side. They are kept up to date as the side changes rather
+// than summed when read.
+type depthTotals struct {
+	volume   int64
+	notional float64
+}
+
+func (t *depthTotals) add(price float64, volume int64) {
+	t.volume += volume
+	t.notional += price * float64(volume)
+	if t.volume == 0 {
+		t.notional = 0 // rather than carry rounding errors on
+	}
+}
+
+func (t *depthTotals) vwap() float64 {
+	if t.volume == 0 {
+		return 0
+	}
+	return t.notional / float64(t.volume)
+}
+
+// BookVWAP returns the book's resting VWAP over its best levels.
+func (ob *Book) BookVWAP() BookVWAP {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	both := depthTotals{volume: ob.bidTop.volume + ob.askTop.volume, notional: ob.bidTop.notional + ob.askTop.notional}
+	return BookVWAP{Levels: ob.vwapLevels, Bid: ob.bidTop.vwap(), Ask: ob.askTop.vwap(), Both: both.vwap()}
+}
+
+// SetVWAPLevels sets how many levels per side BookVWAP covers, 0 for none.
+func (ob *Book) SetVWAPLevels(n int) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.vwapLevels = max(n, 0)
+	for _, side := range []struct {
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+		top    *depthTotals
+	}{{ob.bids, &ob.bidLadder, &ob.bidTop}, {ob.asks, &ob.askLadder, &ob.askTop}} {
+		*side.top = depthTotals{}
+		prices := side.ladder.prices
+		for i := len(prices) - 1; i >= max(len(prices)-ob.vwapLevels, 0); i-- {
+			side.top.add(prices[i], int64(side.levels[prices[i]].TotalVolume))
+		}
+	}
+}
+
+// sideOf returns the levels and the best-level totals of ladder's side.
+func (ob *Book) sideOf(ladder *priceLadder) (map[float64]*LimitLevel, *depthTotals) {
+	if ladder == &ob.bidLadder {
+		return ob.bids, &ob.bidTop
+	}
+	return ob.asks, &ob.askTop
+}
+
+// inTop reports whether price, which is on ladder, is one of its best
+// vwapLevels prices.
+func (ob *Book) inTop(ladder *priceLadder, price float64) bool {
+	n, prices := ob.vwapLevels, ladder.prices
+	switch {
+	case n == 0:
+		return false
+	case len(prices) <= n:
+		return true
+	case ladder.ascending:
+		return price >= prices[len(prices)-n]
+	default:
+		return price <= prices[len(prices)-n]
+	}
+}
+
+// volumeChanged records that the volume at price, on ladder, changed by
+// delta.
+func (ob *Book) volumeChanged(ladder *priceLadder, price float64, delta int64) {
+	if ob.inTop(ladder, price) {
+		_, top := ob.sideOf(ladder)
+		top.add(price, delta)
+	}
+}
+
+// levelInserted is called once price has been inserted into ladder,
+// before its level holds any volume. A level it pushes out of the best
+// ones leaves the totals.
+func (ob *Book) levelInserted(ladder *priceLadder, price float64) {
+	n, prices := ob.vwapLevels, ladder.prices
+	if n == 0 || len(prices) <= n || !ob.inTop(ladder, price) {
+		return
+	}
+	levels, top := ob.sideOf(ladder)
+	out := prices[len(prices)-1-n]
+	top.add(out, -int64(levels[out].TotalVolume))
+}
+
+// levelRemoving is called before price, whose level holds volume, is
+// removed from ladder. The level after the best ones takes its place in
+// the totals.
+func (ob *Book) levelRemoving(ladder *priceLadder, price float64, volume uint32) {
+	if !ob.inTop(ladder, price) {
+		return
+	}
+	levels, top := ob.sideOf(ladder)
+	top.add(price, -int64(volume))
+	if n, prices := ob.vwapLevels, ladder.prices; len(prices) > n {
+		in := prices[len(prices)-1-n]
+		top.add(in, int64(levels[in].TotalVolume))
+	}
+}
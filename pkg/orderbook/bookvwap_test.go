@@ -0,0 +1,90 @@
+package orderbook
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"apexlob/pkg/feed"
+)
+
+// walkedVWAP computes what BookVWAP keeps, from the book's depth.
+func walkedVWAP(ob *Book, n int) BookVWAP {
+	bids, asks := ob.Depth(n)
+	var all depthTotals
+	side := func(levels []PriceLevel) float64 {
+		var t depthTotals
+		for _, l := range levels {
+			t.add(l.Price, int64(l.Volume))
+			all.add(l.Price, int64(l.Volume))
+		}
+		return t.vwap()
+	}
+	return BookVWAP{Levels: n, Bid: side(bids), Ask: side(asks), Both: all.vwap()}
+}
+
+func closeVWAP(a, b BookVWAP) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) <= 1e-9*math.Max(1, math.Abs(y)) }
+	return a.Levels == b.Levels && near(a.Bid, b.Bid) && near(a.Ask, b.Ask) && near(a.Both, b.Both)
+}
+
+func TestBookVWAP(t *testing.T) {
+	ob := New()
+	ob.SubmitOrder(&Order{ID: 1, Price: 99, Quantity: 100, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 2, Price: 98, Quantity: 300, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 3, Price: 101, Quantity: 200, Side: Sell})
+	want := BookVWAP{Levels: DefaultVWAPLevels, Bid: 98.25, Ask: 101, Both: (9900 + 29400 + 20200) / 600.0}
+	if got := ob.BookVWAP(); !closeVWAP(got, want) {
+		t.Errorf("book VWAP = %+v, want %+v", got, want)
+	}
+	ob.SetVWAPLevels(1)
+	if got := ob.BookVWAP(); !closeVWAP(got, BookVWAP{Levels: 1, Bid: 99, Ask: 101, Both: 30100 / 300.0}) {
+		t.Errorf("over one level: %+v", got)
+	}
+	if got := New().BookVWAP(); got != (BookVWAP{Levels: DefaultVWAPLevels}) {
+		t.Errorf("empty book: %+v", got)
+	}
+}
+
+// TestBookVWAPIncremental checks the totals kept as the book changes
+// against walking it, through matching, cancels, evictions and mirrored
+// updates.
+func TestBookVWAPIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, levels := range []int{1, 3, 10} {
+		ob := New()
+		ob.SetVWAPLevels(levels)
+		ob.SetLimits(Limits{MaxLevels: 12, MaxOrders: 60})
+		for i := 0; i < 5000; i++ {
+			side := Buy
+			if rng.Intn(2) == 0 {
+				side = Sell
+			}
+			price := 100 + float64(rng.Intn(30)) - 15
+			if rng.Intn(4) == 0 {
+				ob.CancelOrder(uint64(rng.Intn(i + 1)))
+			} else {
+				ob.SubmitOrder(&Order{ID: uint64(i), Price: price, Quantity: uint32(1 + rng.Intn(50)), Side: side})
+			}
+			if got, want := ob.BookVWAP(), walkedVWAP(ob, levels); !closeVWAP(got, want) {
+				t.Fatalf("%d levels, after %d orders: %+v, walked %+v", levels, i, got, want)
+			}
+		}
+
+		mirror := New()
+		mirror.SetVWAPLevels(levels)
+		mirror.SetLimits(Limits{MaxLevels: 12})
+		for i := 0; i < 3000; i++ {
+			var msgs []feed.Msg
+			if i%1000 == 0 {
+				msgs = append(msgs, feed.Msg{Kind: feed.KindReset})
+			}
+			price := 100 + float64(rng.Intn(30)) - 15
+			msgs = append(msgs, feed.Msg{Kind: feed.KindLevel, Bid: price < 100, Price: price, Quantity: float64(rng.Intn(4)), Last: true})
+			mirror.ApplyMirrored(msgs)
+			if got, want := mirror.BookVWAP(), walkedVWAP(mirror, levels); !closeVWAP(got, want) {
+				t.Fatalf("%d levels, after %d depth updates: %+v, walked %+v", levels, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package orderbook
+
+import (
+	"fmt"
+	"math"
+)
+
+// CheckInvariants checks the book's internal consistency and returns the
+// first broken invariant it finds, or nil:
+//
+//   - each side's ladder is sorted, without duplicates, and holds exactly
+//     the prices of its levels;
+//   - every level holds orders of its own side and price, none of them
+//     empty, and its TotalVolume is theirs;
+//   - the resting count, and the index of orders by ID, agree with the
+//     levels;
+//   - the best bid is below the best ask;
+//   - the book keeps within its Limits;
+//   - the BookVWAP totals are those of its best levels, and the published
+//     trade totals are the book's own.
+//
+// A matching book keeps all of them after every call. A mirrored book is
+// only as uncrossed as its feed, so it may fail the best bid and ask check
+// while the exchange's book is crossed. It is meant for tests and fuzzing:
+// it walks the whole book under the lock.
+func (ob *Book) CheckInvariants() error {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	resting := make(map[*Order]bool, ob.resting)
+	for _, side := range []struct {
+		side   Side
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+		top    depthTotals
+	}{{Buy, ob.bids, &ob.bidLadder, ob.bidTop}, {Sell, ob.asks, &ob.askLadder, ob.askTop}} {
+		prices := side.ladder.prices
+		if len(prices) != len(side.levels) {
+			return fmt.Errorf("%s ladder has %d prices for %d levels", side.side, len(prices), len(side.levels))
+		}
+		if max := ob.limits.MaxLevels; max > 0 && len(prices) > max {
+			return fmt.Errorf("%s side has %d levels, over the limit of %d", side.side, len(prices), max)
+		}
+		var top depthTotals
+		for i, price := range prices {
+			if i > 0 && (side.ladder.ascending && price <= prices[i-1] || !side.ladder.ascending && price >= prices[i-1]) {
+				return fmt.Errorf("%s ladder out of order at %v after %v", side.side, price, prices[i-1])
+			}
+			level := side.levels[price]
+			switch {
+			case level == nil:
+				return fmt.Errorf("%s ladder price %v has no level", side.side, price)
+			case level.Price != price:
+				return fmt.Errorf("%s level at %v says its price is %v", side.side, price, level.Price)
+			case len(level.Orders) == 0:
+				return fmt.Errorf("%s level at %v has no orders", side.side, price)
+			}
+			var volume uint32
+			for _, o := range level.Orders {
+				switch {
+				case o.Quantity == 0:
+					return fmt.Errorf("order %d rests at %v with no quantity", o.ID, price)
+				case o.Side != side.side || o.Price != price:
+					return fmt.Errorf("order %d, a %s at %v, rests on the %s side at %v", o.ID, o.Side, o.Price, side.side, price)
+				case resting[o]:
+					return fmt.Errorf("order %d rests twice", o.ID)
+				}
+				resting[o] = true
+				volume += o.Quantity
+			}
+			if volume != level.TotalVolume {
+				return fmt.Errorf("%s level at %v has a total volume of %d, its orders %d", side.side, price, level.TotalVolume, volume)
+			}
+			if i >= len(prices)-ob.vwapLevels {
+				top.add(price, int64(volume))
+			}
+		}
+		if top.volume != side.top.volume || !closeTo(top.notional, side.top.notional) {
+			return fmt.Errorf("%s VWAP totals are %+v, its best %d levels %+v", side.side, side.top, ob.vwapLevels, top)
+		}
+	}
+
+	if len(resting) != ob.resting {
+		return fmt.Errorf("%d orders counted as resting, %d found", ob.resting, len(resting))
+	}
+	if max := ob.limits.MaxOrders; max > 0 && ob.resting > max {
+		return fmt.Errorf("%d resting orders, over the limit of %d", ob.resting, max)
+	}
+	for id, o := range ob.orders {
+		if o.ID != id || !resting[o] {
+			return fmt.Errorf("order %d is indexed but not resting", id)
+		}
+	}
+	bid, okBid := ob.bidLadder.best()
+	ask, okAsk := ob.askLadder.best()
+	if okBid && okAsk && bid >= ask {
+		return fmt.Errorf("book is crossed: best bid %v, best ask %v", bid, ask)
+	}
+	if t := ob.totals.load(); t != (TradeTotals{LastPrice: ob.lastTradePrice, Volume: ob.totalVolume, Notional: ob.cumulativeNotional}) {
+		return fmt.Errorf("published trade totals %+v are not the book's", t)
+	}
+	return nil
+}
+
+// closeTo reports whether two sums of the same terms, added up in a
+// different order, are equal but for rounding.
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,105 @@
+package orderbook
+
+import (
+	"math"
+	"testing"
+
+	"apexlob/pkg/feed"
+)
+
+func TestCheckInvariants(t *testing.T) {
+	build := func() *Book {
+		ob := New()
+		ob.SubmitOrder(&Order{ID: 1, Price: 99, Quantity: 100, Side: Buy})
+		ob.SubmitOrder(&Order{ID: 2, Price: 99, Quantity: 50, Side: Buy})
+		ob.SubmitOrder(&Order{ID: 3, Price: 101, Quantity: 200, Side: Sell})
+		ob.SubmitOrder(&Order{ID: 4, Price: 99, Quantity: 30, Side: Sell})
+		return ob
+	}
+	if err := build().CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(ob *Book){
+		"level volume":  func(ob *Book) { ob.bids[99].TotalVolume++ },
+		"ladder order":  func(ob *Book) { ob.askLadder.prices = append(ob.askLadder.prices, 102) },
+		"missing level": func(ob *Book) { delete(ob.asks, 101) },
+		"resting count": func(ob *Book) { ob.resting++ },
+		"stale index":   func(ob *Book) { ob.orders[7] = &Order{ID: 7} },
+		"crossed":       func(ob *Book) { ob.addLimit(&Order{ID: 9, Price: 98, Quantity: 1, Side: Sell}, ob.asks, &ob.askLadder) },
+		"vwap totals":   func(ob *Book) { ob.askTop.volume-- },
+		"trade totals":  func(ob *Book) { ob.totalVolume++ },
+		"limits":        func(ob *Book) { ob.limits.MaxOrders = 1 },
+	} {
+		ob := build()
+		corrupt(ob)
+		if err := ob.CheckInvariants(); err == nil {
+			t.Errorf("%s: corrupted book passes", name)
+		}
+	}
+
+	// A mirrored book is checked the same way
+	mirror := New()
+	mirror.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindReset},
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 2},
+		{Kind: feed.KindLevel, Price: 101, Quantity: 1, Last: true},
+	})
+	if err := mirror.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+// FuzzSubmitOrder runs a sequence of orders and cancels, decoded from the
+// input, through a book and checks its invariants after each one. Each
+// operation takes 4 bytes: the kind, the price and a 16-bit quantity; the
+// limits come from their own byte. Price bytes 0xfd-0xff submit NaN, +Inf
+// and -Inf, which the book must refuse.
+//
+//	go test ./pkg/orderbook -run '^$' -fuzz FuzzSubmitOrder
+func FuzzSubmitOrder(f *testing.F) {
+	f.Add(uint8(0), []byte{0, 10, 0, 100, 1, 10, 0, 40, 1, 12, 0, 80, 0, 14, 1, 0})
+	f.Add(uint8(0x23), []byte{0, 5, 0, 9, 0, 6, 0, 9, 0, 7, 0, 9, 1, 2, 0, 50, 3, 1, 0, 0})
+	f.Add(uint8(0x5f), []byte{2, 3, 0, 1, 1, 3, 255, 255, 0, 3, 255, 255, 3, 2, 0, 0, 1, 0, 0, 7})
+	f.Add(uint8(0), []byte{0, 4, 0, 10, 1, 8, 0, 10, 0, 0xfd, 0, 5, 1, 0xfd, 0, 5, 0, 0xfe, 0, 5, 1, 0xff, 0, 5})
+	f.Fuzz(func(t *testing.T, limits uint8, ops []byte) {
+		ob := New()
+		ob.SetLimits(Limits{MaxLevels: int(limits & 7), MaxOrders: int(limits >> 3)})
+		ob.SetVWAPLevels(int(limits % 5))
+		var taker uint64
+		var filled uint32
+		ob.SetExecutionHandler(func(e Execution) {
+			if e.TakerID != taker || e.MakerID >= taker || e.Quantity == 0 {
+				t.Fatalf("order %d executed %+v", taker, e)
+			}
+			filled += e.Quantity
+		})
+		for id := uint64(1); len(ops) >= 4; id, ops = id+1, ops[4:] {
+			kind, price, quantity := ops[0], 90+float64(ops[1]%32)/2, uint32(ops[2])<<8|uint32(ops[3])
+			finite := ops[1] < 0xfd
+			if !finite {
+				price = [...]float64{math.NaN(), math.Inf(1), math.Inf(-1)}[ops[1]-0xfd]
+			}
+			if kind%4 == 3 {
+				// A cancel, of an order submitted up to 256 operations ago
+				ob.CancelOrder(id - 1 - uint64(ops[1]))
+			} else {
+				side := Buy
+				if kind%2 == 1 {
+					side = Sell
+				}
+				order := &Order{ID: id, Price: price, Quantity: quantity, Side: side}
+				taker, filled = id, 0
+				rested := ob.SubmitOrder(order)
+				if !finite && (rested || filled > 0) {
+					t.Fatalf("order %d at %v filled %d, rested %v", id, price, filled, rested)
+				}
+				if finite && rested != (filled < quantity) {
+					t.Fatalf("order %d for %d filled %d, rested %v", id, quantity, filled, rested)
+				}
+			}
+			if err := ob.CheckInvariants(); err != nil {
+				t.Fatalf("after operation %d: %v", id, err)
+			}
+		}
+	})
+}
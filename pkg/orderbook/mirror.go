@@ -0,0 +1,176 @@
+package orderbook
+
+import (
+	"math"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+// ScaleQuantity converts a feed quantity to the book's integer units of
+// 1/1000, saturating rather than wrapping for very large amounts.
+func ScaleQuantity(q float64) uint32 {
+	if scaled := q * 1000; scaled < math.MaxUint32 {
+		return uint32(scaled)
+	}
+	return math.MaxUint32
+}
+
+// MirrorActivity tallies what the exchange has done to a mirrored book:
+// volume that depth updates added to and removed from its levels, and the
+// trades reported against it, all in scaled quantity units. The levels of
+// a snapshot being loaded are not activity.
+type MirrorActivity struct {
+	Updates      uint64 `json:"updates"` // level changes
+	Added        uint64 `json:"added"`
+	Removed      uint64 `json:"removed"`
+	Trades       uint64 `json:"trades"`
+	TradedVolume uint64 `json:"traded_volume"`
+}
+
+// MirrorActivity returns the book's activity since it was created.
+func (ob *Book) MirrorActivity() MirrorActivity {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.activity
+}
+
+// ApplyMirrored applies feed messages to a mirrored book in order under a
+// single lock: depth levels replace the level at their price, resets empty
+// the book and trades are added to the totals. Like a submitted order,
+// every message counts towards the book's checkpoint.
+func (ob *Book) ApplyMirrored(msgs []feed.Msg) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	traded := false
+	for i := range msgs {
+		m := &msgs[i]
+		ob.submitted++
+		switch m.Kind {
+		case feed.KindReset:
+			ob.clearSide(ob.bids, &ob.bidLadder)
+			ob.clearSide(ob.asks, &ob.askLadder)
+			ob.loading = !m.Last // an empty snapshot ends with its reset
+		case feed.KindLevel:
+			side := Sell
+			if m.Bid {
+				side = Buy
+			}
+			ob.setLevel(side, m.Price, ScaleQuantity(m.Quantity), mirroredTime(m))
+			if m.Last {
+				ob.loading = false
+			}
+		default:
+			qty := ScaleQuantity(m.Quantity)
+			ob.activity.Trades++
+			ob.activity.TradedVolume += uint64(qty)
+			ob.lastTradePrice = m.Price
+			ob.totalVolume += qty
+			ob.cumulativeNotional += float64(qty) * m.Price
+			traded = true
+		}
+	}
+	if traded {
+		ob.totals.store(TradeTotals{LastPrice: ob.lastTradePrice, Volume: ob.totalVolume, Notional: ob.cumulativeNotional})
+	}
+}
+
+// mirroredTime is when a feed message says its change happened: the
+// exchange's event time, or when it was received if it has none.
+func mirroredTime(m *feed.Msg) time.Time {
+	if m.EventMs > 0 {
+		return time.UnixMilli(m.EventMs)
+	}
+	return m.Received
+}
+
+// setLevel replaces the level at price with a single resting quantity, or
+// removes it for quantity 0. It never matches: a mirror shows the book as
+// the exchange sent it. The quantity's order ID is the count of messages
+// the book had applied when it was set, which is unique within the book.
+//
+// The quantity's entry time is when the level appeared, at, and stays so
+// while the level is updated: a depth feed does not say which orders at a
+// price changed, so the level ages as its oldest order would. The levels
+// of a snapshot have no entry time, as the exchange does not say how long
+// they had been resting.
+func (ob *Book) setLevel(side Side, price float64, quantity uint32, at time.Time) {
+	sideMap, ladder := ob.bids, &ob.bidLadder
+	if side == Sell {
+		sideMap, ladder = ob.asks, &ob.askLadder
+	}
+	level, exists := sideMap[price]
+	if !ob.loading {
+		var before uint32
+		if exists {
+			before = level.TotalVolume
+		}
+		switch {
+		case quantity > before:
+			ob.activity.Added += uint64(quantity - before)
+		case quantity < before:
+			ob.activity.Removed += uint64(before - quantity)
+		}
+		if quantity != before {
+			ob.activity.Updates++
+		}
+	}
+	if ob.loading {
+		at = time.Time{}
+	}
+	if exists {
+		if len(level.Orders) > 0 {
+			at = level.Orders[0].EntryTime
+		}
+		before := level.TotalVolume
+		ob.dropOrders(level)
+		ob.volumeChanged(ladder, price, -int64(before))
+	}
+	if quantity == 0 {
+		if exists {
+			ob.levelRemoving(ladder, price, 0)
+			delete(sideMap, price)
+			ladder.remove(price)
+			ob.releaseLevel(level)
+		}
+		return
+	}
+	if !exists {
+		level = ob.newLevel(price)
+		sideMap[price] = level
+		ladder.insert(price)
+		ob.levelInserted(ladder, price)
+	}
+	order := AcquireOrder()
+	order.ID, order.Price, order.Quantity, order.Side, order.EntryTime = ob.submitted, price, quantity, side, at
+	level.Orders = append(level.Orders, order)
+	level.TotalVolume = quantity
+	ob.volumeChanged(ladder, price, int64(quantity))
+	ob.resting++
+	ob.enforceLimits()
+}
+
+func (ob *Book) clearSide(sideMap map[float64]*LimitLevel, ladder *priceLadder) {
+	for price, level := range sideMap {
+		ob.dropOrders(level)
+		delete(sideMap, price)
+		ob.releaseLevel(level)
+	}
+	ladder.prices = ladder.prices[:0]
+	_, top := ob.sideOf(ladder)
+	*top = depthTotals{}
+}
+
+// dropOrders releases every order resting at level and empties it.
+func (ob *Book) dropOrders(level *LimitLevel) {
+	for i, o := range level.Orders {
+		if ob.orders[o.ID] == o {
+			delete(ob.orders, o.ID)
+		}
+		ReleaseOrder(o)
+		level.Orders[i] = nil
+	}
+	ob.resting -= len(level.Orders)
+	level.Orders = level.Orders[:0]
+	level.TotalVolume = 0
+}
@@ -0,0 +1,93 @@
+package orderbook
+
+import (
+	"math"
+	"testing"
+
+	"apexlob/pkg/feed"
+)
+
+func TestScaleQuantity(t *testing.T) {
+	if ScaleQuantity(1.5) != 1500 || ScaleQuantity(1e12) != math.MaxUint32 {
+		t.Errorf("ScaleQuantity = %d, %d", ScaleQuantity(1.5), ScaleQuantity(1e12))
+	}
+}
+
+func TestApplyMirrored(t *testing.T) {
+	ob := New()
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindReset},
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 1},
+		{Kind: feed.KindLevel, Bid: true, Price: 98, Quantity: 2},
+		{Kind: feed.KindLevel, Price: 101, Quantity: 3},
+	})
+	// Levels are replaced, not added to, and crossing levels never match
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 4},
+		{Kind: feed.KindLevel, Bid: true, Price: 98},
+		{Kind: feed.KindLevel, Price: 99.5, Quantity: 1},
+		{Kind: feed.KindTrade, Price: 100, Quantity: 2},
+	})
+	if p, q, _ := ob.GetBestBid(); p != 99 || q != 4000 {
+		t.Errorf("best bid = %v x %d, want 99 x 4000", p, q)
+	}
+	if p, q, _ := ob.GetBestAsk(); p != 99.5 || q != 1000 {
+		t.Errorf("best ask = %v x %d, want 99.5 x 1000", p, q)
+	}
+	bids, asks := ob.Depth(10)
+	if len(bids) != 1 || len(asks) != 2 {
+		t.Errorf("depth = %v / %v", bids, asks)
+	}
+	if totals := ob.TradeTotals(); totals.LastPrice != 100 || totals.Volume != 2000 {
+		t.Errorf("totals = %+v", totals)
+	}
+	if cp := ob.Checkpoint(); cp.Orders != 8 {
+		t.Errorf("checkpoint counted %d messages, want 8", cp.Orders)
+	}
+	// A mirrored book serializes and loads like any other
+	restored, err := FromState(ob.State())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Checkpoint() != ob.Checkpoint() {
+		t.Error("restored mirrored book differs")
+	}
+
+	ob.ApplyMirrored([]feed.Msg{{Kind: feed.KindReset}})
+	if _, _, ok := ob.GetBestBid(); ok {
+		t.Error("reset left bids")
+	}
+	if stats := ob.Stats(); stats.RestingOrders != 0 || stats.BidLevels+stats.AskLevels != 0 {
+		t.Errorf("reset left %+v", stats)
+	}
+}
+
+func TestMirrorActivity(t *testing.T) {
+	ob := New()
+	// A snapshot loads levels without adding to the activity
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindReset},
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 1},
+		{Kind: feed.KindLevel, Bid: true, Price: 98, Quantity: 2, Last: true},
+	})
+	if a := ob.MirrorActivity(); a != (MirrorActivity{}) {
+		t.Fatalf("snapshot counted as %+v", a)
+	}
+	ob.ApplyMirrored([]feed.Msg{
+		{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 4},
+		{Kind: feed.KindLevel, Bid: true, Price: 98},
+		{Kind: feed.KindLevel, Price: 99.5, Quantity: 1},
+		{Kind: feed.KindLevel, Price: 99.5, Quantity: 1, Last: true},
+		{Kind: feed.KindTrade, Price: 99.5, Quantity: 0.5},
+	})
+	want := MirrorActivity{Updates: 3, Added: 4000, Removed: 2000, Trades: 1, TradedVolume: 500}
+	if a := ob.MirrorActivity(); a != want {
+		t.Errorf("activity = %+v, want %+v", a, want)
+	}
+	// An empty snapshot ends with its reset
+	ob.ApplyMirrored([]feed.Msg{{Kind: feed.KindReset, Last: true}})
+	ob.ApplyMirrored([]feed.Msg{{Kind: feed.KindLevel, Price: 100, Quantity: 1, Last: true}})
+	if a := ob.MirrorActivity(); a.Added != 5000 {
+		t.Errorf("added %d after an empty snapshot, want 5000", a.Added)
+	}
+}
@@ -0,0 +1,528 @@
+// Package orderbook is a price-time priority limit order book: a matching
+// engine for one symbol, the mirror of an exchange's own book, and the
+// snapshots that save and restore either.
+package orderbook
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+)
+
+type Book struct {
+	bids               map[float64]*LimitLevel
+	asks               map[float64]*LimitLevel
+	bidLadder          priceLadder
+	askLadder          priceLadder
+	orders             map[uint64]*Order // resting orders by ID, for cancels
+	resting            int
+	freeLevels         []*LimitLevel
+	limits             Limits
+	evictedLevels      uint64
+	evictedOrders      uint64
+	submitted          uint64
+	mu                 sync.RWMutex
+	lastTradePrice     float64
+	totalVolume        uint32
+	cumulativeNotional float64
+	totals             totalsSeqlock // lock-free copy of the trade aggregates for readers
+	onExecution        func(Execution)
+	activity           MirrorActivity // of a mirrored book
+	loading            bool           // a mirrored book is taking a snapshot's levels
+	vwapLevels         int            // see BookVWAP
+	bidTop, askTop     depthTotals
+}
+
+func New() *Book {
+	return &Book{
+		bids:       make(map[float64]*LimitLevel),
+		asks:       make(map[float64]*LimitLevel),
+		bidLadder:  priceLadder{ascending: true},
+		orders:     make(map[uint64]*Order),
+		vwapLevels: DefaultVWAPLevels,
+	}
+}
+
+// Limits caps how much a book holds, so a long run replaying trades
+// into it cannot grow without bound. When a side has more than MaxLevels
+// prices, or the book more than MaxOrders resting orders, the levels
+// furthest from the touch are evicted. Zero means no cap.
+type Limits struct {
+	MaxLevels int // per side
+	MaxOrders int
+}
+
+// Stats describes the size of a book.
+type Stats struct {
+	BidLevels     int    `json:"bid_levels"`
+	AskLevels     int    `json:"ask_levels"`
+	RestingOrders int    `json:"resting_orders"`
+	EvictedLevels uint64 `json:"evicted_levels"`
+	EvictedOrders uint64 `json:"evicted_orders"`
+}
+
+func (ob *Book) SetLimits(limits Limits) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.limits = limits
+	ob.enforceLimits()
+}
+
+func (ob *Book) Limits() Limits {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.limits
+}
+
+func (ob *Book) Stats() Stats {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return Stats{
+		BidLevels:     len(ob.bidLadder.prices),
+		AskLevels:     len(ob.askLadder.prices),
+		RestingOrders: ob.resting,
+		EvictedLevels: ob.evictedLevels,
+		EvictedOrders: ob.evictedOrders,
+	}
+}
+
+// priceLadder keeps one side's prices sorted from worst to best, so the best
+// level is the last element: matching reads and pops it without sorting, and
+// inserts reuse the slice's capacity.
+type priceLadder struct {
+	prices    []float64
+	ascending bool // bids: ascending, so the highest is last
+}
+
+func (l *priceLadder) search(price float64) int {
+	if l.ascending {
+		return sort.SearchFloat64s(l.prices, price)
+	}
+	return sort.Search(len(l.prices), func(i int) bool { return l.prices[i] <= price })
+}
+
+func (l *priceLadder) insert(price float64) {
+	i := l.search(price)
+	l.prices = append(l.prices, 0)
+	copy(l.prices[i+1:], l.prices[i:])
+	l.prices[i] = price
+}
+
+func (l *priceLadder) remove(price float64) {
+	if i := l.search(price); i < len(l.prices) && l.prices[i] == price {
+		l.prices = append(l.prices[:i], l.prices[i+1:]...)
+	}
+}
+
+func (l *priceLadder) best() (float64, bool) {
+	if len(l.prices) == 0 {
+		return 0, false
+	}
+	return l.prices[len(l.prices)-1], true
+}
+
+func (l *priceLadder) popBest() { l.prices = l.prices[:len(l.prices)-1] }
+
+func (l *priceLadder) popWorst() float64 {
+	price := l.prices[0]
+	l.prices = append(l.prices[:0], l.prices[1:]...)
+	return price
+}
+
+// SetExecutionHandler registers a callback invoked for every fill. It runs
+// with the book locked, so it must be quick and must not call back into the
+// book.
+func (ob *Book) SetExecutionHandler(h func(Execution)) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.onExecution = h
+}
+
+// SubmitOrder matches the order and rests any remainder, reporting whether
+// it rested. A resting pooled order belongs to the book from then on and is
+// released when filled, cancelled or evicted, so the caller must not touch
+// it again; otherwise the caller still owns it. An order whose price is NaN
+// or infinite neither matches nor rests.
+func (ob *Book) SubmitOrder(order *Order) (rested bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.submitLocked(order)
+}
+
+// SubmitOrders submits orders in turn under a single lock acquisition and
+// appends to rested whether each one rested, with the ownership rules of
+// SubmitOrder.
+func (ob *Book) SubmitOrders(orders []*Order, rested []bool) []bool {
+	rested, _ = ob.SubmitOrdersContext(context.Background(), orders, rested)
+	return rested
+}
+
+// SubmitOrdersContext is SubmitOrders, stopping early with ctx's error once
+// ctx is done. Only the orders with an entry appended to rested were
+// submitted; the caller still owns the rest.
+func (ob *Book) SubmitOrdersContext(ctx context.Context, orders []*Order, rested []bool) ([]bool, error) {
+	done := ctx.Done()
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	for _, order := range orders {
+		select {
+		case <-done:
+			return rested, ctx.Err()
+		default:
+		}
+		rested = append(rested, ob.submitLocked(order))
+	}
+	return rested, nil
+}
+
+func (ob *Book) submitLocked(order *Order) bool {
+	// NaN fails every price comparison in matchOrder, so it would cross
+	// every level
+	if math.IsNaN(order.Price) || math.IsInf(order.Price, 0) {
+		return false
+	}
+	ob.submitted++
+	if order.Side == Buy {
+		ob.matchOrder(order, ob.asks, &ob.askLadder, true)
+		if order.Quantity == 0 {
+			return false
+		}
+		ob.addLimit(order, ob.bids, &ob.bidLadder)
+	} else {
+		ob.matchOrder(order, ob.bids, &ob.bidLadder, false)
+		if order.Quantity == 0 {
+			return false
+		}
+		ob.addLimit(order, ob.asks, &ob.askLadder)
+	}
+	// The order may be evicted straight away if it rests beyond the caps
+	ob.enforceLimits()
+	return true
+}
+
+func (ob *Book) enforceLimits() {
+	if max := ob.limits.MaxLevels; max > 0 {
+		for len(ob.bidLadder.prices) > max {
+			ob.evictWorst(ob.bids, &ob.bidLadder)
+		}
+		for len(ob.askLadder.prices) > max {
+			ob.evictWorst(ob.asks, &ob.askLadder)
+		}
+	}
+	for max := ob.limits.MaxOrders; max > 0 && ob.resting > max; {
+		if len(ob.bidLadder.prices) >= len(ob.askLadder.prices) {
+			ob.evictWorst(ob.bids, &ob.bidLadder)
+		} else {
+			ob.evictWorst(ob.asks, &ob.askLadder)
+		}
+	}
+}
+
+// evictWorst drops the level furthest from the touch and releases its
+// orders.
+func (ob *Book) evictWorst(sideMap map[float64]*LimitLevel, ladder *priceLadder) {
+	level := sideMap[ladder.prices[0]]
+	ob.levelRemoving(ladder, level.Price, level.TotalVolume)
+	price := ladder.popWorst()
+	delete(sideMap, price)
+	for i, o := range level.Orders {
+		if ob.orders[o.ID] == o {
+			delete(ob.orders, o.ID)
+		}
+		ReleaseOrder(o)
+		level.Orders[i] = nil
+	}
+	ob.resting -= len(level.Orders)
+	ob.evictedOrders += uint64(len(level.Orders))
+	ob.evictedLevels++
+	ob.releaseLevel(level)
+}
+
+// CancelOrder removes a resting order, reporting whether it was found.
+func (ob *Book) CancelOrder(id uint64) bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	order, ok := ob.orders[id]
+	if !ok {
+		return false
+	}
+	sideMap, ladder := ob.bids, &ob.bidLadder
+	if order.Side == Sell {
+		sideMap, ladder = ob.asks, &ob.askLadder
+	}
+	level := sideMap[order.Price]
+	for i, o := range level.Orders {
+		if o == order {
+			level.removeAt(i)
+			break
+		}
+	}
+	level.TotalVolume -= order.Quantity
+	ob.volumeChanged(ladder, level.Price, -int64(order.Quantity))
+	delete(ob.orders, id)
+	ob.resting--
+	ReleaseOrder(order)
+	if len(level.Orders) == 0 {
+		ob.levelRemoving(ladder, level.Price, 0)
+		delete(sideMap, level.Price)
+		ladder.remove(level.Price)
+		ob.releaseLevel(level)
+	}
+	return true
+}
+
+func (ob *Book) matchOrder(order *Order, oppositeSide map[float64]*LimitLevel, ladder *priceLadder, isBuy bool) {
+	filled := false
+	for order.Quantity > 0 {
+		// Best opposite level: lowest ask for a buy, highest bid for a sell
+		price, ok := ladder.best()
+		if !ok || (isBuy && order.Price < price) || (!isBuy && order.Price > price) {
+			break
+		}
+		level := oppositeSide[price]
+
+		// Match against orders at this level in time priority
+		for len(level.Orders) > 0 && order.Quantity > 0 {
+			existingOrder := level.Orders[0]
+
+			tradedQty := order.Quantity
+			if existingOrder.Quantity < tradedQty {
+				tradedQty = existingOrder.Quantity
+			}
+
+			ob.lastTradePrice = price
+			ob.totalVolume += tradedQty
+			ob.cumulativeNotional += float64(tradedQty) * price
+			filled = true
+			if ob.onExecution != nil {
+				ob.onExecution(Execution{
+					TakerID:   order.ID,
+					MakerID:   existingOrder.ID,
+					Side:      order.Side,
+					Price:     price,
+					Quantity:  tradedQty,
+					Timestamp: order.EntryTime,
+				})
+			}
+
+			order.Quantity -= tradedQty
+			existingOrder.Quantity -= tradedQty
+			level.TotalVolume -= tradedQty
+			ob.volumeChanged(ladder, price, -int64(tradedQty))
+
+			if existingOrder.Quantity == 0 {
+				level.removeAt(0)
+				ob.resting--
+				if ob.orders[existingOrder.ID] == existingOrder {
+					delete(ob.orders, existingOrder.ID)
+				}
+				ReleaseOrder(existingOrder)
+			}
+		}
+
+		// Remove empty level
+		if len(level.Orders) == 0 {
+			ob.levelRemoving(ladder, price, 0)
+			delete(oppositeSide, price)
+			ladder.popBest()
+			ob.releaseLevel(level)
+		}
+	}
+	// Publish once per order rather than per fill
+	if filled {
+		ob.totals.store(TradeTotals{LastPrice: ob.lastTradePrice, Volume: ob.totalVolume, Notional: ob.cumulativeNotional})
+	}
+}
+
+func (ob *Book) addLimit(order *Order, sideMap map[float64]*LimitLevel, ladder *priceLadder) {
+	level, exists := sideMap[order.Price]
+	if !exists {
+		level = ob.newLevel(order.Price)
+		sideMap[order.Price] = level
+		ladder.insert(order.Price)
+		ob.levelInserted(ladder, order.Price)
+	}
+	level.TotalVolume += order.Quantity
+	ob.volumeChanged(ladder, order.Price, int64(order.Quantity))
+	level.Orders = append(level.Orders, order)
+	ob.orders[order.ID] = order
+	ob.resting++
+}
+
+// newLevel reuses an emptied level, and its Orders capacity, when there is
+// one, so a book that churns through prices stops allocating.
+func (ob *Book) newLevel(price float64) *LimitLevel {
+	n := len(ob.freeLevels)
+	if n == 0 {
+		return &LimitLevel{Price: price}
+	}
+	level := ob.freeLevels[n-1]
+	ob.freeLevels = ob.freeLevels[:n-1]
+	level.Price = price
+	return level
+}
+
+func (ob *Book) releaseLevel(level *LimitLevel) {
+	level.TotalVolume = 0
+	level.Orders = level.Orders[:0]
+	ob.freeLevels = append(ob.freeLevels, level)
+}
+
+// TradeTotals returns a consistent snapshot of the trade aggregates without
+// taking the book lock, so pollers never contend with matching.
+func (ob *Book) TradeTotals() TradeTotals {
+	return ob.totals.load()
+}
+
+// Checkpoint fingerprints a book's whole state after a number of
+// submitted orders, so a replay of the same orders can be checked against
+// it exactly.
+type Checkpoint struct {
+	Orders        uint64      `json:"orders"` // submitted so far
+	Totals        TradeTotals `json:"totals"`
+	BidLevels     int         `json:"bid_levels"`
+	AskLevels     int         `json:"ask_levels"`
+	RestingOrders int         `json:"resting_orders"`
+	// Digest hashes every level's price and volume and every resting
+	// order's ID and quantity, in priority order
+	Digest uint64 `json:"digest"`
+}
+
+func (ob *Book) Checkpoint() Checkpoint {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	h := fnv.New64a()
+	var buf [8]byte
+	put := func(v uint64) {
+		binary.LittleEndian.PutUint64(buf[:], v)
+		h.Write(buf[:])
+	}
+	for _, side := range []struct {
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+	}{{ob.bids, &ob.bidLadder}, {ob.asks, &ob.askLadder}} {
+		put(uint64(len(side.ladder.prices)))
+		for i := len(side.ladder.prices) - 1; i >= 0; i-- {
+			level := side.levels[side.ladder.prices[i]]
+			put(math.Float64bits(level.Price))
+			put(uint64(level.TotalVolume))
+			put(uint64(len(level.Orders)))
+			for _, o := range level.Orders {
+				put(o.ID)
+				put(uint64(o.Quantity))
+			}
+		}
+	}
+	return Checkpoint{
+		Orders:        ob.submitted,
+		Totals:        TradeTotals{LastPrice: ob.lastTradePrice, Volume: ob.totalVolume, Notional: ob.cumulativeNotional},
+		BidLevels:     len(ob.bidLadder.prices),
+		AskLevels:     len(ob.askLadder.prices),
+		RestingOrders: ob.resting,
+		Digest:        h.Sum64(),
+	}
+}
+
+// RestoreTotals sets the trade aggregates, e.g. from a saved session, so
+// later trades add to them rather than starting from zero.
+func (ob *Book) RestoreTotals(t TradeTotals) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.lastTradePrice, ob.totalVolume, ob.cumulativeNotional = t.LastPrice, t.Volume, t.Notional
+	ob.totals.store(t)
+}
+
+func (ob *Book) GetLastTradePrice() float64 { return ob.totals.load().LastPrice }
+
+func (ob *Book) GetVWAP() float64 { return ob.totals.load().VWAP() }
+
+func (ob *Book) GetTotalVolume() uint32 { return ob.totals.load().Volume }
+
+func (ob *Book) GetCumulativeNotional() float64 { return ob.totals.load().Notional }
+
+func (ob *Book) GetBestBid() (float64, uint32, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return bestLevel(ob.bids, &ob.bidLadder)
+}
+
+func (ob *Book) GetBestAsk() (float64, uint32, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return bestLevel(ob.asks, &ob.askLadder)
+}
+
+// LevelVolume returns the volume resting on side at price, 0 if there is
+// no such level.
+func (ob *Book) LevelVolume(side Side, price float64) uint32 {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	sideMap := ob.bids
+	if side == Sell {
+		sideMap = ob.asks
+	}
+	if level, ok := sideMap[price]; ok {
+		return level.TotalVolume
+	}
+	return 0
+}
+
+func bestLevel(sideMap map[float64]*LimitLevel, ladder *priceLadder) (float64, uint32, bool) {
+	price, ok := ladder.best()
+	if !ok {
+		return 0, 0, false
+	}
+	return price, sideMap[price].TotalVolume, true
+}
+
+type PriceLevel struct {
+	Price  float64 `json:"price"`
+	Volume uint32  `json:"volume"`
+	Orders int     `json:"orders"`
+}
+
+// Depth returns up to n levels per side, best price first.
+func (ob *Book) Depth(n int) (bids, asks []PriceLevel) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return depthLevels(ob.bids, &ob.bidLadder, n), depthLevels(ob.asks, &ob.askLadder, n)
+}
+
+// WalkDepth calls fn with the bid levels, best price first, until it
+// returns false, then likewise with the ask levels. It runs with the book
+// read-locked, so fn must be quick and must not call back into the book.
+func (ob *Book) WalkDepth(fn func(side Side, level PriceLevel) bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	for _, side := range []struct {
+		levels map[float64]*LimitLevel
+		ladder *priceLadder
+		side   Side
+	}{{ob.bids, &ob.bidLadder, Buy}, {ob.asks, &ob.askLadder, Sell}} {
+		prices := side.ladder.prices
+		for i := len(prices) - 1; i >= 0; i-- {
+			level := side.levels[prices[i]]
+			if !fn(side.side, PriceLevel{Price: level.Price, Volume: level.TotalVolume, Orders: len(level.Orders)}) {
+				break
+			}
+		}
+	}
+}
+
+func depthLevels(sideMap map[float64]*LimitLevel, ladder *priceLadder, n int) []PriceLevel {
+	count := len(ladder.prices)
+	if n > 0 && count > n {
+		count = n
+	}
+	levels := make([]PriceLevel, count)
+	for i := range levels {
+		price := ladder.prices[len(ladder.prices)-1-i]
+		level := sideMap[price]
+		levels[i] = PriceLevel{Price: price, Volume: level.TotalVolume, Orders: len(level.Orders)}
+	}
+	return levels
+}
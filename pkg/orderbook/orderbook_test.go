@@ -1,20 +1,23 @@
-package main
+package orderbook
 
 import (
+	"context"
+	"errors"
 	"math"
+	"math/rand"
 	"testing"
 )
 
 func TestNewOrderBook(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 	if ob == nil {
-		t.Fatal("NewOrderBook() returned nil")
+		t.Fatal("New() returned nil")
 	}
 	if ob.bids == nil {
-		t.Error("OrderBook bids map should not be nil")
+		t.Error("Book bids map should not be nil")
 	}
 	if ob.asks == nil {
-		t.Error("OrderBook asks map should not be nil")
+		t.Error("Book asks map should not be nil")
 	}
 	if ob.lastTradePrice != 0.0 {
 		t.Errorf("Initial lastTradePrice = %v, want 0.0", ob.lastTradePrice)
@@ -25,7 +28,7 @@ func TestNewOrderBook(t *testing.T) {
 }
 
 func TestOrderBookInitialState(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	if ob.GetLastTradePrice() != 0.0 {
 		t.Errorf("GetLastTradePrice() = %v, want 0.0", ob.GetLastTradePrice())
@@ -39,7 +42,7 @@ func TestOrderBookInitialState(t *testing.T) {
 }
 
 func TestOrderBookBuyOrder(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 	order := &Order{
 		ID:       1,
 		Price:    100.0,
@@ -59,7 +62,7 @@ func TestOrderBookBuyOrder(t *testing.T) {
 }
 
 func TestOrderBookSellOrder(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 	order := &Order{
 		ID:       2,
 		Price:    100.0,
@@ -76,7 +79,7 @@ func TestOrderBookSellOrder(t *testing.T) {
 }
 
 func TestOrderBookMatching(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add a buy order at 100.0
 	buyOrder := &Order{
@@ -112,7 +115,7 @@ func TestOrderBookMatching(t *testing.T) {
 }
 
 func TestOrderBookPartialMatch(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add a buy order for 1000 units
 	buyOrder := &Order{
@@ -145,7 +148,7 @@ func TestOrderBookPartialMatch(t *testing.T) {
 }
 
 func TestOrderBookMultipleMatches(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add multiple buy orders at different prices (best bid first)
 	buyOrder1 := &Order{
@@ -190,7 +193,7 @@ func TestOrderBookMultipleMatches(t *testing.T) {
 }
 
 func TestOrderBookVWAPCalculation(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Create multiple trades at different prices
 	buyOrder1 := &Order{
@@ -239,7 +242,7 @@ func TestOrderBookVWAPCalculation(t *testing.T) {
 }
 
 func TestOrderBookNoMatch(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add a buy order at 100.0
 	buyOrder := &Order{
@@ -269,7 +272,7 @@ func TestOrderBookNoMatch(t *testing.T) {
 }
 
 func TestOrderBookPricePriority(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add buy orders at different prices (best bid first)
 	buyOrder1 := &Order{
@@ -309,7 +312,7 @@ func TestOrderBookPricePriority(t *testing.T) {
 }
 
 func TestOrderBookExactMatch(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	buyOrder := &Order{
 		ID:       1,
@@ -341,7 +344,7 @@ func TestOrderBookExactMatch(t *testing.T) {
 }
 
 func TestOrderBookSamePriceOrders(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Add multiple buy orders at same price
 	buyOrder1 := &Order{
@@ -389,7 +392,7 @@ func TestOrderBookSamePriceOrders(t *testing.T) {
 }
 
 func TestOrderBookZeroQuantity(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Zero quantity order should not crash
 	zeroOrder := &Order{
@@ -409,7 +412,7 @@ func TestOrderBookZeroQuantity(t *testing.T) {
 }
 
 func TestOrderBookVWAPWithZeroVolume(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// No trades, volume is zero
 	vwap := ob.GetVWAP()
@@ -433,7 +436,7 @@ func TestOrderBookVWAPWithZeroVolume(t *testing.T) {
 }
 
 func TestOrderBookConsecutiveTrades(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// First trade
 	buyOrder1 := &Order{
@@ -481,7 +484,7 @@ func TestOrderBookConsecutiveTrades(t *testing.T) {
 }
 
 func TestOrderBookRemainingQuantity(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Buy order larger than sell
 	buyOrder := &Order{
@@ -525,7 +528,7 @@ func TestOrderBookRemainingQuantity(t *testing.T) {
 }
 
 func TestOrderBookConcurrentAccess(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 	done := make(chan bool)
 
 	// Test concurrent reads
@@ -557,12 +560,12 @@ func TestOrderBookConcurrentAccess(t *testing.T) {
 
 	// Should not have crashed
 	if ob == nil {
-		t.Fatal("OrderBook should not be nil after concurrent access")
+		t.Fatal("Book should not be nil after concurrent access")
 	}
 }
 
 func TestOrderBookEmptyBookOperations(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// All operations on empty book should be safe
 	if ob.GetLastTradePrice() != 0.0 {
@@ -580,7 +583,7 @@ func TestOrderBookEmptyBookOperations(t *testing.T) {
 }
 
 func TestOrderBookEqualPriceMatch(t *testing.T) {
-	ob := NewOrderBook()
+	ob := New()
 
 	// Buy order at 100.0
 	buyOrder := &Order{
@@ -608,3 +611,300 @@ func TestOrderBookEqualPriceMatch(t *testing.T) {
 		t.Errorf("GetTotalVolume() = %v, want 500", ob.GetTotalVolume())
 	}
 }
+
+func TestOrderBookDepth(t *testing.T) {
+	ob := New()
+	ob.SubmitOrder(&Order{ID: 1, Price: 99.0, Quantity: 100, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 2, Price: 98.0, Quantity: 200, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 3, Price: 99.0, Quantity: 50, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 4, Price: 102.0, Quantity: 300, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 5, Price: 101.0, Quantity: 400, Side: Sell})
+
+	bids, asks := ob.Depth(1)
+	if len(bids) != 1 || len(asks) != 1 {
+		t.Fatalf("Depth(1) returned %d bids / %d asks, want 1 / 1", len(bids), len(asks))
+	}
+	if bids[0] != (PriceLevel{Price: 99.0, Volume: 150, Orders: 2}) {
+		t.Errorf("best bid level = %+v", bids[0])
+	}
+	if asks[0] != (PriceLevel{Price: 101.0, Volume: 400, Orders: 1}) {
+		t.Errorf("best ask level = %+v", asks[0])
+	}
+
+	bids, asks = ob.Depth(0)
+	if len(bids) != 2 || bids[1].Price != 98.0 || len(asks) != 2 || asks[1].Price != 102.0 {
+		t.Errorf("Depth(0) = %+v / %+v, want full ordered book", bids, asks)
+	}
+}
+
+func TestOrderBookBestBidAsk(t *testing.T) {
+	ob := New()
+	if _, _, ok := ob.GetBestBid(); ok {
+		t.Error("GetBestBid() on empty book should report no level")
+	}
+
+	ob.SubmitOrder(&Order{ID: 1, Price: 99.0, Quantity: 100, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 2, Price: 99.5, Quantity: 200, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 3, Price: 101.0, Quantity: 300, Side: Sell})
+
+	if price, vol, ok := ob.GetBestBid(); !ok || price != 99.5 || vol != 200 {
+		t.Errorf("GetBestBid() = %v, %v, %v; want 99.5, 200, true", price, vol, ok)
+	}
+	if price, vol, ok := ob.GetBestAsk(); !ok || price != 101.0 || vol != 300 {
+		t.Errorf("GetBestAsk() = %v, %v, %v; want 101.0, 300, true", price, vol, ok)
+	}
+}
+
+func TestOrderBookExecutionHandler(t *testing.T) {
+	ob := New()
+	var fills []Execution
+	ob.SetExecutionHandler(func(ex Execution) { fills = append(fills, ex) })
+
+	ob.SubmitOrder(&Order{ID: 1, Price: 100.0, Quantity: 30, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 2, Price: 101.0, Quantity: 30, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 3, Price: 101.0, Quantity: 50, Side: Buy})
+
+	if len(fills) != 2 {
+		t.Fatalf("Executions = %d, want 2", len(fills))
+	}
+	if fills[0].MakerID != 1 || fills[0].Price != 100.0 || fills[0].Quantity != 30 {
+		t.Errorf("First fill = %+v, want maker 1 at 100.0 for 30", fills[0])
+	}
+	if fills[1].MakerID != 2 || fills[1].TakerID != 3 || fills[1].Side != Buy || fills[1].Quantity != 20 {
+		t.Errorf("Second fill = %+v, want taker 3 buying 20 from maker 2", fills[1])
+	}
+}
+
+func TestOrderBookCancelOrder(t *testing.T) {
+	ob := New()
+	ob.SubmitOrder(&Order{ID: 1, Price: 99.0, Quantity: 100, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 2, Price: 99.0, Quantity: 50, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 3, Price: 101.0, Quantity: 30, Side: Sell})
+
+	if !ob.CancelOrder(1) {
+		t.Fatal("CancelOrder(1) did not find the resting order")
+	}
+	if ob.CancelOrder(1) || ob.CancelOrder(42) {
+		t.Error("CancelOrder found an order that is not resting")
+	}
+	if price, vol, ok := ob.GetBestBid(); !ok || price != 99.0 || vol != 50 {
+		t.Errorf("GetBestBid() after cancel = %v, %v, %v; want 99.0, 50, true", price, vol, ok)
+	}
+
+	// Cancelling the last order removes the level
+	ob.CancelOrder(3)
+	if _, _, ok := ob.GetBestAsk(); ok {
+		t.Error("ask level survived cancelling its only order")
+	}
+
+	// A filled order can no longer be cancelled
+	ob.SubmitOrder(&Order{ID: 4, Price: 99.0, Quantity: 50, Side: Sell})
+	if ob.CancelOrder(2) {
+		t.Error("CancelOrder found an order that was filled")
+	}
+}
+
+func TestOrderBookSubmitOrders(t *testing.T) {
+	ob := New()
+	orders := []*Order{
+		{ID: 1, Price: 100.0, Quantity: 30, Side: Sell},
+		{ID: 2, Price: 99.0, Quantity: 10, Side: Buy},
+		{ID: 3, Price: 100.0, Quantity: 50, Side: Buy},
+	}
+	rested := ob.SubmitOrders(orders, nil)
+	if len(rested) != 3 || !rested[0] || !rested[1] || !rested[2] {
+		t.Fatalf("rested = %v, want all true", rested)
+	}
+	// Later orders in the batch match earlier ones
+	if ob.GetTotalVolume() != 30 {
+		t.Errorf("traded volume = %d, want 30", ob.GetTotalVolume())
+	}
+	if price, vol, ok := ob.GetBestBid(); !ok || price != 100.0 || vol != 20 {
+		t.Errorf("GetBestBid() = %v, %v, %v; want 100.0, 20, true", price, vol, ok)
+	}
+	rested = ob.SubmitOrders([]*Order{{ID: 4, Price: 99.0, Quantity: 20, Side: Sell}}, rested[:0])
+	if len(rested) != 1 || rested[0] {
+		t.Errorf("fully filled order reported rested: %v", rested)
+	}
+}
+
+func TestOrderBookSubmitOrdersContext(t *testing.T) {
+	ob := New()
+	orders := []*Order{{ID: 1, Price: 100.0, Quantity: 10, Side: Sell}}
+	rested, err := ob.SubmitOrdersContext(context.Background(), orders, nil)
+	if err != nil || len(rested) != 1 || !rested[0] {
+		t.Fatalf("SubmitOrdersContext = %v, %v; want [true], nil", rested, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rested, err = ob.SubmitOrdersContext(ctx, []*Order{{ID: 2, Price: 100.0, Quantity: 10, Side: Buy}}, rested[:0])
+	if !errors.Is(err, context.Canceled) || len(rested) != 0 {
+		t.Errorf("SubmitOrdersContext after cancel = %v, %v; want nothing submitted and context.Canceled", rested, err)
+	}
+	if _, _, ok := ob.GetBestAsk(); !ok {
+		t.Error("cancelled batch traded")
+	}
+}
+
+func TestOrderBookLevelReuseKeepsPriceOrder(t *testing.T) {
+	ob := New()
+	for i, price := range []float64{101, 103, 102, 105, 104} {
+		ob.SubmitOrder(&Order{ID: uint64(i + 1), Price: price, Quantity: 10, Side: Sell})
+	}
+	// Sweep the two best levels, then rest at prices that land on either
+	// side of the remaining ones using the freed levels
+	ob.SubmitOrder(&Order{ID: 10, Price: 102, Quantity: 20, Side: Buy})
+	ob.SubmitOrder(&Order{ID: 11, Price: 106, Quantity: 10, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 12, Price: 100, Quantity: 10, Side: Sell})
+
+	_, asks := ob.Depth(0)
+	var prices []float64
+	for _, l := range asks {
+		prices = append(prices, l.Price)
+	}
+	want := []float64{100, 103, 104, 105, 106}
+	if len(prices) != len(want) {
+		t.Fatalf("ask prices = %v, want %v", prices, want)
+	}
+	for i := range want {
+		if prices[i] != want[i] || asks[i].Volume != 10 {
+			t.Fatalf("asks = %+v, want prices %v with 10 each", asks, want)
+		}
+	}
+}
+
+func TestOrderBookLimitsEvictFurthestLevels(t *testing.T) {
+	ob := New()
+	ob.SetLimits(Limits{MaxLevels: 3, MaxOrders: 5})
+	for i, price := range []float64{99, 97, 98, 96} {
+		ob.SubmitOrder(&Order{ID: uint64(i + 1), Price: price, Quantity: 10, Side: Buy})
+	}
+	bids, _ := ob.Depth(0)
+	if len(bids) != 3 || bids[0].Price != 99 || bids[2].Price != 97 {
+		t.Fatalf("bids = %+v, want 99, 98, 97", bids)
+	}
+	if ob.CancelOrder(4) {
+		t.Error("order resting beyond the level cap was kept")
+	}
+
+	// The order cap evicts from the side with more levels
+	for i, price := range []float64{101, 102, 102} {
+		ob.SubmitOrder(&Order{ID: uint64(i + 10), Price: price, Quantity: 10, Side: Sell})
+	}
+	stats := ob.Stats()
+	want := Stats{BidLevels: 2, AskLevels: 2, RestingOrders: 5, EvictedLevels: 2, EvictedOrders: 2}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+	if price, _, ok := ob.GetBestBid(); !ok || price != 99 {
+		t.Errorf("best bid = %v, %v after eviction, want 99", price, ok)
+	}
+
+	// Fills and cancels keep the resting count
+	ob.SubmitOrder(&Order{ID: 20, Price: 101, Quantity: 10, Side: Buy})
+	ob.CancelOrder(1)
+	if n := ob.Stats().RestingOrders; n != 3 {
+		t.Errorf("RestingOrders = %d after a fill and a cancel, want 3", n)
+	}
+}
+
+func TestOrderBookMatchDoesNotAllocate(t *testing.T) {
+	ob := New()
+	fills := 0
+	ob.SetExecutionHandler(func(Execution) { fills++ })
+
+	// Orders are recycled from a ring that is far larger than the number
+	// resting at the swept prices, so no order is reused while in the book
+	orders := make([]Order, 256)
+	next := 0
+	submit := func(side Side, price float64) {
+		o := &orders[next%len(orders)]
+		next++
+		*o = Order{ID: uint64(next), Price: price, Quantity: 10, Side: side}
+		ob.SubmitOrder(o)
+	}
+	for price := 100.0; price < 110; price++ {
+		submit(Sell, price)
+		submit(Buy, price-20)
+	}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		// Sweep three levels, then replenish them
+		o := &orders[next%len(orders)]
+		next++
+		*o = Order{ID: uint64(next), Price: 102, Quantity: 30, Side: Buy}
+		ob.SubmitOrder(o)
+		for price := 100.0; price <= 102; price++ {
+			submit(Sell, price)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("match path allocated %.1f times per run, want 0", allocs)
+	}
+	if fills < 3000 {
+		t.Errorf("%d fills, want at least 3000", fills)
+	}
+}
+
+// benchBook rests levels orders of 10 on each side around 1000.
+func benchBook(levels int) *Book {
+	ob := New()
+	for i := 1; i <= levels; i++ {
+		ob.SubmitOrder(&Order{ID: uint64(2 * i), Price: 1000 + float64(i), Quantity: 10, Side: Sell})
+		ob.SubmitOrder(&Order{ID: uint64(2*i + 1), Price: 1000 - float64(i), Quantity: 10, Side: Buy})
+	}
+	return ob
+}
+
+func BenchmarkSubmitOrder(b *testing.B) {
+	ob := benchBook(500)
+	rng := rand.New(rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Mostly passive orders near the touch, some crossing it
+		side := Buy
+		offset := float64(rng.Intn(20)) - 2
+		price := 1000 - offset
+		if i&1 == 1 {
+			side, price = Sell, 1000+offset
+		}
+		o := AcquireOrder()
+		o.ID, o.Price, o.Quantity, o.Side = uint64(i+10000), price, uint32(1+rng.Intn(20)), side
+		if !ob.SubmitOrder(o) {
+			ReleaseOrder(o)
+		}
+	}
+}
+
+func BenchmarkMatchHeavy(b *testing.B) {
+	ob := benchBook(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Sweep five ask levels and put them back
+		o := AcquireOrder()
+		o.ID, o.Price, o.Quantity, o.Side = uint64(i), 1005, 50, Buy
+		ob.SubmitOrder(o)
+		ReleaseOrder(o)
+		for p := 1001; p <= 1005; p++ {
+			o := AcquireOrder()
+			o.ID, o.Price, o.Quantity, o.Side = uint64(i), float64(p), 10, Sell
+			ob.SubmitOrder(o)
+		}
+	}
+}
+
+func BenchmarkCancelHeavy(b *testing.B) {
+	ob := benchBook(100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Rest and cancel a bid behind the queue at one of ten levels
+		id := uint64(1_000_000 + i)
+		o := AcquireOrder()
+		o.ID, o.Price, o.Quantity, o.Side = id, 1000-float64(1+i%10), 10, Buy
+		ob.SubmitOrder(o)
+		ob.CancelOrder(id)
+	}
+}
@@ -0,0 +1,85 @@
+package orderbook
+
+import (
+	"fmt"
+	"time"
+)
+
+type Side int
+
+const (
+	Buy Side = iota
+	Sell
+)
+
+func (s Side) String() string {
+	switch s {
+	case Buy:
+		return "BUY"
+	case Sell:
+		return "SELL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func (s Side) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Side) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "BUY":
+		*s = Buy
+	case "SELL":
+		*s = Sell
+	default:
+		return fmt.Errorf("unknown side %q", text)
+	}
+	return nil
+}
+
+type Order struct {
+	ID        uint64
+	Price     float64
+	Quantity  uint32
+	Side      Side
+	EntryTime time.Time
+
+	pooled bool // from AcquireOrder
+}
+
+type LimitLevel struct {
+	Price       float64
+	TotalVolume uint32
+	Orders      []*Order
+}
+
+// removeAt drops the order at i, keeping time priority, and clears the
+// vacated slot so the backing array does not pin released orders.
+func (l *LimitLevel) removeAt(i int) {
+	copy(l.Orders[i:], l.Orders[i+1:])
+	l.Orders[len(l.Orders)-1] = nil
+	l.Orders = l.Orders[:len(l.Orders)-1]
+}
+
+type Trade struct {
+	Symbol    string    `json:"symbol"`
+	ID        uint64    `json:"id"`
+	Price     float64   `json:"price"`
+	Quantity  float64   `json:"quantity"`
+	Side      Side      `json:"side"` // aggressor side
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Execution is one fill produced by the matching engine: an incoming (taker)
+// order trading against a resting (maker) order.
+type Execution struct {
+	Symbol    string    `json:"symbol"`
+	TakerID   uint64    `json:"taker_id"`
+	MakerID   uint64    `json:"maker_id"`
+	Side      Side      `json:"side"` // taker side
+	Price     float64   `json:"price"`
+	Quantity  uint32    `json:"quantity"`
+	Timestamp time.Time `json:"timestamp"`
+}
@@ -1,4 +1,4 @@
-package main
+package orderbook
 
 import (
 	"testing"
@@ -67,3 +67,21 @@ func TestLimitLevelCreation(t *testing.T) {
 		t.Errorf("LimitLevel Orders length = %v, want 0", len(level.Orders))
 	}
 }
+
+func TestSideTextRoundTrip(t *testing.T) {
+	for _, side := range []Side{Buy, Sell} {
+		text, err := side.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Side
+		if err := got.UnmarshalText(text); err != nil || got != side {
+			t.Errorf("round trip of %v = %v, %v", side, got, err)
+		}
+	}
+
+	var s Side
+	if err := s.UnmarshalText([]byte("HOLD")); err == nil {
+		t.Error("UnmarshalText(HOLD) should fail")
+	}
+}
@@ -0,0 +1,26 @@
+package orderbook
+
+import "sync"
+
+// Orders from AcquireOrder belong to the caller until SubmitOrder reports
+// that the order rested, after which the book releases it once it is
+// filled. An order that did not rest is released by the caller.
+
+var orderPool = sync.Pool{New: func() interface{} { return new(Order) }}
+
+// AcquireOrder returns a zeroed order from the pool.
+func AcquireOrder() *Order {
+	o := orderPool.Get().(*Order)
+	o.pooled = true
+	return o
+}
+
+// ReleaseOrder returns an order from AcquireOrder to the pool. Orders that
+// were not pooled are left to the garbage collector.
+func ReleaseOrder(o *Order) {
+	if o == nil || !o.pooled {
+		return
+	}
+	*o = Order{}
+	orderPool.Put(o)
+}
@@ -0,0 +1,30 @@
+package orderbook
+
+import "testing"
+
+func TestBookReleasesFilledPooledOrders(t *testing.T) {
+	ob := New()
+	maker := AcquireOrder()
+	maker.ID, maker.Price, maker.Quantity, maker.Side = 1, 100, 10, Sell
+	if !ob.SubmitOrder(maker) {
+		t.Fatal("maker did not rest")
+	}
+	plain := &Order{ID: 2, Price: 100, Quantity: 5, Side: Sell}
+	ob.SubmitOrder(plain)
+
+	taker := AcquireOrder()
+	taker.ID, taker.Price, taker.Quantity, taker.Side = 3, 100, 15, Buy
+	if ob.SubmitOrder(taker) {
+		t.Fatal("fully filled taker reported as resting")
+	}
+	ReleaseOrder(taker)
+
+	// The book zeroes pooled makers when it releases them and leaves
+	// caller-allocated orders alone.
+	if maker.pooled || maker.ID != 0 {
+		t.Errorf("filled pooled maker not released: %+v", maker)
+	}
+	if plain.ID != 2 || plain.Quantity != 0 {
+		t.Errorf("unpooled maker modified: %+v", plain)
+	}
+}
@@ -0,0 +1,60 @@
+package orderbook
+
+import (
+	"math"
+	"runtime"
+	"sync/atomic"
+)
+
+// TradeTotals are a book's running trade aggregates.
+type TradeTotals struct {
+	LastPrice float64
+	Volume    uint32
+	Notional  float64
+}
+
+func (t TradeTotals) VWAP() float64 {
+	if t.Volume == 0 {
+		return 0.0
+	}
+	return t.Notional / float64(t.Volume)
+}
+
+// totalsSeqlock publishes TradeTotals to readers without a lock. The single
+// writer makes the sequence odd while it stores the fields and even again
+// afterwards; a reader retries until it sees the same even sequence before
+// and after its loads, so it never mixes fields from two updates and never
+// blocks the writer. Fields are stored atomically to stay race-free.
+type totalsSeqlock struct {
+	seq       uint64
+	lastPrice uint64 // float64 bits
+	volume    uint32
+	notional  uint64 // float64 bits
+}
+
+// store must not be called concurrently; the book calls it under its lock.
+func (s *totalsSeqlock) store(t TradeTotals) {
+	atomic.AddUint64(&s.seq, 1)
+	atomic.StoreUint64(&s.lastPrice, math.Float64bits(t.LastPrice))
+	atomic.StoreUint32(&s.volume, t.Volume)
+	atomic.StoreUint64(&s.notional, math.Float64bits(t.Notional))
+	atomic.AddUint64(&s.seq, 1)
+}
+
+func (s *totalsSeqlock) load() TradeTotals {
+	for {
+		seq := atomic.LoadUint64(&s.seq)
+		if seq&1 != 0 {
+			runtime.Gosched()
+			continue
+		}
+		t := TradeTotals{
+			LastPrice: math.Float64frombits(atomic.LoadUint64(&s.lastPrice)),
+			Volume:    atomic.LoadUint32(&s.volume),
+			Notional:  math.Float64frombits(atomic.LoadUint64(&s.notional)),
+		}
+		if atomic.LoadUint64(&s.seq) == seq {
+			return t
+		}
+	}
+}
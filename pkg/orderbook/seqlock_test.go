@@ -0,0 +1,84 @@
+package orderbook
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTotalsSeqlockReadsConsistentSnapshots(t *testing.T) {
+	var s totalsSeqlock
+	var stop int32
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				// Every stored snapshot has Volume == LastPrice and
+				// Notional == 2*LastPrice; a torn read breaks that
+				got := s.load()
+				if float64(got.Volume) != got.LastPrice || got.Notional != 2*got.LastPrice {
+					t.Errorf("torn read: %+v", got)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 100000; i++ {
+		s.store(TradeTotals{LastPrice: float64(i), Volume: uint32(i), Notional: float64(2 * i)})
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+
+	if got := s.load(); got.Volume != 100000 || got.VWAP() != 2 {
+		t.Errorf("final totals = %+v", got)
+	}
+}
+
+func TestOrderBookTradeTotals(t *testing.T) {
+	ob := New()
+	if got := ob.TradeTotals(); got != (TradeTotals{}) || got.VWAP() != 0 {
+		t.Errorf("empty book totals = %+v", got)
+	}
+	ob.SubmitOrder(&Order{ID: 1, Price: 100, Quantity: 10, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 2, Price: 102, Quantity: 10, Side: Sell})
+	ob.SubmitOrder(&Order{ID: 3, Price: 102, Quantity: 20, Side: Buy})
+
+	want := TradeTotals{LastPrice: 102, Volume: 20, Notional: 2020}
+	if got := ob.TradeTotals(); got != want || got.VWAP() != 101 {
+		t.Errorf("TradeTotals() = %+v, want %+v", got, want)
+	}
+	if ob.GetLastTradePrice() != 102 || ob.GetVWAP() != 101 || ob.GetTotalVolume() != 20 || ob.GetCumulativeNotional() != 2020 {
+		t.Error("getters disagree with TradeTotals")
+	}
+}
+
+// BenchmarkGetVWAPWhileMatching polls the aggregates from parallel readers
+// while a writer keeps matching, as the API and metrics endpoints do.
+func BenchmarkGetVWAPWhileMatching(b *testing.B) {
+	ob := New()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for id := uint64(1); ; id += 2 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ob.SubmitOrder(&Order{ID: id, Price: 100, Quantity: 10, Side: Sell})
+			ob.SubmitOrder(&Order{ID: id + 1, Price: 100, Quantity: 10, Side: Buy})
+		}
+	}()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = ob.GetVWAP()
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	<-done
+}
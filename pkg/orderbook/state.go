@@ -0,0 +1,283 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// State is the whole of a book: every resting order in priority order
+// along with the counters and trade totals. Unlike BookSnapshot, which is a
+// depth view for display, a book loaded from it carries on exactly where
+// the original left off.
+type State struct {
+	Version       int          `json:"version"`
+	Limits        Limits       `json:"limits"`
+	Totals        TradeTotals  `json:"totals"`
+	Submitted     uint64       `json:"submitted"`
+	EvictedLevels uint64       `json:"evicted_levels"`
+	EvictedOrders uint64       `json:"evicted_orders"`
+	Bids          []LevelState `json:"bids"` // best first
+	Asks          []LevelState `json:"asks"`
+}
+
+type LevelState struct {
+	Price  float64      `json:"price"`
+	Orders []OrderState `json:"orders"` // in time priority
+}
+
+type OrderState struct {
+	ID        uint64    `json:"id"`
+	Quantity  uint32    `json:"quantity"`
+	EntryTime time.Time `json:"entry_time"`
+}
+
+const bookStateVersion = 1
+
+// bookMagic starts the binary form, which is little-endian throughout:
+//
+//	magic | version (1 byte) | max levels, max orders (uvarint)
+//	| last price (float64) | volume (uvarint) | notional (float64)
+//	| submitted, evicted levels, evicted orders (uvarint)
+//	| bids, then asks: level count (uvarint), then per level the price
+//	  (float64), order count (uvarint) and per order its ID, quantity
+//	  (uvarint) and entry time in Unix ns (varint)
+//	| CRC-32 of everything before it (uint32)
+const bookMagic = "APEXBOOK"
+
+func (ob *Book) State() State {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return State{
+		Version:       bookStateVersion,
+		Limits:        ob.limits,
+		Totals:        TradeTotals{LastPrice: ob.lastTradePrice, Volume: ob.totalVolume, Notional: ob.cumulativeNotional},
+		Submitted:     ob.submitted,
+		EvictedLevels: ob.evictedLevels,
+		EvictedOrders: ob.evictedOrders,
+		Bids:          levelStates(ob.bids, &ob.bidLadder),
+		Asks:          levelStates(ob.asks, &ob.askLadder),
+	}
+}
+
+func levelStates(sideMap map[float64]*LimitLevel, ladder *priceLadder) []LevelState {
+	levels := make([]LevelState, len(ladder.prices))
+	for i := range levels {
+		level := sideMap[ladder.prices[len(ladder.prices)-1-i]]
+		orders := make([]OrderState, len(level.Orders))
+		for j, o := range level.Orders {
+			orders[j] = OrderState{ID: o.ID, Quantity: o.Quantity, EntryTime: o.EntryTime}
+		}
+		levels[i] = LevelState{Price: level.Price, Orders: orders}
+	}
+	return levels
+}
+
+// FromState builds a book holding state. The state must be one a book
+// could be in: finite prices, each level once and holding orders with
+// quantity, and a book that is not crossed and keeps within its limits.
+func FromState(state State) (*Book, error) {
+	if state.Version != bookStateVersion {
+		return nil, fmt.Errorf("unsupported book state version %d", state.Version)
+	}
+	ob := New()
+	for _, side := range []struct {
+		levels []LevelState
+		side   Side
+		prices map[float64]*LimitLevel
+	}{{state.Bids, Buy, ob.bids}, {state.Asks, Sell, ob.asks}} {
+		for _, level := range side.levels {
+			switch {
+			case math.IsNaN(level.Price) || math.IsInf(level.Price, 0):
+				return nil, fmt.Errorf("%s level has price %v", side.side, level.Price)
+			case side.prices[level.Price] != nil:
+				return nil, fmt.Errorf("%s level at %v appears twice", side.side, level.Price)
+			case len(level.Orders) == 0:
+				return nil, fmt.Errorf("%s level at %v has no orders", side.side, level.Price)
+			}
+			for _, o := range level.Orders {
+				if _, dup := ob.orders[o.ID]; dup {
+					return nil, fmt.Errorf("order %d appears twice", o.ID)
+				}
+				if o.Quantity == 0 {
+					return nil, fmt.Errorf("order %d has no quantity", o.ID)
+				}
+				order := AcquireOrder()
+				order.ID, order.Price, order.Quantity, order.Side, order.EntryTime = o.ID, level.Price, o.Quantity, side.side, o.EntryTime
+				if side.side == Buy {
+					ob.addLimit(order, ob.bids, &ob.bidLadder)
+				} else {
+					ob.addLimit(order, ob.asks, &ob.askLadder)
+				}
+			}
+		}
+	}
+	ob.limits = state.Limits
+	ob.lastTradePrice, ob.totalVolume, ob.cumulativeNotional = state.Totals.LastPrice, state.Totals.Volume, state.Totals.Notional
+	ob.totals.store(state.Totals)
+	ob.submitted, ob.evictedLevels, ob.evictedOrders = state.Submitted, state.EvictedLevels, state.EvictedOrders
+	if err := ob.CheckInvariants(); err != nil {
+		return nil, fmt.Errorf("invalid book state: %w", err)
+	}
+	return ob, nil
+}
+
+// Serialize writes the book in the compact binary form Load reads.
+func (ob *Book) Serialize(w io.Writer) error {
+	_, err := w.Write(ob.State().AppendBinary(nil))
+	return err
+}
+
+// SerializeJSON writes the book as a JSON State, which Load
+// also reads.
+func (ob *Book) SerializeJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(ob.State())
+}
+
+func (s State) AppendBinary(dst []byte) []byte {
+	start := len(dst)
+	dst = append(dst, bookMagic...)
+	dst = append(dst, byte(s.Version))
+	dst = binary.AppendUvarint(dst, uint64(s.Limits.MaxLevels))
+	dst = binary.AppendUvarint(dst, uint64(s.Limits.MaxOrders))
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(s.Totals.LastPrice))
+	dst = binary.AppendUvarint(dst, uint64(s.Totals.Volume))
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(s.Totals.Notional))
+	dst = binary.AppendUvarint(dst, s.Submitted)
+	dst = binary.AppendUvarint(dst, s.EvictedLevels)
+	dst = binary.AppendUvarint(dst, s.EvictedOrders)
+	for _, levels := range [][]LevelState{s.Bids, s.Asks} {
+		dst = binary.AppendUvarint(dst, uint64(len(levels)))
+		for _, level := range levels {
+			dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(level.Price))
+			dst = binary.AppendUvarint(dst, uint64(len(level.Orders)))
+			for _, o := range level.Orders {
+				dst = binary.AppendUvarint(dst, o.ID)
+				dst = binary.AppendUvarint(dst, uint64(o.Quantity))
+				var ns int64
+				if !o.EntryTime.IsZero() {
+					ns = o.EntryTime.UnixNano()
+				}
+				dst = binary.AppendVarint(dst, ns)
+			}
+		}
+	}
+	return binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[start:]))
+}
+
+// Load reads a book written by Serialize or SerializeJSON, telling
+// the two apart by the first byte.
+func Load(r io.Reader) (*Book, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	state, err := ParseState(data)
+	if err != nil {
+		return nil, err
+	}
+	return FromState(state)
+}
+
+func ParseState(data []byte) (State, error) {
+	var s State
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return s, fmt.Errorf("decoding book JSON: %w", err)
+		}
+		return s, nil
+	}
+	if len(data) < len(bookMagic)+5 || string(data[:len(bookMagic)]) != bookMagic {
+		return s, errors.New("not a serialized book")
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return s, errors.New("serialized book fails its checksum")
+	}
+	d := bookDecoder{r: bytes.NewReader(body[len(bookMagic):])}
+	version, _ := d.r.ReadByte()
+	s.Version = int(version)
+	s.Limits.MaxLevels = int(d.uvarint())
+	s.Limits.MaxOrders = int(d.uvarint())
+	s.Totals.LastPrice = d.float()
+	s.Totals.Volume = uint32(d.uvarint())
+	s.Totals.Notional = d.float()
+	s.Submitted = d.uvarint()
+	s.EvictedLevels = d.uvarint()
+	s.EvictedOrders = d.uvarint()
+	for _, side := range []*[]LevelState{&s.Bids, &s.Asks} {
+		n := d.count()
+		levels := make([]LevelState, 0, n)
+		for i := 0; i < n && d.err == nil; i++ {
+			level := LevelState{Price: d.float()}
+			orders := d.count()
+			level.Orders = make([]OrderState, 0, orders)
+			for j := 0; j < orders && d.err == nil; j++ {
+				o := OrderState{ID: d.uvarint(), Quantity: uint32(d.uvarint())}
+				if ns := d.varint(); ns != 0 {
+					o.EntryTime = time.Unix(0, ns)
+				}
+				level.Orders = append(level.Orders, o)
+			}
+			levels = append(levels, level)
+		}
+		*side = levels
+	}
+	if d.err == nil && d.r.Len() > 0 {
+		d.err = fmt.Errorf("%d unexpected trailing bytes", d.r.Len())
+	}
+	if d.err != nil {
+		return s, fmt.Errorf("decoding serialized book: %w", d.err)
+	}
+	return s, nil
+}
+
+// bookDecoder reads the binary form, keeping the first error so the caller
+// can check once at the end.
+type bookDecoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *bookDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.err = err
+	return v
+}
+
+func (d *bookDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.err = err
+	return v
+}
+
+func (d *bookDecoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	var b [8]byte
+	_, d.err = io.ReadFull(d.r, b[:])
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+}
+
+// count reads a length, capped by the bytes left (every item takes at
+// least one) so a corrupt one cannot force a huge allocation.
+func (d *bookDecoder) count() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.err = fmt.Errorf("implausible count %d", n)
+	}
+	return int(n)
+}
@@ -0,0 +1,151 @@
+package orderbook
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+// randomBook fills a book with crossing and resting orders from rng.
+func randomBook(rng *rand.Rand, n int) *Book {
+	ob := New()
+	ob.SetLimits(Limits{MaxLevels: 40, MaxOrders: 300})
+	for i := 0; i < n; i++ {
+		ob.SubmitOrder(randomOrder(rng, uint64(i+1)))
+	}
+	return ob
+}
+
+func randomOrder(rng *rand.Rand, id uint64) *Order {
+	side := Buy
+	if rng.Intn(2) == 0 {
+		side = Sell
+	}
+	return &Order{
+		ID:        id,
+		Price:     100 + float64(rng.Intn(60)-30)*0.5,
+		Quantity:  uint32(1 + rng.Intn(50)),
+		Side:      side,
+		EntryTime: time.Unix(1700000000, int64(id)*1000),
+	}
+}
+
+func TestSerializeRoundTrip(t *testing.T) {
+	for _, format := range []string{"binary", "json"} {
+		t.Run(format, func(t *testing.T) {
+			ob := randomBook(rand.New(rand.NewSource(1)), 2000)
+			var buf bytes.Buffer
+			serialize := ob.Serialize
+			if format == "json" {
+				serialize = ob.SerializeJSON
+			}
+			if err := serialize(&buf); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := Load(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := loaded.Checkpoint(), ob.Checkpoint(); got != want {
+				t.Fatalf("loaded checkpoint = %+v, want %+v", got, want)
+			}
+			if got, want := loaded.Limits(), ob.Limits(); got != want {
+				t.Errorf("limits = %+v, want %+v", got, want)
+			}
+			if got, want := loaded.Stats(), ob.Stats(); got != want {
+				t.Errorf("stats = %+v, want %+v", got, want)
+			}
+
+			// Both books must carry on identically
+			rng := rand.New(rand.NewSource(2))
+			for i := 0; i < 1000; i++ {
+				o := randomOrder(rng, uint64(10000+i))
+				twin := *o
+				ob.SubmitOrder(o)
+				loaded.SubmitOrder(&twin)
+			}
+			if got, want := loaded.Checkpoint(), ob.Checkpoint(); got != want {
+				t.Errorf("after more orders, loaded checkpoint = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestSerializeEmptyBook(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New().Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Checkpoint(), New().Checkpoint(); got != want {
+		t.Errorf("checkpoint = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadRejectsBadInput(t *testing.T) {
+	ob := randomBook(rand.New(rand.NewSource(3)), 200)
+	var buf bytes.Buffer
+	ob.Serialize(&buf)
+	good := buf.Bytes()
+
+	flipped := append([]byte(nil), good...)
+	flipped[len(flipped)/2] ^= 0xff
+
+	dup := ob.State()
+	dup.Asks[0].Orders = append(dup.Asks[0].Orders, dup.Bids[0].Orders[0])
+
+	future := ob.State()
+	future.Version = bookStateVersion + 1
+
+	nan := ob.State()
+	nan.Bids[1].Price = math.NaN()
+
+	inf := ob.State()
+	inf.Asks[0].Price = math.Inf(1)
+
+	zero := ob.State()
+	zero.Bids[0].Orders[0].Quantity = 0
+
+	empty := ob.State()
+	empty.Asks[0].Orders = nil
+
+	twice := ob.State()
+	twice.Bids[1].Price = twice.Bids[0].Price
+
+	crossed := ob.State()
+	crossed.Bids[0].Price = crossed.Asks[0].Price + 1
+
+	limited := ob.State()
+	limited.Limits.MaxLevels = 1
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "not a serialized book"},
+		{"truncated", good[:len(good)-10], "checksum"},
+		{"corrupt", flipped, "checksum"},
+		{"duplicate order", dup.AppendBinary(nil), "appears twice"},
+		{"version", future.AppendBinary(nil), "unsupported book state version"},
+		{"NaN price", nan.AppendBinary(nil), "has price NaN"},
+		{"infinite price", inf.AppendBinary(nil), "has price +Inf"},
+		{"no quantity", zero.AppendBinary(nil), "has no quantity"},
+		{"empty level", empty.AppendBinary(nil), "has no orders"},
+		{"duplicate level", twice.AppendBinary(nil), "appears twice"},
+		{"crossed", crossed.AppendBinary(nil), "book is crossed"},
+		{"over limits", limited.AppendBinary(nil), "over the limit"},
+		{"bad JSON", []byte(`{"version": "one"}`), "decoding book JSON"},
+	} {
+		_, err := Load(bytes.NewReader(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
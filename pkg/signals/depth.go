@@ -0,0 +1,149 @@
+package signals
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"apexlob/pkg/orderbook"
+)
+
+// DefaultDepthTiers are the tiers RegisterDepthImbalance is usually given:
+// the best 1, 5 and 20 levels, and the levels within 5, 10 and 25 bps of
+// the mid.
+const DefaultDepthTiers = "1,5,20,5bps,10bps,25bps"
+
+// DepthTier is the part of the book an imbalance is taken over: either its
+// best Levels levels per side or, if Bps is set, its levels within Bps
+// basis points of the mid.
+type DepthTier struct {
+	Levels int
+	Bps    float64
+}
+
+// Name is the tier's signal name, as in imbalance_top5 or imbalance_10bps.
+func (t DepthTier) Name() string {
+	if t.Bps > 0 {
+		return "imbalance_" + strconv.FormatFloat(t.Bps, 'f', -1, 64) + "bps"
+	}
+	return fmt.Sprintf("imbalance_top%d", t.Levels)
+}
+
+// ParseDepthTiers parses a comma-separated list of tiers: a number of
+// levels, or a distance from the mid with a bps suffix.
+func ParseDepthTiers(s string) ([]DepthTier, error) {
+	var tiers []DepthTier
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		var tier DepthTier
+		if bps, ok := strings.CutSuffix(f, "bps"); ok {
+			v, err := strconv.ParseFloat(bps, 64)
+			if err != nil || v <= 0 || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("invalid depth tier %q", f)
+			}
+			tier.Bps = v
+		} else {
+			n, err := strconv.Atoi(f)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid depth tier %q", f)
+			}
+			tier.Levels = n
+		}
+		if seen[tier.Name()] {
+			return nil, fmt.Errorf("depth tier %q given twice", f)
+		}
+		seen[tier.Name()] = true
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// DepthImbalance is the bid/ask volume imbalance, (bid - ask) / (bid +
+// ask) in [-1, 1], at several depth tiers at once, all taken from one walk
+// of the book. Its own value is the first tier's; RegisterDepthImbalance
+// registers a signal for each of the others. A tier without volume is 0,
+// and the bps tiers are unset while either side is empty.
+type DepthImbalance struct {
+	tiers    []DepthTier
+	bid, ask []float64 // volume per tier
+	values   []float64
+}
+
+func NewDepthImbalance(tiers []DepthTier) *DepthImbalance {
+	n := len(tiers)
+	return &DepthImbalance{tiers: tiers, bid: make([]float64, n), ask: make([]float64, n), values: make([]float64, n)}
+}
+
+// RegisterDepthImbalance registers the signals of every tier, in order.
+func RegisterDepthImbalance(se *Engine, tiers []DepthTier) {
+	if len(tiers) == 0 {
+		return
+	}
+	d := NewDepthImbalance(tiers)
+	se.Register(d)
+	for i := 1; i < len(tiers); i++ {
+		i := i
+		se.Register(Func(tiers[i].Name(), func(*Input) float64 { return d.values[i] }))
+	}
+}
+
+func (d *DepthImbalance) Name() string { return d.tiers[0].Name() }
+
+func (d *DepthImbalance) Update(in *Input) float64 {
+	bestBid, _, okBid := in.Book.GetBestBid()
+	bestAsk, _, okAsk := in.Book.GetBestAsk()
+	mid := (bestBid + bestAsk) / 2
+	hasMid := okBid && okAsk && mid > 0
+	maxLevels, maxBps := 0, 0.0
+	for i, t := range d.tiers {
+		maxLevels, maxBps = max(maxLevels, t.Levels), max(maxBps, t.Bps)
+		d.bid[i], d.ask[i] = 0, 0
+	}
+	if !hasMid {
+		maxBps = 0
+	}
+
+	var rank int
+	var side orderbook.Side = -1
+	in.Book.WalkDepth(func(s orderbook.Side, level orderbook.PriceLevel) bool {
+		if s != side {
+			side, rank = s, 0
+		}
+		vol := d.bid
+		if s == orderbook.Sell {
+			vol = d.ask
+		}
+		var dist float64
+		if hasMid {
+			dist = math.Abs(level.Price-mid) / mid * 1e4
+		}
+		if rank >= maxLevels && (maxBps == 0 || dist > maxBps) {
+			return false
+		}
+		for i, t := range d.tiers {
+			if (t.Bps == 0 && rank < t.Levels) || (t.Bps > 0 && hasMid && dist <= t.Bps) {
+				vol[i] += float64(level.Volume)
+			}
+		}
+		rank++
+		return true
+	})
+
+	for i, t := range d.tiers {
+		total := d.bid[i] + d.ask[i]
+		switch {
+		case t.Bps > 0 && !hasMid:
+			d.values[i] = math.NaN()
+		case total == 0:
+			d.values[i] = 0
+		default:
+			d.values[i] = (d.bid[i] - d.ask[i]) / total
+		}
+	}
+	return d.values[0]
+}
@@ -0,0 +1,161 @@
+package signals
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultDriftFeatures are the signals DriftModel usually learns from.
+const DefaultDriftFeatures = "spread_bps,imbalance,ofi_1m,rsi_14,momentum_10,volatility_20"
+
+const (
+	driftRate    = 0.01  // SGD step size
+	driftDecay   = 0.001 // weight of each trade in the feature means and variances
+	driftClip    = 5     // standard deviations a feature is clipped to
+	driftHits    = 1000  // resolved predictions the hit rate is taken over
+	driftMinHits = 50    // resolved predictions before the hit rate is set
+)
+
+// DriftModel is a baseline learned signal: an online logistic regression
+// of whether the mid will be higher horizon after a trade than at it, on
+// the values of other signals, to compare hand-crafted features against.
+// On every trade it standardizes the features with running means and
+// variances, predicts, and keeps the prediction. Once a prediction is
+// horizon old, the mid then is its label, and a step of stochastic
+// gradient descent moves the weights towards it; predictions the mid did
+// not move over are dropped. Its value is the predicted probability of an
+// up move, and HitRate is how often the direction it predicted was right
+// over the last driftHits predictions resolved. Features must be
+// registered before it; one without a value counts as 0.
+type DriftModel struct {
+	name      string
+	features  []string
+	horizon   time.Duration
+	weights   []float64 // one per feature, then the bias
+	mean, sq  []float64 // running mean and variance of each feature
+	seen      bool
+	pending   []driftPrediction
+	xs        []float64 // standardized features of pending, len(features) each
+	hits      []bool    // ring of resolved predictions
+	next, hit int
+	hitRate   float64
+}
+
+type driftPrediction struct {
+	at   time.Time
+	mid  float64
+	prob float64
+}
+
+func NewDriftModel(name string, features []string, horizon time.Duration) *DriftModel {
+	n := len(features)
+	return &DriftModel{
+		name:     name,
+		features: features,
+		horizon:  horizon,
+		weights:  make([]float64, n+1),
+		mean:     make([]float64, n),
+		sq:       make([]float64, n),
+		hitRate:  math.NaN(),
+	}
+}
+
+// RegisterDriftModel registers the drift_prob signal, predicting the mid
+// horizon ahead from features, and its drift_hit_rate.
+func RegisterDriftModel(se *Engine, features []string, horizon time.Duration) {
+	d := NewDriftModel("drift_prob", features, horizon)
+	se.Register(d)
+	se.Register(Func("drift_hit_rate", func(*Input) float64 { return d.HitRate() }))
+}
+
+func (d *DriftModel) Name() string { return d.name }
+
+func (d *DriftModel) Update(in *Input) float64 {
+	now, n := in.Trade.Timestamp, len(d.features)
+	mid := in.Trade.Price
+	bid, _, okBid := in.Book.GetBestBid()
+	ask, _, okAsk := in.Book.GetBestAsk()
+	if okBid && okAsk {
+		mid = (bid + ask) / 2
+	}
+
+	cutoff := now.Add(-d.horizon)
+	i := 0
+	for ; i < len(d.pending) && !d.pending[i].at.After(cutoff); i++ {
+		p := d.pending[i]
+		if mid == p.mid {
+			continue
+		}
+		up := mid > p.mid
+		d.record((p.prob > 0.5) == up)
+		x := d.xs[i*n : (i+1)*n]
+		grad := -d.predict(x)
+		if up {
+			grad++
+		}
+		for j, v := range x {
+			d.weights[j] += driftRate * grad * v
+		}
+		d.weights[n] += driftRate * grad
+	}
+	d.pending = d.pending[i:]
+	d.xs = d.xs[i*n:]
+
+	start := len(d.xs)
+	for j, name := range d.features {
+		d.xs = append(d.xs, d.standardize(j, in.Values[name]))
+	}
+	d.seen = true
+	prob := d.predict(d.xs[start:])
+	d.pending = append(d.pending, driftPrediction{at: now, mid: mid, prob: prob})
+	return prob
+}
+
+// standardize updates feature j's running mean and variance with v and
+// returns v in standard deviations from the mean.
+func (d *DriftModel) standardize(j int, v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	if !d.seen {
+		d.mean[j] = v
+		return 0
+	}
+	diff := v - d.mean[j]
+	d.mean[j] += driftDecay * diff
+	d.sq[j] = (1 - driftDecay) * (d.sq[j] + driftDecay*diff*diff)
+	if d.sq[j] <= 0 {
+		return 0
+	}
+	return max(-driftClip, min(driftClip, diff/math.Sqrt(d.sq[j])))
+}
+
+func (d *DriftModel) predict(x []float64) float64 {
+	z := d.weights[len(x)]
+	for j, v := range x {
+		z += d.weights[j] * v
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+func (d *DriftModel) record(hit bool) {
+	if len(d.hits) < driftHits {
+		d.hits = append(d.hits, hit)
+	} else {
+		if d.hits[d.next] {
+			d.hit--
+		}
+		d.hits[d.next] = hit
+		d.next = (d.next + 1) % driftHits
+	}
+	if hit {
+		d.hit++
+	}
+	if len(d.hits) >= driftMinHits {
+		d.hitRate = float64(d.hit) / float64(len(d.hits))
+	}
+}
+
+// HitRate is the share of the last predictions resolved whose direction
+// was right; NaN until driftMinHits have been.
+func (d *DriftModel) HitRate() float64 { return d.hitRate }
@@ -0,0 +1,100 @@
+package signals
+
+import (
+	"time"
+)
+
+// The history an Engine keeps of each signal unless SetHistory says
+// otherwise: a value a second for the last hour.
+const (
+	DefaultHistoryStep = time.Second
+	DefaultHistorySize = 3600
+)
+
+// Point is a signal's value as of a trade.
+type Point struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// history is a ring of a signal's last values, at most one per step: a
+// value in the same step as the newest point replaces it. Times are kept
+// as Unix nanoseconds, which halves its size.
+type history struct {
+	points []historyPoint
+	next   int // where the next point goes once points is full
+}
+
+type historyPoint struct {
+	at    int64
+	value float64
+}
+
+func (h *history) add(at int64, value float64, step int64, size int) {
+	p := historyPoint{at, value}
+	n := len(h.points)
+	if n > 0 {
+		newest := &h.points[n-1]
+		if n == size {
+			newest = &h.points[(h.next+size-1)%size]
+		}
+		if at/step == newest.at/step {
+			*newest = p
+			return
+		}
+	}
+	if n < size {
+		h.points = append(h.points, p)
+		return
+	}
+	h.points[h.next] = p
+	h.next = (h.next + 1) % size
+}
+
+// since returns the points after t, oldest first.
+func (h *history) since(t time.Time) []Point {
+	points := []Point{}
+	for _, part := range [][]historyPoint{h.points[h.next:], h.points[:h.next]} {
+		for _, p := range part {
+			at := time.Unix(0, p.at)
+			if at.After(t) {
+				points = append(points, Point{Time: at, Value: p.value})
+			}
+		}
+	}
+	return points
+}
+
+// SetHistory sets how much history the engine keeps of each signal: a
+// point per step, the last size of them. A size of 0 keeps none. It drops
+// what was kept so far.
+func (se *Engine) SetHistory(step time.Duration, size int) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.historyStep, se.historySize = max(step, 1), max(size, 0)
+	se.history = make(map[string]*history)
+}
+
+// History returns the values of the signal named name after since, oldest
+// first, or false if it has none.
+func (se *Engine) History(name string, since time.Time) ([]Point, bool) {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	h, ok := se.history[name]
+	if !ok {
+		return nil, false
+	}
+	return h.since(since), true
+}
+
+func (se *Engine) record(name string, at time.Time, value float64) {
+	if se.historySize == 0 {
+		return
+	}
+	h, ok := se.history[name]
+	if !ok {
+		h = &history{}
+		se.history[name] = h
+	}
+	h.add(at.UnixNano(), value, int64(se.historyStep), se.historySize)
+}
@@ -0,0 +1,66 @@
+package signals
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// PluginSymbol is the function a signal plugin exports. A plugin is a main
+// package built with go build -buildmode=plugin against this module, with
+// the same Go version and flags as the binary loading it, that declares
+//
+//	func NewSignals() []signals.Signal
+//
+// Plugins need cgo on Linux, macOS or FreeBSD; elsewhere LoadPlugin fails.
+const PluginSymbol = "NewSignals"
+
+// Factory makes a fresh set of signals. It is called once per book, as
+// signals keep state and each book's engine runs on its own goroutine.
+type Factory func() []Signal
+
+// LoadPlugin opens the Go plugin at path and returns its NewSignals.
+func LoadPlugin(path string) (Factory, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("signal plugin %s: %w", path, err)
+	}
+	return pluginFactory(path, p.Lookup)
+}
+
+func pluginFactory(path string, lookup func(string) (plugin.Symbol, error)) (Factory, error) {
+	sym, err := lookup(PluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("signal plugin %s: %w", path, err)
+	}
+	switch fn := sym.(type) {
+	case func() []Signal:
+		return fn, nil
+	case *func() []Signal:
+		return *fn, nil
+	}
+	return nil, fmt.Errorf("signal plugin %s: %s is a %T, want func() []signals.Signal", path, PluginSymbol, sym)
+}
+
+// RegisterFactory registers a set of f's signals with se after those already
+// there, so they can read their values. A name already taken is an error,
+// as the values would overwrite each other.
+func RegisterFactory(se *Engine, f Factory) error {
+	taken := make(map[string]bool)
+	for _, name := range se.Names() {
+		taken[name] = true
+	}
+	made := f()
+	for _, s := range made {
+		if s == nil {
+			return fmt.Errorf("signal factory returned a nil signal")
+		}
+		if taken[s.Name()] {
+			return fmt.Errorf("signal %q is already registered", s.Name())
+		}
+		taken[s.Name()] = true
+	}
+	for _, s := range made {
+		se.Register(s)
+	}
+	return nil
+}
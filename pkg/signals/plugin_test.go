@@ -0,0 +1,59 @@
+package signals
+
+import (
+	"errors"
+	"plugin"
+	"strings"
+	"testing"
+)
+
+type constSignal struct {
+	name  string
+	value float64
+}
+
+func (c constSignal) Name() string          { return c.name }
+func (c constSignal) Update(*Input) float64 { return c.value }
+
+func TestPluginFactory(t *testing.T) {
+	newSignals := func() []Signal { return []Signal{constSignal{"custom", 1}} }
+	for _, sym := range []plugin.Symbol{newSignals, &newSignals} {
+		f, err := pluginFactory("test.so", func(name string) (plugin.Symbol, error) {
+			if name != PluginSymbol {
+				return nil, errors.New("not found")
+			}
+			return sym, nil
+		})
+		if err != nil || len(f()) != 1 {
+			t.Errorf("pluginFactory(%T) = %v", sym, err)
+		}
+	}
+	if _, err := pluginFactory("test.so", func(string) (plugin.Symbol, error) { return func() Signal { return nil }, nil }); err == nil || !strings.Contains(err.Error(), "want func() []signals.Signal") {
+		t.Errorf("wrong type accepted: %v", err)
+	}
+	if _, err := pluginFactory("test.so", func(string) (plugin.Symbol, error) { return nil, errors.New("not found") }); err == nil {
+		t.Error("missing symbol accepted")
+	}
+	if _, err := LoadPlugin("/nonexistent/signals.so"); err == nil {
+		t.Error("missing plugin loaded")
+	}
+}
+
+func TestRegisterFactory(t *testing.T) {
+	se := NewEngine()
+	RegisterDefaults(se)
+	// Runs after the defaults, so it can read them
+	double := Func("double_spread", func(in *Input) float64 { return 2 * in.Values["spread_bps"] })
+	if err := RegisterFactory(se, func() []Signal { return []Signal{double} }); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterFactory(se, func() []Signal { return []Signal{constSignal{"spread_bps", 0}} }); err == nil {
+		t.Error("duplicate name registered")
+	}
+	if err := RegisterFactory(se, func() []Signal { return []Signal{constSignal{"a", 0}, constSignal{"a", 0}} }); err == nil {
+		t.Error("duplicate name within a set registered")
+	}
+	if _, ok := se.Value("a"); ok || len(se.Names()) != 16 {
+		t.Errorf("a rejected set was partly registered: %v", se.Names())
+	}
+}
@@ -0,0 +1,82 @@
+package signals
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// MarketQuality measures how much of a mirrored book's liquidity is real
+// over a rolling window of trade time. Its value is the order-to-trade
+// ratio, volume added to the book per unit traded; CancelToFill is volume
+// withdrawn without trading per unit traded, counting every removal beyond
+// the traded volume as a cancel. High ratios mean quotes that flicker
+// rather than fill. Synthetic books have no depth updates, so the ratios
+// are only meaningful for mirrored ones.
+type MarketQuality struct {
+	name         string
+	window       time.Duration
+	samples      []qualitySample
+	cancelToFill float64
+}
+
+type qualitySample struct {
+	at       time.Time
+	activity orderbook.MirrorActivity
+}
+
+func NewMarketQuality(name string, window time.Duration) *MarketQuality {
+	return &MarketQuality{name: name, window: window, cancelToFill: math.NaN()}
+}
+
+// RegisterMarketQuality registers the order_to_trade and cancel_to_fill
+// signals over window, named with its length as in order_to_trade_1m.
+func RegisterMarketQuality(se *Engine, window time.Duration) {
+	suffix := windowSuffix(window)
+	q := NewMarketQuality("order_to_trade_"+suffix, window)
+	se.Register(q)
+	se.Register(Func("cancel_to_fill_"+suffix, func(*Input) float64 { return q.CancelToFill() }))
+}
+
+// windowSuffix names a window in signal names, as 1m rather than 1m0s.
+func windowSuffix(window time.Duration) string {
+	switch {
+	case window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	}
+	return window.String()
+}
+
+func (q *MarketQuality) Name() string { return q.name }
+
+func (q *MarketQuality) Update(in *Input) float64 {
+	now := in.Trade.Timestamp
+	q.samples = append(q.samples, qualitySample{at: now, activity: in.Book.MirrorActivity()})
+	// The base is the newest sample at least a window old, or the oldest
+	// one while the window fills
+	cutoff := now.Add(-q.window)
+	i := 0
+	for i+1 < len(q.samples) && !q.samples[i+1].at.After(cutoff) {
+		i++
+	}
+	q.samples = q.samples[i:]
+
+	base, cur := q.samples[0].activity, q.samples[len(q.samples)-1].activity
+	traded := float64(cur.TradedVolume - base.TradedVolume)
+	if traded == 0 {
+		q.cancelToFill = math.NaN()
+		return math.NaN()
+	}
+	added := float64(cur.Added - base.Added)
+	cancelled := math.Max(float64(cur.Removed-base.Removed)-traded, 0)
+	q.cancelToFill = cancelled / traded
+	return added / traded
+}
+
+// CancelToFill is the cancel-to-fill ratio as of the last update; NaN while
+// nothing has traded in the window.
+func (q *MarketQuality) CancelToFill() float64 { return q.cancelToFill }
@@ -0,0 +1,92 @@
+package signals
+
+import (
+	"math"
+	"time"
+)
+
+// The states of the vol_regime signal.
+const (
+	RegimeQuiet    = 0
+	RegimeNormal   = 1
+	RegimeStressed = 2
+)
+
+// Where in its own history the realized volatility has to be for
+// VolRegime to call the market quiet or stressed.
+const (
+	quietRank    = 0.25
+	stressedRank = 0.9
+)
+
+// VolRegime classifies the market as quiet, normal or stressed by where
+// its short-term realized volatility ranks against its own recent history.
+// The volatility is that of the returns between interval closes over the
+// last window. Each time a trade closes an interval, it is ranked against
+// the volatilities recorded over the last history and then recorded
+// itself. A rank below the 25th percentile is RegimeQuiet, above the 90th
+// RegimeStressed, and anything between RegimeNormal. The state is unset
+// until the history holds a window's worth of volatilities.
+type VolRegime struct {
+	name    string
+	prices  intervalPrices
+	history []float64 // volatilities, oldest first
+	keep    int
+	state   float64
+	rank    float64
+}
+
+func NewVolRegime(name string, interval, window, history time.Duration) *VolRegime {
+	return &VolRegime{
+		name:   name,
+		prices: intervalPrices{interval: interval, keep: int(window/interval) + 1},
+		keep:   int(history / interval),
+		state:  math.NaN(),
+		rank:   math.NaN(),
+	}
+}
+
+func (v *VolRegime) Name() string { return v.name }
+
+func (v *VolRegime) Update(in *Input) float64 {
+	if !v.prices.add(in.Trade.Price, in.Trade.Timestamp) {
+		return v.state
+	}
+	prices := v.prices.prices
+	if len(prices) < v.prices.keep {
+		return v.state
+	}
+	var sum float64
+	for i := 1; i < len(prices); i++ {
+		r := prices[i] - prices[i-1]
+		sum += r * r
+	}
+	vol := math.Sqrt(sum / float64(len(prices)-1))
+
+	if len(v.history) >= v.prices.keep {
+		below := 0
+		for _, h := range v.history {
+			if h < vol {
+				below++
+			}
+		}
+		v.rank = float64(below) / float64(len(v.history))
+		switch {
+		case v.rank < quietRank:
+			v.state = RegimeQuiet
+		case v.rank > stressedRank:
+			v.state = RegimeStressed
+		default:
+			v.state = RegimeNormal
+		}
+	}
+	v.history = append(v.history, vol)
+	if extra := len(v.history) - v.keep; extra > 0 {
+		v.history = append(v.history[:0], v.history[extra:]...)
+	}
+	return v.state
+}
+
+// Rank is where the latest volatility ranked in the history, from 0 for
+// the lowest to 1 for the highest; NaN until the state is set.
+func (v *VolRegime) Rank() float64 { return v.rank }
@@ -0,0 +1,157 @@
+package signals
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The interval and window ReturnStats are usually given.
+const (
+	DefaultReturnInterval = time.Second
+	DefaultReturnWindow   = 5 * time.Minute
+)
+
+// ReturnStats tells mean reversion from momentum in a symbol's returns. It
+// samples the last trade price at the close of every interval of trade
+// time, carrying it through intervals without trades, and over the
+// returns of the last window of intervals computes
+//
+//   - the first-order autocorrelation of returns, its own value: below 0
+//     while moves tend to reverse, above 0 while they tend to continue;
+//   - Lo and MacKinlay's variance ratio at each horizon, the variance of
+//     returns over the horizon over that of the interval returns times the
+//     number of intervals in it: 1 for a random walk, below it under mean
+//     reversion and above it under momentum.
+//
+// The values only change as intervals close, and are unset until the
+// window holds enough returns or while the price has not moved in it.
+type ReturnStats struct {
+	name     string
+	prices   intervalPrices
+	horizons []int // in intervals
+	autocorr float64
+	ratios   []float64
+	returns  []float64 // scratch
+}
+
+// intervalPrices samples the log price of the last trade at the close of
+// every interval of trade time, carrying it through intervals without
+// trades, and keeps the newest keep samples.
+type intervalPrices struct {
+	interval time.Duration
+	keep     int
+	prices   []float64
+	current  int64   // index of the interval of the newest trade
+	last     float64 // log price of the newest trade
+}
+
+// add records a trade, and reports whether it closed any intervals.
+func (s *intervalPrices) add(price float64, at time.Time) bool {
+	if price <= 0 {
+		return false
+	}
+	index := at.UnixNano() / int64(s.interval)
+	closed := false
+	switch {
+	case s.current == 0:
+		s.current = index
+	case index > s.current:
+		for n := min(index-s.current, int64(s.keep)); n > 0; n-- {
+			s.prices = append(s.prices, s.last)
+		}
+		if extra := len(s.prices) - s.keep; extra > 0 {
+			s.prices = append(s.prices[:0], s.prices[extra:]...)
+		}
+		s.current = index
+		closed = true
+	}
+	s.last = math.Log(price)
+	return closed
+}
+
+func NewReturnStats(name string, interval, window time.Duration, horizons []time.Duration) (*ReturnStats, error) {
+	if interval <= 0 || window < 2*interval {
+		return nil, fmt.Errorf("return window %v must span at least two intervals of %v", window, interval)
+	}
+	r := &ReturnStats{name: name, prices: intervalPrices{interval: interval, keep: int(window/interval) + 1}, autocorr: math.NaN()}
+	for _, h := range horizons {
+		if h <= interval || h%interval != 0 {
+			return nil, fmt.Errorf("variance ratio horizon %v is not a multiple of the return interval %v", h, interval)
+		}
+		if h > window/2 {
+			return nil, fmt.Errorf("variance ratio horizon %v is more than half the return window %v", h, window)
+		}
+		r.horizons = append(r.horizons, int(h/interval))
+		r.ratios = append(r.ratios, math.NaN())
+	}
+	return r, nil
+}
+
+// RegisterReturnStats registers the return_autocorr signal and a
+// variance_ratio signal for each horizon, named with its length as in
+// variance_ratio_30s.
+func RegisterReturnStats(se *Engine, interval, window time.Duration, horizons []time.Duration) error {
+	r, err := NewReturnStats("return_autocorr", interval, window, horizons)
+	if err != nil {
+		return err
+	}
+	se.Register(r)
+	for i, h := range horizons {
+		i := i
+		se.Register(Func("variance_ratio_"+windowSuffix(h), func(*Input) float64 { return r.ratios[i] }))
+	}
+	return nil
+}
+
+func (r *ReturnStats) Name() string { return r.name }
+
+func (r *ReturnStats) Update(in *Input) float64 {
+	if r.prices.add(in.Trade.Price, in.Trade.Timestamp) {
+		r.compute()
+	}
+	return r.autocorr
+}
+
+// compute recomputes the statistics over the sampled prices.
+func (r *ReturnStats) compute() {
+	r.autocorr = math.NaN()
+	for i := range r.ratios {
+		r.ratios[i] = math.NaN()
+	}
+	prices := r.prices.prices
+	r.returns = r.returns[:0]
+	for i := 1; i < len(prices); i++ {
+		r.returns = append(r.returns, prices[i]-prices[i-1])
+	}
+	n := len(r.returns)
+	if n < 3 {
+		return
+	}
+	mean := (prices[n] - prices[0]) / float64(n)
+	var variance, cov float64
+	for i, x := range r.returns {
+		variance += (x - mean) * (x - mean)
+		if i > 0 {
+			cov += (x - mean) * (r.returns[i-1] - mean)
+		}
+	}
+	if variance == 0 {
+		return
+	}
+	r.autocorr = cov / variance
+	variance /= float64(n)
+
+	for i, q := range r.horizons {
+		if n < 2*q {
+			continue
+		}
+		var varQ float64
+		for j := q; j <= n; j++ {
+			d := prices[j] - prices[j-q] - float64(q)*mean
+			varQ += d * d
+		}
+		varQ /= float64(n - q + 1)
+		r.ratios[i] = varQ / (float64(q) * variance)
+	}
+}
@@ -0,0 +1,92 @@
+package signals
+
+import (
+	"math"
+	"time"
+)
+
+// RollSpread is Roll's (1984) estimate of the effective spread, in bps of
+// the mean price, from the trades of a rolling window of trade time alone.
+// Trades bouncing between the bid and the ask make successive price
+// changes negatively correlated, and the spread is 2 * sqrt(-cov) of each
+// change with the one before. It needs no depth, so it stands in for
+// spread_bps where only trades are streamed. A window whose changes are
+// not negatively correlated, trending say, estimates 0, and the value is
+// unset until the window holds three changes.
+type RollSpread struct {
+	name   string
+	window time.Duration
+	last   float64 // price of the previous trade
+	change float64 // from the trade before it, if havePair
+	pairs  []rollPair
+	// Sums over pairs of the earlier change, the later one, their product,
+	// and the price
+	sumX, sumY, sumXY, sumPrice float64
+	havePair                    bool
+}
+
+// rollPair is two successive price changes, the second made by the trade
+// at at.
+type rollPair struct {
+	at    time.Time
+	x, y  float64
+	price float64
+}
+
+func NewRollSpread(name string, window time.Duration) *RollSpread {
+	return &RollSpread{name: name, window: window}
+}
+
+// RegisterRollSpread registers the roll_spread_bps signal over window,
+// named with its length as in roll_spread_bps_5m.
+func RegisterRollSpread(se *Engine, window time.Duration) {
+	se.Register(NewRollSpread("roll_spread_bps_"+windowSuffix(window), window))
+}
+
+func (r *RollSpread) Name() string { return r.name }
+
+func (r *RollSpread) Update(in *Input) float64 {
+	tr := in.Trade
+	if r.last == 0 {
+		r.last = tr.Price
+		return math.NaN()
+	}
+	change := tr.Price - r.last
+	r.last = tr.Price
+	if r.havePair {
+		p := rollPair{at: tr.Timestamp, x: r.change, y: change, price: tr.Price}
+		r.pairs = append(r.pairs, p)
+		r.sum(p, 1)
+	}
+	r.change, r.havePair = change, true
+
+	cutoff := tr.Timestamp.Add(-r.window)
+	i := 0
+	for i < len(r.pairs) && r.pairs[i].at.Before(cutoff) {
+		r.sum(r.pairs[i], -1)
+		i++
+	}
+	r.pairs = r.pairs[i:]
+	if len(r.pairs) == 0 {
+		// rather than carry rounding errors on
+		r.sumX, r.sumY, r.sumXY, r.sumPrice = 0, 0, 0, 0
+	}
+
+	n := float64(len(r.pairs))
+	if n < 2 {
+		return math.NaN()
+	}
+	cov := (r.sumXY - r.sumX*r.sumY/n) / (n - 1)
+	mean := r.sumPrice / n
+	if cov >= 0 || mean <= 0 {
+		return 0
+	}
+	return 2 * math.Sqrt(-cov) / mean * 1e4
+}
+
+func (r *RollSpread) sum(p rollPair, sign float64) {
+	r.sumX += sign * p.x
+	r.sumY += sign * p.y
+	r.sumXY += sign * p.x * p.y
+	r.sumPrice += sign * p.price
+}
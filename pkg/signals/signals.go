@@ -0,0 +1,416 @@
+// Package signals computes indicators, such as order book imbalance and
+// trade flow, from each trade and the book it traded against.
+package signals
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+type Input struct {
+	Trade  *orderbook.Trade
+	Book   *orderbook.Book
+	Values map[string]float64 // values already computed in this update
+	Cold   map[string]bool    // of those, the ones still a warm-up placeholder
+}
+
+type Signal interface {
+	Name() string
+	Update(in *Input) float64
+}
+
+// Failer is implemented by signals whose Update can fail. A NaN from a
+// signal normally leaves its last value in place; when Failed reports that
+// the update failed, the engine drops that value so it is not read as
+// current.
+type Failer interface {
+	Failed() bool
+}
+
+// Warmer is implemented by signals that report a placeholder until they
+// have seen enough trades, such as rsi_14's neutral 50. The engine lists
+// them in Input.Cold meanwhile, so a consumer can tell the placeholder
+// from a measurement.
+type Warmer interface {
+	Warm() bool
+}
+
+type Engine struct {
+	mu        sync.RWMutex
+	signals   []Signal
+	values    map[string]float64
+	cold      map[string]bool
+	updatedAt time.Time
+
+	history     map[string]*history // see SetHistory
+	historyStep time.Duration
+	historySize int
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		values:      make(map[string]float64),
+		cold:        make(map[string]bool),
+		history:     make(map[string]*history),
+		historyStep: DefaultHistoryStep,
+		historySize: DefaultHistorySize,
+	}
+}
+
+func (se *Engine) Register(s Signal) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	se.signals = append(se.signals, s)
+}
+
+// OnTrade runs every registered signal in registration order, so derived
+// signals (e.g. model scores) can read the values of signals registered before them.
+func (se *Engine) OnTrade(trade *orderbook.Trade, ob *orderbook.Book) {
+	se.mu.Lock()
+	defer se.mu.Unlock()
+
+	in := &Input{Trade: trade, Book: ob, Values: se.values, Cold: se.cold}
+	for _, s := range se.signals {
+		v := s.Update(in)
+		if w, ok := s.(Warmer); ok && !w.Warm() {
+			se.cold[s.Name()] = true
+		} else {
+			delete(se.cold, s.Name())
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			if f, ok := s.(Failer); ok && f.Failed() {
+				delete(se.values, s.Name())
+			}
+			continue
+		}
+		name := s.Name()
+		se.values[name] = v
+		se.record(name, trade.Timestamp, v)
+	}
+	se.updatedAt = trade.Timestamp
+}
+
+func (se *Engine) Value(name string) (float64, bool) {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	v, ok := se.values[name]
+	return v, ok
+}
+
+// UpdatedAt returns the time of the last trade the signals were updated
+// with.
+func (se *Engine) UpdatedAt() time.Time {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return se.updatedAt
+}
+
+func (se *Engine) Snapshot() map[string]float64 {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	out := make(map[string]float64, len(se.values))
+	for k, v := range se.values {
+		out[k] = v
+	}
+	return out
+}
+
+func (se *Engine) Names() []string {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	names := make([]string, 0, len(se.signals))
+	for _, s := range se.signals {
+		names = append(names, s.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FeatureVector returns the current values for names in the given order;
+// signals without a value yet contribute 0.
+func (se *Engine) FeatureVector(names []string) []float64 {
+	se.mu.RLock()
+	defer se.mu.RUnlock()
+	return featureVector(se.values, names)
+}
+
+func featureVector(values map[string]float64, names []string) []float64 {
+	vec := make([]float64, len(names))
+	for i, name := range names {
+		vec[i] = values[name]
+	}
+	return vec
+}
+
+// RegisterDefaults installs the built-in indicator set, mirroring the
+// C++ AlphaSignalGenerator periods plus book-derived signals.
+func RegisterDefaults(se *Engine) {
+	prices := newPriceHistory(1000)
+	se.Register(&funcSignal{name: "last_price", fn: func(in *Input) float64 {
+		prices.push(in.Trade.Price)
+		return in.Trade.Price
+	}})
+	se.Register(&funcSignal{name: "vwap", fn: func(in *Input) float64 {
+		return in.Book.GetVWAP()
+	}})
+	se.Register(&funcSignal{name: "spread_bps", fn: spreadBps})
+	se.Register(&funcSignal{name: "imbalance", fn: topImbalance})
+	se.Register(&funcSignal{name: "touch_age_s", fn: touchAge})
+	se.Register(NewFlowImbalance("ofi_1m", time.Minute))
+	burst := NewBurstDetector("burst", time.Second, time.Minute)
+	se.Register(burst)
+	se.Register(&funcSignal{name: "trade_intensity", fn: func(*Input) float64 { return burst.Intensity() }})
+	// Until they have enough prices these report 0, or 50 for rsi_14, as
+	// the C++ generator does, and say they are cold
+	se.Register(&funcSignal{name: "sma_10", fn: func(*Input) float64 { return prices.sma(10) }, warm: prices.holds(10)})
+	se.Register(&funcSignal{name: "sma_30", fn: func(*Input) float64 { return prices.sma(30) }, warm: prices.holds(30)})
+	se.Register(&funcSignal{name: "rsi_14", fn: func(*Input) float64 { return prices.rsi(14) }, warm: prices.holds(15)})
+	se.Register(&funcSignal{name: "momentum_10", fn: func(*Input) float64 { return prices.momentum(10) }, warm: prices.holds(11)})
+	se.Register(&funcSignal{name: "volatility_20", fn: func(*Input) float64 { return prices.volatility(20) }, warm: prices.holds(21)})
+	regime := NewVolRegime("vol_regime", time.Second, time.Minute, time.Hour)
+	se.Register(regime)
+	se.Register(&funcSignal{name: "vol_rank", fn: func(*Input) float64 { return regime.Rank() }})
+}
+
+// Func returns a signal named name whose value is fn's result.
+func Func(name string, fn func(in *Input) float64) Signal {
+	return &funcSignal{name: name, fn: fn}
+}
+
+type funcSignal struct {
+	name string
+	fn   func(in *Input) float64
+	warm func() bool // nil when fn needs no warm-up
+}
+
+func (f *funcSignal) Name() string             { return f.name }
+func (f *funcSignal) Update(in *Input) float64 { return f.fn(in) }
+func (f *funcSignal) Warm() bool               { return f.warm == nil || f.warm() }
+
+func spreadBps(in *Input) float64 {
+	bid, _, okBid := in.Book.GetBestBid()
+	ask, _, okAsk := in.Book.GetBestAsk()
+	if !okBid || !okAsk {
+		return math.NaN()
+	}
+	mid := (bid + ask) / 2
+	if mid <= 0 {
+		return math.NaN()
+	}
+	return (ask - bid) / mid * 1e4
+}
+
+func topImbalance(in *Input) float64 {
+	_, bidVol, _ := in.Book.GetBestBid()
+	_, askVol, _ := in.Book.GetBestAsk()
+	total := float64(bidVol) + float64(askVol)
+	if total == 0 {
+		return 0.0
+	}
+	return (float64(bidVol) - float64(askVol)) / total
+}
+
+// touchAge is the mean time in seconds the orders at the best bid and ask
+// have been resting, as of the trade. A touch that keeps being refilled
+// stays young; one that has not moved in a while grows old.
+func touchAge(in *Input) float64 {
+	age, ok := in.Book.TouchAge(in.Trade.Timestamp)
+	if !ok {
+		return math.NaN()
+	}
+	return age.Seconds()
+}
+
+// FlowImbalance is the aggressor-volume imbalance over a rolling time
+// window: (buy - sell) / (buy + sell), in [-1, 1].
+type FlowImbalance struct {
+	name    string
+	window  time.Duration
+	events  []flowEvent
+	buyVol  float64
+	sellVol float64
+}
+
+type flowEvent struct {
+	at  time.Time
+	qty float64
+	buy bool
+}
+
+func NewFlowImbalance(name string, window time.Duration) *FlowImbalance {
+	return &FlowImbalance{name: name, window: window}
+}
+
+func (f *FlowImbalance) Name() string { return f.name }
+
+func (f *FlowImbalance) Update(in *Input) float64 {
+	tr := in.Trade
+	buy := tr.Side == orderbook.Buy
+	f.events = append(f.events, flowEvent{at: tr.Timestamp, qty: tr.Quantity, buy: buy})
+	if buy {
+		f.buyVol += tr.Quantity
+	} else {
+		f.sellVol += tr.Quantity
+	}
+
+	cutoff := tr.Timestamp.Add(-f.window)
+	i := 0
+	for i < len(f.events) && f.events[i].at.Before(cutoff) {
+		if f.events[i].buy {
+			f.buyVol -= f.events[i].qty
+		} else {
+			f.sellVol -= f.events[i].qty
+		}
+		i++
+	}
+	f.events = f.events[i:]
+
+	total := f.buyVol + f.sellVol
+	if total <= 0 {
+		return 0.0
+	}
+	return (f.buyVol - f.sellVol) / total
+}
+
+// BurstDetector scores how clustered trades are. It estimates the trade
+// arrival intensity the way a Hawkes process with an exponential kernel
+// does, each trade adding to a rate that decays between trades, on a fast
+// and a slow time constant. The score is the fast rate over the slow one:
+// about 1 while trades arrive at their usual pace, well above it in a
+// burst, which tends to run ahead of a volatility spike.
+type BurstDetector struct {
+	name       string
+	fast, slow time.Duration
+	first      time.Time // of the first trade
+	last       time.Time
+	// Sums of exp(-(t-ti)/tau) over past trades, as of last
+	fastSum, slowSum float64
+	intensity        float64
+}
+
+func NewBurstDetector(name string, fast, slow time.Duration) *BurstDetector {
+	return &BurstDetector{name: name, fast: fast, slow: slow}
+}
+
+func (b *BurstDetector) Name() string { return b.name }
+
+func (b *BurstDetector) Update(in *Input) float64 {
+	at := in.Trade.Timestamp
+	if b.first.IsZero() {
+		b.first, b.last = at, at
+	}
+	// Trades out of order count as simultaneous
+	if dt := at.Sub(b.last); dt > 0 {
+		b.fastSum *= math.Exp(-dt.Seconds() / b.fast.Seconds())
+		b.slowSum *= math.Exp(-dt.Seconds() / b.slow.Seconds())
+		b.last = at
+	}
+	b.fastSum++
+	b.slowSum++
+
+	elapsed := b.last.Sub(b.first).Seconds()
+	if elapsed <= 0 {
+		b.intensity = math.NaN()
+		return math.NaN()
+	}
+	b.intensity = rate(b.fastSum, b.fast, elapsed)
+	return b.intensity / rate(b.slowSum, b.slow, elapsed)
+}
+
+// Intensity is the fast trade rate, in trades per second, as of the last
+// update; NaN until trades span some time.
+func (b *BurstDetector) Intensity() float64 { return b.intensity }
+
+// rate turns a decayed trade count into trades per second. Dividing by the
+// kernel's mass over the time observed keeps the estimate unbiased before
+// a full time constant has passed.
+func rate(sum float64, tau time.Duration, elapsed float64) float64 {
+	t := tau.Seconds()
+	return sum / (t * -math.Expm1(-elapsed/t))
+}
+
+type priceHistory struct {
+	prices []float64
+	max    int
+}
+
+func newPriceHistory(max int) *priceHistory {
+	return &priceHistory{max: max}
+}
+
+func (h *priceHistory) push(price float64) {
+	h.prices = append(h.prices, price)
+	if len(h.prices) > h.max {
+		h.prices = h.prices[len(h.prices)-h.max:]
+	}
+}
+
+// holds returns a check that the history has at least n prices.
+func (h *priceHistory) holds(n int) func() bool {
+	return func() bool { return len(h.prices) >= n }
+}
+
+func (h *priceHistory) sma(period int) float64 {
+	if len(h.prices) < period {
+		return 0.0
+	}
+	sum := 0.0
+	for _, p := range h.prices[len(h.prices)-period:] {
+		sum += p
+	}
+	return sum / float64(period)
+}
+
+func (h *priceHistory) rsi(period int) float64 {
+	n := len(h.prices)
+	if n < period+1 {
+		return 50.0 // Neutral RSI
+	}
+	var gains, losses float64
+	for i := n - period; i < n; i++ {
+		change := h.prices[i] - h.prices[i-1]
+		if change > 0 {
+			gains += change
+		} else {
+			losses -= change
+		}
+	}
+	if losses == 0 {
+		return 100.0
+	}
+	rs := gains / losses
+	return 100.0 - 100.0/(1.0+rs)
+}
+
+func (h *priceHistory) momentum(period int) float64 {
+	n := len(h.prices)
+	if n < period+1 {
+		return 0.0
+	}
+	past := h.prices[n-period-1]
+	if past == 0 {
+		return 0.0
+	}
+	return (h.prices[n-1] - past) / past * 100.0
+}
+
+func (h *priceHistory) volatility(period int) float64 {
+	if len(h.prices) < period+1 {
+		return 0.0
+	}
+	mean := h.sma(period)
+	if mean == 0 {
+		return 0.0
+	}
+	variance := 0.0
+	for _, p := range h.prices[len(h.prices)-period:] {
+		d := p - mean
+		variance += d * d
+	}
+	variance /= float64(period)
+	return math.Sqrt(variance) / mean * 100.0
+}
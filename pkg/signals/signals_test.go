@@ -0,0 +1,382 @@
+package signals
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+func TestSignalEngineDefaultSignals(t *testing.T) {
+	ob := orderbook.New()
+	se := NewEngine()
+	RegisterDefaults(se)
+
+	now := time.Now()
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 99.0, Quantity: 300, Side: orderbook.Buy, EntryTime: now.Add(-4 * time.Second)})
+	ob.SubmitOrder(&orderbook.Order{ID: 2, Price: 101.0, Quantity: 100, Side: orderbook.Sell, EntryTime: now.Add(-2 * time.Second)})
+	se.OnTrade(&orderbook.Trade{Price: 100.0, Quantity: 1.0, Side: orderbook.Buy, Timestamp: now}, ob)
+
+	if v, _ := se.Value("last_price"); v != 100.0 {
+		t.Errorf("last_price = %v, want 100.0", v)
+	}
+	if v, _ := se.Value("spread_bps"); math.Abs(v-200.0) > 0.01 {
+		t.Errorf("spread_bps = %v, want 200.0", v)
+	}
+	if v, _ := se.Value("imbalance"); math.Abs(v-0.5) > 0.0001 {
+		t.Errorf("imbalance = %v, want 0.5", v)
+	}
+	if v, _ := se.Value("touch_age_s"); v != 3 {
+		t.Errorf("touch_age_s = %v, want 3", v)
+	}
+	if v, _ := se.Value("rsi_14"); v != 50.0 {
+		t.Errorf("rsi_14 = %v, want 50.0 before warm-up", v)
+	}
+}
+
+func TestSignalEngineSpreadMissingOnEmptyBook(t *testing.T) {
+	se := NewEngine()
+	RegisterDefaults(se)
+	se.OnTrade(&orderbook.Trade{Price: 100.0, Quantity: 1.0, Side: orderbook.Buy, Timestamp: time.Now()}, orderbook.New())
+
+	if _, ok := se.Value("spread_bps"); ok {
+		t.Error("spread_bps should be unset when the book has no bids or asks")
+	}
+	if _, ok := se.Value("touch_age_s"); ok {
+		t.Error("touch_age_s should be unset when the book has no bids or asks")
+	}
+}
+
+func TestFlowImbalanceWindow(t *testing.T) {
+	sig := NewFlowImbalance("ofi", time.Minute)
+	start := time.Unix(1700000000, 0)
+
+	v := sig.Update(&Input{Trade: &orderbook.Trade{Quantity: 3, Side: orderbook.Buy, Timestamp: start}})
+	if v != 1.0 {
+		t.Errorf("after one buy = %v, want 1.0", v)
+	}
+	v = sig.Update(&Input{Trade: &orderbook.Trade{Quantity: 1, Side: orderbook.Sell, Timestamp: start.Add(10 * time.Second)}})
+	if math.Abs(v-0.5) > 0.0001 {
+		t.Errorf("after buy 3 / sell 1 = %v, want 0.5", v)
+	}
+	// The initial buy falls out of the window
+	v = sig.Update(&Input{Trade: &orderbook.Trade{Quantity: 1, Side: orderbook.Sell, Timestamp: start.Add(90 * time.Second)}})
+	if v != -1.0 {
+		t.Errorf("after window expiry = %v, want -1.0", v)
+	}
+}
+
+func TestPriceHistoryIndicators(t *testing.T) {
+	h := newPriceHistory(5)
+	for _, p := range []float64{1, 2, 3, 4, 5, 6, 7} {
+		h.push(p)
+	}
+
+	if len(h.prices) != 5 {
+		t.Fatalf("history length = %d, want 5", len(h.prices))
+	}
+	if got := h.sma(5); got != 5.0 {
+		t.Errorf("sma(5) = %v, want 5.0", got)
+	}
+	if got := h.rsi(4); got != 100.0 {
+		t.Errorf("rsi(4) on rising prices = %v, want 100.0", got)
+	}
+	if got := h.momentum(2); math.Abs(got-40.0) > 0.0001 {
+		t.Errorf("momentum(2) = %v, want 40.0", got)
+	}
+}
+
+func TestFeatureVectorOrder(t *testing.T) {
+	se := NewEngine()
+	se.Register(&funcSignal{name: "a", fn: func(*Input) float64 { return 1 }})
+	se.Register(&funcSignal{name: "b", fn: func(*Input) float64 { return 2 }})
+	se.OnTrade(&orderbook.Trade{Timestamp: time.Now()}, orderbook.New())
+
+	vec := se.FeatureVector([]string{"b", "missing", "a"})
+	want := []float64{2, 0, 1}
+	for i := range want {
+		if vec[i] != want[i] {
+			t.Errorf("FeatureVector()[%d] = %v, want %v", i, vec[i], want[i])
+		}
+	}
+}
+
+func TestBurstDetector(t *testing.T) {
+	b := NewBurstDetector("burst", time.Second, time.Minute)
+	start := time.Unix(1700000000, 0)
+	update := func(at time.Duration) float64 {
+		return b.Update(&Input{Trade: &orderbook.Trade{Timestamp: start.Add(at)}})
+	}
+	if v := update(0); !math.IsNaN(v) {
+		t.Errorf("first trade = %v, want NaN", v)
+	}
+	// Two minutes of a trade every 100ms
+	var v float64
+	for i := 1; i <= 1200; i++ {
+		v = update(time.Duration(i) * 100 * time.Millisecond)
+	}
+	if math.Abs(v-1) > 0.1 {
+		t.Errorf("steady flow scores %v, want about 1", v)
+	}
+	if r := b.Intensity(); math.Abs(r-10) > 1 {
+		t.Errorf("steady intensity = %v trades/s, want about 10", r)
+	}
+	// A burst of 50 trades in the next 100ms
+	for i := 0; i < 50; i++ {
+		v = update(120*time.Second + time.Duration(i)*2*time.Millisecond)
+	}
+	if v < 3 {
+		t.Errorf("burst scores %v, want well above 1", v)
+	}
+	// and a lull after it
+	if v = update(130 * time.Second); v > 0.2 {
+		t.Errorf("10s lull scores %v, want well below 1", v)
+	}
+}
+
+func TestRollSpread(t *testing.T) {
+	r := NewRollSpread("roll_spread_bps_1m", time.Minute)
+	start := time.Unix(1700000000, 0)
+	update := func(at time.Duration, price float64) float64 {
+		return r.Update(&Input{Trade: &orderbook.Trade{Price: price, Timestamp: start.Add(at)}})
+	}
+	// Trades bouncing between a bid of 99.95 and an ask of 100.05
+	var v float64
+	for i := 0; i < 200; i++ {
+		price := 99.95
+		if i%2 == 1 {
+			price = 100.05
+		}
+		v = update(time.Duration(i)*100*time.Millisecond, price)
+		if i < 3 && !math.IsNaN(v) {
+			t.Errorf("after %d trades = %v, want NaN", i+1, v)
+		}
+	}
+	if math.Abs(v-20) > 0.5 {
+		t.Errorf("bid-ask bounce estimates %v bps, want about 20", v)
+	}
+	// A steady climb has no bounce in it, once the bounce leaves the
+	// window
+	for i := 0; i < 100; i++ {
+		v = update(time.Minute+time.Duration(i)*time.Second, 100+float64(i)/10)
+	}
+	if v > 0.01 {
+		t.Errorf("trend estimates %v bps, want about 0", v)
+	}
+	if v = update(10*time.Minute, 100); !math.IsNaN(v) {
+		t.Errorf("after an idle window = %v, want NaN", v)
+	}
+}
+
+func TestReturnStats(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	run := func(prices func(i int) float64) (autocorr, ratio float64) {
+		se := NewEngine()
+		if err := RegisterReturnStats(se, time.Second, 10*time.Minute, []time.Duration{5 * time.Second}); err != nil {
+			t.Fatal(err)
+		}
+		// A trade a second for 20 minutes, and one to close the last
+		for i := 0; i <= 1200; i++ {
+			se.OnTrade(&orderbook.Trade{Price: prices(i), Timestamp: start.Add(time.Duration(i) * time.Second)}, orderbook.New())
+		}
+		autocorr, _ = se.Value("return_autocorr")
+		ratio, _ = se.Value("variance_ratio_5s")
+		return autocorr, ratio
+	}
+
+	// Prices bouncing between two levels reverse every move
+	if ac, vr := run(func(i int) float64 { return 100 + float64(i%2) }); ac > -0.95 || vr > 0.25 {
+		t.Errorf("bouncing prices: autocorr %v, variance ratio %v", ac, vr)
+	}
+	// Runs of five moves the same way continue them
+	if ac, vr := run(func(i int) float64 { return 100 + math.Abs(float64(i%10-5)) }); ac < 0.5 || vr < 1.5 {
+		t.Errorf("trending prices: autocorr %v, variance ratio %v", ac, vr)
+	}
+	rng := rand.New(rand.NewSource(1))
+	walk := 100.0
+	if ac, vr := run(func(int) float64 { walk += rng.Float64() - 0.5; return walk }); math.Abs(ac) > 0.15 || math.Abs(vr-1) > 0.3 {
+		t.Errorf("random walk: autocorr %v, variance ratio %v", ac, vr)
+	}
+
+	if err := RegisterReturnStats(NewEngine(), time.Second, time.Minute, []time.Duration{1500 * time.Millisecond}); err == nil {
+		t.Error("a horizon that is not a multiple of the interval was accepted")
+	}
+}
+
+func TestVolRegime(t *testing.T) {
+	v := NewVolRegime("vol_regime", time.Second, time.Minute, time.Hour)
+	rng := rand.New(rand.NewSource(1))
+	start := time.Unix(1700000000, 0)
+	price, at := 100.0, 0
+	run := func(seconds int, step float64) float64 {
+		var state float64
+		for end := at + seconds; at < end; at++ {
+			price += step * (rng.Float64() - 0.5)
+			state = v.Update(&Input{Trade: &orderbook.Trade{Price: price, Timestamp: start.Add(time.Duration(at) * time.Second)}})
+		}
+		return state
+	}
+	if state := run(60, 0.01); !math.IsNaN(state) {
+		t.Errorf("state after a minute = %v, want unset", state)
+	}
+	if state := run(3600, 0.01); state != RegimeNormal {
+		t.Errorf("steady market is %v, want normal", state)
+	}
+	if state := run(30, 0.5); state != RegimeStressed || v.Rank() != 1 {
+		t.Errorf("volatile market is %v at rank %v, want stressed", state, v.Rank())
+	}
+	if state := run(120, 0); state != RegimeQuiet {
+		t.Errorf("still market is %v, want quiet", state)
+	}
+}
+
+func TestDriftModel(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Unix(1700000000, 0)
+	// hint says which way the next trade moves the price, noise nothing
+	var hint float64
+	se := NewEngine()
+	se.Register(Func("hint", func(*Input) float64 { return hint }))
+	se.Register(Func("noise", func(*Input) float64 { return rng.NormFloat64() }))
+	RegisterDriftModel(se, []string{"noise", "hint"}, time.Second)
+	ob := orderbook.New()
+	price := 100.0
+	for i := 0; i < 2000; i++ {
+		price += hint * 0.01
+		hint = float64(rng.Intn(2)*2 - 1)
+		se.OnTrade(&orderbook.Trade{Price: price, Timestamp: start.Add(time.Duration(i) * time.Second)}, ob)
+		if i < 10 {
+			if _, ok := se.Value("drift_hit_rate"); ok {
+				t.Fatalf("hit rate set after %d trades", i+1)
+			}
+		}
+	}
+	if rate, _ := se.Value("drift_hit_rate"); rate < 0.95 {
+		t.Errorf("hit rate = %v on a feature that gives the move away", rate)
+	}
+	if p, _ := se.Value("drift_prob"); (hint > 0) != (p > 0.9) || (hint < 0) != (p < 0.1) {
+		t.Errorf("up probability %v with a hint of %v", p, hint)
+	}
+}
+
+func TestSignalHistory(t *testing.T) {
+	se := NewEngine()
+	se.SetHistory(time.Second, 3)
+	var value float64
+	se.Register(Func("v", func(*Input) float64 { return value }))
+	start := time.Unix(1700000000, 0)
+	for i, at := range []time.Duration{0, 500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second} {
+		value = float64(i)
+		se.OnTrade(&orderbook.Trade{Price: 100, Timestamp: start.Add(at)}, orderbook.New())
+	}
+	// The second trade replaced the first in its second, and the ring
+	// has since pushed it out
+	points, ok := se.History("v", time.Time{})
+	var values []float64
+	for _, p := range points {
+		values = append(values, p.Value)
+	}
+	if !ok || !slices.Equal(values, []float64{2, 3, 4}) || !points[0].Time.Equal(start.Add(time.Second)) {
+		t.Errorf("history = %v", points)
+	}
+	if points, _ := se.History("v", start.Add(2*time.Second)); len(points) != 1 || points[0].Value != 4 {
+		t.Errorf("history since 2s = %v", points)
+	}
+	if _, ok := se.History("w", time.Time{}); ok {
+		t.Error("an unknown signal has a history")
+	}
+}
+
+func TestMarketQuality(t *testing.T) {
+	ob := orderbook.New()
+	se := NewEngine()
+	RegisterMarketQuality(se, time.Minute)
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	trade := func(at time.Duration, msgs ...feed.Msg) {
+		ob.ApplyMirrored(append(msgs, feed.Msg{Kind: feed.KindTrade, Price: 100, Quantity: 1}))
+		se.OnTrade(&orderbook.Trade{Price: 100, Quantity: 1, Timestamp: base.Add(at)}, ob)
+	}
+	trade(0, feed.Msg{Kind: feed.KindReset},
+		feed.Msg{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 1},
+		feed.Msg{Kind: feed.KindLevel, Price: 101, Quantity: 3, Last: true})
+	if _, ok := se.Value("order_to_trade_1m"); ok {
+		t.Error("order_to_trade_1m set from a single trade")
+	}
+
+	// 5 added, 3 removed of which 1 traded
+	trade(30*time.Second,
+		feed.Msg{Kind: feed.KindLevel, Bid: true, Price: 99, Quantity: 6},
+		feed.Msg{Kind: feed.KindLevel, Price: 101, Last: true})
+	if v, _ := se.Value("order_to_trade_1m"); v != 5 {
+		t.Errorf("order_to_trade_1m = %v, want 5", v)
+	}
+	if v, _ := se.Value("cancel_to_fill_1m"); v != 2 {
+		t.Errorf("cancel_to_fill_1m = %v, want 2", v)
+	}
+
+	// The window now starts at the trade 30s in
+	trade(90*time.Second, feed.Msg{Kind: feed.KindLevel, Price: 102, Quantity: 1, Last: true})
+	if v, _ := se.Value("order_to_trade_1m"); v != 1 {
+		t.Errorf("order_to_trade_1m = %v, want 1", v)
+	}
+	if v, _ := se.Value("cancel_to_fill_1m"); v != 0 {
+		t.Errorf("cancel_to_fill_1m = %v, want 0", v)
+	}
+}
+
+func TestDepthImbalance(t *testing.T) {
+	tiers, err := ParseDepthTiers(DefaultDepthTiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	se := NewEngine()
+	RegisterDepthImbalance(se, tiers)
+	if names := se.Names(); len(names) != 6 || names[0] != "imbalance_10bps" || names[5] != "imbalance_top5" {
+		t.Errorf("names %v", names)
+	}
+
+	// Around a mid of 100: 100 bid at 99.99 (1 bps away) and 300 at 99.8
+	// (20 bps); 200 offered at 100.01 and at 100.2, and 500 at 101
+	ob := orderbook.New()
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 99.99, Quantity: 100, Side: orderbook.Buy})
+	ob.SubmitOrder(&orderbook.Order{ID: 2, Price: 99.8, Quantity: 300, Side: orderbook.Buy})
+	ob.SubmitOrder(&orderbook.Order{ID: 3, Price: 100.01, Quantity: 200, Side: orderbook.Sell})
+	ob.SubmitOrder(&orderbook.Order{ID: 4, Price: 100.2, Quantity: 200, Side: orderbook.Sell})
+	ob.SubmitOrder(&orderbook.Order{ID: 5, Price: 101, Quantity: 500, Side: orderbook.Sell})
+	se.OnTrade(&orderbook.Trade{Price: 100, Quantity: 1, Timestamp: time.Now()}, ob)
+	for name, want := range map[string]float64{
+		"imbalance_top1":  -100.0 / 300,
+		"imbalance_top5":  -500.0 / 1300,
+		"imbalance_top20": -500.0 / 1300,
+		"imbalance_5bps":  -100.0 / 300,
+		"imbalance_10bps": -100.0 / 300,
+		"imbalance_25bps": 0,
+	} {
+		if v, ok := se.Value(name); !ok || math.Abs(v-want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, v, want)
+		}
+	}
+
+	// Without a mid the distance tiers are unset
+	se = NewEngine()
+	RegisterDepthImbalance(se, tiers)
+	ob = orderbook.New()
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 100, Side: orderbook.Buy})
+	se.OnTrade(&orderbook.Trade{Price: 100, Quantity: 1, Timestamp: time.Now()}, ob)
+	if v, _ := se.Value("imbalance_top5"); v != 1 {
+		t.Errorf("imbalance_top5 of a one-sided book = %v", v)
+	}
+	if _, ok := se.Value("imbalance_5bps"); ok {
+		t.Error("imbalance_5bps set without a mid")
+	}
+
+	for _, bad := range []string{"0", "5,5", "x", "-3bps", "bps"} {
+		if _, err := ParseDepthTiers(bad); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
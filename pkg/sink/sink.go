@@ -0,0 +1,135 @@
+// Package sink drives consumers of a stream of events, such as storage
+// backends and onward deliveries, each from its own goroutine.
+package sink
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Sink consumes events for storage or onward delivery. Sinks are driven from
+// a single goroutine by a Runner, so implementations need no locking of their
+// own.
+type Sink[E any] interface {
+	Name() string
+	Write(e *E) error
+	Flush() error
+	Close() error
+}
+
+// Options configure a Runner. Write, when set, delivers each event in place
+// of the sink's own Write, so the caller can trace or release events around
+// it. Run, when set, runs the runner's loop, for example under a supervisor
+// that restarts it after a panic.
+type Options[E any] struct {
+	FlushEvery time.Duration
+	Write      func(s Sink[E], e *E) error
+	Run        func(loop func())
+}
+
+// Runner feeds a sink from a channel on its own goroutine, flushing
+// periodically and counting write failures.
+type Runner[E any] struct {
+	sink    Sink[E]
+	events  <-chan E
+	cancel  func()
+	write   func(s Sink[E], e *E) error
+	done    chan struct{}
+	written uint64
+	failed  uint64
+}
+
+// Start runs s on events until Stop or Shutdown. cancel, if not nil, is
+// called by them and must close events.
+func Start[E any](s Sink[E], events <-chan E, cancel func(), opts Options[E]) *Runner[E] {
+	r := &Runner[E]{
+		sink:   s,
+		events: events,
+		cancel: cancel,
+		write:  opts.Write,
+		done:   make(chan struct{}),
+	}
+	if r.write == nil {
+		r.write = Sink[E].Write
+	}
+	flushEvery := opts.FlushEvery
+	if flushEvery <= 0 {
+		flushEvery = time.Second
+	}
+	run := opts.Run
+	if run == nil {
+		run = func(loop func()) { loop() }
+	}
+	go func() {
+		defer close(r.done)
+		run(func() { r.run(flushEvery) })
+	}()
+	return r
+}
+
+func (r *Runner[E]) run(flushEvery time.Duration) {
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				r.flush()
+				return
+			}
+			if err := r.write(r.sink, &e); err != nil {
+				if atomic.AddUint64(&r.failed, 1) == 1 {
+					logger().Error("write failed", "sink", r.sink.Name(), "err", err)
+				}
+				continue
+			}
+			atomic.AddUint64(&r.written, 1)
+		case <-ticker.C:
+			r.flush()
+		}
+	}
+}
+
+func (r *Runner[E]) flush() {
+	if err := r.sink.Flush(); err != nil {
+		logger().Error("flush failed", "sink", r.sink.Name(), "err", err)
+	}
+}
+
+func logger() *slog.Logger {
+	return slog.Default().With("module", "sink")
+}
+
+// Count is a sink's delivery tally.
+type Count struct {
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+}
+
+func (r *Runner[E]) Name() string    { return r.sink.Name() }
+func (r *Runner[E]) Written() uint64 { return atomic.LoadUint64(&r.written) }
+func (r *Runner[E]) Failed() uint64  { return atomic.LoadUint64(&r.failed) }
+
+// Stop cancels the subscription, writes whatever is still queued, then closes
+// the sink.
+func (r *Runner[E]) Stop() error {
+	return r.Shutdown(context.Background())
+}
+
+// Shutdown is Stop, giving up waiting for the queue to drain once ctx is
+// done. It then returns ctx's error and leaves the sink open, as the runner
+// may still be writing to it.
+func (r *Runner[E]) Shutdown(ctx context.Context) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r.sink.Close()
+}
@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type intSink struct {
+	got     []int
+	flushes int
+	closed  bool
+}
+
+func (s *intSink) Name() string { return "ints" }
+
+func (s *intSink) Write(n *int) error {
+	if *n < 0 {
+		return errors.New("negative")
+	}
+	s.got = append(s.got, *n)
+	return nil
+}
+
+func (s *intSink) Flush() error { s.flushes++; return nil }
+func (s *intSink) Close() error { s.closed = true; return nil }
+
+func TestRunnerDrainsOnStop(t *testing.T) {
+	events := make(chan int, 16)
+	s := &intSink{}
+	r := Start[int](s, events, func() { close(events) }, Options[int]{FlushEvery: time.Hour})
+	for _, n := range []int{1, 2, -1, 3} {
+		events <- n
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.got) != 3 || r.Written() != 3 || r.Failed() != 1 {
+		t.Errorf("got %v, written = %d, failed = %d; want 3 written and 1 failed", s.got, r.Written(), r.Failed())
+	}
+	if s.flushes == 0 || !s.closed {
+		t.Errorf("flushes = %d, closed = %v; want a final flush and close", s.flushes, s.closed)
+	}
+}
+
+func TestRunnerUsesWriteAndRunHooks(t *testing.T) {
+	events := make(chan int, 4)
+	s := &intSink{}
+	var wrapped, ran int
+	r := Start[int](s, events, func() { close(events) }, Options[int]{
+		FlushEvery: time.Hour,
+		Write: func(s Sink[int], n *int) error {
+			wrapped++
+			*n *= 10
+			return s.Write(n)
+		},
+		Run: func(loop func()) { ran++; loop() },
+	})
+	events <- 1
+	events <- 2
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if wrapped != 2 || ran != 1 || len(s.got) != 2 || s.got[1] != 20 {
+		t.Errorf("wrapped = %d, ran = %d, got %v; want both events written through the hooks", wrapped, ran, s.got)
+	}
+}
+
+type blockingSink struct {
+	intSink
+	release chan struct{}
+}
+
+func (s *blockingSink) Write(n *int) error {
+	<-s.release
+	return s.intSink.Write(n)
+}
+
+func TestRunnerShutdownGivesUpAtDeadline(t *testing.T) {
+	events := make(chan int, 4)
+	s := &blockingSink{release: make(chan struct{})}
+	var once sync.Once
+	r := Start[int](s, events, func() { once.Do(func() { close(events) }) }, Options[int]{FlushEvery: time.Hour})
+	events <- 1
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	if s.closed {
+		t.Error("sink closed while still being written")
+	}
+	close(s.release)
+	if err := r.Shutdown(context.Background()); err != nil || !s.closed || len(s.got) != 1 {
+		t.Errorf("second Shutdown = %v, closed = %v, got %v; want the queue drained and the sink closed", err, s.closed, s.got)
+	}
+}
@@ -0,0 +1,243 @@
+package apexlob
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"apexlob/pkg/orderbook"
+)
+
+// Position is a strategy's holding in one symbol, carried at average cost.
+type Position struct {
+	Quantity float64 `json:"quantity"` // negative when short
+	AvgPrice float64 `json:"avg_price"`
+	Realized float64 `json:"realized_pnl"`
+}
+
+// apply adds a fill: buying into a short (or selling into a long) realizes
+// the difference to the average price on the quantity closed, and whatever
+// is left over opens a position at the fill price.
+func (p *Position) apply(side orderbook.Side, price, quantity float64) {
+	signed := quantity
+	if side == orderbook.Sell {
+		signed = -quantity
+	}
+	if p.Quantity != 0 && (p.Quantity > 0) != (signed > 0) {
+		closed := math.Min(math.Abs(signed), math.Abs(p.Quantity))
+		if p.Quantity > 0 {
+			p.Realized += (price - p.AvgPrice) * closed
+		} else {
+			p.Realized += (p.AvgPrice - price) * closed
+		}
+		p.Quantity += math.Copysign(closed, signed)
+		signed -= math.Copysign(closed, signed)
+		if p.Quantity == 0 {
+			p.AvgPrice = 0
+		}
+	}
+	if signed != 0 {
+		p.AvgPrice = (p.AvgPrice*math.Abs(p.Quantity) + price*math.Abs(signed)) / (math.Abs(p.Quantity) + math.Abs(signed))
+		p.Quantity += signed
+	}
+}
+
+// Unrealized is the profit on the open position marked at mark.
+func (p Position) Unrealized(mark float64) float64 {
+	if p.Quantity == 0 {
+		return 0
+	}
+	return (mark - p.AvgPrice) * p.Quantity
+}
+
+// MarkPrice says which price open positions are valued at.
+type MarkPrice string
+
+const (
+	MarkLast MarkPrice = "last" // the book's last trade
+	MarkMid  MarkPrice = "mid"  // halfway between best bid and offer, or the last trade while a side is empty
+)
+
+func ParseMarkPrice(s string) (MarkPrice, error) {
+	switch mark := MarkPrice(strings.ToLower(strings.TrimSpace(s))); mark {
+	case "", MarkLast:
+		return MarkLast, nil
+	case MarkMid:
+		return mark, nil
+	}
+	return "", fmt.Errorf("invalid mark price %q: want last or mid", s)
+}
+
+// price is ob's mark.
+func (mark MarkPrice) price(ob *orderbook.Book) float64 {
+	if mark == MarkMid {
+		bid, _, okBid := ob.GetBestBid()
+		ask, _, okAsk := ob.GetBestAsk()
+		if okBid && okAsk {
+			return (bid + ask) / 2
+		}
+	}
+	return ob.GetLastTradePrice()
+}
+
+// PnLTracker keeps positions from fills and values them at the latest
+// marks. Every fill and mark moves the PnL, and the tracker remembers its
+// high-water mark and the deepest fall from it. It is not safe for
+// concurrent use.
+type PnLTracker struct {
+	mark      MarkPrice
+	positions map[string]*trackedPosition
+	order     []*trackedPosition // by first fill, so sums come out the same every run
+	realized  float64
+	peak      float64
+	drawdown  float64
+}
+
+type trackedPosition struct {
+	Position
+	symbol   string
+	mark     float64
+	turnover float64
+}
+
+func NewPnLTracker(mark MarkPrice) *PnLTracker {
+	if mark == "" {
+		mark = MarkLast
+	}
+	return &PnLTracker{mark: mark, positions: make(map[string]*trackedPosition)}
+}
+
+// Position returns the position in symbol.
+func (t *PnLTracker) Position(symbol string) Position {
+	if p, ok := t.positions[symbol]; ok {
+		return p.Position
+	}
+	return Position{}
+}
+
+// Fill adds a fill in symbol. A position not yet marked is valued at its
+// first fill's price.
+func (t *PnLTracker) Fill(symbol string, side orderbook.Side, price, quantity float64) {
+	p, ok := t.positions[symbol]
+	if !ok {
+		p = &trackedPosition{symbol: symbol}
+		t.positions[symbol] = p
+		t.order = append(t.order, p)
+	}
+	before := p.Realized
+	p.apply(side, price, quantity)
+	t.realized += p.Realized - before
+	p.turnover += price * quantity
+	if p.mark == 0 {
+		p.mark = price
+	}
+	t.update()
+}
+
+// Mark values the position in symbol at ob's mark price.
+func (t *PnLTracker) Mark(symbol string, ob *orderbook.Book) {
+	p, ok := t.positions[symbol]
+	if !ok {
+		return
+	}
+	if price := t.mark.price(ob); price > 0 {
+		p.mark = price
+	}
+	if p.Quantity != 0 {
+		t.update()
+	}
+}
+
+func (t *PnLTracker) pnl() float64 {
+	pnl := t.realized
+	for _, p := range t.order {
+		pnl += p.Unrealized(p.mark)
+	}
+	return pnl
+}
+
+func (t *PnLTracker) update() {
+	pnl := t.pnl()
+	t.peak = math.Max(t.peak, pnl)
+	t.drawdown = math.Max(t.drawdown, t.peak-pnl)
+}
+
+// PnLReport values a strategy's positions at their latest marks. Turnover
+// is the notional traded; gross exposure sums the value of every position,
+// long or short, and net exposure nets shorts against longs.
+type PnLReport struct {
+	Mark          MarkPrice              `json:"mark"`
+	RealizedPnL   float64                `json:"realized_pnl"`
+	UnrealizedPnL float64                `json:"unrealized_pnl"`
+	PnL           float64                `json:"pnl"`
+	PeakPnL       float64                `json:"peak_pnl"`
+	MaxDrawdown   float64                `json:"max_drawdown"`
+	Turnover      float64                `json:"turnover"`
+	GrossExposure float64                `json:"gross_exposure"`
+	NetExposure   float64                `json:"net_exposure"`
+	Positions     map[string]PositionPnL `json:"positions"`
+}
+
+type PositionPnL struct {
+	Position      Position `json:"position"`
+	Mark          float64  `json:"mark"`
+	UnrealizedPnL float64  `json:"unrealized_pnl"`
+	PnL           float64  `json:"pnl"`
+	Exposure      float64  `json:"exposure"` // signed
+	Turnover      float64  `json:"turnover"`
+}
+
+func (t *PnLTracker) Report() PnLReport {
+	r := PnLReport{Mark: t.mark, RealizedPnL: t.realized, PeakPnL: t.peak, MaxDrawdown: t.drawdown,
+		Positions: make(map[string]PositionPnL, len(t.positions))}
+	for _, p := range t.order {
+		s := PositionPnL{
+			Position:      p.Position,
+			Mark:          p.mark,
+			UnrealizedPnL: p.Unrealized(p.mark),
+			Exposure:      p.Quantity * p.mark,
+			Turnover:      p.turnover,
+		}
+		s.PnL = p.Realized + s.UnrealizedPnL
+		r.Positions[p.symbol] = s
+		r.UnrealizedPnL += s.UnrealizedPnL
+		r.Turnover += s.Turnover
+		r.GrossExposure += math.Abs(s.Exposure)
+		r.NetExposure += s.Exposure
+	}
+	r.PnL = r.RealizedPnL + r.UnrealizedPnL
+	return r
+}
+
+// RegisterPnLMetrics exports report's figures, taken at scrape time.
+func RegisterPnLMetrics(reg *MetricsRegistry, report func() PnLReport) {
+	reg.GaugeFunc("apexlob_strategy_pnl", "Strategy profit and loss: realized, unrealized at the mark, and their total.", func() []Sample {
+		r := report()
+		return []Sample{
+			{Labels: Labels{"kind": "realized"}, Value: r.RealizedPnL},
+			{Labels: Labels{"kind": "unrealized"}, Value: r.UnrealizedPnL},
+			{Labels: Labels{"kind": "total"}, Value: r.PnL},
+		}
+	})
+	reg.GaugeFunc("apexlob_strategy_max_drawdown", "Largest fall in strategy PnL from its high-water mark.", func() []Sample {
+		return []Sample{{Value: report().MaxDrawdown}}
+	})
+	reg.GaugeFunc("apexlob_strategy_turnover", "Notional the strategy has traded.", func() []Sample {
+		return []Sample{{Value: report().Turnover}}
+	})
+	reg.GaugeFunc("apexlob_strategy_exposure", "Value of the strategy's positions at the mark: gross, and net of shorts.", func() []Sample {
+		r := report()
+		return []Sample{
+			{Labels: Labels{"kind": "gross"}, Value: r.GrossExposure},
+			{Labels: Labels{"kind": "net"}, Value: r.NetExposure},
+		}
+	})
+	reg.GaugeFunc("apexlob_strategy_position", "Strategy position in each symbol; negative when short.", func() []Sample {
+		r := report()
+		samples := make([]Sample, 0, len(r.Positions))
+		for _, sym := range sortedKeys(r.Positions) {
+			samples = append(samples, Sample{Labels: Labels{"symbol": sym}, Value: r.Positions[sym].Position.Quantity})
+		}
+		return samples
+	})
+}
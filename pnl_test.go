@@ -0,0 +1,87 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestPositionApply(t *testing.T) {
+	var p Position
+	p.apply(orderbook.Buy, 100, 2)
+	p.apply(orderbook.Buy, 110, 2)
+	if p.Quantity != 4 || p.AvgPrice != 105 {
+		t.Fatalf("after buys: %+v", p)
+	}
+	// Selling 6 closes the long at a profit of 5 each and opens a short
+	p.apply(orderbook.Sell, 110, 6)
+	if p.Quantity != -2 || p.AvgPrice != 110 || p.Realized != 20 {
+		t.Fatalf("after flip: %+v", p)
+	}
+	if got := p.Unrealized(100); got != 20 {
+		t.Errorf("unrealized at 100 = %v, want 20", got)
+	}
+	p.apply(orderbook.Buy, 115, 2)
+	if p.Quantity != 0 || p.AvgPrice != 0 || p.Realized != 10 {
+		t.Errorf("after close: %+v", p)
+	}
+}
+
+func TestPnLTracker(t *testing.T) {
+	ob := orderbook.New()
+	ob.RestoreTotals(orderbook.TradeTotals{LastPrice: 100})
+	ob.SubmitOrder(&orderbook.Order{ID: 1, Price: 99, Quantity: 1000, Side: orderbook.Buy})
+	ob.SubmitOrder(&orderbook.Order{ID: 2, Price: 103, Quantity: 1000, Side: orderbook.Sell})
+
+	tr := NewPnLTracker(MarkMid)
+	tr.Fill("btcusdt", orderbook.Buy, 100, 2)
+	tr.Mark("btcusdt", ob) // mid 101: up 2
+	ob.RestoreTotals(orderbook.TradeTotals{LastPrice: 95})
+	tr.Fill("btcusdt", orderbook.Sell, 96, 1) // realizes -4 on one
+	tr.Fill("ethusdt", orderbook.Sell, 10, 5)
+	r := tr.Report()
+	btc := r.Positions["btcusdt"]
+	if r.Mark != MarkMid || btc.Mark != 101 || btc.Position.Quantity != 1 || btc.UnrealizedPnL != 1 || btc.PnL != -3 {
+		t.Fatalf("btcusdt = %+v", btc)
+	}
+	// From a peak of 2 down to -3
+	if r.RealizedPnL != -4 || r.PnL != -3 || r.PeakPnL != 2 || r.MaxDrawdown != 5 {
+		t.Errorf("report = %+v", r)
+	}
+	if r.Turnover != 346 || r.GrossExposure != 151 || r.NetExposure != 51 {
+		t.Errorf("turnover %v, exposure %v gross %v net", r.Turnover, r.GrossExposure, r.NetExposure)
+	}
+
+	// The last trade, with no spread to take a mid from
+	last := NewPnLTracker("")
+	last.Fill("btcusdt", orderbook.Buy, 100, 1)
+	last.Mark("btcusdt", ob)
+	if r := last.Report(); r.Mark != MarkLast || r.UnrealizedPnL != -5 || r.MaxDrawdown != 5 {
+		t.Errorf("marked at the last trade: %+v", r)
+	}
+	if _, err := ParseMarkPrice("close"); err == nil {
+		t.Error("invalid mark price accepted")
+	}
+}
+
+func TestPnLMetrics(t *testing.T) {
+	tr := NewPnLTracker(MarkLast)
+	tr.Fill("btcusdt", orderbook.Sell, 100, 0.5)
+	reg := NewMetricsRegistry()
+	RegisterPnLMetrics(reg, tr.Report)
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`apexlob_strategy_pnl{kind="realized"} 0`,
+		`apexlob_strategy_turnover 50`,
+		`apexlob_strategy_exposure{kind="net"} -50`,
+		`apexlob_strategy_position{symbol="btcusdt"} -0.5`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("missing %s in:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package apexlob
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"apexlob/pkg/orderbook"
+)
+
+// Per-message structs are recycled through sync.Pools. Ownership is explicit:
+//
+//   - Orders are pooled by the book, see orderbook.AcquireOrder.
+//   - Trade and Execution payloads published on the bus are reference
+//     counted. The publisher holds one reference and Publish adds one per
+//     delivery; consumers call Event.Release when done with the payload.
+//     A consumer that never releases only stops that payload from being
+//     reused, so releasing is an optimisation, never a requirement.
+
+// eventRef is the reference count shared by every copy of a pooled event.
+type eventRef interface {
+	retain()
+	release()
+}
+
+type pooledTrade struct {
+	orderbook.Trade
+	refs int32
+}
+
+var tradePool = sync.Pool{New: func() interface{} { return new(pooledTrade) }}
+
+func acquireTrade(tr *orderbook.Trade) *pooledTrade {
+	p := tradePool.Get().(*pooledTrade)
+	p.Trade, p.refs = *tr, 1
+	return p
+}
+
+func (p *pooledTrade) retain() { atomic.AddInt32(&p.refs, 1) }
+
+func (p *pooledTrade) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		p.Trade = orderbook.Trade{}
+		tradePool.Put(p)
+	}
+}
+
+type pooledExecution struct {
+	orderbook.Execution
+	refs int32
+}
+
+var executionPool = sync.Pool{New: func() interface{} { return new(pooledExecution) }}
+
+func acquireExecution(ex *orderbook.Execution) *pooledExecution {
+	p := executionPool.Get().(*pooledExecution)
+	p.Execution, p.refs = *ex, 1
+	return p
+}
+
+func (p *pooledExecution) retain() { atomic.AddInt32(&p.refs, 1) }
+
+func (p *pooledExecution) release() {
+	if atomic.AddInt32(&p.refs, -1) == 0 {
+		p.Execution = orderbook.Execution{}
+		executionPool.Put(p)
+	}
+}
+
+// Release gives back this consumer's reference to a pooled payload. The
+// event's Trade or Execution must not be used afterwards. It is a no-op for
+// events that are not pooled.
+func (e *Event) Release() {
+	if e.ref != nil {
+		e.ref.release()
+		e.ref = nil
+	}
+}
@@ -0,0 +1,63 @@
+package apexlob
+
+import (
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestPooledEventReferenceCounting(t *testing.T) {
+	bus := NewEventBus()
+	a, cancelA := bus.Subscribe(4, nil, []EventType{EventExecution})
+	defer cancelA()
+	b, cancelB := bus.Subscribe(4, nil, []EventType{EventExecution})
+	defer cancelB()
+	_, cancelFull := bus.Subscribe(0, nil, []EventType{EventExecution}) // always drops
+	defer cancelFull()
+
+	PublishExecution(bus, &orderbook.Execution{Symbol: "btcusdt", TakerID: 7, Price: 100, Timestamp: time.Now()}, SpanContext{})
+	ea, eb := <-a, <-b
+	pe := ea.ref.(*pooledExecution)
+	if pe.refs != 2 {
+		t.Fatalf("refs = %d, want one per delivered copy", pe.refs)
+	}
+	ea.Release()
+	ea.Release() // second release of the same copy is a no-op
+	if eb.Execution.TakerID != 7 || pe.refs != 1 {
+		t.Errorf("payload recycled while still referenced: %+v, refs %d", eb.Execution, pe.refs)
+	}
+	eb.Release()
+	if pe.refs != 0 {
+		t.Errorf("refs = %d after all releases", pe.refs)
+	}
+}
+
+func TestPublishExecutionDoesNotAllocate(t *testing.T) {
+	bus := NewEventBus()
+	ch, cancel := bus.Subscribe(1, nil, []EventType{EventExecution})
+	defer cancel()
+	ex := &orderbook.Execution{Symbol: "btcusdt", TakerID: 1, Price: 100}
+	allocs := testing.AllocsPerRun(1000, func() {
+		PublishExecution(bus, ex, SpanContext{})
+		e := <-ch
+		e.Release()
+	})
+	if allocs >= 0.1 {
+		t.Errorf("allocs per publish = %v, want ~0", allocs)
+	}
+}
+
+func BenchmarkPublishTradeEvents(b *testing.B) {
+	bus := NewEventBus()
+	state := NewSymbolState("btcusdt")
+	ch, cancel := bus.Subscribe(1, nil, []EventType{EventTrade})
+	defer cancel()
+	tr := &orderbook.Trade{Symbol: "btcusdt", Price: 100, Quantity: 1, Timestamp: time.Now()}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		PublishTradeEvents(bus, state, tr, SpanContext{})
+		e := <-ch
+		e.Release()
+	}
+}
@@ -0,0 +1,328 @@
+package apexlob
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+//go:embed schema/postgres/*.sql
+var postgresMigrations embed.FS
+
+type postgresMigration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// loadPostgresMigrations returns the embedded migrations ordered by the
+// numeric prefix of their file names (0001_init.sql, 0002_....sql).
+func loadPostgresMigrations() ([]postgresMigration, error) {
+	entries, err := postgresMigrations.ReadDir("schema/postgres")
+	if err != nil {
+		return nil, err
+	}
+	var out []postgresMigration
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", e.Name())
+		}
+		data, err := postgresMigrations.ReadFile(path.Join("schema/postgres", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, postgresMigration{version: version, name: e.Name(), sql: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	return out, nil
+}
+
+// MigratePostgres applies any migrations newer than the recorded schema
+// version, each in its own transaction, then converts the capture tables to
+// hypertables when the TimescaleDB extension is installed.
+func MigratePostgres(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		logger("postgres").Info("applied migration", "name", m.name)
+	}
+
+	var timescale bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale); err != nil {
+		return err
+	}
+	if timescale {
+		for _, table := range []string{"trades", "executions", "book_snapshots"} {
+			if _, err := db.Exec(`SELECT create_hypertable($1, 'ts', if_not_exists => TRUE, migrate_data => TRUE)`, table); err != nil {
+				return fmt.Errorf("hypertable %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+type PostgresConfig struct {
+	DSN          string
+	BookInterval time.Duration // minimum spacing of book snapshots per symbol
+	BatchSize    int           // rows per table buffered before an early COPY
+}
+
+// PostgresStore persists trades, executions and sampled book snapshots with
+// COPY, one transaction per flush. Closed candles are upserted instead, so a
+// bar backfilled and then closed again is not stored twice.
+type PostgresStore struct {
+	cfg      PostgresConfig
+	db       *sql.DB
+	tables   map[string]*pgBuffer
+	candles  []Candle
+	lastBook map[string]time.Time
+	rows     *Counter
+	errors   *Counter
+}
+
+type pgBuffer struct {
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
+func NewPostgresStore(cfg PostgresConfig, reg *MetricsRegistry) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("postgres: %w", err)
+	}
+	if err := MigratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := newPostgresStore(cfg, reg)
+	s.db = db
+	return s, nil
+}
+
+func newPostgresStore(cfg PostgresConfig, reg *MetricsRegistry) *PostgresStore {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	return &PostgresStore{
+		cfg: cfg,
+		tables: map[string]*pgBuffer{
+			"trades":         {table: "trades", columns: []string{"ts", "symbol", "id", "side", "price", "quantity"}},
+			"executions":     {table: "executions", columns: []string{"ts", "symbol", "taker_id", "maker_id", "side", "price", "quantity"}},
+			"book_snapshots": {table: "book_snapshots", columns: []string{"ts", "symbol", "side", "level", "price", "volume", "orders"}},
+		},
+		lastBook: make(map[string]time.Time),
+		rows:     reg.Counter("apexlob_postgres_rows_total", "Rows written to Postgres.", nil),
+		errors:   reg.Counter("apexlob_postgres_errors_total", "Rows dropped after a failed Postgres write.", nil),
+	}
+}
+
+func (s *PostgresStore) Name() string { return "postgres" }
+
+func (s *PostgresStore) Write(e *Event) error {
+	var buf *pgBuffer
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		buf = s.tables["trades"]
+		buf.rows = append(buf.rows, []interface{}{tr.Timestamp, tr.Symbol, int64(tr.ID), tr.Side.String(), tr.Price, tr.Quantity})
+	case EventExecution:
+		ex := e.Execution
+		buf = s.tables["executions"]
+		buf.rows = append(buf.rows, []interface{}{ex.Timestamp, ex.Symbol, int64(ex.TakerID), int64(ex.MakerID), ex.Side.String(), ex.Price, int64(ex.Quantity)})
+	case EventBook:
+		if last, ok := s.lastBook[e.Symbol]; ok && e.Timestamp.Sub(last) < s.cfg.BookInterval {
+			return nil
+		}
+		s.lastBook[e.Symbol] = e.Timestamp
+		buf = s.tables["book_snapshots"]
+		for i, lvl := range e.Book.Bids {
+			buf.rows = append(buf.rows, []interface{}{e.Timestamp, e.Symbol, "BID", i, lvl.Price, int64(lvl.Volume), lvl.Orders})
+		}
+		for i, lvl := range e.Book.Asks {
+			buf.rows = append(buf.rows, []interface{}{e.Timestamp, e.Symbol, "ASK", i, lvl.Price, int64(lvl.Volume), lvl.Orders})
+		}
+	case EventCandle:
+		s.candles = append(s.candles, *e.Candle)
+		return nil
+	default:
+		return nil
+	}
+	if len(buf.rows) >= s.cfg.BatchSize {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *PostgresStore) pending() int {
+	n := len(s.candles)
+	for _, b := range s.tables {
+		n += len(b.rows)
+	}
+	return n
+}
+
+// Flush copies all buffered rows in a single transaction; on failure the
+// batch is dropped and counted.
+func (s *PostgresStore) Flush() error {
+	n := s.pending()
+	if n == 0 {
+		return nil
+	}
+	err := s.copyAll()
+	for _, b := range s.tables {
+		b.rows = b.rows[:0]
+	}
+	s.candles = s.candles[:0]
+	if err != nil {
+		s.errors.Add(uint64(n))
+		return err
+	}
+	s.rows.Add(uint64(n))
+	return nil
+}
+
+func (s *PostgresStore) copyAll() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, b := range s.tables {
+		if len(b.rows) == 0 {
+			continue
+		}
+		stmt, err := tx.Prepare(pq.CopyIn(b.table, b.columns...))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, row := range b.rows {
+			if _, err := stmt.Exec(row...); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+		if err := stmt.Close(); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := upsertPostgresCandles(tx, s.candles); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func upsertPostgresCandles(tx *sql.Tx, candles []Candle) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(`INSERT INTO candles (open_time, symbol, interval_seconds, open, high, low, close, volume, trades)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (symbol, interval_seconds, open_time) DO UPDATE SET
+			open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low, close = EXCLUDED.close,
+			volume = EXCLUDED.volume, trades = EXCLUDED.trades`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range candles {
+		if _, err := stmt.Exec(c.OpenTime, c.Symbol, int64(c.Interval/time.Second), c.Open, c.High, c.Low, c.Close, c.Volume, c.Trades); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadCandles implements CandleStore.
+func (s *PostgresStore) LoadCandles(symbol string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	rows, err := s.db.Query(`SELECT open_time, open, high, low, close, volume, trades FROM candles
+		WHERE symbol = $1 AND interval_seconds = $2 AND open_time >= $3 AND open_time < $4 ORDER BY open_time`,
+		symbol, int64(interval/time.Second), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Candle
+	for rows.Next() {
+		c := Candle{Symbol: symbol, Interval: interval}
+		if err := rows.Scan(&c.OpenTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.Trades); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SaveCandles implements CandleStore. It may be called while the store
+// runs as a sink: it writes in its own transaction.
+func (s *PostgresStore) SaveCandles(candles []Candle) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := upsertPostgresCandles(tx, candles); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Close() error {
+	err := s.Flush()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package apexlob
+
+import (
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestPostgresMigrationsOrdered(t *testing.T) {
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].version != 1 {
+		t.Fatalf("migrations = %+v, want 0001 first", migrations)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version <= migrations[i-1].version {
+			t.Errorf("migration %s out of order", migrations[i].name)
+		}
+	}
+	for _, table := range []string{"trades", "executions", "book_snapshots"} {
+		if !strings.Contains(migrations[0].sql, "CREATE TABLE IF NOT EXISTS "+table) {
+			t.Errorf("initial migration does not create %s", table)
+		}
+	}
+}
+
+func TestPostgresStoreBuffersRows(t *testing.T) {
+	s := newPostgresStore(PostgresConfig{BookInterval: time.Second}, NewMetricsRegistry())
+	now := time.Now()
+	s.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: now, Trade: &orderbook.Trade{Symbol: "btcusdt", Timestamp: now}})
+	s.Write(&Event{Type: EventExecution, Symbol: "btcusdt", Timestamp: now, Execution: &orderbook.Execution{Symbol: "btcusdt", Quantity: 5}})
+	book := &BookSnapshot{Bids: []orderbook.PriceLevel{{Price: 1}}, Asks: []orderbook.PriceLevel{{Price: 2}, {Price: 3}}}
+	s.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: now, Book: book})
+	s.Write(&Event{Type: EventBook, Symbol: "btcusdt", Timestamp: now.Add(time.Millisecond), Book: book}) // sampled out
+
+	if got := len(s.tables["book_snapshots"].rows); got != 3 {
+		t.Errorf("book rows = %d, want 3", got)
+	}
+	if row := s.tables["book_snapshots"].rows[2]; row[2] != "ASK" || row[3] != 1 {
+		t.Errorf("second ask row = %v, want side ASK level 1", row)
+	}
+	s.Write(&Event{Type: EventCandle, Symbol: "btcusdt", Timestamp: now, Candle: &Candle{Symbol: "btcusdt", OpenTime: now, Interval: time.Minute}})
+	if len(s.candles) != 1 {
+		t.Errorf("candles = %d, want 1", len(s.candles))
+	}
+	if s.pending() != 6 {
+		t.Errorf("pending = %d, want 6", s.pending())
+	}
+}
+
+// Runs against a real server when APEXLOB_TEST_POSTGRES holds a DSN, e.g.
+// postgres://postgres@localhost/apexlob_test?sslmode=disable
+func TestPostgresStoreIntegration(t *testing.T) {
+	dsn := os.Getenv("APEXLOB_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("APEXLOB_TEST_POSTGRES not set")
+	}
+	store, err := NewPostgresStore(PostgresConfig{DSN: dsn}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sym := "test" + time.Now().Format("150405.000000")
+	store.Write(&Event{Type: EventTrade, Symbol: sym, Trade: &orderbook.Trade{Symbol: sym, ID: 1, Price: 10, Quantity: 2, Timestamp: time.Now()}})
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := sql.Open("postgres", dsn)
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM trades WHERE symbol = $1`, sym).Scan(&n); err != nil || n != 1 {
+		t.Errorf("stored trades = %d (%v), want 1", n, err)
+	}
+	// Migrations are idempotent
+	if err := MigratePostgres(db); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,130 @@
+package apexlob
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRangeWindow is the rolling window a symbol's PriceRange covers
+// unless --range-window sets another.
+const DefaultRangeWindow = time.Hour
+
+// rangeBuckets is how many slices a PriceRange's window is cut into.
+const rangeBuckets = 60
+
+// PriceRange follows a symbol's session open, high, low and close, from
+// its first trade on, and its high and low over a rolling window of trade
+// time. The window is kept as the high and low of each of rangeBuckets
+// slices of it, so it holds a fixed amount however busy the symbol is and
+// ends on a slice boundary: a 1h window reaches back between 59 and 60
+// minutes from the newest trade.
+type PriceRange struct {
+	mu      sync.RWMutex
+	window  time.Duration
+	width   int64 // of a bucket, in ns
+	buckets [rangeBuckets]rangeBucket
+	latest  int64 // newest bucket index
+	session PriceRangeSnapshot
+}
+
+type rangeBucket struct {
+	index     int64 // of the slice it holds, 0 if it holds none
+	high, low float64
+}
+
+// PriceRangeSnapshot is a PriceRange at one point. Range is High - Low.
+type PriceRangeSnapshot struct {
+	Open        float64  `json:"open"`
+	High        float64  `json:"high"`
+	Low         float64  `json:"low"`
+	Close       float64  `json:"close"`
+	Range       float64  `json:"range"`
+	Window      Duration `json:"window"`
+	WindowHigh  float64  `json:"window_high"`
+	WindowLow   float64  `json:"window_low"`
+	WindowRange float64  `json:"window_range"`
+}
+
+func NewPriceRange(window time.Duration) *PriceRange {
+	r := &PriceRange{}
+	r.SetWindow(window)
+	return r
+}
+
+// SetWindow changes the rolling window, forgetting the trades in the old
+// one.
+func (r *PriceRange) SetWindow(window time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.window = window
+	r.width = max(int64(window)/rangeBuckets, 1)
+	r.buckets = [rangeBuckets]rangeBucket{}
+	r.latest = 0
+}
+
+func (r *PriceRange) Window() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.window
+}
+
+// Add records a trade at price. A trade older than the window only counts
+// towards the session.
+func (r *PriceRange) Add(price float64, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &r.session
+	if s.Open == 0 {
+		s.Open, s.High, s.Low = price, price, price
+	}
+	s.High, s.Low, s.Close = max(s.High, price), min(s.Low, price), price
+
+	index := at.UnixNano()/r.width + 1 // so that 0 is an empty bucket
+	if index <= r.latest-rangeBuckets {
+		return
+	}
+	r.latest = max(r.latest, index)
+	b := &r.buckets[index%rangeBuckets]
+	if b.index != index {
+		*b = rangeBucket{index: index, high: price, low: price}
+		return
+	}
+	b.high, b.low = max(b.high, price), min(b.low, price)
+}
+
+// Snapshot returns the session and the window up to the newest trade, or
+// false before the first trade.
+func (r *PriceRange) Snapshot() (PriceRangeSnapshot, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s := r.session
+	if s.Open == 0 {
+		return s, false
+	}
+	s.Range = s.High - s.Low
+	s.Window = Duration(r.window)
+	for _, b := range r.buckets {
+		if b.index == 0 || b.index <= r.latest-rangeBuckets {
+			continue
+		}
+		if s.WindowHigh == 0 {
+			s.WindowHigh, s.WindowLow = b.high, b.low
+		}
+		s.WindowHigh, s.WindowLow = max(s.WindowHigh, b.high), min(s.WindowLow, b.low)
+	}
+	s.WindowRange = s.WindowHigh - s.WindowLow
+	return s, true
+}
+
+// shortDuration formats d without trailing zero units, as in 1h or 15m.
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
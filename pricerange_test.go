@@ -0,0 +1,36 @@
+package apexlob
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceRange(t *testing.T) {
+	r := NewPriceRange(time.Hour)
+	if _, ok := r.Snapshot(); ok {
+		t.Error("a range before any trade")
+	}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, price := range []float64{100, 108, 95, 102} {
+		r.Add(price, base.Add(time.Duration(i)*20*time.Minute))
+	}
+	// A trade too old for the window counts towards the session alone,
+	// and eighty minutes on the first two trades have left the window
+	r.Add(90, base.Add(-time.Hour))
+	r.Add(101, base.Add(80*time.Minute))
+	got, ok := r.Snapshot()
+	want := PriceRangeSnapshot{Open: 100, High: 108, Low: 90, Close: 101, Range: 18, Window: Duration(time.Hour),
+		WindowHigh: 102, WindowLow: 95, WindowRange: 7}
+	if !ok || got != want {
+		t.Errorf("range %+v, want %+v", got, want)
+	}
+
+	r.SetWindow(15 * time.Minute)
+	r.Add(104, base.Add(90*time.Minute))
+	if got, _ := r.Snapshot(); got.WindowHigh != 104 || got.WindowLow != 104 || got.High != 108 {
+		t.Errorf("after a new window %+v", got)
+	}
+	if s := shortDuration(90 * time.Minute); s != "1h30m" {
+		t.Errorf("shortDuration = %s", s)
+	}
+}
@@ -0,0 +1,5 @@
+// Package apexlobpb holds the generated protobuf and gRPC bindings for
+// proto/apexlob.proto.
+package apexlobpb
+
+//go:generate protoc -I .. --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative apexlob.proto
@@ -0,0 +1,242 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedisConfig struct {
+	URL     string // redis://[user:pass@]host:port[/db]
+	Prefix  string // channels are <prefix>:<symbol>:<type>, hashes <prefix>:<symbol>
+	Timeout time.Duration
+}
+
+// RedisSink publishes trades and signal updates on pub/sub channels and keeps
+// a hash per symbol with the latest trade and signal values, so a web
+// backend can read current state with a single HGETALL. Commands are
+// pipelined and their replies checked on Flush.
+type RedisSink struct {
+	cfg       RedisConfig
+	addr      string
+	user      string
+	pass      string
+	db        int
+	conn      net.Conn
+	r         *bufio.Reader
+	w         *bufio.Writer
+	pending   int
+	published *Counter
+	errors    *Counter
+}
+
+func NewRedisSink(cfg RedisConfig, reg *MetricsRegistry) (*RedisSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("redis: invalid url %q", cfg.URL)
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = "apexlob"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	s := &RedisSink{
+		cfg:       cfg,
+		addr:      u.Host,
+		published: reg.Counter("apexlob_redis_published_total", "Events published to Redis.", nil),
+		errors:    reg.Counter("apexlob_redis_errors_total", "Redis command and connection failures.", nil),
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.pass, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid database %q", db)
+		}
+	}
+	return s, nil
+}
+
+func (s *RedisSink) Name() string { return "redis" }
+
+func (s *RedisSink) Write(e *Event) error {
+	key := s.cfg.Prefix + ":" + e.Symbol
+	var fields []string
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		fields = []string{
+			"last_trade_price", strconv.FormatFloat(tr.Price, 'f', -1, 64),
+			"last_trade_quantity", strconv.FormatFloat(tr.Quantity, 'f', -1, 64),
+			"last_trade_side", tr.Side.String(),
+			"last_trade_time", tr.Timestamp.UTC().Format(time.RFC3339Nano),
+		}
+	case EventSignal:
+		for _, name := range sortedKeys(e.Signals) {
+			fields = append(fields, name, strconv.FormatFloat(e.Signals[name], 'f', -1, 64))
+		}
+	default:
+		return nil
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			s.errors.Inc()
+			return err
+		}
+	}
+	s.command("PUBLISH", fmt.Sprintf("%s:%s:%s", s.cfg.Prefix, e.Symbol, e.Type), string(payload))
+	fields = append(fields, "updated_at", e.Timestamp.UTC().Format(time.RFC3339Nano))
+	s.command(append([]string{"HSET", key}, fields...)...)
+	s.published.Inc()
+
+	if s.pending >= 512 {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *RedisSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r, s.w = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	s.pending = 0
+	if s.pass != "" {
+		if s.user != "" {
+			s.command("AUTH", s.user, s.pass)
+		} else {
+			s.command("AUTH", s.pass)
+		}
+	}
+	if s.db != 0 {
+		s.command("SELECT", strconv.Itoa(s.db))
+	}
+	if s.pending > 0 {
+		if err := s.Flush(); err != nil {
+			s.disconnect()
+			return err
+		}
+	}
+	return nil
+}
+
+// command appends one RESP array to the pipeline.
+func (s *RedisSink) command(args ...string) {
+	fmt.Fprintf(s.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(s.w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	s.pending++
+}
+
+// Flush sends the pipeline and reads one reply per queued command.
+func (s *RedisSink) Flush() error {
+	if s.conn == nil || s.pending == 0 {
+		return nil
+	}
+	s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout))
+	defer func() {
+		if s.conn != nil {
+			s.conn.SetDeadline(time.Time{})
+		}
+	}()
+
+	if err := s.w.Flush(); err != nil {
+		s.errors.Inc()
+		s.disconnect()
+		return err
+	}
+	var firstErr error
+	for ; s.pending > 0; s.pending-- {
+		err := readRESP(s.r)
+		var replyErr redisError
+		if errors.As(err, &replyErr) {
+			s.errors.Inc()
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err != nil {
+			s.errors.Inc()
+			s.disconnect()
+			return err
+		}
+	}
+	return firstErr
+}
+
+func (s *RedisSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.r, s.w = nil, nil, nil
+		s.pending = 0
+	}
+}
+
+func (s *RedisSink) Close() error {
+	err := s.Flush()
+	s.disconnect()
+	return err
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// readRESP consumes one reply, returning a redisError for "-" replies.
+func readRESP(r *bufio.Reader) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("redis: bad bulk length %q", line)
+		}
+		if n >= 0 {
+			_, err = io.ReadFull(r, make([]byte, n+2))
+		}
+		return err
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("redis: bad array length %q", line)
+		}
+		for i := 0; i < n; i++ {
+			if err := readRESP(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -0,0 +1,114 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"apexlob/pkg/orderbook"
+)
+
+// The tests run against miniredis, a RESP server that implements the
+// commands with Redis's own replies and error messages.
+
+func TestRedisSinkPublishesAndStoresState(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireAuth("pw")
+	sub := m.NewSubscriber()
+	sub.Psubscribe("apexlob:*")
+	var (
+		mu       sync.Mutex
+		messages []miniredis.PubsubPmessage
+	)
+	go func() {
+		for msg := range sub.Pmessages() {
+			mu.Lock()
+			messages = append(messages, msg)
+			mu.Unlock()
+		}
+	}()
+
+	sink, err := NewRedisSink(RedisConfig{URL: "redis://:pw@" + m.Addr() + "/2"}, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts, Trade: &orderbook.Trade{Price: 42000, Quantity: 0.5, Side: orderbook.Buy, Timestamp: ts}})
+	sink.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: ts, Signals: map[string]float64{"vwap": 41999.5}})
+	sink.Write(&Event{Type: EventBook, Symbol: "btcusdt"}) // ignored
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db := m.DB(2)
+	for field, want := range map[string]string{
+		"last_trade_price":    "42000",
+		"last_trade_quantity": "0.5",
+		"last_trade_side":     "BUY",
+		"vwap":                "41999.5",
+		"updated_at":          "2024-01-01T00:00:00Z",
+	} {
+		if got := db.HGet("apexlob:btcusdt", field); got != want {
+			t.Errorf("HGET apexlob:btcusdt %s = %q, want %q", field, got, want)
+		}
+	}
+	if keys := m.DB(0).Keys(); len(keys) != 0 {
+		t.Errorf("database 0 holds %v, want the state in database 2 only", keys)
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(messages) == 2
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	for i, want := range []string{"apexlob:btcusdt:trade", "apexlob:btcusdt:signal"} {
+		var e Event
+		if messages[i].Channel != want || json.Unmarshal([]byte(messages[i].Message), &e) != nil || e.Symbol != "btcusdt" {
+			t.Errorf("message %d on %s: %q, want a btcusdt event on %s", i, messages[i].Channel, messages[i].Message, want)
+		}
+	}
+	if sink.published.Value() != 2 {
+		t.Errorf("published = %d, want 2", sink.published.Value())
+	}
+}
+
+func TestRedisSinkRejectedPassword(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.RequireAuth("pw")
+	sink, _ := NewRedisSink(RedisConfig{URL: "redis://:wrong@" + m.Addr()}, NewMetricsRegistry())
+	err := sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{}})
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Write error = %v, want the server's WRONGPASS", err)
+	}
+	if sink.errors.Value() == 0 {
+		t.Error("refused AUTH not counted as an error")
+	}
+}
+
+func TestRedisSinkCountsErrorReplies(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("apexlob:btcusdt", "not a hash") // HSET on it fails with WRONGTYPE
+	sink, _ := NewRedisSink(RedisConfig{URL: "redis://" + m.Addr()}, NewMetricsRegistry())
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{}})
+	if err := sink.Flush(); err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+		t.Errorf("Flush error = %v, want the server's WRONGTYPE", err)
+	}
+	if sink.errors.Value() != 1 {
+		t.Errorf("errors = %d, want 1", sink.errors.Value())
+	}
+	// The connection stays usable after an error reply
+	m.Del("apexlob:btcusdt")
+	sink.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Trade: &orderbook.Trade{Price: 1}})
+	if err := sink.Close(); err != nil {
+		t.Errorf("Close after recovery: %v", err)
+	}
+	if got := m.HGet("apexlob:btcusdt", "last_trade_price"); got != "1" {
+		t.Errorf("last_trade_price after recovery = %q, want 1", got)
+	}
+}
@@ -0,0 +1,208 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/signals"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReferencePrices are the latest mark and index price Binance futures
+// publish for a symbol. The mark is what the exchange values positions at,
+// the index the average spot price across venues; trades far from either
+// are a bad tick or someone pushing the price. The same message carries
+// the perpetual's next funding rate.
+type ReferencePrices struct {
+	Mark        float64   `json:"mark"`
+	Index       float64   `json:"index"`
+	FundingRate float64   `json:"funding_rate"`
+	NextFunding time.Time `json:"next_funding"`
+	EventMs     int64     `json:"event_ms"`
+	Received    time.Time `json:"received"`
+}
+
+// SetReferencePrices replaces the symbol's mark and index price. The mark
+// feed reader sets them while shard workers read them, so they are swapped
+// atomically.
+func (s *SymbolState) SetReferencePrices(p ReferencePrices) {
+	s.refPrices.Store(&p)
+}
+
+// ReferencePrices returns the latest mark and index price, if they have
+// arrived.
+func (s *SymbolState) ReferencePrices() (ReferencePrices, bool) {
+	if p := s.refPrices.Load(); p != nil {
+		return *p, true
+	}
+	return ReferencePrices{}, false
+}
+
+// binanceMarkPriceURL is the futures markPrice stream of every symbol,
+// updated every second.
+func binanceMarkPriceURL(symbols []string) string {
+	streams := make([]string, len(symbols))
+	for i, sym := range symbols {
+		streams[i] = sym + "@markPrice@1s"
+	}
+	return "wss://fstream.binance.com/stream?streams=" + strings.Join(streams, "/")
+}
+
+// ingestMarkPrice parses a markPriceUpdate message and stores it on its
+// symbol's state.
+func ingestMarkPrice(shards *ShardSet, symbols *SymbolRegistry, msg []byte, u *feed.MarkPriceUpdate, received time.Time) error {
+	if err := feed.ParseMarkPrice(msg, u); err != nil {
+		return err
+	}
+	sym, ok := shards.Canonical(u.Symbol)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownSymbol, u.Symbol)
+	}
+	state, ok := symbols.Get(sym)
+	if !ok {
+		return fmt.Errorf("%w: %q", errUnknownSymbol, u.Symbol)
+	}
+	mark, err := feed.ParseDecimal(u.Mark)
+	if err != nil {
+		return invalidf("invalid mark price %q: %w", u.Mark, err)
+	}
+	index, err := feed.ParseDecimal(u.Index)
+	if err != nil {
+		return invalidf("invalid index price %q: %w", u.Index, err)
+	}
+	p := ReferencePrices{Mark: mark, Index: index, EventMs: u.EventMs, Received: received}
+	if len(u.FundingRate) > 0 {
+		if p.FundingRate, err = feed.ParseDecimal(u.FundingRate); err != nil {
+			return invalidf("invalid funding rate %q: %w", u.FundingRate, err)
+		}
+	}
+	if u.NextFundingMs > 0 {
+		p.NextFunding = time.UnixMilli(u.NextFundingMs).UTC()
+	}
+	state.SetReferencePrices(p)
+	return nil
+}
+
+// RunMarkPriceFeed reads the futures mark price stream at url until ctx is
+// done or it fails for good, reconnecting with backoff. Unlike the trade
+// feed its messages skip the shards: they only replace each symbol's mark
+// and index price, and do not count towards the message limit.
+func RunMarkPriceFeed(ctx context.Context, m *Monitor, dialer *websocket.Dialer, url string) {
+	markLog := logger("mark")
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		if ctx.Err() == nil {
+			markLog.Error("failed to connect to the mark price stream", "url", url, "err", err)
+		}
+		return
+	}
+	var mu sync.Mutex
+	stopped := false
+	current := conn
+	defer context.AfterFunc(ctx, func() {
+		mu.Lock()
+		stopped = true
+		current.Close()
+		mu.Unlock()
+	})()
+	defer func() {
+		mu.Lock()
+		current.Close()
+		mu.Unlock()
+	}()
+	markLog.Info("connected to the mark price stream", "url", url)
+
+	var message []byte
+	var update feed.MarkPriceUpdate
+	m.Supervisor.Run(ctx, "mark", func() {
+		for {
+			if message, err = feed.ReadMessage(conn, message); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				markLog.Warn("mark price stream dropped", "err", err)
+				conn.Close()
+				if conn, err = redial(ctx, dialer, url); err != nil {
+					if ctx.Err() == nil {
+						markLog.Error("giving up reconnecting", "err", err)
+					}
+					return
+				}
+				mu.Lock()
+				if stopped {
+					mu.Unlock()
+					conn.Close()
+					return
+				}
+				current = conn
+				mu.Unlock()
+				m.Reconnected()
+				continue
+			}
+			m.ingestFailed(ingestMarkPrice(m.Shards, m.Symbols, message, &update, time.Now()))
+		}
+	})
+}
+
+// registerMarkSignals adds mark_dev_bps and index_dev_bps to state's
+// signals: the trade price's deviation from the latest mark and index
+// price in basis points, unset until one has arrived. funding_rate_bps is
+// the perpetual's next funding rate.
+func registerMarkSignals(state *SymbolState) {
+	deviation := func(ref func(ReferencePrices) float64) func(*signals.Input) float64 {
+		return func(in *signals.Input) float64 {
+			p, ok := state.ReferencePrices()
+			if !ok || ref(p) <= 0 {
+				return math.NaN()
+			}
+			return (in.Trade.Price - ref(p)) / ref(p) * 1e4
+		}
+	}
+	state.Signals.Register(signals.Func("mark_dev_bps", deviation(func(p ReferencePrices) float64 { return p.Mark })))
+	state.Signals.Register(signals.Func("index_dev_bps", deviation(func(p ReferencePrices) float64 { return p.Index })))
+	state.Signals.Register(signals.Func("funding_rate_bps", func(*signals.Input) float64 {
+		if p, ok := state.ReferencePrices(); ok && !p.NextFunding.IsZero() {
+			return p.FundingRate * 1e4
+		}
+		return math.NaN()
+	}))
+}
+
+// markDeviationRules are the alert rules of --mark-deviation-bps: the trade
+// price more than bps away from the mark or the index, at most once a
+// minute per symbol.
+func markDeviationRules(bps float64) []RuleConfig {
+	rule := func(name, signal string) RuleConfig {
+		return RuleConfig{
+			Name:     name,
+			Expr:     fmt.Sprintf("%s > %g || %s < -%g", signal, bps, signal, bps),
+			Severity: "warning",
+			Cooldown: Duration(time.Minute),
+		}
+	}
+	return []RuleConfig{rule("mark_deviation", "mark_dev_bps"), rule("index_deviation", "index_dev_bps")}
+}
+
+func registerReferencePriceMetrics(reg *MetricsRegistry, symbols *SymbolRegistry) {
+	reg.GaugeFunc("apexlob_reference_price", "Latest Binance futures mark and index price.", func() []Sample {
+		var samples []Sample
+		for _, sym := range symbols.List() {
+			state, ok := symbols.Get(sym)
+			if !ok {
+				continue
+			}
+			if p, ok := state.ReferencePrices(); ok {
+				samples = append(samples,
+					Sample{Labels: Labels{"symbol": sym, "kind": "mark"}, Value: p.Mark},
+					Sample{Labels: Labels{"symbol": sym, "kind": "index"}, Value: p.Index})
+			}
+		}
+		return samples
+	})
+}
@@ -0,0 +1,57 @@
+package apexlob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBinanceMarkPriceURL(t *testing.T) {
+	want := "wss://fstream.binance.com/stream?streams=btcusdt@markPrice@1s/ethusdt@markPrice@1s"
+	if got := binanceMarkPriceURL([]string{"btcusdt", "ethusdt"}); got != want {
+		t.Errorf("URL = %s", got)
+	}
+}
+
+func TestReplayMarkPriceDeviation(t *testing.T) {
+	capture := strings.Join([]string{
+		`{"stream":"btcusdt@markPrice@1s","data":{"e":"markPriceUpdate","E":1700000000000,"s":"BTCUSDT","p":"100.00","i":"99.00","r":"0.0001"}}`,
+		`{"e":"aggTrade","E":1700000000100,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":false}`,
+		`{"e":"aggTrade","E":1700000000200,"s":"BTCUSDT","a":2,"p":"101.0","q":"1.0","m":false}`,
+	}, "\n")
+	path := filepath.Join(t.TempDir(), "mark.jsonl")
+	if err := os.WriteFile(path, []byte(capture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, MarkDeviation: 50, quietAlerts: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var alerts []AlertEvent
+	m.Rules[0].OnAlert(func(a AlertEvent) { alerts = append(alerts, a) })
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	state, _ := m.Symbols.Get("btcusdt")
+	if p, ok := state.ReferencePrices(); !ok || p.Mark != 100 || p.Index != 99 || p.EventMs != 1700000000000 {
+		t.Fatalf("reference prices = %+v, %v", p, ok)
+	}
+	if v, _ := state.Signals.Value("mark_dev_bps"); v != 100 {
+		t.Errorf("mark_dev_bps = %v, want 100", v)
+	}
+	// The first trade was already 101bps above the index; the second is
+	// 100bps above the mark too. Each rule fires once within its cooldown
+	if len(alerts) != 2 || alerts[0].Rule != "index_deviation" || alerts[1].Rule != "mark_deviation" {
+		t.Errorf("alerts = %+v", alerts)
+	}
+	if snap := state.BookSnapshot(1); snap.Reference == nil || snap.Reference.Index != 99 {
+		t.Errorf("snapshot reference = %+v", snap.Reference)
+	}
+}
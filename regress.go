@@ -0,0 +1,327 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"apexlob/pkg/orderbook"
+)
+
+// DefaultDigestCheckpoint is how many of a symbol's trades go between the
+// signal checkpoints of a digest.
+const DefaultDigestCheckpoint = 1000
+
+// maxDigestDiffs caps the differences reported per symbol; past the first
+// divergence most of what follows differs too.
+const maxDigestDiffs = 20
+
+// RegressionDigest is what replaying a capture through the engine produced,
+// per symbol: a hash of every trade and execution, and the signal values
+// every CheckpointEvery trades. A golden digest recorded before a change to
+// the matching or the signals is compared with one recorded after, see
+// DiffDigests.
+type RegressionDigest struct {
+	CheckpointEvery int                      `json:"checkpoint_every"`
+	Symbols         map[string]*SymbolDigest `json:"symbols"`
+}
+
+// SymbolDigest is one symbol's part of a RegressionDigest. The hashes are
+// FNV-1a over each trade's ID, price, quantity, side and time, and each
+// execution's order IDs, side, price, quantity and time.
+type SymbolDigest struct {
+	Trades        int                `json:"trades"`
+	TradeHash     string             `json:"trade_hash"`
+	Executions    int                `json:"executions"`
+	ExecutionHash string             `json:"execution_hash"`
+	Checkpoints   []SignalCheckpoint `json:"checkpoints"`
+
+	every      int
+	trades     hash.Hash64
+	executions hash.Hash64
+	buf        []byte
+}
+
+// SignalCheckpoint is a symbol's signals after its first Trades trades,
+// with the hash of those trades so a diff can tell where they diverged.
+type SignalCheckpoint struct {
+	Trades    int                `json:"trades"`
+	TradeHash string             `json:"trade_hash"`
+	Signals   map[string]float64 `json:"signals"`
+}
+
+func NewRegressionDigest(checkpointEvery int) *RegressionDigest {
+	return &RegressionDigest{CheckpointEvery: checkpointEvery, Symbols: make(map[string]*SymbolDigest)}
+}
+
+// symbol returns sym's digest, ready to record.
+func (d *RegressionDigest) symbol(sym string) *SymbolDigest {
+	s := &SymbolDigest{every: d.CheckpointEvery, trades: fnv.New64a(), executions: fnv.New64a()}
+	d.Symbols[sym] = s
+	return s
+}
+
+func (s *SymbolDigest) put(h hash.Hash64, values ...uint64) {
+	s.buf = s.buf[:0]
+	for _, v := range values {
+		s.buf = binary.LittleEndian.AppendUint64(s.buf, v)
+	}
+	h.Write(s.buf)
+}
+
+// trade records tr, once signals has taken it.
+func (s *SymbolDigest) trade(tr *orderbook.Trade, signals func() map[string]float64) {
+	s.put(s.trades, tr.ID, math.Float64bits(tr.Price), math.Float64bits(tr.Quantity), uint64(tr.Side), uint64(tr.Timestamp.UnixNano()))
+	s.Trades++
+	s.TradeHash = hashString(s.trades)
+	if s.every > 0 && s.Trades%s.every == 0 {
+		s.Checkpoints = append(s.Checkpoints, SignalCheckpoint{Trades: s.Trades, TradeHash: s.TradeHash, Signals: signals()})
+	}
+}
+
+func (s *SymbolDigest) execution(ex *orderbook.Execution) {
+	s.put(s.executions, ex.TakerID, ex.MakerID, uint64(ex.Side), math.Float64bits(ex.Price), uint64(ex.Quantity), uint64(ex.Timestamp.UnixNano()))
+	s.Executions++
+	s.ExecutionHash = hashString(s.executions)
+}
+
+func hashString(h hash.Hash64) string {
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// RecordDigest has the workers record d as they process the feed. It must
+// be called before Run. Each message is then processed on its own, as for
+// a strategy, so that signals never see the book after a burst of them and
+// the digest does not depend on how the queues happened to fill.
+func (m *Monitor) RecordDigest(d *RegressionDigest) {
+	m.digest = d
+}
+
+// RunRegression replays the capture at path through a pipeline built from
+// opts on a single worker and the event clock, recording its digest.
+func RunRegression(ctx context.Context, opts PipelineOptions, path string, checkpointEvery int) (*RegressionDigest, error) {
+	opts.quietAlerts = true
+	opts.resolveClock(true)
+	opts.Shards = 1
+	m, err := NewMonitor(time.Now(), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+	digest := NewRegressionDigest(checkpointEvery)
+	m.RecordDigest(digest)
+	done := m.Run()
+	err = ReplayCapture(ctx, m, path, 0)
+	<-done
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// DigestDiff is one way a digest differs from the golden one. What names
+// the output, as in trades or checkpoint 3000 spread_bps.
+type DigestDiff struct {
+	Symbol string `json:"symbol,omitempty"`
+	What   string `json:"what"`
+	Want   string `json:"want"`
+	Got    string `json:"got"`
+}
+
+// DiffDigests compares got with the golden digest want. Signal values
+// differ when they are further apart than tolerance, relative to the larger
+// of them or 1, so that a change in the order of floating point operations
+// can pass.
+func DiffDigests(want, got *RegressionDigest, tolerance float64) []DigestDiff {
+	var diffs []DigestDiff
+	if want.CheckpointEvery != got.CheckpointEvery {
+		return []DigestDiff{{What: "checkpoint_every", Want: strconv.Itoa(want.CheckpointEvery), Got: strconv.Itoa(got.CheckpointEvery)}}
+	}
+	for _, sym := range sortedKeys(want.Symbols) {
+		if _, ok := got.Symbols[sym]; !ok {
+			diffs = append(diffs, DigestDiff{Symbol: sym, What: "symbol", Want: "present", Got: "missing"})
+		}
+	}
+	for _, sym := range sortedKeys(got.Symbols) {
+		w, ok := want.Symbols[sym]
+		if !ok {
+			diffs = append(diffs, DigestDiff{Symbol: sym, What: "symbol", Want: "missing", Got: "present"})
+			continue
+		}
+		diffs = append(diffs, diffSymbolDigests(sym, w, got.Symbols[sym], tolerance)...)
+	}
+	return diffs
+}
+
+func diffSymbolDigests(sym string, want, got *SymbolDigest, tolerance float64) []DigestDiff {
+	var diffs []DigestDiff
+	add := func(what, w, g string) {
+		diffs = append(diffs, DigestDiff{Symbol: sym, What: what, Want: w, Got: g})
+	}
+	if want.Trades != got.Trades || want.TradeHash != got.TradeHash {
+		add("trades", fmt.Sprintf("%d %s", want.Trades, want.TradeHash), fmt.Sprintf("%d %s", got.Trades, got.TradeHash))
+	}
+	if want.Executions != got.Executions || want.ExecutionHash != got.ExecutionHash {
+		add("executions", fmt.Sprintf("%d %s", want.Executions, want.ExecutionHash), fmt.Sprintf("%d %s", got.Executions, got.ExecutionHash))
+	}
+	tradesDiverged := false
+	for i := 0; i < min(len(want.Checkpoints), len(got.Checkpoints)); i++ {
+		w, g := want.Checkpoints[i], got.Checkpoints[i]
+		at := fmt.Sprintf("checkpoint %d", w.Trades)
+		if w.TradeHash != g.TradeHash && !tradesDiverged {
+			// Only the first: the hashes differ from there on
+			tradesDiverged = true
+			add(at+" trade_hash", w.TradeHash, g.TradeHash)
+		}
+		for _, name := range sortedKeys(w.Signals) {
+			gv, ok := g.Signals[name]
+			switch {
+			case !ok:
+				add(at+" "+name, formatFloat(w.Signals[name]), "unset")
+			case !closeEnough(w.Signals[name], gv, tolerance):
+				add(at+" "+name, formatFloat(w.Signals[name]), formatFloat(gv))
+			}
+		}
+		for _, name := range sortedKeys(g.Signals) {
+			if _, ok := w.Signals[name]; !ok {
+				add(at+" "+name, "unset", formatFloat(g.Signals[name]))
+			}
+		}
+	}
+	if len(want.Checkpoints) != len(got.Checkpoints) {
+		add("checkpoints", strconv.Itoa(len(want.Checkpoints)), strconv.Itoa(len(got.Checkpoints)))
+	}
+	if len(diffs) > maxDigestDiffs {
+		more := len(diffs) - maxDigestDiffs
+		diffs = append(diffs[:maxDigestDiffs], DigestDiff{Symbol: sym, What: "more", Want: "", Got: fmt.Sprintf("%d more differences", more)})
+	}
+	return diffs
+}
+
+func closeEnough(a, b, tolerance float64) bool {
+	return a == b || math.Abs(a-b) <= tolerance*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// PrintDigestDiffs writes diffs one per line, as symbol, what, and the
+// golden value and the new one.
+func PrintDigestDiffs(w io.Writer, diffs []DigestDiff) {
+	for _, d := range diffs {
+		if d.What == "more" {
+			fmt.Fprintf(w, "%-12s ... %s\n", d.Symbol, d.Got)
+			continue
+		}
+		fmt.Fprintf(w, "%-12s %-36s %s -> %s\n", d.Symbol, d.What, d.Want, d.Got)
+	}
+}
+
+// ReadDigest reads a digest written by WriteDigest.
+func ReadDigest(path string) (*RegressionDigest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var d RegressionDigest
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return &d, nil
+}
+
+// WriteDigest writes d to path as indented JSON, so that a golden digest
+// diffs readably under version control.
+func WriteDigest(path string, d *RegressionDigest) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newRegressCommand() *cobra.Command {
+	var pipeline PipelineOptions
+	var input, golden string
+	var every int
+	var tolerance float64
+	var update, asJSON bool
+	cmd := &cobra.Command{
+		Use:   "regress",
+		Short: "Replay a capture and compare its trades, executions and signals with a golden digest",
+		Long: "Replays a capture through the engine on one worker and the event clock, and digests what it produced:\n" +
+			"a hash of every symbol's trades and executions, and its signal values every --checkpoint-every trades.\n" +
+			"The digest is compared with the golden one at --golden, and any difference fails the command.\n" +
+			"Without a golden digest, or with --update, the new one is written there instead.\n" +
+			"--symbol defaults to every symbol in the capture.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if every <= 0 {
+				return fmt.Errorf("invalid --checkpoint-every %d", every)
+			}
+			if err := symbolsFromCapture(cmd, &pipeline, input); err != nil {
+				return err
+			}
+			want, err := ReadDigest(golden)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			got, err := RunRegression(cmd.Context(), pipeline, input, every)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if want == nil || update {
+				if err := WriteDigest(golden, got); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "recorded %s from %s\n", golden, filepath.Base(input))
+				return nil
+			}
+			diffs := DiffDigests(want, got, tolerance)
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(diffs); err != nil {
+					return err
+				}
+			} else {
+				PrintDigestDiffs(out, diffs)
+			}
+			if len(diffs) > 0 {
+				return fmt.Errorf("%d differences from %s", len(diffs), golden)
+			}
+			if !asJSON {
+				fmt.Fprintf(out, "matches %s\n", golden)
+			}
+			return nil
+		},
+	}
+	pipeline.register(cmd.Flags())
+	cmd.Flags().StringVar(&input, "input", "", "capture file of raw aggTrade messages, one per line, as written by record, or compacted by compact")
+	cmd.Flags().StringVar(&golden, "golden", "", "golden digest to compare with, or to record when it does not exist yet")
+	cmd.Flags().IntVar(&every, "checkpoint-every", DefaultDigestCheckpoint, "record each symbol's signals every this many of its trades")
+	cmd.Flags().Float64Var(&tolerance, "tolerance", 1e-9, "relative difference allowed between signal values")
+	cmd.Flags().BoolVar(&update, "update", false, "write the new digest to --golden rather than compare with it")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the differences as JSON")
+	cmd.MarkFlagRequired("input")
+	cmd.MarkFlagRequired("golden")
+	return cmd
+}
@@ -0,0 +1,102 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRegressionIsRepeatable(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt", "ethusdt"}, 3000)
+	opts := PipelineOptions{Symbols: "btcusdt,ethusdt", Shards: 2, FeedQueue: 64}
+	first, err := RunRegression(context.Background(), opts, path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btc := first.Symbols["btcusdt"]
+	if btc == nil || btc.Trades == 0 || btc.Executions == 0 || len(btc.Checkpoints) != btc.Trades/100 || len(btc.Checkpoints[0].Signals) == 0 {
+		t.Fatalf("digest %+v", btc)
+	}
+	second, err := RunRegression(context.Background(), opts, path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := DiffDigests(first, second, 0); len(diffs) > 0 {
+		var out bytes.Buffer
+		PrintDigestDiffs(&out, diffs)
+		t.Errorf("the same capture digests differently:\n%s", out.String())
+	}
+}
+
+func TestDiffDigests(t *testing.T) {
+	digest := func(tradeHash string, spread float64) *RegressionDigest {
+		return &RegressionDigest{CheckpointEvery: 10, Symbols: map[string]*SymbolDigest{
+			"btcusdt": {Trades: 20, TradeHash: tradeHash, Executions: 3, ExecutionHash: "e", Checkpoints: []SignalCheckpoint{
+				{Trades: 10, TradeHash: "a", Signals: map[string]float64{"spread_bps": 1, "vwap": 100}},
+				{Trades: 20, TradeHash: tradeHash, Signals: map[string]float64{"spread_bps": spread, "vwap": 100}},
+			}},
+		}}
+	}
+	want := digest("b", 2)
+	if diffs := DiffDigests(want, digest("b", 2+1e-12), 1e-9); len(diffs) != 0 {
+		t.Errorf("within tolerance: %+v", diffs)
+	}
+	got := digest("c", 2.5)
+	delete(got.Symbols["btcusdt"].Checkpoints[1].Signals, "vwap")
+	got.Symbols["btcusdt"].Checkpoints[1].Signals["ofi"] = 0.5
+	got.Symbols["ethusdt"] = &SymbolDigest{}
+	var out bytes.Buffer
+	PrintDigestDiffs(&out, DiffDigests(want, got, 1e-9))
+	for _, line := range []string{
+		"btcusdt      trades                               20 b -> 20 c",
+		"btcusdt      checkpoint 20 trade_hash             b -> c",
+		"btcusdt      checkpoint 20 spread_bps             2 -> 2.5",
+		"btcusdt      checkpoint 20 vwap                   100 -> unset",
+		"btcusdt      checkpoint 20 ofi                    unset -> 0.5",
+		"ethusdt      symbol                               missing -> present",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("diff lacks %q:\n%s", line, out.String())
+		}
+	}
+	if diffs := DiffDigests(want, NewRegressionDigest(5), 0); len(diffs) != 1 || diffs[0].What != "checkpoint_every" {
+		t.Errorf("other checkpoints: %+v", diffs)
+	}
+}
+
+func TestRegressCommand(t *testing.T) {
+	path := writeCapture(t, []string{"solusdt"}, 500)
+	golden := filepath.Join(t.TempDir(), "golden.json")
+	run := func(args ...string) (string, error) {
+		root := NewRootCommand()
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(&out)
+		root.SetArgs(append([]string{"regress", "--input", path, "--golden", golden, "--checkpoint-every", "50", "--log-level", "error"}, args...))
+		err := root.Execute()
+		return out.String(), err
+	}
+	if out, err := run(); err != nil || !strings.Contains(out, "recorded") {
+		t.Fatalf("first run = %v:\n%s", err, out)
+	}
+	if out, err := run(); err != nil || !strings.Contains(out, "matches") {
+		t.Fatalf("second run = %v:\n%s", err, out)
+	}
+
+	d, err := ReadDigest(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Symbols["solusdt"].Checkpoints[2].Signals["vwap"] += 1
+	if err := WriteDigest(golden, d); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := run(); err == nil || !strings.Contains(out, "checkpoint 150 vwap") {
+		t.Errorf("changed golden = %v:\n%s", err, out)
+	}
+	if out, err := run("--update"); err != nil || !strings.Contains(out, "recorded") {
+		t.Errorf("--update = %v:\n%s", err, out)
+	}
+}
@@ -0,0 +1,202 @@
+package apexlob
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// RunReport is the final state of a monitor run, written at exit so
+// scripted runs can be compared against each other.
+type RunReport struct {
+	StartedAt  time.Time                 `json:"started_at"`
+	Elapsed    float64                   `json:"elapsed_seconds"`
+	StopReason string                    `json:"stop_reason"`
+	Stats      StatsSnapshot             `json:"stats"`
+	Symbols    map[string]SymbolReport   `json:"symbols"`
+	Alerts     map[string]map[string]int `json:"alerts"` // rule, then symbol
+	Sinks      map[string]SinkCount      `json:"sinks"`
+	Strategy   *StrategyReport           `json:"strategy,omitempty"`
+	Account    *AccountReport            `json:"account,omitempty"`
+}
+
+type SymbolReport struct {
+	LastPrice float64            `json:"last_price"`
+	VWAP      float64            `json:"vwap"`
+	Volume    uint32             `json:"volume"`
+	Notional  float64            `json:"notional"`
+	Signals   map[string]float64 `json:"signals"`
+	// TradeSizes counts the session's trades by notional
+	TradeSizes []HistogramBucket `json:"trade_sizes"`
+}
+
+func NewRunReport(m *Monitor, reason string, alerts map[string]map[string]int) RunReport {
+	r := RunReport{
+		StartedAt:  m.Start,
+		Elapsed:    time.Since(m.Start).Seconds(),
+		StopReason: reason,
+		Stats:      m.Snapshot(),
+		Symbols:    make(map[string]SymbolReport, len(m.SymbolList)),
+		Alerts:     alerts,
+		Sinks:      m.SinkCounts(),
+	}
+	if r.Alerts == nil {
+		r.Alerts = make(map[string]map[string]int)
+	}
+	if m.strategy != nil {
+		strategy := m.strategy.Report()
+		r.Strategy = &strategy
+	}
+	if m.account != nil {
+		account := m.account.Report()
+		r.Account = &account
+	}
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		totals := state.Book.TradeTotals()
+		r.Symbols[sym] = SymbolReport{
+			LastPrice: totals.LastPrice,
+			VWAP:      totals.VWAP(),
+			Volume:    totals.Volume,
+			Notional:  totals.Notional,
+			Signals:   state.Signals.Snapshot(),
+
+			TradeSizes: state.TradeSizes.Buckets(),
+		}
+	}
+	return r
+}
+
+// WriteFile writes the report as CSV if path ends in .csv and as JSON
+// otherwise.
+func (r RunReport) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = r.WriteCSV(f)
+	} else {
+		err = r.WriteJSON(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r RunReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the report in long form, one metric,symbol,value row per
+// number, so reports from different runs can be joined on the first two
+// columns. Run-wide metrics have an empty symbol.
+func (r RunReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	row := func(metric, symbol string, value float64) {
+		cw.Write([]string{metric, symbol, strconv.FormatFloat(value, 'g', -1, 64)})
+	}
+	cw.Write([]string{"metric", "symbol", "value"})
+	row("elapsed_seconds", "", r.Elapsed)
+	row("total_messages", "", float64(r.Stats.TotalMessages))
+	row("messages_per_second", "", r.Stats.MessagesPerSecond)
+	row("avg_processing_ms", "", r.Stats.AvgProcessingMs)
+	e := r.Stats.Errors
+	row("errors.parse", "", float64(e.ParseErrors))
+	row("errors.invalid", "", float64(e.ValidationFailures))
+	row("errors.unknown_symbol", "", float64(e.DroppedMessages))
+	row("errors.reconnects", "", float64(e.Reconnects))
+	row("errors.events_dropped", "", float64(e.DroppedEvents))
+	row("errors.sink_failures", "", float64(e.SinkFailures))
+	for _, l := range []struct {
+		name string
+		p    LatencyPercentiles
+	}{{"processing_latency", r.Stats.Processing}, {"end_to_end_latency", r.Stats.EndToEnd}} {
+		row(l.name+".p50_ms", "", l.p.P50)
+		row(l.name+".p90_ms", "", l.p.P90)
+		row(l.name+".p99_ms", "", l.p.P99)
+		row(l.name+".p999_ms", "", l.p.P999)
+	}
+	for _, sym := range sortedKeys(r.Symbols) {
+		s := r.Symbols[sym]
+		row("last_price", sym, s.LastPrice)
+		row("vwap", sym, s.VWAP)
+		row("volume", sym, float64(s.Volume))
+		row("notional", sym, s.Notional)
+		for _, name := range sortedKeys(s.Signals) {
+			row("signal."+name, sym, s.Signals[name])
+		}
+		for _, b := range s.TradeSizes {
+			bound := "inf"
+			if b.UpTo > 0 {
+				bound = strconv.FormatFloat(b.UpTo, 'f', -1, 64)
+			}
+			row("trade_sizes.le_"+bound, sym, float64(b.Count))
+		}
+	}
+	for _, rule := range sortedKeys(r.Alerts) {
+		for _, sym := range sortedKeys(r.Alerts[rule]) {
+			row("alerts."+rule, sym, float64(r.Alerts[rule][sym]))
+		}
+	}
+	if s := r.Strategy; s != nil {
+		row("strategy.orders", "", float64(s.Orders))
+		row("strategy.fills", "", float64(s.Fills))
+		row("strategy.realized_pnl", "", s.RealizedPnL)
+		row("strategy.unrealized_pnl", "", s.UnrealizedPnL)
+		row("strategy.pnl", "", s.PnL)
+		row("strategy.max_drawdown", "", s.MaxDrawdown)
+		row("strategy.turnover", "", s.Turnover)
+		row("strategy.gross_exposure", "", s.GrossExposure)
+		row("strategy.net_exposure", "", s.NetExposure)
+		for _, sym := range sortedKeys(s.Positions) {
+			row("strategy.position", sym, s.Positions[sym].Position.Quantity)
+			row("strategy.exposure", sym, s.Positions[sym].Exposure)
+			row("strategy.pnl", sym, s.Positions[sym].PnL)
+		}
+	}
+	for _, name := range sortedKeys(r.Sinks) {
+		row("sink_written."+name, "", float64(r.Sinks[name].Written))
+		row("sink_failed."+name, "", float64(r.Sinks[name].Failed))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// runOptions bound a monitor run and say where its report goes.
+type runOptions struct {
+	Duration    time.Duration
+	MaxMessages int
+	Report      string
+	// ShutdownTimeout bounds draining the queues and flushing the sinks
+	ShutdownTimeout time.Duration
+	Wait            bool // keep serving after the feed ends; set by replay
+}
+
+func (o *runOptions) register(fs *pflag.FlagSet) {
+	fs.DurationVar(&o.Duration, "duration", 0, "stop cleanly after this long, e.g. 10m (0 for no limit)")
+	fs.IntVar(&o.MaxMessages, "max-messages", 0, "stop cleanly after this many feed messages (0 for no limit)")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "on exit, how long to wait for queued messages to drain and sinks to flush; a second interrupt skips the wait")
+	fs.StringVar(&o.Report, "report", "", "write final statistics, book totals, signals and alert counts to this file: CSV if it ends in .csv, JSON otherwise")
+}
+
+func (o runOptions) validate() error {
+	if o.Duration < 0 {
+		return fmt.Errorf("invalid --duration %v", o.Duration)
+	}
+	if o.MaxMessages < 0 {
+		return fmt.Errorf("invalid --max-messages %d", o.MaxMessages)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunReport(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt"}, 200)
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, path, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	report := NewRunReport(m, "feed ended", map[string]map[string]int{"spread": {"btcusdt": 3}})
+	sym, ok := report.Symbols["btcusdt"]
+	if !ok || sym.Volume == 0 || sym.VWAP <= 0 || len(sym.Signals) == 0 {
+		t.Fatalf("symbol report = %+v", sym)
+	}
+	var trades uint64
+	for _, b := range sym.TradeSizes {
+		trades += b.Count
+	}
+	if len(sym.TradeSizes) != len(TradeSizeBuckets)+1 || trades == 0 {
+		t.Errorf("trade sizes = %+v", sym.TradeSizes)
+	}
+	if report.Stats.TotalMessages != 200 {
+		t.Errorf("report counts %d messages, want 200", report.Stats.TotalMessages)
+	}
+
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "run.json")
+	if err := report.WriteFile(jsonPath); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(jsonPath)
+	var decoded RunReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.StopReason != "feed ended" || decoded.Symbols["btcusdt"].Volume != sym.Volume || decoded.Alerts["spread"]["btcusdt"] != 3 {
+		t.Errorf("decoded report = %+v", decoded)
+	}
+
+	csvPath := filepath.Join(dir, "run.CSV")
+	if err := report.WriteFile(csvPath); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(csvPath)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[[2]string]string)
+	for _, row := range rows[1:] {
+		values[[2]string{row[0], row[1]}] = row[2]
+	}
+	for _, key := range [][2]string{
+		{"total_messages", ""}, {"processing_latency.p99_ms", ""}, {"vwap", "btcusdt"}, {"alerts.spread", "btcusdt"},
+		{"trade_sizes.le_10000000", "btcusdt"}, {"trade_sizes.le_inf", "btcusdt"},
+	} {
+		if _, ok := values[key]; !ok {
+			t.Errorf("CSV report has no %v row", key)
+		}
+	}
+	if values[[2]string{"total_messages", ""}] != "200" || values[[2]string{"alerts.spread", "btcusdt"}] != "3" {
+		t.Errorf("CSV values = %v", values)
+	}
+}
@@ -0,0 +1,161 @@
+package apexlob
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RotatingFile writes to <dir>/<prefix>-<timestamp><ext>, starting a new file
+// once the current one exceeds maxBytes or has been open for maxAge. Zero
+// limits disable that trigger.
+type RotatingFile struct {
+	dir      string
+	prefix   string
+	ext      string
+	maxBytes int64
+	maxAge   time.Duration
+	onOpen   func(w *bufio.Writer) error // e.g. write a CSV header
+	onClose  func(path string)           // a file is complete, e.g. to archive it
+	now      func() time.Time
+
+	file     *os.File
+	w        *bufio.Writer
+	size     int64
+	openedAt time.Time
+	path     string
+}
+
+func NewRotatingFile(dir, prefix, ext string, maxBytes int64, maxAge time.Duration) (*RotatingFile, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &RotatingFile{
+		dir:      dir,
+		prefix:   prefix,
+		ext:      ext,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		now:      time.Now,
+	}, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	if err := rf.rotateIfNeeded(); err != nil {
+		return 0, err
+	}
+	n, err := rf.w.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *RotatingFile) rotateIfNeeded() error {
+	if rf.file != nil {
+		full := rf.maxBytes > 0 && rf.size >= rf.maxBytes
+		old := rf.maxAge > 0 && rf.now().Sub(rf.openedAt) >= rf.maxAge
+		if !full && !old {
+			return nil
+		}
+		if err := rf.closeCurrent(); err != nil {
+			return err
+		}
+	}
+	return rf.open()
+}
+
+func (rf *RotatingFile) open() error {
+	now := rf.now()
+	path := rotatedPath(rf.dir, rf.prefix, rf.ext, now)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	rf.file = f
+	rf.w = bufio.NewWriterSize(f, 64*1024)
+	rf.size = 0
+	rf.openedAt = now
+	rf.path = path
+	if rf.onOpen != nil {
+		if err := rf.onOpen(rf.w); err != nil {
+			return err
+		}
+		rf.size = int64(rf.w.Buffered())
+	}
+	return nil
+}
+
+func (rf *RotatingFile) closeCurrent() error {
+	if rf.file == nil {
+		return nil
+	}
+	if err := rf.w.Flush(); err != nil {
+		return err
+	}
+	err := rf.file.Close()
+	rf.file, rf.w = nil, nil
+	if err == nil && rf.onClose != nil {
+		rf.onClose(rf.path)
+	}
+	return err
+}
+
+func (rf *RotatingFile) Flush() error {
+	if rf.w == nil {
+		return nil
+	}
+	return rf.w.Flush()
+}
+
+func (rf *RotatingFile) Close() error {
+	return rf.closeCurrent()
+}
+
+// Path is the file currently being written, or "" before the first write.
+func (rf *RotatingFile) Path() string {
+	if rf.file == nil {
+		return ""
+	}
+	return rf.path
+}
+
+// rotatedPath names a new file <dir>/<prefix>-<UTC timestamp><ext>. Several
+// files started within one second get a numeric suffix.
+func rotatedPath(dir, prefix, ext string, now time.Time) string {
+	base := fmt.Sprintf("%s-%s", prefix, now.UTC().Format("20060102T150405"))
+	path := filepath.Join(dir, base+ext)
+	for i := 1; fileExists(path); i++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s.%d%s", base, i, ext))
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseByteSize accepts sizes like "512KB", "100MB" or plain byte counts.
+func parseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, mult = strings.TrimSuffix(s, unit.suffix), unit.mult
+			break
+		}
+	}
+	var n int64
+	if _, err := fmt.Sscanf(strings.TrimSpace(s), "%d", &n); err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
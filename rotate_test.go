@@ -0,0 +1,72 @@
+package apexlob
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	rf, err := NewRotatingFile(dir, "trades", ".csv", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := 0
+	rf.onOpen = func(w *bufio.Writer) error {
+		headers++
+		_, err := w.WriteString("h\n")
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rf.Write([]byte("0123456789\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Close()
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "trades-*.csv"))
+	if len(paths) != 3 || headers != 3 {
+		t.Fatalf("files = %v, headers = %d; want 3 of each", paths, headers)
+	}
+	data, _ := os.ReadFile(paths[0])
+	if string(data) != "h\n0123456789\n" {
+		t.Errorf("first file = %q", data)
+	}
+}
+
+func TestRotatingFileRotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	rf, _ := NewRotatingFile(dir, "signals", ".jsonl", 0, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time { return now }
+
+	rf.Write([]byte("a\n"))
+	first := rf.Path()
+	now = now.Add(30 * time.Second)
+	rf.Write([]byte("b\n"))
+	if rf.Path() != first {
+		t.Error("rotated before the interval elapsed")
+	}
+	now = now.Add(time.Minute)
+	rf.Write([]byte("c\n"))
+	if rf.Path() == first {
+		t.Error("did not rotate after the interval elapsed")
+	}
+	rf.Close()
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{"0": 0, "": 0, "512": 512, "4KB": 4096, "100MB": 100 << 20, "1gb": 1 << 30}
+	for in, want := range tests {
+		got, err := parseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Error("expected error for non-numeric size")
+	}
+}
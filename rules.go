@@ -0,0 +1,309 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+type RuleConfig struct {
+	Name     string   `json:"name"`
+	Expr     string   `json:"expr"`
+	Severity string   `json:"severity,omitempty"`
+	Debounce Duration `json:"debounce,omitempty"` // condition must hold this long before firing
+	Cooldown Duration `json:"cooldown,omitempty"` // minimum time between firings, across separate breaches
+	Sinks    []string `json:"sinks,omitempty"`    // alert sinks to notify; empty means all
+	// Dedup makes a firing within this long of the last one part of the
+	// same incident: counted, but not sent again
+	Dedup Duration `json:"dedup,omitempty"`
+	// EscalateAfter sends the incident again, once, when it has fired this
+	// many times unacknowledged, with severity EscalateTo (critical by
+	// default) to EscalateSinks (the rule's sinks by default). It needs
+	// a Dedup window.
+	EscalateAfter int      `json:"escalate_after,omitempty"`
+	EscalateTo    string   `json:"escalate_to,omitempty"`
+	EscalateSinks []string `json:"escalate_sinks,omitempty"`
+}
+
+// Duration accepts Go duration strings ("30s", "5m") in JSON config.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type AlertEvent struct {
+	Rule      string             `json:"rule"`
+	Symbol    string             `json:"symbol"`
+	Expr      string             `json:"expr"`
+	Severity  string             `json:"severity"`
+	Timestamp time.Time          `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+	Sinks     []string           `json:"-"`
+	// Triggers is how many times the incident has fired, and Escalated is
+	// set on the escalation of one; see RuleConfig.Dedup
+	Triggers  int  `json:"triggers,omitempty"`
+	Escalated bool `json:"escalated,omitempty"`
+}
+
+// AlertIncident is the latest incident of a rule on a symbol: the firings
+// that followed each other within its Dedup window.
+type AlertIncident struct {
+	Rule         string    `json:"rule"`
+	Symbol       string    `json:"symbol"`
+	Severity     string    `json:"severity"`
+	Since        time.Time `json:"since"`
+	Last         time.Time `json:"last"`
+	Triggers     int       `json:"triggers"`
+	Escalated    bool      `json:"escalated"`
+	Acknowledged bool      `json:"acknowledged"`
+}
+
+type AlertHandler func(AlertEvent)
+
+type rule struct {
+	cfg    RuleConfig
+	expr   Expr
+	idents []string
+	state  map[string]*ruleState // per symbol
+}
+
+type ruleState struct {
+	trueSince time.Time
+	active    bool
+	fired     bool // fired since the condition last became true
+	lastFired time.Time
+	lastError string
+	incident  AlertIncident
+}
+
+type RuleEngine struct {
+	mu       sync.Mutex
+	rules    []*rule
+	handlers []AlertHandler
+}
+
+func NewRuleEngine() *RuleEngine {
+	return &RuleEngine{}
+}
+
+func (re *RuleEngine) AddRule(cfg RuleConfig) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("rule with expression %q has no name", cfg.Expr)
+	}
+	expr, err := ParseExpr(cfg.Expr)
+	if err != nil {
+		return fmt.Errorf("rule %s: %w", cfg.Name, err)
+	}
+	if cfg.Severity == "" {
+		cfg.Severity = "info"
+	}
+	if cfg.EscalateAfter > 0 && cfg.Dedup <= 0 {
+		return fmt.Errorf("rule %s: escalate_after needs a dedup window to count firings in", cfg.Name)
+	}
+	if cfg.EscalateTo == "" {
+		cfg.EscalateTo = "critical"
+	}
+
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.rules = append(re.rules, &rule{
+		cfg:    cfg,
+		expr:   expr,
+		idents: exprIdents(expr, nil),
+		state:  make(map[string]*ruleState),
+	})
+	return nil
+}
+
+func (re *RuleEngine) OnAlert(h AlertHandler) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.handlers = append(re.handlers, h)
+}
+
+func (re *RuleEngine) Rules() []RuleConfig {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	out := make([]RuleConfig, len(re.rules))
+	for i, r := range re.rules {
+		out[i] = r.cfg
+	}
+	return out
+}
+
+// Evaluate checks every rule against the latest signal values. A rule is
+// edge-triggered: it fires once its condition has held for the debounce
+// period, then stays quiet until the condition clears and holds again. A
+// new breach within the cooldown of the last firing waits for it to elapse.
+func (re *RuleEngine) Evaluate(symbol string, values map[string]float64, now time.Time) []AlertEvent {
+	re.mu.Lock()
+	var fired []AlertEvent
+	for _, r := range re.rules {
+		st := r.state[symbol]
+		if st == nil {
+			st = &ruleState{}
+			r.state[symbol] = st
+		}
+
+		v, err := r.expr.Eval(values)
+		if err != nil {
+			// Signals may not be warmed up yet; report each distinct error once
+			if msg := err.Error(); msg != st.lastError {
+				logger("rules").Warn("rule not evaluable", "rule", r.cfg.Name, "symbol", symbol, "err", err)
+				st.lastError = msg
+			}
+			st.active, st.fired = false, false
+			continue
+		}
+		st.lastError = ""
+
+		if v == 0 || math.IsNaN(v) {
+			st.active, st.fired = false, false
+			continue
+		}
+		if !st.active {
+			st.active = true
+			st.trueSince = now
+		}
+		if st.fired || now.Sub(st.trueSince) < time.Duration(r.cfg.Debounce) {
+			continue
+		}
+		if !st.lastFired.IsZero() && now.Sub(st.lastFired) < time.Duration(r.cfg.Cooldown) {
+			continue
+		}
+		st.lastFired, st.fired = now, true
+
+		inc := &st.incident
+		if inc.Triggers > 0 && r.cfg.Dedup > 0 && now.Sub(inc.Last) <= time.Duration(r.cfg.Dedup) {
+			inc.Triggers++
+			inc.Last = now
+			if r.cfg.EscalateAfter == 0 || inc.Triggers < r.cfg.EscalateAfter || inc.Escalated || inc.Acknowledged {
+				continue
+			}
+			inc.Escalated = true
+		} else {
+			*inc = AlertIncident{Rule: r.cfg.Name, Symbol: symbol, Severity: r.cfg.Severity, Since: now, Last: now, Triggers: 1}
+		}
+
+		event := AlertEvent{
+			Rule:      r.cfg.Name,
+			Symbol:    symbol,
+			Expr:      r.cfg.Expr,
+			Severity:  r.cfg.Severity,
+			Timestamp: now,
+			Values:    make(map[string]float64, len(r.idents)),
+			Sinks:     r.cfg.Sinks,
+			Triggers:  inc.Triggers,
+		}
+		for _, id := range r.idents {
+			event.Values[id] = values[id]
+		}
+		if inc.Escalated {
+			inc.Severity = r.cfg.EscalateTo
+			event.Severity, event.Escalated = r.cfg.EscalateTo, true
+			if len(r.cfg.EscalateSinks) > 0 {
+				event.Sinks = r.cfg.EscalateSinks
+			}
+		}
+		fired = append(fired, event)
+	}
+	handlers := re.handlers
+	re.mu.Unlock()
+
+	for _, event := range fired {
+		for _, h := range handlers {
+			h(event)
+		}
+	}
+	return fired
+}
+
+// Incidents returns the latest incident of every rule on every symbol it
+// has fired on.
+func (re *RuleEngine) Incidents() []AlertIncident {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	var out []AlertIncident
+	for _, r := range re.rules {
+		for _, st := range r.state {
+			if st.incident.Triggers > 0 {
+				out = append(out, st.incident)
+			}
+		}
+	}
+	return out
+}
+
+// Acknowledge marks the latest incident of rule on symbol as seen, which
+// stops it escalating; its later firings are still deduplicated. It
+// reports false if the rule has not fired on the symbol.
+func (re *RuleEngine) Acknowledge(rule, symbol string) (AlertIncident, bool) {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	for _, r := range re.rules {
+		if r.cfg.Name != rule {
+			continue
+		}
+		if st := r.state[symbol]; st != nil && st.incident.Triggers > 0 {
+			st.incident.Acknowledged = true
+			return st.incident, true
+		}
+	}
+	return AlertIncident{}, false
+}
+
+func exprIdents(e Expr, acc []string) []string {
+	switch x := e.(type) {
+	case identExpr:
+		for _, id := range acc {
+			if id == string(x) {
+				return acc
+			}
+		}
+		return append(acc, string(x))
+	case *unaryExpr:
+		return exprIdents(x.x, acc)
+	case *binaryExpr:
+		return exprIdents(x.r, exprIdents(x.l, acc))
+	case *callExpr:
+		for _, arg := range x.args {
+			acc = exprIdents(arg, acc)
+		}
+	case *windowExpr:
+		return exprIdents(x.x, acc)
+	}
+	return acc
+}
+
+func LoadRules(path string) ([]RuleConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []RuleConfig
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+func logAlert(event AlertEvent) {
+	logger("rules").Warn("alert", "rule", event.Rule, "severity", event.Severity, "symbol", event.Symbol, "expr", event.Expr, "values", event.Values)
+}
@@ -0,0 +1,173 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRuleEngineFiresWithValues(t *testing.T) {
+	re := NewRuleEngine()
+	if err := re.AddRule(RuleConfig{Name: "flow", Expr: "ofi_1m > 0.8 && spread_bps < 2", Severity: "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	var handled []AlertEvent
+	re.OnAlert(func(e AlertEvent) { handled = append(handled, e) })
+
+	now := time.Unix(1700000000, 0)
+	fired := re.Evaluate("btcusdt", map[string]float64{"ofi_1m": 0.9, "spread_bps": 1, "rsi_14": 50}, now)
+
+	if len(fired) != 1 || len(handled) != 1 {
+		t.Fatalf("fired %d / handled %d alerts, want 1", len(fired), len(handled))
+	}
+	e := fired[0]
+	if e.Rule != "flow" || e.Symbol != "btcusdt" || e.Severity != "warn" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if len(e.Values) != 2 || e.Values["ofi_1m"] != 0.9 {
+		t.Errorf("event values = %v, want only referenced signals", e.Values)
+	}
+}
+
+func TestRuleEngineDebounce(t *testing.T) {
+	re := NewRuleEngine()
+	re.AddRule(RuleConfig{Name: "r", Expr: "x > 1", Debounce: Duration(2 * time.Second)})
+	now := time.Unix(1700000000, 0)
+	on := map[string]float64{"x": 2}
+	off := map[string]float64{"x": 0}
+
+	if n := len(re.Evaluate("s", on, now)); n != 0 {
+		t.Errorf("fired %d alerts before debounce elapsed", n)
+	}
+	// Condition drops out, restarting the debounce window
+	re.Evaluate("s", off, now.Add(time.Second))
+	if n := len(re.Evaluate("s", on, now.Add(2*time.Second))); n != 0 {
+		t.Errorf("fired %d alerts after debounce reset", n)
+	}
+	if n := len(re.Evaluate("s", on, now.Add(4*time.Second))); n != 1 {
+		t.Errorf("fired %d alerts after debounce elapsed, want 1", n)
+	}
+}
+
+func TestRuleEngineEdgeTriggered(t *testing.T) {
+	re := NewRuleEngine()
+	re.AddRule(RuleConfig{Name: "r", Expr: "x > 1"})
+	now := time.Unix(1700000000, 0)
+	on, off := map[string]float64{"x": 2}, map[string]float64{"x": 0}
+
+	var got []int
+	for i, values := range []map[string]float64{on, on, on, off, off, on, on} {
+		got = append(got, len(re.Evaluate("s", values, now.Add(time.Duration(i)*time.Second))))
+	}
+	// Fires when the condition becomes true, and again only after it clears
+	if want := []int{1, 0, 0, 0, 0, 1, 0}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("firings per update = %v, want %v", got, want)
+	}
+}
+
+func TestRuleEngineCooldown(t *testing.T) {
+	re := NewRuleEngine()
+	re.AddRule(RuleConfig{Name: "r", Expr: "x > 1", Cooldown: Duration(time.Minute)})
+	now := time.Unix(1700000000, 0)
+	on, off := map[string]float64{"x": 2}, map[string]float64{"x": 0}
+
+	total := 0
+	total += len(re.Evaluate("s", on, now))
+	re.Evaluate("s", off, now.Add(20*time.Second))
+	total += len(re.Evaluate("s", on, now.Add(30*time.Second)))
+	total += len(re.Evaluate("other", on, now.Add(30*time.Second)))
+	// The breach that began within the cooldown fires once it elapses
+	total += len(re.Evaluate("s", on, now.Add(61*time.Second)))
+	total += len(re.Evaluate("s", on, now.Add(90*time.Second)))
+	if total != 3 {
+		t.Errorf("fired %d alerts, want 3 (cooldown is per rule and symbol)", total)
+	}
+}
+
+func TestRuleEngineErrorsPerSymbol(t *testing.T) {
+	re := NewRuleEngine()
+	re.AddRule(RuleConfig{Name: "r", Expr: "x > 1"})
+	now := time.Unix(1700000000, 0)
+
+	re.Evaluate("a", map[string]float64{}, now)
+	re.Evaluate("b", map[string]float64{"x": 2}, now)
+	st := re.rules[0].state
+	if st["a"].lastError == "" || st["b"].lastError != "" {
+		t.Errorf("errors a=%q b=%q, want only a's recorded", st["a"].lastError, st["b"].lastError)
+	}
+}
+
+func TestRuleEngineDedupEscalation(t *testing.T) {
+	re := NewRuleEngine()
+	err := re.AddRule(RuleConfig{Name: "r", Expr: "x > 1", Severity: "warn", Dedup: Duration(time.Minute),
+		EscalateAfter: 3, EscalateSinks: []string{"pager"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	on, off := map[string]float64{"x": 2}, map[string]float64{"x": 0}
+	flap := func(at time.Duration) []AlertEvent {
+		re.Evaluate("s", off, now.Add(at-time.Millisecond))
+		return re.Evaluate("s", on, now.Add(at))
+	}
+
+	if fired := flap(0); len(fired) != 1 || fired[0].Triggers != 1 || fired[0].Escalated {
+		t.Fatalf("first firing = %+v", fired)
+	}
+	if fired := flap(30 * time.Second); len(fired) != 0 {
+		t.Errorf("a repeat within the dedup window was sent: %+v", fired)
+	}
+	fired := flap(80 * time.Second)
+	if len(fired) != 1 || !fired[0].Escalated || fired[0].Severity != "critical" || fired[0].Triggers != 3 || fired[0].Sinks[0] != "pager" {
+		t.Fatalf("third firing = %+v, want the escalation", fired)
+	}
+	if fired := flap(90 * time.Second); len(fired) != 0 {
+		t.Errorf("escalated twice: %+v", fired)
+	}
+	incidents := re.Incidents()
+	if len(incidents) != 1 || incidents[0].Triggers != 4 || incidents[0].Severity != "critical" {
+		t.Errorf("incidents = %+v", incidents)
+	}
+
+	// Over a minute later it is a new incident, which acknowledging keeps
+	// from escalating
+	if fired := flap(200 * time.Second); len(fired) != 1 || fired[0].Triggers != 1 {
+		t.Fatalf("new incident = %+v", fired)
+	}
+	if inc, ok := re.Acknowledge("r", "s"); !ok || !inc.Acknowledged {
+		t.Errorf("acknowledge = %+v, %v", inc, ok)
+	}
+	for _, at := range []time.Duration{210, 220, 230} {
+		if fired := flap(at * time.Second); len(fired) != 0 {
+			t.Errorf("acknowledged incident escalated: %+v", fired)
+		}
+	}
+	if _, ok := re.Acknowledge("r", "other"); ok {
+		t.Error("acknowledged a rule that never fired on the symbol")
+	}
+	if err := re.AddRule(RuleConfig{Name: "e", Expr: "x > 1", EscalateAfter: 2}); err == nil {
+		t.Error("escalate_after without a dedup window was accepted")
+	}
+}
+
+func TestRuleEngineRejectsInvalidRules(t *testing.T) {
+	re := NewRuleEngine()
+	if err := re.AddRule(RuleConfig{Name: "bad", Expr: "x >"}); err == nil {
+		t.Error("AddRule with invalid expression should fail")
+	}
+	if err := re.AddRule(RuleConfig{Expr: "x > 1"}); err == nil {
+		t.Error("AddRule without name should fail")
+	}
+}
+
+func TestRuleConfigJSON(t *testing.T) {
+	var cfgs []RuleConfig
+	data := `[{"name":"wide","expr":"spread_bps > 5","cooldown":"30s","debounce":"500ms"}]`
+	if err := json.Unmarshal([]byte(data), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(cfgs[0].Cooldown) != 30*time.Second || time.Duration(cfgs[0].Debounce) != 500*time.Millisecond {
+		t.Errorf("parsed durations = %v / %v", cfgs[0].Cooldown, cfgs[0].Debounce)
+	}
+}
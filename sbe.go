@@ -0,0 +1,300 @@
+package apexlob
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// Simple Binary Encoding of bus events, laid out as described by
+// schema/sbe.xml so consumers can generate decoders with the real SBE
+// tool chain. Every message is a fixed-size block (timestamp, symbol and the
+// event's scalar fields) followed by repeating groups; all integers and
+// floats are little-endian. Timestamps are nanoseconds since the Unix epoch,
+// 0 meaning unset; symbols are NUL-padded char[16].
+
+const (
+	sbeSchemaID      = 1
+	sbeSchemaVersion = 1
+	sbeHeaderSize    = 8
+	sbeSymbolSize    = 16
+
+	sbeTemplateTrade     = 1
+	sbeTemplateBook      = 2
+	sbeTemplateCandle    = 3
+	sbeTemplateSignals   = 4
+	sbeTemplateExecution = 5
+
+	// Simple Open Framing Header encoding type for little-endian SBE.
+	sofhEncodingSBE = 0x5be0
+	sofhSize        = 6
+)
+
+var errSBEShort = errors.New("sbe: message truncated")
+
+var sbeBlockLengths = map[uint16]uint16{
+	sbeTemplateTrade:     49,
+	sbeTemplateBook:      44,
+	sbeTemplateCandle:    84,
+	sbeTemplateSignals:   24,
+	sbeTemplateExecution: 53,
+}
+
+type sbeEncoder struct{ b []byte }
+
+func (e *sbeEncoder) u8(v uint8)    { e.b = append(e.b, v) }
+func (e *sbeEncoder) u16(v uint16)  { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *sbeEncoder) u32(v uint32)  { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *sbeEncoder) u64(v uint64)  { e.b = binary.LittleEndian.AppendUint64(e.b, v) }
+func (e *sbeEncoder) f64(v float64) { e.u64(math.Float64bits(v)) }
+
+func (e *sbeEncoder) time(t time.Time) {
+	if t.IsZero() {
+		e.u64(0)
+		return
+	}
+	e.u64(uint64(t.UnixNano()))
+}
+
+func (e *sbeEncoder) symbol(s string) {
+	var buf [sbeSymbolSize]byte
+	copy(buf[:], s)
+	e.b = append(e.b, buf[:]...)
+}
+
+func (e *sbeEncoder) group(blockLength uint16, n int) {
+	e.u16(blockLength)
+	e.u16(uint16(n))
+}
+
+// AppendSBE appends the SBE encoding of e to dst.
+func AppendSBE(dst []byte, e *Event) ([]byte, error) {
+	var template uint16
+	switch {
+	case e.Type == EventTrade && e.Trade != nil:
+		template = sbeTemplateTrade
+	case e.Type == EventBook && e.Book != nil:
+		template = sbeTemplateBook
+	case e.Type == EventCandle && e.Candle != nil:
+		template = sbeTemplateCandle
+	case e.Type == EventSignal:
+		template = sbeTemplateSignals
+	case e.Type == EventExecution && e.Execution != nil:
+		template = sbeTemplateExecution
+	default:
+		return dst, fmt.Errorf("sbe: cannot encode %s event", e.Type)
+	}
+	enc := sbeEncoder{b: dst}
+	enc.u16(sbeBlockLengths[template])
+	enc.u16(template)
+	enc.u16(sbeSchemaID)
+	enc.u16(sbeSchemaVersion)
+	enc.time(e.Timestamp)
+	enc.symbol(e.Symbol)
+
+	switch template {
+	case sbeTemplateTrade:
+		tr := e.Trade
+		enc.u64(tr.ID)
+		enc.f64(tr.Price)
+		enc.f64(tr.Quantity)
+		enc.u8(uint8(tr.Side))
+	case sbeTemplateBook:
+		b := e.Book
+		enc.f64(b.LastTradePrice)
+		enc.f64(b.VWAP)
+		enc.u32(b.TotalVolume)
+		for _, levels := range [][]orderbook.PriceLevel{b.Bids, b.Asks} {
+			enc.group(16, len(levels))
+			for _, lvl := range levels {
+				enc.f64(lvl.Price)
+				enc.u32(lvl.Volume)
+				enc.u32(uint32(lvl.Orders))
+			}
+		}
+	case sbeTemplateCandle:
+		c := e.Candle
+		enc.time(c.OpenTime)
+		enc.u64(uint64(c.Interval))
+		enc.f64(c.Open)
+		enc.f64(c.High)
+		enc.f64(c.Low)
+		enc.f64(c.Close)
+		enc.f64(c.Volume)
+		enc.u32(uint32(c.Trades))
+	case sbeTemplateSignals:
+		names := make([]string, 0, len(e.Signals))
+		for name := range e.Signals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		enc.group(8, len(names))
+		for _, name := range names {
+			enc.f64(e.Signals[name])
+			if len(name) > 255 {
+				name = name[:255]
+			}
+			enc.u8(uint8(len(name)))
+			enc.b = append(enc.b, name...)
+		}
+	case sbeTemplateExecution:
+		ex := e.Execution
+		enc.u64(ex.TakerID)
+		enc.u64(ex.MakerID)
+		enc.f64(ex.Price)
+		enc.u32(ex.Quantity)
+		enc.u8(uint8(ex.Side))
+	}
+	return enc.b, nil
+}
+
+// AppendSOFH appends a Simple Open Framing Header for a message of n bytes,
+// which lets SBE messages be concatenated in files and byte streams.
+func AppendSOFH(dst []byte, n int) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(n+sofhSize))
+	return binary.BigEndian.AppendUint16(dst, sofhEncodingSBE)
+}
+
+// ReadSBEFrame reads one SOFH-framed message from r, as written by the sbe
+// export format. It returns io.EOF at a clean end of stream.
+func ReadSBEFrame(r io.Reader) (Event, error) {
+	var hdr [sofhSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Event{}, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if enc := binary.BigEndian.Uint16(hdr[4:]); enc != sofhEncodingSBE || n < sofhSize+sbeHeaderSize {
+		return Event{}, fmt.Errorf("sbe: bad frame header %x", hdr)
+	}
+	msg := make([]byte, n-sofhSize)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return Event{}, io.ErrUnexpectedEOF
+	}
+	e, _, err := DecodeSBE(msg)
+	return e, err
+}
+
+type sbeDecoder struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (d *sbeDecoder) take(n int) []byte {
+	if d.err != nil || d.off+n > len(d.b) {
+		d.err = errSBEShort
+		return make([]byte, n)
+	}
+	p := d.b[d.off : d.off+n]
+	d.off += n
+	return p
+}
+
+func (d *sbeDecoder) u8() uint8    { return d.take(1)[0] }
+func (d *sbeDecoder) u16() uint16  { return binary.LittleEndian.Uint16(d.take(2)) }
+func (d *sbeDecoder) u32() uint32  { return binary.LittleEndian.Uint32(d.take(4)) }
+func (d *sbeDecoder) u64() uint64  { return binary.LittleEndian.Uint64(d.take(8)) }
+func (d *sbeDecoder) f64() float64 { return math.Float64frombits(d.u64()) }
+
+func (d *sbeDecoder) time() time.Time {
+	if ns := int64(d.u64()); ns != 0 {
+		return time.Unix(0, ns).UTC()
+	}
+	return time.Time{}
+}
+
+func (d *sbeDecoder) symbol() string {
+	raw := d.take(sbeSymbolSize)
+	n := 0
+	for n < len(raw) && raw[n] != 0 {
+		n++
+	}
+	return string(raw[:n])
+}
+
+// DecodeSBE decodes one message from the start of b and returns it with the
+// number of bytes consumed. Fields appended to a block by newer schema
+// versions are skipped using the header's block length.
+func DecodeSBE(b []byte) (Event, int, error) {
+	d := &sbeDecoder{b: b}
+	blockLength := int(d.u16())
+	template := d.u16()
+	if schema := d.u16(); d.err == nil && schema != sbeSchemaID {
+		return Event{}, 0, fmt.Errorf("sbe: unknown schema id %d", schema)
+	}
+	d.u16() // version
+	blockEnd := d.off + blockLength
+	var e Event
+	e.Timestamp = d.time()
+	e.Symbol = d.symbol()
+
+	groups := func(fn func()) {
+		if d.err == nil && blockEnd > d.off {
+			d.take(blockEnd - d.off)
+		}
+		fn()
+	}
+	switch template {
+	case sbeTemplateTrade:
+		e.Type = EventTrade
+		e.Trade = &orderbook.Trade{Symbol: e.Symbol, Timestamp: e.Timestamp, ID: d.u64(), Price: d.f64(), Quantity: d.f64(), Side: orderbook.Side(d.u8())}
+	case sbeTemplateBook:
+		e.Type = EventBook
+		book := &BookSnapshot{Symbol: e.Symbol, Timestamp: e.Timestamp, LastTradePrice: d.f64(), VWAP: d.f64(), TotalVolume: d.u32()}
+		groups(func() {
+			for _, side := range []*[]orderbook.PriceLevel{&book.Bids, &book.Asks} {
+				entryLength, n := int(d.u16()), int(d.u16())
+				for i := 0; i < n && d.err == nil; i++ {
+					start := d.off
+					*side = append(*side, orderbook.PriceLevel{Price: d.f64(), Volume: d.u32(), Orders: int(d.u32())})
+					d.take(start + entryLength - d.off)
+				}
+			}
+		})
+		e.Book = book
+	case sbeTemplateCandle:
+		e.Type = EventCandle
+		e.Candle = &Candle{
+			Symbol:   e.Symbol,
+			OpenTime: d.time(),
+			Interval: time.Duration(d.u64()),
+			Open:     d.f64(),
+			High:     d.f64(),
+			Low:      d.f64(),
+			Close:    d.f64(),
+			Volume:   d.f64(),
+			Trades:   int(d.u32()),
+		}
+	case sbeTemplateSignals:
+		e.Type = EventSignal
+		groups(func() {
+			entryLength, n := int(d.u16()), int(d.u16())
+			e.Signals = make(map[string]float64, n)
+			for i := 0; i < n && d.err == nil; i++ {
+				start := d.off
+				value := d.f64()
+				d.take(start + entryLength - d.off)
+				name := string(d.take(int(d.u8())))
+				e.Signals[name] = value
+			}
+		})
+	case sbeTemplateExecution:
+		e.Type = EventExecution
+		e.Execution = &orderbook.Execution{Symbol: e.Symbol, Timestamp: e.Timestamp, TakerID: d.u64(), MakerID: d.u64(), Price: d.f64(), Quantity: d.u32(), Side: orderbook.Side(d.u8())}
+	default:
+		return Event{}, 0, fmt.Errorf("sbe: unknown template id %d", template)
+	}
+	if e.Type != EventBook && e.Type != EventSignal && d.err == nil && blockEnd > d.off {
+		d.take(blockEnd - d.off)
+	}
+	if d.err != nil {
+		return Event{}, 0, d.err
+	}
+	return e, d.off, nil
+}
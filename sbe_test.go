@@ -0,0 +1,144 @@
+package apexlob
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func sbeTestEvents() []Event {
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	return []Event{
+		{Type: EventTrade, Symbol: "btcusdt", Timestamp: ts, Trade: &orderbook.Trade{Symbol: "btcusdt", ID: 42, Price: 64000.5, Quantity: 0.25, Side: orderbook.Sell, Timestamp: ts}},
+		{Type: EventBook, Symbol: "ethusdt", Timestamp: ts, Book: &BookSnapshot{
+			Symbol: "ethusdt", Timestamp: ts, LastTradePrice: 3000, VWAP: 2999.5, TotalVolume: 17,
+			Bids: []orderbook.PriceLevel{{Price: 2999, Volume: 5, Orders: 2}, {Price: 2998, Volume: 1, Orders: 1}},
+			Asks: []orderbook.PriceLevel{{Price: 3001, Volume: 3, Orders: 1}},
+		}},
+		{Type: EventCandle, Symbol: "btcusdt", Timestamp: ts, Candle: &Candle{
+			Symbol: "btcusdt", OpenTime: ts.Truncate(time.Minute), Interval: time.Minute,
+			Open: 1, High: 3, Low: 0.5, Close: 2, Volume: 10, Trades: 7,
+		}},
+		{Type: EventSignal, Symbol: "btcusdt", Timestamp: ts, Signals: map[string]float64{"rsi_14": 55.5, "ofi": -3}},
+		{Type: EventExecution, Symbol: "btcusdt", Timestamp: ts, Execution: &orderbook.Execution{Symbol: "btcusdt", TakerID: 9, MakerID: 4, Side: orderbook.Buy, Price: 100, Quantity: 3, Timestamp: ts}},
+	}
+}
+
+func TestSBERoundTrip(t *testing.T) {
+	for _, want := range sbeTestEvents() {
+		b, err := AppendSBE(nil, &want)
+		if err != nil {
+			t.Fatalf("%s: %v", want.Type, err)
+		}
+		if bl := binary.LittleEndian.Uint16(b); int(bl) != sbeBlockLength(t, b) {
+			t.Errorf("%s: header block length %d does not match encoded block", want.Type, bl)
+		}
+		got, n, err := DecodeSBE(b)
+		if err != nil {
+			t.Fatalf("%s: %v", want.Type, err)
+		}
+		if n != len(b) {
+			t.Errorf("%s: consumed %d of %d bytes", want.Type, n, len(b))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: decoded %+v, want %+v", want.Type, got, want)
+		}
+		if _, _, err := DecodeSBE(b[:len(b)-1]); !errors.Is(err, errSBEShort) {
+			t.Errorf("%s: truncated message error = %v", want.Type, err)
+		}
+	}
+}
+
+// sbeBlockLength measures the fixed block of an encoded message by
+// re-encoding it with its groups emptied.
+func sbeBlockLength(t *testing.T, b []byte) int {
+	t.Helper()
+	e, _, err := DecodeSBE(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch e.Type {
+	case EventBook:
+		e.Book.Bids, e.Book.Asks = nil, nil
+		out, _ := AppendSBE(nil, &e)
+		return len(out) - sbeHeaderSize - 8
+	case EventSignal:
+		e.Signals = nil
+		out, _ := AppendSBE(nil, &e)
+		return len(out) - sbeHeaderSize - 4
+	}
+	return len(b) - sbeHeaderSize
+}
+
+func TestSBEDecodeSkipsNewerFields(t *testing.T) {
+	e := sbeTestEvents()[0]
+	b, _ := AppendSBE(nil, &e)
+	// A version 2 producer appended a field to the trade block
+	binary.LittleEndian.PutUint16(b, binary.LittleEndian.Uint16(b)+8)
+	binary.LittleEndian.PutUint16(b[6:], 2)
+	b = append(b, 1, 2, 3, 4, 5, 6, 7, 8)
+	got, n, err := DecodeSBE(b)
+	if err != nil || n != len(b) || got.Trade.ID != 42 {
+		t.Errorf("decoded %+v, %d bytes, err %v", got.Trade, n, err)
+	}
+
+	binary.LittleEndian.PutUint16(b[2:], 99)
+	if _, _, err := DecodeSBE(b); err == nil {
+		t.Error("expected error for unknown template")
+	}
+	if _, err := AppendSBE(nil, &Event{Type: EventTrade}); err == nil {
+		t.Error("expected error for trade event without a trade")
+	}
+}
+
+func TestReadSBEFrame(t *testing.T) {
+	var buf []byte
+	events := sbeTestEvents()
+	for i := range events {
+		msg, _ := AppendSBE(nil, &events[i])
+		buf = append(AppendSOFH(buf, len(msg)), msg...)
+	}
+	r := bytes.NewReader(buf)
+	for i := range events {
+		e, err := ReadSBEFrame(r)
+		if err != nil || e.Type != events[i].Type {
+			t.Fatalf("frame %d: %v %v", i, e.Type, err)
+		}
+	}
+	if _, err := ReadSBEFrame(r); err != io.EOF {
+		t.Errorf("end of stream error = %v, want io.EOF", err)
+	}
+	if _, err := ReadSBEFrame(bytes.NewReader([]byte{0, 0, 0, 20, 0x5b, 0xe0, 1})); err != io.ErrUnexpectedEOF {
+		t.Errorf("short frame error = %v", err)
+	}
+}
+
+func BenchmarkEncodeBook(b *testing.B) {
+	e := sbeTestEvents()[1]
+	for i := 0; i < 20; i++ {
+		e.Book.Bids = append(e.Book.Bids, orderbook.PriceLevel{Price: 2990 - float64(i), Volume: 4, Orders: 2})
+		e.Book.Asks = append(e.Book.Asks, orderbook.PriceLevel{Price: 3010 + float64(i), Volume: 4, Orders: 2})
+	}
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, _ := json.Marshal(&e)
+			b.SetBytes(int64(len(out)))
+		}
+	})
+	b.Run("sbe", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			buf, _ = AppendSBE(buf[:0], &e)
+			b.SetBytes(int64(len(buf)))
+		}
+	})
+}
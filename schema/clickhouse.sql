@@ -0,0 +1,35 @@
+-- ClickHouse tables written by the -clickhouse-url sink. Created on startup
+-- when -clickhouse-create is set; otherwise apply this file by hand:
+--
+--   clickhouse-client --database apexlob --multiquery < schema/clickhouse.sql
+--
+-- Both tables are partitioned by day so old captures can be dropped with
+-- ALTER TABLE ... DROP PARTITION.
+
+CREATE TABLE IF NOT EXISTS trades
+(
+    timestamp DateTime64(6, 'UTC'),
+    symbol    LowCardinality(String),
+    id        UInt64,
+    side      Enum8('BUY' = 1, 'SELL' = 2),
+    price     Float64,
+    quantity  Float64
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (symbol, timestamp, id);
+
+-- One row per price level per sampled snapshot; level 0 is the best price.
+CREATE TABLE IF NOT EXISTS book_snapshots
+(
+    timestamp DateTime64(6, 'UTC'),
+    symbol    LowCardinality(String),
+    side      Enum8('BID' = 1, 'ASK' = 2),
+    level     UInt16,
+    price     Float64,
+    volume    UInt32,
+    orders    UInt32
+)
+ENGINE = MergeTree
+PARTITION BY toYYYYMMDD(timestamp)
+ORDER BY (symbol, timestamp, side, level);
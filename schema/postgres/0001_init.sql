@@ -0,0 +1,36 @@
+-- Capture tables for the -postgres-dsn store. Timestamps are stored as
+-- timestamptz; prices as double precision to match the feed.
+
+CREATE TABLE IF NOT EXISTS trades (
+    ts        timestamptz      NOT NULL,
+    symbol    text             NOT NULL,
+    id        bigint           NOT NULL,
+    side      text             NOT NULL,
+    price     double precision NOT NULL,
+    quantity  double precision NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trades_symbol_ts ON trades (symbol, ts DESC);
+
+-- Fills produced by the local matching engine (scaled integer quantity).
+CREATE TABLE IF NOT EXISTS executions (
+    ts        timestamptz      NOT NULL,
+    symbol    text             NOT NULL,
+    taker_id  bigint           NOT NULL,
+    maker_id  bigint           NOT NULL,
+    side      text             NOT NULL,
+    price     double precision NOT NULL,
+    quantity  bigint           NOT NULL
+);
+CREATE INDEX IF NOT EXISTS executions_symbol_ts ON executions (symbol, ts DESC);
+
+-- One row per price level per sampled snapshot; level 0 is the best price.
+CREATE TABLE IF NOT EXISTS book_snapshots (
+    ts        timestamptz      NOT NULL,
+    symbol    text             NOT NULL,
+    side      text             NOT NULL,
+    level     smallint         NOT NULL,
+    price     double precision NOT NULL,
+    volume    bigint           NOT NULL,
+    orders    integer          NOT NULL
+);
+CREATE INDEX IF NOT EXISTS book_snapshots_symbol_ts ON book_snapshots (symbol, ts DESC);
@@ -0,0 +1,14 @@
+-- Closed candles, kept so a restart can resume the candle history and only
+-- backfill the bars it missed.
+CREATE TABLE IF NOT EXISTS candles (
+    open_time        timestamptz      NOT NULL,
+    symbol           text             NOT NULL,
+    interval_seconds integer          NOT NULL,
+    open             double precision NOT NULL,
+    high             double precision NOT NULL,
+    low              double precision NOT NULL,
+    close            double precision NOT NULL,
+    volume           double precision NOT NULL,
+    trades           integer          NOT NULL,
+    PRIMARY KEY (symbol, interval_seconds, open_time)
+);
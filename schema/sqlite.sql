@@ -0,0 +1,37 @@
+-- Tables for -store sqlite://path.db. Timestamps are fixed-width UTC
+-- ISO-8601 text, so they sort correctly and work with SQLite's date
+-- functions.
+
+CREATE TABLE IF NOT EXISTS trades (
+    ts       TEXT    NOT NULL,
+    symbol   TEXT    NOT NULL,
+    id       INTEGER NOT NULL,
+    side     TEXT    NOT NULL,
+    price    REAL    NOT NULL,
+    quantity REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS trades_symbol_ts ON trades (symbol, ts);
+
+CREATE TABLE IF NOT EXISTS candles (
+    open_time        TEXT    NOT NULL,
+    symbol           TEXT    NOT NULL,
+    interval_seconds INTEGER NOT NULL,
+    open             REAL    NOT NULL,
+    high             REAL    NOT NULL,
+    low              REAL    NOT NULL,
+    close            REAL    NOT NULL,
+    volume           REAL    NOT NULL,
+    trades           INTEGER NOT NULL,
+    PRIMARY KEY (symbol, interval_seconds, open_time)
+);
+
+-- One row per monitor run, updated as the session progresses.
+CREATE TABLE IF NOT EXISTS sessions (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at          TEXT    NOT NULL,
+    updated_at          TEXT    NOT NULL,
+    symbols             TEXT    NOT NULL,
+    messages            INTEGER NOT NULL DEFAULT 0,
+    messages_per_second REAL    NOT NULL DEFAULT 0,
+    avg_processing_ms   REAL    NOT NULL DEFAULT 0
+);
@@ -0,0 +1,149 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// SessionState is the part of every symbol's state that accumulates over a
+// trading session: the VWAP numerator and volume behind the trade totals
+// and the candle in progress. Saving it lets a restart within the same
+// session carry on instead of starting every aggregate from zero.
+type SessionState struct {
+	SessionStart time.Time                `json:"session_start"`
+	SavedAt      time.Time                `json:"saved_at"`
+	Symbols      map[string]SymbolSession `json:"symbols"`
+}
+
+type SymbolSession struct {
+	Totals orderbook.TradeTotals `json:"totals"`
+	Candle *Candle               `json:"candle,omitempty"`
+}
+
+// SessionStart is the start of the session containing t, with sessions of
+// length aligned to the Unix epoch, i.e. to UTC midnight for a day.
+func SessionStart(t time.Time, length time.Duration) time.Time {
+	return t.UTC().Truncate(length)
+}
+
+func CaptureSession(symbols *SymbolRegistry, sessionStart time.Time) SessionState {
+	s := SessionState{SessionStart: sessionStart, SavedAt: time.Now().UTC(), Symbols: make(map[string]SymbolSession)}
+	for _, sym := range symbols.List() {
+		state, _ := symbols.Get(sym)
+		ss := SymbolSession{Totals: state.Book.TradeTotals()}
+		if c, ok := state.Candles.Current(); ok {
+			ss.Candle = &c
+		}
+		s.Symbols[sym] = ss
+	}
+	return s
+}
+
+// Restore applies the saved aggregates to the symbols both have in common
+// and returns those symbols. It must run before any trade is processed.
+func (s SessionState) Restore(symbols *SymbolRegistry) []string {
+	var restored []string
+	for _, sym := range sortedKeys(s.Symbols) {
+		state, ok := symbols.Get(sym)
+		if !ok {
+			continue
+		}
+		saved := s.Symbols[sym]
+		state.Book.RestoreTotals(saved.Totals)
+		if saved.Candle != nil {
+			state.Candles.Restore(*saved.Candle)
+		}
+		restored = append(restored, sym)
+	}
+	return restored
+}
+
+// SaveSession writes s to path through a temporary file and a rename, so a
+// crash mid-write leaves the previous save intact.
+func SaveSession(path string, s SessionState) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("saving session to %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadSession reads a saved session; a missing file is not an error and
+// returns ok false.
+func LoadSession(path string) (s SessionState, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, false, nil
+	}
+	if err != nil {
+		return s, false, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, false, fmt.Errorf("reading session %s: %w", path, err)
+	}
+	return s, true, nil
+}
+
+// PersistSession resumes the current session from path if it was saved
+// during it, then saves the session there every interval and once more on
+// Close, after the workers have drained.
+func (m *Monitor) PersistSession(path string, interval, length time.Duration) error {
+	sessionLog := logger("session")
+	start := SessionStart(time.Now(), length)
+	saved, ok, err := LoadSession(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !ok:
+		sessionLog.Info("starting a new session", "file", path, "session_start", start)
+	case !saved.SessionStart.Equal(start):
+		sessionLog.Info("saved session has ended, starting a new one", "file", path, "saved_session_start", saved.SessionStart, "session_start", start)
+	default:
+		restored := saved.Restore(m.Symbols)
+		sessionLog.Info("resumed session", "file", path, "session_start", start, "saved_at", saved.SavedAt, "symbols", restored)
+	}
+
+	save := func() {
+		if err := SaveSession(path, CaptureSession(m.Symbols, start)); err != nil {
+			sessionLog.Error("failed to save session", "err", err)
+		}
+	}
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					save()
+				case <-m.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	m.onClose(save)
+	return nil
+}
@@ -0,0 +1,94 @@
+package apexlob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestSessionResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	capture := writeCapture(t, []string{"btcusdt"}, 200)
+	run := func() orderbook.TradeTotals {
+		m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.PersistSession(path, time.Hour, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		done := m.Run()
+		if err := ReplayCapture(context.Background(), m, capture, 0); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+		m.Close()
+		state, _ := m.Symbols.Get("btcusdt")
+		return state.Book.TradeTotals()
+	}
+
+	first := run()
+	if first.Volume == 0 {
+		t.Fatal("no volume traded")
+	}
+	saved, ok, err := LoadSession(path)
+	if err != nil || !ok {
+		t.Fatalf("session not saved on close: %v", err)
+	}
+	if saved.Symbols["btcusdt"].Totals != first || saved.Symbols["btcusdt"].Candle == nil {
+		t.Errorf("saved %+v, want totals %+v and a candle", saved.Symbols["btcusdt"], first)
+	}
+
+	// The restart carries on from the saved totals
+	second := run()
+	if second.Volume != 2*first.Volume || second.Notional < 1.99*first.Notional {
+		t.Errorf("resumed totals %+v after %+v", second, first)
+	}
+
+	// A session saved before today's started is not resumed
+	saved.SessionStart = saved.SessionStart.Add(-24 * time.Hour)
+	if err := SaveSession(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	if fresh := run(); fresh.Volume != first.Volume {
+		t.Errorf("stale session resumed: volume %d, want %d", fresh.Volume, first.Volume)
+	}
+}
+
+func TestSaveSessionIsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "session.json")
+	if _, ok, err := LoadSession(path); ok || err != nil {
+		t.Fatalf("missing session: ok %v, err %v", ok, err)
+	}
+	s := SessionState{SessionStart: SessionStart(time.Now(), time.Hour), Symbols: map[string]SymbolSession{"btcusdt": {Totals: orderbook.TradeTotals{LastPrice: 1, Volume: 2, Notional: 3}}}}
+	if err := SaveSession(path, s); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+	loaded, ok, err := LoadSession(path)
+	if err != nil || !ok || loaded.Symbols["btcusdt"].Totals.Notional != 3 {
+		t.Errorf("loaded %+v, %v", loaded, err)
+	}
+
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, _, err := LoadSession(path); err == nil {
+		t.Error("corrupt session accepted")
+	}
+}
+
+func TestSessionStart(t *testing.T) {
+	at := time.Date(2024, 3, 5, 17, 42, 0, 0, time.FixedZone("EST", -5*3600))
+	if got := SessionStart(at, 24*time.Hour); !got.Equal(time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("daily session start = %v", got)
+	}
+	if got := SessionStart(at, 8*time.Hour); !got.Equal(time.Date(2024, 3, 5, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("8h session start = %v", got)
+	}
+}
@@ -0,0 +1,168 @@
+package apexlob
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"apexlob/pkg/feed"
+)
+
+// ShardSet spreads symbols over worker goroutines. Every symbol is owned by
+// exactly one worker, chosen by hashing its name, so books and signal state
+// are never shared between goroutines and throughput grows with the number
+// of cores. The feed reader is the single producer of every worker's ring.
+type ShardSet struct {
+	rings      []*feed.Ring
+	route      map[string]shardRoute
+	tuning     WorkerTuning
+	supervisor *Supervisor
+	historical bool // see SetHistorical
+}
+
+type shardRoute struct {
+	shard  int
+	symbol string // canonical (lower-case) name
+}
+
+// NewShardSet assigns symbols to at most workers shards, each with a ring of
+// queue messages. With a single symbol, messages that carry no symbol are
+// routed to it.
+func NewShardSet(symbols []string, workers, queue int) *ShardSet {
+	if workers > len(symbols) {
+		workers = len(symbols)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	s := &ShardSet{route: make(map[string]shardRoute, 2*len(symbols))}
+	for i := 0; i < workers; i++ {
+		s.rings = append(s.rings, feed.NewRing(queue))
+	}
+	for _, sym := range symbols {
+		sym = strings.ToLower(sym)
+		r := shardRoute{shard: ShardIndex(sym, workers), symbol: sym}
+		// Binance sends upper-case symbols in payloads
+		s.route[sym] = r
+		s.route[strings.ToUpper(sym)] = r
+	}
+	if len(symbols) == 1 {
+		s.route[""] = s.route[strings.ToLower(symbols[0])]
+	}
+	return s
+}
+
+// ShardIndex maps a symbol onto one of n shards. The mapping depends only on
+// the name and n, so a symbol keeps its worker across restarts.
+func ShardIndex(symbol string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(symbol)))
+	return int(h.Sum32() % uint32(n))
+}
+
+func (s *ShardSet) Workers() int { return len(s.rings) }
+
+// Shard returns the worker owning symbol, or -1 if it is not configured.
+func (s *ShardSet) Shard(symbol string) int {
+	if r, ok := s.route[symbol]; ok {
+		return r.shard
+	}
+	return -1
+}
+
+// Canonical returns the configured (lower-case) name of symbol as it
+// appears in feed messages.
+func (s *ShardSet) Canonical(symbol []byte) (string, bool) {
+	r, ok := s.route[string(symbol)]
+	return r.symbol, ok
+}
+
+// Push routes m to the worker owning symbol, setting m.Symbol to the
+// canonical name. It reports false for symbols that are not configured or
+// once the set is closed. Only one goroutine may push.
+func (s *ShardSet) Push(symbol []byte, m *feed.Msg) bool {
+	r, ok := s.route[string(symbol)]
+	if !ok {
+		return false
+	}
+	m.Symbol = r.symbol
+	m.Historical = s.historical
+	return s.rings[r.shard].Push(m)
+}
+
+// SetHistorical marks the messages pushed from now on as history replayed
+// ahead of the live feed, or not. Only the goroutine that pushes may call
+// it.
+func (s *ShardSet) SetHistorical(historical bool) { s.historical = historical }
+
+// Tune sets how the workers started by Run wait and where they run.
+func (s *ShardSet) Tune(t WorkerTuning) {
+	s.tuning = t
+	for _, ring := range s.rings {
+		ring.SetBusyPoll(t.BusyPoll)
+	}
+}
+
+// Supervise has the workers started by Run restarted by sup if they panic.
+// The batch being processed when a worker panics is lost.
+func (s *ShardSet) Supervise(sup *Supervisor) { s.supervisor = sup }
+
+// Run starts one goroutine per shard calling process with the shard index
+// and every batch of messages drained from its ring in one wakeup. The batch
+// is reused once process returns. The returned channel is closed once Close
+// has been called and every worker has drained its ring.
+func (s *ShardSet) Run(process func(shard int, batch []feed.Msg)) <-chan struct{} {
+	var wg sync.WaitGroup
+	for i, ring := range s.rings {
+		wg.Add(1)
+		go func(shard int, ring *feed.Ring) {
+			defer wg.Done()
+			if s.tuning.LockThread || len(s.tuning.CPUs) > 0 {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+			if cpus := s.tuning.CPUs; len(cpus) > 0 {
+				if err := pinThread(cpus[shard%len(cpus)]); err != nil {
+					logger("shard").Warn("failed to pin worker", "shard", shard, "cpu", cpus[shard%len(cpus)], "err", err)
+				}
+			}
+			batch := make([]feed.Msg, ring.Cap())
+			s.supervisor.Run(context.Background(), "shard-"+strconv.Itoa(shard), func() {
+				for {
+					n := ring.PopBatch(batch)
+					if n == 0 {
+						return
+					}
+					process(shard, batch[:n])
+				}
+			})
+		}(i, ring)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// QueueDepths reports the number of queued messages per shard.
+func (s *ShardSet) QueueDepths() []int {
+	depths := make([]int, len(s.rings))
+	for i, ring := range s.rings {
+		depths[i] = ring.Len()
+	}
+	return depths
+}
+
+// QueueCapacity is the size of every shard's queue.
+func (s *ShardSet) QueueCapacity() int { return s.rings[0].Cap() }
+
+func (s *ShardSet) Close() {
+	for _, ring := range s.rings {
+		ring.Close()
+	}
+}
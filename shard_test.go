@@ -0,0 +1,126 @@
+package apexlob
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+)
+
+func TestShardSetRouting(t *testing.T) {
+	syms := []string{"btcusdt", "ethusdt", "solusdt", "bnbusdt", "xrpusdt"}
+	s := NewShardSet(syms, 3, 16)
+	if s.Workers() != 3 {
+		t.Fatalf("Workers = %d, want 3", s.Workers())
+	}
+	for _, sym := range syms {
+		want := ShardIndex(sym, 3)
+		if got := s.Shard(sym); got != want {
+			t.Errorf("Shard(%s) = %d, want %d", sym, got, want)
+		}
+	}
+
+	if ShardIndex("BTCUSDT", 3) != ShardIndex("btcusdt", 3) {
+		t.Error("ShardIndex depends on case")
+	}
+
+	var m feed.Msg
+	if !s.Push([]byte("ETHUSDT"), &m) || m.Symbol != "ethusdt" {
+		t.Errorf("upper-case symbol routed to %q", m.Symbol)
+	}
+	if s.Push([]byte("dogeusdt"), &m) || s.Push(nil, &m) {
+		t.Error("unknown or missing symbol was routed")
+	}
+
+	// Fewer symbols than workers: one worker per symbol; a lone symbol
+	// also takes messages that don't name one
+	single := NewShardSet([]string{"btcusdt"}, 8, 16)
+	if single.Workers() != 1 || !single.Push(nil, &m) || m.Symbol != "btcusdt" {
+		t.Errorf("single-symbol set: %d workers, routed to %q", single.Workers(), m.Symbol)
+	}
+}
+
+func TestShardSetRunOwnsSymbols(t *testing.T) {
+	for _, tuning := range []WorkerTuning{
+		{},
+		{LockThread: true, BusyPoll: 100 * time.Microsecond},
+	} {
+		testShardSetRunOwnsSymbols(t, tuning)
+	}
+}
+
+func testShardSetRunOwnsSymbols(t *testing.T, tuning WorkerTuning) {
+	syms := make([]string, 12)
+	for i := range syms {
+		syms[i] = fmt.Sprintf("sym%dusdt", i)
+	}
+	s := NewShardSet(syms, 4, 8)
+	s.Tune(tuning)
+
+	// Unsynchronized per-symbol state: the race detector flags any symbol
+	// that is processed on more than one goroutine.
+	last := make(map[string]*uint64, len(syms))
+	for _, sym := range syms {
+		last[sym] = new(uint64)
+	}
+	outOfOrder := make(chan string, len(syms))
+	done := s.Run(func(shard int, batch []feed.Msg) {
+		for _, m := range batch {
+			if shard != s.Shard(m.Symbol) {
+				outOfOrder <- m.Symbol
+			}
+			if p := last[m.Symbol]; m.TradeID != *p+1 {
+				outOfOrder <- m.Symbol
+			} else {
+				*p = m.TradeID
+			}
+		}
+	})
+
+	const perSymbol = 500
+	for id := uint64(1); id <= perSymbol; id++ {
+		for _, sym := range syms {
+			s.Push([]byte(sym), &feed.Msg{TradeID: id})
+		}
+	}
+	s.Close()
+	<-done
+	close(outOfOrder)
+	for sym := range outOfOrder {
+		t.Errorf("%s processed out of order", sym)
+	}
+	for sym, p := range last {
+		if *p != perSymbol {
+			t.Errorf("%s processed %d messages, want %d", sym, *p, perSymbol)
+		}
+	}
+}
+
+func TestShardSetRestartsPanickingWorker(t *testing.T) {
+	s := NewShardSet([]string{"btcusdt"}, 1, 1)
+	sup := NewSupervisor(NewMetricsRegistry())
+	s.Supervise(sup)
+
+	var processed []uint64
+	done := s.Run(func(shard int, batch []feed.Msg) {
+		for _, m := range batch {
+			if m.TradeID == 2 {
+				panic("bad message")
+			}
+			processed = append(processed, m.TradeID)
+		}
+	})
+	for id := uint64(1); id <= 3; id++ {
+		s.Push([]byte("btcusdt"), &feed.Msg{TradeID: id})
+	}
+	s.Close()
+	<-done
+
+	if fmt.Sprint(processed) != "[1 3]" {
+		t.Errorf("processed %v, want [1 3]", processed)
+	}
+	if got := sup.Panics()["shard-0"]; got != 1 {
+		t.Errorf("shard-0 panics = %d, want 1", got)
+	}
+}
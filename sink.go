@@ -0,0 +1,49 @@
+package apexlob
+
+import (
+	"context"
+	"time"
+
+	"apexlob/pkg/sink"
+)
+
+// Sink consumes normalized events for storage or onward delivery. Sinks are
+// driven from a single goroutine by SinkRunner, so implementations need no
+// locking of their own. Trade and Execution payloads are pooled and must not
+// be retained after Write returns.
+type Sink = sink.Sink[Event]
+
+// SinkRunner attaches a sink to the event bus on its own goroutine, flushing
+// periodically and counting write failures. Under a Supervisor, a panic in
+// the sink loses the event being written and the runner carries on with
+// the next.
+type SinkRunner = sink.Runner[Event]
+
+// SinkCount is a sink's delivery tally.
+type SinkCount = sink.Count
+
+func StartSink(bus *EventBus, s Sink, types []EventType, flushEvery time.Duration, sup *Supervisor) *SinkRunner {
+	name := "sink:" + s.Name()
+	events, cancel := bus.SubscribeAs(name, 8192, nil, types)
+	return sink.Start(s, events, cancel, sink.Options[Event]{
+		FlushEvery: flushEvery,
+		Write:      writeEvent,
+		Run:        func(loop func()) { sup.Run(context.Background(), name, loop) },
+	})
+}
+
+// writeEvent writes e to s inside a span when e is traced, then returns its
+// pooled payload.
+func writeEvent(s Sink, e *Event) error {
+	var span *Span
+	if e.trace.Valid() {
+		span = otelTracer.Start("sink.write", e.trace)
+		span.SetAttr("sink", s.Name())
+		span.SetAttr("event", string(e.Type))
+		span.SetAttr("queue_ms", float64(time.Since(e.Timestamp).Microseconds())/1000)
+	}
+	err := s.Write(e)
+	e.Release()
+	span.End()
+	return err
+}
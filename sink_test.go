@@ -0,0 +1,82 @@
+package apexlob
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	events  []Event
+	flushes int
+	closed  bool
+	failOn  EventType
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(e *Event) error {
+	if e.Type == s.failOn {
+		return errors.New("rejected")
+	}
+	s.events = append(s.events, *e)
+	return nil
+}
+
+func (s *recordingSink) Flush() error { s.flushes++; return nil }
+func (s *recordingSink) Close() error { s.closed = true; return nil }
+
+func TestSinkRunnerDrainsOnStop(t *testing.T) {
+	bus := NewEventBus()
+	sink := &recordingSink{failOn: EventCandle}
+	runner := StartSink(bus, sink, []EventType{EventTrade, EventCandle}, time.Hour, nil)
+
+	for i := 0; i < 100; i++ {
+		bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt"})
+	}
+	bus.Publish(Event{Type: EventCandle, Symbol: "btcusdt"})
+	bus.Publish(Event{Type: EventBook, Symbol: "btcusdt"}) // not subscribed
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 100 || runner.Written() != 100 || runner.Failed() != 1 {
+		t.Errorf("events = %d, written = %d, failed = %d; want 100, 100, 1", len(sink.events), runner.Written(), runner.Failed())
+	}
+	if sink.flushes == 0 || !sink.closed {
+		t.Errorf("flushes = %d, closed = %v; want a final flush and close", sink.flushes, sink.closed)
+	}
+}
+
+type panickingSink struct{ recordingSink }
+
+func (s *panickingSink) Write(e *Event) error {
+	if e.Symbol == "bad" {
+		panic("bad event")
+	}
+	return s.recordingSink.Write(e)
+}
+
+func TestSinkRunnerSurvivesPanic(t *testing.T) {
+	bus := NewEventBus()
+	sink := &panickingSink{}
+	sup := NewSupervisor(NewMetricsRegistry())
+	runner := StartSink(bus, sink, []EventType{EventTrade}, time.Hour, sup)
+
+	bus.Publish(Event{Type: EventTrade, Symbol: "btcusdt"})
+	bus.Publish(Event{Type: EventTrade, Symbol: "bad"})
+	bus.Publish(Event{Type: EventTrade, Symbol: "ethusdt"})
+
+	if err := runner.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 2 || runner.Written() != 2 {
+		t.Errorf("events = %d, written = %d; want 2 around the panic", len(sink.events), runner.Written())
+	}
+	if !sink.closed {
+		t.Error("sink not closed")
+	}
+	if got := sup.Panics()["sink:recording"]; got != 1 {
+		t.Errorf("panics = %d, want 1", got)
+	}
+}
@@ -0,0 +1,191 @@
+package apexlob
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//go:embed schema/sqlite.sql
+var sqliteSchema string
+
+const sqliteTime = "2006-01-02T15:04:05.000000Z"
+
+// SQLiteStore keeps trades, closed candles and a per-run session row in a
+// local database file. Rows are buffered and written in one transaction per
+// flush from the sink goroutine, so the feed never waits on disk.
+type SQLiteStore struct {
+	db        *sql.DB
+	sessionID int64
+	stats     func() StatsSnapshot
+	trades    [][]interface{}
+	candles   [][]interface{}
+	rows      *Counter
+	errors    *Counter
+}
+
+func NewSQLiteStore(path string, symbols []string, stats func() StatsSnapshot, reg *MetricsRegistry) (*SQLiteStore, error) {
+	if sqliteDriver == "" {
+		return nil, errors.New("SQLite support not compiled in; rebuild with -tags sqlite (requires cgo)")
+	}
+	if path == "" {
+		return nil, errors.New("sqlite store: empty path")
+	}
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection keeps writes serialized and the pragmas in effect
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", sqliteSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlite store %s: %w", path, err)
+		}
+	}
+
+	now := time.Now().UTC().Format(sqliteTime)
+	res, err := db.Exec(`INSERT INTO sessions (started_at, updated_at, symbols) VALUES (?, ?, ?)`, now, now, strings.Join(symbols, ","))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	id, _ := res.LastInsertId()
+
+	return &SQLiteStore{
+		db:        db,
+		sessionID: id,
+		stats:     stats,
+		rows:      reg.Counter("apexlob_sqlite_rows_total", "Rows written to the SQLite store.", nil),
+		errors:    reg.Counter("apexlob_sqlite_errors_total", "Rows dropped after a failed SQLite write.", nil),
+	}, nil
+}
+
+func (s *SQLiteStore) Name() string { return "sqlite" }
+
+func (s *SQLiteStore) Write(e *Event) error {
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		s.trades = append(s.trades, []interface{}{tr.Timestamp.UTC().Format(sqliteTime), tr.Symbol, int64(tr.ID), tr.Side.String(), tr.Price, tr.Quantity})
+	case EventCandle:
+		c := e.Candle
+		s.candles = append(s.candles, []interface{}{c.OpenTime.UTC().Format(sqliteTime), c.Symbol, int64(c.Interval / time.Second),
+			c.Open, c.High, c.Low, c.Close, c.Volume, c.Trades})
+	}
+	return nil
+}
+
+func (s *SQLiteStore) Flush() error {
+	n := len(s.trades) + len(s.candles)
+	err := s.writeBatch()
+	s.trades, s.candles = s.trades[:0], s.candles[:0]
+	if err != nil {
+		s.errors.Add(uint64(n))
+		return err
+	}
+	s.rows.Add(uint64(n))
+	return nil
+}
+
+func (s *SQLiteStore) writeBatch() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	batches := []struct {
+		query string
+		rows  [][]interface{}
+	}{
+		{`INSERT INTO trades (ts, symbol, id, side, price, quantity) VALUES (?, ?, ?, ?, ?, ?)`, s.trades},
+		{`INSERT OR REPLACE INTO candles (open_time, symbol, interval_seconds, open, high, low, close, volume, trades)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, s.candles},
+	}
+	for _, b := range batches {
+		if len(b.rows) == 0 {
+			continue
+		}
+		stmt, err := tx.Prepare(b.query)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, row := range b.rows {
+			if _, err := stmt.Exec(row...); err != nil {
+				stmt.Close()
+				tx.Rollback()
+				return err
+			}
+		}
+		stmt.Close()
+	}
+
+	if s.stats != nil {
+		st := s.stats()
+		if _, err := tx.Exec(`UPDATE sessions SET updated_at = ?, messages = ?, messages_per_second = ?, avg_processing_ms = ? WHERE id = ?`,
+			time.Now().UTC().Format(sqliteTime), st.TotalMessages, st.MessagesPerSecond, st.AvgProcessingMs, s.sessionID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadCandles implements CandleStore.
+func (s *SQLiteStore) LoadCandles(symbol string, interval time.Duration, from, to time.Time) ([]Candle, error) {
+	rows, err := s.db.Query(`SELECT open_time, open, high, low, close, volume, trades FROM candles
+		WHERE symbol = ? AND interval_seconds = ? AND open_time >= ? AND open_time < ? ORDER BY open_time`,
+		symbol, int64(interval/time.Second), from.UTC().Format(sqliteTime), to.UTC().Format(sqliteTime))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Candle
+	for rows.Next() {
+		c := Candle{Symbol: symbol, Interval: interval}
+		var openTime string
+		if err := rows.Scan(&openTime, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume, &c.Trades); err != nil {
+			return nil, err
+		}
+		if c.OpenTime, err = time.Parse(sqliteTime, openTime); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SaveCandles implements CandleStore. It may be called while the store
+// runs as a sink: the single connection serializes the writes.
+func (s *SQLiteStore) SaveCandles(candles []Candle) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO candles (open_time, symbol, interval_seconds, open, high, low, close, volume, trades)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range candles {
+		if _, err := stmt.Exec(c.OpenTime.UTC().Format(sqliteTime), c.Symbol, int64(c.Interval/time.Second),
+			c.Open, c.High, c.Low, c.Close, c.Volume, c.Trades); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Close() error {
+	err := s.Flush()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
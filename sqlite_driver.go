@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package apexlob
+
+import _ "github.com/mattn/go-sqlite3"
+
+const sqliteDriver = "sqlite3"
@@ -0,0 +1,6 @@
+//go:build !sqlite
+
+package apexlob
+
+// The SQLite driver needs cgo, so it is only linked into builds tagged sqlite.
+const sqliteDriver = ""
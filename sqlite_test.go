@@ -0,0 +1,71 @@
+//go:build sqlite
+
+package apexlob
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestSQLiteStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.db")
+	stats := func() StatsSnapshot {
+		return StatsSnapshot{TotalMessages: 42, MessagesPerSecond: 7, AvgProcessingMs: 0.5}
+	}
+	store, err := NewSQLiteStore(path, []string{"btcusdt"}, stats, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.Write(&Event{Type: EventTrade, Trade: &orderbook.Trade{Symbol: "btcusdt", ID: 1, Price: 100, Quantity: 2, Side: orderbook.Buy, Timestamp: ts}})
+	candle := &Candle{Symbol: "btcusdt", OpenTime: ts, Interval: time.Minute, Open: 1, High: 2, Low: 1, Close: 2, Volume: 3, Trades: 1}
+	store.Write(&Event{Type: EventCandle, Candle: candle})
+	store.Write(&Event{Type: EventCandle, Candle: candle}) // same bar again replaces
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var trades, candles, messages int
+	var tradeTS string
+	db.QueryRow(`SELECT count(*), max(ts) FROM trades`).Scan(&trades, &tradeTS)
+	db.QueryRow(`SELECT count(*) FROM candles`).Scan(&candles)
+	db.QueryRow(`SELECT messages FROM sessions ORDER BY id DESC LIMIT 1`).Scan(&messages)
+	if trades != 1 || candles != 1 || messages != 42 {
+		t.Errorf("trades = %d, candles = %d, session messages = %d; want 1, 1, 42", trades, candles, messages)
+	}
+	if tradeTS != "2024-01-01T00:00:00.000000Z" {
+		t.Errorf("trade ts = %q", tradeTS)
+	}
+}
+
+func TestSQLiteStoreCandles(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "candles.db"), []string{"btcusdt"}, nil, NewMetricsRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var bars []Candle
+	for i := 0; i < 5; i++ {
+		bars = append(bars, Candle{Symbol: "btcusdt", OpenTime: base.Add(time.Duration(i) * time.Minute), Interval: time.Minute, Close: float64(i), Trades: i})
+	}
+	if err := store.SaveCandles(bars); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.LoadCandles("btcusdt", time.Minute, base.Add(time.Minute), base.Add(4*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || !got[0].OpenTime.Equal(bars[1].OpenTime) || got[2].Close != 3 || got[2].Trades != 3 {
+		t.Errorf("loaded %+v, want bars 1 to 3", got)
+	}
+}
@@ -0,0 +1,137 @@
+package apexlob
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PipelineStats is how the feed pipeline reports message timings and how
+// the API, dashboard, debug vars and stores read them back.
+type PipelineStats interface {
+	// MessageReceived notes a message read off the socket and reports
+	// whether it was the first one.
+	MessageReceived(at time.Time) (first bool)
+	// MessageProcessed records one message's processing time and its
+	// end-to-end latency from the exchange event time against the shard
+	// that handled it. endToEnd is skipped when not positive: the message
+	// had no event time or the clocks are out of step.
+	MessageProcessed(shard int, processing, endToEnd time.Duration)
+	Snapshot() StatsSnapshot
+}
+
+// statsCounters is one shard's slice of the totals, padded to a cache line
+// so workers bumping their own counters don't contend with each other.
+type statsCounters struct {
+	messages     uint64
+	processingNs uint64
+	_            [48]byte
+}
+
+// TimingStats counts processed messages and their processing time without
+// locks. Every shard worker writes only its own counters and histograms;
+// readers sum them.
+type TimingStats struct {
+	connectionStart time.Time
+	firstMessageNs  int64 // UnixNano of the first message, 0 until then
+	counters        []statsCounters
+	processing      []*HDRHistogram
+	endToEnd        []*HDRHistogram
+}
+
+// maxRecordedLatency caps the histograms; slower messages count as this.
+const maxRecordedLatency = time.Minute
+
+var _ PipelineStats = (*TimingStats)(nil)
+
+func NewTimingStats(start time.Time, shards int) *TimingStats {
+	if shards < 1 {
+		shards = 1
+	}
+	ts := &TimingStats{connectionStart: start, counters: make([]statsCounters, shards)}
+	for i := 0; i < shards; i++ {
+		ts.processing = append(ts.processing, NewHDRHistogram(maxRecordedLatency))
+		ts.endToEnd = append(ts.endToEnd, NewHDRHistogram(maxRecordedLatency))
+	}
+	return ts
+}
+
+func (ts *TimingStats) MessageReceived(at time.Time) bool {
+	return atomic.LoadInt64(&ts.firstMessageNs) == 0 && atomic.CompareAndSwapInt64(&ts.firstMessageNs, 0, at.UnixNano())
+}
+
+func (ts *TimingStats) MessageProcessed(shard int, processing, endToEnd time.Duration) {
+	c := &ts.counters[shard]
+	atomic.AddUint64(&c.messages, 1)
+	atomic.AddUint64(&c.processingNs, uint64(processing))
+	ts.processing[shard].Record(processing)
+	if endToEnd > 0 {
+		ts.endToEnd[shard].Record(endToEnd)
+	}
+}
+
+// Totals returns the message count and summed processing time across
+// shards.
+func (ts *TimingStats) Totals() (messages int, processing time.Duration) {
+	for i := range ts.counters {
+		c := &ts.counters[i]
+		messages += int(atomic.LoadUint64(&c.messages))
+		processing += time.Duration(atomic.LoadUint64(&c.processingNs))
+	}
+	return messages, processing
+}
+
+// FirstMessageTime is when the first message arrived, or the zero time.
+func (ts *TimingStats) FirstMessageTime() time.Time {
+	if ns := atomic.LoadInt64(&ts.firstMessageNs); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// Latency merges the per-shard processing and end-to-end histograms.
+func (ts *TimingStats) Latency() (processing, endToEnd *HDRHistogram) {
+	processing = NewHDRHistogram(maxRecordedLatency)
+	endToEnd = NewHDRHistogram(maxRecordedLatency)
+	for i := range ts.processing {
+		processing.Merge(ts.processing[i])
+		endToEnd.Merge(ts.endToEnd[i])
+	}
+	return processing, endToEnd
+}
+
+func (ts *TimingStats) Snapshot() StatsSnapshot {
+	messages, processing := ts.Totals()
+	procHist, e2eHist := ts.Latency()
+	snap := StatsSnapshot{
+		UptimeSeconds: time.Since(ts.connectionStart).Seconds(),
+		TotalMessages: messages,
+		Processing:    procHist.Percentiles(),
+		EndToEnd:      e2eHist.Percentiles(),
+	}
+	if snap.UptimeSeconds > 0 {
+		snap.MessagesPerSecond = float64(messages) / snap.UptimeSeconds
+	}
+	if messages > 0 {
+		snap.AvgProcessingMs = float64(processing.Nanoseconds()) / 1e6 / float64(messages)
+	}
+	return snap
+}
+
+// LatencySamples reports the latency quantiles as Prometheus samples
+// labelled by stage and quantile.
+func (ts *TimingStats) LatencySamples() []Sample {
+	processing, endToEnd := ts.Latency()
+	var samples []Sample
+	for _, stage := range []struct {
+		name string
+		h    *HDRHistogram
+	}{{"processing", processing}, {"end_to_end", endToEnd}} {
+		for i, d := range stage.h.Quantiles(latencyQuantiles...) {
+			samples = append(samples, Sample{
+				Labels: Labels{"stage": stage.name, "quantile": formatFloat(latencyQuantiles[i])},
+				Value:  d.Seconds(),
+			})
+		}
+	}
+	return samples
+}
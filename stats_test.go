@@ -0,0 +1,92 @@
+package apexlob
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimingStatsConcurrentShards(t *testing.T) {
+	ts := NewTimingStats(time.Now().Add(-time.Second), 4)
+	var wg sync.WaitGroup
+	for shard := 0; shard < 4; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				ts.MessageProcessed(shard, 2*time.Millisecond, 0)
+			}
+		}(shard)
+	}
+	wg.Wait()
+
+	messages, processing := ts.Totals()
+	if messages != 4000 || processing != 8*time.Second {
+		t.Fatalf("Totals = %d, %v; want 4000, 8s", messages, processing)
+	}
+	snap := ts.Snapshot()
+	if snap.TotalMessages != 4000 || snap.AvgProcessingMs != 2 {
+		t.Errorf("snapshot = %+v", snap)
+	}
+	if p := snap.Processing; p.P50 < 1.99 || p.P50 > 2.01 || p.P999 < 1.99 || p.P999 > 2.01 {
+		t.Errorf("processing percentiles = %+v, want 2ms", p)
+	}
+	if snap.EndToEnd != (LatencyPercentiles{}) {
+		t.Errorf("end-to-end recorded without event times: %+v", snap.EndToEnd)
+	}
+	if snap.UptimeSeconds < 1 || snap.MessagesPerSecond <= 0 || snap.MessagesPerSecond > 4000 {
+		t.Errorf("uptime %.2fs, rate %.1f/s", snap.UptimeSeconds, snap.MessagesPerSecond)
+	}
+}
+
+func TestTimingStatsFirstMessageOnce(t *testing.T) {
+	ts := NewTimingStats(time.Now(), 1)
+	if !ts.FirstMessageTime().IsZero() {
+		t.Fatal("first message time set before any message")
+	}
+	first := time.Now()
+	if !ts.MessageReceived(first) {
+		t.Fatal("first message not reported")
+	}
+	if ts.MessageReceived(first.Add(time.Second)) {
+		t.Error("second message reported as first")
+	}
+	if !ts.FirstMessageTime().Equal(time.Unix(0, first.UnixNano())) {
+		t.Errorf("FirstMessageTime = %v, want %v", ts.FirstMessageTime(), first)
+	}
+}
+
+func TestTimingStatsLatencySamples(t *testing.T) {
+	ts := NewTimingStats(time.Now(), 2)
+	for i := 1; i <= 100; i++ {
+		ts.MessageProcessed(i%2, time.Duration(i)*time.Microsecond, time.Duration(i)*10*time.Millisecond)
+	}
+	samples := ts.LatencySamples()
+	if len(samples) != 8 {
+		t.Fatalf("got %d samples, want 8", len(samples))
+	}
+	for _, s := range samples {
+		if s.Labels["stage"] == "end_to_end" && s.Labels["quantile"] == "0.99" {
+			if s.Value < 0.985 || s.Value > 0.995 {
+				t.Errorf("end-to-end p99 = %.3fs, want ~0.99s", s.Value)
+			}
+			return
+		}
+	}
+	t.Error("no end-to-end p99 sample")
+}
+
+func BenchmarkTimingStatsParallel(b *testing.B) {
+	ts := NewTimingStats(time.Now(), 8)
+	var next int32
+	var mu sync.Mutex
+	b.RunParallel(func(pb *testing.PB) {
+		mu.Lock()
+		shard := int(next) % 8
+		next++
+		mu.Unlock()
+		for pb.Next() {
+			ts.MessageProcessed(shard, time.Microsecond, time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,26 @@
+package apexlob
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OpenStore opens the persistence backend named by spec, either
+// sqlite://path.db or a postgres:// connection URL, and returns it with the
+// event types it stores.
+func OpenStore(spec string, symbols []string, stats func() StatsSnapshot, reg *MetricsRegistry) (Sink, []EventType, error) {
+	scheme, rest, ok := strings.Cut(spec, "://")
+	if !ok {
+		return nil, nil, fmt.Errorf("store %q: expected sqlite://path or postgres://...", spec)
+	}
+	switch scheme {
+	case "sqlite":
+		s, err := NewSQLiteStore(rest, symbols, stats, reg)
+		return s, []EventType{EventTrade, EventCandle}, err
+	case "postgres", "postgresql":
+		s, err := NewPostgresStore(PostgresConfig{DSN: spec, BookInterval: 5 * time.Second}, reg)
+		return s, []EventType{EventTrade, EventExecution, EventBook, EventCandle}, err
+	}
+	return nil, nil, fmt.Errorf("store %q: unsupported scheme %q", spec, scheme)
+}
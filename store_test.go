@@ -0,0 +1,24 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOpenStoreRejectsUnknownSpecs(t *testing.T) {
+	for _, spec := range []string{"path.db", "mysql://localhost/db"} {
+		if _, _, err := OpenStore(spec, nil, nil, NewMetricsRegistry()); err == nil {
+			t.Errorf("OpenStore(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestOpenStoreSQLiteWithoutDriver(t *testing.T) {
+	if sqliteDriver != "" {
+		t.Skip("built with -tags sqlite")
+	}
+	_, _, err := OpenStore("sqlite://capture.db", nil, nil, NewMetricsRegistry())
+	if err == nil || !strings.Contains(err.Error(), "-tags sqlite") {
+		t.Errorf("error = %v, want a hint to rebuild with -tags sqlite", err)
+	}
+}
@@ -0,0 +1,156 @@
+package apexlob
+
+import (
+	"math"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// The reference strategies below are small on purpose: they show how a
+// Strategy quotes, takes, tracks its orders and respects a position limit,
+// and are meant to be copied rather than traded.
+func init() {
+	RegisterStrategy("mm", func() Strategy { return NewMarketMaker() })
+	RegisterStrategy("momentum", func() Strategy { return NewMomentum() })
+}
+
+// MarketMaker quotes a bid and an offer a fixed spread around each book's
+// mid, or its last trade while one side is empty. Quotes are skewed away
+// from the position it has built up, and the side that would take it past
+// MaxPosition is not quoted at all. A quote is replaced only when the price
+// it should be at moves by a tick or more.
+type MarketMaker struct {
+	SpreadBps   float64 // between bid and offer
+	Size        float64 // of each quote
+	MaxPosition float64 // in either direction
+	Tick        float64 // quotes are rounded away from the mid to a multiple of it
+
+	quotes map[string]*mmQuotes
+}
+
+type mmQuotes struct {
+	bid, ask uint64 // order IDs, 0 when not quoting
+}
+
+func NewMarketMaker() *MarketMaker {
+	return &MarketMaker{SpreadBps: 2, Size: 0.01, MaxPosition: 0.05, Tick: 0.01, quotes: make(map[string]*mmQuotes)}
+}
+
+func (mm *MarketMaker) OnTrade(*StrategyContext, *orderbook.Trade) {}
+
+func (mm *MarketMaker) OnTimer(*StrategyContext, time.Time) {}
+
+func (mm *MarketMaker) OnBook(ctx *StrategyContext, symbol string) {
+	ob := ctx.Book(symbol)
+	mid := ob.GetLastTradePrice()
+	bid, _, okBid := ob.GetBestBid()
+	ask, _, okAsk := ob.GetBestAsk()
+	if okBid && okAsk {
+		mid = (bid + ask) / 2
+	}
+	if mid <= 0 {
+		return
+	}
+	q, ok := mm.quotes[symbol]
+	if !ok {
+		q = &mmQuotes{}
+		mm.quotes[symbol] = q
+	}
+	// Leaning against the position: long moves both quotes down so the
+	// offer is hit sooner, short moves them up
+	pos := ctx.Position(symbol).Quantity
+	half := mid * mm.SpreadBps / 2e4
+	center := mid - half*pos/mm.MaxPosition
+	mm.requote(ctx, symbol, &q.bid, orderbook.Buy, math.Floor((center-half)/mm.Tick)*mm.Tick, pos+mm.Size <= mm.MaxPosition+1e-9)
+	mm.requote(ctx, symbol, &q.ask, orderbook.Sell, math.Ceil((center+half)/mm.Tick)*mm.Tick, pos-mm.Size >= -mm.MaxPosition-1e-9)
+}
+
+// requote keeps the order at *id resting at price, or none if !want.
+func (mm *MarketMaker) requote(ctx *StrategyContext, symbol string, id *uint64, side orderbook.Side, price float64, want bool) {
+	if *id != 0 {
+		var resting *StrategyOrder
+		for _, o := range ctx.OpenOrders(symbol) {
+			if o.ID == *id {
+				resting = &o
+				break
+			}
+		}
+		switch {
+		case resting == nil:
+			*id = 0 // filled
+		case want && math.Abs(resting.Price-price) < mm.Tick/2:
+			return
+		default:
+			ctx.Cancel(*id)
+			*id = 0
+		}
+	}
+	if want && price > 0 {
+		*id, _ = ctx.Submit(symbol, side, price, mm.Size)
+	}
+}
+
+// Momentum takes liquidity in the direction a signal points: it buys at
+// the offer when the signal rises above Threshold and sells at the bid when
+// it falls below -Threshold, up to MaxPosition either way, and waits
+// Cooldown of feed time between trades. It flattens once the signal turns
+// against its position. Its orders are marketable, and whatever does not
+// fill at once is cancelled.
+type Momentum struct {
+	Signal      string
+	Threshold   float64
+	Size        float64
+	MaxPosition float64
+	Cooldown    time.Duration
+
+	last map[string]time.Time
+}
+
+func NewMomentum() *Momentum {
+	return &Momentum{Signal: "imbalance", Threshold: 0.6, Size: 0.01, MaxPosition: 0.05, Cooldown: time.Second, last: make(map[string]time.Time)}
+}
+
+func (m *Momentum) OnTrade(*StrategyContext, *orderbook.Trade) {}
+
+func (m *Momentum) OnTimer(*StrategyContext, time.Time) {}
+
+func (m *Momentum) OnBook(ctx *StrategyContext, symbol string) {
+	if ctx.Now().Sub(m.last[symbol]) < m.Cooldown {
+		return
+	}
+	value, ok := ctx.Signals(symbol)[m.Signal]
+	if !ok || math.IsNaN(value) {
+		return
+	}
+	pos := ctx.Position(symbol).Quantity
+	var side orderbook.Side
+	var qty float64
+	switch {
+	case value > m.Threshold && pos+m.Size <= m.MaxPosition+1e-9:
+		side, qty = orderbook.Buy, m.Size
+	case value < -m.Threshold && pos-m.Size >= -m.MaxPosition-1e-9:
+		side, qty = orderbook.Sell, m.Size
+	case pos > 0 && value < 0:
+		side, qty = orderbook.Sell, pos
+	case pos < 0 && value > 0:
+		side, qty = orderbook.Buy, -pos
+	default:
+		return
+	}
+	ob := ctx.Book(symbol)
+	price, _, ok := ob.GetBestAsk()
+	if side == orderbook.Sell {
+		price, _, ok = ob.GetBestBid()
+	}
+	if !ok {
+		return
+	}
+	id, err := ctx.Submit(symbol, side, price, qty)
+	if err != nil {
+		return
+	}
+	// Immediate or cancel: what did not fill on arrival never rests
+	ctx.Cancel(id)
+	m.last[symbol] = ctx.Now()
+}
@@ -0,0 +1,53 @@
+package apexlob
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestMarketMaker(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt", "ethusdt"}, 3000)
+	opts := PipelineOptions{Symbols: "btcusdt,ethusdt", FeedQueue: 64, Limits: DefaultSymbolLimits}
+	for _, paper := range []bool{false, true} {
+		// The synthetic feed barely moves, so quote it tight
+		mm := NewMarketMaker()
+		mm.SpreadBps = 0.02
+		report, err := RunStrategyBacktest(context.Background(), opts, path, mm, StrategyOptions{Paper: paper})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := report.Strategy
+		if r.Fills == 0 || r.Cancels == 0 {
+			t.Fatalf("paper %v: %d fills, %d cancels", paper, r.Fills, r.Cancels)
+		}
+		for sym, s := range r.Positions {
+			if math.Abs(s.Position.Quantity) > mm.MaxPosition+1e-9 {
+				t.Errorf("paper %v: %s position %v beyond the limit", paper, sym, s.Position.Quantity)
+			}
+		}
+		// At most a bid and an offer per symbol
+		if len(r.OpenOrders) > 4 {
+			t.Errorf("paper %v: %d open orders", paper, len(r.OpenOrders))
+		}
+	}
+}
+
+func TestMomentum(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt"}, 3000)
+	// Its trades all carry the same event time
+	m := NewMomentum()
+	m.Cooldown = 0
+	report, err := RunStrategyBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", FeedQueue: 64, Limits: DefaultSymbolLimits}, path, m, StrategyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := report.Strategy
+	if r.Fills == 0 {
+		t.Fatal("momentum never traded")
+	}
+	btc := r.Symbols["btcusdt"]
+	if pos := r.Positions["btcusdt"].Position; btc.MakerFills != 0 || math.Abs(pos.Quantity) > m.MaxPosition+1e-9 {
+		t.Errorf("btcusdt = %+v, position %+v", btc, pos)
+	}
+}
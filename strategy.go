@@ -0,0 +1,557 @@
+package apexlob
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+// Strategy is trading logic driven by the feed. It is called after each
+// message has been applied to the books, never concurrently, and trades
+// through ctx: orders it submits go into the same books as the feed's and
+// fill against them.
+type Strategy interface {
+	OnTrade(ctx *StrategyContext, trade *orderbook.Trade)
+	// OnBook follows every change to symbol's book, trades included
+	OnBook(ctx *StrategyContext, symbol string)
+	// OnTimer is called every timer interval of feed time
+	OnTimer(ctx *StrategyContext, now time.Time)
+}
+
+// FillHandler is implemented by strategies that want to hear of each of
+// their fills as it happens.
+type FillHandler interface {
+	OnFill(ctx *StrategyContext, fill Fill)
+}
+
+// Fill is part or all of a strategy order trading.
+type Fill struct {
+	Symbol   string         `json:"symbol"`
+	OrderID  uint64         `json:"order_id"`
+	Side     orderbook.Side `json:"side"`
+	Price    float64        `json:"price"`
+	Quantity float64        `json:"quantity"`
+	Maker    bool           `json:"maker"` // the order was resting
+	Time     time.Time      `json:"time"`
+}
+
+// StrategyOrder is a strategy's order that has not yet filled in full or
+// been cancelled.
+type StrategyOrder struct {
+	ID        uint64         `json:"id"`
+	Symbol    string         `json:"symbol"`
+	Side      orderbook.Side `json:"side"`
+	Price     float64        `json:"price"`
+	Remaining float64        `json:"remaining"`
+	Submitted time.Time      `json:"submitted"`
+	// Active is false while the order is on its way to the book
+	Active bool `json:"active"`
+	// QueueAhead is the volume resting ahead of a paper order at its price,
+	// when queue position is modelled
+	QueueAhead float64 `json:"queue_ahead,omitempty"`
+
+	arrives time.Time // when an inactive order reaches the book
+}
+
+// StrategyOptions say how an attached strategy runs.
+type StrategyOptions struct {
+	Timer time.Duration // OnTimer interval of feed time; 0 for none
+	// Paper keeps the strategy's orders out of the books: they rest in a
+	// shadow book of their own and fill when the market trades through
+	// their price, so trading logic can run against the live feed
+	Paper bool
+	// EntryLatency delays each order reaching the book, and CancelLatency
+	// each cancel, by that much feed time. An order being cancelled can
+	// still fill until the cancel arrives.
+	EntryLatency  time.Duration
+	CancelLatency time.Duration
+	// QueuePosition puts a paper order at the back of the queue at its
+	// price: trades at that price fill it only once the volume resting
+	// ahead of it when it arrived has traded or been cancelled
+	QueuePosition bool
+	// Mark is the price open positions are valued at, the last trade by
+	// default
+	Mark MarkPrice
+}
+
+// strategyAction is an order or cancel delayed by latency.
+type strategyAction struct {
+	due    time.Time
+	order  *StrategyOrder
+	cancel bool
+}
+
+// strategyOrderBase starts the IDs of strategy orders, far above any
+// Binance trade ID, so an execution tells by its IDs whose orders traded.
+const strategyOrderBase = 1 << 62
+
+func isStrategyOrder(id uint64) bool { return id >= strategyOrderBase }
+
+// StrategyContext runs a Strategy against a monitor's books and is what the
+// strategy trades through. Its clock is feed time, the exchange event time
+// of the message being handled, so a backtest runs the same however fast
+// the capture is replayed.
+type StrategyContext struct {
+	strategy Strategy
+	symbols  *SymbolRegistry
+	opts     StrategyOptions
+
+	mu         sync.Mutex // held while the strategy runs
+	now        time.Time
+	nextTimer  time.Time
+	nextID     uint64
+	open       map[uint64]*StrategyOrder
+	cancelling map[uint64]bool
+	actions    []strategyAction // by due time
+	pnl        *PnLTracker
+	symStats   map[string]*strategySymbolStats
+	orders     int
+	cancels    int
+
+	// Fills reported by the books' execution handlers, which run under the
+	// book lock and possibly on another shard's worker, waiting to be
+	// handed to the strategy
+	fillsMu sync.Mutex
+	fills   []Fill
+}
+
+type strategySymbolStats struct {
+	fills, makerFills int
+	bought, sold      float64
+}
+
+func newStrategyContext(s Strategy, symbols *SymbolRegistry, opts StrategyOptions) *StrategyContext {
+	return &StrategyContext{
+		strategy:   s,
+		symbols:    symbols,
+		opts:       opts,
+		nextID:     strategyOrderBase,
+		open:       make(map[uint64]*StrategyOrder),
+		cancelling: make(map[uint64]bool),
+		pnl:        NewPnLTracker(opts.Mark),
+		symStats:   make(map[string]*strategySymbolStats),
+	}
+}
+
+// Now is the feed time of the message being handled.
+func (c *StrategyContext) Now() time.Time { return c.now }
+
+// Book returns symbol's book, or nil if it is not streamed. The strategy
+// may read it but must trade only through Submit and Cancel.
+func (c *StrategyContext) Book(symbol string) *orderbook.Book {
+	state, ok := c.symbols.Get(symbol)
+	if !ok {
+		return nil
+	}
+	return state.Book
+}
+
+// Signals returns the current values of symbol's signals.
+func (c *StrategyContext) Signals(symbol string) map[string]float64 {
+	state, ok := c.symbols.Get(symbol)
+	if !ok {
+		return nil
+	}
+	return state.Signals.Snapshot()
+}
+
+// Submit places a limit order in symbol's book and returns its ID. The
+// order matches against the book once it gets there, straight away unless
+// there is entry latency, and whatever is left rests until it fills or is
+// cancelled. Its fills reach the strategy once the current callback
+// returns. A paper order takes what it crosses in the book without
+// changing it, and rests in the shadow book.
+func (c *StrategyContext) Submit(symbol string, side orderbook.Side, price, quantity float64) (uint64, error) {
+	if _, ok := c.symbols.Get(symbol); !ok {
+		return 0, fmt.Errorf("%w: %q", errUnknownSymbol, symbol)
+	}
+	if !(price > 0) {
+		return 0, fmt.Errorf("invalid order price %v", price)
+	}
+	qty := orderbook.ScaleQuantity(quantity)
+	if qty == 0 {
+		return 0, fmt.Errorf("invalid order quantity %v", quantity)
+	}
+	c.nextID++
+	c.orders++
+	o := &StrategyOrder{ID: c.nextID, Symbol: symbol, Side: side, Price: price, Remaining: float64(qty) / 1000, Submitted: c.now}
+	c.open[o.ID] = o
+	if c.opts.EntryLatency > 0 {
+		o.arrives = c.now.Add(c.opts.EntryLatency)
+		c.schedule(strategyAction{due: o.arrives, order: o})
+	} else {
+		c.place(o)
+	}
+	return o.ID, nil
+}
+
+// Cancel withdraws an order, reporting whether it was still open. With
+// cancel latency the order stays open, and can fill, until the cancel
+// arrives. An order evicted by the book's limits is dropped from
+// OpenOrders by cancelling it too.
+func (c *StrategyContext) Cancel(id uint64) bool {
+	o, ok := c.open[id]
+	if !ok || c.cancelling[id] {
+		return false
+	}
+	if c.opts.CancelLatency == 0 && o.Active {
+		return c.cancel(o)
+	}
+	due := c.now.Add(c.opts.CancelLatency)
+	if due.Before(o.arrives) {
+		// A cancel cannot overtake its order
+		due = o.arrives
+	}
+	c.cancelling[id] = true
+	c.schedule(strategyAction{due: due, order: o, cancel: true})
+	return true
+}
+
+func (c *StrategyContext) schedule(a strategyAction) {
+	i := sort.Search(len(c.actions), func(i int) bool { return c.actions[i].due.After(a.due) })
+	c.actions = append(c.actions, strategyAction{})
+	copy(c.actions[i+1:], c.actions[i:])
+	c.actions[i] = a
+}
+
+// place sends o to its book.
+func (c *StrategyContext) place(o *StrategyOrder) {
+	o.Active = true
+	state, _ := c.symbols.Get(o.Symbol)
+	if c.opts.Paper {
+		c.placePaper(state.Book, o)
+		return
+	}
+	order := &orderbook.Order{ID: o.ID, Price: o.Price, Quantity: orderbook.ScaleQuantity(o.Remaining), Side: o.Side, EntryTime: c.now}
+	if !state.Book.SubmitOrder(order) {
+		delete(c.open, o.ID)
+	}
+}
+
+func (c *StrategyContext) cancel(o *StrategyOrder) bool {
+	delete(c.open, o.ID)
+	delete(c.cancelling, o.ID)
+	if !c.opts.Paper {
+		state, _ := c.symbols.Get(o.Symbol)
+		if !state.Book.CancelOrder(o.ID) {
+			return false
+		}
+	}
+	c.cancels++
+	return true
+}
+
+// OpenOrders lists symbol's resting orders, or every symbol's for "", oldest
+// first.
+func (c *StrategyContext) OpenOrders(symbol string) []StrategyOrder {
+	var orders []StrategyOrder
+	for _, o := range c.open {
+		if symbol == "" || o.Symbol == symbol {
+			orders = append(orders, *o)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders
+}
+
+// Position returns the strategy's position in symbol.
+func (c *StrategyContext) Position(symbol string) Position {
+	return c.pnl.Position(symbol)
+}
+
+// placePaper fills o against the levels it crosses in ob, at their prices
+// and up to their volume, and rests what is left in the shadow book.
+func (c *StrategyContext) placePaper(ob *orderbook.Book, o *StrategyOrder) {
+	bids, asks := ob.Depth(0)
+	levels := asks
+	if o.Side == orderbook.Sell {
+		levels = bids
+	}
+	left := o.Remaining
+	for _, l := range levels {
+		if left < 0.0005 || (o.Side == orderbook.Buy && l.Price > o.Price) || (o.Side == orderbook.Sell && l.Price < o.Price) {
+			break
+		}
+		qty := math.Min(left, float64(l.Volume)/1000)
+		left -= qty
+		c.queueFill(Fill{Symbol: o.Symbol, OrderID: o.ID, Side: o.Side, Price: l.Price, Quantity: qty})
+	}
+	if left < 0.0005 {
+		delete(c.open, o.ID)
+	} else if c.opts.QueuePosition {
+		o.QueueAhead = float64(ob.LevelVolume(o.Side, o.Price)) / 1000
+	}
+}
+
+// fillPaper fills the paper orders trade went through: bids above its price
+// and offers below it, and with queue position modelled those at its price
+// once the queue ahead of them is used up. The furthest through fill
+// first, then the oldest, and together they take no more than the trade's
+// quantity.
+func (c *StrategyContext) fillPaper(trade *orderbook.Trade) {
+	var hit []*StrategyOrder
+	for _, o := range c.open {
+		if o.Symbol != trade.Symbol || !o.Active {
+			continue
+		}
+		through := (o.Side == orderbook.Buy && trade.Price < o.Price) || (o.Side == orderbook.Sell && trade.Price > o.Price)
+		atPrice := c.opts.QueuePosition && trade.Price == o.Price && trade.Side != o.Side
+		if through || atPrice {
+			hit = append(hit, o)
+		}
+	}
+	sort.Slice(hit, func(i, j int) bool {
+		di, dj := math.Abs(hit[i].Price-trade.Price), math.Abs(hit[j].Price-trade.Price)
+		if di != dj {
+			return di > dj
+		}
+		return hit[i].ID < hit[j].ID
+	})
+	left := trade.Quantity
+	for _, o := range hit {
+		reached := left
+		if o.Price == trade.Price {
+			// Each order's queue is worked through by the whole trade
+			reached = left - o.QueueAhead
+			o.QueueAhead = math.Max(0, o.QueueAhead-trade.Quantity)
+		}
+		if reached < 0.0005 {
+			continue
+		}
+		qty := math.Min(o.Remaining, reached)
+		left -= qty
+		c.queueFill(Fill{Symbol: o.Symbol, OrderID: o.ID, Side: o.Side, Price: o.Price, Quantity: qty, Maker: true})
+	}
+}
+
+// shrinkQueues caps the queue ahead of symbol's paper orders at what rests
+// at their price: if the level has shrunk below it, orders ahead of them
+// were cancelled.
+func (c *StrategyContext) shrinkQueues(symbol string) {
+	ob := c.Book(symbol)
+	for _, o := range c.open {
+		if o.Symbol == symbol && o.QueueAhead > 0 {
+			o.QueueAhead = math.Min(o.QueueAhead, float64(ob.LevelVolume(o.Side, o.Price))/1000)
+		}
+	}
+}
+
+func (c *StrategyContext) queueFill(f Fill) {
+	c.fillsMu.Lock()
+	c.fills = append(c.fills, f)
+	c.fillsMu.Unlock()
+}
+
+// execution records the strategy's side of an execution in symbol's book.
+// Books call it from their execution handlers.
+func (c *StrategyContext) execution(symbol string, ex *orderbook.Execution) {
+	if !isStrategyOrder(ex.TakerID) && !isStrategyOrder(ex.MakerID) {
+		return
+	}
+	qty := float64(ex.Quantity) / 1000
+	if isStrategyOrder(ex.TakerID) {
+		c.queueFill(Fill{Symbol: symbol, OrderID: ex.TakerID, Side: ex.Side, Price: ex.Price, Quantity: qty})
+	}
+	if isStrategyOrder(ex.MakerID) {
+		makerSide := orderbook.Sell
+		if ex.Side == orderbook.Sell {
+			makerSide = orderbook.Buy
+		}
+		c.queueFill(Fill{Symbol: symbol, OrderID: ex.MakerID, Side: makerSide, Price: ex.Price, Quantity: qty, Maker: true})
+	}
+}
+
+// beforeMessage brings the clock up to a message's feed time before its
+// pipeline applies it, running the timers, orders and cancels due by then.
+func (c *StrategyContext) beforeMessage(m *feed.Msg) {
+	at := m.Received
+	if m.EventMs > 0 {
+		at = time.UnixMilli(m.EventMs)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advance(at)
+}
+
+// advance moves the clock to t, first running whatever is due by then in
+// time order, delayed orders and cancels ahead of timers due at the same
+// time.
+func (c *StrategyContext) advance(t time.Time) {
+	timer := c.opts.Timer
+	if timer > 0 && c.nextTimer.IsZero() {
+		c.nextTimer = t.Add(timer)
+	}
+	for {
+		timerDue := timer > 0 && !c.nextTimer.After(t)
+		actionDue := len(c.actions) > 0 && !c.actions[0].due.After(t)
+		switch {
+		case actionDue && (!timerDue || !c.actions[0].due.After(c.nextTimer)):
+			a := c.actions[0]
+			c.actions = c.actions[1:]
+			c.setNow(a.due)
+			if _, open := c.open[a.order.ID]; !open {
+				delete(c.cancelling, a.order.ID) // filled meanwhile
+			} else if a.cancel {
+				c.cancel(a.order)
+			} else {
+				c.place(a.order)
+			}
+		case timerDue:
+			c.setNow(c.nextTimer)
+			c.strategy.OnTimer(c, c.nextTimer)
+			c.nextTimer = c.nextTimer.Add(timer)
+		default:
+			c.setNow(t)
+			return
+		}
+		c.deliverFills()
+	}
+}
+
+func (c *StrategyContext) setNow(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// onMessage runs the strategy for a message its pipeline has just
+// processed: a trade, or the last level of a depth update with a nil trade.
+func (c *StrategyContext) onMessage(m *feed.Msg, trade *orderbook.Trade) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Fills of resting orders by this message come first
+	if c.opts.Paper && trade != nil {
+		c.fillPaper(trade)
+	}
+	if c.opts.Paper && c.opts.QueuePosition {
+		c.shrinkQueues(m.Symbol)
+	}
+	c.deliverFills()
+	c.pnl.Mark(m.Symbol, c.Book(m.Symbol))
+	if trade != nil {
+		c.strategy.OnTrade(c, trade)
+		c.deliverFills()
+	}
+	c.strategy.OnBook(c, m.Symbol)
+	c.deliverFills()
+}
+
+// deliverFills books the pending fills and hands them to the strategy,
+// including those of any orders it submits in response.
+func (c *StrategyContext) deliverFills() {
+	handler, _ := c.strategy.(FillHandler)
+	for {
+		c.fillsMu.Lock()
+		fills := c.fills
+		c.fills = nil
+		c.fillsMu.Unlock()
+		if len(fills) == 0 {
+			return
+		}
+		for _, f := range fills {
+			f.Time = c.now
+			c.record(f)
+			if handler != nil {
+				handler.OnFill(c, f)
+			}
+		}
+	}
+}
+
+func (c *StrategyContext) record(f Fill) {
+	c.pnl.Fill(f.Symbol, f.Side, f.Price, f.Quantity)
+	stats, ok := c.symStats[f.Symbol]
+	if !ok {
+		stats = &strategySymbolStats{}
+		c.symStats[f.Symbol] = stats
+	}
+	stats.fills++
+	if f.Maker {
+		stats.makerFills++
+	}
+	if f.Side == orderbook.Buy {
+		stats.bought += f.Quantity
+	} else {
+		stats.sold += f.Quantity
+	}
+	if o, ok := c.open[f.OrderID]; ok {
+		// Quantities are in thousandths, so compare in those
+		if o.Remaining = o.Remaining - f.Quantity; o.Remaining < 0.0005 {
+			delete(c.open, f.OrderID)
+		}
+	}
+}
+
+// strategyFactories holds the strategies --strategy can name.
+var strategyFactories = map[string]func() Strategy{}
+
+// RegisterStrategy makes a strategy available to --strategy as name.
+func RegisterStrategy(name string, factory func() Strategy) {
+	strategyFactories[name] = factory
+}
+
+// NewStrategy returns a new instance of the strategy registered as name.
+func NewStrategy(name string) (Strategy, error) {
+	factory, ok := strategyFactories[name]
+	if !ok {
+		available := "none"
+		if len(strategyFactories) > 0 {
+			available = strings.Join(sortedKeys(strategyFactories), ", ")
+		}
+		return nil, fmt.Errorf("unknown strategy %q (available: %s)", name, available)
+	}
+	return factory(), nil
+}
+
+// StrategyReport summarizes a strategy's trading, with open positions
+// valued at each book's mark.
+type StrategyReport struct {
+	Paper      bool            `json:"paper"`
+	Orders     int             `json:"orders"`
+	Cancels    int             `json:"cancels"`
+	Fills      int             `json:"fills"`
+	OpenOrders []StrategyOrder `json:"open_orders"`
+	PnLReport
+	Symbols map[string]StrategySymbolReport `json:"symbols"`
+}
+
+type StrategySymbolReport struct {
+	Fills      int     `json:"fills"`
+	MakerFills int     `json:"maker_fills"`
+	Bought     float64 `json:"bought"`
+	Sold       float64 `json:"sold"`
+}
+
+// Report summarizes the strategy's trading so far.
+func (c *StrategyContext) Report() StrategyReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := StrategyReport{Paper: c.opts.Paper, Orders: c.orders, Cancels: c.cancels, PnLReport: c.pnlReport(),
+		OpenOrders: c.OpenOrders(""), Symbols: make(map[string]StrategySymbolReport)}
+	for sym, stats := range c.symStats {
+		r.Symbols[sym] = StrategySymbolReport{Fills: stats.fills, MakerFills: stats.makerFills, Bought: stats.bought, Sold: stats.sold}
+		r.Fills += stats.fills
+	}
+	return r
+}
+
+// PnL values the strategy's positions at the books' current marks.
+func (c *StrategyContext) PnL() PnLReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pnlReport()
+}
+
+func (c *StrategyContext) pnlReport() PnLReport {
+	c.deliverFills()
+	for _, sym := range c.symbols.List() {
+		c.pnl.Mark(sym, c.Book(sym))
+	}
+	return c.pnl.Report()
+}
@@ -0,0 +1,322 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+// scriptedStrategy takes the first ask it sees, offers the position out
+// above the market, and parks and later cancels a bid on its timer.
+type scriptedStrategy struct {
+	trades, books int
+	timers        []time.Time
+	fills         []Fill
+	parked        uint64
+}
+
+func (s *scriptedStrategy) OnTrade(ctx *StrategyContext, tr *orderbook.Trade) {
+	s.trades++
+	if s.trades != 1 {
+		return
+	}
+	if _, err := ctx.Submit(tr.Symbol, orderbook.Buy, 100, 0.4); err != nil {
+		panic(err)
+	}
+	if _, err := ctx.Submit(tr.Symbol, orderbook.Sell, 105, 0.4); err != nil {
+		panic(err)
+	}
+	if _, err := ctx.Submit(tr.Symbol, orderbook.Buy, 100, 0); err == nil {
+		panic("zero quantity accepted")
+	}
+}
+
+func (s *scriptedStrategy) OnBook(ctx *StrategyContext, symbol string) { s.books++ }
+
+func (s *scriptedStrategy) OnTimer(ctx *StrategyContext, now time.Time) {
+	s.timers = append(s.timers, now)
+	if s.parked == 0 {
+		s.parked, _ = ctx.Submit("btcusdt", orderbook.Buy, 90, 1)
+	} else if !ctx.Cancel(s.parked) || ctx.Cancel(s.parked) {
+		panic("cancel")
+	}
+}
+
+func (s *scriptedStrategy) OnFill(ctx *StrategyContext, f Fill) { s.fills = append(s.fills, f) }
+
+func TestRunStrategyBacktest(t *testing.T) {
+	// A seller rests 1 at 100, then a buyer sweeps up to 106
+	capture := `{"e":"aggTrade","E":1700000000000,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":true}
+{"e":"aggTrade","E":1700000002500,"s":"BTCUSDT","a":2,"p":"106.0","q":"1.0","m":false}
+`
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	os.WriteFile(path, []byte(capture), 0o644)
+
+	s := &scriptedStrategy{}
+	report, err := RunStrategyBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", Shards: 4, FeedQueue: 16}, path, s, StrategyOptions{Timer: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.trades != 2 || s.books != 2 || len(s.timers) != 2 {
+		t.Fatalf("calls: %d trades, %d books, %d timers", s.trades, s.books, len(s.timers))
+	}
+	if !s.timers[0].Equal(time.UnixMilli(1700000001000)) {
+		t.Errorf("first timer at %v, want a second of feed time in", s.timers[0])
+	}
+	// Bought 0.4 off the resting seller, then sold it to the buyer at 105
+	if len(s.fills) != 2 || s.fills[0].Maker || s.fills[0].Price != 100 || !s.fills[1].Maker || s.fills[1].Price != 105 {
+		t.Fatalf("fills = %+v", s.fills)
+	}
+	r := report.Strategy
+	if r == nil {
+		t.Fatal("no strategy report")
+	}
+	if r.Orders != 3 || r.Cancels != 1 || r.Fills != 2 || len(r.OpenOrders) != 0 {
+		t.Errorf("report = %+v", r)
+	}
+	if math.Abs(r.RealizedPnL-2) > 1e-9 || r.UnrealizedPnL != 0 || math.Abs(r.PnL-2) > 1e-9 {
+		t.Errorf("pnl = %v (realized %v, unrealized %v), want 2", r.PnL, r.RealizedPnL, r.UnrealizedPnL)
+	}
+	btc := r.Symbols["btcusdt"]
+	if btc.Bought != 0.4 || btc.Sold != 0.4 || btc.MakerFills != 1 || r.Positions["btcusdt"].Position.Quantity != 0 {
+		t.Errorf("btcusdt = %+v", btc)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "strategy  3 orders, 1 cancelled, 2 fills, 0 open") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
+
+func TestPaperTrading(t *testing.T) {
+	capture := `{"e":"aggTrade","E":1700000000000,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":true}
+{"e":"aggTrade","E":1700000000100,"s":"BTCUSDT","a":2,"p":"105.0","q":"1.0","m":false}
+{"e":"aggTrade","E":1700000000200,"s":"BTCUSDT","a":3,"p":"105.5","q":"0.3","m":false}
+{"e":"aggTrade","E":1700000000300,"s":"BTCUSDT","a":4,"p":"98.0","q":"5.0","m":true}
+`
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	os.WriteFile(path, []byte(capture), 0o644)
+	opts := PipelineOptions{Symbols: "btcusdt", FeedQueue: 16}
+	plain, err := RunBacktest(context.Background(), opts, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &paperStrategy{}
+	report, err := RunStrategyBacktest(context.Background(), opts, path, s, StrategyOptions{Paper: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Paper orders leave the book as the feed built it
+	if report.Books["btcusdt"].TotalVolume != plain.Books["btcusdt"].TotalVolume {
+		t.Errorf("book volume %d with paper orders, %d without", report.Books["btcusdt"].TotalVolume, plain.Books["btcusdt"].TotalVolume)
+	}
+	// Took 0.4 off the offer at 100; the offer at 105 filled only when a
+	// trade went through it, and only for that trade's 0.3; the bid at 99
+	// filled in full when the market traded down to 98
+	want := []Fill{
+		{OrderID: strategyOrderBase + 1, Side: orderbook.Buy, Price: 100, Quantity: 0.4},
+		{OrderID: strategyOrderBase + 2, Side: orderbook.Sell, Price: 105, Quantity: 0.3, Maker: true},
+		{OrderID: strategyOrderBase + 3, Side: orderbook.Buy, Price: 99, Quantity: 1, Maker: true},
+	}
+	if len(s.fills) != len(want) {
+		t.Fatalf("fills = %+v", s.fills)
+	}
+	for i, f := range s.fills {
+		if f.OrderID != want[i].OrderID || f.Side != want[i].Side || f.Price != want[i].Price ||
+			math.Abs(f.Quantity-want[i].Quantity) > 1e-9 || f.Maker != want[i].Maker {
+			t.Errorf("fill %d = %+v, want %+v", i, f, want[i])
+		}
+	}
+	r := report.Strategy
+	pos := r.Positions["btcusdt"].Position
+	if !r.Paper || math.Abs(pos.Quantity-1.1) > 1e-9 || math.Abs(pos.Realized-1.5) > 1e-9 {
+		t.Errorf("paper report = %+v, position %+v", r, pos)
+	}
+	if len(r.OpenOrders) != 1 || r.OpenOrders[0].Side != orderbook.Sell || math.Abs(r.OpenOrders[0].Remaining-0.1) > 1e-9 {
+		t.Errorf("open orders = %+v, want the rest of the offer at 105", r.OpenOrders)
+	}
+}
+
+type paperStrategy struct {
+	scriptedStrategy
+}
+
+func (s *paperStrategy) OnTrade(ctx *StrategyContext, tr *orderbook.Trade) {
+	if tr.ID == 1 {
+		ctx.Submit(tr.Symbol, orderbook.Buy, 100, 0.4)
+		ctx.Submit(tr.Symbol, orderbook.Sell, 105, 0.4)
+		ctx.Submit(tr.Symbol, orderbook.Buy, 99, 1)
+	}
+}
+
+// quoter keeps a bid and an ask around the last trade.
+type quoter struct{}
+
+func (quoter) OnTrade(ctx *StrategyContext, tr *orderbook.Trade) {
+	for _, o := range ctx.OpenOrders(tr.Symbol) {
+		ctx.Cancel(o.ID)
+	}
+	ctx.Submit(tr.Symbol, orderbook.Buy, tr.Price-0.02, 0.01)
+	ctx.Submit(tr.Symbol, orderbook.Sell, tr.Price+0.02, 0.01)
+}
+
+func (quoter) OnBook(*StrategyContext, string)     {}
+func (quoter) OnTimer(*StrategyContext, time.Time) {}
+
+func TestStrategyBacktestIsDeterministic(t *testing.T) {
+	path := writeCapture(t, []string{"btcusdt", "ethusdt"}, 2000)
+	opts := PipelineOptions{Symbols: "btcusdt,ethusdt", FeedQueue: 64, Limits: DefaultSymbolLimits}
+	first, err := RunStrategyBacktest(context.Background(), opts, path, quoter{}, StrategyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Strategy.Fills == 0 {
+		t.Fatal("quoter never filled")
+	}
+	for i := 0; i < 3; i++ {
+		again, err := RunStrategyBacktest(context.Background(), opts, path, quoter{}, StrategyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(*again.Strategy) != fmt.Sprint(*first.Strategy) {
+			t.Fatalf("run %d differs:\n%+v\n%+v", i+2, *again.Strategy, *first.Strategy)
+		}
+	}
+
+	if _, err := RunStrategyBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", FeedQueue: 16, BookModes: "mirrored"}, path, quoter{}, StrategyOptions{}); err == nil {
+		t.Error("strategy attached to a mirrored book")
+	}
+	if _, err := RunStrategyBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", FeedQueue: 16, BookModes: "mirrored"}, path, quoter{}, StrategyOptions{Paper: true}); err != nil {
+		t.Errorf("paper strategy on a mirrored book: %v", err)
+	}
+	if _, err := NewStrategy("nosuch"); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
+
+// actionStrategy runs a step on the trade with that ID.
+type actionStrategy struct {
+	scriptedStrategy
+	steps map[uint64]func(ctx *StrategyContext, tr *orderbook.Trade)
+	ids   []uint64
+}
+
+func (s *actionStrategy) OnTrade(ctx *StrategyContext, tr *orderbook.Trade) {
+	if step, ok := s.steps[tr.ID]; ok {
+		step(ctx, tr)
+	}
+}
+
+func runActions(t *testing.T, capture string, opts StrategyOptions, steps map[uint64]func(*actionStrategy, *StrategyContext, *orderbook.Trade)) (*actionStrategy, *StrategyReport) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	os.WriteFile(path, []byte(capture), 0o644)
+	s := &actionStrategy{steps: make(map[uint64]func(*StrategyContext, *orderbook.Trade))}
+	for id, step := range steps {
+		step := step
+		s.steps[id] = func(ctx *StrategyContext, tr *orderbook.Trade) { step(s, ctx, tr) }
+	}
+	report, err := RunStrategyBacktest(context.Background(), PipelineOptions{Symbols: "btcusdt", FeedQueue: 16}, path, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, report.Strategy
+}
+
+func TestStrategyLatency(t *testing.T) {
+	// An offer at 100 is taken 100ms later; a second offer goes up at 101
+	// and the market then trades down to 99
+	capture := `{"e":"aggTrade","E":1700000000000,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":true}
+{"e":"aggTrade","E":1700000000100,"s":"BTCUSDT","a":2,"p":"100.0","q":"1.0","m":false}
+{"e":"aggTrade","E":1700000001000,"s":"BTCUSDT","a":3,"p":"101.0","q":"1.0","m":true}
+{"e":"aggTrade","E":1700000001100,"s":"BTCUSDT","a":4,"p":"99.0","q":"5.0","m":true}
+`
+	buy := map[uint64]func(*actionStrategy, *StrategyContext, *orderbook.Trade){
+		1: func(s *actionStrategy, ctx *StrategyContext, tr *orderbook.Trade) {
+			id, _ := ctx.Submit(tr.Symbol, orderbook.Buy, 100, 0.4)
+			if o := ctx.OpenOrders(""); len(o) > 0 && o[0].ID == id && o[0].Active {
+				panic("order active before it arrived")
+			}
+		},
+	}
+	for _, paper := range []bool{false, true} {
+		s, _ := runActions(t, capture, StrategyOptions{Paper: paper}, buy)
+		if len(s.fills) != 1 || s.fills[0].Maker {
+			t.Errorf("paper %v without latency: fills = %+v, want the offer taken", paper, s.fills)
+		}
+		// Arriving after the offer went, the bid rests until the market
+		// comes down to it
+		s, r := runActions(t, capture, StrategyOptions{Paper: paper, EntryLatency: 500 * time.Millisecond}, buy)
+		if len(s.fills) != 1 || !s.fills[0].Maker || s.fills[0].Price != 100 || math.Abs(s.fills[0].Quantity-0.4) > 1e-9 {
+			t.Errorf("paper %v with latency: fills = %+v", paper, s.fills)
+		}
+		if r.Orders != 1 || len(r.OpenOrders) != 0 {
+			t.Errorf("paper %v with latency: report = %+v", paper, r)
+		}
+	}
+
+	// A bid at 99.5 is cancelled 100ms before the market trades through it
+	cancel := map[uint64]func(*actionStrategy, *StrategyContext, *orderbook.Trade){
+		1: func(s *actionStrategy, ctx *StrategyContext, tr *orderbook.Trade) {
+			id, _ := ctx.Submit(tr.Symbol, orderbook.Buy, 99.5, 1)
+			s.ids = append(s.ids, id)
+		},
+		3: func(s *actionStrategy, ctx *StrategyContext, tr *orderbook.Trade) {
+			if !ctx.Cancel(s.ids[0]) || ctx.Cancel(s.ids[0]) {
+				panic("cancel")
+			}
+		},
+	}
+	for _, paper := range []bool{false, true} {
+		s, r := runActions(t, capture, StrategyOptions{Paper: paper}, cancel)
+		if len(s.fills) != 0 || r.Cancels != 1 {
+			t.Errorf("paper %v without latency: fills = %+v, %d cancels", paper, s.fills, r.Cancels)
+		}
+		// The cancel is still on its way when the trade comes
+		s, r = runActions(t, capture, StrategyOptions{Paper: paper, CancelLatency: 200 * time.Millisecond}, cancel)
+		if len(s.fills) != 1 || s.fills[0].Price != 99.5 || r.Cancels != 0 || len(r.OpenOrders) != 0 {
+			t.Errorf("paper %v with latency: fills = %+v, report %+v", paper, s.fills, r)
+		}
+	}
+}
+
+func TestStrategyQueuePosition(t *testing.T) {
+	// A bid of 1 rests at 100 and sellers then hit it for 0.6 and 0.7
+	capture := `{"e":"aggTrade","E":1700000000000,"s":"BTCUSDT","a":1,"p":"100.0","q":"1.0","m":false}
+{"e":"aggTrade","E":1700000000100,"s":"BTCUSDT","a":2,"p":"100.0","q":"0.6","m":true}
+{"e":"aggTrade","E":1700000000200,"s":"BTCUSDT","a":3,"p":"100.0","q":"0.7","m":true}
+`
+	bid := func(s *actionStrategy, ctx *StrategyContext, tr *orderbook.Trade) {
+		ctx.Submit(tr.Symbol, orderbook.Buy, 100, 0.5)
+	}
+	steps := map[uint64]func(*actionStrategy, *StrategyContext, *orderbook.Trade){
+		1: bid,
+		2: func(s *actionStrategy, ctx *StrategyContext, tr *orderbook.Trade) {
+			if o := ctx.OpenOrders(tr.Symbol); len(o) != 1 || math.Abs(o[0].QueueAhead-0.4) > 1e-9 {
+				panic(fmt.Sprintf("queue after the first hit: %+v", o))
+			}
+		},
+	}
+	// Without a queue, trading at the bid's price never fills it
+	s, _ := runActions(t, capture, StrategyOptions{Paper: true}, map[uint64]func(*actionStrategy, *StrategyContext, *orderbook.Trade){1: bid})
+	if len(s.fills) != 0 {
+		t.Errorf("fills without queue position = %+v", s.fills)
+	}
+	// Behind the 1 already there, the bid gets what the second seller has
+	// left after the last 0.4 ahead of it
+	s, r := runActions(t, capture, StrategyOptions{Paper: true, QueuePosition: true}, steps)
+	if len(s.fills) != 1 || !s.fills[0].Maker || math.Abs(s.fills[0].Quantity-0.3) > 1e-9 {
+		t.Fatalf("fills = %+v", s.fills)
+	}
+	if len(r.OpenOrders) != 1 || math.Abs(r.OpenOrders[0].Remaining-0.2) > 1e-9 || r.OpenOrders[0].QueueAhead != 0 {
+		t.Errorf("open orders = %+v", r.OpenOrders)
+	}
+}
@@ -0,0 +1,263 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Summary covers one scheduled reporting period: what traded, how wide the
+// spread was and which alerts fired between Start and End.
+type Summary struct {
+	Start   time.Time                 `json:"start"`
+	End     time.Time                 `json:"end"`
+	Partial bool                      `json:"partial,omitempty"` // cut short by shutdown
+	Symbols map[string]SymbolSummary  `json:"symbols"`
+	Alerts  map[string]map[string]int `json:"alerts"` // rule, then symbol
+}
+
+type SymbolSummary struct {
+	Trades   int         `json:"trades"`
+	Volume   float64     `json:"volume"`
+	Notional float64     `json:"notional"`
+	VWAP     float64     `json:"vwap"`
+	Open     float64     `json:"open"`
+	High     float64     `json:"high"`
+	Low      float64     `json:"low"`
+	Close    float64     `json:"close"`
+	Spread   SpreadStats `json:"spread_bps"`
+}
+
+// SpreadStats summarize the spread_bps signal over a period.
+type SpreadStats struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// Text renders the summary for chat sinks, one line per symbol.
+func (s Summary) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Summary %s to %s UTC", s.Start.UTC().Format("2006-01-02 15:04"), s.End.UTC().Format("2006-01-02 15:04"))
+	if s.Partial {
+		b.WriteString(" (partial)")
+	}
+	for _, sym := range sortedKeys(s.Symbols) {
+		ss := s.Symbols[sym]
+		fmt.Fprintf(&b, "\n%s: %d trades, volume %.4f, VWAP %.2f, high %.2f, low %.2f", sym, ss.Trades, ss.Volume, ss.VWAP, ss.High, ss.Low)
+		if ss.Spread.Samples > 0 {
+			fmt.Fprintf(&b, ", spread %.2f bps avg (%.2f-%.2f)", ss.Spread.Mean, ss.Spread.Min, ss.Spread.Max)
+		}
+	}
+	if len(s.Alerts) == 0 {
+		b.WriteString("\nno alerts")
+	}
+	for _, rule := range sortedKeys(s.Alerts) {
+		fmt.Fprintf(&b, "\nalerts %s:", rule)
+		for _, sym := range sortedKeys(s.Alerts[rule]) {
+			fmt.Fprintf(&b, " %s=%d", sym, s.Alerts[rule][sym])
+		}
+	}
+	return b.String()
+}
+
+type summaryAccum struct {
+	SymbolSummary
+	spreadSum float64
+}
+
+// SummaryScheduler is a sink that cuts trades and signal snapshots into
+// periods of a fixed interval, aligned to the Unix epoch as sessions are,
+// and emits a Summary as each one ends. Periods follow event time, so a
+// replay reports the periods it covers; a period ends with the first event
+// after it, and the one in progress is emitted, marked partial, on Close.
+type SummaryScheduler struct {
+	interval time.Duration
+	emit     func(Summary)
+	start    time.Time // of the period in progress; zero before the first event
+	symbols  map[string]*summaryAccum
+
+	// Alerts are counted on the shard goroutines, by period start
+	mu     sync.Mutex
+	alerts map[time.Time]map[string]map[string]int
+}
+
+func NewSummaryScheduler(interval time.Duration, emit func(Summary)) *SummaryScheduler {
+	return &SummaryScheduler{
+		interval: interval,
+		emit:     emit,
+		symbols:  make(map[string]*summaryAccum),
+		alerts:   make(map[time.Time]map[string]map[string]int),
+	}
+}
+
+func (s *SummaryScheduler) Name() string { return "summary" }
+
+// OnAlert counts an alert towards the period it fired in.
+func (s *SummaryScheduler) OnAlert(e AlertEvent) {
+	start := e.Timestamp.UTC().Truncate(s.interval)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byRule := s.alerts[start]
+	if byRule == nil {
+		byRule = make(map[string]map[string]int)
+		s.alerts[start] = byRule
+	}
+	if byRule[e.Rule] == nil {
+		byRule[e.Rule] = make(map[string]int)
+	}
+	byRule[e.Rule][e.Symbol]++
+}
+
+func (s *SummaryScheduler) Write(e *Event) error {
+	start := e.Timestamp.UTC().Truncate(s.interval)
+	if s.start.IsZero() {
+		s.start = start
+	} else if start.After(s.start) {
+		s.finish(false)
+		s.start = start
+	}
+	acc := s.symbols[e.Symbol]
+	if acc == nil {
+		acc = &summaryAccum{}
+		s.symbols[e.Symbol] = acc
+	}
+	switch e.Type {
+	case EventTrade:
+		tr := e.Trade
+		if acc.Trades == 0 {
+			acc.Open, acc.High, acc.Low = tr.Price, tr.Price, tr.Price
+		}
+		acc.Trades++
+		acc.Volume += tr.Quantity
+		acc.Notional += tr.Price * tr.Quantity
+		acc.High = math.Max(acc.High, tr.Price)
+		acc.Low = math.Min(acc.Low, tr.Price)
+		acc.Close = tr.Price
+	case EventSignal:
+		spread, ok := e.Signals["spread_bps"]
+		if !ok || math.IsNaN(spread) {
+			break
+		}
+		if acc.Spread.Samples == 0 {
+			acc.Spread.Min, acc.Spread.Max = spread, spread
+		}
+		acc.Spread.Samples++
+		acc.spreadSum += spread
+		acc.Spread.Min = math.Min(acc.Spread.Min, spread)
+		acc.Spread.Max = math.Max(acc.Spread.Max, spread)
+	}
+	return nil
+}
+
+// finish emits the period in progress and resets the accumulators. The
+// alerts of any earlier period, raised after it was emitted, are folded in.
+func (s *SummaryScheduler) finish(partial bool) {
+	summary := Summary{
+		Start:   s.start,
+		End:     s.start.Add(s.interval),
+		Partial: partial,
+		Symbols: make(map[string]SymbolSummary, len(s.symbols)),
+		Alerts:  make(map[string]map[string]int),
+	}
+	for sym, acc := range s.symbols {
+		ss := acc.SymbolSummary
+		if ss.Notional > 0 && ss.Volume > 0 {
+			ss.VWAP = ss.Notional / ss.Volume
+		}
+		if ss.Spread.Samples > 0 {
+			ss.Spread.Mean = acc.spreadSum / float64(ss.Spread.Samples)
+		}
+		summary.Symbols[sym] = ss
+	}
+	s.mu.Lock()
+	for start, byRule := range s.alerts {
+		if start.After(s.start) {
+			continue
+		}
+		for rule, bySymbol := range byRule {
+			if summary.Alerts[rule] == nil {
+				summary.Alerts[rule] = make(map[string]int)
+			}
+			for sym, n := range bySymbol {
+				summary.Alerts[rule][sym] += n
+			}
+		}
+		delete(s.alerts, start)
+	}
+	s.mu.Unlock()
+	s.symbols = make(map[string]*summaryAccum)
+	s.emit(summary)
+}
+
+func (s *SummaryScheduler) Flush() error { return nil }
+
+// Close emits the period in progress, if any event arrived in it.
+func (s *SummaryScheduler) Close() error {
+	if !s.start.IsZero() {
+		s.finish(true)
+	}
+	return nil
+}
+
+// appendSummary writes s to path as one JSON line.
+func appendSummary(path string, s Summary) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// StartSummaries emits a Summary every interval of event time: logged,
+// appended to file as JSON Lines if it is set, and posted to the named
+// alert sinks.
+func (m *Monitor) StartSummaries(interval time.Duration, file string, sinks []string) error {
+	if interval <= 0 {
+		return fmt.Errorf("invalid summary interval %v", interval)
+	}
+	if len(sinks) > 0 && m.alerts == nil {
+		return fmt.Errorf("summary sinks %s need --alert-sinks", strings.Join(sinks, ", "))
+	}
+	for _, name := range sinks {
+		if !m.alerts.HasSink(name) {
+			return fmt.Errorf("unknown alert sink %q for summaries", name)
+		}
+	}
+	summaryLog := logger("summary")
+	scheduler := NewSummaryScheduler(interval, func(s Summary) {
+		summaryLog.Info("period summary", "start", s.Start, "end", s.End, "partial", s.Partial, "symbols", len(s.Symbols), "alert_rules", len(s.Alerts))
+		if file != "" {
+			if err := appendSummary(file, s); err != nil {
+				summaryLog.Error("failed to write summary", "file", file, "err", err)
+			}
+		}
+		if len(sinks) > 0 {
+			m.alerts.DispatchSummary(s, sinks)
+		}
+	})
+	for _, rules := range m.Rules {
+		rules.OnAlert(scheduler.OnAlert)
+	}
+	runner := StartSink(m.Bus, scheduler, []EventType{EventTrade, EventSignal}, time.Second, m.Supervisor)
+	m.onShutdown(func(ctx context.Context) {
+		if err := runner.Shutdown(ctx); err != nil {
+			summaryLog.Error("failed to emit the last summary", "err", err)
+		}
+	})
+	return nil
+}
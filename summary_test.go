@@ -0,0 +1,109 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestSummaryScheduler(t *testing.T) {
+	var got []Summary
+	s := NewSummaryScheduler(time.Hour, func(sum Summary) { got = append(got, sum) })
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	trade := func(at time.Duration, price, qty float64) {
+		s.Write(&Event{Type: EventTrade, Symbol: "btcusdt", Timestamp: base.Add(at), Trade: &orderbook.Trade{Price: price, Quantity: qty}})
+	}
+	spread := func(at time.Duration, bps float64) {
+		s.Write(&Event{Type: EventSignal, Symbol: "btcusdt", Timestamp: base.Add(at), Signals: map[string]float64{"spread_bps": bps}})
+	}
+
+	trade(time.Minute, 100, 1)
+	spread(time.Minute, 2)
+	trade(20*time.Minute, 110, 1)
+	spread(20*time.Minute, 4)
+	trade(40*time.Minute, 90, 2)
+	s.OnAlert(AlertEvent{Rule: "wide", Symbol: "btcusdt", Timestamp: base.Add(30 * time.Minute)})
+	s.OnAlert(AlertEvent{Rule: "wide", Symbol: "btcusdt", Timestamp: base.Add(61 * time.Minute)})
+	if len(got) != 0 {
+		t.Fatalf("emitted %d summaries before the hour ended", len(got))
+	}
+
+	// The first event of the next hour ends the first
+	trade(70*time.Minute, 95, 1)
+	if len(got) != 1 {
+		t.Fatalf("emitted %d summaries, want 1", len(got))
+	}
+	first := got[0]
+	if !first.Start.Equal(base) || !first.End.Equal(base.Add(time.Hour)) || first.Partial {
+		t.Errorf("first period = %v to %v, partial %v", first.Start, first.End, first.Partial)
+	}
+	want := SymbolSummary{Trades: 3, Volume: 4, Notional: 390, VWAP: 97.5, Open: 100, High: 110, Low: 90, Close: 90,
+		Spread: SpreadStats{Samples: 2, Mean: 3, Min: 2, Max: 4}}
+	if sum := first.Symbols["btcusdt"]; sum != want {
+		t.Errorf("first period btcusdt = %+v, want %+v", sum, want)
+	}
+	if n := first.Alerts["wide"]["btcusdt"]; n != 1 {
+		t.Errorf("first period counted %d alerts, want 1", n)
+	}
+
+	// Shutdown emits the hour in progress
+	s.Close()
+	if len(got) != 2 || !got[1].Partial || got[1].Symbols["btcusdt"].Trades != 1 || got[1].Alerts["wide"]["btcusdt"] != 1 {
+		t.Errorf("final summary = %+v", got[1:])
+	}
+	if text := got[0].Text(); !strings.Contains(text, "btcusdt: 3 trades") || !strings.Contains(text, "alerts wide: btcusdt=1") {
+		t.Errorf("Text() = %q", text)
+	}
+}
+
+func TestStartSummaries(t *testing.T) {
+	rs := &recordingServer{}
+	srv := httptest.NewServer(http.HandlerFunc(rs.handler))
+	defer srv.Close()
+	dir := t.TempDir()
+	sinks := filepath.Join(dir, "sinks.json")
+	os.WriteFile(sinks, []byte(`[{"name":"team","type":"slack","url":"`+srv.URL+`"}]`), 0o644)
+
+	m, _ := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 64})
+	if err := m.StartSummaries(time.Hour, "", []string{"team"}); err == nil {
+		t.Error("summary sink accepted without --alert-sinks")
+	}
+	m.Close()
+
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 64, SinksFile: sinks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartSummaries(time.Hour, "", []string{"missing"}); err == nil {
+		t.Error("unknown summary sink accepted")
+	}
+	file := filepath.Join(dir, "summaries.jsonl")
+	if err := m.StartSummaries(time.Hour, file, []string{"team"}); err != nil {
+		t.Fatal(err)
+	}
+	done := m.Run()
+	m.Shards.Close()
+	<-done
+	state, _ := m.Symbols.Get("btcusdt")
+	PublishTradeEvents(m.Bus, state, &orderbook.Trade{Symbol: "btcusdt", Price: 100, Quantity: 1, Timestamp: time.Now()}, SpanContext{})
+	m.Close()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum Summary
+	if err := json.Unmarshal(data, &sum); err != nil || !sum.Partial || sum.Symbols["btcusdt"].Trades != 1 {
+		t.Errorf("summary file = %s (%v)", data, err)
+	}
+	if len(rs.bodies) != 1 || !strings.Contains(rs.bodies[0], "btcusdt: 1 trades") {
+		t.Errorf("slack received %q", rs.bodies)
+	}
+}
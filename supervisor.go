@@ -0,0 +1,104 @@
+package apexlob
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Supervisor keeps long-running goroutines (the feed reader, shard workers
+// and sinks) going through panics. A recovered panic is logged with its
+// stack trace and counted, and the component is started again where it
+// left off rather than taking the whole process, or silently its share of
+// the pipeline, down with it.
+type Supervisor struct {
+	registry *MetricsRegistry
+	mu       sync.Mutex
+	panics   map[string]*Counter
+}
+
+const (
+	minRestartBackoff = 10 * time.Millisecond
+	maxRestartBackoff = 5 * time.Second
+	// restartBackoffReset is how long a component must run without
+	// panicking for its next restart to be immediate again.
+	restartBackoffReset = time.Minute
+)
+
+func NewSupervisor(registry *MetricsRegistry) *Supervisor {
+	return &Supervisor{registry: registry, panics: make(map[string]*Counter)}
+}
+
+// Run calls fn, and again each time it panics, until it returns normally
+// or ctx is done. Restarts back off from minRestartBackoff, doubling up
+// to maxRestartBackoff while fn keeps panicking soon after starting. fn
+// must keep whatever it needs to resume outside itself. A nil Supervisor
+// just calls fn.
+func (s *Supervisor) Run(ctx context.Context, component string, fn func()) {
+	if s == nil {
+		fn()
+		return
+	}
+	panics := s.counter(component)
+	var backoff time.Duration
+	for {
+		started := time.Now()
+		if !s.call(component, fn) {
+			return
+		}
+		panics.Inc()
+		if time.Since(started) > restartBackoffReset || backoff == 0 {
+			backoff = minRestartBackoff
+		} else if backoff *= 2; backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+		logger("supervisor").Warn("restarting component", "component", component, "after", backoff, "panics", panics.Value())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// call runs fn and reports whether it panicked.
+func (s *Supervisor) call(component string, fn func()) (panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicked = true
+			logger("supervisor").Error("recovered from panic", "component", component, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		}
+	}()
+	fn()
+	return false
+}
+
+// counter registers a component's panic counter on first use, so every
+// supervised component is exported from the start, at zero.
+func (s *Supervisor) counter(component string) *Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.panics[component]
+	if !ok {
+		c = s.registry.Counter("apexlob_panics_total", "Panics recovered by the supervisor, by component; each one restarts the component.",
+			Labels{"component": component})
+		s.panics[component] = c
+	}
+	return c
+}
+
+// Panics returns the number of panics recovered so far by component.
+func (s *Supervisor) Panics() map[string]uint64 {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]uint64, len(s.panics))
+	for component, c := range s.panics {
+		counts[component] = c.Value()
+	}
+	return counts
+}
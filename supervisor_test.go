@@ -0,0 +1,79 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisorRestartsAfterPanic(t *testing.T) {
+	reg := NewMetricsRegistry()
+	sup := NewSupervisor(reg)
+
+	calls := 0
+	sup.Run(context.Background(), "worker", func() {
+		calls++
+		if calls <= 2 {
+			panic("boom")
+		}
+	})
+	if calls != 3 {
+		t.Errorf("fn called %d times, want 3", calls)
+	}
+	if got := sup.Panics()["worker"]; got != 2 {
+		t.Errorf("Panics()[worker] = %d, want 2", got)
+	}
+
+	sup.Run(context.Background(), "quiet", func() {})
+	var b bytes.Buffer
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`apexlob_panics_total{component="worker"} 2`, `apexlob_panics_total{component="quiet"} 0`} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, b.String())
+		}
+	}
+}
+
+func TestSupervisorStopsDuringBackoff(t *testing.T) {
+	sup := NewSupervisor(NewMetricsRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sup.Run(ctx, "worker", func() {
+			calls++
+			if calls == 1 {
+				cancel()
+			}
+			panic("boom")
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times after cancel, want 1", calls)
+	}
+}
+
+func TestNilSupervisorJustCalls(t *testing.T) {
+	var sup *Supervisor
+	called := false
+	sup.Run(context.Background(), "worker", func() { called = true })
+	if !called || sup.Panics() != nil {
+		t.Errorf("called = %v, Panics() = %v", called, sup.Panics())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("nil supervisor recovered a panic")
+		}
+	}()
+	sup.Run(context.Background(), "worker", func() { panic("boom") })
+}
@@ -0,0 +1,148 @@
+package apexlob
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apexlob/pkg/orderbook"
+	"apexlob/pkg/signals"
+)
+
+// SymbolState bundles everything the monitor keeps for one instrument.
+type SymbolState struct {
+	Symbol  string
+	Mode    BookMode // what Book represents; synthetic unless set
+	Book    *orderbook.Book
+	Signals *signals.Engine
+	Tape    *TradeTape
+	Candles *CandleBuilder
+	Range   *PriceRange
+	// TradeSizes is the session's trades by notional, price times
+	// quantity in the quote currency, in TradeSizeBuckets
+	TradeSizes *Histogram
+
+	refPrices atomic.Pointer[ReferencePrices] // see SetReferencePrices
+	messages  atomic.Uint64                   // processed by the symbol's pipeline
+}
+
+// Messages returns how many feed messages of the symbol have been
+// processed.
+func (s *SymbolState) Messages() uint64 { return s.messages.Load() }
+
+// SymbolLimits bounds the memory one symbol's state can hold.
+type SymbolLimits struct {
+	Book     orderbook.Limits
+	TapeSize int
+}
+
+// TradeSizeBuckets are the bounds of a symbol's trade size histogram, a
+// bucket per power of ten of notional from 10 to 10m, so that retail and
+// block flow land apart.
+var TradeSizeBuckets = ExponentialBuckets(10, 10, 7)
+
+var DefaultSymbolLimits = SymbolLimits{
+	Book:     orderbook.Limits{MaxLevels: 10000, MaxOrders: 200000},
+	TapeSize: 1000,
+}
+
+func NewSymbolState(symbol string) *SymbolState {
+	return NewSymbolStateWithLimits(symbol, DefaultSymbolLimits)
+}
+
+func NewSymbolStateWithLimits(symbol string, limits SymbolLimits) *SymbolState {
+	engine := signals.NewEngine()
+	signals.RegisterDefaults(engine)
+	book := orderbook.New()
+	book.SetLimits(limits.Book)
+	candles := NewCandleBuilder(symbol, time.Minute)
+	registerCandleSignals(engine, candles)
+	return &SymbolState{
+		Symbol:  symbol,
+		Mode:    BookSynthetic,
+		Book:    book,
+		Signals: engine,
+		Tape:    NewTradeTape(limits.TapeSize),
+		Range:   NewPriceRange(DefaultRangeWindow),
+		Candles: candles,
+
+		TradeSizes: NewHistogram(TradeSizeBuckets),
+	}
+}
+
+type BookSnapshot struct {
+	Symbol         string                 `json:"symbol"`
+	Mode           BookMode               `json:"mode"`
+	Timestamp      time.Time              `json:"timestamp"`
+	LastTradePrice float64                `json:"last_trade_price"`
+	VWAP           float64                `json:"vwap"`
+	TotalVolume    uint32                 `json:"total_volume"`
+	Bids           []orderbook.PriceLevel `json:"bids"`
+	Asks           []orderbook.PriceLevel `json:"asks"`
+	// Range is the session and rolling high and low, once there has been
+	// a trade
+	Range *PriceRangeSnapshot `json:"range,omitempty"`
+	// Reference holds the futures mark and index price with --mark-price
+	Reference *ReferencePrices `json:"reference,omitempty"`
+}
+
+func (s *SymbolState) BookSnapshot(depth int) BookSnapshot {
+	bids, asks := s.Book.Depth(depth)
+	totals := s.Book.TradeTotals()
+	var ref *ReferencePrices
+	if p, ok := s.ReferencePrices(); ok {
+		ref = &p
+	}
+	var rng *PriceRangeSnapshot
+	if r, ok := s.Range.Snapshot(); ok {
+		rng = &r
+	}
+	return BookSnapshot{
+		Symbol:         s.Symbol,
+		Mode:           s.Mode,
+		Timestamp:      time.Now(),
+		LastTradePrice: totals.LastPrice,
+		VWAP:           totals.VWAP(),
+		TotalVolume:    totals.Volume,
+		Bids:           bids,
+		Asks:           asks,
+		Range:          rng,
+		Reference:      ref,
+	}
+}
+
+// SymbolRegistry maps lower-cased symbol names to their state for the query APIs.
+type SymbolRegistry struct {
+	mu      sync.RWMutex
+	symbols map[string]*SymbolState
+}
+
+func NewSymbolRegistry() *SymbolRegistry {
+	return &SymbolRegistry{symbols: make(map[string]*SymbolState)}
+}
+
+func (r *SymbolRegistry) Add(state *SymbolState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.symbols[strings.ToLower(state.Symbol)] = state
+}
+
+func (r *SymbolRegistry) Get(symbol string) (*SymbolState, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state, ok := r.symbols[strings.ToLower(symbol)]
+	return state, ok
+}
+
+func (r *SymbolRegistry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	symbols := make([]string, 0, len(r.symbols))
+	for symbol := range r.symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
@@ -0,0 +1,68 @@
+package apexlob
+
+import (
+	"sync"
+
+	"apexlob/pkg/orderbook"
+)
+
+// TradeTape keeps the most recent trades in a fixed-size ring buffer.
+type TradeTape struct {
+	mu     sync.RWMutex
+	trades []orderbook.Trade
+	next   int
+	full   bool
+}
+
+func NewTradeTape(capacity int) *TradeTape {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &TradeTape{trades: make([]orderbook.Trade, capacity)}
+}
+
+func (tt *TradeTape) Add(trade orderbook.Trade) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.trades[tt.next] = trade
+	tt.next++
+	if tt.next == len(tt.trades) {
+		tt.next = 0
+		tt.full = true
+	}
+}
+
+func (tt *TradeTape) Len() int {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	if tt.full {
+		return len(tt.trades)
+	}
+	return tt.next
+}
+
+// Cap returns how many trades the tape holds when full.
+func (tt *TradeTape) Cap() int { return len(tt.trades) }
+
+// Recent returns up to n trades, newest first. n <= 0 returns everything held.
+func (tt *TradeTape) Recent(n int) []orderbook.Trade {
+	tt.mu.RLock()
+	defer tt.mu.RUnlock()
+	size := tt.next
+	if tt.full {
+		size = len(tt.trades)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+	out := make([]orderbook.Trade, n)
+	idx := tt.next
+	for i := 0; i < n; i++ {
+		idx--
+		if idx < 0 {
+			idx = len(tt.trades) - 1
+		}
+		out[i] = tt.trades[idx]
+	}
+	return out
+}
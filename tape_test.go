@@ -0,0 +1,35 @@
+package apexlob
+
+import (
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestTradeTapeRecent(t *testing.T) {
+	tt := NewTradeTape(3)
+	if got := tt.Recent(10); len(got) != 0 {
+		t.Errorf("empty tape Recent() = %v", got)
+	}
+
+	for i := uint64(1); i <= 5; i++ {
+		tt.Add(orderbook.Trade{ID: i})
+	}
+
+	if tt.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", tt.Len())
+	}
+	got := tt.Recent(0)
+	want := []uint64{5, 4, 3}
+	if len(got) != len(want) {
+		t.Fatalf("Recent(0) returned %d trades, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].ID != want[i] {
+			t.Errorf("Recent(0)[%d].ID = %d, want %d", i, got[i].ID, want[i])
+		}
+	}
+	if got := tt.Recent(2); len(got) != 2 || got[0].ID != 5 {
+		t.Errorf("Recent(2) = %v", got)
+	}
+}
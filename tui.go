@@ -0,0 +1,351 @@
+package apexlob
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+
+	"golang.org/x/term"
+)
+
+const (
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	tuiColumnWidth = 38
+	tuiMaxDepth    = 50
+	tuiEventLines  = 5
+	// Trades this many times the average size on the tape are highlighted
+	tuiLargeTrade = 5
+)
+
+// Dashboard renders a full-screen terminal view of one symbol at a time:
+// depth ladder, time & sales, signal values and feed statistics.
+type Dashboard struct {
+	symbols *SymbolRegistry
+	stats   func() StatsSnapshot
+	out     io.Writer
+	refresh time.Duration
+	color   palette
+
+	mu          sync.Mutex
+	current     int
+	paused      bool
+	depth       int
+	hideSignals bool
+	hideFeed    bool
+	hideEvents  bool
+	// events feeds the events pane; nil leaves it out
+	events *ConsoleEvents
+	// The feed panel counts from the last reset: base is the stats then
+	base   StatsSnapshot
+	baseAt time.Time
+	// When the message count last moved, to flag a stale feed
+	seenMessages int
+	seenAt       time.Time
+}
+
+func NewDashboard(symbols *SymbolRegistry, stats func() StatsSnapshot, out io.Writer, refresh time.Duration, color palette) *Dashboard {
+	return &Dashboard{
+		symbols: symbols,
+		stats:   stats,
+		out:     out,
+		refresh: refresh,
+		color:   color,
+		depth:   10,
+		seenAt:  time.Now(),
+	}
+}
+
+// HandleKey applies a keypress and reports whether the user asked to quit.
+func (d *Dashboard) HandleKey(key byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch key {
+	case 'q', 3: // q or Ctrl-C
+		return true
+	case 'p', ' ':
+		d.paused = !d.paused
+	case 'n', '\t':
+		d.current++
+	case 'b':
+		d.current--
+	case '+', '=':
+		d.depth = min(d.depth+5, tuiMaxDepth)
+	case '-', '_':
+		d.depth = max(d.depth-5, 5)
+	case 's':
+		d.hideSignals = !d.hideSignals
+	case 'f':
+		d.hideFeed = !d.hideFeed
+	case 'e':
+		d.hideEvents = !d.hideEvents
+	case 'r':
+		d.base, d.baseAt = d.stats(), time.Now()
+	}
+	return false
+}
+
+// sessionStats is the feed stats since the last reset. Latency percentiles
+// cover the whole run: the histograms cannot be rewound.
+func (d *Dashboard) sessionStats(s StatsSnapshot) StatsSnapshot {
+	if d.baseAt.IsZero() {
+		return s
+	}
+	base := d.base
+	out := s
+	out.TotalMessages = s.TotalMessages - base.TotalMessages
+	out.Errors = s.Errors.Since(base.Errors)
+	out.UptimeSeconds = time.Since(d.baseAt).Seconds()
+	out.MessagesPerSecond, out.AvgProcessingMs = 0, 0
+	if out.UptimeSeconds > 0 {
+		out.MessagesPerSecond = float64(out.TotalMessages) / out.UptimeSeconds
+	}
+	if out.TotalMessages > 0 {
+		total := s.AvgProcessingMs*float64(s.TotalMessages) - base.AvgProcessingMs*float64(base.TotalMessages)
+		out.AvgProcessingMs = total / float64(out.TotalMessages)
+	}
+	return out
+}
+
+func (d *Dashboard) selected() (*SymbolState, []string) {
+	names := d.symbols.List()
+	if len(names) == 0 {
+		return nil, names
+	}
+	d.current = ((d.current % len(names)) + len(names)) % len(names)
+	state, _ := d.symbols.Get(names[d.current])
+	return state, names
+}
+
+// Frame builds one screen. Lines end in \r\n because the terminal is in raw mode.
+func (d *Dashboard) Frame() string {
+	d.mu.Lock()
+	state, names := d.selected()
+	paused, depth := d.paused, d.depth
+	hideSignals, hideFeed, hideEvents := d.hideSignals, d.hideFeed, d.hideEvents
+	raw := d.stats()
+	if raw.TotalMessages != d.seenMessages {
+		d.seenMessages, d.seenAt = raw.TotalMessages, time.Now()
+	}
+	stale := time.Since(d.seenAt) >= feedStaleAfter
+	stats, since := d.sessionStats(raw), d.baseAt
+	d.mu.Unlock()
+	color := d.color
+
+	var b strings.Builder
+	if state == nil {
+		b.WriteString("Waiting for symbols...\r\n")
+		return b.String()
+	}
+
+	status := "[LIVE]"
+	switch {
+	case paused:
+		status = "[PAUSED]"
+	case stale:
+		status = color.warn(fmt.Sprintf("[STALE %ds]", int(time.Since(d.seenAt).Seconds())))
+	}
+	book := state.BookSnapshot(depth)
+	fmt.Fprintf(&b, "ApexLOB  %s  %s  %s book  %s\r\n", strings.ToUpper(state.Symbol), status, state.Mode, time.Now().Format("15:04:05"))
+	ladder := ladderLines(book, depth, color)
+	trades := state.Tape.Recent(len(ladder))
+	last := fmt.Sprintf("%.2f", book.LastTradePrice)
+	if len(trades) > 1 {
+		last = color.tick(last, trades[0].Price, trades[1].Price)
+	}
+	var rng string
+	if book.Range != nil {
+		rng = " | " + rangeText(*book.Range)
+	}
+	fmt.Fprintf(&b, "Last: %s | VWAP: %.2f | Vol: %d%s | Symbols: %s\r\n\r\n",
+		last, book.VWAP, book.TotalVolume, rng, strings.Join(names, " "))
+
+	tape := tapeLines(trades, len(ladder)-1, color)
+	writeColumns(&b, ladder, tape)
+	b.WriteString("\r\n")
+
+	var left, right []string
+	if !hideSignals {
+		left = signalLines(state.Signals.Snapshot())
+	}
+	if !hideFeed {
+		right = statsLines(stats, since, color)
+	}
+	if left == nil {
+		left, right = right, nil
+	}
+	if left != nil {
+		writeColumns(&b, left, right)
+	}
+	help := "[q] quit  [p] pause  [n/b] next/prev symbol  [+/-] depth  [s] signals  [f] feed  [r] reset stats"
+	if d.events != nil {
+		if !hideEvents {
+			b.WriteString("\r\nEVENTS\r\n")
+			for _, line := range d.events.Recent(tuiEventLines) {
+				fmt.Fprintf(&b, "%s\r\n", line)
+			}
+		}
+		help += "  [e] events"
+	}
+	b.WriteString("\r\n" + help + "\r\n")
+	return b.String()
+}
+
+// ladderLines renders the depth ladder, asks in red above bids in green.
+func ladderLines(book BookSnapshot, depth int, color palette) []string {
+	lines := []string{fmt.Sprintf("%-12s %12s %8s", "DEPTH", "PRICE", "VOLUME")}
+	for i := depth - 1; i >= 0; i-- {
+		if i < len(book.Asks) {
+			lines = append(lines, color.down(fmt.Sprintf("%-12s %12.2f %8d", "ask", book.Asks[i].Price, book.Asks[i].Volume)))
+		} else {
+			lines = append(lines, "")
+		}
+	}
+	lines = append(lines, strings.Repeat("-", 34))
+	for i := 0; i < depth; i++ {
+		if i < len(book.Bids) {
+			lines = append(lines, color.up(fmt.Sprintf("%-12s %12.2f %8d", "bid", book.Bids[i].Price, book.Bids[i].Volume)))
+		} else {
+			lines = append(lines, "")
+		}
+	}
+	return lines
+}
+
+// tapeLines renders up to n of trades, newest first. Sides are green for
+// buys and red for sells, prices coloured by tick against the trade before,
+// and trades tuiLargeTrade times the average size of the others shown in
+// reverse video.
+// trades may hold one more than n, to tick the oldest one shown.
+func tapeLines(trades []orderbook.Trade, n int, color palette) []string {
+	lines := []string{fmt.Sprintf("%-8s %-4s %12s %10s", "TIME", "SIDE", "PRICE", "QTY")}
+	shown := trades[:min(n, len(trades))]
+	var total float64
+	for _, tr := range shown {
+		total += tr.Quantity
+	}
+	for i, tr := range shown {
+		side := fmt.Sprintf("%-4s", tr.Side.String())
+		if tr.Side == orderbook.Buy {
+			side = color.up(side)
+		} else {
+			side = color.down(side)
+		}
+		price := fmt.Sprintf("%12.2f", tr.Price)
+		if i+1 < len(trades) {
+			price = color.tick(price, tr.Price, trades[i+1].Price)
+		}
+		line := fmt.Sprintf("%-8s %s %s %10.4f", tr.Timestamp.Format("15:04:05"), side, price, tr.Quantity)
+		if len(shown) > 2 && tr.Quantity >= tuiLargeTrade*(total-tr.Quantity)/float64(len(shown)-1) {
+			line = color.highlight(fmt.Sprintf("%-8s %-4s %12.2f %10.4f", tr.Timestamp.Format("15:04:05"), tr.Side.String(), tr.Price, tr.Quantity))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func signalLines(values map[string]float64) []string {
+	lines := []string{"SIGNALS"}
+	for _, name := range sortedKeys(values) {
+		lines = append(lines, fmt.Sprintf("%-20s %14.4f", name, values[name]))
+	}
+	return lines
+}
+
+// statsLines renders the feed panel, counted from since unless it is zero.
+func statsLines(s StatsSnapshot, since time.Time, color palette) []string {
+	title := "FEED"
+	if !since.IsZero() {
+		title = "FEED since " + since.Format("15:04:05")
+	}
+	errLine := fmt.Sprintf("%-20s %14d", "errors", s.Errors.Total())
+	if s.Errors.Total() > 0 {
+		errLine = color.warn(errLine)
+	}
+	lines := []string{
+		title,
+		fmt.Sprintf("%-20s %14d", "messages", s.TotalMessages),
+		fmt.Sprintf("%-20s %14.2f", "msgs/sec", s.MessagesPerSecond),
+		fmt.Sprintf("%-20s %14.3f", "avg proc (ms)", s.AvgProcessingMs),
+		fmt.Sprintf("%-20s %14.3f", "p99 proc (ms)", s.Processing.P99),
+		fmt.Sprintf("%-20s %14.3f", "p99 e2e (ms)", s.EndToEnd.P99),
+		fmt.Sprintf("%-20s %14.0f", "uptime (s)", s.UptimeSeconds),
+		errLine,
+	}
+	for _, e := range s.Errors.nonZero() {
+		lines = append(lines, fmt.Sprintf("  %-18s %14d", e.name, e.n))
+	}
+	return lines
+}
+
+func writeColumns(b *strings.Builder, left, right []string) {
+	n := len(left)
+	if len(right) > n {
+		n = len(right)
+	}
+	for i := 0; i < n; i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		fmt.Fprintf(b, "%s  %s\r\n", padRight(l, tuiColumnWidth), r)
+	}
+}
+
+// Run redraws on every refresh tick until stop is closed. Frames are skipped
+// while paused so the screen can be inspected.
+func (d *Dashboard) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+	io.WriteString(d.out, ansiHideCursor)
+	defer io.WriteString(d.out, ansiShowCursor)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			paused := d.paused
+			d.mu.Unlock()
+			if !paused {
+				io.WriteString(d.out, ansiClear+d.Frame())
+			}
+		}
+	}
+}
+
+// ReadKeys puts the terminal in raw mode and feeds keypresses to the
+// dashboard, closing quit when the user exits. The returned function
+// restores the terminal.
+func (d *Dashboard) ReadKeys(in *os.File, quit chan<- struct{}) (func(), error) {
+	fd := int(in.Fd())
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	restore := func() { term.Restore(fd, oldState) }
+
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := in.Read(buf); err != nil {
+				return
+			}
+			if d.HandleKey(buf[0]) {
+				close(quit)
+				return
+			}
+		}
+	}()
+	return restore, nil
+}
@@ -0,0 +1,126 @@
+package apexlob
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/orderbook"
+)
+
+func newTestDashboard() (*Dashboard, *SymbolRegistry) {
+	symbols := NewSymbolRegistry()
+	for _, sym := range []string{"btcusdt", "ethusdt"} {
+		state := NewSymbolState(sym)
+		state.Book.SubmitOrder(&orderbook.Order{ID: 1, Price: 99.5, Quantity: 100, Side: orderbook.Buy})
+		state.Book.SubmitOrder(&orderbook.Order{ID: 2, Price: 100.5, Quantity: 200, Side: orderbook.Sell})
+		tr := orderbook.Trade{Symbol: sym, Price: 100.0, Quantity: 0.25, Side: orderbook.Buy, Timestamp: time.Now()}
+		state.Tape.Add(tr)
+		state.Signals.OnTrade(&tr, state.Book)
+		symbols.Add(state)
+	}
+	stats := func() StatsSnapshot { return StatsSnapshot{TotalMessages: 12, MessagesPerSecond: 3.5} }
+	return NewDashboard(symbols, stats, &strings.Builder{}, time.Millisecond, palette(false)), symbols
+}
+
+func TestDashboardFrame(t *testing.T) {
+	d, _ := newTestDashboard()
+	frame := d.Frame()
+
+	for _, want := range []string{"BTCUSDT", "[LIVE]", "99.50", "100.50", "BUY", "last_price", "msgs/sec"} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame missing %q:\n%s", want, frame)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(frame, "\r\n", ""), "\n") {
+		t.Error("frame lines must end in \\r\\n for raw terminal mode")
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	d, _ := newTestDashboard()
+	if strings.Contains(d.Frame(), "EVENTS") {
+		t.Error("events pane without an event stream")
+	}
+	d.events = NewConsoleEvents(60, DefaultLargeTradeFactor, nil)
+	d.events.Add(time.Now(), "RECONNECT", "", "feed reconnected")
+	if frame := d.Frame(); !strings.Contains(frame, "EVENTS") || !strings.Contains(frame, "feed reconnected") || !strings.Contains(frame, "[e] events") {
+		t.Errorf("frame without events:\n%s", frame)
+	}
+	d.HandleKey('e')
+	if strings.Contains(d.Frame(), "feed reconnected") {
+		t.Error("hidden events pane shown")
+	}
+}
+
+func TestDashboardKeys(t *testing.T) {
+	d, _ := newTestDashboard()
+
+	d.HandleKey('n')
+	if !strings.Contains(d.Frame(), "ETHUSDT") {
+		t.Error("'n' should switch to the next symbol")
+	}
+	d.HandleKey('n')
+	if !strings.Contains(d.Frame(), "BTCUSDT") {
+		t.Error("symbol selection should wrap around")
+	}
+	d.HandleKey('b')
+	if !strings.Contains(d.Frame(), "ETHUSDT") {
+		t.Error("'b' should switch to the previous symbol")
+	}
+
+	d.HandleKey('p')
+	if !strings.Contains(d.Frame(), "[PAUSED]") {
+		t.Error("'p' should pause the display")
+	}
+	if !d.HandleKey('q') {
+		t.Error("'q' should quit")
+	}
+}
+
+func TestDashboardPanelsAndReset(t *testing.T) {
+	d, _ := newTestDashboard()
+	messages := 100
+	d.stats = func() StatsSnapshot {
+		return StatsSnapshot{TotalMessages: messages, AvgProcessingMs: 0.5}
+	}
+
+	d.HandleKey('+')
+	if got := strings.Count(d.Frame(), "ask "); got != 1 || d.depth != 15 {
+		t.Errorf("depth %d after '+' shows %d asks", d.depth, got)
+	}
+	for i := 0; i < 20; i++ {
+		d.HandleKey('-')
+	}
+	if d.depth != 5 {
+		t.Errorf("depth = %d after many '-', want 5", d.depth)
+	}
+
+	d.HandleKey('s')
+	if frame := d.Frame(); strings.Contains(frame, "SIGNALS") || !strings.Contains(frame, "FEED") {
+		t.Errorf("'s' should hide the signals:\n%s", frame)
+	}
+	d.HandleKey('f')
+	if strings.Contains(d.Frame(), "FEED") {
+		t.Error("'f' should hide the feed stats")
+	}
+	d.HandleKey('s')
+	d.HandleKey('f')
+
+	// Stats count from the reset; the average covers only the new messages
+	d.HandleKey('r')
+	messages = 110
+	d.stats = func() StatsSnapshot {
+		return StatsSnapshot{TotalMessages: messages, AvgProcessingMs: (0.5*100 + 1.5*10) / 110}
+	}
+	frame := d.Frame()
+	if !strings.Contains(frame, "FEED since") {
+		t.Errorf("reset feed panel not labelled:\n%s", frame)
+	}
+	d.mu.Lock()
+	s := d.sessionStats(d.stats())
+	d.mu.Unlock()
+	if s.TotalMessages != 10 || s.AvgProcessingMs < 1.4999 || s.AvgProcessingMs > 1.5001 {
+		t.Errorf("session stats = %+v", s)
+	}
+}
@@ -0,0 +1,126 @@
+package apexlob
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// RuntimeTuning holds process-wide Go runtime settings for users chasing
+// microsecond-level jitter. Zero values leave the runtime defaults (and the
+// GOMAXPROCS, GOGC and GOMEMLIMIT environment variables) alone.
+type RuntimeTuning struct {
+	MaxProcs    int
+	GC          string // "off" or a GOGC percentage
+	MemoryLimit int64  // soft heap limit in bytes
+	// Ballast is allocated once and never touched, so it raises the heap
+	// size the GC paces against without using physical memory
+	Ballast int64
+}
+
+// gcBallast keeps the ballast reachable for the life of the process.
+var gcBallast []byte
+
+func (t RuntimeTuning) Apply() error {
+	if t.MaxProcs > 0 {
+		runtime.GOMAXPROCS(t.MaxProcs)
+	}
+	switch t.GC {
+	case "":
+	case "off":
+		debug.SetGCPercent(-1)
+	default:
+		percent, err := strconv.Atoi(t.GC)
+		if err != nil || percent < 0 {
+			return fmt.Errorf("invalid GC percentage %q: want off or a non-negative integer", t.GC)
+		}
+		debug.SetGCPercent(percent)
+	}
+	if t.MemoryLimit > 0 {
+		debug.SetMemoryLimit(t.MemoryLimit)
+	}
+	if t.Ballast > 0 {
+		gcBallast = make([]byte, t.Ballast)
+	}
+	return nil
+}
+
+// WorkerTuning controls how shard workers wait for and run on CPUs.
+type WorkerTuning struct {
+	// LockThread wires each worker to its own OS thread, so the scheduler
+	// never migrates it between threads mid-burst
+	LockThread bool
+	// CPUs pins worker i to CPUs[i%len(CPUs)]; it implies LockThread.
+	// Linux only.
+	CPUs []int
+	// BusyPoll spins on an empty ring for up to this long before parking,
+	// trading a core per worker for a shorter wakeup
+	BusyPoll time.Duration
+}
+
+// ParseCPUList parses a list of CPU numbers and ranges such as "2,4-7".
+func ParseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range splitList(s) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range %q", part)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// tuningFlags registers the tuning flags shared by the monitor and the bench
+// subcommand.
+type tuningFlags struct {
+	maxProcs    *int
+	gc          *string
+	memoryLimit *string
+	ballast     *string
+	lockThreads *bool
+	cpus        *string
+	busyPoll    *time.Duration
+}
+
+func registerTuningFlags(fs *pflag.FlagSet) *tuningFlags {
+	return &tuningFlags{
+		maxProcs:    fs.Int("gomaxprocs", 0, "OS threads running Go code at once (0 for $GOMAXPROCS or the CPU count)"),
+		gc:          fs.String("gogc", "", "GC target percentage, or off (empty for $GOGC)"),
+		memoryLimit: fs.String("gomemlimit", "", "soft heap limit such as 2GB; the GC runs harder near it (empty for $GOMEMLIMIT)"),
+		ballast:     fs.String("gc-ballast", "", "untouched heap allocation such as 512MB that makes collections rarer"),
+		lockThreads: fs.Bool("lock-threads", false, "lock each shard worker to its own OS thread"),
+		cpus:        fs.String("cpu-affinity", "", "CPUs to pin shard workers to, e.g. 2-5 or 2,4,6; implies --lock-threads (Linux only)"),
+		busyPoll:    fs.Duration("busy-poll", 0, "spin on an empty shard queue for up to this long before sleeping; needs a spare core per worker"),
+	}
+}
+
+func (f *tuningFlags) parse() (RuntimeTuning, WorkerTuning, error) {
+	rt := RuntimeTuning{MaxProcs: *f.maxProcs, GC: strings.ToLower(strings.TrimSpace(*f.gc))}
+	var err error
+	if rt.MemoryLimit, err = parseByteSize(*f.memoryLimit); err != nil {
+		return rt, WorkerTuning{}, fmt.Errorf("--gomemlimit: %w", err)
+	}
+	if rt.Ballast, err = parseByteSize(*f.ballast); err != nil {
+		return rt, WorkerTuning{}, fmt.Errorf("--gc-ballast: %w", err)
+	}
+	wt := WorkerTuning{LockThread: *f.lockThreads, BusyPoll: *f.busyPoll}
+	if wt.CPUs, err = ParseCPUList(*f.cpus); err != nil {
+		return rt, wt, fmt.Errorf("--cpu-affinity: %w", err)
+	}
+	return rt, wt, nil
+}
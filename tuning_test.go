@@ -0,0 +1,78 @@
+package apexlob
+
+import (
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := ParseCPUList("2, 4-6,9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{2, 4, 5, 6, 9}
+	if len(cpus) != len(want) {
+		t.Fatalf("cpus = %v, want %v", cpus, want)
+	}
+	for i := range want {
+		if cpus[i] != want[i] {
+			t.Fatalf("cpus = %v, want %v", cpus, want)
+		}
+	}
+	if cpus, err := ParseCPUList(""); err != nil || cpus != nil {
+		t.Errorf("empty list = %v, %v", cpus, err)
+	}
+	for _, bad := range []string{"x", "-1", "5-3", "1-x"} {
+		if _, err := ParseCPUList(bad); err == nil {
+			t.Errorf("ParseCPUList(%q) accepted", bad)
+		}
+	}
+}
+
+func TestRuntimeTuningApply(t *testing.T) {
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	if err := (RuntimeTuning{MaxProcs: 2, GC: "250"}).Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOMAXPROCS(0) != 2 || debug.SetGCPercent(250) != 250 {
+		t.Error("GOMAXPROCS or GOGC not applied")
+	}
+	if err := (RuntimeTuning{GC: "off"}).Apply(); err != nil || debug.SetGCPercent(100) != -1 {
+		t.Errorf("GC off not applied: %v", err)
+	}
+	if err := (RuntimeTuning{GC: "lots"}).Apply(); err == nil {
+		t.Error("invalid GC percentage accepted")
+	}
+}
+
+func TestTuningFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	tf := registerTuningFlags(fs)
+	err := fs.Parse([]string{"--gogc", "OFF", "--gomemlimit", "2GB", "--gc-ballast", "1MB", "--cpu-affinity", "0-1", "--busy-poll", "50us"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, wt, err := tf.parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.GC != "off" || rt.MemoryLimit != 2<<30 || rt.Ballast != 1<<20 {
+		t.Errorf("runtime tuning = %+v", rt)
+	}
+	if len(wt.CPUs) != 2 || wt.BusyPoll != 50*time.Microsecond || wt.LockThread {
+		t.Errorf("worker tuning = %+v", wt)
+	}
+
+	fs = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	tf = registerTuningFlags(fs)
+	fs.Parse([]string{"--cpu-affinity", "3-1"})
+	if _, _, err := tf.parse(); err == nil {
+		t.Error("invalid --cpu-affinity accepted")
+	}
+}
@@ -0,0 +1,391 @@
+package apexlob
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"apexlob/pkg/orderbook"
+
+	"github.com/gorilla/websocket"
+)
+
+// listenKeyKeepAlive is how often a listen key is extended. Binance expires
+// one an hour after it was last created or extended.
+const listenKeyKeepAlive = 30 * time.Minute
+
+// binanceUserStreamURL is the user-data stream of listen key key.
+func binanceUserStreamURL(key string) string {
+	return "wss://stream.binance.com:443/ws/" + key
+}
+
+// ListenKeys creates, extends and closes the listen keys that authorize a
+// Binance user-data stream. Only the API key is needed: the endpoints are
+// not signed.
+type ListenKeys struct {
+	Client  *http.Client
+	BaseURL string
+	APIKey  string
+}
+
+// Create returns a new listen key, or the account's current one if it is
+// still open.
+func (k ListenKeys) Create(ctx context.Context) (string, error) {
+	var resp struct {
+		ListenKey string `json:"listenKey"`
+	}
+	if err := k.do(ctx, http.MethodPost, "", &resp); err != nil {
+		return "", err
+	}
+	if resp.ListenKey == "" {
+		return "", fmt.Errorf("listen key: empty response")
+	}
+	return resp.ListenKey, nil
+}
+
+// KeepAlive extends key for another hour.
+func (k ListenKeys) KeepAlive(ctx context.Context, key string) error {
+	return k.do(ctx, http.MethodPut, key, nil)
+}
+
+// Close ends key's stream.
+func (k ListenKeys) Close(ctx context.Context, key string) error {
+	return k.do(ctx, http.MethodDelete, key, nil)
+}
+
+func (k ListenKeys) do(ctx context.Context, method, key string, out any) error {
+	u := k.BaseURL + "/api/v3/userDataStream"
+	if key != "" {
+		u += "?listenKey=" + url.QueryEscape(key)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-MBX-APIKEY", k.APIKey)
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Msg != "" {
+			return fmt.Errorf("listen key %s: %s (%d)", strings.ToLower(method), apiErr.Msg, apiErr.Code)
+		}
+		return fmt.Errorf("listen key %s: %s", strings.ToLower(method), resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// executionReport is the user-data stream event for an order of the
+// account changing. Only TRADE executions are fills. encoding/json matches
+// keys regardless of case, so the keys that differ from a wanted one only
+// in case are declared too.
+type executionReport struct {
+	Event           string `json:"e"`
+	EventMs         int64  `json:"E"`
+	Symbol          string `json:"s"`
+	Side            string `json:"S"`
+	ExecutionType   string `json:"x"`
+	OrderStatus     string `json:"X"`
+	OrderID         int64  `json:"i"`
+	Ignore          int64  `json:"I"`
+	TradeID         int64  `json:"t"`
+	LastQuantity    string `json:"l"`
+	LastPrice       string `json:"L"`
+	Commission      string `json:"n"`
+	CommissionAsset string `json:"N"`
+	TradeMs         int64  `json:"T"`
+}
+
+// AccountFill is one fill of an order on the account's own exchange
+// account.
+type AccountFill struct {
+	Symbol          string         `json:"symbol"`
+	OrderID         int64          `json:"order_id"`
+	TradeID         int64          `json:"trade_id"`
+	Side            orderbook.Side `json:"side"`
+	Price           float64        `json:"price"`
+	Quantity        float64        `json:"quantity"`
+	Commission      float64        `json:"commission"`
+	CommissionAsset string         `json:"commission_asset,omitempty"`
+	Timestamp       time.Time      `json:"timestamp"`
+}
+
+// parseExecutionReport returns the fill an executionReport carries, and
+// false for reports of orders being placed, cancelled or expired.
+func parseExecutionReport(r *executionReport) (AccountFill, bool, error) {
+	if r.ExecutionType != "TRADE" {
+		return AccountFill{}, false, nil
+	}
+	f := AccountFill{
+		Symbol:          strings.ToLower(r.Symbol),
+		OrderID:         r.OrderID,
+		TradeID:         r.TradeID,
+		CommissionAsset: r.CommissionAsset,
+		Timestamp:       time.UnixMilli(r.TradeMs).UTC(),
+	}
+	switch r.Side {
+	case "BUY":
+		f.Side = orderbook.Buy
+	case "SELL":
+		f.Side = orderbook.Sell
+	default:
+		return AccountFill{}, false, invalidf("execution report: unknown side %q", r.Side)
+	}
+	var err error
+	if f.Price, err = strconv.ParseFloat(r.LastPrice, 64); err != nil || f.Price <= 0 {
+		return AccountFill{}, false, invalidf("execution report: invalid price %q", r.LastPrice)
+	}
+	if f.Quantity, err = strconv.ParseFloat(r.LastQuantity, 64); err != nil || f.Quantity <= 0 {
+		return AccountFill{}, false, invalidf("execution report: invalid quantity %q", r.LastQuantity)
+	}
+	if r.Commission != "" {
+		if f.Commission, err = strconv.ParseFloat(r.Commission, 64); err != nil {
+			return AccountFill{}, false, invalidf("execution report: invalid commission %q", r.Commission)
+		}
+	}
+	return f, true, nil
+}
+
+// AccountTracker keeps the positions and PnL of the account's real fills,
+// marked at the books of the streamed symbols. Fills arrive on the
+// user-data stream's goroutine and reports are taken on others, so it
+// locks.
+type AccountTracker struct {
+	mu          sync.Mutex
+	symbols     *SymbolRegistry
+	pnl         *PnLTracker
+	fills       int
+	commissions map[string]float64
+	last        []AccountFill // most recent last
+}
+
+// accountRecentFills is how many fills AccountReport lists.
+const accountRecentFills = 20
+
+func NewAccountTracker(symbols *SymbolRegistry, mark MarkPrice) *AccountTracker {
+	return &AccountTracker{symbols: symbols, pnl: NewPnLTracker(mark), commissions: make(map[string]float64)}
+}
+
+// Fill adds a fill to the account's positions.
+func (a *AccountTracker) Fill(f AccountFill) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pnl.Fill(f.Symbol, f.Side, f.Price, f.Quantity)
+	a.fills++
+	if f.CommissionAsset != "" {
+		a.commissions[f.CommissionAsset] += f.Commission
+	}
+	if len(a.last) == accountRecentFills {
+		a.last = append(a.last[:0], a.last[1:]...)
+	}
+	a.last = append(a.last, f)
+}
+
+// AccountReport is the account's PnL with the fills it came from.
+type AccountReport struct {
+	PnLReport
+	Fills       int                `json:"fills"`
+	Commissions map[string]float64 `json:"commissions"` // by asset
+	RecentFills []AccountFill      `json:"recent_fills"`
+}
+
+// Report values the account's positions at the books' current marks.
+// Positions in symbols that are not streamed stay at their last fill price.
+func (a *AccountTracker) Report() AccountReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sym := range a.symbols.List() {
+		if state, ok := a.symbols.Get(sym); ok {
+			a.pnl.Mark(sym, state.Book)
+		}
+	}
+	r := AccountReport{
+		PnLReport:   a.pnl.Report(),
+		Fills:       a.fills,
+		Commissions: make(map[string]float64, len(a.commissions)),
+		RecentFills: append([]AccountFill{}, a.last...),
+	}
+	for asset, n := range a.commissions {
+		r.Commissions[asset] = n
+	}
+	return r
+}
+
+func registerAccountMetrics(reg *MetricsRegistry, account *AccountTracker) {
+	reg.GaugeFunc("apexlob_account_pnl", "Exchange account profit and loss from its real fills: realized, unrealized at the mark, and their total.", func() []Sample {
+		r := account.Report()
+		return []Sample{
+			{Labels: Labels{"kind": "realized"}, Value: r.RealizedPnL},
+			{Labels: Labels{"kind": "unrealized"}, Value: r.UnrealizedPnL},
+			{Labels: Labels{"kind": "total"}, Value: r.PnL},
+		}
+	})
+	reg.GaugeFunc("apexlob_account_position", "Exchange account position in each symbol; negative when short.", func() []Sample {
+		r := account.Report()
+		samples := make([]Sample, 0, len(r.Positions))
+		for _, sym := range sortedKeys(r.Positions) {
+			samples = append(samples, Sample{Labels: Labels{"symbol": sym}, Value: r.Positions[sym].Position.Quantity})
+		}
+		return samples
+	})
+}
+
+// ingestUserData handles one user-data stream message. It reports whether
+// the listen key has expired, which ends the stream.
+func ingestUserData(account *AccountTracker, msg []byte) (expired bool, err error) {
+	var head struct {
+		Event   string `json:"e"`
+		EventMs int64  `json:"E"`
+	}
+	if err := json.Unmarshal(msg, &head); err != nil {
+		return false, err
+	}
+	switch head.Event {
+	case "executionReport":
+		var r executionReport
+		if err := json.Unmarshal(msg, &r); err != nil {
+			return false, err
+		}
+		f, ok, err := parseExecutionReport(&r)
+		if err != nil || !ok {
+			return false, err
+		}
+		account.Fill(f)
+		logger("account").Info("fill", "symbol", f.Symbol, "side", f.Side, "price", f.Price, "quantity", f.Quantity, "order_id", f.OrderID)
+	case "listenKeyExpired":
+		return true, nil
+	}
+	// Balance and position updates are not tracked
+	return false, nil
+}
+
+// RunUserDataStream follows the account's user-data stream until ctx is
+// done or it fails for good, feeding its fills to m's account tracker. It
+// keeps the listen key alive, and takes a new key and reconnects when the
+// stream drops or the key expires; the key is closed on the way out.
+func RunUserDataStream(ctx context.Context, m *Monitor, dialer *websocket.Dialer, keys ListenKeys) {
+	accountLog := logger("account")
+	if m.account == nil {
+		return
+	}
+	var key string
+	connect := func() (*websocket.Conn, error) {
+		var err error
+		if key, err = keys.Create(ctx); err != nil {
+			return nil, err
+		}
+		conn, _, err := dialer.DialContext(ctx, binanceUserStreamURL(key), nil)
+		return conn, err
+	}
+	conn, err := connect()
+	if err != nil {
+		if ctx.Err() == nil {
+			accountLog.Error("failed to open the user-data stream", "err", err)
+		}
+		return
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := keys.Close(closeCtx, key); err != nil {
+			accountLog.Warn("failed to close the listen key", "err", err)
+		}
+	}()
+	var mu sync.Mutex
+	stopped := false
+	current := conn
+	defer context.AfterFunc(ctx, func() {
+		mu.Lock()
+		stopped = true
+		current.Close()
+		mu.Unlock()
+	})()
+	defer func() {
+		mu.Lock()
+		current.Close()
+		mu.Unlock()
+	}()
+	accountLog.Info("following the account's user-data stream")
+
+	go func() {
+		ticker := time.NewTicker(listenKeyKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				k := key
+				mu.Unlock()
+				if err := keys.KeepAlive(ctx, k); err != nil && ctx.Err() == nil {
+					accountLog.Warn("failed to extend the listen key", "err", err)
+				}
+			}
+		}
+	}()
+
+	var message []byte
+	m.Supervisor.Run(ctx, "account", func() {
+		for {
+			_, message, err = conn.ReadMessage()
+			expired := false
+			if err == nil {
+				if expired, err = ingestUserData(m.account, message); !expired {
+					m.ingestFailed(err)
+					continue
+				}
+				accountLog.Warn("listen key expired")
+			} else if ctx.Err() != nil {
+				return
+			} else {
+				accountLog.Warn("user-data stream dropped", "err", err)
+			}
+			conn.Close()
+			for attempt, backoff := 1, time.Second; ; attempt, backoff = attempt+1, backoff*2 {
+				mu.Lock()
+				conn, err = connect()
+				if err == nil {
+					if stopped {
+						conn.Close()
+						mu.Unlock()
+						return
+					}
+					current = conn
+				}
+				mu.Unlock()
+				if err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if attempt == 5 {
+					accountLog.Error("giving up reconnecting", "err", err)
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+			}
+			m.Reconnected()
+		}
+	})
+}
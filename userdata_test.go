@@ -0,0 +1,94 @@
+package apexlob
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apexlob/pkg/orderbook"
+)
+
+func TestListenKeys(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != "key" || r.URL.Path != "/api/v3/userDataStream" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"code":-2015,"msg":"Invalid API-key"}`))
+			return
+		}
+		calls = append(calls, r.Method+" "+r.URL.Query().Get("listenKey"))
+		if r.Method == http.MethodPost {
+			w.Write([]byte(`{"listenKey":"pqia91ma19a5s61cv6a81va65sdf19v8a65a1a5s61cv6a81va65sdf19v8a65a1"}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	keys := ListenKeys{Client: srv.Client(), BaseURL: srv.URL, APIKey: "key"}
+	key, err := keys.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.KeepAlive(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Close(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"POST ", "PUT " + key, "DELETE " + key}
+	if len(calls) != 3 || calls[0] != want[0] || calls[1] != want[1] || calls[2] != want[2] {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	keys.APIKey = "wrong"
+	if _, err := keys.Create(ctx); err == nil || err.Error() != "listen key post: Invalid API-key (-2015)" {
+		t.Errorf("Create with a bad key: %v", err)
+	}
+}
+
+func TestAccountTrackerFromUserData(t *testing.T) {
+	symbols := NewSymbolRegistry()
+	state := NewSymbolState("btcusdt")
+	symbols.Add(state)
+	account := NewAccountTracker(symbols, MarkLast)
+
+	for _, msg := range []string{
+		`{"e":"outboundAccountPosition","E":1700000000000,"u":1700000000000,"B":[]}`,
+		`{"e":"executionReport","E":1700000000001,"s":"BTCUSDT","S":"BUY","x":"NEW","i":7,"t":-1,"l":"0.00000000","L":"0.00000000","T":1700000000001}`,
+		`{"e":"executionReport","E":1700000000002,"s":"BTCUSDT","S":"BUY","x":"TRADE","X":"FILLED","i":7,"I":42,"t":11,"l":"2.0","L":"100.0","n":"0.002","N":"BTC","T":1700000000002}`,
+		`{"e":"executionReport","E":1700000000003,"s":"BTCUSDT","S":"SELL","x":"TRADE","X":"PARTIALLY_FILLED","i":8,"t":12,"l":"1.0","L":"110.0","n":"0.11","N":"USDT","T":1700000000003}`,
+	} {
+		if expired, err := ingestUserData(account, []byte(msg)); err != nil || expired {
+			t.Fatalf("ingesting %s: expired %v, err %v", msg, expired, err)
+		}
+	}
+	state.Book.RestoreTotals(orderbook.TradeTotals{LastPrice: 120})
+
+	r := account.Report()
+	if r.Fills != 2 || len(r.RecentFills) != 2 || r.RecentFills[1].TradeID != 12 {
+		t.Errorf("fills = %d, recent %+v", r.Fills, r.RecentFills)
+	}
+	p := r.Positions["btcusdt"]
+	if p.Position.Quantity != 1 || p.Position.AvgPrice != 100 || p.Mark != 120 {
+		t.Errorf("position %+v", p)
+	}
+	if math.Abs(r.RealizedPnL-10) > 1e-9 || math.Abs(r.UnrealizedPnL-20) > 1e-9 {
+		t.Errorf("realized %v, unrealized %v; want 10 and 20", r.RealizedPnL, r.UnrealizedPnL)
+	}
+	if r.Commissions["BTC"] != 0.002 || r.Commissions["USDT"] != 0.11 {
+		t.Errorf("commissions %v", r.Commissions)
+	}
+
+	var invalid *invalidError
+	if _, err := ingestUserData(account, []byte(`{"e":"executionReport","s":"BTCUSDT","S":"BUY","x":"TRADE","l":"1","L":"abc"}`)); !errors.As(err, &invalid) {
+		t.Errorf("bad price: %v, want a validation error", err)
+	}
+	if expired, err := ingestUserData(account, []byte(`{"e":"listenKeyExpired","E":1700003600000}`)); err != nil || !expired {
+		t.Errorf("listenKeyExpired: expired %v, err %v", expired, err)
+	}
+}
@@ -0,0 +1,251 @@
+package apexlob
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+
+	"github.com/spf13/cobra"
+)
+
+// WALVerification summarizes a successful VerifyWAL.
+type WALVerification struct {
+	Segments    int      `json:"segments"`
+	Events      int      `json:"events"`
+	Checkpoints int      `json:"checkpoints"` // book checkpoints compared
+	Symbols     []string `json:"symbols"`
+	// Truncated is set when the log ends part-way through a record, as it
+	// does after a crash; everything before that was verified
+	Truncated bool `json:"truncated"`
+}
+
+// CheckpointMismatch is the first book whose replayed state differs from
+// what the live run recorded.
+type CheckpointMismatch struct {
+	Segment int
+	Symbol  string
+	Want    orderbook.Checkpoint
+	Got     orderbook.Checkpoint
+}
+
+func (e *CheckpointMismatch) Error() string {
+	return fmt.Sprintf("segment %d: %s diverges after %d orders: recorded %+v, replayed %+v",
+		e.Segment, e.Symbol, e.Want.Orders, e.Want, e.Got)
+}
+
+// walSegment is one run's checkpoints, by symbol and then by the number of
+// orders the book had taken.
+type walSegment map[string]map[uint64]orderbook.Checkpoint
+
+// VerifyWAL replays the events of the log at path through fresh books,
+// segment by segment, and checks that every book matches each checkpoint
+// recorded for it at the same number of orders. Checkpoints are written
+// asynchronously to the events they describe, so the log is read twice:
+// once for the checkpoints and once for the replay.
+func VerifyWAL(path string) (WALVerification, error) {
+	var result WALVerification
+	segments, truncated, err := readWALCheckpoints(path)
+	if err != nil {
+		return result, err
+	}
+	result.Truncated = truncated
+
+	f, err := os.Open(path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	r, err := NewWALReader(f)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", path, err)
+	}
+
+	interned := make(map[string]string)
+	seen := make(map[string]bool)
+	var books map[string]*orderbook.Book
+	var modes map[string]BookMode
+	var pending walSegment
+	segment := -1
+	// check compares a book against the checkpoint recorded at its current
+	// order count, if there is one
+	check := func(sym string, ob *orderbook.Book, orders uint64) error {
+		want, ok := pending[sym][orders]
+		if !ok {
+			return nil
+		}
+		delete(pending[sym], orders)
+		result.Checkpoints++
+		if got := ob.Checkpoint(); got != want {
+			return &CheckpointMismatch{Segment: segment, Symbol: sym, Want: want, Got: got}
+		}
+		return nil
+	}
+	// finish fails if the segment has checkpoints its events never reached.
+	// Events are logged before they are applied, so even a truncated log
+	// holds every event its checkpoints count.
+	finish := func() error {
+		for _, sym := range sortedKeys(pending) {
+			if n := len(pending[sym]); n > 0 {
+				return fmt.Errorf("segment %d: %d checkpoints of %s count more orders than the log holds", segment, n, sym)
+			}
+		}
+		return nil
+	}
+	orders := make(map[string]uint64)
+	var msg feed.Msg
+	one := make([]feed.Msg, 1) // for ApplyMirrored
+	for {
+		typ, payload, err := r.Next()
+		if err == io.EOF || errors.Is(err, errWALTruncated) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		switch typ {
+		case walHeader:
+			if err := finish(); err != nil {
+				return result, err
+			}
+			segment++
+			var header WALHeader
+			if err := json.Unmarshal(payload, &header); err != nil {
+				return result, fmt.Errorf("segment %d: bad header: %w", segment, err)
+			}
+			pending = segments[segment]
+			books = make(map[string]*orderbook.Book, len(header.Books))
+			modes = make(map[string]BookMode, len(header.Books))
+			for sym, start := range header.Books {
+				modes[sym] = start.Mode
+				ob := orderbook.New()
+				ob.SetLimits(start.Limits)
+				ob.RestoreTotals(start.Totals)
+				books[sym] = ob
+				orders[sym] = 0
+				seen[sym] = true
+				if err := check(sym, ob, 0); err != nil {
+					return result, err
+				}
+			}
+		case walEvent:
+			if segment < 0 {
+				return result, errors.New("WAL event before the first header")
+			}
+			if err := decodeWALEvent(payload, &msg, interned); err != nil {
+				return result, err
+			}
+			ob, ok := books[msg.Symbol]
+			if !ok {
+				return result, fmt.Errorf("segment %d: event for %s, which the header does not list", segment, msg.Symbol)
+			}
+			if modes[msg.Symbol] == BookMirrored {
+				one[0] = msg
+				ob.ApplyMirrored(one)
+			} else if order := orderFromFeed(&msg, time.UnixMilli(msg.EventMs)); !ob.SubmitOrder(order) {
+				orderbook.ReleaseOrder(order)
+			}
+			orders[msg.Symbol]++
+			result.Events++
+			if err := check(msg.Symbol, ob, orders[msg.Symbol]); err != nil {
+				return result, err
+			}
+		}
+	}
+	if segment < 0 {
+		return result, errors.New("WAL has no segments")
+	}
+	if err := finish(); err != nil {
+		return result, err
+	}
+	result.Segments = segment + 1
+	result.Symbols = sortedKeys(seen)
+	return result, nil
+}
+
+// readWALCheckpoints collects every segment's checkpoints and reports
+// whether the log is truncated.
+func readWALCheckpoints(path string) ([]walSegment, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	r, err := NewWALReader(f)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	var segments []walSegment
+	for {
+		typ, payload, err := r.Next()
+		if err == io.EOF {
+			return segments, false, nil
+		}
+		if errors.Is(err, errWALTruncated) {
+			return segments, true, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		switch typ {
+		case walHeader:
+			segments = append(segments, make(walSegment))
+		case walCheckpoint:
+			if len(segments) == 0 {
+				return nil, false, errors.New("WAL checkpoint before the first header")
+			}
+			var c WALCheckpoint
+			if err := json.Unmarshal(payload, &c); err != nil {
+				return nil, false, fmt.Errorf("bad checkpoint: %w", err)
+			}
+			seg := segments[len(segments)-1]
+			for sym, book := range c.Books {
+				if seg[sym] == nil {
+					seg[sym] = make(map[uint64]orderbook.Checkpoint)
+				}
+				seg[sym][book.Orders] = book
+			}
+		}
+	}
+}
+
+func newVerifyCommand() *cobra.Command {
+	var walPath string
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Replay a write-ahead log and check the books against its checkpoints",
+		Long: "Replays the events of a log written with --wal through fresh books and checks that\n" +
+			"every book reproduces each checkpoint the live run recorded: the same levels, resting\n" +
+			"orders in the same priority, and bit-identical trade totals. Any difference is\n" +
+			"nondeterminism in the engine, and the first one is reported.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			result, err := VerifyWAL(walPath)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(result)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "verified %d events in %d segments against %d checkpoints (%s)\n",
+				result.Events, result.Segments, result.Checkpoints, strings.Join(result.Symbols, ", "))
+			if result.Truncated {
+				fmt.Fprintln(cmd.OutOrStdout(), "the log ends in a partial record; everything before it was verified")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&walPath, "wal", "", "write-ahead log written by live, serve or replay with --wal")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the result as JSON")
+	cmd.MarkFlagRequired("wal")
+	return cmd
+}
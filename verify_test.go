@@ -0,0 +1,128 @@
+package apexlob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+// runWithWAL replays a capture through a monitor logging to path and
+// checkpointing every few milliseconds, so checkpoints land mid-stream.
+func runWithWAL(t *testing.T, path string, symbols []string, n int) {
+	t.Helper()
+	m, err := NewMonitor(time.Now(), PipelineOptions{
+		Symbols: strings.Join(symbols, ","), Shards: 2, FeedQueue: 64,
+		Limits: SymbolLimits{Book: orderbook.Limits{MaxLevels: 50, MaxOrders: 400}, TapeSize: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartWAL(path, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, writeCapture(t, symbols, n), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	m.Close()
+}
+
+func TestVerifyWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	runWithWAL(t, path, []string{"btcusdt", "ethusdt"}, 3000)
+	runWithWAL(t, path, []string{"btcusdt", "ethusdt"}, 500)
+
+	result, err := VerifyWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Segments != 2 || result.Events != 3500 || result.Truncated {
+		t.Errorf("result = %+v", result)
+	}
+	// At least the final checkpoint of each symbol in each segment
+	if result.Checkpoints < 4 {
+		t.Errorf("compared %d checkpoints, want at least 4", result.Checkpoints)
+	}
+
+	root := NewRootCommand()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"verify", "--wal", path})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "verified 3500 events in 2 segments") {
+		t.Errorf("verify printed %q", out.String())
+	}
+}
+
+func TestVerifyWALMirrored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	m, err := NewMonitor(time.Now(), PipelineOptions{Symbols: "btcusdt", Shards: 1, FeedQueue: 16, BookModes: "mirrored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartWAL(path, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	done := m.Run()
+	if err := ReplayCapture(context.Background(), m, writeDepthCapture(t, 200), 0); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	m.Close()
+
+	result, err := VerifyWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A reset, three snapshot levels, then two levels and a trade a round
+	if result.Events != 4+200*3 || result.Checkpoints == 0 {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestVerifyWALDetectsDivergence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	ob := orderbook.New()
+	w, err := OpenWAL(path, WALHeader{Books: map[string]WALBookStart{"btcusdt": {}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		m := feed.Msg{Symbol: "btcusdt", TradeID: uint64(i + 1), Price: 100 + float64(i%3), Quantity: 1, BuyerMaker: i%2 == 0}
+		w.AppendEvent(&m)
+		ob.SubmitOrder(orderFromFeed(&m, time.Now()))
+	}
+	// What a nondeterministic engine might have recorded
+	want := ob.Checkpoint()
+	want.Digest++
+	w.AppendCheckpoint(WALCheckpoint{Books: map[string]orderbook.Checkpoint{"btcusdt": want}})
+	w.Close()
+
+	_, err = VerifyWAL(path)
+	var mismatch *CheckpointMismatch
+	if !errors.As(err, &mismatch) || mismatch.Symbol != "btcusdt" || mismatch.Want.Orders != 10 {
+		t.Fatalf("err = %v, want a mismatch after 10 orders", err)
+	}
+	if mismatch.Got.Digest != want.Digest-1 {
+		t.Errorf("replayed digest %d, want %d", mismatch.Got.Digest, want.Digest-1)
+	}
+
+	// A checkpoint counting events the log lacks is an error too
+	path = filepath.Join(t.TempDir(), "short.wal")
+	w, _ = OpenWAL(path, WALHeader{Books: map[string]WALBookStart{"btcusdt": {}}})
+	w.AppendCheckpoint(WALCheckpoint{Books: map[string]orderbook.Checkpoint{"btcusdt": {Orders: 5}}})
+	w.Close()
+	if _, err := VerifyWAL(path); err == nil || !strings.Contains(err.Error(), "more orders than the log holds") {
+		t.Errorf("checkpoint beyond the log: %v", err)
+	}
+}
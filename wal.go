@@ -0,0 +1,326 @@
+package apexlob
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+// The write-ahead log is an append-only file of every normalized feed
+// event, written before the event is queued for its shard, with periodic
+// checkpoints of every book. Replaying the events through fresh books must
+// reproduce each checkpoint exactly; see VerifyWAL.
+//
+// The file starts with walMagic and is a sequence of records:
+//
+//	type (1 byte) | payload length (uint32) | payload | CRC-32 of type and payload (uint32)
+//
+// little-endian throughout. Every process that opens the log appends a
+// header record first, so a log holds one segment per run.
+const walMagic = "APEXWAL1"
+
+const (
+	walHeader     byte = 'H' // JSON WALHeader
+	walEvent      byte = 'E' // binary feed.Msg, see appendWALEvent
+	walCheckpoint byte = 'C' // JSON WALCheckpoint
+)
+
+// maxWALRecord bounds a record's payload so a corrupt length cannot make a
+// reader allocate without limit.
+const maxWALRecord = 64 << 20
+
+var errWALTruncated = errors.New("WAL ends in a partial record")
+
+// WALHeader starts a segment with what a replay needs to rebuild the books
+// as they were before its first event.
+type WALHeader struct {
+	Created time.Time               `json:"created"`
+	Books   map[string]WALBookStart `json:"books"`
+}
+
+type WALBookStart struct {
+	Mode   BookMode              `json:"mode,omitempty"` // synthetic if empty
+	Limits orderbook.Limits      `json:"limits"`
+	Totals orderbook.TradeTotals `json:"totals"` // e.g. resumed from a session file
+}
+
+type WALCheckpoint struct {
+	Time  time.Time                       `json:"time"`
+	Books map[string]orderbook.Checkpoint `json:"books"`
+}
+
+// WALWriter appends records to a log. Events and checkpoints may be
+// appended from different goroutines.
+type WALWriter struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	buf    []byte
+	err    error // first write error; later appends are dropped
+	closed bool
+}
+
+// OpenWAL opens the log at path for appending, creating it if needed, and
+// starts a new segment with header.
+func OpenWAL(path string, header WALHeader) (*WALWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := &WALWriter{f: f, w: bufio.NewWriterSize(f, 256<<10)}
+	if info, err := f.Stat(); err != nil || info.Size() == 0 {
+		w.w.WriteString(walMagic)
+	}
+	if err := w.appendJSON(walHeader, header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *WALWriter) AppendEvent(m *feed.Msg) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = appendWALEvent(w.buf[:0], m)
+	return w.writeRecord(walEvent, w.buf)
+}
+
+func (w *WALWriter) AppendCheckpoint(c WALCheckpoint) error {
+	return w.appendJSON(walCheckpoint, c)
+}
+
+func (w *WALWriter) appendJSON(typ byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeRecord(typ, payload)
+}
+
+func (w *WALWriter) writeRecord(typ byte, payload []byte) error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	var head [5]byte
+	head[0] = typ
+	binary.LittleEndian.PutUint32(head[1:], uint32(len(payload)))
+	crc := crc32.Update(crc32.ChecksumIEEE(head[:1]), crc32.IEEETable, payload)
+	var tail [4]byte
+	binary.LittleEndian.PutUint32(tail[:], crc)
+	w.w.Write(head[:])
+	w.w.Write(payload)
+	if _, err := w.w.Write(tail[:]); err != nil {
+		w.err = err
+		logger("wal").Error("write failed, no further events will be logged", "err", err)
+	}
+	return w.err
+}
+
+// Flush hands buffered records to the operating system.
+func (w *WALWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if err := w.w.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Close flushes the log and syncs it to disk.
+func (w *WALWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	err := w.w.Flush()
+	if serr := w.f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// appendWALEvent encodes the fields of m that determine its effect on the
+// book and signals: symbol length (1 byte) and name, trade ID, price,
+// quantity, flags (1 byte, see walFlags), event time in ms and receipt
+// time in ns.
+func appendWALEvent(dst []byte, m *feed.Msg) []byte {
+	dst = append(dst, byte(len(m.Symbol)))
+	dst = append(dst, m.Symbol...)
+	dst = binary.LittleEndian.AppendUint64(dst, m.TradeID)
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(m.Price))
+	dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(m.Quantity))
+	dst = append(dst, walFlags(m))
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(m.EventMs))
+	return binary.LittleEndian.AppendUint64(dst, uint64(m.Received.UnixNano()))
+}
+
+// The flags byte of an event holds its kind in the high four bits. Logs
+// written before depth events have only walBuyerMaker.
+const (
+	walBuyerMaker byte = 1 << iota
+	walBid
+	walLast
+)
+
+func walFlags(m *feed.Msg) byte {
+	flags := byte(m.Kind) << 4
+	if m.BuyerMaker {
+		flags |= walBuyerMaker
+	}
+	if m.Bid {
+		flags |= walBid
+	}
+	if m.Last {
+		flags |= walLast
+	}
+	return flags
+}
+
+// decodeWALEvent decodes an event payload into m. symbols interns symbol
+// names so long replays do not allocate one per event.
+func decodeWALEvent(payload []byte, m *feed.Msg, symbols map[string]string) error {
+	if len(payload) < 1 || len(payload) != 1+int(payload[0])+41 {
+		return fmt.Errorf("malformed WAL event of %d bytes", len(payload))
+	}
+	n := int(payload[0])
+	sym, ok := symbols[string(payload[1:1+n])]
+	if !ok {
+		sym = string(payload[1 : 1+n])
+		symbols[sym] = sym
+	}
+	p := payload[1+n:]
+	*m = feed.Msg{
+		Symbol:     sym,
+		TradeID:    binary.LittleEndian.Uint64(p),
+		Price:      math.Float64frombits(binary.LittleEndian.Uint64(p[8:])),
+		Quantity:   math.Float64frombits(binary.LittleEndian.Uint64(p[16:])),
+		Kind:       feed.Kind(p[24] >> 4),
+		BuyerMaker: p[24]&walBuyerMaker != 0,
+		Bid:        p[24]&walBid != 0,
+		Last:       p[24]&walLast != 0,
+		EventMs:    int64(binary.LittleEndian.Uint64(p[25:])),
+		Received:   time.Unix(0, int64(binary.LittleEndian.Uint64(p[33:]))),
+	}
+	return nil
+}
+
+// WALReader reads the records of a log in order.
+type WALReader struct {
+	r       *bufio.Reader
+	payload []byte
+}
+
+func NewWALReader(r io.Reader) (*WALReader, error) {
+	br := bufio.NewReaderSize(r, 256<<10)
+	magic := make([]byte, len(walMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != walMagic {
+		return nil, errors.New("not a WAL file")
+	}
+	return &WALReader{r: br}, nil
+}
+
+// Next returns the next record. The payload is only valid until the next
+// call. It returns io.EOF at the end of the log and errWALTruncated if the
+// log ends part-way through a record, as after a crash.
+func (r *WALReader) Next() (typ byte, payload []byte, err error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r.r, head[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, errWALTruncated
+	}
+	n := binary.LittleEndian.Uint32(head[1:])
+	if n > maxWALRecord {
+		return 0, nil, fmt.Errorf("WAL record of %d bytes is corrupt", n)
+	}
+	if cap(r.payload) < int(n)+4 {
+		r.payload = make([]byte, n+4)
+	}
+	buf := r.payload[:n+4]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return 0, nil, errWALTruncated
+	}
+	payload = buf[:n]
+	crc := crc32.Update(crc32.ChecksumIEEE(head[:1]), crc32.IEEETable, payload)
+	if crc != binary.LittleEndian.Uint32(buf[n:]) {
+		return 0, nil, fmt.Errorf("WAL record of type %q fails its checksum", head[0])
+	}
+	return head[0], payload, nil
+}
+
+// StartWAL logs every event the feed ingests to path, with a checkpoint of
+// every book each interval and a final one on Close. It must be called
+// before Run.
+func (m *Monitor) StartWAL(path string, interval time.Duration) error {
+	header := WALHeader{Created: time.Now().UTC(), Books: make(map[string]WALBookStart, len(m.SymbolList))}
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		header.Books[sym] = WALBookStart{Mode: state.Mode, Limits: state.Book.Limits(), Totals: state.Book.TradeTotals()}
+	}
+	w, err := OpenWAL(path, header)
+	if err != nil {
+		return err
+	}
+	m.wal = w
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.checkpointWAL()
+				case <-m.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	m.onClose(func() {
+		m.checkpointWAL()
+		if err := w.Close(); err != nil {
+			logger("wal").Error("failed to close WAL", "err", err)
+		}
+	})
+	return nil
+}
+
+func (m *Monitor) checkpointWAL() {
+	c := WALCheckpoint{Time: time.Now().UTC(), Books: make(map[string]orderbook.Checkpoint, len(m.SymbolList))}
+	for _, sym := range m.SymbolList {
+		state, _ := m.Symbols.Get(sym)
+		c.Books[sym] = state.Book.Checkpoint()
+	}
+	if m.wal.AppendCheckpoint(c) == nil {
+		m.wal.Flush()
+	}
+}
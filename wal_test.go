@@ -0,0 +1,100 @@
+package apexlob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"apexlob/pkg/feed"
+	"apexlob/pkg/orderbook"
+)
+
+func TestWALRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.wal")
+	header := WALHeader{Created: time.Now().UTC(), Books: map[string]WALBookStart{"btcusdt": {Limits: orderbook.Limits{MaxLevels: 5}}}}
+	w, err := OpenWAL(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []feed.Msg{
+		{Symbol: "btcusdt", TradeID: 1, Price: 100.25, Quantity: 0.5, BuyerMaker: true, EventMs: 1700000000000, Received: time.Unix(0, 1700000000000123456)},
+		{Symbol: "btcusdt", TradeID: 2, Price: 100.5, Quantity: 1.25, Received: time.Unix(0, 1700000000001000000)},
+		{Kind: feed.KindLevel, Symbol: "btcusdt", TradeID: 7, Price: 99.5, Bid: true, Last: true, Received: time.Unix(0, 1700000000002000000)},
+	}
+	for i := range events {
+		if err := w.AppendEvent(&events[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.AppendCheckpoint(WALCheckpoint{Books: map[string]orderbook.Checkpoint{"btcusdt": {Orders: 2, Digest: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AppendEvent(&events[0]); err == nil {
+		t.Error("append after Close succeeded")
+	}
+	// Reopening appends a second segment after the first
+	w, err = OpenWAL(path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	data, _ := os.ReadFile(path)
+	r, err := NewWALReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []byte
+	var decoded []feed.Msg
+	for {
+		typ, payload, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, typ)
+		if typ == walEvent {
+			var m feed.Msg
+			if err := decodeWALEvent(payload, &m, map[string]string{}); err != nil {
+				t.Fatal(err)
+			}
+			decoded = append(decoded, m)
+		}
+	}
+	if string(types) != "HEEECH" {
+		t.Errorf("record types = %q, want HEEECH", types)
+	}
+	for i, want := range events {
+		got := decoded[i]
+		if got.Symbol != want.Symbol || got.TradeID != want.TradeID || got.Price != want.Price || got.Quantity != want.Quantity ||
+			got.Kind != want.Kind || got.BuyerMaker != want.BuyerMaker || got.Bid != want.Bid || got.Last != want.Last || got.EventMs != want.EventMs || !got.Received.Equal(want.Received) {
+			t.Errorf("event %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	// A torn final record reads as truncation, a flipped bit as corruption
+	r, _ = NewWALReader(bytes.NewReader(data[:len(data)-3]))
+	for err = nil; err == nil; _, _, err = r.Next() {
+	}
+	if !errors.Is(err, errWALTruncated) {
+		t.Errorf("torn log: %v, want truncation", err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(walMagic)+10] ^= 1
+	r, _ = NewWALReader(bytes.NewReader(corrupt))
+	if _, _, err := r.Next(); err == nil || errors.Is(err, errWALTruncated) {
+		t.Errorf("corrupt record: %v, want a checksum error", err)
+	}
+	if _, err := NewWALReader(bytes.NewReader([]byte("nope"))); err == nil {
+		t.Error("non-WAL file accepted")
+	}
+}
@@ -0,0 +1,19 @@
+package apexlob
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// WebUIHandler serves the embedded browser dashboard.
+func WebUIHandler() http.Handler {
+	root, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
@@ -0,0 +1,131 @@
+"use strict";
+
+const MAX_POINTS = 600;
+const state = { symbol: null, prices: [], vwaps: [], book: null, signals: {}, history: {}, ws: null };
+
+function $(id) { return document.getElementById(id); }
+
+function fmt(v, digits) { return Number.isFinite(v) ? v.toFixed(digits) : "–"; }
+
+function push(arr, v) {
+  arr.push(v);
+  if (arr.length > MAX_POINTS) arr.shift();
+}
+
+function drawLines(canvas, series) {
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  const all = series.flatMap(s => s.data);
+  if (all.length < 2) return;
+  const min = Math.min(...all), max = Math.max(...all);
+  const span = max - min || 1;
+  for (const s of series) {
+    ctx.strokeStyle = s.color;
+    ctx.beginPath();
+    s.data.forEach((v, i) => {
+      const x = (i / (MAX_POINTS - 1)) * canvas.width;
+      const y = canvas.height - ((v - min) / span) * (canvas.height - 10) - 5;
+      i === 0 ? ctx.moveTo(x, y) : ctx.lineTo(x, y);
+    });
+    ctx.stroke();
+  }
+  ctx.fillStyle = "#8fa1b3";
+  ctx.fillText(max.toFixed(2), 4, 12);
+  ctx.fillText(min.toFixed(2), 4, canvas.height - 4);
+}
+
+function drawDepth(canvas, book) {
+  const ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  if (!book) return;
+  const levels = [...(book.asks || []).slice().reverse().map(l => ({ ...l, side: "ask" })),
+                  ...(book.bids || []).map(l => ({ ...l, side: "bid" }))];
+  if (levels.length === 0) return;
+  const maxVol = Math.max(...levels.map(l => l.volume));
+  const rowH = canvas.height / levels.length;
+  levels.forEach((l, i) => {
+    const w = (l.volume / maxVol) * (canvas.width - 110);
+    ctx.fillStyle = l.side === "ask" ? "#bf616a" : "#a3be8c";
+    ctx.fillRect(110, i * rowH + 1, w, rowH - 2);
+    ctx.fillStyle = "#d8dee9";
+    ctx.fillText(l.price.toFixed(2), 4, i * rowH + rowH / 2 + 4);
+  });
+}
+
+function renderSignals() {
+  const container = $("signals");
+  for (const name of Object.keys(state.signals).sort()) {
+    let row = document.getElementById("sig-" + name);
+    if (!row) {
+      row = document.createElement("div");
+      row.className = "signal";
+      row.id = "sig-" + name;
+      row.innerHTML = `<span class="name">${name}</span><span class="value"></span><canvas width="160" height="24"></canvas>`;
+      container.appendChild(row);
+    }
+    row.querySelector(".value").textContent = fmt(state.signals[name], 4);
+    drawLines(row.querySelector("canvas"), [{ data: state.history[name], color: "#88c0d0" }]);
+  }
+}
+
+function onEvent(e) {
+  switch (e.type) {
+  case "trade":
+    push(state.prices, e.trade.price);
+    $("last").textContent = fmt(e.trade.price, 2);
+    break;
+  case "book":
+    state.book = e.book;
+    push(state.vwaps, e.book.vwap);
+    $("vwap").textContent = fmt(e.book.vwap, 2);
+    $("volume").textContent = e.book.total_volume;
+    break;
+  case "signal":
+    state.signals = e.signals;
+    for (const [name, v] of Object.entries(e.signals)) {
+      state.history[name] = state.history[name] || [];
+      push(state.history[name], v);
+    }
+    break;
+  }
+}
+
+function connect(symbol) {
+  if (state.ws) state.ws.close();
+  Object.assign(state, { symbol, prices: [], vwaps: [], book: null, signals: {}, history: {} });
+  $("signals").innerHTML = "";
+  const proto = location.protocol === "https:" ? "wss" : "ws";
+  const ws = new WebSocket(`${proto}://${location.host}/ws?symbols=${symbol}&types=trade,book,signal`);
+  ws.onopen = () => { $("status").textContent = "live"; };
+  ws.onclose = () => {
+    $("status").textContent = "disconnected";
+    if (state.ws === ws) setTimeout(() => connect(symbol), 2000);
+  };
+  ws.onmessage = msg => onEvent(JSON.parse(msg.data));
+  state.ws = ws;
+}
+
+function render() {
+  drawLines($("price"), [{ data: state.prices, color: "#ebcb8b" }, { data: state.vwaps, color: "#81a1c1" }]);
+  drawDepth($("depth"), state.book);
+  renderSignals();
+}
+
+async function pollStats() {
+  try {
+    const stats = await (await fetch("/stats")).json();
+    $("rate").textContent = fmt(stats.messages_per_second, 1);
+  } catch (err) { /* server restarting */ }
+}
+
+async function init() {
+  const symbols = await (await fetch("/symbols")).json();
+  const select = $("symbol");
+  for (const s of symbols) select.add(new Option(s.toUpperCase(), s));
+  select.onchange = () => connect(select.value);
+  if (symbols.length > 0) connect(symbols[0]);
+  setInterval(render, 250);
+  setInterval(pollStats, 2000);
+}
+
+init();